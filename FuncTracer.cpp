@@ -1,36 +1,200 @@
 /* FuncTracer.cpp */
 #include "pin.H"
+#include <atomic>
+#include <chrono>
 #include <iostream>
 #include <fstream>
 #include <sstream>
-#include <unistd.h> // For getpid()
+#include <unistd.h>
 #include "FuncTracer.hpp"
 
 using namespace std;
 
-// Global set and mutex to track logged functions
-static set<string> logged_functions;
-static mutex log_mutex;
+// Routines of the loaded images and how often they were called
+static CallRegistry registry;
 
-void log_function_call(const char* img_name, const char* func_name)
+// Call-graph edges cost an analysis call per call instruction, so they are opt-in.
+KNOB<BOOL> KnobEdges(KNOB_MODE_WRITEONCE, "pintool", "edges", "0", "record caller -> callee edges of direct calls");
+// First-call timestamps cost a clock read at the first call of each routine.
+KNOB<BOOL> KnobTimeline(KNOB_MODE_WRITEONCE, "pintool", "timeline", "0", "record when and in which thread each routine is first called");
+KNOB<BOOL> KnobJson(KNOB_MODE_WRITEONCE, "pintool", "json", "0", "write the log as JSON lines, one object per event");
+KNOB<BOOL> KnobProto(KNOB_MODE_WRITEONCE, "pintool", "proto", "0", "write the log as protobuf records of proto/funkoverage_log.proto (needs -o)");
+KNOB<std::string> KnobOutput(KNOB_MODE_WRITEONCE, "pintool", "o", "", "log file appended to by all the processes of the run (default: Pin's -logfile)");
+KNOB<BOOL> KnobAfl(KNOB_MODE_WRITEONCE, "pintool", "afl", "0", "count the transitions between routines in the AFL coverage map of __AFL_SHM_ID (AFL_MAP_SIZE bytes)");
+KNOB<std::string> KnobStream(KNOB_MODE_WRITEONCE, "pintool", "stream", "", "unix socket of funkoverage stream-collector the log is sent to, falling back to -o");
+KNOB<std::string> KnobEnv(KNOB_MODE_WRITEONCE, "pintool", "env", "", "comma-separated environment variables recorded in the log, besides USER, LANG and CI");
+KNOB<std::string> KnobSession(KNOB_MODE_WRITEONCE, "pintool", "session", "", "session the invocation belongs to, e.g. smoke or regression");
+KNOB<std::string> KnobTags(KNOB_MODE_WRITEONCE, "pintool", "tags", "", "comma-separated tags of the invocation");
+KNOB<std::string> KnobAPI(KNOB_MODE_WRITEONCE, "pintool", "api", "", "comma-separated shared libraries whose exported functions alone are traced, counting only the calls made from other images");
+KNOB<std::string> KnobSample(KNOB_MODE_WRITEONCE, "pintool", "sample", "", "sampling rate of the traced invocation, recorded in the log header");
+// The wrapper execs Pin, so nothing runs after the program exits but the tool.
+KNOB<UINT32> KnobExitPid(KNOB_MODE_WRITEONCE, "pintool", "exit_pid", "0", "process whose exit ends the log with an exit trailer: the one the wrapper exec'd Pin in");
+KNOB<UINT64> KnobStarted(KNOB_MODE_WRITEONCE, "pintool", "started", "0", "when the wrapper launched Pin, in ns since the epoch, for the exit trailer");
+
+// The log named by -o; without it, lines go to Pin's -logfile.
+static LogWriter writer;
+
+// Writes a block of complete lines to the log in one piece. sync flushes it to
+// the disk, for the lines that would otherwise be lost by a crash.
+static VOID write_log(const string &block, bool sync = false)
 {
-    string log_key;
+    if (!writer.is_open())
     {
-        lock_guard<mutex> guard(log_mutex);
-        log_key = string(img_name) + ":" + func_name;
-        if (logged_functions.contains(log_key))
-            return;
-        logged_functions.insert(log_key);
+        LOG(block);
+        return;
     }
+    if (!writer.write(block, sync))
+        cerr << "FuncTracer: could not write to " << KnobOutput.Value() << endl;
+}
+
+// The name of the traced program, the collector files a streamed log under:
+// the base name of the first argument after the "--" ending the Pin command
+// line.
+static string program_name(int argc, char *argv[])
+{
+    for (int i = 0; i + 1 < argc; i++)
+        if (string(argv[i]) == "--")
+        {
+            const string program = argv[i + 1];
+            return program.substr(program.rfind('/') + 1);
+        }
+    return "";
+}
 
-    pid_t pid;
-    PIN_LockClient();
-    pid = PIN_GetPid();
-    PIN_UnlockClient();
+// Environment variables always recorded in the context line: who ran the
+// program, with which locale, and whether from a CI job.
+static const vector<string> context_env_names = {"USER", "LANG", "CI"};
 
-    ostringstream oss;
-    oss << "[PID:" << pid << "] [Image:" << img_name << "] [Called:" << func_name << "]\n";
-    LOG(oss.str());
+// Writes the context line: the program arguments follow the "--" ending the
+// Pin command line, the environment is the application's.
+static VOID write_context(int argc, char *argv[], uint64_t start_ns)
+{
+    vector<string> args;
+    for (int i = 0; i < argc; i++)
+    {
+        if (string(argv[i]) != "--")
+            continue;
+        args.assign(argv + i + 1, argv + argc);
+        break;
+    }
+    vector<string> names = context_env_names;
+    stringstream extra(KnobEnv.Value());
+    for (string name; getline(extra, name, ',');)
+        if (!name.empty())
+            names.push_back(name);
+    vector<pair<string, string>> env;
+    for (const string &name : names)
+        if (const char *value = getenv(name.c_str()))
+            env.emplace_back(name, value);
+    char host[256] = "";
+    gethostname(host, sizeof(host) - 1);
+    char cwd[4096] = "";
+    if (!getcwd(cwd, sizeof(cwd)))
+        cwd[0] = 0;
+    write_log(context_line(start_ns, host, cwd, args, env));
+}
+
+// The libraries of -api; empty outside API mode.
+static vector<string> api_libraries;
+
+// The options changing what the log records, for the [Options:...] field of
+// the header.
+static string log_options()
+{
+    string options;
+    if (KnobEdges.Value())
+        options += " -edges";
+    if (KnobTimeline.Value())
+        options += " -timeline";
+    if (!KnobAPI.Value().empty())
+        options += " -api " + KnobAPI.Value();
+    return options.empty() ? options : options.substr(1);
+}
+
+// The main executable, and whether its entry point was reached: the images
+// loaded afterwards are plugins it loaded with dlopen.
+static string main_executable;
+static volatile bool program_started = false;
+
+// Analysis routine, executed at the entry point of the main executable
+VOID record_program_start()
+{
+    program_started = true;
+}
+
+// Analysis routine, executed before every instrumented routine
+VOID record_call(FuncRecord *rec)
+{
+    __atomic_fetch_add(&rec->calls, 1, __ATOMIC_RELAXED);
+}
+
+// Analysis routine replacing record_call with -timeline: the first call also
+// records the time, process and thread.
+VOID record_call_timed(FuncRecord *rec)
+{
+    if (__atomic_fetch_add(&rec->calls, 1, __ATOMIC_RELAXED) != 0)
+        return;
+    const uint64_t now = chrono::duration_cast<chrono::nanoseconds>(
+                             chrono::system_clock::now().time_since_epoch()).count();
+    rec->first_pid = PIN_GetPid();
+    rec->first_tid = PIN_GetTid();
+    __atomic_store_n(&rec->first_ns, now, __ATOMIC_RELEASE);
+}
+
+// Analysis routine replacing record_call with -api: only the calls made from
+// outside the library [low, high] use its API, the library's calls of its
+// own exported functions do not.
+VOID record_api_call(FuncRecord *rec, ADDRINT return_ip, ADDRINT low, ADDRINT high)
+{
+    if (return_ip < low || return_ip > high)
+        __atomic_fetch_add(&rec->calls, 1, __ATOMIC_RELAXED);
+}
+
+// The coverage map of the fuzzer running the program (-afl).
+static AflMap afl_map;
+
+// Analysis routine, executed before every instrumented routine with -afl
+VOID record_afl(UINT32 location)
+{
+    afl_map.record(location);
+}
+
+// Returns the addresses of the functions image exports in its dynamic symbol table.
+static set<ADDRINT> exported_functions(IMG img)
+{
+    set<ADDRINT> addrs;
+    for (SYM sym = IMG_RegsymHead(img); SYM_Valid(sym); sym = SYM_Next(sym))
+        if (SYM_Dynamic(sym))
+            addrs.insert(SYM_Address(sym));
+    return addrs;
+}
+
+// Analysis routine, executed before every instrumented call instruction
+VOID record_edge(EdgeRecord *edge)
+{
+    __atomic_fetch_add(&edge->calls, 1, __ATOMIC_RELAXED);
+}
+
+// Instruments the direct calls of an open routine. Indirect calls are not
+// followed: their target is only known at run time.
+VOID instrument_edges(RTN rtn, const string &image_name, const string &caller, UINT32 load,
+                      const set<string, less<>> &dispatchers)
+{
+    for (INS ins = RTN_InsHead(rtn); INS_Valid(ins); ins = INS_Next(ins))
+    {
+        if (!INS_IsCall(ins) || !INS_IsDirectControlFlow(ins))
+            continue;
+        RTN target = RTN_FindByAddress(INS_DirectControlFlowTargetAddress(ins));
+        if (!RTN_Valid(target))
+            continue;
+        const string callee(call_target(RTN_Name(target)));
+        if (!func_is_relevant(callee) && !ifunc_routine(callee, dispatchers))
+            continue;
+        EdgeRecord *edge = registry.add_edge(image_name, caller, callee, load);
+        INS_InsertCall(ins, IPOINT_BEFORE, (AFUNPTR)record_edge,
+                       IARG_PTR, edge,
+                       IARG_END);
+    }
 }
 
 // Pin calls this function for every image loaded into the process's address space.
@@ -38,15 +202,49 @@ void log_function_call(const char* img_name, const char* func_name)
 VOID image_load(IMG img, VOID *v)
 {
     const string &image_name = IMG_Name(img);
-    if (!image_is_relevant(image_name)) // Check if the image is relevant for our analysis
+    // In API mode, only the exported functions of the selected libraries are traced.
+    const bool api = !api_libraries.empty();
+    // Check if the image is relevant for our analysis
+    if (!image_is_relevant(image_name) || (api && !api_library_matches(image_name, api_libraries)))
     {
-        LOG("[Image:" + image_name + "] is not relevant, skipping...\n");
+        write_log(skipped_image_line(image_name));
         return; // Skip irrelevant images
     }
+    const set<ADDRINT> exported = api ? exported_functions(img) : set<ADDRINT>();
+    const UINT32 load = IMG_Id(img);
+    // The lines of the image are written at once, so they stay together.
+    string out;
+    if (IMG_IsMainExecutable(img))
+    {
+        main_executable = image_name;
+        // The dynamic loader maps the needed libraries before jumping there.
+        RTN entry = RTN_FindByAddress(IMG_EntryAddress(img));
+        if (RTN_Valid(entry))
+        {
+            RTN_Open(entry);
+            RTN_InsertCall(entry, IPOINT_BEFORE, (AFUNPTR)record_program_start, IARG_END);
+            RTN_Close(entry);
+        }
+    }
+    else if (program_started)
+        out += plugin_line(image_name, main_executable);
+    const void *ehdr = reinterpret_cast<const void *>(IMG_LowAddress(img));
+    const string build_id = mapped_build_id(ehdr, IMG_LoadOffset(img));
+    if (!build_id.empty())
+        out += build_id_line(image_name, build_id);
+    const string arch = mapped_arch(ehdr);
+    if (!arch.empty())
+        out += arch_line(image_name, arch);
+    // The functions with an IFUNC resolver, whose per-CPU variants are logged.
+    set<string, less<>> dispatchers;
+    for (SEC sec = IMG_SecHead(img); SEC_Valid(sec); sec = SEC_Next(sec))
+        for (RTN rtn = SEC_RtnHead(sec); RTN_Valid(rtn); rtn = RTN_Next(rtn))
+            if (const string &rtn_name = RTN_Name(rtn); rtn_name.size() > ifunc_resolver_suffix.size() && rtn_name.ends_with(ifunc_resolver_suffix))
+                dispatchers.insert(rtn_name.substr(0, rtn_name.size() - ifunc_resolver_suffix.size()));
     // We iterate through all the sections of the image.
     for (SEC sec = IMG_SecHead(img); SEC_Valid(sec); sec = SEC_Next(sec))
     {
-        LOG("[Image:" + image_name + "] [Section:" + SEC_Name(sec) + "]\n");
+        out += section_line(image_name, SEC_Name(sec));
         // We iterate through all the routines (functions) in the image.
         if (SEC_Type(sec) != SEC_TYPE_EXEC)
             continue; // Only instrument executable sections
@@ -54,27 +252,127 @@ VOID image_load(IMG img, VOID *v)
         {
             RTN_Open(rtn);
             const string &rtn_name = RTN_Name(rtn);
-            if (func_is_relevant(rtn_name)) // Check if the function is relevant for our analysis
+            // Check if the function is relevant for our analysis
+            if ((func_is_relevant(rtn_name) || ifunc_routine(rtn_name, dispatchers)) && (!api || exported.contains(RTN_Address(rtn))))
             {
-                ostringstream oss;
+                // The address relative to the image stays the same across runs despite ASLR.
+                const uint64_t addr = RTN_Address(rtn) - IMG_LowAddress(img);
                 // We log the image name and function name so we can see which function is being instrumented.
-                oss << "[Image:" << image_name << "] [Function:" << RTN_Name(rtn) << "]\n";
-                LOG(oss.str());
-                // For each routine, we insert a call to our analysis function `log_function_call`.
-                RTN_InsertCall(rtn, IPOINT_BEFORE, (AFUNPTR)log_function_call,
-                               IARG_PTR, image_name.c_str(),
-                               IARG_PTR, rtn_name.c_str(),
-                               IARG_END);
+                out += entry_line("Function", image_name, rtn_name, addr);
+                // For each routine, we insert a call to our analysis function `record_call`.
+                FuncRecord *rec = registry.add(image_name, rtn_name, addr, load);
+                if (api)
+                    RTN_InsertCall(rtn, IPOINT_BEFORE, (AFUNPTR)record_api_call,
+                                   IARG_PTR, rec,
+                                   IARG_RETURN_IP,
+                                   IARG_ADDRINT, IMG_LowAddress(img),
+                                   IARG_ADDRINT, IMG_HighAddress(img),
+                                   IARG_END);
+                else
+                    RTN_InsertCall(rtn, IPOINT_BEFORE, (AFUNPTR)(KnobTimeline.Value() ? record_call_timed : record_call),
+                                   IARG_PTR, rec,
+                                   IARG_END);
+                if (afl_map.attached())
+                    RTN_InsertCall(rtn, IPOINT_BEFORE, (AFUNPTR)record_afl,
+                                   IARG_UINT32, afl_location(image_name, addr),
+                                   IARG_END);
+                if (KnobEdges.Value())
+                    instrument_edges(rtn, image_name, rtn_name, load, dispatchers);
             }
             RTN_Close(rtn);
         }
     }
+    write_log(out);
+}
+
+// Pin calls this function when an image is unloaded (e.g. dlclose).
+// We write the calls recorded for it before its routines are forgotten.
+VOID image_unload(IMG img, VOID *v)
+{
+    const string calls = registry.flush_image(IMG_Name(img), IMG_Id(img));
+    if (!calls.empty())
+        write_log(calls, true);
+}
+
+// When the tool started, in ns since the epoch.
+static uint64_t tool_start_ns = 0;
+
+// The name of the traced program, for the instrumentation events.
+static string traced_program;
+
+// Whether this is the process the wrapper exec'd Pin in, whose exit ends the
+// traced run.
+static bool wrapper_process()
+{
+    return UINT32(PIN_GetPid()) == KnobExitPid.Value();
+}
+
+// Sends an instrumentation event of the traced run to journald or syslog, as
+// the wrapper logs its start (see funkoverage events).
+static VOID log_event(bool failure, const string &kind, const string &fields)
+{
+    send_syslog(syslog_event(failure, kind, traced_program, PIN_GetPid(), fields, time(nullptr)));
+}
+
+// Writes what is left to log when the process exits, once: the calls and, in
+// the process of -exit_pid, the exit trailer. A process killed by a signal
+// exits with status 128 plus the signal, as shells report it.
+static VOID finish(INT32 code, INT32 signal)
+{
+    static atomic<bool> finished(false);
+    if (finished.exchange(true))
+        return;
+    const string calls = registry.flush_all();
+    if (!calls.empty())
+        write_log(calls, true);
+    if (wrapper_process())
+    {
+        const uint64_t now_ns = chrono::duration_cast<chrono::nanoseconds>(
+                                    chrono::system_clock::now().time_since_epoch()).count();
+        const uint64_t started_ns = KnobStarted.Value() != 0 ? KnobStarted.Value() : tool_start_ns;
+        const unsigned status = signal > 0 ? 128 + signal : code & 0xff;
+        const uint64_t wall_ms = now_ns > started_ns ? (now_ns - started_ns) / 1000000 : 0;
+        write_log(exit_trailer(status, signal, wall_ms, started_ns), true);
+        const string where = writer.is_streaming() ? "stream=" + KnobStream.Value() : "log=" + KnobOutput.Value();
+        log_event(false, "stop", "status=" + to_string(status) + " " + where);
+    }
+    writer.close();
+}
+
+// Pin calls this function when the application exits.
+VOID fini(INT32 code, VOID *v)
+{
+    finish(code, 0);
+}
+
+// Pin calls this function when a signal is delivered to the application: a
+// fatal one ends the process without running its exit code.
+VOID context_change(THREADID tid, CONTEXT_CHANGE_REASON reason, const CONTEXT *from, CONTEXT *to, INT32 info, VOID *v)
+{
+    if (reason == CONTEXT_CHANGE_REASON_FATALSIGNAL)
+        finish(0, info);
+}
+
+// Pin calls this function in a child process right after fork.
+VOID after_fork_in_child(THREADID tid, const CONTEXT *ctxt, VOID *v)
+{
+    registry.forget_parent_calls();
+    afl_map.reset();
+    // A lock taken on the inherited file description would not keep the
+    // parent out, so the child opens the log on its own.
+    if (writer.is_open())
+        writer.reopen();
 }
 
 // Pin calls this function when the application is about to fork a new process.
 // Returning TRUE tells Pin to follow and instrument the child process.
 BOOL follow_child_process(CHILD_PROCESS childProcess, VOID *v)
 {
+    // exec replaces the process without calling fini: write the calls so far,
+    // the new program appends its own to the same log.
+    const string calls = registry.flush_all();
+    if (!calls.empty())
+        write_log(calls, true);
     return TRUE; // Follow the child
 }
 
@@ -90,9 +388,60 @@ int main(int argc, char *argv[])
 
     // Initialize PIN symbols. This is required for routine-level instrumentation.
     PIN_InitSymbols();
+    api_libraries = split_list(KnobAPI.Value());
+    if (KnobJson.Value() && KnobProto.Value())
+    {
+        cerr << "FuncTracer: -json and -proto are exclusive" << endl;
+        return 1;
+    }
+    if (KnobJson.Value())
+        log_encoding = LogEncoding::Json;
+    else if (KnobProto.Value())
+        log_encoding = LogEncoding::Proto;
+    // Pin's -logfile is for text: binary records need a log of their own.
+    if (log_encoding == LogEncoding::Proto && KnobOutput.Value().empty())
+    {
+        cerr << "FuncTracer: -proto needs -o" << endl;
+        return 1;
+    }
+
+    if (KnobAfl.Value())
+    {
+        const char *map_size = getenv("AFL_MAP_SIZE");
+        if (!afl_map.attach(getenv("__AFL_SHM_ID"), map_size ? strtoul(map_size, nullptr, 10) : AFL_DEFAULT_MAP_SIZE))
+        {
+            cerr << "FuncTracer: -afl needs the coverage map of a fuzzer in __AFL_SHM_ID" << endl;
+            return 1;
+        }
+    }
+
+    // A collector that cannot be reached leaves the log to -o.
+    traced_program = program_name(argc, argv);
+    const bool streamed = !KnobStream.Value().empty() &&
+                          writer.stream(KnobStream.Value(), traced_program, KnobOutput.Value());
+    if (!streamed && !KnobOutput.Value().empty() && !writer.open(KnobOutput.Value()))
+    {
+        cerr << "FuncTracer: could not open " << KnobOutput.Value() << endl;
+        if (wrapper_process())
+            log_event(true, "failure", "status=1 reason=no-log log=" + KnobOutput.Value());
+        return 1;
+    }
+
+    // Identify the log format so the report generator knows how to read it,
+    // and the run so it can skip duplicate copies of the log.
+    const uint64_t start_ns = chrono::duration_cast<chrono::nanoseconds>(
+                                  chrono::system_clock::now().time_since_epoch()).count();
+    tool_start_ns = start_ns;
+    write_log(log_header(make_run_id(PIN_GetPid(), start_ns), KnobSample.Value(), KnobSession.Value(), KnobTags.Value(),
+                         log_options()));
+    write_context(argc, argv, start_ns);
 
     // Register the function to be called for every loaded image.
     IMG_AddInstrumentFunction(image_load, 0);
+    IMG_AddUnloadFunction(image_unload, 0);
+    PIN_AddFiniFunction(fini, 0);
+    PIN_AddContextChangeFunction(context_change, 0);
+    PIN_AddForkFunction(FPOINT_AFTER_IN_CHILD, after_fork_in_child, 0);
 
     // install callback to follow the childs
     PIN_AddFollowChildProcessFunction(follow_child_process, 0);
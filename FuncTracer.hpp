@@ -1,9 +1,574 @@
 #ifndef FUNCTRACER_HPP
 #define FUNCTRACER_HPP
 
+#include <cerrno>
+#include <cstdint>
+#include <cstdio>
+#include <cstring>
+#include <ctime>
+#include <link.h>
+#include <fcntl.h>
+#include <sys/file.h>
+#include <sys/mman.h>
+#include <sys/shm.h>
+#include <sys/socket.h>
+#include <sys/un.h>
+#include <unistd.h>
+#include <algorithm>
 #include <string>
 #include <set>
+#include <map>
+#include <memory>
 #include <mutex>
+#include <vector>
+
+// Version of the log format written by the tool. Version 1 logs (no header)
+// printed a Called line at every first call; version 2 keeps the calls in
+// memory and prints them once when the image is unloaded or the process exits;
+// version 3 escapes the image and function fields with escape_field;
+// version 4 adds the image-relative start address of each routine as an
+// [Addr:0x...] field before the function, telling apart same-named statics;
+// version 5 adds the number of calls as a [Count:N] field to Called lines;
+// version 6 adds the optional "[Count:N] [Caller:a] [Callee:b]" call-graph edges;
+// version 7 adds the "[FuncTracer] [Context]" line describing the invocation
+// and an "[Image:x] [BuildID:hex]" line per image;
+// version 8 adds the optional "[Pid:N] [Tid:N] [Time:ns] [First:f]" first-call lines;
+// version 9 adds an "[Image:x] [Arch:name]" line per image;
+// version 10 adds an "[Image:x] [Plugin:loader]" line per image loaded at run time (dlopen);
+// version 11 adds the [Tool:version] and [Options:...] fields to the header.
+constexpr int LOG_FORMAT_VERSION = 11;
+
+// Version of the tool, released together with funkoverage.
+constexpr const char *FUNCTRACER_VERSION = "0.6.3";
+
+// Identifies one traced process, so the report generator can tell a copy of a
+// log (e.g. collected twice by rsync) from another run. Built from the pid and
+// the start time, which no two processes of a host share.
+inline std::string make_run_id(uint64_t pid, uint64_t start_ns)
+{
+    char buf[48];
+    snprintf(buf, sizeof(buf), "%llx-%llx", (unsigned long long)pid, (unsigned long long)start_ns);
+    return buf;
+}
+
+// Escapes the characters that would break a "[Key:value]" field (brackets,
+// backslashes and line breaks) as \xNN, so symbols like operator[] stay intact.
+inline std::string escape_field(const std::string_view &value)
+{
+    static const char hex[] = "0123456789abcdef";
+    std::string out;
+    out.reserve(value.size());
+    for (unsigned char c : value)
+    {
+        if (c == '[' || c == ']' || c == '\\' || c == '\n' || c == '\r')
+        {
+            out += "\\x";
+            out += hex[c >> 4];
+            out += hex[c & 0xf];
+        }
+        else
+            out += c;
+    }
+    return out;
+}
+
+// Splits a comma-separated knob value, dropping empty items.
+inline std::vector<std::string> split_list(const std::string_view &value)
+{
+    std::vector<std::string> items;
+    size_t start = 0;
+    while (start <= value.size())
+    {
+        size_t end = value.find(',', start);
+        if (end == std::string_view::npos)
+            end = value.size();
+        if (end > start)
+            items.emplace_back(value.substr(start, end - start));
+        start = end + 1;
+    }
+    return items;
+}
+
+// How the log is written: as bracketed fields; as JSON lines (-json), one
+// object per event named by its "event" member, whose strings need no
+// escape_field; or as the protobuf records of proto/funkoverage_log.proto
+// (-proto). Readers of the latter two need no FuncTracer-specific parser.
+enum class LogEncoding
+{
+    Text,
+    Json,
+    Proto
+};
+inline LogEncoding log_encoding = LogEncoding::Text;
+
+// Appends value to out as a JSON string. Quotes, backslashes and control
+// characters are escaped; other bytes are copied as they are, so symbols
+// that are not valid UTF-8 still come out unchanged.
+inline void append_json_string(std::string &out, const std::string_view &value)
+{
+    static const char hex[] = "0123456789abcdef";
+    out += '"';
+    for (unsigned char c : value)
+    {
+        if (c == '"' || c == '\\')
+        {
+            out += '\\';
+            out += c;
+        }
+        else if (c < 0x20)
+        {
+            out += "\\u00";
+            out += hex[c >> 4];
+            out += hex[c & 0xf];
+        }
+        else
+            out += c;
+    }
+    out += '"';
+}
+
+// Builds the JSON line of one event, e.g.
+// {"event":"called","image":"/bin/ls","addr":"0x4f10","count":3,"function":"main"}.
+class JsonEvent
+{
+  public:
+    explicit JsonEvent(const char *event) : out("{\"event\":\"") { out += event; out += '"'; }
+
+    JsonEvent &str(const char *name, const std::string_view &value)
+    {
+        key(name);
+        append_json_string(out, value);
+        return *this;
+    }
+
+    JsonEvent &num(const char *name, uint64_t value)
+    {
+        key(name);
+        out += std::to_string(value);
+        return *this;
+    }
+
+    // Addresses are strings as in the bracketed format ("0x4f10"), beyond
+    // the integers every JSON reader represents exactly.
+    JsonEvent &addr(uint64_t value)
+    {
+        char buf[24];
+        snprintf(buf, sizeof(buf), "0x%llx", (unsigned long long)value);
+        return str("addr", buf);
+    }
+
+    JsonEvent &list(const char *name, const std::vector<std::string> &values)
+    {
+        key(name);
+        out += '[';
+        for (size_t i = 0; i < values.size(); i++)
+        {
+            if (i > 0)
+                out += ',';
+            append_json_string(out, values[i]);
+        }
+        out += ']';
+        return *this;
+    }
+
+    std::string line() const { return out + "}\n"; }
+
+  private:
+    std::string out;
+
+    void key(const char *name)
+    {
+        out += ",\"";
+        out += name;
+        out += "\":";
+    }
+};
+
+// Builds a message of proto/funkoverage_log.proto in the protobuf wire
+// format. Zero numbers and empty strings are left out, as proto3 does.
+class ProtoMessage
+{
+  public:
+    ProtoMessage &num(int field, uint64_t value)
+    {
+        if (value != 0)
+        {
+            tag(field, 0);
+            varint(value);
+        }
+        return *this;
+    }
+
+    ProtoMessage &str(int field, const std::string_view &value)
+    {
+        if (!value.empty())
+            bytes(field, value);
+        return *this;
+    }
+
+    // Repeated strings are written even when empty, keeping their positions.
+    ProtoMessage &strs(int field, const std::vector<std::string> &values)
+    {
+        for (const auto &value : values)
+            bytes(field, value);
+        return *this;
+    }
+
+    ProtoMessage &message(int field, const ProtoMessage &value)
+    {
+        bytes(field, value.out);
+        return *this;
+    }
+
+    const std::string &data() const { return out; }
+
+  private:
+    std::string out;
+
+    void varint(uint64_t value)
+    {
+        while (value >= 0x80)
+        {
+            out += char((value & 0x7f) | 0x80);
+            value >>= 7;
+        }
+        out += char(value);
+    }
+
+    void tag(int field, int wire_type) { varint(uint64_t(field) << 3 | wire_type); }
+
+    void bytes(int field, const std::string_view &value)
+    {
+        tag(field, 2);
+        varint(value.size());
+        out += value;
+    }
+};
+
+// The fields of the Event message, one per kind of event.
+enum ProtoEvent
+{
+    PROTO_HEADER = 1,
+    PROTO_CONTEXT = 2,
+    PROTO_FUNCTION = 3,
+    PROTO_CALLED = 4,
+    PROTO_EDGE = 5,
+    PROTO_FIRST_CALL = 6,
+    PROTO_IMAGE = 7,
+    PROTO_EXIT = 8,
+};
+
+// Frames an event as one record of a protobuf log: a Log message whose
+// events field (16) holds it.
+inline std::string proto_record(ProtoEvent kind, const ProtoMessage &event)
+{
+    return ProtoMessage().message(16, ProtoMessage().message(kind, event)).data();
+}
+
+// The header line, naming the format and the tool that wrote it; the optional
+// [Run:id], [Sample:rate], [Session:name], [Tags:a,b] and [Options:...] fields
+// are ignored by older readers. options are the tool options changing what
+// the log records, as given on the command line (-edges -api libssl.so.3).
+inline std::string log_header(const std::string &run_id = "", const std::string &sample = "",
+                              const std::string &session = "", const std::string &tags = "",
+                              const std::string &options = "")
+{
+    if (log_encoding == LogEncoding::Proto)
+        return proto_record(PROTO_HEADER, ProtoMessage()
+                                              .num(1, LOG_FORMAT_VERSION)
+                                              .str(2, FUNCTRACER_VERSION)
+                                              .str(3, run_id)
+                                              .str(4, sample)
+                                              .str(5, session)
+                                              .strs(6, split_list(tags))
+                                              .str(7, options));
+    if (log_encoding == LogEncoding::Json)
+    {
+        JsonEvent event("header");
+        event.num("format", LOG_FORMAT_VERSION).str("tool", FUNCTRACER_VERSION);
+        if (!run_id.empty())
+            event.str("run", run_id);
+        if (!sample.empty())
+            event.str("sample", sample);
+        if (!session.empty())
+            event.str("session", session);
+        if (!tags.empty())
+            event.list("tags", split_list(tags));
+        if (!options.empty())
+            event.str("options", options);
+        return event.line();
+    }
+    std::string header = "[FuncTracer] [Format:" + std::to_string(LOG_FORMAT_VERSION) + "] [Tool:" + FUNCTRACER_VERSION + "]";
+    if (!run_id.empty())
+        header += " [Run:" + run_id + "]";
+    if (!sample.empty())
+        header += " [Sample:" + sample + "]";
+    if (!session.empty())
+        header += " [Session:" + escape_field(session) + "]";
+    if (!tags.empty())
+        header += " [Tags:" + escape_field(tags) + "]";
+    if (!options.empty())
+        header += " [Options:" + escape_field(options) + "]";
+    return header + "\n";
+}
+
+// Formats the trailer ending the log of an invocation, written by the process
+// the wrapper launched when it exits: its exit status, the signal that killed
+// it if any, the wall time since the wrapper launched Pin and when that was,
+// in nanoseconds since the epoch.
+inline std::string exit_trailer(unsigned code, unsigned signal, uint64_t wall_ms, uint64_t started_ns)
+{
+    if (log_encoding == LogEncoding::Proto)
+        return proto_record(PROTO_EXIT, ProtoMessage().num(1, code).num(2, signal).num(3, wall_ms).num(4, started_ns));
+    if (log_encoding == LogEncoding::Json)
+    {
+        JsonEvent event("exit");
+        event.num("code", code);
+        if (signal > 0)
+            event.num("signal", signal);
+        return event.num("wall_ms", wall_ms).num("started", started_ns).line();
+    }
+    std::string line = "[FuncTracer] [Exit:" + std::to_string(code) + "]";
+    if (signal > 0)
+        line += " [Signal:" + std::to_string(signal) + "]";
+    return line + " [WallMs:" + std::to_string(wall_ms) + "] [Started:" + std::to_string(started_ns) + "]\n";
+}
+
+// Formats the context line of an invocation: when and where it ran, its
+// arguments and the selected environment variables, each as an escaped field.
+inline std::string context_line(uint64_t start_ns, const std::string &host, const std::string &cwd,
+                                const std::vector<std::string> &args,
+                                const std::vector<std::pair<std::string, std::string>> &env)
+{
+    const time_t secs = start_ns / 1000000000;
+    struct tm tm;
+    char when[32];
+    gmtime_r(&secs, &tm);
+    strftime(when, sizeof(when), "%Y-%m-%dT%H:%M:%SZ", &tm);
+    if (log_encoding != LogEncoding::Text)
+    {
+        std::vector<std::string> vars;
+        for (const auto &[name, value] : env)
+            vars.push_back(name + "=" + value);
+        if (log_encoding == LogEncoding::Proto)
+            return proto_record(PROTO_CONTEXT, ProtoMessage().str(1, when).str(2, host).str(3, cwd).strs(4, args).strs(5, vars));
+        return JsonEvent("context").str("time", when).str("host", host).str("cwd", cwd).list("args", args).list("env", vars).line();
+    }
+    std::string line = std::string("[FuncTracer] [Context] [Time:") + when + "]";
+    line += " [Host:" + escape_field(host) + "] [Cwd:" + escape_field(cwd) + "]";
+    for (const auto &arg : args)
+        line += " [Arg:" + escape_field(arg) + "]";
+    for (const auto &[name, value] : env)
+        line += " [Env:" + escape_field(name) + "=" + escape_field(value) + "]";
+    return line + "\n";
+}
+
+// Returns the GNU build ID of an ELF image mapped in memory, in hex, or ""
+// if it has none. ehdr is the first mapped byte of the image (its ELF header)
+// and bias the difference between the mapped and the linked addresses.
+inline std::string mapped_build_id(const void *ehdr, uintptr_t bias)
+{
+    const auto *eh = static_cast<const ElfW(Ehdr) *>(ehdr);
+    if (memcmp(eh->e_ident, ELFMAG, SELFMAG) != 0)
+        return "";
+    const auto *phdrs = reinterpret_cast<const ElfW(Phdr) *>(static_cast<const char *>(ehdr) + eh->e_phoff);
+    for (int i = 0; i < eh->e_phnum; i++)
+    {
+        if (phdrs[i].p_type != PT_NOTE)
+            continue;
+        const char *note = reinterpret_cast<const char *>(bias + phdrs[i].p_vaddr);
+        const char *end = note + phdrs[i].p_memsz;
+        while (note + sizeof(ElfW(Nhdr)) <= end)
+        {
+            const auto *nh = reinterpret_cast<const ElfW(Nhdr) *>(note);
+            const char *name = note + sizeof(ElfW(Nhdr));
+            const char *desc = name + ((nh->n_namesz + 3) & ~3u);
+            if (desc + nh->n_descsz > end)
+                break;
+            if (nh->n_type == NT_GNU_BUILD_ID && nh->n_namesz == 4 && memcmp(name, "GNU", 4) == 0)
+            {
+                static const char hex[] = "0123456789abcdef";
+                std::string id;
+                for (uint32_t j = 0; j < nh->n_descsz; j++)
+                {
+                    id += hex[(unsigned char)desc[j] >> 4];
+                    id += hex[(unsigned char)desc[j] & 0xf];
+                }
+                return id;
+            }
+            note = desc + ((nh->n_descsz + 3) & ~3u);
+        }
+    }
+    return "";
+}
+
+// Formats the line recording the build ID of an image.
+inline std::string build_id_line(const std::string &image, const std::string &build_id)
+{
+    if (log_encoding == LogEncoding::Proto)
+        return proto_record(PROTO_IMAGE, ProtoMessage().str(1, image).str(2, build_id));
+    if (log_encoding == LogEncoding::Json)
+        return JsonEvent("build_id").str("image", image).str("build_id", build_id).line();
+    return "[Image:" + escape_field(image) + "] [BuildID:" + build_id + "]\n";
+}
+
+// Names the architecture of an ELF image like uname -m, from its machine,
+// class (ELFCLASS32/64) and byte order. Unknown machines are named by number.
+inline std::string elf_arch_name(uint16_t machine, unsigned char cls, unsigned char data)
+{
+    const bool is64 = cls == ELFCLASS64, le = data == ELFDATA2LSB;
+    switch (machine)
+    {
+    case EM_X86_64:
+        return is64 ? "x86_64" : "x32";
+    case EM_386:
+        return "i386";
+    case EM_AARCH64:
+        return le ? "aarch64" : "aarch64_be";
+    case EM_ARM:
+        return "arm";
+    case EM_PPC64:
+        return le ? "ppc64le" : "ppc64";
+    case EM_PPC:
+        return "ppc";
+    case EM_S390:
+        return is64 ? "s390x" : "s390";
+    case EM_RISCV:
+        return is64 ? "riscv64" : "riscv32";
+    }
+    return "machine" + std::to_string(machine);
+}
+
+// Reads the architecture of a loaded image from its ELF header.
+inline std::string mapped_arch(const void *ehdr)
+{
+    const auto *eh = static_cast<const ElfW(Ehdr) *>(ehdr);
+    if (memcmp(eh->e_ident, ELFMAG, SELFMAG) != 0)
+        return "";
+    return elf_arch_name(eh->e_machine, eh->e_ident[EI_CLASS], eh->e_ident[EI_DATA]);
+}
+
+// Formats the line recording the architecture of an image.
+inline std::string arch_line(const std::string &image, const std::string &arch)
+{
+    if (log_encoding == LogEncoding::Proto)
+        return proto_record(PROTO_IMAGE, ProtoMessage().str(1, image).str(3, arch));
+    if (log_encoding == LogEncoding::Json)
+        return JsonEvent("arch").str("image", image).str("arch", arch).line();
+    return "[Image:" + escape_field(image) + "] [Arch:" + arch + "]\n";
+}
+
+// Formats the line marking image as a plugin: loaded at run time, with
+// dlopen, by the program loader rather than at its start.
+inline std::string plugin_line(const std::string &image, const std::string &loader)
+{
+    if (log_encoding == LogEncoding::Proto)
+        return proto_record(PROTO_IMAGE, ProtoMessage().str(1, image).str(4, loader));
+    if (log_encoding == LogEncoding::Json)
+        return JsonEvent("plugin").str("image", image).str("loader", loader).line();
+    return "[Image:" + escape_field(image) + "] [Plugin:" + escape_field(loader) + "]\n";
+}
+
+// Formats the line listing a section of an image, which the report ignores.
+// Protobuf logs leave it out.
+inline std::string section_line(const std::string &image, const std::string &section)
+{
+    if (log_encoding == LogEncoding::Proto)
+        return "";
+    if (log_encoding == LogEncoding::Json)
+        return JsonEvent("section").str("image", image).str("section", section).line();
+    return "[Image:" + image + "] [Section:" + section + "]\n";
+}
+
+// Formats the line telling an image is not traced. Protobuf logs leave it out.
+inline std::string skipped_image_line(const std::string &image)
+{
+    if (log_encoding == LogEncoding::Proto)
+        return "";
+    if (log_encoding == LogEncoding::Json)
+        return JsonEvent("skipped").str("image", image).line();
+    return "[Image:" + image + "] is not relevant, skipping...\n";
+}
+
+// Formats a Function or Called line (kind is "Function" or "Called"). A zero
+// address means unknown and a zero count is not written.
+inline std::string entry_line(const char *kind, const std::string &image, const std::string &name, uint64_t addr, uint64_t count = 0)
+{
+    if (log_encoding == LogEncoding::Proto)
+        return proto_record(kind[0] == 'F' ? PROTO_FUNCTION : PROTO_CALLED,
+                            ProtoMessage().str(1, image).num(2, addr).num(3, count).str(4, name));
+    if (log_encoding == LogEncoding::Json)
+    {
+        // kind is "Function" or "Called", the event "function" or "called".
+        JsonEvent event(kind[0] == 'F' ? "function" : "called");
+        event.str("image", image);
+        if (addr != 0)
+            event.addr(addr);
+        if (count != 0)
+            event.num("count", count);
+        return event.str("function", name).line();
+    }
+    std::string line = "[Image:" + escape_field(image) + "] ";
+    char buf[32];
+    if (addr != 0)
+    {
+        snprintf(buf, sizeof(buf), "[Addr:0x%llx] ", (unsigned long long)addr);
+        line += buf;
+    }
+    if (count != 0)
+    {
+        snprintf(buf, sizeof(buf), "[Count:%llu] ", (unsigned long long)count);
+        line += buf;
+    }
+    return line + "[" + kind + ":" + escape_field(name) + "]\n";
+}
+
+// Formats the line telling when and in which process and thread a routine was
+// first called, as nanoseconds since the epoch.
+inline std::string first_call_line(const std::string &image, const std::string &name, uint64_t addr,
+                                   uint64_t pid, uint64_t tid, uint64_t time_ns)
+{
+    if (log_encoding == LogEncoding::Proto)
+        return proto_record(PROTO_FIRST_CALL, ProtoMessage().str(1, image).num(2, addr).num(3, pid).num(4, tid).num(5, time_ns).str(6, name));
+    if (log_encoding == LogEncoding::Json)
+    {
+        JsonEvent event("first_call");
+        event.str("image", image);
+        if (addr != 0)
+            event.addr(addr);
+        return event.num("pid", pid).num("tid", tid).num("time_ns", time_ns).str("function", name).line();
+    }
+    std::string line = "[Image:" + escape_field(image) + "] ";
+    char buf[96];
+    if (addr != 0)
+    {
+        snprintf(buf, sizeof(buf), "[Addr:0x%llx] ", (unsigned long long)addr);
+        line += buf;
+    }
+    snprintf(buf, sizeof(buf), "[Pid:%llu] [Tid:%llu] [Time:%llu] ", (unsigned long long)pid,
+             (unsigned long long)tid, (unsigned long long)time_ns);
+    return line + buf + "[First:" + escape_field(name) + "]\n";
+}
+
+// Formats a caller -> callee edge line with the number of calls made along it.
+inline std::string edge_line(const std::string &image, const std::string &caller, const std::string &callee, uint64_t count)
+{
+    if (log_encoding == LogEncoding::Proto)
+        return proto_record(PROTO_EDGE, ProtoMessage().str(1, image).num(2, count).str(3, caller).str(4, callee));
+    if (log_encoding == LogEncoding::Json)
+        return JsonEvent("edge").str("image", image).num("count", count).str("caller", caller).str("callee", callee).line();
+    return "[Image:" + escape_field(image) + "] [Count:" + std::to_string(count) + "] [Caller:" +
+           escape_field(caller) + "] [Callee:" + escape_field(callee) + "]\n";
+}
+
+// Names the function a call lands in: a call through the PLT stub "puts@plt"
+// ends up in puts, in whichever image defines it.
+inline std::string_view call_target(std::string_view name)
+{
+    if (name.ends_with("@plt"))
+        name.remove_suffix(4);
+    return name;
+}
 
 // Determine if function name is relevant to us and if it will be logged
 bool func_is_relevant(const std::string_view &func_name)
@@ -22,6 +587,40 @@ bool func_is_relevant(const std::string_view &func_name)
     return true;
 }
 
+// Pin names the resolver routine of a GNU IFUNC function "<function>_ifunc".
+inline constexpr std::string_view ifunc_resolver_suffix = "_ifunc";
+
+// Tells whether a routine is an IFUNC resolver, or one of the per-CPU variants
+// a resolver of the image chooses from ("__memcpy_avx_unaligned" for memcpy,
+// of the dispatchers memcpy_ifunc makes). They are logged despite a "__"
+// prefix, for the report to merge them into their function; the tags are
+// those of ifuncVariantTags in cmd/ifunc.go.
+bool ifunc_routine(std::string_view name, const std::set<std::string, std::less<>> &dispatchers)
+{
+    static const std::set<std::string_view> tags = {
+        "sse2", "ssse3", "sse4", "sse42", "avx", "avx2", "avx512", "avx512f", "avx512bw", "avx512vl",
+        "evex", "evex512", "rtm", "erms", "unaligned", "aligned", "generic", "baseline", "sve",
+        "asimd", "simd", "power7", "power8", "power9", "power10", "z13", "z15"
+    };
+    if (name.size() > ifunc_resolver_suffix.size() && name.ends_with(ifunc_resolver_suffix))
+        return true;
+    while (name.starts_with('_'))
+        name.remove_prefix(1);
+    for (size_t i = 1; i < name.size(); i++)
+    {
+        if (name[i] != '_' || !dispatchers.contains(name.substr(0, i)))
+            continue;
+        for (std::string_view rest = name.substr(i + 1); !rest.empty();)
+        {
+            const size_t end = std::min(rest.find('_'), rest.size());
+            if (tags.contains(rest.substr(0, end)))
+                return true;
+            rest.remove_prefix(std::min(end + 1, rest.size()));
+        }
+    }
+    return false;
+}
+
 bool image_is_relevant(const std::string_view &image_name)
 {
     static const std::set<std::string_view> blacklist = {
@@ -30,4 +629,439 @@ bool image_is_relevant(const std::string_view &image_name)
     return !blacklist.contains(image_name);
 }
 
+// Tells whether image is one of the libraries of -api: a library matches its
+// file name ("libssl.so.3"), or a prefix of it up to a dot or dash ("libssl",
+// "libssl.so"), so the selection survives soname bumps.
+inline bool api_library_matches(const std::string_view &image_name, const std::vector<std::string> &libs)
+{
+    std::string_view base = image_name;
+    if (const size_t slash = base.rfind('/'); slash != std::string_view::npos)
+        base.remove_prefix(slash + 1);
+    for (const std::string &lib : libs)
+    {
+        if (lib.empty() || !base.starts_with(lib))
+            continue;
+        if (base.size() == lib.size() || base[lib.size()] == '.' || base[lib.size()] == '-')
+            return true;
+    }
+    return false;
+}
+
+// One instrumented routine. The analysis routine only increments `calls`, so
+// the hot path takes no lock and does no I/O.
+struct FuncRecord
+{
+    std::string image;
+    std::string name;
+    uint64_t addr = 0; // start address relative to the image, 0 if unknown
+    uint64_t calls = 0;
+    // With -timeline: when and where the routine was first called, 0 if not yet.
+    uint64_t first_ns = 0;
+    uint64_t first_pid = 0;
+    uint64_t first_tid = 0;
+};
+
+// One caller -> callee pair of an image; `calls` is incremented at every call.
+struct EdgeRecord
+{
+    std::string image;
+    std::string caller;
+    std::string callee;
+    uint64_t calls = 0;
+};
+
+// Keeps the routines of every loaded image until their calls are flushed to the
+// log. An image is identified by its name and load: a plugin unloaded and
+// loaded again, or loaded twice with dlmopen, gets records of its own per
+// load, so the unload of one does not drop the calls of another.
+class CallRegistry
+{
+public:
+    // Registers a routine; the returned pointer stays valid until the image is flushed.
+    FuncRecord *add(const std::string &image, const std::string &name, uint64_t addr = 0, uint32_t load = 0)
+    {
+        std::lock_guard<std::mutex> guard(mtx);
+        auto &records = by_image[{image, load}];
+        records.push_back(std::make_unique<FuncRecord>(FuncRecord{image, name, addr}));
+        return records.back().get();
+    }
+
+    // Registers a call site; the returned pointer stays valid until the image is flushed.
+    EdgeRecord *add_edge(const std::string &image, const std::string &caller, const std::string &callee, uint32_t load = 0)
+    {
+        std::lock_guard<std::mutex> guard(mtx);
+        auto &edges = edges_by_image[{image, load}];
+        edges.push_back(std::make_unique<EdgeRecord>(EdgeRecord{image, caller, callee}));
+        return edges.back().get();
+    }
+
+    // Returns the Called and edge lines of a load of an image and forgets it (used on image unload).
+    std::string flush_image(const std::string &image, uint32_t load = 0)
+    {
+        std::lock_guard<std::mutex> guard(mtx);
+        std::string out;
+        if (auto it = by_image.find({image, load}); it != by_image.end())
+        {
+            out += format_calls(it->second);
+            by_image.erase(it);
+        }
+        if (auto it = edges_by_image.find({image, load}); it != edges_by_image.end())
+        {
+            out += format_edges(it->second);
+            edges_by_image.erase(it);
+        }
+        return out;
+    }
+
+    // Clears the calls, edges and first calls recorded so far, in a forked
+    // child: they were made, and are written, by the parent.
+    void forget_parent_calls()
+    {
+        std::lock_guard<std::mutex> guard(mtx);
+        for (auto &[key, records] : by_image)
+            for (auto &rec : records)
+                rec->calls = 0, rec->first_ns = 0;
+        for (auto &[key, edges] : edges_by_image)
+            for (auto &edge : edges)
+                edge->calls = 0;
+    }
+
+    // Returns the Called and edge lines of all the images still loaded (used at process exit).
+    std::string flush_all()
+    {
+        std::lock_guard<std::mutex> guard(mtx);
+        std::string out;
+        for (auto &[key, records] : by_image)
+            out += format_calls(records);
+        for (auto &[key, edges] : edges_by_image)
+            out += format_edges(edges);
+        by_image.clear();
+        edges_by_image.clear();
+        return out;
+    }
+
+private:
+    static std::string format_calls(const std::vector<std::unique_ptr<FuncRecord>> &records)
+    {
+        // The same symbol may appear in several sections: its calls are summed
+        // into one line, written in registration order, and its first call is
+        // the earliest of them.
+        std::map<std::pair<std::string, uint64_t>, uint64_t> counts;
+        std::map<std::pair<std::string, uint64_t>, const FuncRecord *> firsts;
+        std::vector<const FuncRecord *> order;
+        for (const auto &rec : records)
+        {
+            const uint64_t calls = __atomic_load_n(&rec->calls, __ATOMIC_RELAXED);
+            if (calls == 0)
+                continue;
+            auto [it, inserted] = counts.try_emplace({rec->name, rec->addr}, 0);
+            it->second += calls;
+            if (inserted)
+                order.push_back(rec.get());
+            const uint64_t first_ns = __atomic_load_n(&rec->first_ns, __ATOMIC_ACQUIRE);
+            if (first_ns == 0)
+                continue;
+            auto [first, none] = firsts.try_emplace({rec->name, rec->addr}, rec.get());
+            if (!none && first_ns < first->second->first_ns)
+                first->second = rec.get();
+        }
+        std::string out;
+        for (const FuncRecord *rec : order)
+        {
+            out += entry_line("Called", rec->image, rec->name, rec->addr, counts[{rec->name, rec->addr}]);
+            if (auto it = firsts.find({rec->name, rec->addr}); it != firsts.end())
+            {
+                const FuncRecord *first = it->second;
+                out += first_call_line(rec->image, rec->name, rec->addr, first->first_pid, first->first_tid, first->first_ns);
+            }
+        }
+        return out;
+    }
+
+    // Several call sites of a caller may reach the same callee: their calls
+    // are summed into one line, written in registration order.
+    static std::string format_edges(const std::vector<std::unique_ptr<EdgeRecord>> &edges)
+    {
+        std::map<std::pair<std::string, std::string>, uint64_t> counts;
+        std::vector<const EdgeRecord *> order;
+        for (const auto &edge : edges)
+        {
+            const uint64_t calls = __atomic_load_n(&edge->calls, __ATOMIC_RELAXED);
+            if (calls == 0)
+                continue;
+            auto [it, inserted] = counts.try_emplace({edge->caller, edge->callee}, 0);
+            it->second += calls;
+            if (inserted)
+                order.push_back(edge.get());
+        }
+        std::string out;
+        for (const EdgeRecord *edge : order)
+            out += edge_line(edge->image, edge->caller, edge->callee, counts[{edge->caller, edge->callee}]);
+        return out;
+    }
+
+    std::mutex mtx;
+    // Keyed by image name and load.
+    using ImageKey = std::pair<std::string, uint32_t>;
+    std::map<ImageKey, std::vector<std::unique_ptr<FuncRecord>>> by_image;
+    std::map<ImageKey, std::vector<std::unique_ptr<EdgeRecord>>> edges_by_image;
+};
+
+// Size of the coverage map of AFL, unless AFL_MAP_SIZE sets another.
+constexpr size_t AFL_DEFAULT_MAP_SIZE = 1 << 16;
+
+// Identifies a routine in the AFL map like the random ID afl-cc gives a basic
+// block, but stable across runs: the FNV-1a hash of its image and address.
+inline uint32_t afl_location(const std::string &image, uint64_t addr)
+{
+    uint32_t hash = 2166136261u;
+    auto mix = [&hash](unsigned char c) { hash = (hash ^ c) * 16777619u; };
+    for (unsigned char c : image)
+        mix(c);
+    for (int i = 0; i < 8; i++)
+        mix(static_cast<unsigned char>(addr >> (8 * i)));
+    return hash;
+}
+
+// The coverage map a fuzzer of the AFL family shares with its target (-afl):
+// every call counts the transition from the routine called before, as AFL
+// counts the edges between basic blocks, so system binaries traced by Pin
+// serve as coverage-guided targets.
+class AflMap
+{
+public:
+    // Attaches the map of __AFL_SHM_ID: a System V shared memory identifier,
+    // or the POSIX shared memory name of AFL++ built with USEMMAP (and of
+    // funkoverage fuzz-bridge).
+    bool attach(const char *shm_id, size_t map_size)
+    {
+        if (!shm_id || !*shm_id || map_size == 0)
+            return false;
+        char *end = nullptr;
+        const long id = strtol(shm_id, &end, 10);
+        void *mem = MAP_FAILED;
+        if (*end == 0)
+        {
+            mem = shmat(static_cast<int>(id), nullptr, 0);
+            if (mem == reinterpret_cast<void *>(-1))
+                mem = MAP_FAILED;
+        }
+        else
+        {
+            const int fd = ::open(("/dev/shm/" + std::string(shm_id + (*shm_id == '/'))).c_str(), O_RDWR | O_CLOEXEC);
+            if (fd < 0)
+                return false;
+            mem = mmap(nullptr, map_size, PROT_READ | PROT_WRITE, MAP_SHARED, fd, 0);
+            ::close(fd);
+        }
+        if (mem == MAP_FAILED)
+            return false;
+        map = static_cast<uint8_t *>(mem);
+        size = map_size;
+        return true;
+    }
+
+    bool attached() const { return map != nullptr; }
+
+    // Counts the transition from the previous routine to location. Threads
+    // share the previous routine: a race only blurs which edge is counted.
+    void record(uint32_t location)
+    {
+        map[(location ^ prev) % size]++;
+        prev = location >> 1;
+    }
+
+    // A forked child starts with no previous routine, like an AFL target.
+    void reset() { prev = 0; }
+
+private:
+    uint8_t *map = nullptr;
+    size_t size = 0;
+    uint32_t prev = 0;
+};
+
+// Formats an instrumentation event for the local syslog socket, as logger -t
+// funkoverage sends those of the wrapper: "event=<kind> binary=<name>
+// pid=<pid>" and the key=value fields, with facility user and severity err or
+// info (see funkoverage events).
+inline std::string syslog_event(bool failure, const std::string &kind, const std::string &binary, uint64_t pid,
+                                const std::string &fields, time_t now)
+{
+    struct tm tm;
+    char when[32];
+    localtime_r(&now, &tm);
+    strftime(when, sizeof(when), "%b %e %H:%M:%S", &tm);
+    const std::string id = std::to_string(pid);
+    return "<" + std::to_string(failure ? 11 : 14) + ">" + when + " funkoverage[" + id + "]: event=" + kind +
+           " binary=" + binary + " pid=" + id + (fields.empty() ? "" : " " + fields);
+}
+
+// Sends a message to the syslog socket; events are best effort, so failures
+// are ignored.
+inline void send_syslog(const std::string &message, const char *socket_path = "/dev/log")
+{
+    sockaddr_un addr{};
+    if (strlen(socket_path) >= sizeof(addr.sun_path))
+        return;
+    addr.sun_family = AF_UNIX;
+    strcpy(addr.sun_path, socket_path);
+    const int fd = ::socket(AF_UNIX, SOCK_DGRAM | SOCK_CLOEXEC, 0);
+    if (fd < 0)
+        return;
+    sendto(fd, message.data(), message.size(), MSG_NOSIGNAL, reinterpret_cast<sockaddr *>(&addr), sizeof(addr));
+    ::close(fd);
+}
+
+// Appends to a log shared by the processes of a traced run: -follow_execv
+// children open it again and forked children inherit it. Blocks of complete
+// lines are written under an exclusive lock with O_APPEND, so lines of
+// different processes never interleave and no process truncates the log.
+//
+// With stream, blocks go to the unix socket of funkoverage stream-collector
+// instead, one connection per process: after a "[FuncTracer] [Stream:name]"
+// line naming the program, each block is sent as its size in decimal and a
+// newline, then its bytes. Should the collector go away, the rest of the
+// blocks are appended to the log file.
+class LogWriter
+{
+public:
+    ~LogWriter() { close(); }
+
+    bool open(const std::string &log_path)
+    {
+        std::lock_guard<std::mutex> guard(mtx);
+        path = log_path;
+        return open_locked();
+    }
+
+    // Connects to the stream collector listening on socket_path, sending the
+    // program name the collector files the log under. log_path is the log
+    // written should the collector go away, empty for none.
+    bool stream(const std::string &socket_path, const std::string &name, const std::string &log_path)
+    {
+        std::lock_guard<std::mutex> guard(mtx);
+        socket = socket_path;
+        stream_name = name;
+        path = log_path;
+        return connect_locked();
+    }
+
+    // Opens the log again, so a forked child no longer shares the open file
+    // description (and thus the lock) of its parent, or the connection of its
+    // parent to the stream collector.
+    bool reopen()
+    {
+        std::lock_guard<std::mutex> guard(mtx);
+        if (fd >= 0)
+            ::close(fd);
+        fd = -1;
+        if (streaming && connect_locked())
+            return true;
+        return open_locked();
+    }
+
+    bool is_open() const { return fd >= 0; }
+
+    // Whether the log goes to the stream collector rather than to the file.
+    bool is_streaming() const { return streaming; }
+
+    // Appends a block of complete lines; sync also waits for it to reach the disk.
+    bool write(const std::string &block, bool sync = false)
+    {
+        std::lock_guard<std::mutex> guard(mtx);
+        if (fd < 0)
+            return false;
+        if (streaming)
+        {
+            if (send_all(std::to_string(block.size()) + "\n" + block))
+                return true;
+            ::close(fd);
+            fd = -1;
+            if (!open_locked())
+                return false;
+        }
+        while (flock(fd, LOCK_EX) != 0)
+            if (errno != EINTR)
+                return false;
+        bool ok = true;
+        for (size_t done = 0; done < block.size();)
+        {
+            const ssize_t n = ::write(fd, block.data() + done, block.size() - done);
+            if (n < 0 && errno == EINTR)
+                continue;
+            if (n <= 0)
+            {
+                ok = false;
+                break;
+            }
+            done += n;
+        }
+        if (sync && fsync(fd) != 0)
+            ok = false;
+        flock(fd, LOCK_UN);
+        return ok;
+    }
+
+    void close()
+    {
+        std::lock_guard<std::mutex> guard(mtx);
+        if (fd >= 0)
+            ::close(fd);
+        fd = -1;
+    }
+
+private:
+    bool open_locked()
+    {
+        streaming = false;
+        if (path.empty())
+            return false;
+        fd = ::open(path.c_str(), O_WRONLY | O_CREAT | O_APPEND | O_CLOEXEC, 0644);
+        return fd >= 0;
+    }
+
+    bool connect_locked()
+    {
+        sockaddr_un addr{};
+        if (socket.empty() || socket.size() >= sizeof(addr.sun_path))
+            return false;
+        addr.sun_family = AF_UNIX;
+        memcpy(addr.sun_path, socket.c_str(), socket.size());
+        fd = ::socket(AF_UNIX, SOCK_STREAM | SOCK_CLOEXEC, 0);
+        if (fd < 0)
+            return false;
+        streaming = true;
+        if (::connect(fd, reinterpret_cast<sockaddr *>(&addr), sizeof(addr)) == 0 &&
+            send_all("[FuncTracer] [Stream:" + escape_field(stream_name) + "]\n"))
+            return true;
+        ::close(fd);
+        fd = -1;
+        streaming = false;
+        return false;
+    }
+
+    // Sends data whole; MSG_NOSIGNAL keeps a collector gone away from
+    // killing the traced program with SIGPIPE.
+    bool send_all(const std::string &data)
+    {
+        for (size_t done = 0; done < data.size();)
+        {
+            const ssize_t n = ::send(fd, data.data() + done, data.size() - done, MSG_NOSIGNAL);
+            if (n < 0 && errno == EINTR)
+                continue;
+            if (n <= 0)
+                return false;
+            done += n;
+        }
+        return true;
+    }
+
+    std::mutex mtx;
+    std::string path;
+    std::string socket;
+    std::string stream_name;
+    bool streaming = false;
+    int fd = -1;
+};
+
 #endif // FUNCTRACER_HPP
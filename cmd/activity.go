@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Wrapper Activity Events ---
+
+// syslogTag identifies the instrumentation events wrappers send with logger
+// and FuncTracer sends to the syslog socket.
+const syslogTag = "funkoverage"
+
+// Wrapper events, logged as "event=<kind> binary=<name> pid=<pid> ...".
+const (
+	activityStart   = "start"   // pin launched
+	activityStop    = "stop"    // traced run ended, with its exit status
+	activityFailure = "failure" // pin could not start or open the log
+)
+
+// defaultSyslogFiles are read when journalctl is not available.
+var defaultSyslogFiles = []string{"/var/log/messages", "/var/log/syslog"}
+
+// WrapperEvent is one instrumentation event of a wrapped binary.
+type WrapperEvent struct {
+	Time   time.Time `json:"time"`
+	Host   string    `json:"host,omitempty"`
+	Event  string    `json:"event"`
+	Binary string    `json:"binary"`
+	PID    int       `json:"pid"`
+	// Fields holds the remaining key=value pairs: status, log and reason.
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// EventFilter selects the events listed by funkoverage events.
+type EventFilter struct {
+	Since  time.Time
+	Binary string
+	Event  string
+}
+
+func (f EventFilter) match(e WrapperEvent) bool {
+	return !e.Time.Before(f.Since) &&
+		(f.Binary == "" || e.Binary == f.Binary) &&
+		(f.Event == "" || e.Event == f.Event)
+}
+
+// parseEventSince parses an events --since value: a duration back from now
+// (2h, 30m) or an absolute time as accepted by --timestamp.
+func parseEventSince(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	t, err := parseReportTimestamp(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected a duration (2h), Unix seconds or RFC 3339", s)
+	}
+	return t, nil
+}
+
+// parseEventMessage parses the message of a wrapper event. Values may contain
+// spaces (log paths): words without "=" continue the previous value.
+func parseEventMessage(msg string) (WrapperEvent, bool) {
+	var e WrapperEvent
+	fields := make(map[string]string)
+	key := ""
+	for _, word := range strings.Fields(msg) {
+		k, v, ok := strings.Cut(word, "=")
+		if !ok {
+			if key == "" {
+				return WrapperEvent{}, false
+			}
+			fields[key] += " " + word
+			continue
+		}
+		key = k
+		fields[k] = v
+	}
+	e.Event, e.Binary = fields["event"], fields["binary"]
+	if e.Event == "" || e.Binary == "" {
+		return WrapperEvent{}, false
+	}
+	e.PID, _ = strconv.Atoi(fields["pid"])
+	delete(fields, "event")
+	delete(fields, "binary")
+	delete(fields, "pid")
+	if len(fields) > 0 {
+		e.Fields = fields
+	}
+	return e, true
+}
+
+// journalEntry holds the fields of journalctl -o json used here.
+type journalEntry struct {
+	Message  any    `json:"MESSAGE"` // a string, or bytes for binary data
+	Realtime string `json:"__REALTIME_TIMESTAMP"`
+	Hostname string `json:"_HOSTNAME"`
+}
+
+// readJournalEvents parses the output of journalctl -o json.
+func readJournalEvents(r io.Reader) ([]WrapperEvent, error) {
+	var events []WrapperEvent
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("could not parse journal entry: %w", err)
+		}
+		msg, ok := entry.Message.(string)
+		if !ok {
+			continue
+		}
+		e, ok := parseEventMessage(msg)
+		if !ok {
+			continue
+		}
+		if usec, err := strconv.ParseInt(entry.Realtime, 10, 64); err == nil {
+			e.Time = time.UnixMicro(usec)
+		}
+		e.Host = entry.Hostname
+		events = append(events, e)
+	}
+	return events, scanner.Err()
+}
+
+// syslogTimeLayouts are the timestamps of classic (RFC 3164) and high
+// precision (RFC 3339) syslog files.
+var syslogTimeLayouts = []string{time.RFC3339Nano, time.Stamp}
+
+// readSyslogEvents parses the wrapper events of a syslog file, whose lines
+// look like "<time> <host> funkoverage[<pid>]: event=start ...". Classic
+// timestamps carry no year and are taken to be of the year of now.
+func readSyslogEvents(r io.Reader, now time.Time) ([]WrapperEvent, error) {
+	var events []WrapperEvent
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		i := strings.Index(line, " "+syslogTag+"[")
+		if i < 0 {
+			i = strings.Index(line, " "+syslogTag+":")
+		}
+		if i < 0 {
+			continue
+		}
+		_, msg, ok := strings.Cut(line[i+1:], ": ")
+		if !ok {
+			continue
+		}
+		e, ok := parseEventMessage(msg)
+		if !ok {
+			continue
+		}
+		prefix := line[:i]
+		if j := strings.LastIndexByte(prefix, ' '); j >= 0 {
+			e.Host = prefix[j+1:]
+			prefix = strings.TrimSpace(prefix[:j])
+		}
+		for _, layout := range syslogTimeLayouts {
+			t, err := time.ParseInLocation(layout, prefix, now.Location())
+			if err != nil {
+				continue
+			}
+			if t.Year() == 0 {
+				t = t.AddDate(now.Year(), 0, 0)
+			}
+			e.Time = t
+			break
+		}
+		events = append(events, e)
+	}
+	return events, scanner.Err()
+}
+
+// wrapperEvents returns the wrapper events of the syslog file, or of the
+// journal (falling back to the default syslog files) when file is empty,
+// matching filter in chronological order.
+func wrapperEvents(file string, filter EventFilter) ([]WrapperEvent, error) {
+	now := time.Now()
+	var events []WrapperEvent
+	var err error
+	switch {
+	case file != "":
+		events, err = readSyslogFile(file, now)
+	case hasJournal():
+		args := []string{"-t", syslogTag, "-o", "json", "--no-pager"}
+		if !filter.Since.IsZero() {
+			args = append(args, "--since", filter.Since.Local().Format("2006-01-02 15:04:05"))
+		}
+		var out []byte
+		if out, err = exec.Command("journalctl", args...).Output(); err != nil {
+			return nil, fmt.Errorf("journalctl: %w", err)
+		}
+		events, err = readJournalEvents(bytes.NewReader(out))
+	default:
+		found := false
+		for _, f := range defaultSyslogFiles {
+			if _, statErr := os.Stat(f); statErr == nil {
+				events, err = readSyslogFile(f, now)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, errors.New("no journal and none of " + strings.Join(defaultSyslogFiles, ", ") + " found, pass a syslog file with --file")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	matched := events[:0]
+	for _, e := range events {
+		if filter.match(e) {
+			matched = append(matched, e)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool { return matched[i].Time.Before(matched[j].Time) })
+	return matched, nil
+}
+
+// hasJournal reports whether journalctl can be queried.
+func hasJournal() bool {
+	_, err := exec.LookPath("journalctl")
+	return err == nil
+}
+
+func readSyslogFile(path string, now time.Time) ([]WrapperEvent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readSyslogEvents(f, now)
+}
+
+// printWrapperEvents lists events one per line, or as a JSON array.
+func printWrapperEvents(w io.Writer, events []WrapperEvent, asJSON bool) error {
+	if asJSON {
+		if events == nil {
+			events = []WrapperEvent{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(events)
+	}
+	for _, e := range events {
+		var details []string
+		for _, k := range sortedKeys(e.Fields) {
+			details = append(details, k+"="+e.Fields[k])
+		}
+		fmt.Fprintf(w, "%s  %-12s %-8s %-20s %7d  %s\n", e.Time.Format(time.RFC3339), e.Host, e.Event, e.Binary, e.PID, strings.Join(details, " "))
+	}
+	return nil
+}
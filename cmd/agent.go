@@ -0,0 +1,574 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Fleet Agents ---
+
+// agentsFileName keeps the registered agents in the collector store.
+const agentsFileName = "agents.json"
+
+// agentStateFileName records, in the log directory, the logs an agent shipped.
+const agentStateFileName = ".funkoverage-agent.json"
+
+// agentResultsKept bounds the instruction results remembered per agent.
+const agentResultsKept = 20
+
+// Actions an agent applies on behalf of the collector.
+const (
+	agentWrap   = "wrap"
+	agentUnwrap = "unwrap"
+)
+
+// AgentInstruction is a wrap or unwrap queued for an agent.
+type AgentInstruction struct {
+	ID     int64     `json:"id"`
+	Action string    `json:"action"`
+	Path   string    `json:"path"`
+	Queued time.Time `json:"queued"`
+}
+
+// AgentResult is the outcome of an instruction on the agent.
+type AgentResult struct {
+	ID     int64     `json:"id"`
+	Action string    `json:"action"`
+	Path   string    `json:"path"`
+	Error  string    `json:"error,omitempty"`
+	Done   time.Time `json:"done"`
+}
+
+// AgentHealth is what an agent reports about itself at every heartbeat.
+type AgentHealth struct {
+	Uptime time.Duration `json:"uptime"`
+	// Wrapped lists the binaries of the wrap manifest.
+	Wrapped []string `json:"wrapped"`
+	// PendingLogs counts the finished logs not shipped yet.
+	PendingLogs int    `json:"pending_logs"`
+	ShippedLogs int    `json:"shipped_logs"`
+	FreeBytes   uint64 `json:"free_bytes"`
+	LastError   string `json:"last_error,omitempty"`
+}
+
+// AgentHeartbeat is the body an agent posts to /api/agents/heartbeat.
+type AgentHeartbeat struct {
+	Host    string        `json:"host"`
+	Version string        `json:"version"`
+	Health  AgentHealth   `json:"health"`
+	Results []AgentResult `json:"results,omitempty"`
+}
+
+// AgentRecord is what the collector knows of an agent.
+type AgentRecord struct {
+	Host       string             `json:"host"`
+	Version    string             `json:"version"`
+	Registered time.Time          `json:"registered"`
+	LastSeen   time.Time          `json:"last_seen"`
+	Health     AgentHealth        `json:"health"`
+	Pending    []AgentInstruction `json:"pending"`
+	Results    []AgentResult      `json:"results"`
+}
+
+type agentRegistry struct {
+	NextID int64                   `json:"next_id"`
+	Agents map[string]*AgentRecord `json:"agents"`
+}
+
+var agentsMu sync.Mutex
+
+// readAgents reads the registry of storeDir; the caller holds agentsMu.
+func readAgents(storeDir string) (*agentRegistry, error) {
+	reg := &agentRegistry{Agents: map[string]*AgentRecord{}}
+	content, err := os.ReadFile(filepath.Join(storeDir, agentsFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return reg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, reg); err != nil {
+		return nil, err
+	}
+	if reg.Agents == nil {
+		reg.Agents = map[string]*AgentRecord{}
+	}
+	return reg, nil
+}
+
+// updateAgents applies fn to the registry of storeDir and saves it when fn succeeds.
+func updateAgents(storeDir string, fn func(*agentRegistry) error) error {
+	agentsMu.Lock()
+	defer agentsMu.Unlock()
+	reg, err := readAgents(storeDir)
+	if err != nil {
+		return err
+	}
+	if err := fn(reg); err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(reg, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(storeDir, ".agents-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(content, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(storeDir, agentsFileName))
+}
+
+// heartbeat registers or refreshes the agent, drops the instructions it
+// reports results for and returns those still pending.
+func (reg *agentRegistry) heartbeat(hb AgentHeartbeat, now time.Time) []AgentInstruction {
+	rec, ok := reg.Agents[hb.Host]
+	if !ok {
+		rec = &AgentRecord{Host: hb.Host, Registered: now}
+		reg.Agents[hb.Host] = rec
+	}
+	rec.Version, rec.Health, rec.LastSeen = hb.Version, hb.Health, now
+	done := map[int64]bool{}
+	for _, res := range hb.Results {
+		done[res.ID] = true
+		rec.Results = append(rec.Results, res)
+	}
+	if n := len(rec.Results); n > agentResultsKept {
+		rec.Results = rec.Results[n-agentResultsKept:]
+	}
+	pending := []AgentInstruction{}
+	for _, inst := range rec.Pending {
+		if !done[inst.ID] {
+			pending = append(pending, inst)
+		}
+	}
+	rec.Pending = pending
+	return pending
+}
+
+// queue adds an instruction for host, or for every registered agent when
+// host is "*". It returns the hosts it was queued for.
+func (reg *agentRegistry) queue(host, action, path string, now time.Time) ([]string, error) {
+	hosts := []string{host}
+	if host == "*" {
+		hosts = sortedKeys(reg.Agents)
+	}
+	for _, h := range hosts {
+		rec, ok := reg.Agents[h]
+		if !ok {
+			return nil, fmt.Errorf("unknown agent %q", h)
+		}
+		reg.NextID++
+		rec.Pending = append(rec.Pending, AgentInstruction{ID: reg.NextID, Action: action, Path: path, Queued: now})
+	}
+	return hosts, nil
+}
+
+// addAgentHandlers registers the fleet endpoints on mux:
+//
+//	POST /api/agents/heartbeat                                   register or refresh an agent, returning its instructions
+//	POST /api/agents/instructions?host=<host|*>&action=<a>&path=<p>  queue a wrap or unwrap
+//	GET  /api/agents                                              list the agents with their health
+//
+// Agents run the wraps and unwraps as root, so a collector without a token
+// serves none of them: anyone could queue them for every host. Agents present
+// token; queueing instructions and listing the agents takes adminToken, so a
+// compromised host cannot take over the others.
+func addAgentHandlers(mux *http.ServeMux, storeDir, token, adminToken string) {
+	if token == "" {
+		return
+	}
+	mux.HandleFunc("/api/agents/heartbeat", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r, token) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var hb AgentHeartbeat
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&hb); err != nil || hb.Host == "" {
+			http.Error(w, "invalid heartbeat", http.StatusBadRequest)
+			return
+		}
+		var pending []AgentInstruction
+		err := updateAgents(storeDir, func(reg *agentRegistry) error {
+			pending = reg.heartbeat(hb, time.Now())
+			return nil
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(pending)
+	})
+	mux.HandleFunc("/api/agents/instructions", func(w http.ResponseWriter, r *http.Request) {
+		if adminToken == "" || !authorized(r, adminToken) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		query := r.URL.Query()
+		action, path := query.Get("action"), query.Get("path")
+		if (action != agentWrap && action != agentUnwrap) || !filepath.IsAbs(path) {
+			http.Error(w, "expected action=wrap|unwrap and an absolute path", http.StatusBadRequest)
+			return
+		}
+		var hosts []string
+		err := updateAgents(storeDir, func(reg *agentRegistry) (err error) {
+			hosts, err = reg.queue(query.Get("host"), action, path, time.Now())
+			return err
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		for _, h := range hosts {
+			fmt.Fprintln(w, h)
+		}
+	})
+	mux.HandleFunc("/api/agents", func(w http.ResponseWriter, r *http.Request) {
+		if adminToken == "" || !authorized(r, adminToken) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		agentsMu.Lock()
+		reg, err := readAgents(storeDir)
+		agentsMu.Unlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		agents := []*AgentRecord{}
+		for _, host := range sortedKeys(reg.Agents) {
+			agents = append(agents, reg.Agents[host])
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(agents)
+	})
+}
+
+// AgentOptions configure funkoverage agent.
+type AgentOptions struct {
+	// URL is the base URL of the collector, e.g. http://collector:8081.
+	URL   string
+	Token string
+	// Host names the agent at the collector and prefixes its logs.
+	Host    string
+	Product string
+	LogDir  string
+	// Interval separates two rounds of shipping and heartbeat.
+	Interval time.Duration
+	// Once runs a single round.
+	Once bool
+	// Wrap is used for the binaries the collector asks to wrap.
+	Wrap WrapOptions
+}
+
+// agentState is what an agent keeps across restarts: the size each log had
+// when shipped, so a log is shipped again only if it grew.
+type agentState struct {
+	Shipped map[string]int64 `json:"shipped"`
+}
+
+type fleetAgent struct {
+	opts    AgentOptions
+	client  *http.Client
+	started time.Time
+	state   agentState
+	shipped int
+	lastErr error
+	results []AgentResult
+}
+
+func (a *fleetAgent) statePath() string {
+	return filepath.Join(a.opts.LogDir, agentStateFileName)
+}
+
+func (a *fleetAgent) loadState() {
+	a.state = agentState{}
+	if content, err := os.ReadFile(a.statePath()); err == nil {
+		_ = json.Unmarshal(content, &a.state)
+	}
+	if a.state.Shipped == nil {
+		a.state.Shipped = map[string]int64{}
+	}
+}
+
+func (a *fleetAgent) saveState() error {
+	content, err := json.Marshal(a.state)
+	if err != nil {
+		return err
+	}
+	tmp := a.statePath() + ".tmp"
+	if err := os.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, a.statePath())
+}
+
+// request sends an authenticated request to the collector and returns the
+// response body, failing on anything but a 2xx status.
+func (a *fleetAgent) request(method, path string, query url.Values, body io.Reader, header http.Header) ([]byte, error) {
+	u := strings.TrimSuffix(a.opts.URL, "/") + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if a.opts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.opts.Token)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	content, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(content)))
+	}
+	return content, nil
+}
+
+// pendingLogs returns the finished logs of the log directory not shipped
+// yet, and forgets the shipped logs that were removed since.
+func (a *fleetAgent) pendingLogs() ([]string, error) {
+	entries, err := os.ReadDir(a.opts.LogDir)
+	if err != nil {
+		return nil, err
+	}
+	var live liveLogDetector
+	present := map[string]bool{}
+	var pending []string
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".log") {
+			continue
+		}
+		present[entry.Name()] = true
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(a.opts.LogDir, entry.Name())
+		if size, ok := a.state.Shipped[entry.Name()]; ok && size == info.Size() || live.isLive(path, info) {
+			continue
+		}
+		pending = append(pending, entry.Name())
+	}
+	for name := range a.state.Shipped {
+		if !present[name] {
+			delete(a.state.Shipped, name)
+		}
+	}
+	sort.Strings(pending)
+	return pending, nil
+}
+
+// shipLog uploads one log gzip-compressed.
+func (a *fleetAgent) shipLog(name string) error {
+	f, err := os.Open(filepath.Join(a.opts.LogDir, name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	if _, err := io.Copy(gz, io.LimitReader(f, info.Size())); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	query := url.Values{"name": {name}, "host": {a.opts.Host}}
+	if a.opts.Product != "" {
+		query.Set("product", a.opts.Product)
+	}
+	if _, err := a.request(http.MethodPost, "/api/logs", query, &body, http.Header{"Content-Encoding": {"gzip"}}); err != nil {
+		return err
+	}
+	a.state.Shipped[name] = info.Size()
+	a.shipped++
+	return nil
+}
+
+// shipLogs uploads the pending logs, stopping at the first failure.
+func (a *fleetAgent) shipLogs() error {
+	pending, err := a.pendingLogs()
+	if err != nil {
+		return err
+	}
+	for _, name := range pending {
+		if err = a.shipLog(name); err != nil {
+			err = fmt.Errorf("could not ship %s: %w", name, err)
+			break
+		}
+	}
+	if serr := a.saveState(); err == nil {
+		err = serr
+	}
+	return err
+}
+
+func (a *fleetAgent) health() AgentHealth {
+	h := AgentHealth{Uptime: time.Since(a.started).Round(time.Second), ShippedLogs: a.shipped, Wrapped: []string{}}
+	if m, err := loadManifest(safeBinDir()); err == nil {
+		for _, e := range m.Entries {
+			h.Wrapped = append(h.Wrapped, e.Path)
+		}
+	}
+	if pending, err := a.pendingLogs(); err == nil {
+		h.PendingLogs = len(pending)
+	}
+	h.FreeBytes = freeBytes(a.opts.LogDir)
+	if a.lastErr != nil {
+		h.LastError = a.lastErr.Error()
+	}
+	return h
+}
+
+// heartbeat reports the health and the results of the applied instructions,
+// and returns the instructions still pending.
+func (a *fleetAgent) heartbeat() ([]AgentInstruction, error) {
+	body, err := json.Marshal(AgentHeartbeat{Host: a.opts.Host, Version: versionString, Health: a.health(), Results: a.results})
+	if err != nil {
+		return nil, err
+	}
+	content, err := a.request(http.MethodPost, "/api/agents/heartbeat", nil, bytes.NewReader(body), http.Header{"Content-Type": {"application/json"}})
+	if err != nil {
+		return nil, err
+	}
+	a.results = nil
+	var pending []AgentInstruction
+	if err := json.Unmarshal(content, &pending); err != nil {
+		return nil, fmt.Errorf("invalid heartbeat response: %w", err)
+	}
+	return pending, nil
+}
+
+// apply runs the instructions, keeping their results for the next heartbeat.
+func (a *fleetAgent) apply(instructions []AgentInstruction) {
+	for _, inst := range instructions {
+		var err error
+		switch inst.Action {
+		case agentWrap:
+			err = wrap(inst.Path, a.opts.Wrap)
+		case agentUnwrap:
+			err = unwrap(inst.Path)
+		default:
+			err = fmt.Errorf("unknown action %q", inst.Action)
+		}
+		res := AgentResult{ID: inst.ID, Action: inst.Action, Path: inst.Path, Done: time.Now()}
+		if err != nil {
+			res.Error = err.Error()
+			fmt.Printf("agent: %s %s failed: %v\n", inst.Action, inst.Path, err)
+		} else {
+			fmt.Printf("agent: %s %s\n", inst.Action, inst.Path)
+		}
+		a.results = append(a.results, res)
+	}
+}
+
+// round ships the pending logs, then sends a heartbeat and applies the
+// instructions it returns, reporting their results right away. Without a
+// token the agent only ships logs: it takes no instructions from a collector
+// anyone could queue them at.
+func (a *fleetAgent) round() error {
+	shipErr := a.shipLogs()
+	if shipErr != nil {
+		a.lastErr = shipErr
+	}
+	if a.opts.Token == "" {
+		return shipErr
+	}
+	instructions, err := a.heartbeat()
+	if err != nil {
+		return err
+	}
+	if len(instructions) > 0 {
+		a.apply(instructions)
+		if _, err := a.heartbeat(); err != nil {
+			return err
+		}
+	}
+	if shipErr == nil {
+		a.lastErr = nil
+	}
+	return shipErr
+}
+
+// runAgent ships the logs of opts.LogDir to the collector and applies its
+// instructions every opts.Interval until killed, or once with opts.Once.
+func runAgent(opts AgentOptions) error {
+	if opts.URL == "" {
+		return errors.New("no collector URL given (--url or agent.url in the config file)")
+	}
+	if opts.Host == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+		opts.Host = host
+	}
+	if opts.Interval <= 0 {
+		return errors.New("the interval must be positive")
+	}
+	if err := os.MkdirAll(opts.LogDir, 0755); err != nil {
+		return err
+	}
+	a := &fleetAgent{opts: opts, client: &http.Client{Timeout: 5 * time.Minute}, started: time.Now()}
+	a.loadState()
+	for {
+		err := a.round()
+		if opts.Once {
+			return err
+		}
+		if err != nil {
+			a.lastErr = err
+			fmt.Println("agent:", err)
+		}
+		time.Sleep(opts.Interval)
+	}
+}
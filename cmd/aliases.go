@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// --- Function Aliases ---
+
+// SymbolAlias renames the functions named From, or matching the regular
+// expression of a "re:" From, to To, which may refer to the groups of the
+// expression as $1 or ${name}.
+type SymbolAlias struct {
+	From string
+	To   string
+	re   *regexp.Regexp
+}
+
+// SymbolAliases is a mapping file read with report --aliases. Functions
+// renamed to the same name are merged into one: wrapper symbols into the
+// real function, macro-generated variants into their template.
+type SymbolAliases struct {
+	File  string
+	rules []SymbolAlias
+	// digest identifies the content of the file in log indexes.
+	digest string
+}
+
+// loadSymbolAliases reads a mapping file of "from = to" lines, where blank
+// lines and lines starting with # are ignored. The first rule matching a
+// function applies.
+func loadSymbolAliases(path string) (*SymbolAliases, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(content)
+	aliases := &SymbolAliases{File: path, digest: hex.EncodeToString(sum[:8])}
+	scanner := bufio.NewScanner(strings.NewReader(string(content)))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// The separator needs its spaces: operator= and operator== are
+		// function names.
+		from, to, ok := strings.Cut(line, " = ")
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if !ok || from == "" || to == "" {
+			return nil, fmt.Errorf("%s:%d: expected from = to, got %q", path, n, line)
+		}
+		alias := SymbolAlias{From: from, To: to}
+		if expr, ok := strings.CutPrefix(from, "re:"); ok {
+			if alias.re, err = regexp.Compile("^(?:" + expr + ")$"); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+			}
+		}
+		aliases.rules = append(aliases.rules, alias)
+	}
+	return aliases, scanner.Err()
+}
+
+// rename returns the name of function after the first matching rule, the
+// function itself when none matches.
+func (a *SymbolAliases) rename(function string) string {
+	if a == nil {
+		return function
+	}
+	for _, r := range a.rules {
+		switch {
+		case r.re == nil && r.From == function:
+			return r.To
+		case r.re != nil:
+			if m := r.re.FindStringSubmatchIndex(function); m != nil {
+				return string(r.re.ExpandString(nil, r.To, function, m))
+			}
+		}
+	}
+	return function
+}
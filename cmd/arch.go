@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// --- Image Architectures ---
+
+// archMarker follows the image of the line recording its architecture (format 9).
+var archMarker = []byte("] [Arch:")
+
+// parseArchLine returns the image and architecture of an architecture line.
+func parseArchLine(line []byte) (image []byte, arch string, ok bool) {
+	i := bytes.Index(line, archMarker)
+	if i < 0 {
+		return nil, "", false
+	}
+	start := bytes.Index(line[:i], imageMarker)
+	if start < 0 {
+		return nil, "", false
+	}
+	rest := line[i+len(archMarker):]
+	j := bytes.IndexByte(rest, ']')
+	if j <= 0 {
+		return nil, "", false
+	}
+	return unescapeField(line[start+len(imageMarker) : i]), string(rest[:j]), true
+}
+
+// elfArchName mirrors elf_arch_name of FuncTracer.hpp.
+func elfArchName(machine elf.Machine, class elf.Class, data elf.Data) string {
+	is64, le := class == elf.ELFCLASS64, data == elf.ELFDATA2LSB
+	pick := func(cond bool, a, b string) string {
+		if cond {
+			return a
+		}
+		return b
+	}
+	switch machine {
+	case elf.EM_X86_64:
+		return pick(is64, "x86_64", "x32")
+	case elf.EM_386:
+		return "i386"
+	case elf.EM_AARCH64:
+		return pick(le, "aarch64", "aarch64_be")
+	case elf.EM_ARM:
+		return "arm"
+	case elf.EM_PPC64:
+		return pick(le, "ppc64le", "ppc64")
+	case elf.EM_PPC:
+		return "ppc"
+	case elf.EM_S390:
+		return pick(is64, "s390x", "s390")
+	case elf.EM_RISCV:
+		return pick(is64, "riscv64", "riscv32")
+	}
+	return "machine" + strconv.Itoa(int(machine))
+}
+
+// goArchNames maps GOARCH values to the names of elfArchName.
+var goArchNames = map[string]string{
+	"amd64": "x86_64", "386": "i386", "arm64": "aarch64", "arm": "arm",
+	"ppc64le": "ppc64le", "ppc64": "ppc64", "s390x": "s390x", "riscv64": "riscv64",
+}
+
+// hostArch is the architecture of the host generating the reports.
+func hostArch() string {
+	if name, ok := goArchNames[runtime.GOARCH]; ok {
+		return name
+	}
+	return runtime.GOARCH
+}
+
+// archImageKey is the name an image is merged and reported under. Images of
+// the host architecture keep their path; others get the architecture
+// appended, so same-named binaries of different architectures stay apart.
+func archImageKey(image, arch string) string {
+	if arch == "" || arch == hostArch() {
+		return image
+	}
+	return image + " [" + arch + "]"
+}
+
+// splitArchImage splits a name made by archImageKey into the image path and
+// the architecture, empty for the host architecture.
+func splitArchImage(key string) (image, arch string) {
+	if i := strings.LastIndex(key, " ["); i >= 0 && strings.HasSuffix(key, "]") {
+		return key[:i], key[i+2 : len(key)-1]
+	}
+	return key, ""
+}
+
+var (
+	imageArchCacheMu sync.Mutex
+	imageArchCache   = map[string]string{}
+)
+
+// resolveImageArch reads the architecture of an image from its ELF header,
+// or from its backup in SAFE_BIN_DIR once its path holds the wrapper script.
+// It returns "" when neither can be read.
+func resolveImageArch(image string) string {
+	imageArchCacheMu.Lock()
+	defer imageArchCacheMu.Unlock()
+	if arch, ok := imageArchCache[image]; ok {
+		return arch
+	}
+	paths := []string{image}
+	if m, err := loadManifest(safeBinDir()); err == nil {
+		for _, e := range m.Entries {
+			if e.Path == image {
+				paths = append(paths, e.Backup)
+			}
+		}
+	}
+	arch := ""
+	for _, path := range paths {
+		if f, err := elf.Open(path); err == nil {
+			arch = elfArchName(f.Machine, f.Class, f.Data)
+			f.Close()
+			break
+		}
+	}
+	imageArchCache[image] = arch
+	return arch
+}
+
+// imageArchs maps the reported images to their architecture: the one their
+// name carries, else the one recorded in the logs (format 9), else the one
+// resolved from the binary. Images whose architecture is unknown are left out.
+func imageArchs(coverage map[string]*CoverageData, stats []LogStats) map[string]string {
+	recorded := make(map[string]string)
+	for _, s := range stats {
+		for image, arch := range s.Archs {
+			if archImageKey(image, arch) == image {
+				recorded[image] = arch
+			}
+		}
+	}
+	archs := make(map[string]string)
+	for key := range coverage {
+		image, arch := splitArchImage(key)
+		if arch == "" {
+			arch = recorded[image]
+		}
+		if arch == "" {
+			arch = resolveImageArch(image)
+		}
+		if arch != "" {
+			archs[key] = arch
+		}
+	}
+	return archs
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// --- Baseline Comparison ---
+
+// coverageStateVersion is bumped whenever the state layout changes.
+const coverageStateVersion = 1
+
+// CoverageState is the per-function coverage of a report run, saved with
+// --save-state and compared against with --baseline.
+type CoverageState struct {
+	Version     int                    `json:"version"`
+	GeneratedAt time.Time              `json:"generated_at"`
+	Images      map[string]*ImageIndex `json:"images"`
+}
+
+// Changes of an image or function versus the baseline.
+const (
+	changeNew       = "new"       // image absent from the baseline, or function newly called
+	changeRegressed = "regressed" // lost coverage
+	changeImproved  = "improved"  // gained coverage without losing any
+	changeUnchanged = "unchanged"
+)
+
+// saveCoverageState writes the called and defined functions of every image.
+func saveCoverageState(path string, coverage map[string]*CoverageData, generatedAt time.Time) error {
+	state := CoverageState{Version: coverageStateVersion, GeneratedAt: generatedAt, Images: make(map[string]*ImageIndex, len(coverage))}
+	for image, data := range coverage {
+		state.Images[image] = &ImageIndex{
+			TotalCount:  len(data.TotalFunctions),
+			CalledCount: len(data.CalledFunctions),
+			Functions:   sortedKeys(data.TotalFunctions),
+			Called:      sortedKeys(data.CalledFunctions),
+		}
+	}
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(content, '\n'), 0644)
+}
+
+// loadCoverageState reads a state saved by a previous run.
+func loadCoverageState(path string) (*CoverageState, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var state CoverageState
+	if err := json.Unmarshal(content, &state); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+	if state.Version != coverageStateVersion {
+		return nil, fmt.Errorf("%s has state version %d, expected %d", path, state.Version, coverageStateVersion)
+	}
+	return &state, nil
+}
+
+// ImageComparison sums up how an image changed since the baseline.
+type ImageComparison struct {
+	Change      string
+	BaselinePct float64
+	Delta       float64 // coverage points gained (or lost, when negative)
+	NewlyCalled int
+	Regressed   int
+	// RegressedFunctions were called in the baseline and no longer are.
+	RegressedFunctions []string
+	// called is the set of functions the baseline called.
+	called map[string]struct{}
+}
+
+// compareImage compares the coverage of image with the baseline. It returns
+// nil without a baseline.
+func (s *CoverageState) compareImage(image string, data *CoverageData) *ImageComparison {
+	if s == nil {
+		return nil
+	}
+	c := &ImageComparison{Change: changeNew, called: map[string]struct{}{}}
+	base, ok := s.Images[image]
+	if !ok {
+		return c
+	}
+	for _, fn := range base.Called {
+		c.called[fn] = struct{}{}
+	}
+	if base.TotalCount > 0 {
+		c.BaselinePct = float64(base.CalledCount) / float64(base.TotalCount) * 100
+	}
+	if total := len(data.TotalFunctions); total > 0 {
+		c.Delta = float64(len(data.CalledFunctions))/float64(total)*100 - c.BaselinePct
+	} else {
+		c.Delta = -c.BaselinePct
+	}
+	for fn := range data.CalledFunctions {
+		if _, ok := c.called[fn]; !ok {
+			c.NewlyCalled++
+		}
+	}
+	for _, fn := range base.Called {
+		if _, ok := data.CalledFunctions[fn]; !ok {
+			c.Regressed++
+			c.RegressedFunctions = append(c.RegressedFunctions, fn)
+		}
+	}
+	switch {
+	case c.Regressed > 0:
+		c.Change = changeRegressed
+	case c.NewlyCalled > 0:
+		c.Change = changeImproved
+	default:
+		c.Change = changeUnchanged
+	}
+	return c
+}
+
+// functionChange returns the change of a function versus the baseline: newly
+// called, no longer called or unchanged.
+func (c *ImageComparison) functionChange(fn string, called bool) string {
+	if c == nil {
+		return ""
+	}
+	_, before := c.called[fn]
+	switch {
+	case called && !before:
+		return changeNew
+	case before && !called:
+		return changeRegressed
+	}
+	return changeUnchanged
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// --- Call Graph ---
+
+// Edge is a call from one function to another, recorded by FuncTracer -edges.
+// Both ends are plain function names: the callee may live in another image.
+type Edge struct {
+	Caller string `json:"caller"`
+	Callee string `json:"callee"`
+}
+
+// addEdge adds n calls along e.
+func (d *CoverageData) addEdge(e Edge, n uint64) {
+	if n == 0 {
+		return
+	}
+	if d.Edges == nil {
+		d.Edges = make(map[Edge]uint64)
+	}
+	d.Edges[e] += n
+}
+
+// Edge lines look like "[Image:/bin/ls] [Count:3] [Caller:main_loop] [Callee:parse]".
+var (
+	callerMarker = []byte("] [Caller:")
+	calleeMarker = []byte("] [Callee:")
+)
+
+// parseEdgeLine extracts the image, caller, callee and call count of an edge
+// line without allocating. The returned slices alias line.
+func parseEdgeLine(line []byte) (image, caller, callee []byte, count uint64, ok bool) {
+	i := bytes.Index(line, imageMarker)
+	if i < 0 {
+		return nil, nil, nil, 0, false
+	}
+	rest := line[i+len(imageMarker):]
+	j := bytes.Index(rest, callerMarker)
+	if j < 0 {
+		return nil, nil, nil, 0, false
+	}
+	image, rest = rest[:j], rest[j+len(callerMarker):]
+	if c := bytes.Index(image, countMarker); c >= 0 {
+		image, count = image[:c], parseCount(image[c+len(countMarker):])
+	}
+	k := bytes.Index(rest, calleeMarker)
+	if k < 0 {
+		return nil, nil, nil, 0, false
+	}
+	caller, rest = rest[:k], rest[k+len(calleeMarker):]
+	end := bytes.LastIndexByte(rest, ']')
+	if end < 0 {
+		return nil, nil, nil, 0, false
+	}
+	return unescapeField(bytes.TrimSpace(image)), unescapeField(bytes.TrimSpace(caller)), unescapeField(bytes.TrimSpace(rest[:end])), count, true
+}
+
+// sortedEdges returns the edges of data with at least minCalls calls, ordered
+// by caller and callee.
+func sortedEdges(data *CoverageData, minCalls uint64) []Edge {
+	edges := make([]Edge, 0, len(data.Edges))
+	for e, n := range data.Edges {
+		if n >= minCalls {
+			edges = append(edges, e)
+		}
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].Caller != edges[j].Caller {
+			return edges[i].Caller < edges[j].Caller
+		}
+		return edges[i].Callee < edges[j].Callee
+	})
+	return edges
+}
+
+func dotReportFileName(image string) string {
+	return fmt.Sprintf("callgraph_%s.dot", safeImageName(image))
+}
+
+// generateDOTReport writes the call graph of an image in Graphviz format,
+// leaving out the edges taken fewer than minCalls times. Callees defined in
+// this image are drawn as called (green) or uncalled (red) functions.
+func generateDOTReport(image string, data *CoverageData, minCalls uint64, outputDir string, generatedAt time.Time) error {
+	f, err := os.Create(filepath.Join(outputDir, dotReportFileName(image)))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	edges := sortedEdges(data, minCalls)
+	nodes := make(map[string]struct{}, 2*len(edges))
+	for _, e := range edges {
+		nodes[e.Caller] = struct{}{}
+		nodes[e.Callee] = struct{}{}
+	}
+	fmt.Fprintf(w, "// Call graph of %s, generated %s\n", image, generatedAt.Format(reportTimeLayout))
+	fmt.Fprintf(w, "digraph %s {\n", strconv.Quote(filepath.Base(image)))
+	fmt.Fprintln(w, "  node [shape=box, style=filled, fillcolor=white];")
+	for _, fn := range sortedKeys(nodes) {
+		color := "white" // defined in another image
+		if _, ok := data.CalledFunctions[fn]; ok {
+			color = "palegreen"
+		} else if _, ok := data.TotalFunctions[fn]; ok {
+			color = "lightpink"
+		}
+		fmt.Fprintf(w, "  %s [fillcolor=%s];\n", strconv.Quote(fn), color)
+	}
+	for _, e := range edges {
+		fmt.Fprintf(w, "  %s -> %s [label=\"%d\"];\n", strconv.Quote(e.Caller), strconv.Quote(e.Callee), data.Edges[e])
+	}
+	fmt.Fprintln(w, "}")
+	return w.Flush()
+}
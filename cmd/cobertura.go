@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// --- Cobertura XML Report ---
+
+// The Cobertura report of an image lays out its functions the way coverage
+// viewers (GitLab, Jenkins, Azure DevOps) display a tree: C++ namespaces are
+// the packages and the innermost scope of each function, its class or
+// namespace, the class; unscoped and C functions are in the (global) class.
+// Each function counts as one line, covered when it was called, at its
+// definition when the debug info locates it.
+
+func coberturaReportFileName(image string) string {
+	return fmt.Sprintf("cobertura_%s.xml", safeImageName(image))
+}
+
+type CoberturaCoverage struct {
+	XMLName         xml.Name           `xml:"coverage"`
+	LineRate        string             `xml:"line-rate,attr"`
+	BranchRate      string             `xml:"branch-rate,attr"`
+	LinesCovered    int                `xml:"lines-covered,attr"`
+	LinesValid      int                `xml:"lines-valid,attr"`
+	BranchesCovered int                `xml:"branches-covered,attr"`
+	BranchesValid   int                `xml:"branches-valid,attr"`
+	Complexity      string             `xml:"complexity,attr"`
+	Version         string             `xml:"version,attr"`
+	Timestamp       int64              `xml:"timestamp,attr"`
+	Sources         []string           `xml:"sources>source"`
+	Packages        []CoberturaPackage `xml:"packages>package"`
+}
+
+type CoberturaPackage struct {
+	Name       string           `xml:"name,attr"`
+	LineRate   string           `xml:"line-rate,attr"`
+	BranchRate string           `xml:"branch-rate,attr"`
+	Complexity string           `xml:"complexity,attr"`
+	Classes    []CoberturaClass `xml:"classes>class"`
+}
+
+type CoberturaClass struct {
+	Name       string            `xml:"name,attr"`
+	Filename   string            `xml:"filename,attr"`
+	LineRate   string            `xml:"line-rate,attr"`
+	BranchRate string            `xml:"branch-rate,attr"`
+	Complexity string            `xml:"complexity,attr"`
+	Methods    []CoberturaMethod `xml:"methods>method"`
+	Lines      []CoberturaLine   `xml:"lines>line"`
+}
+
+type CoberturaMethod struct {
+	Name       string          `xml:"name,attr"`
+	Signature  string          `xml:"signature,attr"`
+	LineRate   string          `xml:"line-rate,attr"`
+	BranchRate string          `xml:"branch-rate,attr"`
+	Lines      []CoberturaLine `xml:"lines>line"`
+}
+
+type CoberturaLine struct {
+	Number int    `xml:"number,attr"`
+	Hits   uint64 `xml:"hits,attr"`
+}
+
+// coberturaRate formats the share of covered lines as Cobertura rates are.
+func coberturaRate(covered, valid int) string {
+	if valid == 0 {
+		return "0"
+	}
+	return fmt.Sprintf("%.4g", float64(covered)/float64(valid))
+}
+
+// coberturaScope returns the package and class of a function: its
+// namespaces, and its innermost scope, joined with dots.
+func coberturaScope(fn string) (pkg, class, leaf string) {
+	scope, leaf := splitScope(fn)
+	if len(scope) == 0 {
+		return globalNamespace, globalNamespace, leaf
+	}
+	pkg = globalNamespace
+	if len(scope) > 1 {
+		pkg = strings.Join(scope[:len(scope)-1], ".")
+	}
+	return pkg, strings.Join(scope, "."), leaf
+}
+
+// generateCoberturaReport writes the Cobertura report of an image.
+func generateCoberturaReport(image string, data *CoverageData, outputDir string, generatedAt time.Time) error {
+	// Without debug info, the functions are not located in their sources.
+	locations, _ := functionLocations(image)
+	type classKey struct{ pkg, class string }
+	classes := map[classKey]*CoberturaClass{}
+	var keys []classKey
+	covered := map[classKey]int{}
+	for _, fn := range sortedKeys(data.TotalFunctions) {
+		pkg, name, leaf := coberturaScope(fn)
+		key := classKey{pkg, name}
+		class, ok := classes[key]
+		if !ok {
+			class = &CoberturaClass{Name: name, Filename: filepath.Base(image), BranchRate: "0", Complexity: "0"}
+			classes[key] = class
+			keys = append(keys, key)
+		}
+		method := CoberturaMethod{Name: leaf, LineRate: "0", BranchRate: "0"}
+		hits := uint64(0)
+		if _, called := data.CalledFunctions[fn]; called {
+			covered[key]++
+			method.LineRate = "1"
+			hits = max(data.Calls[fn], 1)
+		}
+		if loc, ok := locations[fn]; ok && loc.Line > 0 {
+			// The class is in the file of its first located function.
+			if len(class.Lines) == 0 {
+				class.Filename = loc.File
+			}
+			line := CoberturaLine{Number: loc.Line, Hits: hits}
+			method.Lines = []CoberturaLine{line}
+			class.Lines = append(class.Lines, line)
+		}
+		class.Methods = append(class.Methods, method)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].pkg != keys[j].pkg {
+			return keys[i].pkg < keys[j].pkg
+		}
+		return keys[i].class < keys[j].class
+	})
+
+	var packages []CoberturaPackage
+	pkgTotal, pkgCovered := 0, 0
+	finish := func() {
+		if n := len(packages); n > 0 {
+			packages[n-1].LineRate = coberturaRate(pkgCovered, pkgTotal)
+		}
+		pkgTotal, pkgCovered = 0, 0
+	}
+	for _, key := range keys {
+		if n := len(packages); n == 0 || packages[n-1].Name != key.pkg {
+			finish()
+			packages = append(packages, CoberturaPackage{Name: key.pkg, BranchRate: "0", Complexity: "0"})
+		}
+		class := classes[key]
+		class.LineRate = coberturaRate(covered[key], len(class.Methods))
+		pkgTotal += len(class.Methods)
+		pkgCovered += covered[key]
+		p := &packages[len(packages)-1]
+		p.Classes = append(p.Classes, *class)
+	}
+	finish()
+
+	total, called := len(data.TotalFunctions), 0
+	for _, n := range covered {
+		called += n
+	}
+	report := CoberturaCoverage{
+		LineRate:     coberturaRate(called, total),
+		BranchRate:   "0",
+		LinesCovered: called,
+		LinesValid:   total,
+		Complexity:   "0",
+		Version:      "funkoverage",
+		Timestamp:    generatedAt.Unix(),
+		Sources:      []string{image},
+		Packages:     packages,
+	}
+	f, err := os.Create(filepath.Join(outputDir, coberturaReportFileName(image)))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	w.WriteString(xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	w.WriteString("\n")
+	return w.Flush()
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// --- Remote Log Collection ---
+
+// stagingDirName holds one rsync mirror per host inside the destination, so
+// interrupted transfers resume instead of starting over.
+const stagingDirName = ".hosts"
+
+// readHostsFile returns the hosts listed one per line, skipping blank lines and # comments.
+func readHostsFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var hosts []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hosts = append(hosts, line)
+	}
+	return hosts, scanner.Err()
+}
+
+// hostTag turns a [user@]host entry into a filename-safe tag.
+func hostTag(host string) string {
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		host = host[i+1:]
+	}
+	return unsafeNameRe.ReplaceAllString(host, "_")
+}
+
+// tagHostLogs exposes every log mirrored from a host in dest as <host>_<name>.log.
+// Files already present are left alone, so repeated collections are cheap.
+func tagHostLogs(stagingDir, dest, tag string) (int, error) {
+	entries, err := os.ReadDir(stagingDir)
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".log") {
+			continue
+		}
+		target := filepath.Join(dest, tag+"_"+entry.Name())
+		if _, err := os.Lstat(target); err == nil {
+			continue
+		}
+		source := filepath.Join(stagingDir, entry.Name())
+		if err := os.Link(source, target); err != nil {
+			if err := copyFile(source, target); err != nil {
+				return added, err
+			}
+		}
+		added++
+	}
+	return added, nil
+}
+
+// copyFile copies source to destination, preserving the permissions.
+func copyFile(source, destination string) error {
+	content, err := os.ReadFile(source)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(destination, content, fi.Mode().Perm())
+}
+
+// collectHost mirrors remoteDir of a single host into dest with rsync over SSH.
+func collectHost(host, remoteDir, dest string) (int, error) {
+	tag := hostTag(host)
+	stagingDir := filepath.Join(dest, stagingDirName, tag)
+	if err := os.MkdirAll(stagingDir, 0755); err != nil {
+		return 0, err
+	}
+	cmd := exec.Command("rsync", "-az", "--partial", "--include=*.log", "--exclude=*",
+		"-e", "ssh -o BatchMode=yes", host+":"+strings.TrimSuffix(remoteDir, "/")+"/", stagingDir+"/")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return 0, fmt.Errorf("rsync failed: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return tagHostLogs(stagingDir, dest, tag)
+}
+
+// collectLogs fetches the logs of all hosts, running up to jobs transfers in parallel.
+func collectLogs(hosts []string, remoteDir, dest string, jobs int) error {
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+	if jobs < 1 {
+		jobs = 1
+	}
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed []string
+	)
+	sem := make(chan struct{}, jobs)
+	for _, host := range hosts {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(host string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			added, err := collectHost(host, remoteDir, dest)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "collect error for %s: %v\n", host, err)
+				failed = append(failed, host)
+				return
+			}
+			fmt.Printf("Collected %d new log(s) from %s\n", added, host)
+		}(host)
+	}
+	wg.Wait()
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to collect from: %v", failed)
+	}
+	return nil
+}
+
+// collect reads the hosts file and fetches their logs into dest.
+func collect(hostsFile, remoteDir, dest string, jobs int) error {
+	hosts, err := readHostsFile(hostsFile)
+	if err != nil {
+		return fmt.Errorf("could not read hosts file: %w", err)
+	}
+	if len(hosts) == 0 {
+		return errors.New("no hosts listed in " + hostsFile)
+	}
+	return collectLogs(hosts, remoteDir, dest, jobs)
+}
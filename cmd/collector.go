@@ -0,0 +1,185 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// --- Log Ingestion API ---
+
+// maxUploadSize bounds a single uploaded log (after decompression); a
+// variable for the tests.
+var maxUploadSize int64 = 1 << 30
+
+// CollectorConfig configures the log ingestion server.
+type CollectorConfig struct {
+	// Token is the bearer token clients must present. Empty disables authentication.
+	Token string `json:"token"`
+	// AdminToken is the bearer token of the operators queueing instructions
+	// for the fleet agents, which every agent holding Token must not be able
+	// to. Empty disables the queueing.
+	AdminToken string `json:"admin_token"`
+}
+
+var unsafeNameRe = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
+
+// uploadFileName builds the name under which an uploaded log is stored,
+// prefixing the reporting host so logs from different machines never clash.
+func uploadFileName(host, name string) string {
+	name = unsafeNameRe.ReplaceAllString(filepath.Base(name), "_")
+	if name == "" || name == "." || name == ".." {
+		name = "upload"
+	}
+	if !strings.HasSuffix(name, ".log") {
+		name += ".log"
+	}
+	if host != "" {
+		name = unsafeNameRe.ReplaceAllString(host, "_") + "_" + name
+	}
+	return name
+}
+
+// authorized reports whether r carries "Authorization: Bearer <token>". An
+// empty token authorizes every request.
+func authorized(r *http.Request, token string) bool {
+	if token == "" {
+		return true
+	}
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// storeUpload writes body to storeDir/name without overwriting existing logs.
+// A body failing midway, such as one beyond maxUploadSize, leaves no file.
+func storeUpload(storeDir, name string, body io.Reader) (string, error) {
+	base := strings.TrimSuffix(name, ".log")
+	for i := 0; ; i++ {
+		candidate := name
+		if i > 0 {
+			candidate = fmt.Sprintf("%s.%d.log", base, i)
+		}
+		path := filepath.Join(storeDir, candidate)
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(f, body)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(path)
+			return "", err
+		}
+		return path, nil
+	}
+}
+
+// newCollectorMux builds the ingestion handlers:
+//
+//	POST /api/logs?name=<file>&host=<host>&product=<p>&run=<r>   store the request body as a log
+//	GET  /api/logs                                                list the stored logs
+//	GET  /dashboard, /api/dashboard                               coverage merged per host and run
+//	/api/agents...                                                fleet agents (see addAgentHandlers)
+func newCollectorMux(storeDir string, cfg CollectorConfig) *http.ServeMux {
+	mux := http.NewServeMux()
+	cache := newLogCache(AnalyzeOptions{})
+	mux.HandleFunc("/api/logs", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r, cfg.Token) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.Method {
+		case http.MethodPost:
+			body := r.Body
+			if r.Header.Get("Content-Encoding") == "gzip" {
+				gz, err := gzip.NewReader(r.Body)
+				if err != nil {
+					http.Error(w, "invalid gzip body: "+err.Error(), http.StatusBadRequest)
+					return
+				}
+				defer gz.Close()
+				body = gz
+			}
+			query := r.URL.Query()
+			name := uploadFileName(query.Get("host"), query.Get("name"))
+			path, err := storeUpload(storeDir, name, http.MaxBytesReader(w, body, maxUploadSize))
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, fmt.Sprintf("log larger than %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			rec := UploadRecord{
+				File:     filepath.Base(path),
+				Host:     query.Get("host"),
+				Product:  query.Get("product"),
+				Run:      query.Get("run"),
+				Received: time.Now(),
+			}
+			if err := appendUploadRecord(storeDir, rec); err != nil {
+				fmt.Println("collector: could not index upload:", err)
+			}
+			// Analyze the log once now, for the dashboard to merge its coverage.
+			if _, _, err := cache.load(path); err != nil {
+				fmt.Println("collector: could not analyze upload:", err)
+			}
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintln(w, filepath.Base(path))
+		case http.MethodGet:
+			entries, err := os.ReadDir(storeDir)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			names := []string{}
+			for _, entry := range entries {
+				if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".log") {
+					names = append(names, entry.Name())
+				}
+			}
+			sort.Strings(names)
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(names)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	addDashboardHandlers(mux, storeDir, cfg.Token, cache)
+	addAgentHandlers(mux, storeDir, cfg.Token, cfg.AdminToken)
+	return mux
+}
+
+// collector runs the ingestion server, storing uploaded logs into storeDir.
+func collector(storeDir, addr string, cfg CollectorConfig) error {
+	if err := os.MkdirAll(storeDir, 0755); err != nil {
+		return err
+	}
+	if cfg.AdminToken != "" && cfg.AdminToken == cfg.Token {
+		return errors.New("collector.admin_token must differ from collector.token, which every agent holds")
+	}
+	if cfg.Token == "" {
+		fmt.Println("Warning: no collector token configured, uploads are not authenticated and fleet agents are not served")
+	} else if cfg.AdminToken == "" {
+		fmt.Println("Warning: no collector admin token configured, no instructions can be queued for the fleet agents")
+	}
+	fmt.Printf("Collecting logs into %s on %s\n", storeDir, addr)
+	return http.ListenAndServe(addr, newCollectorMux(storeDir, cfg))
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// --- Command Hooks ---
+
+// HooksConfig lists the scripts run before and after wrap, unwrap and report,
+// for sites to snapshot configurations, notify a CMDB or restart services
+// around instrumentation changes. A failing pre hook cancels the command; a
+// failing post hook fails it.
+type HooksConfig struct {
+	PreWrap    []string `json:"pre_wrap"`
+	PostWrap   []string `json:"post_wrap"`
+	PreUnwrap  []string `json:"pre_unwrap"`
+	PostUnwrap []string `json:"post_unwrap"`
+	PreReport  []string `json:"pre_report"`
+	PostReport []string `json:"post_report"`
+}
+
+// HookContext is the JSON a hook reads on its stdin. Its fields are also in
+// the environment of the hook, as FUNKOVERAGE_HOOK, FUNKOVERAGE_COMMAND,
+// FUNKOVERAGE_BINARIES (one per line), FUNKOVERAGE_OUTPUT_DIR,
+// FUNKOVERAGE_STATUS and FUNKOVERAGE_ERROR.
+type HookContext struct {
+	// Hook is "pre-wrap", "post-wrap", "pre-unwrap"...
+	Hook    string `json:"hook"`
+	Command string `json:"command"`
+	// Binaries are the binaries wrap and unwrap work on.
+	Binaries []string `json:"binaries,omitempty"`
+	// Inputs, OutputDir and Formats are the arguments of report.
+	Inputs    []string `json:"inputs,omitempty"`
+	OutputDir string   `json:"output_dir,omitempty"`
+	Formats   []string `json:"formats,omitempty"`
+	// Status is "ok" or "failed" in post hooks, where Error tells why.
+	Status string `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// scripts returns the pre and post hooks of a command.
+func (c HooksConfig) scripts(command string) (pre, post []string) {
+	switch command {
+	case "wrap":
+		return c.PreWrap, c.PostWrap
+	case "unwrap":
+		return c.PreUnwrap, c.PostUnwrap
+	case "report":
+		return c.PreReport, c.PostReport
+	}
+	return nil, nil
+}
+
+// around runs command between its pre and post hooks. The post hooks run
+// whether or not the command succeeded.
+func (c HooksConfig) around(command string, ctx HookContext, run func() error) error {
+	pre, post := c.scripts(command)
+	ctx.Command, ctx.Hook = command, "pre-"+command
+	if err := runHooks(pre, ctx); err != nil {
+		return err
+	}
+	err := run()
+	ctx.Hook, ctx.Status = "post-"+command, "ok"
+	if err != nil {
+		ctx.Status, ctx.Error = "failed", err.Error()
+	}
+	if hookErr := runHooks(post, ctx); hookErr != nil {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, hookErr)
+			return err
+		}
+		return hookErr
+	}
+	return err
+}
+
+// runHooks runs the scripts in order, stopping at the first that fails.
+func runHooks(scripts []string, ctx HookContext) error {
+	if len(scripts) == 0 {
+		return nil
+	}
+	input, err := json.Marshal(ctx)
+	if err != nil {
+		return err
+	}
+	env := append(os.Environ(),
+		"FUNKOVERAGE_HOOK="+ctx.Hook,
+		"FUNKOVERAGE_COMMAND="+ctx.Command,
+		"FUNKOVERAGE_BINARIES="+strings.Join(ctx.Binaries, "\n"),
+		"FUNKOVERAGE_OUTPUT_DIR="+ctx.OutputDir,
+		"FUNKOVERAGE_STATUS="+ctx.Status,
+		"FUNKOVERAGE_ERROR="+ctx.Error,
+	)
+	for _, script := range scripts {
+		cmd := exec.Command(script)
+		cmd.Stdin = bytes.NewReader(input)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		cmd.Env = env
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s hook %s: %w", ctx.Hook, script, err)
+		}
+	}
+	return nil
+}
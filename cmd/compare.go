@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// --- Saved Report Comparison ---
+
+// SavedReport is the per-image coverage of an earlier report run read back
+// from its artifacts, compared with --compare when its logs are long gone.
+type SavedReport struct {
+	File string
+	// Images are keyed by image path, or by safeImageName when read from
+	// XUnit files, which keep no more of the name.
+	Images          map[string]CoverageSummary
+	AverageCoverage float64
+	safeNames       bool
+}
+
+// ImageDelta is the coverage of an image versus a saved report.
+type ImageDelta struct {
+	// Saved is false when the image is not in the saved report.
+	Saved       bool
+	PreviousPct float64
+	Delta       float64 // coverage points gained (or lost, when negative)
+}
+
+// loadSavedReport reads the summary.json or the XUnit files of an earlier
+// report: path is one of those files, or the output directory of the run.
+func loadSavedReport(path string) (*SavedReport, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{path}
+	if info.IsDir() {
+		if summary := filepath.Join(path, summaryFileName); fileExists(summary) {
+			files = []string{summary}
+		} else if files, _ = filepath.Glob(filepath.Join(path, "coverage_*.xml*")); len(files) == 0 {
+			return nil, fmt.Errorf("no %s nor XUnit report in %s", summaryFileName, path)
+		}
+	}
+	if strings.HasSuffix(files[0], ".json") {
+		totals, err := loadCoverageTotals(files[0])
+		if err != nil {
+			return nil, err
+		}
+		report := &SavedReport{File: path, Images: make(map[string]CoverageSummary, len(totals.Rows)), AverageCoverage: totals.AverageCoverage}
+		for _, row := range totals.Rows {
+			report.Images[row.ImageName] = row
+		}
+		return report, nil
+	}
+	report := &SavedReport{File: path, Images: map[string]CoverageSummary{}, safeNames: true}
+	var total, called int
+	for _, file := range files {
+		rows, err := readXUnitCoverage(file)
+		if err != nil {
+			return nil, err
+		}
+		// The parts of a split report add up.
+		for _, row := range rows {
+			total += row.TotalCount
+			called += row.CalledCount
+			if part, ok := report.Images[row.ImageName]; ok {
+				row.TotalCount += part.TotalCount
+				row.CalledCount += part.CalledCount
+				row.CoveragePct = float64(row.CalledCount) / float64(row.TotalCount) * 100
+			}
+			report.Images[row.ImageName] = row
+		}
+	}
+	if total > 0 {
+		report.AverageCoverage = float64(called) / float64(total) * 100
+	}
+	return report, nil
+}
+
+// readXUnitCoverage reads the coverage of the images of an XUnit report,
+// gzipped or not: each test suite is an image, its skipped tests the uncalled
+// functions, or its failures with --xml-uncalled-as failure.
+func readXUnitCoverage(path string) ([]CoverageSummary, error) {
+	f, err := openLog(path)
+	if err != nil {
+		return nil, err
+	}
+	content, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	var suites TestSuites
+	if err := xml.Unmarshal(content, &suites); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+	var rows []CoverageSummary
+	for _, suite := range suites.TestSuite {
+		name, ok := strings.CutPrefix(suite.Name, "binary_coverage_")
+		if !ok {
+			continue
+		}
+		row := CoverageSummary{ImageName: name, TotalCount: suite.Tests, CalledCount: suite.Tests - suite.Skipped - suite.Failures}
+		if row.TotalCount > 0 {
+			row.CoveragePct = float64(row.CalledCount) / float64(row.TotalCount) * 100
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) == 0 {
+		return nil, errors.New(path + " is not a funkoverage XUnit report")
+	}
+	return rows, nil
+}
+
+// compareImage returns the coverage of image versus the saved report, nil
+// without one.
+func (r *SavedReport) compareImage(image string, pct float64) *ImageDelta {
+	if r == nil {
+		return nil
+	}
+	key := image
+	if r.safeNames {
+		key = safeImageName(image)
+	}
+	saved, ok := r.Images[key]
+	if !ok {
+		return &ImageDelta{}
+	}
+	return &ImageDelta{Saved: true, PreviousPct: saved.CoveragePct, Delta: pct - saved.CoveragePct}
+}
+
+// String names the saved report in the reports.
+func (r *SavedReport) String() string {
+	if r == nil {
+		return ""
+	}
+	return r.File
+}
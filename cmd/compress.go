@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// --- Compressed Reports ---
+
+// report --compress replaces the large HTML, XML and JSON files of the output
+// directory by gzipped ones, which web servers serve as they are (nginx
+// gzip_static always, Apache MultiViews) to browsers asking for the
+// uncompressed name: the report of a whole distribution is tens of GB
+// otherwise.
+
+const (
+	compressedIndexFileName = "compressed-index.json"
+	// compressMinSize is the size below which files are left as they are:
+	// they would gain little, and tools read summary-like files directly.
+	compressMinSize = 16 << 10
+)
+
+// compressedExts are the extensions of the files report --compress gzips.
+var compressedExts = []string{".html", ".xml", ".json"}
+
+// CompressedFile is a file of the output directory replaced by its gzipped
+// copy, with both sizes.
+type CompressedFile struct {
+	File           string `json:"file"`
+	Compressed     string `json:"compressed"`
+	Size           int64  `json:"size"`
+	CompressedSize int64  `json:"compressed_size"`
+}
+
+// CompressedIndex is the compressed-index.json of a compressed output directory.
+type CompressedIndex struct {
+	Size           int64            `json:"size"`
+	CompressedSize int64            `json:"compressed_size"`
+	Files          []CompressedFile `json:"files"`
+}
+
+// compressOutputDir gzips the HTML, XML and JSON files of outputDir of at
+// least compressMinSize bytes, but for the summary and indexes tools read,
+// and lists them in compressed-index.json. It returns the compressed files.
+func compressOutputDir(outputDir string) ([]CompressedFile, error) {
+	entries, err := os.ReadDir(outputDir)
+	if err != nil {
+		return nil, err
+	}
+	index := CompressedIndex{Files: []CompressedFile{}}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || !slices.Contains(compressedExts, filepath.Ext(name)) ||
+			slices.Contains([]string{summaryFileName, xunitIndexFileName, compressedIndexFileName}, name) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.Size() < compressMinSize {
+			continue
+		}
+		file, err := gzipFile(filepath.Join(outputDir, name), info)
+		if err != nil {
+			return nil, err
+		}
+		index.Files = append(index.Files, file)
+		index.Size += file.Size
+		index.CompressedSize += file.CompressedSize
+	}
+	content, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(outputDir, compressedIndexFileName), append(content, '\n'), 0644); err != nil {
+		return nil, err
+	}
+	return index.Files, nil
+}
+
+// gzipFile replaces path by path.gz, keeping its modification time for the
+// Last-Modified header of the servers.
+func gzipFile(path string, info os.FileInfo) (CompressedFile, error) {
+	file := CompressedFile{File: filepath.Base(path), Compressed: filepath.Base(path) + ".gz", Size: info.Size()}
+	in, err := os.Open(path)
+	if err != nil {
+		return file, err
+	}
+	defer in.Close()
+	staged := path + ".gz.tmp"
+	out, err := os.Create(staged)
+	if err != nil {
+		return file, err
+	}
+	w := bufio.NewWriter(out)
+	gz := gzip.NewWriter(w)
+	gz.Name = file.File
+	gz.ModTime = info.ModTime()
+	_, err = io.Copy(gz, in)
+	err = errors.Join(err, gz.Close(), w.Flush(), out.Close())
+	if err == nil {
+		err = os.Chtimes(staged, info.ModTime(), info.ModTime())
+	}
+	if err == nil {
+		err = os.Rename(staged, path+".gz")
+	}
+	if err != nil {
+		os.Remove(staged)
+		return file, err
+	}
+	if compressed, err := os.Stat(path + ".gz"); err == nil {
+		file.CompressedSize = compressed.Size()
+	}
+	return file, os.Remove(path)
+}
+
+// compressedArtifacts renames the artifacts that were compressed.
+func compressedArtifacts(artifacts []string, compressed []CompressedFile) []string {
+	gzipped := make(map[string]bool, len(compressed))
+	for _, c := range compressed {
+		gzipped[c.File] = true
+	}
+	renamed := make([]string, len(artifacts))
+	for i, artifact := range artifacts {
+		renamed[i] = artifact
+		if gzipped[filepath.Base(artifact)] {
+			renamed[i] += ".gz"
+		}
+	}
+	return renamed
+}
+
+// removeStaleCompressed removes the gzipped copies an earlier compressed run
+// left of the files written again uncompressed, which servers would serve
+// instead, and its compressed-index.json.
+func removeStaleCompressed(outputDir string) {
+	gzipped, _ := filepath.Glob(filepath.Join(outputDir, "*.gz"))
+	for _, path := range gzipped {
+		if fileExists(strings.TrimSuffix(path, ".gz")) {
+			os.Remove(path)
+		}
+	}
+	os.Remove(filepath.Join(outputDir, compressedIndexFileName))
+}
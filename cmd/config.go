@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+const defaultConfigFile = "/etc/funkoverage/config.json"
+
+// Config holds the settings read from the funkoverage configuration file.
+type Config struct {
+	Notify    NotifyConfig    `json:"notify"`
+	Collector CollectorConfig `json:"collector"`
+	Agent     AgentConfig     `json:"agent"`
+	Events    EventsConfig    `json:"events"`
+	Wrap      WrapConfig      `json:"wrap"`
+	Hooks     HooksConfig     `json:"hooks"`
+	Reportd   ReportdConfig   `json:"reportd"`
+	// Gates are the per-image coverage targets report checks.
+	Gates GatesConfig `json:"gates"`
+	// ReportWebhooks are called after every report generation.
+	ReportWebhooks []WebhookConfig `json:"report_webhooks"`
+}
+
+// AgentConfig holds the defaults of funkoverage agent.
+type AgentConfig struct {
+	// URL is the base URL of the collector the agent reports to.
+	URL string `json:"url"`
+	// Token is the bearer token of the collector (its collector.token).
+	Token   string `json:"token"`
+	Host    string `json:"host"`
+	Product string `json:"product"`
+	// Interval is a duration such as "5m".
+	Interval string `json:"interval"`
+}
+
+// WrapConfig holds the defaults of funkoverage wrap.
+type WrapConfig struct {
+	// Disable lists rules such as "uid=0,boot" (see wrap --disable), added
+	// to the ones given on the command line.
+	Disable []string `json:"disable"`
+	// LogName is the log file name template of wrap --log-name, which the
+	// command line overrides.
+	LogName string `json:"log_name"`
+}
+
+// disableRules parses the configured disable rules.
+func (c WrapConfig) disableRules() ([]DisableRule, error) {
+	var rules []DisableRule
+	for _, s := range c.Disable {
+		r, err := parseDisableRule(s)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// loadConfig reads the JSON configuration file pointed to by FUNKOVERAGE_CONFIG
+// (or the default location). A missing file yields an empty configuration.
+func loadConfig() (*Config, error) {
+	path := os.Getenv("FUNKOVERAGE_CONFIG")
+	if path == "" {
+		path = defaultConfigFile
+	}
+	cfg := &Config{}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read config %s: %w", path, err)
+	}
+	if err := json.Unmarshal(content, cfg); err != nil {
+		return nil, fmt.Errorf("could not parse config %s: %w", path, err)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// --- Container Runs ---
+
+// Where Pin, FuncTracer.so, funkoverage itself and the log directory are
+// mounted inside the container.
+const (
+	containerPinRoot = "/opt/funkoverage/pin"
+	containerToolDir = "/opt/funkoverage/tools"
+	containerBinary  = "/opt/funkoverage/bin/funkoverage"
+	containerLogDir  = "/opt/funkoverage/logs"
+)
+
+// containerEnv are passed on to the container when set, so wrapped binaries
+// label, sample and trace their runs as they would on the host.
+var containerEnv = []string{
+	"COVERAGE_SESSION", "COVERAGE_TAGS", "FUNKOVERAGE_EDGES", "FUNKOVERAGE_TIMELINE",
+	"FUNKOVERAGE_SAMPLE", "FUNKOVERAGE_LOG_ENV",
+}
+
+// ContainerOptions describe a funkoverage container run.
+type ContainerOptions struct {
+	// Engine is podman or docker, by default whichever is installed (podman first).
+	Engine string
+	Image  string
+	// LogDir receives the logs written in the container.
+	LogDir string
+	// Wrap lists more binaries of the container to wrap besides the command.
+	Wrap    []string
+	Command []string
+}
+
+// containerEngine returns the path of the container engine to run.
+func containerEngine(name string) (string, error) {
+	if name != "" {
+		return exec.LookPath(name)
+	}
+	for _, name := range []string{"podman", "docker"} {
+		if path, err := exec.LookPath(name); err == nil {
+			return path, nil
+		}
+	}
+	return "", errors.New("neither podman nor docker found, install one or pass --engine")
+}
+
+// containerScript returns the script wrapping the command, and the extra
+// binaries, inside the container before running the command.
+func containerScript(wrap []string) string {
+	targets := `"$target"`
+	if len(wrap) > 0 {
+		targets += " " + shellWords(wrap)
+	}
+	return `set -e
+target=$(command -v "$1") || { echo "funkoverage: $1 not found in the container" >&2; exit 127; }
+funkoverage wrap ` + targets + `
+exec "$@"
+`
+}
+
+// containerRunArgs returns the engine arguments of a run mounting pinRoot,
+// the directory of FuncTracer.so, the funkoverage binary self and the
+// staging directory receiving the logs.
+func containerRunArgs(opts ContainerOptions, pinRoot, toolDir, self, staging string) []string {
+	args := []string{"run", "--rm", "-i",
+		// The mounts are shared with the host: do not relabel them for SELinux.
+		"--security-opt", "label=disable",
+		"-v", pinRoot + ":" + containerPinRoot + ":ro",
+		"-v", toolDir + ":" + containerToolDir + ":ro",
+		"-v", self + ":" + containerBinary + ":ro",
+		"-v", staging + ":" + containerLogDir,
+		"-e", "PIN_ROOT=" + containerPinRoot,
+		"-e", "PIN_TOOL_SEARCH_DIR=" + containerToolDir,
+		"-e", "LOG_DIR=" + containerLogDir,
+		"-e", "PATH=/opt/funkoverage/bin:/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+	}
+	for _, name := range containerEnv {
+		if _, ok := os.LookupEnv(name); ok {
+			args = append(args, "-e", name)
+		}
+	}
+	args = append(args, "--entrypoint", "/bin/sh", opts.Image, "-c", containerScript(opts.Wrap), "sh")
+	return append(args, opts.Command...)
+}
+
+// shellWords quotes each word for a POSIX shell.
+func shellWords(words []string) string {
+	quoted := make([]string, len(words))
+	for i, w := range words {
+		quoted[i] = shellQuote(w)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// containerRun runs the command in a container with its binary wrapped, then
+// moves the logs written in the container into LogDir, prefixed with the
+// image name like the logs of collect. It returns the exit status of the command.
+func containerRun(opts ContainerOptions) (int, error) {
+	if opts.Image == "" || len(opts.Command) == 0 {
+		return 0, errors.New("an image and a command are required")
+	}
+	engine, err := containerEngine(opts.Engine)
+	if err != nil {
+		return 0, err
+	}
+	pinRoot := os.Getenv("PIN_ROOT")
+	if pinRoot == "" {
+		return 0, errors.New("PIN_ROOT environment variable is not set")
+	}
+	searchDir := os.Getenv("PIN_TOOL_SEARCH_DIR")
+	if searchDir == "" {
+		searchDir = defaultPinToolSearchDir
+	}
+	pinTool, err := findPinTool(searchDir)
+	if err != nil {
+		return 0, err
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return 0, fmt.Errorf("could not locate the funkoverage binary: %w", err)
+	}
+	if err := os.MkdirAll(opts.LogDir, 0755); err != nil {
+		return 0, err
+	}
+	staging, err := os.MkdirTemp(opts.LogDir, ".container-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(staging)
+	// The user of the container may not be the one running funkoverage.
+	if err := os.Chmod(staging, 0777); err != nil {
+		return 0, err
+	}
+
+	cmd := exec.Command(engine, containerRunArgs(opts, pinRoot, filepath.Dir(pinTool), self, staging)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	status := 0
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return 0, fmt.Errorf("%s failed: %w", filepath.Base(engine), err)
+		}
+		status = exitErr.ExitCode()
+	}
+	added, err := tagHostLogs(staging, opts.LogDir, unsafeNameRe.ReplaceAllString(opts.Image, "_"))
+	if err != nil {
+		return status, err
+	}
+	fmt.Printf("Collected %d log(s) into %s\n", added, opts.LogDir)
+	return status, nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// --- Invocation Context ---
+
+var (
+	// contextMarker starts the line describing the traced invocation (format 7).
+	contextMarker = []byte("[FuncTracer] [Context]")
+	// buildIDMarker follows the image of the line recording its build ID.
+	buildIDMarker = []byte("] [BuildID:")
+)
+
+// LogContext describes the invocation a log was written by.
+type LogContext struct {
+	Time time.Time         `json:"time"`
+	Host string            `json:"host,omitempty"`
+	Cwd  string            `json:"cwd,omitempty"`
+	Argv []string          `json:"argv,omitempty"`
+	Env  map[string]string `json:"env,omitempty"`
+}
+
+// headerFields returns the escaped [Key:value] fields following prefix.
+func headerFields(line, prefix []byte) [][]byte {
+	rest := line[bytes.Index(line, prefix)+len(prefix):]
+	var fields [][]byte
+	for {
+		i := bytes.Index(rest, []byte(" ["))
+		if i < 0 {
+			return fields
+		}
+		rest = rest[i+2:]
+		j := bytes.IndexByte(rest, ']')
+		if j < 0 {
+			return fields
+		}
+		fields = append(fields, rest[:j])
+		rest = rest[j+1:]
+	}
+}
+
+// parseLogContext parses a context line.
+func parseLogContext(line []byte) (*LogContext, bool) {
+	if !bytes.Contains(line, contextMarker) {
+		return nil, false
+	}
+	ctx := &LogContext{}
+	for _, field := range headerFields(line, contextMarker) {
+		key, value, ok := bytes.Cut(field, []byte(":"))
+		if !ok {
+			continue
+		}
+		v := string(unescapeField(append([]byte(nil), value...)))
+		switch string(key) {
+		case "Time":
+			ctx.Time, _ = time.Parse(time.RFC3339, v)
+		case "Host":
+			ctx.Host = v
+		case "Cwd":
+			ctx.Cwd = v
+		case "Arg":
+			ctx.Argv = append(ctx.Argv, v)
+		case "Env":
+			if name, val, ok := strings.Cut(v, "="); ok {
+				if ctx.Env == nil {
+					ctx.Env = make(map[string]string)
+				}
+				ctx.Env[name] = val
+			}
+		}
+	}
+	return ctx, true
+}
+
+// parseBuildIDLine returns the image and build ID of a build ID line.
+func parseBuildIDLine(line []byte) (image []byte, buildID string, ok bool) {
+	i := bytes.Index(line, buildIDMarker)
+	if i < 0 {
+		return nil, "", false
+	}
+	start := bytes.Index(line[:i], imageMarker)
+	if start < 0 {
+		return nil, "", false
+	}
+	rest := line[i+len(buildIDMarker):]
+	j := bytes.IndexByte(rest, ']')
+	if j <= 0 {
+		return nil, "", false
+	}
+	return unescapeField(line[start+len(imageMarker) : i]), string(rest[:j]), true
+}
+
+// commandLine formats argv for a shell, quoting the arguments that need it.
+func commandLine(argv []string) string {
+	quoted := make([]string, len(argv))
+	for i, arg := range argv {
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'\\$`*?[]{}()<>|&;#~") {
+			arg = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
+
+// ExplainedLog is what funkoverage explain tells about a log.
+type ExplainedLog struct {
+	LogStats
+	Images []ImageSummary `json:"images"`
+}
+
+// ImageSummary is the coverage of one image within a log.
+type ImageSummary struct {
+	Image       string  `json:"image"`
+	BuildID     string  `json:"build_id,omitempty"`
+	Arch        string  `json:"arch,omitempty"`
+	TotalCount  int     `json:"total_count"`
+	CalledCount int     `json:"called_count"`
+	CoveragePct float64 `json:"coverage_pct"`
+}
+
+// explainLogs reads each log on its own and describes its invocation.
+func explainLogs(logFiles []string) ([]ExplainedLog, error) {
+	var explained []ExplainedLog
+	for _, logFile := range logFiles {
+		coverage, stats, err := analyzeLogsWith([]string{logFile}, AnalyzeOptions{})
+		if err != nil {
+			return nil, err
+		}
+		e := ExplainedLog{LogStats: stats[0], Images: []ImageSummary{}}
+		for _, image := range sortedKeys(coverage) {
+			data := coverage[image]
+			path, _ := splitArchImage(image)
+			s := ImageSummary{Image: path, BuildID: stats[0].BuildIDs[path], Arch: stats[0].Archs[path], TotalCount: len(data.TotalFunctions), CalledCount: len(data.CalledFunctions)}
+			if s.TotalCount > 0 {
+				s.CoveragePct = float64(s.CalledCount) / float64(s.TotalCount) * 100
+			}
+			e.Images = append(e.Images, s)
+		}
+		explained = append(explained, e)
+	}
+	return explained, nil
+}
+
+// printExplainedLogs writes the description of each log, or a JSON array.
+func printExplainedLogs(w io.Writer, logs []ExplainedLog, asJSON bool) error {
+	if asJSON {
+		if logs == nil {
+			logs = []ExplainedLog{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(logs)
+	}
+	for i, l := range logs {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "Log:      %s\n", l.File)
+		if l.RunID != "" {
+			fmt.Fprintf(w, "Run:      %s\n", l.RunID)
+		}
+		if l.Import != "" {
+			fmt.Fprintf(w, "Imported: from %s data\n", l.Import)
+		}
+		if l.Tool != "" {
+			fmt.Fprintf(w, "Tracer:   FuncTracer %s, log format %d\n", l.Tool, l.Format)
+		}
+		if l.Options != "" {
+			fmt.Fprintf(w, "Options:  %s\n", l.Options)
+		}
+		if c := l.Context; c != nil {
+			fmt.Fprintf(w, "Time:     %s\n", c.Time.Format(time.RFC3339))
+			fmt.Fprintf(w, "Host:     %s\n", c.Host)
+			fmt.Fprintf(w, "Cwd:      %s\n", c.Cwd)
+			fmt.Fprintf(w, "Command:  %s\n", commandLine(c.Argv))
+			for _, name := range sortedKeys(c.Env) {
+				fmt.Fprintf(w, "Env:      %s=%s\n", name, c.Env[name])
+			}
+		} else if l.Import == "" {
+			fmt.Fprintln(w, "Context:  none (written by a FuncTracer older than format 7)")
+		}
+		if l.Session != "" || len(l.Tags) > 0 {
+			fmt.Fprintf(w, "Session:  %s", l.Session)
+			if len(l.Tags) > 0 {
+				fmt.Fprintf(w, " [tags: %s]", strings.Join(l.Tags, ", "))
+			}
+			fmt.Fprintln(w)
+		}
+		if l.Sample != "" {
+			fmt.Fprintf(w, "Sampled:  %s of the invocations\n", l.Sample)
+		}
+		if e := l.Exit; e != nil {
+			fmt.Fprintf(w, "Exit:     status %d", e.Code)
+			if e.Signal > 0 {
+				fmt.Fprintf(w, " (signal %d)", e.Signal)
+			}
+			fmt.Fprintf(w, " after %s", e.Wall)
+			if e.Startup > 0 {
+				fmt.Fprintf(w, ", Pin start-up ~%s", e.Startup.Round(time.Millisecond))
+			}
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "Images:   %d\n", len(l.Images))
+		for _, img := range l.Images {
+			fmt.Fprintf(w, "  %s: %d/%d functions called (%.1f%%)", img.Image, img.CalledCount, img.TotalCount, img.CoveragePct)
+			if img.Arch != "" {
+				fmt.Fprintf(w, ", %s", img.Arch)
+			}
+			if img.BuildID != "" {
+				fmt.Fprintf(w, ", build ID %s", img.BuildID)
+			}
+			fmt.Fprintln(w)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// --- Tracing Control ---
+
+// funkoverage ctl turns tracing off and on per binary name pattern, without
+// rewrapping, e.g. as a kill switch while instrumentation overhead gets in
+// the way of an investigation. ctl serve keeps the rules behind a control
+// socket of the log directory and saves them to its control file, which
+// wrappers and Windows launchers read on every run: shell wrappers cannot
+// query a socket without socat.
+const (
+	controlSocketName = ".funkoverage.ctl"
+	controlFileName   = ".funkoverage.control"
+)
+
+// ControlRule turns tracing off ("disable") or back on ("enable") for the
+// binaries whose name, as they were called, matches the glob Pattern. The
+// last rule matching a binary applies; binaries no rule matches are traced.
+type ControlRule struct {
+	Action  string `json:"action"`
+	Pattern string `json:"pattern"`
+}
+
+// controlRequest is a line a ctl command writes to the control socket:
+// "enable" or "disable" a pattern, "reset" or "status".
+type controlRequest struct {
+	Command string `json:"command"`
+	Pattern string `json:"pattern,omitempty"`
+}
+
+// controlResponse is the line the control server answers with: the rules in
+// force once the request was applied, or why it was not.
+type controlResponse struct {
+	Rules []ControlRule `json:"rules"`
+	Error string        `json:"error,omitempty"`
+}
+
+// readControlRules reads the control file of logDir, without rules when
+// there is none.
+func readControlRules(logDir string) ([]ControlRule, error) {
+	content, err := os.ReadFile(filepath.Join(logDir, controlFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rules []ControlRule
+	for _, line := range strings.Split(string(content), "\n") {
+		if action, pattern, ok := strings.Cut(strings.TrimSpace(line), " "); ok {
+			rules = append(rules, ControlRule{Action: action, Pattern: pattern})
+		}
+	}
+	return rules, nil
+}
+
+// writeControlRules replaces the control file of logDir in one step, one
+// "<action> <pattern>" line per rule, or removes it without rules.
+func writeControlRules(logDir string, rules []ControlRule) error {
+	file := filepath.Join(logDir, controlFileName)
+	if len(rules) == 0 {
+		if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(logDir, 0777); err != nil {
+		return err
+	}
+	var b strings.Builder
+	for _, r := range rules {
+		fmt.Fprintf(&b, "%s %s\n", r.Action, r.Pattern)
+	}
+	staged := file + ".tmp"
+	if err := os.WriteFile(staged, []byte(b.String()), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(staged, file); err != nil {
+		os.Remove(staged)
+		return err
+	}
+	return nil
+}
+
+// applyControl returns the rules once a request is applied. A rule replaces
+// the one of the same pattern, and goes last.
+func applyControl(rules []ControlRule, req controlRequest) ([]ControlRule, error) {
+	switch req.Command {
+	case "status":
+		return rules, nil
+	case "reset":
+		return nil, nil
+	case "enable", "disable":
+	default:
+		return rules, fmt.Errorf("unknown control command %q", req.Command)
+	}
+	// The wrapper reads the pattern as the rest of the line, and matches it
+	// against the name the binary was called by.
+	if _, err := path.Match(req.Pattern, ""); err != nil || req.Pattern == "" || strings.ContainsAny(req.Pattern, "/\n\r") {
+		return rules, fmt.Errorf("invalid pattern %q: expected a glob matching binary names, like ls or python3*", req.Pattern)
+	}
+	var kept []ControlRule
+	for _, r := range rules {
+		if r.Pattern != req.Pattern {
+			kept = append(kept, r)
+		}
+	}
+	return append(kept, ControlRule{Action: req.Command, Pattern: req.Pattern}), nil
+}
+
+// controlTraced reports whether the rules let the binary called name be traced.
+func controlTraced(rules []ControlRule, name string) bool {
+	traced := true
+	for _, r := range rules {
+		if ok, _ := path.Match(r.Pattern, name); ok {
+			traced = r.Action != "disable"
+		}
+	}
+	return traced
+}
+
+// controlScript is the part of the wrapper script running the original
+// untraced when the rules of the control file say so.
+const controlScript = `# Tracing control (funkoverage ctl): the last rule of the control file whose
+# pattern matches the binary name turns tracing off or on.
+if [ -f "$LOG_DIR/` + controlFileName + `" ]; then
+    control_action=enable
+    while read -r action pattern; do
+        case "$binary_name" in
+            $pattern) control_action="$action" ;;
+        esac
+    done < "$LOG_DIR/` + controlFileName + `"
+    if [ "$control_action" = disable ]; then
+        exec "$ORIGINAL_BINARY" "$@"
+    fi
+fi
+`
+
+// serveControl answers the ctl commands on socket, saving the rules to the
+// control file of logDir, until interrupted. The socket is the owner's only.
+func serveControl(logDir, socket string) error {
+	if socket == "" {
+		socket = filepath.Join(logDir, controlSocketName)
+	}
+	if err := os.MkdirAll(logDir, 0777); err != nil {
+		return err
+	}
+	// A socket left behind by a server that did not stop cleanly.
+	if info, err := os.Lstat(socket); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(socket)
+	}
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(socket)
+	if err := os.Chmod(socket, 0600); err != nil {
+		listener.Close()
+		return err
+	}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stop
+		listener.Close()
+	}()
+	fmt.Printf("Serving tracing control for %s on %s\n", logDir, socket)
+	var mu sync.Mutex
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			mu.Lock()
+			defer mu.Unlock()
+			handleControl(conn, logDir)
+		}()
+	}
+}
+
+// handleControl applies the request of one connection and answers it.
+func handleControl(conn io.ReadWriter, logDir string) {
+	var resp controlResponse
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	var req controlRequest
+	if err == nil {
+		err = json.Unmarshal(line, &req)
+	}
+	if err == nil {
+		resp.Rules, err = controlLocally(logDir, req)
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	json.NewEncoder(conn).Encode(resp)
+}
+
+// controlLocally applies a request to the control file of logDir.
+func controlLocally(logDir string, req controlRequest) ([]ControlRule, error) {
+	rules, err := readControlRules(logDir)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := applyControl(rules, req)
+	if err != nil {
+		return rules, err
+	}
+	if req.Command != "status" {
+		if err := writeControlRules(logDir, updated); err != nil {
+			return rules, err
+		}
+	}
+	return updated, nil
+}
+
+// sendControl sends a request to the control server on socket. Without a
+// server, it applies the request to the control file itself, so the kill
+// switch works all the same.
+func sendControl(logDir, socket string, req controlRequest) ([]ControlRule, error) {
+	if socket == "" {
+		socket = filepath.Join(logDir, controlSocketName)
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ECONNREFUSED) {
+			return controlLocally(logDir, req)
+		}
+		return nil, err
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, err
+	}
+	var resp controlResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("control server on %s: %w", socket, err)
+	}
+	if resp.Error != "" {
+		return resp.Rules, errors.New(resp.Error)
+	}
+	return resp.Rules, nil
+}
+
+// printControlRules writes the rules in force, in the order they apply.
+func printControlRules(w io.Writer, rules []ControlRule) {
+	if len(rules) == 0 {
+		fmt.Fprintln(w, "Tracing is on for every wrapped binary.")
+		return
+	}
+	fmt.Fprintln(w, "Tracing rules (the last matching one applies; other binaries are traced):")
+	for _, r := range rules {
+		fmt.Fprintf(w, "  %-8s %s\n", r.Action, r.Pattern)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Multi-host Aggregation ---
+
+// uploadIndexName is the append-only index recording where each stored log came from.
+const uploadIndexName = "index.jsonl"
+
+// UploadRecord describes one log received by the collector.
+type UploadRecord struct {
+	File     string    `json:"file"`
+	Host     string    `json:"host"`
+	Product  string    `json:"product"`
+	Run      string    `json:"run"`
+	Received time.Time `json:"received"`
+}
+
+var uploadIndexMu sync.Mutex
+
+// appendUploadRecord adds rec to the index of storeDir.
+func appendUploadRecord(storeDir string, rec UploadRecord) error {
+	uploadIndexMu.Lock()
+	defer uploadIndexMu.Unlock()
+	f, err := os.OpenFile(filepath.Join(storeDir, uploadIndexName), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// readUploadIndex returns the records of every log still present in storeDir.
+// Logs dropped into the directory by other means are reported with an empty host.
+func readUploadIndex(storeDir string) ([]UploadRecord, error) {
+	byFile := map[string]UploadRecord{}
+	uploadIndexMu.Lock()
+	f, err := os.Open(filepath.Join(storeDir, uploadIndexName))
+	if err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var rec UploadRecord
+			if json.Unmarshal(scanner.Bytes(), &rec) == nil {
+				byFile[rec.File] = rec
+			}
+		}
+		f.Close()
+	}
+	uploadIndexMu.Unlock()
+
+	entries, err := os.ReadDir(storeDir)
+	if err != nil {
+		return nil, err
+	}
+	records := []UploadRecord{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".log") {
+			continue
+		}
+		rec, ok := byFile[entry.Name()]
+		if !ok {
+			rec = UploadRecord{File: entry.Name(), Received: logTimestamp(filepath.Join(storeDir, entry.Name()))}
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// GroupRow is the merged coverage of all the logs sharing a host or a run.
+type GroupRow struct {
+	Name       string    `json:"name"`
+	Product    string    `json:"product,omitempty"`
+	Hosts      int       `json:"hosts"`
+	Logs       int       `json:"logs"`
+	FirstSeen  time.Time `json:"first_seen"`
+	Total      int       `json:"total_functions"`
+	Called     int       `json:"called_functions"`
+	Percentage float64   `json:"coverage_pct"`
+}
+
+type DashboardData struct {
+	GeneratedAt string     `json:"generated_at"`
+	Product     string     `json:"product,omitempty"`
+	Overall     GroupRow   `json:"overall"`
+	Hosts       []GroupRow `json:"hosts"`
+	Runs        []GroupRow `json:"runs"`
+}
+
+// groupRecords merges the cached coverage of the records sharing the same key.
+func groupRecords(storeDir string, cache *logCache, records []UploadRecord, key func(UploadRecord) string) ([]GroupRow, error) {
+	groups := map[string][]UploadRecord{}
+	for _, rec := range records {
+		groups[key(rec)] = append(groups[key(rec)], rec)
+	}
+	rows := make([]GroupRow, 0, len(groups))
+	for name, recs := range groups {
+		logFiles := make([]string, 0, len(recs))
+		hosts := map[string]struct{}{}
+		row := GroupRow{Name: name, Product: recs[0].Product, Logs: len(recs), FirstSeen: recs[0].Received}
+		for _, rec := range recs {
+			logFiles = append(logFiles, filepath.Join(storeDir, rec.File))
+			hosts[rec.Host] = struct{}{}
+			if rec.Received.Before(row.FirstSeen) {
+				row.FirstSeen = rec.Received
+			}
+		}
+		coverage, _, err := cache.merge(logFiles)
+		if err != nil {
+			return nil, err
+		}
+		summary := summarizeCoverage(coverage)
+		row.Hosts = len(hosts)
+		row.Total, row.Called, row.Percentage = summary.TotalFunctions, summary.TotalCalled, summary.AverageCoverage
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Name < rows[j].Name })
+	return rows, nil
+}
+
+// buildDashboard aggregates the stored logs per host and per run, optionally
+// restricted to one product. Runs are ordered chronologically to follow a
+// release cycle. Each log is analyzed once by cache, then merged per view.
+func buildDashboard(storeDir, product string, cache *logCache) (*DashboardData, error) {
+	all, err := readUploadIndex(storeDir)
+	if err != nil {
+		return nil, err
+	}
+	present := make([]string, 0, len(all))
+	for _, rec := range all {
+		present = append(present, filepath.Join(storeDir, rec.File))
+	}
+	cache.retain(present)
+	records := all[:0]
+	for _, rec := range all {
+		if product == "" || rec.Product == product {
+			records = append(records, rec)
+		}
+	}
+	data := &DashboardData{GeneratedAt: time.Now().Format("2006-01-02 15:04:05 MST"), Product: product}
+	if data.Hosts, err = groupRecords(storeDir, cache, records, func(r UploadRecord) string { return r.Host }); err != nil {
+		return nil, err
+	}
+	if data.Runs, err = groupRecords(storeDir, cache, records, func(r UploadRecord) string { return r.Run }); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(data.Runs, func(i, j int) bool { return data.Runs[i].FirstSeen.Before(data.Runs[j].FirstSeen) })
+	overall, err := groupRecords(storeDir, cache, records, func(UploadRecord) string { return "all" })
+	if err != nil {
+		return nil, err
+	}
+	if len(overall) > 0 {
+		data.Overall = overall[0]
+	}
+	return data, nil
+}
+
+// addDashboardHandlers serves the multi-host dashboard as HTML and JSON, to
+// the clients presenting the collector token. cache holds the coverage of the
+// stored logs.
+func addDashboardHandlers(mux *http.ServeMux, storeDir, token string, cache *logCache) {
+	tmpl := template.Must(template.New("dashboard").Parse(dashboardHTMLTemplate))
+	mux.HandleFunc("/dashboard", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r, token) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		data, err := buildDashboard(storeDir, r.URL.Query().Get("product"), cache)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_ = tmpl.Execute(w, data)
+	})
+	mux.HandleFunc("/api/dashboard", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r, token) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		data, err := buildDashboard(storeDir, r.URL.Query().Get("product"), cache)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(data)
+	})
+}
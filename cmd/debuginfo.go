@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// --- Detached Debug Info ---
+
+// buildIDDebugPath is where distributions install the debuginfo of a build-id.
+func buildIDDebugPath(buildID string) string {
+	return fmt.Sprintf("%s/.build-id/%s/%s.debug", globalDebugRoot, buildID[:2], buildID[2:])
+}
+
+// debugLink returns the file name recorded in the .gnu_debuglink section.
+func debugLink(f *elf.File) (string, bool) {
+	sec := f.Section(".gnu_debuglink")
+	if sec == nil {
+		return "", false
+	}
+	data, err := sec.Data()
+	if err != nil {
+		return "", false
+	}
+	// Layout: NUL-terminated file name, padding, 4-byte CRC32.
+	i := bytes.IndexByte(data, 0)
+	if i <= 0 {
+		return "", false
+	}
+	return string(data[:i]), true
+}
+
+// debuginfodCacheDir follows the layout of the elfutils debuginfod client, so
+// both share their downloads.
+func debuginfodCacheDir() string {
+	if dir := os.Getenv("DEBUGINFOD_CACHE_PATH"); dir != "" {
+		return dir
+	}
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "debuginfod_client")
+	}
+	return filepath.Join(os.TempDir(), "debuginfod_client")
+}
+
+// fetchDebuginfod downloads the debuginfo of buildID from the servers listed
+// in DEBUGINFOD_URLS, returning the cached file. It returns "" when no server
+// is configured or none has the file.
+func fetchDebuginfod(buildID string) (string, error) {
+	urls := strings.Fields(os.Getenv("DEBUGINFOD_URLS"))
+	if len(urls) == 0 {
+		return "", nil
+	}
+	cached := filepath.Join(debuginfodCacheDir(), buildID, "debuginfo")
+	if _, err := os.Stat(cached); err == nil {
+		return cached, nil
+	}
+	client := &http.Client{Timeout: 5 * time.Minute}
+	var errs []string
+	for _, base := range urls {
+		resp, err := client.Get(strings.TrimSuffix(base, "/") + "/buildid/" + buildID + "/debuginfo")
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusNotFound {
+				errs = append(errs, fmt.Sprintf("%s returned %s", base, resp.Status))
+			}
+			continue
+		}
+		err = writeDebuginfo(cached, resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return "", err
+		}
+		return cached, nil
+	}
+	if len(errs) > 0 {
+		return "", fmt.Errorf("debuginfod: %s", strings.Join(errs, "; "))
+	}
+	return "", nil
+}
+
+// writeDebuginfo stores a download atomically, so an interrupted transfer
+// never leaves a truncated file in the cache.
+func writeDebuginfo(path string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".debuginfo-*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// findDebugFile locates the detached debuginfo of binPath: first under the
+// build-id tree, then through .gnu_debuglink next to the binary, in its .debug
+// directory or mirrored under the debug root, and finally from debuginfod.
+// It returns "" when there is none.
+func findDebugFile(binPath string) (string, error) {
+	f, err := elf.Open(binPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open elf: %w", err)
+	}
+	defer f.Close()
+	buildID, _ := getBuildID(f)
+	if len(buildID) > 2 {
+		if path := buildIDDebugPath(buildID); fileExists(path) {
+			return path, nil
+		}
+	}
+	if link, ok := debugLink(f); ok {
+		dir := filepath.Dir(binPath)
+		if real, err := filepath.EvalSymlinks(binPath); err == nil {
+			dir = filepath.Dir(real)
+		}
+		for _, path := range []string{
+			filepath.Join(dir, link),
+			filepath.Join(dir, ".debug", link),
+			filepath.Join(globalDebugRoot, dir, link),
+		} {
+			if path != binPath && fileExists(path) {
+				return path, nil
+			}
+		}
+	}
+	if len(buildID) > 2 {
+		return fetchDebuginfod(buildID)
+	}
+	return "", nil
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
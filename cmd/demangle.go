@@ -0,0 +1,130 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/ianlancetaylor/demangle"
+)
+
+// --- Function Names ---
+
+// The analysis keys functions on the symbols the logs name them by, so a
+// Called entry always matches its Function entry whatever the demangler
+// makes of them. Symbols become the names shown in the reports once all the
+// logs are merged.
+
+// Optimizers name the copies of a function they make after it, with a
+// suffix that changes from build to build: LTO privatization (.lto_priv.0),
+// constant propagation (.constprop.3), IPA-SRA (.isra.0), partial inlining
+// (.part.0), hot/cold splitting (.cold), ThinLTO promotion (.llvm.1234) and
+// the numbers that make local names unique (.1). The demangler prints them
+// as " [clone .constprop.3]".
+var (
+	cloneSuffixRe  = regexp.MustCompile(`(?:\.(?:lto_priv|constprop|isra|part|cold|localalias|llvm|clone)(?:\.\d+)*|\.\d+)+$`)
+	demangledClone = regexp.MustCompile(`(?: \[clone [^\]]*\])+$`)
+)
+
+// stripCloneSuffixes returns the name of the function an optimizer copy was
+// made from: "foo.constprop.0" and "foo(int) [clone .lto_priv.0]" give "foo"
+// and "foo(int)".
+func stripCloneSuffixes(name string) string {
+	if s := demangledClone.ReplaceAllString(name, ""); s != name {
+		return s
+	}
+	if loc := cloneSuffixRe.FindStringIndex(name); loc != nil && loc[0] > 0 {
+		return name[:loc[0]]
+	}
+	return name
+}
+
+// displayName returns the name a symbol, as keyed by the analysis, is
+// reported under: demangled unless NoDemangle is set, without the suffixes of
+// optimizer copies unless CloneSuffixes is set, then renamed by the aliases.
+func (t *symbolTable) displayName(symbol string) string {
+	if s, ok := t.display[symbol]; ok {
+		return s
+	}
+	name, version := splitSymbolVersion(symbol)
+	s := name
+	if !t.opts.NoDemangle {
+		s = demangle.Filter(name)
+	}
+	if !t.opts.CloneSuffixes {
+		s = stripCloneSuffixes(s)
+	}
+	if version != "" {
+		s += "@" + version
+	}
+	s = t.opts.Aliases.rename(s)
+	t.display[symbol] = s
+	return s
+}
+
+// presentFunctions renames the functions of coverage from their symbols to
+// their display names. Symbols displayed alike, such as the clones of a C++
+// constructor, merge into one function, called when any of them was. The
+// symbols of the renamed functions go to Mangled, and the constructor and
+// destructor clones that ran to Variants.
+func presentFunctions(coverage map[string]*CoverageData, t *symbolTable) {
+	for _, data := range coverage {
+		// A symbol shared by functions at several addresses keeps the
+		// address disambiguateFunctions appended.
+		rename := func(key string) (string, string) {
+			symbol, addr := key, ""
+			if i := strings.LastIndex(key, " [0x"); i > 0 && strings.HasSuffix(key, "]") {
+				symbol, addr = key[:i], key[i:]
+			}
+			return t.displayName(symbol) + addr, symbol
+		}
+		total := make(map[string]struct{}, len(data.TotalFunctions))
+		for key := range data.TotalFunctions {
+			name, _ := rename(key)
+			total[name] = struct{}{}
+			if name != key {
+				data.addMangled(name, key)
+			}
+		}
+		called := make(map[string]struct{}, len(data.CalledFunctions))
+		ran := map[string]map[string]struct{}{}
+		for key := range data.CalledFunctions {
+			name, symbol := rename(key)
+			called[name] = struct{}{}
+			if name == key {
+				continue
+			}
+			data.addMangled(name, key)
+			base, _ := splitSymbolVersion(symbol)
+			if variant := structorVariant(base, demangle.Filter(base)); variant != "" {
+				if ran[name] == nil {
+					ran[name] = map[string]struct{}{}
+				}
+				ran[name][variant] = struct{}{}
+			}
+		}
+		data.TotalFunctions, data.CalledFunctions = total, called
+		if data.Calls != nil {
+			calls := make(map[string]uint64, len(data.Calls))
+			for key, n := range data.Calls {
+				name, _ := rename(key)
+				calls[name] += n
+			}
+			data.Calls = calls
+		}
+		if data.Edges != nil {
+			edges := make(map[Edge]uint64, len(data.Edges))
+			for e, n := range data.Edges {
+				caller, _ := rename(e.Caller)
+				callee, _ := rename(e.Callee)
+				edges[Edge{Caller: caller, Callee: callee}] += n
+			}
+			data.Edges = edges
+		}
+		for name, variants := range ran {
+			if data.Variants == nil {
+				data.Variants = make(map[string][]string)
+			}
+			data.Variants[name] = sortedKeys(variants)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// --- Runtime Disable Rules ---
+
+// DisableRule keeps a wrapped binary from being traced when all of its
+// conditions hold, e.g. when run by root while the system is still booting.
+type DisableRule struct {
+	UID  int    // effective user ID, -1 for any
+	User string // effective user name
+	Cwd  string // working directory, or a directory above it
+	Boot bool   // systemd has not finished booting
+}
+
+// parseDisableRule parses a --disable rule: comma-separated conditions among
+// uid=N, user=NAME, cwd=DIR and boot, e.g. "uid=0,boot".
+func parseDisableRule(s string) (DisableRule, error) {
+	r := DisableRule{UID: -1}
+	if strings.ContainsAny(s, "\n\r") {
+		return DisableRule{}, fmt.Errorf("line break in rule %q", s)
+	}
+	for _, cond := range strings.Split(s, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(cond), "=")
+		switch key {
+		case "uid":
+			uid, err := strconv.Atoi(value)
+			if err != nil || uid < 0 {
+				return DisableRule{}, fmt.Errorf("invalid uid %q in rule %q", value, s)
+			}
+			r.UID = uid
+		case "user":
+			if value == "" {
+				return DisableRule{}, fmt.Errorf("empty user in rule %q", s)
+			}
+			r.User = value
+		case "cwd":
+			if !filepath.IsAbs(value) {
+				return DisableRule{}, fmt.Errorf("cwd must be an absolute directory in rule %q", s)
+			}
+			r.Cwd = filepath.Clean(value)
+		case "boot":
+			r.Boot = true
+		default:
+			return DisableRule{}, fmt.Errorf("unknown condition %q in rule %q, expected uid=N, user=NAME, cwd=DIR or boot", cond, s)
+		}
+	}
+	return r, nil
+}
+
+func (r DisableRule) String() string {
+	var conds []string
+	if r.UID >= 0 {
+		conds = append(conds, "uid="+strconv.Itoa(r.UID))
+	}
+	if r.User != "" {
+		conds = append(conds, "user="+r.User)
+	}
+	if r.Cwd != "" {
+		conds = append(conds, "cwd="+r.Cwd)
+	}
+	if r.Boot {
+		conds = append(conds, "boot")
+	}
+	return strings.Join(conds, ",")
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// shellCondition returns the test of the wrapper matching the rule. The
+// boot check spawns systemctl, so it comes last.
+func (r DisableRule) shellCondition() string {
+	var tests []string
+	if r.UID >= 0 {
+		tests = append(tests, fmt.Sprintf(`[ "$EUID" = %d ]`, r.UID))
+	}
+	if r.User != "" {
+		tests = append(tests, `[ "$(id -un)" = `+shellQuote(r.User)+` ]`)
+	}
+	if r.Cwd != "" {
+		dir := strings.TrimSuffix(r.Cwd, "/") + "/"
+		tests = append(tests, `case "$PWD/" in `+shellQuote(dir)+`*) true ;; *) false ;; esac`)
+	}
+	if r.Boot {
+		tests = append(tests, `case "$(systemctl is-system-running 2>/dev/null)" in initializing|starting) true ;; *) false ;; esac`)
+	}
+	return "{ " + strings.Join(tests, " && ") + "; }"
+}
+
+// disableRulesScript returns the wrapper lines running the original binary
+// untraced when one of the rules matches, empty without rules.
+func disableRulesScript(rules []DisableRule) string {
+	if len(rules) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("# Disable rules: do not trace when one of them matches.\n")
+	for _, r := range rules {
+		fmt.Fprintf(&b, "# %s\nif %s; then\n    exec \"$ORIGINAL_BINARY\" \"$@\"\nfi\n", r, r.shellCondition())
+	}
+	return b.String()
+}
+
+// disableFlag collects repeated --disable flags.
+type disableFlag []DisableRule
+
+func (f *disableFlag) String() string {
+	parts := make([]string, len(*f))
+	for i, r := range *f {
+		parts[i] = r.String()
+	}
+	return strings.Join(parts, " ")
+}
+
+func (f *disableFlag) Set(s string) error {
+	r, err := parseDisableRule(s)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, r)
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"debug/elf"
+	"os"
+	"sort"
+)
+
+// --- Duplicate Binaries ---
+
+// The same binary is often traced under several paths: hardlinks, copies in
+// chroots or container layers. Counted apart, the copies dilute each other's
+// coverage, so images of identical content are merged into one, which lists
+// the other paths as its Copies.
+
+// mergeDuplicateImages merges the images of coverage with the same build ID,
+// or the same content, into the one of the shortest path.
+func mergeDuplicateImages(coverage map[string]*CoverageData, stats []LogStats) {
+	groups := make(map[string][]string)
+	for image, id := range imageIdentities(coverage, stats) {
+		groups[id] = append(groups[id], image)
+	}
+	for _, images := range groups {
+		if len(images) < 2 {
+			continue
+		}
+		sort.Slice(images, func(i, j int) bool {
+			if len(images[i]) != len(images[j]) {
+				return len(images[i]) < len(images[j])
+			}
+			return images[i] < images[j]
+		})
+		kept := images[0]
+		for _, image := range images[1:] {
+			data := coverage[image]
+			mergeCoverage(coverage, map[string]*CoverageData{kept: data})
+			coverage[kept].Copies = mergeSorted(coverage[kept].Copies, []string{image})
+			delete(coverage, image)
+		}
+	}
+}
+
+// imageIdentities identifies the images of coverage by the build ID the logs
+// recorded for them or, for the host images on disk, by the one of their ELF
+// file; images without build ID are identified by the SHA-256 of their
+// content, computed only when another image has the same size.
+func imageIdentities(coverage map[string]*CoverageData, stats []LogStats) map[string]string {
+	ids := make(map[string]string)
+	for _, s := range stats {
+		if s.DuplicateOf != "" {
+			continue
+		}
+		for image, id := range s.BuildIDs {
+			if key := archImageKey(image, s.Archs[image]); coverage[key] != nil && id != "" {
+				ids[key] = "build-id:" + id
+			}
+		}
+	}
+	bySize := make(map[int64][]string)
+	for image := range coverage {
+		if _, ok := ids[image]; ok {
+			continue
+		}
+		if _, arch := splitArchImage(image); arch != "" {
+			continue
+		}
+		info, err := os.Stat(image)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if f, err := elf.Open(image); err == nil {
+			id, _ := getBuildID(f)
+			f.Close()
+			if id != "" {
+				ids[image] = "build-id:" + id
+				continue
+			}
+		}
+		bySize[info.Size()] = append(bySize[info.Size()], image)
+	}
+	for _, images := range bySize {
+		if len(images) < 2 {
+			continue
+		}
+		for _, image := range images {
+			if sum, err := fileSHA256(image); err == nil {
+				ids[image] = "sha256:" + sum
+			}
+		}
+	}
+	return ids
+}
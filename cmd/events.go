@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// --- Event Streaming ---
+
+// EventsConfig selects the message bus coverage events are published to.
+type EventsConfig struct {
+	NATS      NATSConfig      `json:"nats"`
+	KafkaREST KafkaRESTConfig `json:"kafka_rest"`
+	// StateFile records the events already published (default:
+	// eventStateFileName in the report directory).
+	StateFile string `json:"state_file"`
+}
+
+// eventStateFileName is the default EventsConfig.StateFile.
+const eventStateFileName = "events-state.json"
+
+// eventBatchSize is how many events are sent per NATS flush or Kafka REST
+// request; a variable for the tests.
+var eventBatchSize = 500
+
+type NATSConfig struct {
+	URL     string `json:"url"` // nats://host:4222
+	Subject string `json:"subject"`
+}
+
+// KafkaRESTConfig publishes through a Kafka REST proxy, which avoids shipping a Kafka client.
+type KafkaRESTConfig struct {
+	URL   string `json:"url"`
+	Topic string `json:"topic"`
+}
+
+func (c EventsConfig) enabled() bool {
+	return c.NATS.URL != "" || c.KafkaREST.URL != ""
+}
+
+// CoverageEvent is either the first call of a function ("function_hit") or
+// the coverage summary of a single log ("run_summary").
+type CoverageEvent struct {
+	Type     string          `json:"type"`
+	Time     time.Time       `json:"time"`
+	Log      string          `json:"log"`
+	Image    string          `json:"image,omitempty"`
+	Function string          `json:"function,omitempty"`
+	Summary  *CoverageTotals `json:"summary,omitempty"`
+}
+
+// eventState is what publishEvents has already delivered to each bus, so that
+// every report run only sends what is new, and a bus failing does not make the
+// others send everything again.
+type eventState struct {
+	// Buses maps "nats" and "kafka_rest" to what they received.
+	Buses map[string]*busState `json:"buses"`
+	// Logs and Hits are from the state files saved before the buses were
+	// tracked apart: every bus resumes from them.
+	busState
+}
+
+// busState is what one bus received.
+type busState struct {
+	// Logs are the logs whose run_summary was published.
+	Logs map[string]bool `json:"logs,omitempty"`
+	// Hits are the functions whose function_hit was published, per image.
+	Hits map[string]map[string]bool `json:"hits,omitempty"`
+}
+
+// loadEventState reads the state saved at path; a missing file is an empty
+// state.
+func loadEventState(path string) (*eventState, error) {
+	state := &eventState{Buses: map[string]*busState{}}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, state); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+	if state.Buses == nil {
+		state.Buses = map[string]*busState{}
+	}
+	return state, nil
+}
+
+// bus returns what the bus name received.
+func (s *eventState) bus(name string) *busState {
+	if b := s.Buses[name]; b != nil {
+		return b
+	}
+	b := &busState{Logs: map[string]bool{}, Hits: map[string]map[string]bool{}}
+	for log := range s.Logs {
+		b.Logs[log] = true
+	}
+	for image, fns := range s.Hits {
+		b.Hits[image] = make(map[string]bool, len(fns))
+		for fn := range fns {
+			b.Hits[image][fn] = true
+		}
+	}
+	s.Buses[name] = b
+	return b
+}
+
+// delivered records the events the bus acknowledged.
+func (b *busState) delivered(events []CoverageEvent) {
+	for _, ev := range events {
+		switch ev.Type {
+		case "function_hit":
+			if b.Hits[ev.Image] == nil {
+				b.Hits[ev.Image] = make(map[string]bool)
+			}
+			b.Hits[ev.Image][ev.Function] = true
+		case "run_summary":
+			b.Logs[ev.Log] = true
+		}
+	}
+}
+
+// save writes the state of the buses only, the ones resuming from an older
+// state file having taken it over.
+func (s *eventState) save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	content, err := json.Marshal(eventState{Buses: s.Buses})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(content, '\n'), 0644)
+}
+
+// coverageEvents replays chronologically the logs the report analyzed, from
+// the coverage of each it kept (AnalyzeOptions.KeepLogCoverage), emitting a
+// function_hit for every function called for the first time and a run_summary
+// for each log the bus did not receive yet. The logs the analysis skipped, as
+// copies of another log of the same run or as still being written, are left
+// for later.
+func coverageEvents(stats []LogStats, bus *busState) []CoverageEvent {
+	sorted := make([]LogStats, 0, len(stats))
+	for _, s := range stats {
+		if s.DuplicateOf == "" && s.Live != liveLogsSkip && s.Coverage != nil {
+			sorted = append(sorted, s)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return logTimestamp(sorted[i].File).Before(logTimestamp(sorted[j].File))
+	})
+	hit := make(map[string]map[string]bool)
+	events := []CoverageEvent{}
+	for _, s := range sorted {
+		if bus.Logs[s.File] {
+			continue
+		}
+		ts := logTimestamp(s.File)
+		for _, image := range sortedKeys(s.Coverage) {
+			if hit[image] == nil {
+				hit[image] = make(map[string]bool)
+			}
+			for _, fn := range sortedKeys(s.Coverage[image].CalledFunctions) {
+				if bus.Hits[image][fn] || hit[image][fn] {
+					continue
+				}
+				hit[image][fn] = true
+				events = append(events, CoverageEvent{Type: "function_hit", Time: ts, Log: s.File, Image: image, Function: fn})
+			}
+		}
+		summary := summarizeCoverage(s.Coverage)
+		events = append(events, CoverageEvent{Type: "run_summary", Time: ts, Log: s.File, Summary: &summary})
+	}
+	return events
+}
+
+// inBatches calls publish with the events, eventBatchSize at a time, up to the
+// first error, recording each batch published as delivered to bus.
+func inBatches(events []CoverageEvent, bus *busState, publish func([]CoverageEvent) error) error {
+	for batch := range slices.Chunk(events, max(eventBatchSize, 1)) {
+		if err := publish(batch); err != nil {
+			return err
+		}
+		bus.delivered(batch)
+	}
+	return nil
+}
+
+// natsConn is a connection speaking the plain-text NATS protocol.
+type natsConn struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	w       *bufio.Writer
+	subject string
+}
+
+// dialNATS connects to the server of cfg.
+func dialNATS(cfg NATSConfig) (*natsConn, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	conn, err := net.DialTimeout("tcp", host, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	c := &natsConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn), subject: cfg.Subject}
+	if c.subject == "" {
+		c.subject = "funkoverage.events"
+	}
+	_ = conn.SetDeadline(time.Now().Add(time.Minute))
+	if line, err := c.r.ReadString('\n'); err != nil || !strings.HasPrefix(line, "INFO") {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected NATS greeting %q: %v", line, err)
+	}
+	connect := map[string]any{"verbose": false, "pedantic": false, "name": "funkoverage"}
+	if u.User != nil {
+		connect["user"] = u.User.Username()
+		connect["pass"], _ = u.User.Password()
+	}
+	opts, _ := json.Marshal(connect)
+	fmt.Fprintf(c.w, "CONNECT %s\r\n", opts)
+	return c, nil
+}
+
+func (c *natsConn) Close() error {
+	return c.conn.Close()
+}
+
+// publish sends the events and waits for the server to acknowledge them with
+// a PONG.
+func (c *natsConn) publish(events []CoverageEvent) error {
+	_ = c.conn.SetDeadline(time.Now().Add(time.Minute))
+	for _, ev := range events {
+		payload, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.w, "PUB %s.%s %d\r\n%s\r\n", c.subject, ev.Type, len(payload), payload)
+	}
+	fmt.Fprint(c.w, "PING\r\n")
+	if err := c.w.Flush(); err != nil {
+		return err
+	}
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		switch {
+		case strings.HasPrefix(line, "PONG"):
+			return nil
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New(strings.TrimSpace(line))
+		}
+	}
+}
+
+// publishNATS sends the events the bus did not receive yet over a single
+// connection, one acknowledged batch at a time.
+func publishNATS(cfg NATSConfig, events []CoverageEvent, bus *busState) error {
+	c, err := dialNATS(cfg)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return inBatches(events, bus, c.publish)
+}
+
+// publishKafkaREST posts the events to a topic of a Kafka REST proxy (v2 API).
+func publishKafkaREST(cfg KafkaRESTConfig, events []CoverageEvent) error {
+	type record struct {
+		Key   string        `json:"key"`
+		Value CoverageEvent `json:"value"`
+	}
+	records := make([]record, 0, len(events))
+	for _, ev := range events {
+		records = append(records, record{Key: ev.Image, Value: ev})
+	}
+	topic := cfg.Topic
+	if topic == "" {
+		topic = "funkoverage-events"
+	}
+	endpoint := strings.TrimSuffix(cfg.URL, "/") + "/topics/" + url.PathEscape(topic)
+	return postJSONAs(endpoint, "application/vnd.kafka.json.v2+json", map[string]any{"records": records})
+}
+
+// publishEvents streams the coverage events of the logs the report analyzed
+// to every configured bus, leaving out those each bus received in earlier
+// runs. What every bus acknowledged is recorded, even when another failed.
+func publishEvents(cfg EventsConfig, stats []LogStats, outputDir string) error {
+	if !cfg.enabled() {
+		return nil
+	}
+	stateFile := cfg.StateFile
+	if stateFile == "" {
+		stateFile = filepath.Join(outputDir, eventStateFileName)
+	}
+	state, err := loadEventState(stateFile)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	if cfg.NATS.URL != "" {
+		bus := state.bus("nats")
+		if events := coverageEvents(stats, bus); len(events) > 0 {
+			if err := publishNATS(cfg.NATS, events, bus); err != nil {
+				errs = append(errs, fmt.Errorf("nats: %w", err))
+			}
+		}
+	}
+	if cfg.KafkaREST.URL != "" {
+		bus := state.bus("kafka_rest")
+		if events := coverageEvents(stats, bus); len(events) > 0 {
+			publish := func(batch []CoverageEvent) error { return publishKafkaREST(cfg.KafkaREST, batch) }
+			if err := inBatches(events, bus, publish); err != nil {
+				errs = append(errs, fmt.Errorf("kafka: %w", err))
+			}
+		}
+	}
+	if err := state.save(stateFile); err != nil {
+		errs = append(errs, fmt.Errorf("state: %w", err))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Execution Summary ---
+
+// trailerMarker starts the line FuncTracer appends to a log once the traced
+// invocation ended: "[FuncTracer] [Exit:N] [Signal:N] [WallMs:N] [Started:ns]".
+var trailerMarker = []byte("[FuncTracer] [Exit:")
+
+// LogExit is how a traced invocation ended, from the trailer of its log.
+type LogExit struct {
+	Code   int `json:"code"`
+	Signal int `json:"signal,omitempty"`
+	// Wall is the run time of the invocation, Pin included.
+	Wall time.Duration `json:"wall_ns"`
+	// Startup estimates the Pin overhead: the time from the wrapper
+	// launching Pin to the tool writing the log header.
+	Startup time.Duration `json:"startup_ns,omitempty"`
+	// started is when the wrapper launched Pin.
+	started time.Time
+}
+
+// parseLogTrailer parses the trailer line of a log.
+func parseLogTrailer(line []byte) (LogExit, bool) {
+	if !bytes.HasPrefix(line, trailerMarker) {
+		return LogExit{}, false
+	}
+	fields := make(map[string]string)
+	for _, field := range strings.Fields(string(line)) {
+		if k, v, ok := strings.Cut(strings.Trim(field, "[]"), ":"); ok {
+			fields[k] = v
+		}
+	}
+	var exit LogExit
+	var err error
+	if exit.Code, err = strconv.Atoi(fields["Exit"]); err != nil {
+		return LogExit{}, false
+	}
+	exit.Signal, _ = strconv.Atoi(fields["Signal"])
+	if ms, err := strconv.ParseInt(fields["WallMs"], 10, 64); err == nil {
+		exit.Wall = time.Duration(ms) * time.Millisecond
+	}
+	if ns, err := strconv.ParseInt(fields["Started"], 10, 64); err == nil {
+		exit.started = time.Unix(0, ns)
+	}
+	return exit, true
+}
+
+// runStart returns when FuncTracer started, encoded in the run ID as
+// "<pid>-<start ns>" in hexadecimal.
+func runStart(runID string) (time.Time, bool) {
+	_, start, ok := strings.Cut(runID, "-")
+	if !ok {
+		return time.Time{}, false
+	}
+	ns, err := strconv.ParseUint(start, 16, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(ns)), true
+}
+
+// setStartup estimates the Pin start-up time of a log from its run ID.
+func (e *LogExit) setStartup(runID string) {
+	if e.started.IsZero() {
+		return
+	}
+	if start, ok := runStart(runID); ok && start.After(e.started) {
+		e.Startup = start.Sub(e.started)
+	}
+}
+
+// logBinary returns the name of the wrapped binary that wrote a log.
+func logBinary(path string) string {
+	name := filepath.Base(path)
+	if loc := logTimestampRe.FindStringIndex(name); loc != nil {
+		return name[:loc[0]]
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ".log")
+}
+
+// ExecutionRow sums up the traced invocations of one wrapped binary.
+type ExecutionRow struct {
+	Binary   string
+	Runs     int
+	Failed   int // exited with a non-zero status
+	Signaled int // killed by a signal
+	Wall     time.Duration
+	// startup sums the Pin start-up estimates of startups runs.
+	startup  time.Duration
+	startups int
+}
+
+// MeanWall is the average run time of an invocation.
+func (r ExecutionRow) MeanWall() time.Duration {
+	if r.Runs == 0 {
+		return 0
+	}
+	return (r.Wall / time.Duration(r.Runs)).Round(time.Millisecond)
+}
+
+// MeanStartup is the average Pin start-up time, zero when unknown.
+func (r ExecutionRow) MeanStartup() time.Duration {
+	if r.startups == 0 {
+		return 0
+	}
+	return (r.startup / time.Duration(r.startups)).Round(time.Millisecond)
+}
+
+func (r *ExecutionRow) add(e *LogExit) {
+	r.Runs++
+	switch {
+	case e.Signal > 0:
+		r.Signaled++
+	case e.Code != 0:
+		r.Failed++
+	}
+	r.Wall += e.Wall
+	if e.Startup > 0 {
+		r.startup += e.Startup
+		r.startups++
+	}
+}
+
+// ExecutionSummary sums up how the traced invocations ended, per binary.
+type ExecutionSummary struct {
+	Rows  []ExecutionRow
+	Total ExecutionRow
+	// NoTrailer counts the logs of older wrappers or of runs still going.
+	NoTrailer int
+}
+
+// executionSummary returns the summary of the logs read, nil when none of
+// them has a trailer. Duplicate copies of a log are left out.
+func executionSummary(stats []LogStats) *ExecutionSummary {
+	rows := make(map[string]*ExecutionRow)
+	summary := &ExecutionSummary{Total: ExecutionRow{Binary: "all"}}
+	for _, s := range stats {
+		if s.DuplicateOf != "" {
+			continue
+		}
+		if s.Exit == nil {
+			summary.NoTrailer++
+			continue
+		}
+		binary := logBinary(s.File)
+		row, ok := rows[binary]
+		if !ok {
+			row = &ExecutionRow{Binary: binary}
+			rows[binary] = row
+		}
+		row.add(s.Exit)
+		summary.Total.add(s.Exit)
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	for _, binary := range sortedKeys(rows) {
+		summary.Rows = append(summary.Rows, *rows[binary])
+	}
+	return summary
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"hash/fnv"
+	"html"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// --- Flame Graphs ---
+
+const (
+	foldedReportFileName     = "flamegraph.folded"
+	flameGraphReportFileName = "flamegraph.svg"
+)
+
+// foldedStack is one line of the folded-stack format read by flamegraph.pl
+// and speedscope: the frames from the root down and their weight.
+type foldedStack struct {
+	frames []string
+	count  uint64
+}
+
+func (s foldedStack) String() string {
+	frames := make([]string, len(s.frames))
+	for i, f := range s.frames {
+		// ';' separates the frames and the last space the count
+		frames[i] = strings.NewReplacer(";", ":", "\n", " ").Replace(f)
+	}
+	return fmt.Sprintf("%s %d", strings.Join(frames, ";"), s.count)
+}
+
+// foldedStacks turns the call counts of every image into stacks weighted by
+// calls. FuncTracer records no full stacks, so they are at most two deep: the
+// calls along each call-graph edge are stacked on their caller, and the calls
+// no edge accounts for sit directly on the image.
+func foldedStacks(coverage map[string]*CoverageData) []foldedStack {
+	stacks := []foldedStack{}
+	for image, data := range coverage {
+		root := filepath.Base(image)
+		incoming := make(map[string]uint64)
+		for e, n := range data.Edges {
+			stacks = append(stacks, foldedStack{[]string{root, e.Caller, e.Callee}, n})
+			incoming[e.Callee] += n
+		}
+		for fn, n := range data.Calls {
+			if n > incoming[fn] {
+				stacks = append(stacks, foldedStack{[]string{root, fn}, n - incoming[fn]})
+			}
+		}
+	}
+	sort.Slice(stacks, func(i, j int) bool {
+		return strings.Join(stacks[i].frames, "\x00") < strings.Join(stacks[j].frames, "\x00")
+	})
+	return stacks
+}
+
+// generateFoldedReport writes the folded stacks of all the images.
+func generateFoldedReport(stacks []foldedStack, outputDir string) error {
+	f, err := os.Create(filepath.Join(outputDir, foldedReportFileName))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	for _, s := range stacks {
+		fmt.Fprintln(w, s)
+	}
+	return w.Flush()
+}
+
+// flameNode is a frame of the flame graph with the summed weight of its stacks.
+type flameNode struct {
+	name     string
+	value    uint64
+	children map[string]*flameNode
+}
+
+func buildFlameTree(stacks []foldedStack) *flameNode {
+	root := &flameNode{name: "all", children: map[string]*flameNode{}}
+	for _, s := range stacks {
+		root.value += s.count
+		node := root
+		for _, frame := range s.frames {
+			child, ok := node.children[frame]
+			if !ok {
+				child = &flameNode{name: frame, children: map[string]*flameNode{}}
+				node.children[frame] = child
+			}
+			child.value += s.count
+			node = child
+		}
+	}
+	return root
+}
+
+func (n *flameNode) depth() int {
+	d := 0
+	for _, c := range n.children {
+		d = max(d, c.depth())
+	}
+	return d + 1
+}
+
+// Flame graph geometry, in pixels.
+const (
+	flameWidth       = 1200
+	flameFrameHeight = 16
+	flameMargin      = 10
+	flameTitleHeight = 40
+	flameMinWidth    = 0.1 // narrower frames are left out
+)
+
+// flameColor gives a frame a stable warm color derived from its name.
+func flameColor(name string) string {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	v := h.Sum32()
+	return fmt.Sprintf("rgb(%d,%d,%d)", 205+v%50, 80+(v>>8)%130, 40+(v>>16)%50)
+}
+
+// generateFlameGraph renders the folded stacks as a standalone SVG flame graph,
+// the roots at the bottom and each frame as wide as its share of the calls.
+func generateFlameGraph(stacks []foldedStack, outputDir string, generatedAt time.Time) error {
+	f, err := os.Create(filepath.Join(outputDir, flameGraphReportFileName))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	root := buildFlameTree(stacks)
+	depth := root.depth()
+	height := flameTitleHeight + depth*flameFrameHeight + 2*flameMargin
+	fmt.Fprintf(w, `<?xml version="1.0" standalone="no"?>
+<svg version="1.1" width="%d" height="%d" xmlns="http://www.w3.org/2000/svg" font-family="Verdana" font-size="12">
+<rect x="0" y="0" width="100%%" height="100%%" fill="#f8f8f8"/>
+<text x="%d" y="24" text-anchor="middle" font-size="17">Flame Graph (calls)</text>
+<text x="%d" y="24" text-anchor="end" font-size="10">Generated at: %s</text>
+`, flameWidth, height, flameWidth/2, flameWidth-flameMargin, html.EscapeString(generatedAt.Format(reportTimeLayout)))
+	if root.value > 0 {
+		scale := float64(flameWidth-2*flameMargin) / float64(root.value)
+		var draw func(n *flameNode, x float64, level int)
+		draw = func(n *flameNode, x float64, level int) {
+			width := float64(n.value) * scale
+			if width < flameMinWidth {
+				return
+			}
+			y := height - flameMargin - (level+1)*flameFrameHeight
+			label := html.EscapeString(printableSymbol(n.name))
+			fmt.Fprintf(w, "<g><title>%s (%d calls, %.2f%%)</title><rect x=\"%.2f\" y=\"%d\" width=\"%.2f\" height=\"%d\" fill=\"%s\" rx=\"2\"/>",
+				label, n.value, float64(n.value)/float64(root.value)*100, x, y, width, flameFrameHeight-1, flameColor(n.name))
+			// Verdana at 12px is about 7px per character.
+			if chars := int(width-6) / 7; chars >= 3 {
+				text := printableSymbol(n.name)
+				if r := []rune(text); len(r) > chars {
+					text = string(r[:chars-2]) + ".."
+				}
+				fmt.Fprintf(w, "<text x=\"%.2f\" y=\"%d\">%s</text>", x+3, y+flameFrameHeight-4, html.EscapeString(text))
+			}
+			fmt.Fprintln(w, "</g>")
+			children := make([]string, 0, len(n.children))
+			for name := range n.children {
+				children = append(children, name)
+			}
+			sort.Strings(children)
+			for _, name := range children {
+				child := n.children[name]
+				draw(child, x, level+1)
+				x += float64(child.value) * scale
+			}
+		}
+		draw(root, flameMargin, 0)
+	}
+	fmt.Fprintln(w, "</svg>")
+	return w.Flush()
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 const versionString = "0.6.3"
@@ -13,6 +14,12 @@ const versionString = "0.6.3"
 // --- CLI ---
 
 func main() {
+	// A copy of funkoverage in place of a wrapped PE executable launches it.
+	if self, err := os.Executable(); err == nil {
+		if cfg, ok := readLauncherConfig(self); ok {
+			os.Exit(runLauncher(cfg, launcherName(self), os.Args[1:]))
+		}
+	}
 	if len(os.Args) < 2 {
 		fmt.Print(helpText)
 		os.Exit(1)
@@ -20,9 +27,126 @@ func main() {
 
 	// Define subcommands
 	wrapCmd := flag.NewFlagSet("wrap", flag.ExitOnError)
+	wrapSample := wrapCmd.String("sample", "", "Trace only every Nth invocation (N) or a random share of them (P%)")
+	wrapAPI := wrapCmd.String("api", "", "Trace only the functions these comma-separated shared libraries export (e.g. libssl,libcrypto.so.3), counting the calls other images make to them")
+	var wrapDisable disableFlag
+	wrapLogName := wrapCmd.String("log-name", "", "Template of the log file names, from {binary}, {pid}, {session}, {host}, {timestamp} and {nanos} (default "+defaultLogName+")")
+	wrapRewrap := wrapCmd.Bool("rewrap", false, "Regenerate the wrapper of binaries already wrapped with the current template and options, keeping their backup")
+	wrapCmd.Var(&wrapDisable, "disable", "Run untraced when all the conditions of this rule hold: uid=N, user=NAME, cwd=DIR, boot (repeatable)")
 	unwrapCmd := flag.NewFlagSet("unwrap", flag.ExitOnError)
+	unwrapForce := unwrapCmd.Bool("force", false, "Restore binaries whose wrapper was edited or whose backup was moved, from the backup store or their package")
 	reportCmd := flag.NewFlagSet("report", flag.ExitOnError)
-	reportFormats := reportCmd.String("formats", "html,txt,xml", "Comma-separated list: html,xml,txt (default: html,txt,xml)")
+	reportFormats := reportCmd.String("formats", "html,txt,xml", "Comma-separated list: html,xml,cobertura,txt,dot,folded,flamegraph,perfetto (default: html,txt,xml)")
+	reportGroupBy := reportCmd.String("group-by", "", "Merge images into one row per source package (package), or add the coverage of each session (session)")
+	reportSession := reportCmd.String("session", "", "Comma-separated sessions (COVERAGE_SESSION) whose logs are reported")
+	reportTag := reportCmd.String("tag", "", "Comma-separated tags (COVERAGE_TAGS): report the logs carrying any of them")
+	reportJobs := reportCmd.Int("jobs", 0, "Number of per-image reports generated in parallel (default: number of CPUs)")
+	reportMaxMemory := reportCmd.String("max-memory", "", "Spill analysis state to disk beyond this size, e.g. 512M or 2G")
+	reportNoDemangle := reportCmd.Bool("no-demangle", false, "Report C++ functions under their mangled symbol")
+	reportCloneSuffixes := reportCmd.Bool("clone-suffixes", false, "Keep the copies optimizers make of functions (foo.constprop.0, foo.lto_priv.0) apart")
+	reportSymbolVersions := reportCmd.Bool("symbol-versions", false, "Keep ELF symbol versions (memcpy@GLIBC_2.14) in function names")
+	reportAliases := reportCmd.String("aliases", "", "File of \"from = to\" rules renaming functions, merging those renamed alike")
+	reportPreset := reportCmd.String("preset", "", "Comma-separated presets of functions left out of the coverage: cxx-internals")
+	reportNoSoname := reportCmd.Bool("no-soname", false, "Report shared libraries under the name of their file (libfoo.so.1.2.3) instead of their soname")
+	var reportPathMap pathMapFlag
+	reportCmd.Var(&reportPathMap, "path-map", "Rewrite image path prefixes, old=new (repeatable)")
+	var reportImages ImageFilter
+	reportCmd.Var(&reportImages, "image", "Report only the images matching this glob, or regex with re:, excluding them with a leading ! (repeatable)")
+	reportTop := reportCmd.Int("top", defaultHotFunctions, "Number of most called functions listed per image, 0 disables")
+	reportNamespaceDepth := reportCmd.Int("namespace-depth", 1, "Levels of C++ namespaces the per-namespace coverage is summed up by")
+	reportXMLUncalledAs := reportCmd.String("xml-uncalled-as", "", "Make each function an XUnit test case, the uncalled ones skipped or a failure: skipped, failure")
+	reportXMLChunkSize := reportCmd.Int("xml-chunk-size", 0, "Split the XUnit report of the images with more functions into files of as many functions")
+	reportCompress := reportCmd.Bool("compress", false, "Gzip the large HTML, XML and JSON files of the output directory, for static serving")
+	reportInlined := reportCmd.Bool("inlined", false, "Count the functions inlined into called ones as called, from the DWARF debug info")
+	reportHTMLSingleFile := reportCmd.Bool("html-single-file", false, "Also bundle the HTML pages into the standalone coverage-report.html")
+	reportPaginateAbove := reportCmd.Int("paginate-above", defaultPaginateAbove, "Functions beyond which the HTML function list is rendered page by page, -1 never")
+	reportDotMinCalls := reportCmd.Uint64("dot-min-calls", 1, "Leave call-graph edges taken fewer times out of the dot output")
+	reportSourceRoot := reportCmd.String("source-root", "", "Source tree of the traced programs, annotated with coverage in the HTML report")
+	reportSourceURL := reportCmd.String("source-url", "", "Repository URL template the HTML report links functions to, with {rev}, {file} and {line}")
+	reportSourceRev := reportCmd.String("source-rev", "", "Revision substituted for {rev} in --source-url (default: HEAD)")
+	reportSourceIndex := reportCmd.String("source-index", "", "compile_commands.json or ctags tags file locating the functions of images without debug info")
+	reportBaseline := reportCmd.String("baseline", "", "State file of a previous run (see --save-state) the HTML reports are compared with")
+	reportCompare := reportCmd.String("compare", "", "summary.json or XUnit XML report of an earlier run, or its output directory, the text and HTML reports show the coverage changes since")
+	reportSaveState := reportCmd.String("save-state", "", "Write the per-function coverage of this run to a state file, for later --baseline comparisons")
+	reportHistory := reportCmd.String("history", "", "Directory keeping a coverage snapshot per run, for the trends of the aggregate report")
+	reportThresholds := reportCmd.String("thresholds", defaultThresholds.String(), "Coverage percentages below which images are red and yellow in the aggregate report, <red>,<green>")
+	reportBelow := reportCmd.Float64("below", 0, "List only the images below this coverage percentage in the text and aggregate reports")
+	reportCmd.Float64Var(reportBelow, "min-image-coverage", 0, "Same as --below")
+	reportAbove := reportCmd.Float64("above", 0, "List only the images above this coverage percentage in the text and aggregate reports")
+	reportNoSummary := reportCmd.Bool("no-summary", false, "Do not write summary.json into the output directory")
+	reportIFuncVariants := reportCmd.Bool("ifunc-variants", false, "List which IFUNC implementation ran for each function in the text report")
+	reportStructorVariants := reportCmd.Bool("structor-variants", false, "List which C++ constructor and destructor variants (C1, C2, D0...) ran in the text report")
+	reportReachability := reportCmd.Bool("reachability", false, "Group the uncalled functions of the text report by their static reachability from main")
+	reportStrict := reportCmd.Bool("strict", false, "Fail when a log has more malformed lines than --max-malformed")
+	reportMaxMalformed := reportCmd.Float64("max-malformed", 1.0, "Tolerated percentage of malformed lines per log with --strict")
+	reportTimestamp := reportCmd.String("timestamp", "", "Generation time written into the reports, Unix seconds or RFC 3339 (default: SOURCE_DATE_EPOCH or now)")
+	reportLiveLogs := reportCmd.String("live-logs", liveLogsTail, "Logs still being written: tail (read complete lines) or skip")
+	serveCmd := flag.NewFlagSet("serve", flag.ExitOnError)
+	serveAddr := serveCmd.String("addr", ":8080", "Address to listen on")
+	collectCmd := flag.NewFlagSet("collect", flag.ExitOnError)
+	collectHosts := collectCmd.String("hosts", "", "File listing the [user@]host entries to collect from, one per line")
+	collectDest := collectCmd.String("dest", "", "Local directory receiving the logs")
+	collectRemoteDir := collectCmd.String("remote-dir", defaultLogDir, "Log directory on the remote hosts")
+	collectJobs := collectCmd.Int("jobs", 4, "Number of hosts to collect from in parallel")
+	collectReport := collectCmd.String("report", "", "Generate reports into this directory after collecting")
+	collectorCmd := flag.NewFlagSet("collector", flag.ExitOnError)
+	collectorAddr := collectorCmd.String("addr", ":8081", "Address to listen on")
+	streamCollectorCmd := flag.NewFlagSet("stream-collector", flag.ExitOnError)
+	streamSocket := streamCollectorCmd.String("socket", "", "Unix socket to listen on (default: "+streamSocketName+" in the log directory, where wrappers look for it)")
+	streamMaxSize := streamCollectorCmd.Int64("max-size", defaultStreamMaxSize, "Rotate a log once it holds this many bytes")
+	streamMaxAge := streamCollectorCmd.Duration("max-age", defaultStreamMaxAge, "Rotate a log once it was started this long ago")
+	explainCmd := flag.NewFlagSet("explain", flag.ExitOnError)
+	explainJSON := explainCmd.Bool("json", false, "Print the description of the logs as JSON")
+	statsCmd := flag.NewFlagSet("stats", flag.ExitOnError)
+	statsTable := statsCmd.Bool("table", false, "Print a line per image besides the totals")
+	importCmd := flag.NewFlagSet("import", flag.ExitOnError)
+	importFormat := importCmd.String("format", "", "Format of the inputs: drcov, gcov, lcov or sancov")
+	importImage := importCmd.String("image", "", "Path of the binary lcov and gcov data describe, as it appears in the reports")
+	importBinary := importCmd.String("binary", "", "ELF binary the sancov offsets are resolved against")
+	containerRunCmd := flag.NewFlagSet("container run", flag.ExitOnError)
+	containerImage := containerRunCmd.String("image", "", "Container image to run the command in")
+	containerEngineName := containerRunCmd.String("engine", "", "Container engine: podman or docker (default: whichever is installed, podman first)")
+	containerLogDir := containerRunCmd.String("log-dir", "", "Directory receiving the logs written in the container (default: $LOG_DIR or "+defaultLogDir+")")
+	containerWrap := containerRunCmd.String("wrap", "", "Comma-separated binaries of the container to wrap besides the command")
+	agentCmd := flag.NewFlagSet("agent", flag.ExitOnError)
+	agentURL := agentCmd.String("url", "", "Base URL of the collector, e.g. http://collector:8081 (default: agent.url in the config)")
+	agentHost := agentCmd.String("host", "", "Name of this host at the collector (default: agent.host in the config, else the hostname)")
+	agentProduct := agentCmd.String("product", "", "Product the shipped logs are recorded under (default: agent.product in the config)")
+	agentInterval := agentCmd.Duration("interval", 0, "Time between two rounds of shipping logs and heartbeat (default: agent.interval in the config, else 5m)")
+	agentOnce := agentCmd.Bool("once", false, "Run a single round and exit")
+	generateHooksCmd := flag.NewFlagSet("generate-hooks", flag.ExitOnError)
+	generateHooksFormat := generateHooksCmd.String("format", "", "Package manager to generate hooks for: zypper, dnf or apt")
+	generateHooksOutput := generateHooksCmd.String("output", "", "Install the hooks below this root directory, / for this system (default: print them)")
+	refreshCmd := flag.NewFlagSet("refresh", flag.ExitOnError)
+	upgradeWrappersCmd := flag.NewFlagSet("upgrade-wrappers", flag.ExitOnError)
+	upgradeDryRun := upgradeWrappersCmd.Bool("dry-run", false, "List the wrappers to regenerate without touching them")
+	startWindowCmd := flag.NewFlagSet("start-window", flag.ExitOnError)
+	startWindowLogDir := startWindowCmd.String("log-dir", "", "Log directory of the wrapped binaries (default: $LOG_DIR or "+defaultLogDir+")")
+	stopWindowCmd := flag.NewFlagSet("stop-window", flag.ExitOnError)
+	stopWindowLogDir := stopWindowCmd.String("log-dir", "", "Log directory of the wrapped binaries (default: $LOG_DIR or "+defaultLogDir+")")
+	stopWindowReset := stopWindowCmd.Bool("reset", false, "Stop using coverage windows: trace every run again")
+	ctlCmd := flag.NewFlagSet("ctl", flag.ExitOnError)
+	ctlLogDir := ctlCmd.String("log-dir", "", "Log directory of the wrapped binaries (default: $LOG_DIR or "+defaultLogDir+")")
+	ctlSocket := ctlCmd.String("socket", "", "Control socket (default: .funkoverage.ctl in the log directory)")
+	reportdCmd := flag.NewFlagSet("reportd", flag.ExitOnError)
+	statusCmd := flag.NewFlagSet("status", flag.ExitOnError)
+	recoverCmd := flag.NewFlagSet("recover", flag.ExitOnError)
+	recoverManifest := recoverCmd.String("manifest", "", "Manifest whose wraps are applied again (default: manifest.json of SAFE_BIN_DIR)")
+	statusJSON := statusCmd.Bool("json", false, "Print the wrapped binaries as a JSON array")
+	stapCmd := flag.NewFlagSet("stap", flag.ExitOnError)
+	stapBinary := stapCmd.String("binary", "", "Binary whose functions are probed (default: the one of the command or --pid)")
+	stapPid := stapCmd.Int("pid", 0, "Trace this running process until it exits instead of starting a command")
+	stapLogDir := stapCmd.String("log-dir", "", "Directory receiving the log (default: $LOG_DIR or "+defaultLogDir+")")
+	stapPath := stapCmd.String("stap", "stap", "stap executable")
+	fuzzBridgeCmd := flag.NewFlagSet("fuzz-bridge", flag.ExitOnError)
+	fuzzMapSize := fuzzBridgeCmd.Int("map-size", 0, "Size of the coverage map created outside a fuzzer (default: $AFL_MAP_SIZE, else 65536)")
+	fuzzOutput := fuzzBridgeCmd.String("output", "", "File receiving the coverage map of a run outside a fuzzer, like afl-showmap -r")
+	eventsCmd := flag.NewFlagSet("events", flag.ExitOnError)
+	eventsSince := eventsCmd.String("since", "", "List events from this time on: a duration back from now (2h), Unix seconds or RFC 3339")
+	eventsBinary := eventsCmd.String("binary", "", "List only the events of this wrapped binary name")
+	eventsKind := eventsCmd.String("event", "", "List only events of this kind: start, stop or failure")
+	eventsFile := eventsCmd.String("file", "", "Read the events from this syslog file instead of the journal")
+	eventsJSON := eventsCmd.Bool("json", false, "Print the events as JSON")
 
 	wrapCmd.Usage = func() {
 		fmt.Print(wrapHelpText)
@@ -39,6 +163,111 @@ func main() {
 		reportCmd.PrintDefaults()
 	}
 
+	serveCmd.Usage = func() {
+		fmt.Print(serveHelpText)
+		serveCmd.PrintDefaults()
+	}
+
+	collectCmd.Usage = func() {
+		fmt.Print(collectHelpText)
+		collectCmd.PrintDefaults()
+	}
+
+	collectorCmd.Usage = func() {
+		fmt.Print(collectorHelpText)
+		collectorCmd.PrintDefaults()
+	}
+
+	streamCollectorCmd.Usage = func() {
+		fmt.Print(streamCollectorHelpText)
+		streamCollectorCmd.PrintDefaults()
+	}
+
+	explainCmd.Usage = func() {
+		fmt.Print(explainHelpText)
+		explainCmd.PrintDefaults()
+	}
+
+	statsCmd.Usage = func() {
+		fmt.Print(statsHelpText)
+		statsCmd.PrintDefaults()
+	}
+
+	importCmd.Usage = func() {
+		fmt.Print(importHelpText)
+		importCmd.PrintDefaults()
+	}
+
+	containerRunCmd.Usage = func() {
+		fmt.Print(containerHelpText)
+		containerRunCmd.PrintDefaults()
+	}
+
+	agentCmd.Usage = func() {
+		fmt.Print(agentHelpText)
+		agentCmd.PrintDefaults()
+	}
+
+	generateHooksCmd.Usage = func() {
+		fmt.Print(generateHooksHelpText)
+		generateHooksCmd.PrintDefaults()
+	}
+
+	refreshCmd.Usage = func() {
+		fmt.Print(refreshHelpText)
+		refreshCmd.PrintDefaults()
+	}
+
+	recoverCmd.Usage = func() {
+		fmt.Print(recoverHelpText)
+		recoverCmd.PrintDefaults()
+	}
+
+	upgradeWrappersCmd.Usage = func() {
+		fmt.Print(upgradeWrappersHelpText)
+		upgradeWrappersCmd.PrintDefaults()
+	}
+
+	startWindowCmd.Usage = func() {
+		fmt.Print(startWindowHelpText)
+		startWindowCmd.PrintDefaults()
+	}
+
+	stopWindowCmd.Usage = func() {
+		fmt.Print(stopWindowHelpText)
+		stopWindowCmd.PrintDefaults()
+	}
+
+	ctlCmd.Usage = func() {
+		fmt.Print(ctlHelpText)
+		ctlCmd.PrintDefaults()
+	}
+
+	reportdCmd.Usage = func() {
+		fmt.Print(reportdHelpText)
+		reportdCmd.PrintDefaults()
+	}
+
+	statusCmd.Usage = func() {
+		fmt.Print(statusHelpText)
+		statusCmd.PrintDefaults()
+	}
+
+	stapCmd.Usage = func() {
+		fmt.Print(stapHelpText)
+		stapCmd.PrintDefaults()
+	}
+
+	fuzzBridgeCmd.Usage = func() {
+		fmt.Print(fuzzBridgeHelpText)
+		fuzzBridgeCmd.PrintDefaults()
+	}
+
+	eventsCmd.Usage = func() {
+		fmt.Print(eventsHelpText)
+		eventsCmd.PrintDefaults()
+	}
+
 	switch os.Args[1] {
 	case "help", "--help", "-h":
 		fmt.Print(helpText)
@@ -52,7 +281,41 @@ func main() {
 			fmt.Println("wrap: missing binary path(s)")
 			os.Exit(1)
 		}
-		if err := wrapMany(wrapCmd.Args()); err != nil {
+		sample, err := parseSampling(*wrapSample)
+		if err != nil {
+			fmt.Println("wrap: --sample:", err)
+			os.Exit(1)
+		}
+		api, err := parseAPILibraries(*wrapAPI)
+		if err != nil {
+			fmt.Println("wrap: --api:", err)
+			os.Exit(1)
+		}
+		cfg, err := loadConfig()
+		if err != nil {
+			fmt.Println("wrap error:", err)
+			os.Exit(1)
+		}
+		disable, err := cfg.Wrap.disableRules()
+		if err != nil {
+			fmt.Println("wrap: config wrap.disable:", err)
+			os.Exit(1)
+		}
+		logName, err := parseLogName(cfg.Wrap.LogName)
+		if err != nil {
+			fmt.Println("wrap: config wrap.log_name:", err)
+			os.Exit(1)
+		}
+		if *wrapLogName != "" {
+			if logName, err = parseLogName(*wrapLogName); err != nil {
+				fmt.Println("wrap: --log-name:", err)
+				os.Exit(1)
+			}
+		}
+		opts := WrapOptions{Sample: sample, Disable: append(disable, wrapDisable...), API: api, LogName: logName, Rewrap: *wrapRewrap}
+		if err := cfg.Hooks.around("wrap", HookContext{Binaries: wrapCmd.Args()}, func() error {
+			return wrapMany(wrapCmd.Args(), opts)
+		}); err != nil {
 			fmt.Println("wrap error:", err)
 			os.Exit(1)
 		}
@@ -62,71 +325,493 @@ func main() {
 			fmt.Println("unwrap: missing binary path(s)")
 			os.Exit(1)
 		}
-		if err := unwrapMany(unwrapCmd.Args()); err != nil {
+		cfg, err := loadConfig()
+		if err != nil {
+			fmt.Println("unwrap error:", err)
+			os.Exit(1)
+		}
+		if err := cfg.Hooks.around("unwrap", HookContext{Binaries: unwrapCmd.Args()}, func() error {
+			return unwrapMany(unwrapCmd.Args(), UnwrapOptions{Force: *unwrapForce})
+		}); err != nil {
 			fmt.Println("unwrap error:", err)
 			os.Exit(1)
 		}
 	case "report", "-r":
 		reportCmd.Parse(os.Args[2:])
 		if reportCmd.NArg() < 2 {
-			fmt.Println("report: missing arguments. Usage: report <inputdir|log1.txt,log2.txt> <outputdir> [--formats <formats>]")
+			fmt.Println("report: missing arguments. Usage: report [--formats <formats>] <inputdir|log1.txt,log2.txt|glob>... <outputdir>")
 			os.Exit(1)
 		}
-		inputArg := reportCmd.Arg(0)
-		outputDir := reportCmd.Arg(1)
+		inputs := reportCmd.Args()[:reportCmd.NArg()-1]
+		outputDir := reportCmd.Arg(reportCmd.NArg() - 1)
 		formats := strings.Split(*reportFormats, ",")
 
 		if len(formats) == 0 {
-			fmt.Println("report: must specify at least one of html, xml, txt")
+			fmt.Println("report: must specify at least one of html, xml, cobertura, txt, dot, folded, flamegraph, perfetto")
 			os.Exit(1)
 		}
 
-		logFiles := []string{}
-		info, err := os.Stat(inputArg)
-		if err == nil && info.IsDir() {
-			entries, err := os.ReadDir(inputArg)
-			if err != nil {
-				fmt.Printf("report: failed to read directory %s: %v\n", inputArg, err)
+		maxMemory := int64(0)
+		if *reportMaxMemory != "" {
+			var err error
+			if maxMemory, err = parseByteSize(*reportMaxMemory); err != nil {
+				fmt.Println("report: --max-memory:", err)
+				os.Exit(1)
+			}
+		}
+
+		var timestamp time.Time
+		if *reportTimestamp != "" {
+			var err error
+			if timestamp, err = parseReportTimestamp(*reportTimestamp); err != nil {
+				fmt.Println("report: --timestamp:", err)
+				os.Exit(1)
+			}
+		}
+
+		if *reportSourceRoot != "" {
+			if info, err := os.Stat(*reportSourceRoot); err != nil {
+				fmt.Println("report: --source-root:", err)
+				os.Exit(1)
+			} else if !info.IsDir() {
+				fmt.Println("report: --source-root:", *reportSourceRoot, "is not a directory")
 				os.Exit(1)
 			}
-			for _, entry := range entries {
-				if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".log") {
-					logFiles = append(logFiles, filepath.Join(inputArg, entry.Name()))
-				}
+		}
+
+		if *reportSourceURL != "" && !strings.Contains(*reportSourceURL, "{file}") {
+			fmt.Println("report: --source-url: the template has no {file} placeholder")
+			os.Exit(1)
+		}
+
+		if *reportSourceIndex != "" {
+			if *reportSourceRoot == "" && *reportSourceURL == "" {
+				fmt.Println("report: --source-index needs --source-root or --source-url")
+				os.Exit(1)
 			}
-			if len(logFiles) == 0 {
-				fmt.Printf("report: no .log files found in directory %s\n", inputArg)
+			if _, err := loadSourceIndex(*reportSourceIndex); err != nil {
+				fmt.Println("report: --source-index:", err)
 				os.Exit(1)
 			}
-		} else {
-			logFiles = strings.Split(inputArg, ",")
 		}
-		coverage, err := analyzeLogs(logFiles)
+
+		thresholds, err := parseThresholds(*reportThresholds)
+		if err != nil {
+			fmt.Println("report: --thresholds:", err)
+			os.Exit(1)
+		}
+		listing, err := newCoverageFilter(*reportBelow, *reportAbove)
+		if err != nil {
+			fmt.Println("report: --below/--above:", err)
+			os.Exit(1)
+		}
+
+		var aliases *SymbolAliases
+		if *reportAliases != "" {
+			if aliases, err = loadSymbolAliases(*reportAliases); err != nil {
+				fmt.Println("report: --aliases:", err)
+				os.Exit(1)
+			}
+		}
+		presets, err := lookupFunctionPresets(splitList(*reportPreset))
+		if err != nil {
+			fmt.Println("report: --preset:", err)
+			os.Exit(1)
+		}
+
+		opts := ReportOptions{
+			Inputs:    inputs,
+			OutputDir: outputDir,
+			Formats:   formats,
+			GroupBy:   *reportGroupBy,
+			Labels:    LabelFilter{Sessions: splitList(*reportSession), Tags: splitList(*reportTag)},
+			Jobs:      *reportJobs,
+			AnalyzeOptions: AnalyzeOptions{
+				MaxMemory:      maxMemory,
+				SymbolVersions: *reportSymbolVersions,
+				NoDemangle:     *reportNoDemangle,
+				CloneSuffixes:  *reportCloneSuffixes,
+				PathMap:        reportPathMap,
+				Aliases:        aliases,
+				Presets:        presets,
+				Images:         reportImages,
+				LiveLogs:       *reportLiveLogs,
+			},
+			Top:              *reportTop,
+			NamespaceDepth:   *reportNamespaceDepth,
+			HTMLSingleFile:   *reportHTMLSingleFile,
+			Inlined:          *reportInlined,
+			NoSoname:         *reportNoSoname,
+			Compress:         *reportCompress,
+			XMLUncalledAs:    *reportXMLUncalledAs,
+			XMLChunkSize:     *reportXMLChunkSize,
+			PaginateAbove:    *reportPaginateAbove,
+			DotMinCalls:      *reportDotMinCalls,
+			Sources:          SourceOptions{Root: *reportSourceRoot, URL: *reportSourceURL, Rev: *reportSourceRev, Index: *reportSourceIndex},
+			BaselineFile:     *reportBaseline,
+			CompareFile:      *reportCompare,
+			StateFile:        *reportSaveState,
+			HistoryDir:       *reportHistory,
+			Thresholds:       thresholds,
+			Listing:          listing,
+			NoSummary:        *reportNoSummary,
+			IFuncVariants:    *reportIFuncVariants,
+			StructorVariants: *reportStructorVariants,
+			Reachability:     *reportReachability,
+			Strict:           *reportStrict,
+			MaxMalformed:     *reportMaxMalformed,
+			Timestamp:        timestamp,
+		}
+		cfg, err := loadConfig()
 		if err != nil {
 			fmt.Println("report error:", err)
 			os.Exit(1)
 		}
-		for _, format := range formats {
-			switch format {
-			case "txt":
-				printTxtReport(coverage)
-			case "html":
-				_ = os.MkdirAll(outputDir, 0755)
-				for image, data := range coverage {
-					if err := generateHTMLReport(image, data, outputDir); err != nil {
-						fmt.Println("HTML report error:", err)
-					}
-				}
-				_ = generateAggregateHTMLReport(coverage, outputDir)
-			case "xml":
-				_ = os.MkdirAll(outputDir, 0755)
-				for image, data := range coverage {
-					if err := generateXUnitReport(image, data, outputDir); err != nil {
-						fmt.Println("XUnit report error:", err)
-					}
-				}
+		if err := cfg.Hooks.around("report", HookContext{Inputs: inputs, OutputDir: outputDir, Formats: formats}, func() error {
+			return runReport(opts)
+		}); err != nil {
+			fmt.Println("report error:", err)
+			os.Exit(1)
+		}
+	case "serve":
+		serveCmd.Parse(os.Args[2:])
+		if serveCmd.NArg() < 1 {
+			fmt.Println("serve: missing arguments. Usage: serve [--addr <addr>] <inputdir|log1.txt,log2.txt>")
+			os.Exit(1)
+		}
+		if err := serve(serveCmd.Arg(0), *serveAddr); err != nil {
+			fmt.Println("serve error:", err)
+			os.Exit(1)
+		}
+	case "collect":
+		collectCmd.Parse(os.Args[2:])
+		if *collectHosts == "" || *collectDest == "" {
+			fmt.Println("collect: missing arguments. Usage: collect --hosts <hostsfile> --dest <dir>")
+			os.Exit(1)
+		}
+		if err := collect(*collectHosts, *collectRemoteDir, *collectDest, *collectJobs); err != nil {
+			fmt.Println("collect error:", err)
+			os.Exit(1)
+		}
+		if *collectReport != "" {
+			opts := ReportOptions{Inputs: []string{*collectDest}, OutputDir: *collectReport, Formats: []string{"html", "txt", "xml"}, Top: defaultHotFunctions, NamespaceDepth: 1, Thresholds: defaultThresholds}
+			if err := runReport(opts); err != nil {
+				fmt.Println("report error:", err)
+				os.Exit(1)
 			}
 		}
+	case "collector":
+		collectorCmd.Parse(os.Args[2:])
+		if collectorCmd.NArg() < 1 {
+			fmt.Println("collector: missing arguments. Usage: collector [--addr <addr>] <storedir>")
+			os.Exit(1)
+		}
+		cfg, err := loadConfig()
+		if err != nil {
+			fmt.Println("collector error:", err)
+			os.Exit(1)
+		}
+		if err := collector(collectorCmd.Arg(0), *collectorAddr, cfg.Collector); err != nil {
+			fmt.Println("collector error:", err)
+			os.Exit(1)
+		}
+	case "stream-collector":
+		streamCollectorCmd.Parse(os.Args[2:])
+		opts := StreamOptions{LogDir: streamCollectorCmd.Arg(0), Socket: *streamSocket, MaxSize: *streamMaxSize, MaxAge: *streamMaxAge}
+		if opts.LogDir == "" {
+			if opts.LogDir = os.Getenv("LOG_DIR"); opts.LogDir == "" {
+				opts.LogDir = defaultLogDir
+			}
+		}
+		if err := runStreamCollector(opts); err != nil {
+			fmt.Println("stream-collector error:", err)
+			os.Exit(1)
+		}
+	case "explain":
+		explainCmd.Parse(os.Args[2:])
+		if explainCmd.NArg() < 1 {
+			fmt.Println("explain: missing arguments. Usage: explain [--json] <inputdir|log1.txt,log2.txt>")
+			os.Exit(1)
+		}
+		logFiles, err := collectLogFiles(explainCmd.Arg(0))
+		if err == nil {
+			var logs []ExplainedLog
+			if logs, err = explainLogs(logFiles); err == nil {
+				err = printExplainedLogs(os.Stdout, logs, *explainJSON)
+			}
+		}
+		if err != nil {
+			fmt.Println("explain error:", err)
+			os.Exit(1)
+		}
+	case "stats":
+		statsCmd.Parse(os.Args[2:])
+		if statsCmd.NArg() < 1 {
+			fmt.Println("stats: missing arguments. Usage: stats [--table] <inputdir|log1.txt,log2.txt|state.json>")
+			os.Exit(1)
+		}
+		totals, err := coverageStats(statsCmd.Arg(0))
+		if err != nil {
+			fmt.Println("stats error:", err)
+			os.Exit(1)
+		}
+		printCoverageStats(os.Stdout, totals, *statsTable)
+	case "import":
+		importCmd.Parse(os.Args[2:])
+		if importCmd.NArg() < 2 {
+			fmt.Println("import: missing arguments. Usage: import --format <format> <input>... <outputdir>")
+			os.Exit(1)
+		}
+		args := importCmd.Args()
+		logs, err := importLogs(args[:len(args)-1], args[len(args)-1], ImportOptions{Format: *importFormat, Image: *importImage, Binary: *importBinary})
+		for _, log := range logs {
+			fmt.Println("Imported", log)
+		}
+		if err != nil {
+			fmt.Println("import error:", err)
+			os.Exit(1)
+		}
+	case "container":
+		if len(os.Args) < 3 || os.Args[2] != "run" {
+			fmt.Println("container: missing arguments. Usage: container run --image <image> [--wrap <binaries>] -- <command> [args...]")
+			os.Exit(1)
+		}
+		containerRunCmd.Parse(os.Args[3:])
+		opts := ContainerOptions{Engine: *containerEngineName, Image: *containerImage, LogDir: *containerLogDir, Command: containerRunCmd.Args()}
+		if opts.LogDir == "" {
+			if opts.LogDir = os.Getenv("LOG_DIR"); opts.LogDir == "" {
+				opts.LogDir = defaultLogDir
+			}
+		}
+		if *containerWrap != "" {
+			opts.Wrap = splitList(*containerWrap)
+		}
+		status, err := containerRun(opts)
+		if err != nil {
+			fmt.Println("container error:", err)
+			os.Exit(1)
+		}
+		os.Exit(status)
+	case "agent":
+		agentCmd.Parse(os.Args[2:])
+		cfg, err := loadConfig()
+		if err != nil {
+			fmt.Println("agent error:", err)
+			os.Exit(1)
+		}
+		disable, err := cfg.Wrap.disableRules()
+		if err != nil {
+			fmt.Println("agent: config wrap.disable:", err)
+			os.Exit(1)
+		}
+		logName, err := parseLogName(cfg.Wrap.LogName)
+		if err != nil {
+			fmt.Println("agent: config wrap.log_name:", err)
+			os.Exit(1)
+		}
+		opts := AgentOptions{URL: cfg.Agent.URL, Token: cfg.Agent.Token, Host: cfg.Agent.Host, Product: cfg.Agent.Product, LogDir: os.Getenv("LOG_DIR"), Interval: 5 * time.Minute, Once: *agentOnce, Wrap: WrapOptions{Disable: disable, LogName: logName}}
+		if opts.LogDir == "" {
+			opts.LogDir = defaultLogDir
+		}
+		if cfg.Agent.Interval != "" {
+			if opts.Interval, err = time.ParseDuration(cfg.Agent.Interval); err != nil {
+				fmt.Println("agent: config agent.interval:", err)
+				os.Exit(1)
+			}
+		}
+		if *agentURL != "" {
+			opts.URL = *agentURL
+		}
+		if *agentHost != "" {
+			opts.Host = *agentHost
+		}
+		if *agentProduct != "" {
+			opts.Product = *agentProduct
+		}
+		if *agentInterval != 0 {
+			opts.Interval = *agentInterval
+		}
+		if err := runAgent(opts); err != nil {
+			fmt.Println("agent error:", err)
+			os.Exit(1)
+		}
+	case "generate-hooks":
+		generateHooksCmd.Parse(os.Args[2:])
+		if *generateHooksFormat == "" {
+			fmt.Println("generate-hooks: missing arguments. Usage: generate-hooks --format zypper|dnf|apt [--output <root>]")
+			os.Exit(1)
+		}
+		self, err := os.Executable()
+		if err == nil {
+			self, err = filepath.EvalSymlinks(self)
+		}
+		if err != nil {
+			fmt.Println("generate-hooks error: could not locate the funkoverage binary:", err)
+			os.Exit(1)
+		}
+		files, err := generateHooks(*generateHooksFormat, self, hookEnvironment())
+		if err == nil && *generateHooksOutput != "" {
+			err = writeHooks(files, *generateHooksOutput)
+		} else if err == nil {
+			for _, f := range files {
+				fmt.Printf("# %s\n%s\n", f.Path, f.Content)
+			}
+		}
+		if err != nil {
+			fmt.Println("generate-hooks error:", err)
+			os.Exit(1)
+		}
+	case "refresh":
+		refreshCmd.Parse(os.Args[2:])
+		if err := refreshWrappers(safeBinDir()); err != nil {
+			fmt.Println("refresh error:", err)
+			os.Exit(1)
+		}
+	case "recover":
+		recoverCmd.Parse(os.Args[2:])
+		manifest := *recoverManifest
+		if manifest == "" {
+			manifest = filepath.Join(safeBinDir(), manifestFileName)
+		}
+		if err := recoverWrappers(manifest, safeBinDir()); err != nil {
+			fmt.Println("recover error:", err)
+			os.Exit(1)
+		}
+	case "upgrade-wrappers":
+		upgradeWrappersCmd.Parse(os.Args[2:])
+		if err := upgradeWrappers(safeBinDir(), *upgradeDryRun); err != nil {
+			fmt.Println("upgrade-wrappers error:", err)
+			os.Exit(1)
+		}
+	case "start-window":
+		startWindowCmd.Parse(os.Args[2:])
+		if startWindowCmd.NArg() != 1 {
+			fmt.Println("start-window: expected one label")
+			os.Exit(1)
+		}
+		if err := startWindow(windowLogDir(*startWindowLogDir), startWindowCmd.Arg(0)); err != nil {
+			fmt.Println("start-window error:", err)
+			os.Exit(1)
+		}
+	case "stop-window":
+		stopWindowCmd.Parse(os.Args[2:])
+		if err := stopWindow(windowLogDir(*stopWindowLogDir), *stopWindowReset); err != nil {
+			fmt.Println("stop-window error:", err)
+			os.Exit(1)
+		}
+	case "ctl":
+		if len(os.Args) < 3 {
+			fmt.Println("ctl: missing command. Usage: ctl serve|enable <pattern>|disable <pattern>|reset|status")
+			os.Exit(1)
+		}
+		command := os.Args[2]
+		ctlCmd.Parse(os.Args[3:])
+		logDir := windowLogDir(*ctlLogDir)
+		if command == "serve" {
+			if err := serveControl(logDir, *ctlSocket); err != nil {
+				fmt.Println("ctl error:", err)
+				os.Exit(1)
+			}
+			return
+		}
+		req := controlRequest{Command: command}
+		switch command {
+		case "enable", "disable":
+			if ctlCmd.NArg() != 1 {
+				fmt.Printf("ctl %s: expected one binary name pattern\n", command)
+				os.Exit(1)
+			}
+			req.Pattern = ctlCmd.Arg(0)
+		case "reset", "status":
+		default:
+			fmt.Printf("ctl: unknown command %q. Usage: ctl serve|enable <pattern>|disable <pattern>|reset|status\n", command)
+			os.Exit(1)
+		}
+		rules, err := sendControl(logDir, *ctlSocket, req)
+		if err != nil {
+			fmt.Println("ctl error:", err)
+			os.Exit(1)
+		}
+		printControlRules(os.Stdout, rules)
+	case "reportd":
+		reportdCmd.Parse(os.Args[2:])
+		cfg, err := loadConfig()
+		if err != nil {
+			fmt.Println("reportd error:", err)
+			os.Exit(1)
+		}
+		opts, err := cfg.Reportd.options()
+		if err != nil {
+			fmt.Println("reportd: config", err)
+			os.Exit(1)
+		}
+		opts.Hooks = cfg.Hooks
+		if err := runReportd(opts, stopOnSignal()); err != nil {
+			fmt.Println("reportd error:", err)
+			os.Exit(1)
+		}
+	case "status":
+		statusCmd.Parse(os.Args[2:])
+		statuses, err := wrapStatus(safeBinDir(), statusCmd.Args())
+		if perr := printWrapStatus(os.Stdout, statuses, *statusJSON); err == nil {
+			err = perr
+		}
+		if err != nil {
+			fmt.Println("status error:", err)
+			os.Exit(1)
+		}
+	case "stap":
+		stapCmd.Parse(os.Args[2:])
+		opts := StapOptions{Stap: *stapPath, Binary: *stapBinary, LogDir: *stapLogDir, Pid: *stapPid, Command: stapCmd.Args()}
+		if opts.LogDir == "" {
+			if opts.LogDir = os.Getenv("LOG_DIR"); opts.LogDir == "" {
+				opts.LogDir = defaultLogDir
+			}
+		}
+		status, log, err := runStap(opts)
+		if err != nil {
+			fmt.Println("stap error:", err)
+			os.Exit(1)
+		}
+		fmt.Println("Wrote", log)
+		os.Exit(status)
+	case "fuzz-bridge":
+		fuzzBridgeCmd.Parse(os.Args[2:])
+		if fuzzBridgeCmd.NArg() < 1 {
+			fmt.Println("fuzz-bridge: missing arguments. Usage: fuzz-bridge [--map-size <bytes>] [--output <file>] -- <command> [args...]")
+			os.Exit(1)
+		}
+		status, err := fuzzBridge(FuzzBridgeOptions{MapSize: *fuzzMapSize, Output: *fuzzOutput, Command: fuzzBridgeCmd.Args()})
+		if err != nil {
+			fmt.Println("fuzz-bridge error:", err)
+			os.Exit(1)
+		}
+		os.Exit(status)
+	case "events":
+		eventsCmd.Parse(os.Args[2:])
+		filter := EventFilter{Binary: *eventsBinary, Event: *eventsKind}
+		switch filter.Event {
+		case "", activityStart, activityStop, activityFailure:
+		default:
+			fmt.Println("events: --event: expected start, stop or failure, got", filter.Event)
+			os.Exit(1)
+		}
+		if *eventsSince != "" {
+			since, err := parseEventSince(*eventsSince, time.Now())
+			if err != nil {
+				fmt.Println("events: --since:", err)
+				os.Exit(1)
+			}
+			filter.Since = since
+		}
+		events, err := wrapperEvents(*eventsFile, filter)
+		if err == nil {
+			err = printWrapperEvents(os.Stdout, events, *eventsJSON)
+		}
+		if err != nil {
+			fmt.Println("events error:", err)
+			os.Exit(1)
+		}
 	default:
 		fmt.Println("Unknown command:", os.Args[1])
 		fmt.Print(helpText)
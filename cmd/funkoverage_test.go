@@ -1,12 +1,30 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"debug/elf"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/ianlancetaylor/demangle"
 )
 
 // --- isELF tests ---
@@ -260,7 +278,7 @@ func TestWrapUnwrapLogic(t *testing.T) {
 		t.Fatal(err)
 	}
 	// Wrap
-	if err := wrap(orig); err != nil {
+	if err := wrap(orig, WrapOptions{}); err != nil {
 		t.Fatalf("wrap failed: %v", err)
 	}
 	// The wrapper should now exist and be a shell script
@@ -317,7 +335,7 @@ func TestWrapManyAndUnwrapMany(t *testing.T) {
 	}
 
 	// Wrap all binaries
-	if err := wrapMany([]string{bin1, bin2, bin3}); err != nil {
+	if err := wrapMany([]string{bin1, bin2, bin3}, WrapOptions{}); err != nil {
 		t.Fatalf("wrapMany failed: %v", err)
 	}
 	for _, bin := range []string{bin1, bin2, bin3} {
@@ -331,7 +349,7 @@ func TestWrapManyAndUnwrapMany(t *testing.T) {
 	}
 
 	// Unwrap all binaries
-	if err := unwrapMany([]string{bin1, bin2, bin3}); err != nil {
+	if err := unwrapMany([]string{bin1, bin2, bin3}, UnwrapOptions{}); err != nil {
 		t.Fatalf("unwrapMany failed: %v", err)
 	}
 	for _, bin := range []string{bin1, bin2, bin3} {
@@ -379,7 +397,7 @@ func TestWrapUnwrapMulticall(t *testing.T) {
 	}
 
 	// Wrap the symlink
-	if err := wrap(symlinkBin); err != nil {
+	if err := wrap(symlinkBin, WrapOptions{}); err != nil {
 		t.Fatalf("wrap failed: %v", err)
 	}
 
@@ -444,7 +462,7 @@ func TestUnwrapViaSymlink(t *testing.T) {
 	}
 
 	// Wrap the real binary directly
-	if err := wrap(realBin); err != nil {
+	if err := wrap(realBin, WrapOptions{}); err != nil {
 		t.Fatalf("wrap failed: %v", err)
 	}
 
@@ -475,7 +493,7 @@ func TestGenerateHTMLReportBaseName(t *testing.T) {
 		CalledFunctions: map[string]struct{}{"foo": {}},
 	}
 	imagePath := "/some/long/path/mybinary"
-	err := generateHTMLReport(imagePath, data, tmp)
+	err := generateHTMLReport(imagePath, data, false, HTMLOptions{}, tmp, time.Now())
 	if err != nil {
 		t.Fatalf("generateHTMLReport failed: %v", err)
 	}
@@ -575,3 +593,5485 @@ func TestSummarizeCoverage_MultipleImages(t *testing.T) {
 		t.Errorf("expected rows sorted by image name, got: %v", []string{summary.Rows[0].ImageName, summary.Rows[1].ImageName})
 	}
 }
+
+func TestLogTimestampFromFilename(t *testing.T) {
+	ts := logTimestamp("/logs/md5sum_20250709-104648_272113752.log")
+	want := time.Date(2025, 7, 9, 10, 46, 48, 0, time.Local)
+	if !ts.Equal(want) {
+		t.Errorf("expected %v, got %v", want, ts)
+	}
+}
+
+func TestServeGrafanaQuery(t *testing.T) {
+	tmp := t.TempDir()
+	first := "[Image:/bin/prog] [Function:foo]\n[Image:/bin/prog] [Function:bar]\n[Image:/bin/prog] [Called:foo]\n[Image:/bin/other] [Function:baz]\n"
+	second := "[Image:/bin/prog] [Function:foo]\n[Image:/bin/prog] [Function:bar]\n[Image:/bin/prog] [Called:bar]\n"
+	if err := os.WriteFile(filepath.Join(tmp, "prog_20250101-100000_1.log"), []byte(first), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmp, "prog_20250102-100000_1.log"), []byte(second), 0644); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(newServeMux(tmp))
+	defer srv.Close()
+
+	body := `{"targets":[{"target":"prog","type":"timeserie"},{"target":"uncalled","type":"table"},{"target":"prog","type":"table"},{"target":"total","type":"timeserie"}]}`
+	resp, err := srv.Client().Post(srv.URL+"/query", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var result []json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 4 {
+		t.Fatalf("expected 4 results, got %d", len(result))
+	}
+	var series grafanaSeries
+	if err := json.Unmarshal(result[0], &series); err != nil {
+		t.Fatal(err)
+	}
+	if len(series.Datapoints) != 2 || series.Datapoints[0][0] != 50 || series.Datapoints[1][0] != 100 {
+		t.Errorf("unexpected datapoints: %v", series.Datapoints)
+	}
+	var table grafanaTable
+	if err := json.Unmarshal(result[1], &table); err != nil {
+		t.Fatal(err)
+	}
+	if len(table.Rows) != 1 || table.Rows[0][0] != "other" || table.Rows[0][1] != "baz" {
+		t.Errorf("expected the uncalled baz of other, got %v", table.Rows)
+	}
+	// A table target naming an image only lists the uncalled functions of that image
+	if err := json.Unmarshal(result[2], &table); err != nil {
+		t.Fatal(err)
+	}
+	if len(table.Rows) != 0 {
+		t.Errorf("expected no uncalled functions in prog, got %v", table.Rows)
+	}
+	if err := json.Unmarshal(result[3], &series); err != nil {
+		t.Fatal(err)
+	}
+	if len(series.Datapoints) != 2 || series.Datapoints[0][0] != float64(1)/3*100 || series.Datapoints[1][0] != float64(2)/3*100 {
+		t.Errorf("unexpected total datapoints: %v", series.Datapoints)
+	}
+
+	// The incremental totals match a summary of all the logs
+	logFiles, err := collectLogFiles(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeline, err := coverageTimeline(newLogCache(AnalyzeOptions{}), logFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coverage, err := analyzeLogs(logFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := timeline[len(timeline)-1].Summary, summarizeCoverage(coverage); !reflect.DeepEqual(got, want) {
+		t.Errorf("timeline ends at %+v, expected %+v", got, want)
+	}
+}
+
+func TestCoverageAlerts(t *testing.T) {
+	cfg := NotifyConfig{MinCoverage: 50, MaxDrop: 1}
+	baseline := &CoverageTotals{Rows: []CoverageSummary{
+		{ImageName: "/bin/a", CoveragePct: 80},
+		{ImageName: "/bin/b", CoveragePct: 40},
+	}}
+	current := CoverageTotals{
+		Rows: []CoverageSummary{
+			{ImageName: "/bin/a", CoveragePct: 60},
+			{ImageName: "/bin/b", CoveragePct: 39.5},
+		},
+		AverageCoverage: 45,
+	}
+	alerts := coverageAlerts(cfg, current, baseline)
+	if len(alerts) != 2 {
+		t.Fatalf("expected 2 alerts, got %v", alerts)
+	}
+	if !strings.Contains(alerts[1], "a regressed") {
+		t.Errorf("expected regression alert for a, got %q", alerts[1])
+	}
+}
+
+func TestNotifyRegressionsWebhook(t *testing.T) {
+	var received map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer srv.Close()
+
+	baselineFile := filepath.Join(t.TempDir(), "baseline.json")
+	cfg := NotifyConfig{MinCoverage: 90, Webhook: srv.URL, BaselineFile: baselineFile}
+	current := CoverageTotals{AverageCoverage: 50}
+	if err := notifyRegressions(cfg, current); err != nil {
+		t.Fatalf("notifyRegressions failed: %v", err)
+	}
+	if received == nil || !strings.Contains(received["text"].(string), "below") {
+		t.Errorf("expected threshold alert to be posted, got %v", received)
+	}
+	if _, err := loadCoverageTotals(baselineFile); err != nil {
+		t.Errorf("expected baseline to be saved: %v", err)
+	}
+}
+
+func TestNotifyBaseline(t *testing.T) {
+	failing := true
+	posts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posts++
+		if failing {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	baselineFile := filepath.Join(t.TempDir(), "baseline.json")
+	cfg := NotifyConfig{MaxDrop: 1, Webhook: srv.URL, BaselineFile: baselineFile}
+	run := func(pct float64) error {
+		return notifyRegressions(cfg, CoverageTotals{Rows: []CoverageSummary{{ImageName: "/usr/bin/a", CoveragePct: pct}}})
+	}
+	baseline := func() float64 {
+		b, err := loadCoverageTotals(baselineFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b.Rows[0].CoveragePct
+	}
+	if err := run(50); err != nil {
+		t.Fatal(err)
+	}
+	// Each step stays within max_drop, but the decline adds up.
+	for _, pct := range []float64{49.5, 49} {
+		if err := run(pct); err != nil || posts != 0 || baseline() != 50 {
+			t.Fatalf("expected no alert and the baseline kept at 50 for %.1f, got %v, %d posts, %.1f", pct, err, posts, baseline())
+		}
+	}
+	// A failed delivery keeps the baseline, and the alert is sent again.
+	if err := run(48.5); err == nil || posts != 1 || baseline() != 50 {
+		t.Fatalf("expected the failed alert to keep the baseline, got %v, %d posts, %.1f", err, posts, baseline())
+	}
+	failing = false
+	if err := run(48.5); err != nil || posts != 2 || baseline() != 48.5 {
+		t.Fatalf("expected the delivered alert to move the baseline, got %v, %d posts, %.1f", err, posts, baseline())
+	}
+	if err := run(60); err != nil || posts != 2 || baseline() != 60 {
+		t.Errorf("expected an improvement to move the baseline, got %v, %d posts, %.1f", err, posts, baseline())
+	}
+}
+
+func TestCollectorUpload(t *testing.T) {
+	store := t.TempDir()
+	srv := httptest.NewServer(newCollectorMux(store, CollectorConfig{Token: "s3cret"}))
+	defer srv.Close()
+
+	upload := func(token string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/api/logs?host=vm1&name=../prog_1.log", strings.NewReader("[Image:prog] [Function:foo]\n"))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	if resp := upload("wrong"); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401 with a wrong token, got %d", resp.StatusCode)
+	}
+	if resp := upload("s3cret"); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", resp.StatusCode)
+	}
+	upload("s3cret")
+	for _, name := range []string{"vm1_prog_1.log", "vm1_prog_1.1.log"} {
+		if _, err := os.Stat(filepath.Join(store, name)); err != nil {
+			t.Errorf("expected %s to be stored: %v", name, err)
+		}
+	}
+	for _, path := range []string{"/dashboard", "/api/dashboard"} {
+		// The token alone, without the Bearer scheme, is refused.
+		for header, want := range map[string]int{"Bearer wrong": http.StatusUnauthorized, "Bearer s3cret": http.StatusOK, "s3cret": http.StatusUnauthorized} {
+			req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+			req.Header.Set("Authorization", header)
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != want {
+				t.Errorf("expected %d for %s with %q, got %d", want, path, header, resp.StatusCode)
+			}
+		}
+	}
+
+	// Logs beyond the limit are refused, not cut short.
+	defer func(size int64) { maxUploadSize = size }(maxUploadSize)
+	maxUploadSize = 8
+	if resp := upload("s3cret"); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 for a log beyond the limit, got %d", resp.StatusCode)
+	}
+	if _, err := os.Stat(filepath.Join(store, "vm1_prog_1.2.log")); err == nil {
+		t.Error("expected the partial log to be removed")
+	}
+}
+
+func TestTagHostLogs(t *testing.T) {
+	tmp := t.TempDir()
+	staging := filepath.Join(tmp, stagingDirName, "vm1")
+	if err := os.MkdirAll(staging, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"ls_1.log", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(staging, name), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tag := hostTag("root@vm1.example.com"); tag != "vm1.example.com" {
+		t.Errorf("unexpected host tag %q", tag)
+	}
+	added, err := tagHostLogs(staging, tmp, "vm1")
+	if err != nil || added != 1 {
+		t.Fatalf("expected 1 log tagged, got %d (err: %v)", added, err)
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "vm1_ls_1.log")); err != nil {
+		t.Errorf("tagged log missing: %v", err)
+	}
+	// A second pass must not duplicate anything
+	if added, _ := tagHostLogs(staging, tmp, "vm1"); added != 0 {
+		t.Errorf("expected no new logs on second pass, got %d", added)
+	}
+}
+
+func TestBuildDashboard(t *testing.T) {
+	store := t.TempDir()
+	logs := map[string]string{
+		"vm1_a.log": "[Image:prog] [Function:foo]\n[Image:prog] [Function:bar]\n[Image:prog] [Called:foo]\n",
+		"vm2_a.log": "[Image:prog] [Function:foo]\n[Image:prog] [Function:bar]\n[Image:prog] [Called:bar]\n",
+	}
+	for name, content := range logs {
+		if err := os.WriteFile(filepath.Join(store, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	appendUploadRecord(store, UploadRecord{File: "vm1_a.log", Host: "vm1", Product: "p", Run: "rc1"})
+	appendUploadRecord(store, UploadRecord{File: "vm2_a.log", Host: "vm2", Product: "p", Run: "rc1"})
+
+	cache := newLogCache(AnalyzeOptions{})
+	// The second build merges the cached coverage, which the first must not have changed
+	for i := 0; i < 2; i++ {
+		data, err := buildDashboard(store, "p", cache)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data.Hosts) != 2 || data.Hosts[0].Percentage != 50 || data.Hosts[1].Percentage != 50 {
+			t.Errorf("unexpected host rows: %+v", data.Hosts)
+		}
+		if len(data.Runs) != 1 || data.Runs[0].Hosts != 2 || data.Runs[0].Percentage != 100 {
+			t.Errorf("unexpected run rows: %+v", data.Runs)
+		}
+		if data.Overall.Percentage != 100 {
+			t.Errorf("expected merged coverage of 100%%, got %f", data.Overall.Percentage)
+		}
+	}
+	if len(cache.entries) != 2 {
+		t.Errorf("expected the 2 logs cached, got %d", len(cache.entries))
+	}
+
+	// A log rewritten since is analyzed again, a removed one leaves the cache
+	if err := os.WriteFile(filepath.Join(store, "vm1_a.log"), []byte(logs["vm1_a.log"]+"[Image:prog] [Called:bar]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(store, "vm2_a.log"))
+	data, err := buildDashboard(store, "p", cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Hosts) != 1 || data.Hosts[0].Percentage != 100 {
+		t.Errorf("rewritten log not reanalyzed: %+v", data.Hosts)
+	}
+	if _, ok := cache.entries[filepath.Join(store, "vm2_a.log")]; ok || len(cache.entries) != 1 {
+		t.Errorf("removed log still cached: %d entries", len(cache.entries))
+	}
+}
+
+func TestCoverageEventsFirstHit(t *testing.T) {
+	tmp := t.TempDir()
+	first := filepath.Join(tmp, "prog_20250101-100000_1.log")
+	second := filepath.Join(tmp, "prog_20250102-100000_1.log")
+	if err := os.WriteFile(first, []byte("[Image:prog] [Function:foo]\n[Image:prog] [Called:foo]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(second, []byte("[Image:prog] [Called:foo]\n[Image:prog] [Called:bar]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	analyze := func(logs ...string) []LogStats {
+		_, stats, err := analyzeLogsWith(logs, AnalyzeOptions{KeepLogCoverage: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stats
+	}
+	state := &eventState{Buses: map[string]*busState{}}
+	events := coverageEvents(analyze(second, first), state.bus("kafka_rest"))
+	var types []string
+	for _, ev := range events {
+		types = append(types, ev.Type+":"+ev.Function)
+	}
+	want := "function_hit:foo run_summary: function_hit:bar run_summary:"
+	if got := strings.Join(types, " "); got != want {
+		t.Errorf("expected events %q, got %q", want, got)
+	}
+
+	// Later runs only publish the new logs and first calls, in batches.
+	third := filepath.Join(tmp, "prog_20250103-100000_1.log")
+	if err := os.WriteFile(third, []byte("[Image:prog] [Called:foo]\n[Image:prog] [Called:baz]\n[Image:other] [Called:main]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(size int) { eventBatchSize = size }(eventBatchSize)
+	eventBatchSize = 2
+	var batches []int
+	kafkaDown := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if kafkaDown {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		var body struct{ Records []json.RawMessage }
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		batches = append(batches, len(body.Records))
+	}))
+	defer srv.Close()
+	cfg := EventsConfig{KafkaREST: KafkaRESTConfig{URL: srv.URL}, StateFile: filepath.Join(tmp, "state", "events.json")}
+	for _, logs := range [][]string{{first, second}, {first, second, third}, {first, second, third}} {
+		if err := publishEvents(cfg, analyze(logs...), tmp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(batches, []int{2, 2, 2, 1}) {
+		t.Errorf("expected 4 then 3 new events in batches of 2, got %v", batches)
+	}
+
+	// A NATS bus added later gets every event over a single connection, and
+	// does not get them again when the Kafka proxy failed meanwhile.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var mu sync.Mutex
+	conns, published := 0, 0
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns++
+			mu.Unlock()
+			go func() {
+				defer conn.Close()
+				fmt.Fprint(conn, "INFO {}\r\n")
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					switch {
+					case strings.HasPrefix(line, "PUB "):
+						if _, err := r.ReadString('\n'); err != nil {
+							return
+						}
+						mu.Lock()
+						published++
+						mu.Unlock()
+					case strings.HasPrefix(line, "PING"):
+						fmt.Fprint(conn, "PONG\r\n")
+					}
+				}
+			}()
+		}
+	}()
+	cfg.NATS = NATSConfig{URL: "nats://" + ln.Addr().String()}
+	fourth := filepath.Join(tmp, "prog_20250104-100000_1.log")
+	if err := os.WriteFile(fourth, []byte("[Image:prog] [Called:qux]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	kafkaDown = true
+	if err := publishEvents(cfg, analyze(first, second, third, fourth), tmp); err == nil || !strings.Contains(err.Error(), "kafka") {
+		t.Errorf("expected the kafka failure, got %v", err)
+	}
+	kafkaDown = false
+	if err := publishEvents(cfg, analyze(first, second, third, fourth), tmp); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if conns != 1 || published != 9 {
+		t.Errorf("expected the 9 events over 1 NATS connection, got %d over %d", published, conns)
+	}
+	if !reflect.DeepEqual(batches, []int{2, 2, 2, 1, 2}) {
+		t.Errorf("expected kafka to only get the 2 events of the fourth log, got %v", batches)
+	}
+
+	// A state saved before the buses were tracked apart is resumed by each bus
+	legacy := filepath.Join(tmp, "legacy.json")
+	if err := os.WriteFile(legacy, []byte(`{"logs":{"`+first+`":true},"hits":{"prog":{"foo":true}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old, err := loadEventState(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events := coverageEvents(analyze(first, second), old.bus("nats")); len(events) != 2 || events[0].Function != "bar" {
+		t.Errorf("expected the events of the second log only, got %+v", events)
+	}
+}
+
+func TestDeliverWebhookRetryAndSignature(t *testing.T) {
+	orig := webhookBackoff
+	webhookBackoff = time.Millisecond
+	defer func() { webhookBackoff = orig }()
+
+	attempts := 0
+	var signature string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		signature = r.Header.Get(signatureHeader)
+	}))
+	defer srv.Close()
+
+	body := []byte(`{"event":"report"}`)
+	if err := deliverWebhook(WebhookConfig{URL: srv.URL, Secret: "k"}, body); err != nil {
+		t.Fatalf("deliverWebhook failed: %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("expected 3 attempts, got %d", attempts)
+	}
+	if signature != signPayload("k", body) {
+		t.Errorf("unexpected signature %q", signature)
+	}
+}
+
+func TestGroupCoverageByPackage(t *testing.T) {
+	coverage := map[string]*CoverageData{
+		"/usr/lib64/libfoo.so.1": {
+			TotalFunctions:  map[string]struct{}{"init": {}, "run": {}},
+			CalledFunctions: map[string]struct{}{"init": {}},
+		},
+		"/usr/bin/foo": {
+			TotalFunctions:  map[string]struct{}{"init": {}},
+			CalledFunctions: map[string]struct{}{},
+		},
+		"/opt/custom": {
+			TotalFunctions:  map[string]struct{}{"x": {}},
+			CalledFunctions: map[string]struct{}{"x": {}},
+		},
+	}
+	packages := map[string]string{"/usr/lib64/libfoo.so.1": "foo", "/usr/bin/foo": "foo"}
+	grouped := groupCoverageByPackage(coverage, packages)
+	if len(grouped) != 2 {
+		t.Fatalf("expected 2 groups, got %d", len(grouped))
+	}
+	foo := grouped["foo"]
+	if len(foo.TotalFunctions) != 3 || len(foo.CalledFunctions) != 1 {
+		t.Errorf("expected 3 total / 1 called in foo, got %d / %d", len(foo.TotalFunctions), len(foo.CalledFunctions))
+	}
+	if _, ok := grouped[unpackagedGroup]; !ok {
+		t.Error("expected unpackaged group for /opt/custom")
+	}
+}
+
+func TestResolvePackageUsesManifest(t *testing.T) {
+	orig := packageResolver
+	defer func() { packageResolver = orig }()
+	// An earlier run (go test -count) cached the package of the path
+	packageCacheMu.Lock()
+	delete(packageCache, "/usr/bin/md5sum-test")
+	packageCacheMu.Unlock()
+	var asked string
+	packageResolver = func(path string) string {
+		asked = path
+		return "coreutils"
+	}
+	m := &Manifest{}
+	m.put(ManifestEntry{Path: "/usr/bin/md5sum-test", Backup: "/var/coverage/bin/123/md5sum-test"})
+	if pkg := resolvePackage("/var/coverage/bin/123/md5sum-test", m); pkg != "coreutils" {
+		t.Errorf("expected coreutils, got %q", pkg)
+	}
+	if asked != "/usr/bin/md5sum-test" {
+		t.Errorf("expected lookup of the original path, got %q", asked)
+	}
+}
+
+func TestSourcePackages(t *testing.T) {
+	for line, want := range map[string]string{
+		"libopenssl3 openssl-3.1.4-9.1.src.rpm":    "openssl",
+		"glibc-devel glibc-2.39-12.fc40.src.rpm":   "glibc",
+		"gpg-pubkey (none)":                        "gpg-pubkey",
+		"foo foo-1.0-1.nosrc.rpm":                  "foo",
+		"python3-bar python-bar-2.0-1.el9.src.rpm": "python-bar",
+	} {
+		if got := rpmSourcePackage(line); got != want {
+			t.Errorf("rpmSourcePackage(%q) = %q, want %q", line, got, want)
+		}
+	}
+
+	orig := packageResolver
+	defer func() { packageResolver = orig }()
+	packageResolver = func(path string) string {
+		t.Errorf("unexpected package query for %s", path)
+		return ""
+	}
+	m := &Manifest{}
+	m.put(ManifestEntry{Path: "/usr/bin/openssl-test", Backup: "/var/coverage/bin/7/openssl-test", Package: "openssl"})
+	if pkg := resolvePackage("/var/coverage/bin/7/openssl-test", m); pkg != "openssl" {
+		t.Errorf("expected the package of the manifest, got %q", pkg)
+	}
+}
+
+func TestWrapRecordsManifest(t *testing.T) {
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found")
+	}
+	tmp := t.TempDir()
+	os.Setenv("PIN_ROOT", "/tmp/pin")
+	os.Setenv("PIN_TOOL_SEARCH_DIR", tmp)
+	os.Setenv("SAFE_BIN_DIR", tmp)
+	os.Setenv("LOG_DIR", tmp)
+	if err := os.WriteFile(filepath.Join(tmp, "FuncTracer.so"), []byte("dummy"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(tmp, "main.c")
+	if err := os.WriteFile(src, []byte("int main() { return 0; }"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bin := filepath.Join(tmp, "bin")
+	if out, err := exec.Command("gcc", "-g", "-Wl,--build-id", "-o", bin, src).CombinedOutput(); err != nil {
+		t.Fatalf("failed to compile: %v\n%s", err, out)
+	}
+	f, err := elf.Open(bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buildID, _ := getBuildID(f)
+	f.Close()
+	origPackage, origVersion := packageResolver, packageVersionResolver
+	defer func() { packageResolver, packageVersionResolver = origPackage, origVersion }()
+	packageResolver = func(string) string { return "openssl" }
+	packageVersionResolver = func(string) (string, string) { return "libopenssl3", "3.1.4-9.1" }
+	if err := wrap(bin, WrapOptions{}); err != nil {
+		t.Fatalf("wrap failed: %v", err)
+	}
+	m, err := loadManifest(tmp)
+	if err != nil || len(m.Entries) != 1 || m.Entries[0].Path != bin {
+		t.Fatalf("expected manifest entry for %s, got %+v (err: %v)", bin, m, err)
+	}
+	if e := m.Entries[0]; buildID == "" || e.BuildID != buildID || e.PackageName != "libopenssl3" || e.PackageVersion != "3.1.4-9.1" {
+		t.Errorf("expected the build ID %s and package version in the manifest, got %+v", buildID, e)
+	}
+	statuses, err := wrapStatus(tmp, []string{bin})
+	if err != nil || len(statuses) != 1 || statuses[0].State != "wrapped" {
+		t.Fatalf("status = %+v (err: %v)", statuses, err)
+	}
+	var out bytes.Buffer
+	printWrapStatus(&out, statuses, false)
+	for _, s := range []string{bin + " (wrapped)\n", "  build ID:  " + buildID + "\n", "  package:   libopenssl3 3.1.4-9.1 (source openssl)\n"} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("expected %q in the status:\n%s", s, out.String())
+		}
+	}
+	if _, err := wrapStatus(tmp, []string{filepath.Join(tmp, "other")}); err == nil {
+		t.Error("expected an error for a binary missing from the manifest")
+	}
+	if err := unwrap(bin); err != nil {
+		t.Fatalf("unwrap failed: %v", err)
+	}
+	if m, _ := loadManifest(tmp); len(m.Entries) != 0 {
+		t.Errorf("expected manifest entry to be removed, got %+v", m.Entries)
+	}
+}
+
+func TestParseLogLine(t *testing.T) {
+	cases := []struct {
+		line           string
+		kind           lineKind
+		image, funcStr string
+	}{
+		{" [tid:1] [Image:/bin/ls] [Function:main]", lineFunction, "/bin/ls", "main"},
+		{"[PID:7] [Image:/bin/ls] [Called: foo ]", lineCalled, "/bin/ls", "foo"},
+		{"[Image:/bin/ls] [Section:.text]", lineOther, "", ""},
+		{"[Image:/bin/ls] [Function:truncated", lineOther, "", ""},
+		{"garbage", lineOther, "", ""},
+		{"[Image:/bin/ls] [Function:Vec::operator[](unsigned long)]", lineFunction, "/bin/ls", "Vec::operator[](unsigned long)"},
+		{`[Image:/opt/a\x5d b] [Called:Vec::operator\x5b\x5d(unsigned long)]`, lineCalled, "/opt/a] b", "Vec::operator[](unsigned long)"},
+		{`[Image:/bin/ls] [Function:a\x5cb\x0ac\xzz]`, lineFunction, "/bin/ls", "a\\b\nc\\xzz"},
+	}
+	for _, c := range cases {
+		kind, image, function, _, _ := parseLogLine([]byte(c.line))
+		if kind != c.kind || string(image) != c.image || string(function) != c.funcStr {
+			t.Errorf("parseLogLine(%q) = %v %q %q, want %v %q %q", c.line, kind, image, function, c.kind, c.image, c.funcStr)
+		}
+	}
+	kind, image, function, addr, _ := parseLogLine([]byte("[Image:/bin/ls] [Addr:0x1a40] [Function:init]"))
+	if kind != lineFunction || string(image) != "/bin/ls" || string(function) != "init" || string(addr) != "0x1a40" {
+		t.Errorf("unexpected parse of a format 4 line: %v %q %q %q", kind, image, function, addr)
+	}
+	kind, image, function, addr, count := parseLogLine([]byte("[Image:/bin/ls] [Addr:0x1a40] [Count:42] [Called:init]"))
+	if kind != lineCalled || string(image) != "/bin/ls" || string(function) != "init" || string(addr) != "0x1a40" || count != 42 {
+		t.Errorf("unexpected parse of a format 5 line: %v %q %q %q %d", kind, image, function, addr, count)
+	}
+}
+
+func TestAnalyzeLogsFormatHeader(t *testing.T) {
+	tmp := t.TempDir()
+	v2 := filepath.Join(tmp, "v2.log")
+	content := " [tid:1] [FuncTracer] [Format:2]\n [tid:1] [Image:prog] [Function:foo]\n [tid:1] [Image:prog] [Called:foo]\n"
+	if err := os.WriteFile(v2, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	coverage, err := analyzeLogs([]string{v2})
+	if err != nil {
+		t.Fatalf("analyzeLogs failed on format 2 log: %v", err)
+	}
+	if len(coverage["prog"].CalledFunctions) != 1 {
+		t.Errorf("expected foo to be called")
+	}
+
+	future := filepath.Join(tmp, "future.log")
+	if err := os.WriteFile(future, []byte("[FuncTracer] [Format:99]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := analyzeLogs([]string{future}); err == nil || !strings.Contains(err.Error(), "newer than the supported") {
+		t.Errorf("expected an error for an unsupported log format, got %v", err)
+	}
+	unknown := filepath.Join(tmp, "unknown.log")
+	if err := os.WriteFile(unknown, []byte("[FuncTracer] [Format:0]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := analyzeLogs([]string{unknown}); err == nil || !strings.Contains(err.Error(), "unknown format 0") {
+		t.Errorf("expected an error for an unknown log format, got %v", err)
+	}
+
+	v11 := filepath.Join(tmp, "v11.log")
+	content = "[FuncTracer] [Format:11] [Tool:0.6.3] [Run:1-2] [Options:-edges -api libssl.so.3]\n[Image:prog] [Function:foo]\n"
+	if err := os.WriteFile(v11, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, stats, err := analyzeLogsWith([]string{v11}, AnalyzeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := stats[0]; s.Format != 11 || s.Tool != "0.6.3" || s.Options != "-edges -api libssl.so.3" {
+		t.Errorf("expected the tracer of the header, got format %d, tool %q, options %q", s.Format, s.Tool, s.Options)
+	}
+}
+
+func TestJSONLinesLog(t *testing.T) {
+	tmp := t.TempDir()
+	bracketed := filepath.Join(tmp, "prog_1.log")
+	content := "[FuncTracer] [Format:11] [Tool:0.6.3] [Run:1f-abc] [Session:smoke] [Tags:nightly,x86]\n" +
+		"[FuncTracer] [Context] [Time:2025-07-09T10:46:48Z] [Host:vm1] [Cwd:/tmp] [Arg:prog] [Arg:-v] [Env:USER=me]\n" +
+		"[Image:/usr/bin/prog] [BuildID:ab12]\n" +
+		"[Image:/usr/bin/prog] [Addr:0x10] [Function:operator\\x5b\\x5d]\n" +
+		"[Image:/usr/bin/prog] [Addr:0x20] [Function:main]\n" +
+		"[Image:/usr/bin/prog] [Addr:0x30] [Function:helper]\n" +
+		"[Image:/usr/bin/prog] [Count:1] [Caller:main] [Callee:operator\\x5b\\x5d]\n" +
+		"[Image:/usr/bin/prog] [Addr:0x20] [Count:1] [Called:main]\n" +
+		"[Image:/usr/bin/prog] [Addr:0x10] [Count:4] [Called:operator\\x5b\\x5d]\n" +
+		"[FuncTracer] [Exit:0] [WallMs:12] [Started:1]\n"
+	jsonl := filepath.Join(tmp, "prog_2.log")
+	jsonContent := `{"event":"header","format":11,"tool":"0.6.3","run":"1f-abd","session":"smoke","tags":["nightly","x86"]}
+{"event":"context","time":"2025-07-09T10:46:48Z","host":"vm1","cwd":"/tmp","args":["prog","-v"],"env":["USER=me"]}
+{"event":"build_id","image":"/usr/bin/prog","build_id":"ab12"}
+{"event":"section","image":"/usr/bin/prog","section":".text"}
+{"event":"function","image":"/usr/bin/prog","addr":"0x10","function":"operator[]"}
+{"event":"function","image":"/usr/bin/prog","addr":"0x20","function":"m\u0061in"}
+{ "event" : "function", "image":"/usr/bin/prog", "addr":"0x30", "function":"helper", "future":{"a":[1,"]"]} }
+{"event":"edge","image":"/usr/bin/prog","count":1,"caller":"main","callee":"operator[]"}
+{"event":"called","image":"/usr/bin/prog","addr":"0x20","count":1,"function":"main"}
+{"event":"called","image":"/usr/bin/prog","addr":"0x10","count":4,"function":"operator[]"}
+{"event":"called","image":"/usr/bin/prog","function":
+{"event":"exit","code":0,"wall_ms":12,"started":1}
+`
+	if err := os.WriteFile(bracketed, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(jsonl, []byte(jsonContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+	want, wantStats, err := analyzeLogsWith([]string{bracketed}, AnalyzeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, stats, err := analyzeLogsWith([]string{jsonl}, AnalyzeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the coverage of the bracketed log %+v, got %+v", want["/usr/bin/prog"], got["/usr/bin/prog"])
+	}
+	s, w := stats[0], wantStats[0]
+	if s.Malformed != 1 {
+		t.Errorf("expected the truncated line to be malformed, got %d", s.Malformed)
+	}
+	if s.Format != 11 || s.Tool != "0.6.3" || s.RunID != "1f-abd" || s.Session != w.Session || !reflect.DeepEqual(s.Tags, w.Tags) ||
+		!reflect.DeepEqual(s.Context, w.Context) || !reflect.DeepEqual(s.BuildIDs, w.BuildIDs) || s.Exit == nil || s.Exit.Wall != w.Exit.Wall {
+		t.Errorf("expected the stats of the bracketed log %+v, got %+v", w, s)
+	}
+	if session, tags, _ := readLogLabels(jsonl); session != "smoke" || len(tags) != 2 {
+		t.Errorf("expected the labels of the header, got %q %q", session, tags)
+	}
+
+	future := filepath.Join(tmp, "future.log")
+	if err := os.WriteFile(future, []byte(`{"event":"header","format":99}`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := analyzeLogs([]string{future}); err == nil || !strings.Contains(err.Error(), "newer than the supported") {
+		t.Errorf("expected an error for an unsupported log format, got %v", err)
+	}
+}
+
+func TestProtobufLog(t *testing.T) {
+	tmp := t.TempDir()
+	bracketed := filepath.Join(tmp, "prog_1.log")
+	content := "[FuncTracer] [Format:11] [Tool:0.6.3] [Run:1f-abc] [Session:smoke] [Tags:nightly,x86]\n" +
+		"[Image:/usr/bin/prog] [BuildID:ab12]\n" +
+		"[Image:/usr/bin/prog] [Addr:0x10] [Function:operator\\x5b\\x5d]\n" +
+		"[Image:/usr/bin/prog] [Addr:0x20] [Function:main]\n" +
+		"[Image:/usr/bin/prog] [Count:1] [Caller:main] [Callee:operator\\x5b\\x5d]\n" +
+		"[Image:/usr/bin/prog] [Addr:0x20] [Count:1] [Called:main]\n" +
+		"[FuncTracer] [Exit:0] [WallMs:12] [Started:1]\n"
+	if err := os.WriteFile(bracketed, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Messages are built field by field: strings are length-delimited,
+	// numbers are varints.
+	type field struct {
+		n   int
+		val any
+	}
+	message := func(fields ...field) []byte {
+		var b []byte
+		for _, f := range fields {
+			switch v := f.val.(type) {
+			case string:
+				b = appendProtoBytes(b, f.n, []byte(v))
+			case uint64:
+				b = binary.AppendUvarint(binary.AppendUvarint(b, uint64(f.n)<<3), v)
+			}
+		}
+		return b
+	}
+	var log []byte
+	record := func(kind int, fields ...field) {
+		log = appendProtoBytes(log, 16, appendProtoBytes(nil, kind, message(fields...)))
+	}
+	record(protoHeader, field{1, uint64(11)}, field{2, "0.6.3"}, field{3, "1f-abd"}, field{5, "smoke"}, field{6, "nightly"}, field{6, "x86"}, field{99, "future"})
+	record(protoImage, field{1, "/usr/bin/prog"}, field{2, "ab12"})
+	record(protoFunction, field{1, "/usr/bin/prog"}, field{2, uint64(0x10)}, field{4, "operator[]"})
+	record(protoFunction, field{1, "/usr/bin/prog"}, field{2, uint64(0x20)}, field{4, "main"})
+	record(protoEdge, field{1, "/usr/bin/prog"}, field{2, uint64(1)}, field{3, "main"}, field{4, "operator[]"})
+	record(protoCalled, field{1, "/usr/bin/prog"}, field{2, uint64(0x20)}, field{3, uint64(1)}, field{4, "main"})
+	log = appendProtoExit(log, 0, 12*time.Millisecond, time.Unix(0, 1))
+	// A record cut short by a crash ends the log, which is no longer written.
+	log = append(log, 0x82, 0x01, 0x20, 0x1a)
+	proto := filepath.Join(tmp, "prog_2.log")
+	if err := os.WriteFile(proto, log, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !endsMidLine(proto, int64(len(log))) {
+		t.Error("expected the cut record to end the log mid-record")
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(proto, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	want, wantStats, err := analyzeLogsWith([]string{bracketed}, AnalyzeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, stats, err := analyzeLogsWith([]string{proto}, AnalyzeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the coverage of the bracketed log %+v, got %+v", want["/usr/bin/prog"], got["/usr/bin/prog"])
+	}
+	s, w := stats[0], wantStats[0]
+	if s.Malformed != 1 {
+		t.Errorf("expected the truncated record to be malformed, got %d", s.Malformed)
+	}
+	if s.Format != 11 || s.Tool != "0.6.3" || s.RunID != "1f-abd" || s.Session != w.Session || !reflect.DeepEqual(s.Tags, w.Tags) ||
+		!reflect.DeepEqual(s.BuildIDs, w.BuildIDs) || s.Exit == nil || s.Exit.Wall != w.Exit.Wall {
+		t.Errorf("expected the stats of the bracketed log %+v, got %+v", w, s)
+	}
+	if session, tags, _ := readLogLabels(proto); session != "smoke" || len(tags) != 2 {
+		t.Errorf("expected the labels of the header, got %q %q", session, tags)
+	}
+}
+
+func TestStreamCollector(t *testing.T) {
+	tmp := t.TempDir()
+	streamDir, filesDir := filepath.Join(tmp, "stream"), filepath.Join(tmp, "files")
+	for _, dir := range []string{streamDir, filesDir} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	invocation := func(run string, called string) []string {
+		return []string{
+			"[FuncTracer] [Format:11] [Tool:0.6.3] [Run:" + run + "]\n" +
+				"[Image:/usr/bin/prog] [Addr:0x10] [Function:main]\n" +
+				"[Image:/usr/bin/prog] [Addr:0x20] [Function:helper]\n",
+			"[Image:/usr/bin/prog] [Addr:" + called + "] [Count:1] [Called:" + map[string]string{"0x10": "main", "0x20": "helper"}[called] + "]\n",
+			"[FuncTracer] [Exit:0] [WallMs:3] [Started:1]\n",
+		}
+	}
+	c := &streamCollector{opts: StreamOptions{LogDir: streamDir, MaxSize: 1 << 20, MaxAge: time.Hour}, logs: map[streamKey]*streamLog{}}
+	for i, called := range []string{"0x10", "0x20"} {
+		blocks := invocation(fmt.Sprintf("1f-%d", i), called)
+		var conn bytes.Buffer
+		conn.WriteString("[FuncTracer] [Stream:prog]\n")
+		for _, block := range blocks {
+			fmt.Fprintf(&conn, "%d\n%s", len(block), block)
+		}
+		// A block cut short by the process dying is dropped.
+		conn.WriteString("100\n[Image:/usr/bin/prog] [Addr:0x30] [Count:1] [Called:x]\n")
+		if err := c.receive(&conn); err != nil {
+			t.Fatal(err)
+		}
+		name := filepath.Join(filesDir, fmt.Sprintf("prog_20250709-10464%d_1.log", i))
+		if err := os.WriteFile(name, []byte(strings.Join(blocks, "")), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.receive(strings.NewReader("GET / HTTP/1.0\n")); err == nil {
+		t.Error("expected an error for a stream without a hello")
+	}
+
+	logs, _ := filepath.Glob(filepath.Join(streamDir, "prog_*.log"))
+	if len(logs) != 1 {
+		t.Fatalf("expected one log for the binary, got %v", logs)
+	}
+	content, err := os.ReadFile(logs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(content), "[Function:main]"); n != 1 {
+		t.Errorf("expected the definitions once, got %d in:\n%s", n, content)
+	}
+	c.rotateAll()
+	if _, err := os.Stat(logs[0]); !os.IsNotExist(err) {
+		t.Errorf("expected the rotated log to be removed, got %v", err)
+	}
+	if _, err := os.Stat(logs[0] + ".gz"); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := collectLogFiles(filesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := analyzeLogs(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamed, err := collectLogFiles(streamDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, stats, err := analyzeLogsWith(streamed, AnalyzeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the coverage of the log files %+v, got %+v", want["/usr/bin/prog"], got["/usr/bin/prog"])
+	}
+	if len(stats) != 1 || stats[0].Malformed != 0 || stats[0].Live != "" {
+		t.Errorf("expected one complete log, got %+v", stats)
+	}
+	if logBinary(logs[0]+".gz") != "prog" {
+		t.Errorf("expected the binary of the rotated log, got %q", logBinary(logs[0]+".gz"))
+	}
+}
+
+func TestAnalyzeLogsBoundedSpills(t *testing.T) {
+	tmp := t.TempDir()
+	var sb strings.Builder
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&sb, "[Image:/lib/a.so] [Function:fa%d]\n[Image:/lib/b.so] [Function:fb%d]\n", i, i)
+		if i%3 == 0 {
+			fmt.Fprintf(&sb, "[Image:/lib/a.so] [Called:fa%d]\n", i)
+		}
+	}
+	logFile := filepath.Join(tmp, "big.log")
+	if err := os.WriteFile(logFile, []byte(sb.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	want, err := analyzeLogs([]string{logFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _, err := analyzeLogsWith([]string{logFile}, AnalyzeOptions{MaxMemory: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("spilled analysis differs from in-memory analysis")
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	cases := map[string]int64{"1048576": 1 << 20, "512M": 512 << 20, "2g": 2 << 30, "64KiB": 64 << 10}
+	for in, want := range cases {
+		if got, err := parseByteSize(in); err != nil || got != want {
+			t.Errorf("parseByteSize(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	if _, err := parseByteSize("lots"); err == nil {
+		t.Error("expected an error for an invalid size")
+	}
+}
+
+func TestLogIndexSidecar(t *testing.T) {
+	tmp := t.TempDir()
+	logFile := filepath.Join(tmp, "run.log")
+	if err := os.WriteFile(logFile, []byte("[Image:prog] [Function:foo]\n[Image:prog] [Function:bar]\n[Image:prog] [Called:foo]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	want, err := analyzeLogs([]string{logFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, ok := readLogIndex(logFile, AnalyzeOptions{})
+	if !ok {
+		t.Fatal("expected analyzeLogs to write an up-to-date index")
+	}
+	if img := idx.Images["prog"]; img == nil || img.TotalCount != 2 || img.CalledCount != 1 {
+		t.Errorf("unexpected index entry: %+v", idx.Images["prog"])
+	}
+	got, err := analyzeLogs([]string{logFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("coverage from index differs from parsed coverage")
+	}
+
+	// Growing the log invalidates the index.
+	f, err := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintln(f, "[Image:prog] [Called:bar]")
+	f.Close()
+	if _, ok := readLogIndex(logFile, AnalyzeOptions{}); ok {
+		t.Error("expected a stale index after the log changed")
+	}
+	got, err = analyzeLogs([]string{logFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got["prog"].CalledFunctions) != 2 {
+		t.Errorf("expected the reparsed log to report 2 called functions, got %d", len(got["prog"].CalledFunctions))
+	}
+}
+
+func TestMalformedLineAccounting(t *testing.T) {
+	tmp := t.TempDir()
+	logFile := filepath.Join(tmp, "truncated.log")
+	content := "Pin: pin-4.2\n" +
+		" [tid:1] [Image:prog] [Section:.text]\n" +
+		" [tid:1] [Image:[vdso]] is not relevant, skipping...\n" +
+		" [tid:1] [Image:prog] [Function:foo]\n" +
+		" [tid:1] [Image:prog] [Garbage\n" +
+		" [tid:1] [Image:prog] [Called:foo]\n" +
+		" [tid:1] [Ima"
+	if err := os.WriteFile(logFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A finished log: a fresh one ending mid-line would be taken as still written.
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(logFile, old, old); err != nil {
+		t.Fatal(err)
+	}
+	_, stats, err := analyzeLogsWith([]string{logFile}, AnalyzeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 || stats[0].Lines != 7 || stats[0].Malformed != 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if err := checkMalformed(stats, 50); err != nil {
+		t.Errorf("2 of 7 lines should be within a 50%% threshold: %v", err)
+	}
+	if err := checkMalformed(stats, 10); err == nil {
+		t.Error("expected 2 of 7 malformed lines to exceed a 10% threshold")
+	}
+	// The stats survive a round trip through the index sidecar.
+	if _, cached, ok := loadLogIndex(logFile, AnalyzeOptions{}); !ok || cached.Malformed != 2 || cached.Lines != 7 {
+		t.Errorf("unexpected cached stats: %+v %v", cached, ok)
+	}
+}
+
+func TestOverlongLineIsSkipped(t *testing.T) {
+	tmp := t.TempDir()
+	logFile := filepath.Join(tmp, "long.log")
+	content := "[Image:prog] [Function:" + strings.Repeat("x", maxLogLineSize) + "]\n[Image:prog] [Function:foo]\n"
+	if err := os.WriteFile(logFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	coverage, stats, err := analyzeLogsWith([]string{logFile}, AnalyzeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := coverage["prog"].TotalFunctions["foo"]; !ok {
+		t.Error("expected the line after the overlong one to be parsed")
+	}
+	if stats[0].Malformed != 1 {
+		t.Errorf("expected the overlong line to be counted as malformed, got %+v", stats[0])
+	}
+}
+
+// adversarialSymbols are names that would corrupt the reports if rendered verbatim.
+var adversarialSymbols = []string{
+	"std::vector<int, std::allocator<int> >::operator[](unsigned long)",
+	`"><script>alert(1)</script>`,
+	"a && b' onmouseover='x",
+	"multi\nline",
+}
+
+func TestReportsEscapeSymbolNames(t *testing.T) {
+	tmp := t.TempDir()
+	data := newCoverageData()
+	for i, fn := range adversarialSymbols {
+		data.TotalFunctions[fn] = struct{}{}
+		if i%2 == 0 {
+			data.CalledFunctions[fn] = struct{}{}
+		}
+	}
+	image := "/bin/<evil>&prog"
+
+	if err := generateHTMLReport(image, data, false, HTMLOptions{}, tmp, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	html, err := os.ReadFile(filepath.Join(tmp, htmlReportFileName(image)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, raw := range []string{"<script>alert", "<evil>", "<int,", "' onmouseover='"} {
+		if strings.Contains(string(html), raw) {
+			t.Errorf("HTML report contains unescaped %q", raw)
+		}
+	}
+	if !strings.Contains(string(html), "&lt;script&gt;") {
+		t.Error("expected the script symbol to be rendered escaped")
+	}
+
+	if err := generateXUnitReport(image, data, XUnitOptions{}, tmp, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(filepath.Join(tmp, xunitReportFileName(image)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var text strings.Builder
+	dec := xml.NewDecoder(f)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("XUnit report is not well-formed: %v", err)
+		}
+		if cd, ok := tok.(xml.CharData); ok {
+			text.Write(cd)
+		}
+	}
+	for _, fn := range adversarialSymbols {
+		if !strings.Contains(text.String(), printableSymbol(fn)) {
+			t.Errorf("XUnit report lost symbol %q", fn)
+		}
+	}
+	if strings.Contains(text.String(), "multi\nline") {
+		t.Error("expected the newline of a symbol to be quoted")
+	}
+}
+
+func TestHTMLReportEmbedsDataAndPaginates(t *testing.T) {
+	tmp := t.TempDir()
+	data := newCoverageData()
+	for i, fn := range adversarialSymbols {
+		data.TotalFunctions[fn] = struct{}{}
+		if i%2 == 0 {
+			data.CalledFunctions[fn] = struct{}{}
+			data.addCalls(fn, uint64(i+1))
+		}
+	}
+	image := "/usr/bin/big"
+	read := func(opts HTMLOptions) (string, ImageExport) {
+		if err := generateHTMLReport(image, data, false, opts, tmp, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		html, err := os.ReadFile(filepath.Join(tmp, htmlReportFileName(image)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, blob, found := strings.Cut(string(html), `<script type="application/json" id="coverage-data">`)
+		blob, _, _ = strings.Cut(blob, "</script>")
+		var export ImageExport
+		if err := json.Unmarshal([]byte(blob), &export); !found || err != nil {
+			t.Fatalf("embedded coverage data not found or invalid: %v", err)
+		}
+		return string(html), export
+	}
+
+	html, export := read(HTMLOptions{})
+	if !strings.Contains(html, `<li class="called"`) || strings.Contains(html, `id="function-list"`) {
+		t.Error("expected the functions rendered as list items")
+	}
+	if export.Image != "big" || export.TotalCount != len(adversarialSymbols) || export.CalledCount != len(data.CalledFunctions) || len(export.Functions) != export.TotalCount {
+		t.Errorf("unexpected export: %s, %d/%d called, %d functions", export.Image, export.CalledCount, export.TotalCount, len(export.Functions))
+	}
+	for _, fn := range export.Functions {
+		if _, called := data.CalledFunctions[fn.Name]; fn.Called != called || fn.Calls != data.Calls[fn.Name] {
+			t.Errorf("exported %q as called %v with %d calls", fn.Name, fn.Called, fn.Calls)
+		}
+	}
+	if html, _ := read(HTMLOptions{PaginateAbove: -1}); strings.Contains(html, `id="function-list"`) {
+		t.Error("PaginateAbove -1: expected no paged function list")
+	}
+
+	html, paged := read(HTMLOptions{PaginateAbove: len(adversarialSymbols) - 1})
+	if strings.Contains(html, `<li class="called"`) || !strings.Contains(html, `id="function-list"`) {
+		t.Error("expected the functions rendered page by page from the embedded data")
+	}
+	if len(paged.Functions) != len(export.Functions) {
+		t.Errorf("paged page embeds %d functions, want %d", len(paged.Functions), len(export.Functions))
+	}
+}
+
+func TestStreamedReportsMatchBuffered(t *testing.T) {
+	tmp := t.TempDir()
+	data := newCoverageData()
+	for i := 0; i < defaultPaginateAbove+500; i++ {
+		fn := fmt.Sprintf("fn%05d", i)
+		if i%1000 == 0 {
+			fn = fmt.Sprintf("operator<<(ns::T<%d>&, \"'%d')", i, i)
+		}
+		data.TotalFunctions[fn] = struct{}{}
+		if i%3 == 0 {
+			data.CalledFunctions[fn] = struct{}{}
+		}
+	}
+	image := "/usr/bin/big"
+	names := sortedKeys(data.TotalFunctions)
+
+	// The XUnit report parses to the document the encoder used to build in
+	// memory before streaming: one test case listing the called then the
+	// uncalled functions in name order.
+	if err := generateXUnitReport(image, data, XUnitOptions{}, tmp, time.Unix(1700000000, 0).UTC()); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join(tmp, xunitReportFileName(image)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got TestSuites
+	if err := xml.Unmarshal(content, &got); err != nil {
+		t.Fatalf("streamed XUnit report does not parse: %v", err)
+	}
+	var details strings.Builder
+	details.WriteString("CALLED FUNCTIONS:\n")
+	for _, fn := range names {
+		if _, ok := data.CalledFunctions[fn]; ok {
+			details.WriteString("  ✓ " + fn + "\n")
+		}
+	}
+	details.WriteString("\nUNCALLED FUNCTIONS:\n")
+	for _, fn := range names {
+		if _, ok := data.CalledFunctions[fn]; !ok {
+			details.WriteString("  ✗ " + fn + "\n")
+		}
+	}
+	summary := summarizeCoverage(map[string]*CoverageData{image: data})
+	fmt.Fprintf(&details, "\nTOTALS:\n  Total Functions: %d\n  Total Called: %d\n  Average Coverage: %.2f%%\n", summary.TotalFunctions, summary.TotalCalled, summary.AverageCoverage)
+	if len(got.TestSuite) != 1 || len(got.TestSuite[0].TestCase) != 1 || got.TestSuite[0].TestCase[0].Passed == nil {
+		t.Fatalf("unexpected XUnit layout: %s", content[:200])
+	}
+	passed := got.TestSuite[0].TestCase[0].Passed
+	want := TestSuites{
+		XMLName:   xml.Name{Local: "testsuites"},
+		Generated: "2023-11-14 22:13:20 UTC",
+		TestSuite: []TestSuite{{
+			Name:     "binary_coverage_big",
+			Skipped:  len(names) - len(data.CalledFunctions),
+			Tests:    len(names),
+			TestCase: []TestCase{{ClassName: "binary_coverage_big", Name: "Result", Passed: &Passed{Message: passed.Message, Text: details.String()}}},
+		}},
+	}
+	if !strings.HasPrefix(passed.Message, "Coverage Summary for big |") {
+		t.Errorf("unexpected summary message %q", passed.Message)
+	}
+	if !reflect.DeepEqual(got, want) {
+		if passed.Text != details.String() {
+			t.Errorf("streamed function list differs: got %d bytes, want %d", len(passed.Text), details.Len())
+		}
+		t.Errorf("streamed XUnit report differs from the buffered one: %+v", got.TestSuite[0])
+	}
+
+	// The HTML page lists, or embeds when paginated, every function in name order.
+	entries := regexp.MustCompile(`<li class="(called|uncalled)" title="([^"]*)"`)
+	for _, opts := range []HTMLOptions{{}, {PaginateAbove: -1}} {
+		if err := generateHTMLReport(image, data, false, opts, tmp, time.Unix(1700000000, 0).UTC()); err != nil {
+			t.Fatal(err)
+		}
+		content, err := os.ReadFile(filepath.Join(tmp, htmlReportFileName(image)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, blob, _ := strings.Cut(string(content), `<script type="application/json" id="coverage-data">`)
+		blob, _, _ = strings.Cut(blob, "</script>")
+		var export ImageExport
+		if err := json.Unmarshal([]byte(blob), &export); err != nil {
+			t.Fatalf("PaginateAbove %d: embedded data does not parse: %v", opts.PaginateAbove, err)
+		}
+		listed := entries.FindAllStringSubmatch(string(content), -1)
+		if opts.PaginateAbove == 0 && len(listed) != 0 {
+			t.Errorf("expected the %d functions paginated, got %d list items", len(names), len(listed))
+		}
+		if opts.PaginateAbove < 0 && len(listed) != len(names) {
+			t.Fatalf("expected %d list items, got %d", len(names), len(listed))
+		}
+		if len(export.Functions) != len(names) {
+			t.Fatalf("expected %d embedded functions, got %d", len(names), len(export.Functions))
+		}
+		for i, fn := range names {
+			_, called := data.CalledFunctions[fn]
+			if export.Functions[i].Name != fn || export.Functions[i].Called != called {
+				t.Errorf("embedded function %d is %+v, want %q called %v", i, export.Functions[i], fn, called)
+				break
+			}
+			if listed != nil && (html.UnescapeString(listed[i][2]) != fn || (listed[i][1] == "called") != called) {
+				t.Errorf("list item %d is %q, want %q called %v", i, listed[i][0], fn, called)
+				break
+			}
+		}
+	}
+}
+
+func TestSymbolVersionNormalization(t *testing.T) {
+	tmp := t.TempDir()
+	logFile := filepath.Join(tmp, "versions.log")
+	content := "[Image:libfoo.so] [Function:foo@@VER_1.2]\n[Image:libfoo.so] [Called:foo@VER_1.2]\n" +
+		"[Image:libfoo.so] [Function:memcpy@GLIBC_2.14]\n[Image:libfoo.so] [Called:memcpy]\n" +
+		"[Image:libfoo.so] [Function:_ZN3Foo3barEv@@LIBFOO_1]\n"
+	if err := os.WriteFile(logFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	coverage, err := analyzeLogs([]string{logFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := coverage["libfoo.so"]
+	for _, fn := range []string{"foo", "memcpy", "Foo::bar()"} {
+		if _, ok := data.TotalFunctions[fn]; !ok {
+			t.Errorf("expected normalized function %q, got %v", fn, data.TotalFunctions)
+		}
+	}
+	if len(data.CalledFunctions) != 2 {
+		t.Errorf("expected foo and memcpy to be called, got %v", data.CalledFunctions)
+	}
+
+	versioned, _, err := analyzeLogsWith([]string{logFile}, AnalyzeOptions{SymbolVersions: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = versioned["libfoo.so"]
+	for _, fn := range []string{"foo@VER_1.2", "memcpy@GLIBC_2.14", "Foo::bar()@LIBFOO_1"} {
+		if _, ok := data.TotalFunctions[fn]; !ok {
+			t.Errorf("expected versioned function %q, got %v", fn, data.TotalFunctions)
+		}
+	}
+	if _, ok := data.CalledFunctions["foo@VER_1.2"]; !ok {
+		t.Errorf("expected default and non-default versions of foo to match, got %v", data.CalledFunctions)
+	}
+}
+
+func TestCollapseIFuncs(t *testing.T) {
+	set := func(names ...string) map[string]struct{} {
+		m := make(map[string]struct{})
+		for _, n := range names {
+			m[n] = struct{}{}
+		}
+		return m
+	}
+	coverage := map[string]*CoverageData{
+		"/nonexistent/libc.so.6": {
+			TotalFunctions: set("memcpy_ifunc", "__memcpy_avx_unaligned_erms", "__memcpy_sse2_unaligned",
+				"strlen_ifunc", "strchr_ifunc", "memcpy_chk_helper", "puts"),
+			CalledFunctions: set("memcpy_ifunc", "__memcpy_avx_unaligned_erms", "strlen_ifunc", "puts"),
+			Calls:           map[string]uint64{"memcpy_ifunc": 1, "__memcpy_avx_unaligned_erms": 500, "strlen_ifunc": 2, "puts": 3},
+		},
+	}
+	implementations := collapseIFuncs(coverage)
+	data := coverage["/nonexistent/libc.so.6"]
+	wantTotal := set("memcpy", "strlen", "strchr", "memcpy_chk_helper", "puts")
+	if !reflect.DeepEqual(data.TotalFunctions, wantTotal) {
+		t.Errorf("TotalFunctions = %v, want %v", data.TotalFunctions, wantTotal)
+	}
+	// strlen has no traced variants, so its resolver call stands in for it.
+	wantCalled := set("memcpy", "strlen", "puts")
+	if !reflect.DeepEqual(data.CalledFunctions, wantCalled) {
+		t.Errorf("CalledFunctions = %v, want %v", data.CalledFunctions, wantCalled)
+	}
+	wantCalls := map[string]uint64{"memcpy": 500, "strlen": 2, "puts": 3}
+	if !reflect.DeepEqual(data.Calls, wantCalls) {
+		t.Errorf("Calls = %v, want %v", data.Calls, wantCalls)
+	}
+	ran := implementations["/nonexistent/libc.so.6"]["memcpy"]
+	if !reflect.DeepEqual(ran, []string{"__memcpy_avx_unaligned_erms"}) {
+		t.Errorf("unexpected memcpy implementations: %v", ran)
+	}
+}
+
+func TestAddDynsymFallback(t *testing.T) {
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found")
+	}
+	tmp := t.TempDir()
+	src := filepath.Join(tmp, "lib.c")
+	code := "int exported_a(void) { return 1; }\nint exported_b(void) { return 2; }\nstatic int hidden(void) { return 3; }\nint use_hidden(void) { return hidden(); }\n"
+	if err := os.WriteFile(src, []byte(code), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stripped := filepath.Join(tmp, "libstripped.so")
+	full := filepath.Join(tmp, "libfull.so")
+	if out, err := exec.Command("gcc", "-shared", "-fPIC", "-s", "-o", stripped, src).CombinedOutput(); err != nil {
+		t.Fatalf("failed to compile: %v\n%s", err, out)
+	}
+	if out, err := exec.Command("gcc", "-shared", "-fPIC", "-o", full, src).CombinedOutput(); err != nil {
+		t.Fatalf("failed to compile: %v\n%s", err, out)
+	}
+	coverage := map[string]*CoverageData{
+		stripped: {TotalFunctions: map[string]struct{}{"exported_a": {}}, CalledFunctions: map[string]struct{}{"exported_a": {}}},
+		full:     {TotalFunctions: map[string]struct{}{"exported_a": {}}, CalledFunctions: map[string]struct{}{}},
+	}
+	partial := addDynsymFallback(coverage)
+	if !partial[stripped] || partial[full] {
+		t.Errorf("expected only the stripped library to be partial, got %v", partial)
+	}
+	for _, fn := range []string{"exported_a", "exported_b", "use_hidden"} {
+		if _, ok := coverage[stripped].TotalFunctions[fn]; !ok {
+			t.Errorf("expected exported function %s in the totals of the stripped library", fn)
+		}
+	}
+	if _, ok := coverage[stripped].TotalFunctions["hidden"]; ok {
+		t.Error("static functions are not exported and cannot be recovered")
+	}
+	if len(coverage[full].TotalFunctions) != 1 {
+		t.Errorf("unstripped images must be left alone, got %v", coverage[full].TotalFunctions)
+	}
+}
+
+// buildDetachedDebug compiles a shared library, splits its symbols into a
+// .debug file and strips it, as distributions do.
+func buildDetachedDebug(t *testing.T, dir string) (lib, debug string) {
+	for _, tool := range []string{"gcc", "objcopy", "strip"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not found", tool)
+		}
+	}
+	src := filepath.Join(dir, "lib.c")
+	code := "static int hidden(void) { return 3; }\nint use_hidden(void) { return hidden(); }\n"
+	if err := os.WriteFile(src, []byte(code), 0644); err != nil {
+		t.Fatal(err)
+	}
+	lib = filepath.Join(dir, "libsplit.so")
+	debug = lib + ".debug"
+	for _, args := range [][]string{
+		{"gcc", "-g", "-shared", "-fPIC", "-Wl,--build-id", "-o", lib, src},
+		{"objcopy", "--only-keep-debug", lib, debug},
+		{"strip", "--strip-all", lib},
+		{"objcopy", "--add-gnu-debuglink=" + debug, lib},
+	} {
+		if out, err := exec.Command(args[0], args[1:]...).CombinedOutput(); err != nil {
+			t.Fatalf("%v failed: %v\n%s", args, err, out)
+		}
+	}
+	return lib, debug
+}
+
+func TestFindDebugFileDebugLink(t *testing.T) {
+	tmp := t.TempDir()
+	orig := globalDebugRoot
+	globalDebugRoot = filepath.Join(tmp, "debugroot")
+	defer func() { globalDebugRoot = orig }()
+	t.Setenv("DEBUGINFOD_URLS", "")
+	lib, debug := buildDetachedDebug(t, tmp)
+
+	path, err := findDebugFile(lib)
+	if err != nil || path != debug {
+		t.Fatalf("findDebugFile = %q, %v; want %q", path, err, debug)
+	}
+	coverage := map[string]*CoverageData{lib: {TotalFunctions: map[string]struct{}{}, CalledFunctions: map[string]struct{}{}}}
+	partial := addDynsymFallback(coverage)
+	if partial[lib] {
+		t.Error("an image with detached debuginfo should not be partial")
+	}
+	if _, ok := coverage[lib].TotalFunctions["hidden"]; !ok {
+		t.Errorf("expected the static function to be recovered from the debuginfo, got %v", coverage[lib].TotalFunctions)
+	}
+}
+
+func TestFetchDebuginfod(t *testing.T) {
+	requested := ""
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.Path
+		if r.URL.Path != "/buildid/abcd1234/debuginfo" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("ELF debug data"))
+	}))
+	defer srv.Close()
+	cache := t.TempDir()
+	t.Setenv("DEBUGINFOD_CACHE_PATH", cache)
+	t.Setenv("DEBUGINFOD_URLS", srv.URL)
+
+	path, err := fetchDebuginfod("abcd1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != filepath.Join(cache, "abcd1234", "debuginfo") {
+		t.Errorf("unexpected cache path %q", path)
+	}
+	if content, _ := os.ReadFile(path); string(content) != "ELF debug data" {
+		t.Errorf("unexpected cached content %q", content)
+	}
+	if path, err := fetchDebuginfod("ffff"); err != nil || path != "" {
+		t.Errorf("expected a missing build-id to yield nothing, got %q, %v (last request %s)", path, err, requested)
+	}
+}
+
+func TestNormalizeImagePath(t *testing.T) {
+	tmp := t.TempDir()
+	real := filepath.Join(tmp, "libreal.so.1.2")
+	if err := os.WriteFile(real, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(tmp, "libreal.so.1")
+	if err := os.Symlink("libreal.so.1.2", link); err != nil {
+		t.Fatal(err)
+	}
+	resolvedReal, _ := filepath.EvalSymlinks(real)
+	mappings := []PathMapping{{Old: "/mnt/sysroot", New: ""}, {Old: "/opt/old", New: "/opt/new"}}
+	cases := map[string]string{
+		"/nonexistent/bin/x":            "/nonexistent/bin/x",
+		"/bin/nonexistent-tool":         "/usr/bin/nonexistent-tool",
+		"/lib64/nonexistent.so":         "/usr/lib64/nonexistent.so",
+		"/library/nonexistent":          "/library/nonexistent",
+		"/mnt/sysroot/sbin/nonexistent": "/usr/sbin/nonexistent",
+		"/opt/old/nonexistent":          "/opt/new/nonexistent",
+		"/opt/older/nonexistent":        "/opt/older/nonexistent",
+		"[vdso]":                        "[vdso]",
+		link:                            resolvedReal,
+	}
+	for in, want := range cases {
+		if got := normalizeImagePath(in, mappings); got != want {
+			t.Errorf("normalizeImagePath(%q) = %q, want %q", in, got, want)
+		}
+	}
+	if _, err := parsePathMapping("novalue"); err == nil {
+		t.Error("expected an error for a mapping without '='")
+	}
+}
+
+func TestAnalyzeLogsMergesImagePaths(t *testing.T) {
+	tmp := t.TempDir()
+	logFile := filepath.Join(tmp, "usrmerge.log")
+	content := "[Image:/bin/nonexistent-tool] [Function:foo]\n[Image:/usr/bin/nonexistent-tool] [Function:bar]\n" +
+		"[Image:/usr/bin/nonexistent-tool] [Called:foo]\n[Image:/chroot/usr/bin/nonexistent-tool] [Called:bar]\n"
+	if err := os.WriteFile(logFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	coverage, _, err := analyzeLogsWith([]string{logFile}, AnalyzeOptions{PathMap: []PathMapping{{Old: "/chroot", New: ""}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(coverage) != 1 {
+		t.Fatalf("expected a single merged image, got %d", len(coverage))
+	}
+	data := coverage["/usr/bin/nonexistent-tool"]
+	if data == nil || len(data.TotalFunctions) != 2 || len(data.CalledFunctions) != 2 {
+		t.Errorf("unexpected merged coverage: %+v", data)
+	}
+}
+
+func TestDuplicateFunctionNamesByAddress(t *testing.T) {
+	tmp := t.TempDir()
+	logFile := filepath.Join(tmp, "statics.log")
+	content := "[FuncTracer] [Format:4]\n" +
+		"[Image:prog] [Addr:0x1000] [Function:init]\n[Image:prog] [Addr:0x2000] [Function:init]\n" +
+		"[Image:prog] [Addr:0x3000] [Function:foo]\n[Image:prog] [Addr:0x2000] [Called:init]\n" +
+		"[Image:prog] [Addr:0x3000] [Called:foo]\n"
+	if err := os.WriteFile(logFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	coverage, err := analyzeLogs([]string{logFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := coverage["prog"]
+	for _, fn := range []string{"init [0x1000]", "init [0x2000]", "foo"} {
+		if _, ok := data.TotalFunctions[fn]; !ok {
+			t.Errorf("expected %q in the totals, got %v", fn, data.TotalFunctions)
+		}
+	}
+	if len(data.TotalFunctions) != 3 || len(data.CalledFunctions) != 2 {
+		t.Errorf("unexpected coverage: %+v", data)
+	}
+	if _, ok := data.CalledFunctions["init [0x2000]"]; !ok {
+		t.Errorf("expected the second init to be called, got %v", data.CalledFunctions)
+	}
+}
+
+func TestDuplicateRunsAreSkipped(t *testing.T) {
+	tmp := t.TempDir()
+	write := func(name, runID, called string) string {
+		path := filepath.Join(tmp, name)
+		content := "[FuncTracer] [Format:4] [Run:" + runID + "]\n[Image:prog] [Function:foo]\n[Image:prog] [Function:bar]\n[Image:prog] [Called:" + called + "]\n"
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	first := write("a.log", "1f-abc", "foo")
+	copied := write("a.copy.log", "1f-abc", "foo")
+	other := write("b.log", "20-abd", "bar")
+	coverage, stats, err := analyzeLogsWith([]string{first, copied, other}, AnalyzeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(coverage["prog"].CalledFunctions) != 2 {
+		t.Errorf("expected both runs to count, got %v", coverage["prog"].CalledFunctions)
+	}
+	dups := duplicateLogs(stats)
+	if len(dups) != 1 || dups[0].File != copied || dups[0].DuplicateOf != first {
+		t.Errorf("expected %s to be skipped as a copy of %s, got %+v", copied, first, dups)
+	}
+	// The run ID also survives the index sidecar.
+	if _, stats, err = analyzeLogsWith([]string{first, copied}, AnalyzeOptions{}); err != nil || len(duplicateLogs(stats)) != 1 {
+		t.Errorf("expected the indexed copy to be skipped too, got %+v, %v", stats, err)
+	}
+}
+
+func TestPrepareOutputDir(t *testing.T) {
+	tmp := t.TempDir()
+	logs := filepath.Join(tmp, "logs")
+	if err := os.Mkdir(logs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(tmp, "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(logs, filepath.Join(tmp, "link")); err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []string{"", "/", logs, filepath.Join(logs, "report"), filepath.Join(tmp, "link", "report"), file} {
+		if err := prepareOutputDir(bad, logs); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+	out := filepath.Join(tmp, "logs-report", "nested")
+	if err := prepareOutputDir(out, logs); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info, err := os.Stat(out); err != nil || !info.IsDir() {
+		t.Errorf("expected %s to be created", out)
+	}
+}
+
+func TestLiveLogs(t *testing.T) {
+	tmp := t.TempDir()
+	logFile := filepath.Join(tmp, "live.log")
+	content := "[Image:prog] [Function:foo]\n[Image:prog] [Function:bar]\n[Image:prog] [Called:foo]\n[Image:prog] [Called:ba"
+	if err := os.WriteFile(logFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	coverage, stats, err := analyzeLogsWith([]string{logFile}, AnalyzeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 || stats[0].Live != liveLogsTail || stats[0].Lines != 3 || stats[0].Malformed != 0 {
+		t.Errorf("expected the partial tail to be left out, got %+v", stats)
+	}
+	if len(coverage["prog"].TotalFunctions) != 2 || len(coverage["prog"].CalledFunctions) != 1 {
+		t.Errorf("unexpected coverage: %+v", coverage["prog"])
+	}
+	if _, err := os.Stat(logFile + logIndexSuffix); err == nil {
+		t.Error("a live log must not be indexed")
+	}
+	coverage, stats, err = analyzeLogsWith([]string{logFile}, AnalyzeOptions{LiveLogs: liveLogsSkip})
+	if err != nil || len(coverage) != 0 || len(liveLogs(stats)) != 1 {
+		t.Errorf("expected the live log to be skipped, got %v %+v %v", coverage, stats, err)
+	}
+
+	// A log held open by another process is live even when it looks complete.
+	held := filepath.Join(tmp, "held.log")
+	if err := os.WriteFile(held, []byte("[Image:prog] [Function:foo]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("sh", "-c", "exec 3<\"$0\"; sleep 30", held)
+	if err := cmd.Start(); err != nil {
+		t.Skip("cannot start a process holding the log:", err)
+	}
+	defer func() { cmd.Process.Kill(); cmd.Wait() }()
+	for i := 0; i < 50; i++ {
+		if info, err := os.Stat(held); err == nil && new(liveLogDetector).isLive(held, info) {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Error("expected a log held open by another process to be live")
+}
+
+func TestReportsAreReproducible(t *testing.T) {
+	data := &CoverageData{
+		TotalFunctions:  map[string]struct{}{"zeta": {}, "alpha": {}, "mid": {}, "beta": {}},
+		CalledFunctions: map[string]struct{}{"mid": {}, "alpha": {}},
+	}
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	generatedAt, err := reportTimestamp(time.Time{})
+	if err != nil || !generatedAt.Equal(time.Unix(1700000000, 0)) {
+		t.Fatalf("expected SOURCE_DATE_EPOCH to be used, got %v %v", generatedAt, err)
+	}
+	var outputs [2][]byte
+	for i := range outputs {
+		dir := t.TempDir()
+		if err := generateXUnitReport("/bin/prog", data, XUnitOptions{}, dir, generatedAt); err != nil {
+			t.Fatal(err)
+		}
+		if err := generateHTMLReport("/bin/prog", data, false, HTMLOptions{Top: defaultHotFunctions}, dir, generatedAt); err != nil {
+			t.Fatal(err)
+		}
+		xml, _ := os.ReadFile(filepath.Join(dir, xunitReportFileName("/bin/prog")))
+		html, _ := os.ReadFile(filepath.Join(dir, htmlReportFileName("/bin/prog")))
+		outputs[i] = append(xml, html...)
+	}
+	if !bytes.Equal(outputs[0], outputs[1]) {
+		t.Error("expected byte-identical reports for the same data")
+	}
+	if !bytes.Contains(outputs[0], []byte("2023-11-14 22:13:20 UTC")) {
+		t.Error("expected the SOURCE_DATE_EPOCH time in the reports")
+	}
+	if i, j := bytes.Index(outputs[0], []byte("alpha")), bytes.Index(outputs[0], []byte("zeta")); i < 0 || j < i {
+		t.Error("expected the functions in name order")
+	}
+
+	for in, want := range map[string]string{"1700000000": "2023-11-14T22:13:20Z", "2024-01-02T03:04:05+02:00": "2024-01-02T01:04:05Z"} {
+		if got, err := parseReportTimestamp(in); err != nil || got.Format(time.RFC3339) != want {
+			t.Errorf("parseReportTimestamp(%q) = %v, %v, want %s", in, got, err, want)
+		}
+	}
+	if _, err := parseReportTimestamp("yesterday"); err == nil {
+		t.Error("expected an error for an invalid timestamp")
+	}
+}
+
+func TestParallelReportsAreIdentical(t *testing.T) {
+	tmp := t.TempDir()
+	logs := filepath.Join(tmp, "logs")
+	if err := os.Mkdir(logs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	var content strings.Builder
+	content.WriteString("[FuncTracer] [Format:5]\n")
+	for i := 0; i < 12; i++ {
+		image := fmt.Sprintf("/usr/bin/prog%02d", i)
+		for j := 0; j < 20; j++ {
+			fmt.Fprintf(&content, "[Image:%s] [Function:fn%02d]\n", image, j)
+			if (i+j)%3 == 0 {
+				fmt.Fprintf(&content, "[Image:%s] [Called:fn%02d] [Calls:%d]\n", image, j, i+j+1)
+			}
+		}
+	}
+	if err := os.WriteFile(filepath.Join(logs, "prog_20260101-100000_1.log"), []byte(content.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Run with -race (see run_unit_tests.sh), the workers of forEachImage must
+	// not share state, nor make the output depend on their scheduling. Both
+	// runs write to the same directory, which summary.json lists.
+	out := filepath.Join(tmp, "out")
+	var outputs [2]map[string][]byte
+	for i, jobs := range []int{1, 4} {
+		if err := os.RemoveAll(out); err != nil {
+			t.Fatal(err)
+		}
+		opts := ReportOptions{Inputs: []string{logs}, OutputDir: out, Formats: []string{"txt", "html", "xml", "cobertura"}, Jobs: jobs, Timestamp: time.Unix(1700000000, 0).UTC()}
+		if err := runReport(opts); err != nil {
+			t.Fatal(err)
+		}
+		outputs[i] = map[string][]byte{}
+		err := filepath.WalkDir(out, func(path string, d os.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			data, err := os.ReadFile(path)
+			rel, _ := filepath.Rel(out, path)
+			outputs[i][rel] = data
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(outputs[0]) < 12 {
+		t.Fatalf("expected a report per image, got %d files", len(outputs[0]))
+	}
+	for name, data := range outputs[0] {
+		if other, ok := outputs[1][name]; !ok {
+			t.Errorf("%s missing with 4 jobs", name)
+		} else if !bytes.Equal(data, other) {
+			t.Errorf("%s differs between 1 and 4 jobs", name)
+		}
+	}
+	if len(outputs[1]) != len(outputs[0]) {
+		t.Errorf("expected the same files with 1 and 4 jobs, got %d and %d", len(outputs[0]), len(outputs[1]))
+	}
+}
+
+func TestCallCountsAndHotFunctions(t *testing.T) {
+	tmp := t.TempDir()
+	var sb strings.Builder
+	sb.WriteString("[FuncTracer] [Format:5]\n")
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(&sb, "[Image:prog] [Function:f%02d]\n[Image:prog] [Count:%d] [Called:f%02d]\n", i, i+1, i)
+	}
+	// An image unloaded and loaded again writes its calls twice.
+	sb.WriteString("[Image:prog] [Count:100] [Called:f00]\n")
+	logFile := filepath.Join(tmp, "counts.log")
+	if err := os.WriteFile(logFile, []byte(sb.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, opts := range []AnalyzeOptions{{}, {}, {MaxMemory: 512}} { // parsed, indexed, spilled
+		coverage, _, err := analyzeLogsWith([]string{logFile, logFile}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := coverage["prog"]
+		if data.Calls["f00"] != 202 || data.Calls["f19"] != 40 || len(data.Calls) != 20 {
+			t.Errorf("unexpected call counts with %+v: %v", opts, data.Calls)
+		}
+		hot := hotFunctions("prog", data, 3)
+		want := []HotFunction{{"prog", "f00", 202}, {"prog", "f19", 40}, {"prog", "f18", 38}}
+		if !reflect.DeepEqual(hot, want) {
+			t.Errorf("hotFunctions = %v, want %v", hot, want)
+		}
+	}
+	if hot := hotFunctions("prog", newCoverageData(), 3); hot != nil {
+		t.Errorf("expected no hot functions without call counts, got %v", hot)
+	}
+
+	srv := httptest.NewServer(newServeMux(logFile))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/api/hot?top=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var got []HotFunction
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, []HotFunction{{"prog", "f00", 101}}) {
+		t.Errorf("unexpected /api/hot response: %v", got)
+	}
+}
+
+func TestCallGraphEdges(t *testing.T) {
+	tmp := t.TempDir()
+	logs := filepath.Join(tmp, "logs")
+	if err := os.Mkdir(logs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	content := "[FuncTracer] [Format:6]\n" +
+		"[Image:prog] [Function:main_loop]\n[Image:prog] [Function:parse]\n[Image:prog] [Function:unused]\n" +
+		"[Image:prog] [Count:1] [Called:main_loop]\n[Image:prog] [Count:5] [Called:parse]\n" +
+		"[Image:prog] [Count:5] [Caller:main_loop] [Callee:parse]\n" +
+		"[Image:prog] [Count:1] [Caller:main_loop] [Callee:puts]\n" +
+		"[Image:prog] [Count:2] [Caller:parse] [Callee:Vec::operator\\x5b\\x5d]\n"
+	logFile := filepath.Join(logs, "edges.log")
+	if err := os.WriteFile(logFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	want := map[Edge]uint64{{"main_loop", "parse"}: 5, {"main_loop", "puts"}: 1, {"parse", "Vec::operator[]"}: 2}
+	for _, opts := range []AnalyzeOptions{{}, {}, {MaxMemory: 64}} { // parsed, indexed, spilled
+		coverage, stats, err := analyzeLogsWith([]string{logFile}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(coverage["prog"].Edges, want) {
+			t.Errorf("Edges with %+v = %v, want %v", opts, coverage["prog"].Edges, want)
+		}
+		if len(malformedLogs(stats)) != 0 {
+			t.Errorf("edge lines must not count as malformed: %+v", stats)
+		}
+	}
+
+	out := filepath.Join(tmp, "out")
+	if err := runReport(ReportOptions{Inputs: []string{logs}, OutputDir: out, Formats: []string{"dot"}, DotMinCalls: 2}); err != nil {
+		t.Fatal(err)
+	}
+	dot, err := os.ReadFile(filepath.Join(out, dotReportFileName("prog")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{`digraph "prog" {`, `"main_loop" -> "parse" [label="5"];`, `"parse" -> "Vec::operator[]" [label="2"];`, `"parse" [fillcolor=palegreen];`} {
+		if !strings.Contains(string(dot), s) {
+			t.Errorf("expected %q in the call graph:\n%s", s, dot)
+		}
+	}
+	if strings.Contains(string(dot), "puts") {
+		t.Errorf("expected the edges below --dot-min-calls to be pruned:\n%s", dot)
+	}
+}
+
+func TestFlameGraph(t *testing.T) {
+	coverage := map[string]*CoverageData{
+		"/bin/prog": {
+			TotalFunctions:  map[string]struct{}{"main_loop": {}, "parse": {}, "a;b": {}},
+			CalledFunctions: map[string]struct{}{"main_loop": {}, "parse": {}, "a;b": {}},
+			Calls:           map[string]uint64{"main_loop": 1, "parse": 7, "a;b": 2},
+			Edges:           map[Edge]uint64{{"main_loop", "parse"}: 5},
+		},
+	}
+	var lines []string
+	for _, s := range foldedStacks(coverage) {
+		lines = append(lines, s.String())
+	}
+	// parse has 2 calls from callers that were not recorded.
+	want := []string{"prog;a:b 2", "prog;main_loop 1", "prog;main_loop;parse 5", "prog;parse 2"}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("foldedStacks = %q, want %q", lines, want)
+	}
+
+	dir := t.TempDir()
+	if err := generateFlameGraph(foldedStacks(coverage), dir, time.Unix(0, 0).UTC()); err != nil {
+		t.Fatal(err)
+	}
+	svg, err := os.ReadFile(filepath.Join(dir, flameGraphReportFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := xml.Unmarshal(svg, new(struct{})); err != nil {
+		t.Errorf("invalid SVG: %v", err)
+	}
+	for _, s := range []string{"<title>all (10 calls, 100.00%)</title>", "<title>parse (5 calls, 50.00%)</title>"} {
+		if !bytes.Contains(svg, []byte(s)) {
+			t.Errorf("expected %q in the flame graph", s)
+		}
+	}
+}
+
+func TestScopeGrouping(t *testing.T) {
+	tests := []struct {
+		name  string
+		scope []string
+		leaf  string
+	}{
+		{"main", nil, "main"},
+		{"ns::Vec<std::pair<int, int> >::at(unsigned long) const", []string{"ns", "Vec<std::pair<int, int> >"}, "at(unsigned long) const"},
+		{"void ns::f<a::b>(c::d)", []string{"ns"}, "f<a::b>(c::d)"},
+		{"(anonymous namespace)::helper()", []string{"(anonymous namespace)"}, "helper()"},
+		{"Vec::operator<(Vec const&)", []string{"Vec"}, "operator<(Vec const&)"},
+		{"ns::Vec::operator()()", []string{"ns", "Vec"}, "operator()()"},
+	}
+	for _, tt := range tests {
+		scope, leaf := splitScope(tt.name)
+		if !reflect.DeepEqual(scope, tt.scope) || leaf != tt.leaf {
+			t.Errorf("splitScope(%q) = %q, %q, want %q, %q", tt.name, scope, leaf, tt.scope, tt.leaf)
+		}
+	}
+
+	entry := func(fn string) FunctionEntry { return FunctionEntry{Name: fn} }
+	if groupByScope(&CoverageData{TotalFunctions: map[string]struct{}{"main": {}}}, entry) != nil {
+		t.Error("expected no scopes for C functions")
+	}
+	data := &CoverageData{
+		TotalFunctions:  map[string]struct{}{"main": {}, "ns::A::f()": {}, "ns::A::g()": {}, "ns::B::h()": {}, "ns::free()": {}},
+		CalledFunctions: map[string]struct{}{"main": {}, "ns::A::f()": {}},
+	}
+	root := groupByScope(data, entry)
+	if len(root.Groups) != 1 || root.Groups[0].Name != "ns" || len(root.Functions) != 1 {
+		t.Fatalf("unexpected tree: %+v", root)
+	}
+	ns := root.Groups[0]
+	if ns.TotalCount != 4 || ns.CalledCount != 1 || len(ns.Groups) != 2 || ns.Functions[0].Name != "free()" {
+		t.Errorf("unexpected ns group: %+v", ns)
+	}
+	if a := ns.Groups[0]; a.Path != "ns::A" || a.CoveragePct() != 50 {
+		t.Errorf("unexpected class group: %+v", a)
+	}
+
+	// Functions of the same unqualified name keep the order of their full names.
+	thunks := &CoverageData{TotalFunctions: map[string]struct{}{"ns::A::~A()": {}, "virtual thunk to ns::A::~A()": {}, "non-virtual thunk to ns::A::~A()": {}}}
+	full := func(fn string) FunctionEntry { return FunctionEntry{Name: fn, Mangled: fn} }
+	for range 10 {
+		var order []string
+		for _, e := range groupByScope(thunks, full).Groups[0].Groups[0].Functions {
+			order = append(order, e.Mangled)
+		}
+		if want := []string{"non-virtual thunk to ns::A::~A()", "ns::A::~A()", "virtual thunk to ns::A::~A()"}; !reflect.DeepEqual(order, want) {
+			t.Fatalf("got functions %q, want %q", order, want)
+		}
+	}
+
+	dir := t.TempDir()
+	if err := generateHTMLReport("/bin/prog", data, false, HTMLOptions{}, dir, time.Unix(0, 0).UTC()); err != nil {
+		t.Fatal(err)
+	}
+	html, err := os.ReadFile(filepath.Join(dir, htmlReportFileName("/bin/prog")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{`<summary>ns <span class="percentage">1/4 (25.0%)</span></summary>`, `<summary>A <span class="percentage">1/2 (50.0%)</span></summary>`, `<li class="called" title="f()">f()</li>`} {
+		if !bytes.Contains(html, []byte(s)) {
+			t.Errorf("expected %q in the report", s)
+		}
+	}
+}
+
+func TestAnnotatedSource(t *testing.T) {
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found")
+	}
+	tmp := t.TempDir()
+	build := filepath.Join(tmp, "build", "src")
+	if err := os.MkdirAll(build, 0755); err != nil {
+		t.Fatal(err)
+	}
+	src := "static int helper(void) { return 1; }\n\nint unused(void) { return 2; }\n\nint main() { return helper() - 1; }\n"
+	if err := os.WriteFile(filepath.Join(build, "prog.c"), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bin := filepath.Join(tmp, "prog")
+	cc := exec.Command("gcc", "-g", "-O0", "-o", bin, filepath.Join("src", "prog.c"))
+	cc.Dir = filepath.Dir(build)
+	if out, err := cc.CombinedOutput(); err != nil {
+		t.Fatalf("failed to compile: %v\n%s", err, out)
+	}
+
+	locations, err := functionLocations(bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc := locations["unused"]; filepath.Base(loc.File) != "prog.c" || loc.Line != 3 {
+		t.Errorf("unused defined at %+v, want prog.c:3", loc)
+	}
+
+	// The checkout lives elsewhere than the build tree.
+	root := filepath.Join(tmp, "checkout")
+	if err := os.MkdirAll(filepath.Join(root, "src"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "src", "prog.c"), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	data := &CoverageData{
+		TotalFunctions:  map[string]struct{}{"helper": {}, "unused": {}},
+		CalledFunctions: map[string]struct{}{"helper": {}},
+	}
+	out := filepath.Join(tmp, "out")
+	if err := os.Mkdir(out, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := generateHTMLReport(bin, data, false, HTMLOptions{Sources: SourceOptions{Root: root}}, out, time.Unix(0, 0).UTC()); err != nil {
+		t.Fatal(err)
+	}
+	page := sourceReportFileName(bin, filepath.Join("src", "prog.c"))
+	annotated, err := os.ReadFile(filepath.Join(out, page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{`<tr id="L1" class="called">`, `<tr id="L3" class="uncalled">`, `<tr id="L5">`, `<h1>src/prog.c</h1>`} {
+		if !bytes.Contains(annotated, []byte(s)) {
+			t.Errorf("expected %q in the annotated source", s)
+		}
+	}
+	report, err := os.ReadFile(filepath.Join(out, htmlReportFileName(bin)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link := `<a href="` + page + `#L3">unused</a>`; !bytes.Contains(report, []byte(link)) {
+		t.Errorf("expected %q in the detailed report", link)
+	}
+
+	// Without a checkout, repository links use the path within the build directory.
+	repo := SourceOptions{URL: "https://example.com/repo/blob/{rev}/{file}#L{line}", Rev: "v1.0"}
+	if err := generateHTMLReport(bin, data, false, HTMLOptions{Sources: repo}, out, time.Unix(0, 0).UTC()); err != nil {
+		t.Fatal(err)
+	}
+	if report, err = os.ReadFile(filepath.Join(out, htmlReportFileName(bin))); err != nil {
+		t.Fatal(err)
+	}
+	if link := `href="https://example.com/repo/blob/v1.0/src/prog.c#L3"`; !bytes.Contains(report, []byte(link)) {
+		t.Errorf("expected %q in the detailed report", link)
+	}
+}
+
+func TestSourceIndex(t *testing.T) {
+	for fn, want := range map[string]string{
+		"main":                         "main",
+		"ns::Foo<int>::run(int) const": "ns::Foo::run",
+		"void ns::apply<char>(char)":   "ns::apply",
+		"Foo::operator()(int)":         "Foo::operator()",
+		"Foo::operator<<(int)":         "Foo::operator<<",
+		"helper [0x1040]":              "helper",
+		"std::vector<int, std::allocator<int> >::push_back(int const&)": "std::vector::push_back",
+	} {
+		if got := plainFunctionName(fn); got != want {
+			t.Errorf("plainFunctionName(%q) = %q, want %q", fn, got, want)
+		}
+	}
+
+	tmp := t.TempDir()
+	root := filepath.Join(tmp, "src")
+	if err := os.MkdirAll(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	c := "#include <stdio.h>\n\nint helper(int x);\nMODULE(prog);\n\nstatic int\nhelper (int x)\n{\n  if (x) {\n    return 1;\n  }\n  return 0;\n}\n\nint main(void) { return helper(0); }\n"
+	cpp := "namespace ns {\nclass Foo {\n  void run();\n};\n\nvoid Foo::run()\n{\n}\n}\n"
+	for name, content := range map[string]string{"prog.c": c, "foo.cpp": cpp} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	commands := filepath.Join(tmp, "compile_commands.json")
+	db := `[{"directory": "` + tmp + `", "file": "src/prog.c", "command": "cc -c src/prog.c"},
+		{"directory": "` + tmp + `", "file": "src/foo.cpp", "arguments": ["c++", "-c", "src/foo.cpp"]}]`
+	if err := os.WriteFile(commands, []byte(db), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tags := filepath.Join(tmp, "tags")
+	content := "!_TAG_FILE_FORMAT\t2\t/extended format/\n" +
+		"Foo\tsrc/foo.cpp\t/^class Foo {$/;\"\tc\tnamespace:ns\n" +
+		"helper\tsrc/prog.c\t/^helper (int x)$/;\"\tf\ttyperef:typename:int\tfile:\n" +
+		"main\tsrc/prog.c\t15;\"\tf\n" +
+		"run\tsrc/foo.cpp\t/^void Foo::run()$/;\"\tkind:function\tline:6\tclass:ns::Foo\n"
+	if err := os.WriteFile(tags, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{commands, tags} {
+		index, err := loadSourceIndex(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for fn, want := range map[string]string{"helper": "prog.c:7", "main": "prog.c:15", "ns::Foo::run()": "foo.cpp:6"} {
+			if loc, ok := index.lookup(fn); !ok || fmt.Sprintf("%s:%d", filepath.Base(loc.File), loc.Line) != want {
+				t.Errorf("%s: %s located at %+v, want %s", filepath.Base(path), fn, loc, want)
+			}
+		}
+		if loc, ok := index.lookup("MODULE"); ok {
+			t.Errorf("%s: the macro call is taken for a definition at %+v", filepath.Base(path), loc)
+		}
+	}
+
+	// An image without debug info is annotated from the index.
+	data := &CoverageData{
+		TotalFunctions:  map[string]struct{}{"helper": {}, "main": {}},
+		CalledFunctions: map[string]struct{}{"main": {}},
+	}
+	bin := filepath.Join(tmp, "missing-binary")
+	out := filepath.Join(tmp, "out")
+	if err := os.Mkdir(out, 0755); err != nil {
+		t.Fatal(err)
+	}
+	sources := SourceOptions{Root: root, URL: "https://example.com/{file}#L{line}", Index: commands}
+	if err := generateHTMLReport(bin, data, false, HTMLOptions{Sources: sources}, out, time.Unix(0, 0).UTC()); err != nil {
+		t.Fatal(err)
+	}
+	annotated, err := os.ReadFile(filepath.Join(out, sourceReportFileName(bin, "prog.c")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{`<tr id="L7" class="uncalled">`, `<tr id="L15" class="called">`} {
+		if !bytes.Contains(annotated, []byte(s)) {
+			t.Errorf("expected %q in the annotated source", s)
+		}
+	}
+	report, _ := os.ReadFile(filepath.Join(out, htmlReportFileName(bin)))
+	if !bytes.Contains(report, []byte("https://example.com/prog.c#L7")) {
+		t.Errorf("expected the repository link of helper in the report")
+	}
+}
+
+func TestCoverageHistory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "history")
+	if history, err := loadHistory(dir); err != nil || len(history) != 0 {
+		t.Fatalf("expected an empty history, got %v %v", history, err)
+	}
+	runs := []float64{40, 60, 50}
+	for i, pct := range runs {
+		totals := CoverageTotals{Rows: []CoverageSummary{{ImageName: "/bin/prog", TotalCount: 10, CalledCount: int(pct / 10), CoveragePct: pct}}}
+		if err := saveHistorySnapshot(dir, time.Unix(int64(1000*(i+1)), 0), totals); err != nil {
+			t.Fatal(err)
+		}
+	}
+	history, err := loadHistory(dir)
+	if err != nil || len(history) != 3 || history[0].Rows[0].CoveragePct != 40 {
+		t.Fatalf("unexpected history %+v %v", history, err)
+	}
+
+	current := CoverageTotals{Rows: []CoverageSummary{{ImageName: "/bin/prog", CoveragePct: 70}, {ImageName: "/bin/new", CoveragePct: 10}}}
+	trends := imageTrends(history, time.Unix(4000, 0), current)
+	if got := trends["/bin/prog"]; !reflect.DeepEqual(got.Points, []float64{40, 60, 50, 70}) || !got.HasPrevious || got.Delta != 20 {
+		t.Errorf("unexpected trend %+v", got)
+	}
+	if got := trends["/bin/new"]; got.HasPrevious || len(got.Points) != 1 {
+		t.Errorf("expected no previous run for a new image, got %+v", got)
+	}
+	// Re-running at the time of the last snapshot compares with the one before.
+	if got := imageTrends(history, time.Unix(3000, 0), current)["/bin/prog"]; got.Delta != 10 {
+		t.Errorf("expected the snapshot being replaced to be skipped, got %+v", got)
+	}
+
+	coverage := map[string]*CoverageData{"/bin/prog": {
+		TotalFunctions:  map[string]struct{}{"a": {}, "b": {}},
+		CalledFunctions: map[string]struct{}{"a": {}},
+	}}
+	out := t.TempDir()
+	if err := generateAggregateHTMLReport(coverage, nil, nil, nil, AggregateView{Thresholds: defaultThresholds, Trends: trends}, out, time.Unix(4000, 0)); err != nil {
+		t.Fatal(err)
+	}
+	html, err := os.ReadFile(filepath.Join(out, aggregateReportFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{`<svg class="sparkline"`, `<span class="delta-up">&#43;20.0</span>`, `<th>Trend</th>`} {
+		if !bytes.Contains(html, []byte(s)) {
+			t.Errorf("expected %q in the aggregate report", s)
+		}
+	}
+}
+
+func TestCoverageThresholds(t *testing.T) {
+	th, err := parseThresholds("40, 75.5")
+	if err != nil || th != (CoverageThresholds{Red: 40, Green: 75.5}) {
+		t.Fatalf("parseThresholds = %+v, %v", th, err)
+	}
+	for _, bad := range []string{"80", "80,50", "a,b", "-1,50", "50,101"} {
+		if _, err := parseThresholds(bad); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+	for pct, want := range map[float64]string{0: "low", 39.9: "low", 40: "medium", 75.5: "high", 100: "high"} {
+		if got := th.level(pct); got != want {
+			t.Errorf("level(%v) = %s, want %s", pct, got, want)
+		}
+	}
+
+	image := func(total, called int) *CoverageData {
+		d := newCoverageData()
+		for i := range total {
+			d.TotalFunctions[fmt.Sprint("f", i)] = struct{}{}
+			if i < called {
+				d.CalledFunctions[fmt.Sprint("f", i)] = struct{}{}
+			}
+		}
+		return d
+	}
+	coverage := map[string]*CoverageData{"/bin/red": image(10, 1), "/bin/yellow": image(10, 6), "/bin/green": image(10, 9)}
+	out := t.TempDir()
+	if err := generateAggregateHTMLReport(coverage, nil, nil, nil, AggregateView{Thresholds: defaultThresholds, Listing: CoverageFilter{Below: 80}}, out, time.Unix(0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	html, err := os.ReadFile(filepath.Join(out, aggregateReportFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{`<tr class="level-low">`, `<tr class="level-medium">`, `Images Below 80%:</strong> 2`, `(1 below 50%)`, `1 more left out`} {
+		if !bytes.Contains(html, []byte(s)) {
+			t.Errorf("expected %q in the aggregate report", s)
+		}
+	}
+	if bytes.Contains(html, []byte(`<tr class="level-high">`)) || bytes.Contains(html, []byte("<td>green")) {
+		t.Error("expected the images reaching --below to be left out")
+	}
+}
+
+func TestBaselineComparison(t *testing.T) {
+	dir := t.TempDir()
+	before := map[string]*CoverageData{"/bin/prog": {
+		TotalFunctions:  map[string]struct{}{"a": {}, "b": {}, "c": {}, "d": {}},
+		CalledFunctions: map[string]struct{}{"a": {}, "b": {}},
+	}}
+	statePath := filepath.Join(dir, "state.json")
+	if err := saveCoverageState(statePath, before, time.Unix(1000, 0).UTC()); err != nil {
+		t.Fatal(err)
+	}
+	baseline, err := loadCoverageState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after := map[string]*CoverageData{
+		"/bin/prog": {
+			TotalFunctions:  map[string]struct{}{"a": {}, "b": {}, "c": {}, "d": {}},
+			CalledFunctions: map[string]struct{}{"a": {}, "c": {}, "d": {}},
+		},
+		"/bin/other": {
+			TotalFunctions:  map[string]struct{}{"x": {}},
+			CalledFunctions: map[string]struct{}{},
+		},
+	}
+	c := baseline.compareImage("/bin/prog", after["/bin/prog"])
+	if c.Change != changeRegressed || c.NewlyCalled != 2 || c.Regressed != 1 || c.Delta != 25 || !reflect.DeepEqual(c.RegressedFunctions, []string{"b"}) {
+		t.Errorf("unexpected comparison %+v", c)
+	}
+	for fn, want := range map[string]string{"a": changeUnchanged, "b": changeRegressed, "c": changeNew} {
+		_, called := after["/bin/prog"].CalledFunctions[fn]
+		if got := c.functionChange(fn, called); got != want {
+			t.Errorf("functionChange(%s) = %s, want %s", fn, got, want)
+		}
+	}
+	if c := baseline.compareImage("/bin/other", after["/bin/other"]); c.Change != changeNew {
+		t.Errorf("expected an image missing from the baseline to be new, got %+v", c)
+	}
+	if (*CoverageState)(nil).compareImage("/bin/prog", after["/bin/prog"]) != nil {
+		t.Error("expected no comparison without a baseline")
+	}
+
+	out := t.TempDir()
+	if err := generateHTMLReport("/bin/prog", after["/bin/prog"], false, HTMLOptions{Baseline: baseline}, out, time.Unix(2000, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := generateAggregateHTMLReport(after, nil, nil, nil, AggregateView{Thresholds: defaultThresholds, Baseline: baseline}, out, time.Unix(2000, 0)); err != nil {
+		t.Fatal(err)
+	}
+	detailed, _ := os.ReadFile(filepath.Join(out, htmlReportFileName("/bin/prog")))
+	aggregate, _ := os.ReadFile(filepath.Join(out, aggregateReportFileName))
+	for _, s := range []string{`<span class="badge badge-regressed">regressed</span> b`, `<span class="badge badge-new">new</span> c`, `50.0% &rarr; 75.0% (&#43;25.0)`} {
+		if !bytes.Contains(detailed, []byte(s)) {
+			t.Errorf("expected %q in the detailed report", s)
+		}
+	}
+	for _, s := range []string{`<strong>Newly Called Functions:</strong> 2`, `<strong>Regressed Functions:</strong> 1`, `<span class="badge badge-new">new</span></td>`} {
+		if !bytes.Contains(aggregate, []byte(s)) {
+			t.Errorf("expected %q in the aggregate report", s)
+		}
+	}
+}
+
+func TestNamespaceSummaries(t *testing.T) {
+	for fn, want := range map[string]string{
+		"Adaptation::Icap::Xaction::start()": "Adaptation",
+		"std::vector<int>::push_back(int&&)": "std",
+		"png_read_info":                      "png_",
+		"g_hash_table_new(void*)":            "g_",
+		"main":                               globalNamespace,
+		"_init_once":                         globalNamespace,
+		"(anonymous namespace)::helper(int)": "(anonymous namespace)",
+	} {
+		if got := namespaceOf(fn, 1); got != want {
+			t.Errorf("namespaceOf(%q) = %q, want %q", fn, got, want)
+		}
+	}
+	if got := namespaceOf("Adaptation::Icap::Xaction::start()", 2); got != "Adaptation::Icap" {
+		t.Errorf("expected two levels, got %q", got)
+	}
+
+	data := &CoverageData{
+		TotalFunctions:  map[string]struct{}{"Adaptation::Icap::a()": {}, "Adaptation::Icap::b()": {}, "Adaptation::Ecap::c()": {}, "main": {}},
+		CalledFunctions: map[string]struct{}{"Adaptation::Icap::a()": {}, "main": {}},
+	}
+	got := namespaceSummaries("/usr/sbin/squid", data, 2)
+	want := []NamespaceSummary{
+		{"squid", globalNamespace, 1, 1, 100},
+		{"squid", "Adaptation::Ecap", 1, 0, 0},
+		{"squid", "Adaptation::Icap", 2, 1, 50},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("namespaceSummaries = %+v, want %+v", got, want)
+	}
+	single := &CoverageData{TotalFunctions: map[string]struct{}{"main": {}, "run": {}}}
+	if got := namespaceSummaries("/bin/prog", single, 1); got != nil {
+		t.Errorf("expected no summary for a single group, got %+v", got)
+	}
+
+	rec := httptest.NewRecorder()
+	newServeMux(t.TempDir()).ServeHTTP(rec, httptest.NewRequest("GET", "/api/namespaces?depth=0", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid depth, got %d", rec.Code)
+	}
+}
+
+func TestWrapSampling(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want Sampling
+		str  string
+	}{{"", Sampling{}, ""}, {"1", Sampling{}, ""}, {"100%", Sampling{}, ""}, {"10", Sampling{Every: 10}, "10"}, {"5%", Sampling{Percent: 5}, "5%"}} {
+		got, err := parseSampling(tc.in)
+		if err != nil || got != tc.want || got.String() != tc.str {
+			t.Errorf("parseSampling(%q) = %+v (%q), %v; want %+v (%q)", tc.in, got, got.String(), err, tc.want, tc.str)
+		}
+	}
+	for _, in := range []string{"0", "-3", "0%", "101%", "x", "2.5%"} {
+		if _, err := parseSampling(in); err == nil {
+			t.Errorf("parseSampling(%q): expected an error", in)
+		}
+	}
+	if rate, ok := parseLogSample([]byte("[FuncTracer] [Format:6] [Run:1f-abc] [Sample:1/10]")); !ok || rate != "1/10" {
+		t.Errorf("parseLogSample = %q, %v", rate, ok)
+	}
+	rates := sampledLogs([]LogStats{{Sample: "1/10"}, {Sample: "1/10"}, {Sample: "1/10", DuplicateOf: "a.log"}, {}})
+	if len(rates) != 1 || rates["1/10"] != 2 {
+		t.Errorf("sampledLogs = %v", rates)
+	}
+
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found")
+	}
+	tmp := t.TempDir()
+	os.Setenv("PIN_ROOT", tmp)
+	os.Setenv("PIN_TOOL_SEARCH_DIR", tmp)
+	os.Setenv("SAFE_BIN_DIR", tmp)
+	os.Setenv("LOG_DIR", tmp)
+	if err := os.WriteFile(filepath.Join(tmp, "FuncTracer.so"), []byte("dummy"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A fake pin records its arguments and runs the binary after "--".
+	pin := "#!/bin/bash\necho \"$*\" >> \"$PIN_ROOT/pin.calls\"\nwhile [ \"$1\" != -- ]; do shift; done\nshift\nexec \"$@\"\n"
+	if err := os.WriteFile(filepath.Join(tmp, "pin"), []byte(pin), 0755); err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(tmp, "main.c")
+	if err := os.WriteFile(src, []byte("int main() { return 0; }"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bin := filepath.Join(tmp, "bin")
+	if out, err := exec.Command("gcc", "-g", "-o", bin, src).CombinedOutput(); err != nil {
+		t.Fatalf("failed to compile: %v\n%s", err, out)
+	}
+	if err := wrap(bin, WrapOptions{Sample: Sampling{Every: 2}}); err != nil {
+		t.Fatalf("wrap failed: %v", err)
+	}
+	defer unwrap(bin)
+	for i := 0; i < 4; i++ {
+		if out, err := exec.Command(bin).CombinedOutput(); err != nil {
+			t.Fatalf("wrapped run failed: %v\n%s", err, out)
+		}
+	}
+	calls, err := os.ReadFile(filepath.Join(tmp, "pin.calls"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(calls)), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], "-sample 1/2") {
+		t.Errorf("expected 2 traced runs out of 4 with -sample 1/2, got:\n%s", calls)
+	}
+}
+
+func TestFuzzBridge(t *testing.T) {
+	if _, err := os.Stat("/dev/shm"); err != nil {
+		t.Skip("no /dev/shm")
+	}
+	if _, err := fuzzBridge(FuzzBridgeOptions{Command: []string{"true"}}); err == nil {
+		t.Error("expected an error for a run outside a fuzzer without --output")
+	}
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found")
+	}
+	tmp := t.TempDir()
+	os.Setenv("PIN_ROOT", tmp)
+	os.Setenv("PIN_TOOL_SEARCH_DIR", tmp)
+	os.Setenv("SAFE_BIN_DIR", tmp)
+	os.Setenv("LOG_DIR", tmp)
+	if err := os.WriteFile(filepath.Join(tmp, "FuncTracer.so"), []byte("dummy"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A fake pin records its arguments, counts a transition in the map and
+	// runs the binary after "--".
+	pin := "#!/bin/bash\necho \"$*\" >> \"$PIN_ROOT/pin.calls\"\n" +
+		"printf '\\003' | dd of=\"/dev/shm$__AFL_SHM_ID\" bs=1 seek=5 conv=notrunc status=none\n" +
+		"while [ \"$1\" != -- ]; do shift; done\nshift\nexec \"$@\"\n"
+	if err := os.WriteFile(filepath.Join(tmp, "pin"), []byte(pin), 0755); err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(tmp, "main.c")
+	if err := os.WriteFile(src, []byte("int main() { return 7; }"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bin := filepath.Join(tmp, "bin")
+	if out, err := exec.Command("gcc", "-g", "-o", bin, src).CombinedOutput(); err != nil {
+		t.Fatalf("failed to compile: %v\n%s", err, out)
+	}
+	output := filepath.Join(tmp, "map.txt")
+	status, err := fuzzBridge(FuzzBridgeOptions{MapSize: 64, Output: output, Command: []string{bin}})
+	if err != nil || status != 7 {
+		t.Fatalf("expected the status of the command, got %d (err: %v)", status, err)
+	}
+	if got, _ := os.ReadFile(output); string(got) != "000005:3\n" {
+		t.Errorf("expected the transition of the map, got %q", got)
+	}
+
+	// A wrapped binary runs through its wrapper, which passes -afl itself.
+	if err := wrap(bin, WrapOptions{}); err != nil {
+		t.Fatalf("wrap failed: %v", err)
+	}
+	defer unwrap(bin)
+	if status, err := fuzzBridge(FuzzBridgeOptions{MapSize: 64, Output: output, Command: []string{bin}}); err != nil || status != 7 {
+		t.Fatalf("expected the status of the wrapped command, got %d (err: %v)", status, err)
+	}
+	calls, err := os.ReadFile(filepath.Join(tmp, "pin.calls"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(calls)), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], "-o /dev/null -afl 1 --") || !strings.Contains(lines[1], "-afl 1") {
+		t.Errorf("expected pin to run with -afl, got:\n%s", calls)
+	}
+	if got, _ := os.ReadFile(output); string(got) != "000005:3\n" {
+		t.Errorf("expected the transition of the wrapped run, got %q", got)
+	}
+}
+
+func TestWrapperEvents(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	syslog := "Oct 17 10:00:00 host1 funkoverage[42]: event=start binary=ls pid=42 log=/var/coverage/data/my logs/ls.log\n" +
+		"Oct 17 10:00:01 host1 systemd[1]: Started something.\n" +
+		"2026-10-17T10:00:02.5+00:00 host1 funkoverage[42]: event=stop binary=ls pid=42 status=0 log=/tmp/ls.log\n" +
+		"Oct 17 11:00:00 host2 funkoverage: event=failure binary=tar pid=7 status=127 reason=pin-not-started\n"
+	events, err := readSyslogEvents(strings.NewReader(syslog), now)
+	if err != nil || len(events) != 3 {
+		t.Fatalf("expected 3 events, got %+v (err: %v)", events, err)
+	}
+	if e := events[0]; e.Event != activityStart || e.Binary != "ls" || e.PID != 42 || e.Host != "host1" ||
+		!e.Time.Equal(time.Date(2026, 10, 17, 10, 0, 0, 0, time.UTC)) || e.Fields["log"] != "/var/coverage/data/my logs/ls.log" {
+		t.Errorf("unexpected start event %+v", e)
+	}
+	if e := events[1]; e.Event != activityStop || e.Fields["status"] != "0" || !e.Time.Equal(time.Date(2026, 10, 17, 10, 0, 2, 5e8, time.UTC)) {
+		t.Errorf("unexpected stop event %+v", e)
+	}
+	if e := events[2]; e.Event != activityFailure || e.Host != "host2" || e.Fields["reason"] != "pin-not-started" {
+		t.Errorf("unexpected failure event %+v", e)
+	}
+	since, err := parseEventSince("90m", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := EventFilter{Since: since, Event: activityFailure}
+	if !filter.match(events[2]) || filter.match(events[0]) {
+		t.Errorf("filter %+v matched the wrong events", filter)
+	}
+
+	journal := `{"MESSAGE":"event=start binary=ls pid=42 log=/tmp/ls.log","__REALTIME_TIMESTAMP":"1792238400000000","_HOSTNAME":"host1"}
+{"MESSAGE":[1,2,3],"__REALTIME_TIMESTAMP":"1792238400000001"}
+`
+	events, err = readJournalEvents(strings.NewReader(journal))
+	if err != nil || len(events) != 1 || events[0].Host != "host1" || events[0].Time.Unix() != 1792238400 {
+		t.Fatalf("unexpected journal events %+v (err: %v)", events, err)
+	}
+	var out bytes.Buffer
+	if err := printWrapperEvents(&out, events, false); err != nil || !strings.Contains(out.String(), "start") || !strings.Contains(out.String(), "log=/tmp/ls.log") {
+		t.Errorf("unexpected listing %q (err: %v)", out.String(), err)
+	}
+
+	// A wrapped run logs its start and stop through logger.
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found")
+	}
+	tmp := t.TempDir()
+	os.Setenv("PIN_ROOT", tmp)
+	os.Setenv("PIN_TOOL_SEARCH_DIR", tmp)
+	os.Setenv("SAFE_BIN_DIR", tmp)
+	os.Setenv("LOG_DIR", tmp)
+	if err := os.WriteFile(filepath.Join(tmp, "FuncTracer.so"), []byte("dummy"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The fake pin records its pid and arguments and runs the binary after "--".
+	pin := "#!/bin/bash\necho \"$$ $*\" > \"$PIN_ROOT/pin.args\"\nwhile [ \"$1\" != -- ]; do shift; done\nshift\nexec \"$@\"\n"
+	logger := "#!/bin/bash\necho \"$*\" >> \"$PIN_ROOT/syslog\"\n"
+	binDir := filepath.Join(tmp, "path")
+	if err := os.Mkdir(binDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmp, "pin"), []byte(pin), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(binDir, "logger"), []byte(logger), 0755); err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(tmp, "main.c")
+	if err := os.WriteFile(src, []byte("int main() { return 3; }"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bin := filepath.Join(tmp, "bin")
+	if out, err := exec.Command("gcc", "-g", "-o", bin, src).CombinedOutput(); err != nil {
+		t.Fatalf("failed to compile: %v\n%s", err, out)
+	}
+	if err := wrap(bin, WrapOptions{}); err != nil {
+		t.Fatalf("wrap failed: %v", err)
+	}
+	defer unwrap(bin)
+	cmd := exec.Command(bin)
+	cmd.Env = append(os.Environ(), "PATH="+binDir+":"+os.Getenv("PATH"))
+	if err := cmd.Run(); cmd.ProcessState == nil || cmd.ProcessState.ExitCode() != 3 {
+		t.Fatalf("expected the exit status of the binary, got %v", err)
+	}
+	logged, err := os.ReadFile(filepath.Join(tmp, "syslog"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(logged)), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], "-t funkoverage -p user.info -- event=start binary=bin") {
+		t.Errorf("unexpected logger calls:\n%s", logged)
+	}
+	// The wrapper execs pin, whose FuncTracer ends the log of the process
+	// keeping the wrapper's pid and logs the stop.
+	args, err := os.ReadFile(filepath.Join(tmp, "pin.args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, _, _ := strings.Cut(string(args), " ")
+	if !strings.Contains(string(args), " -exit_pid "+pid+" -started ") {
+		t.Errorf("expected pin to run in the wrapper process, got %q", args)
+	}
+
+	// Without pin, the wrapper logs the failure.
+	if err := os.Remove(filepath.Join(tmp, "pin")); err != nil {
+		t.Fatal(err)
+	}
+	cmd = exec.Command(bin)
+	cmd.Env = append(os.Environ(), "PATH="+binDir+":"+os.Getenv("PATH"))
+	if err := cmd.Run(); cmd.ProcessState == nil || cmd.ProcessState.ExitCode() != 127 {
+		t.Fatalf("expected status 127 without pin, got %v", err)
+	}
+	logged, _ = os.ReadFile(filepath.Join(tmp, "syslog"))
+	lines = strings.Split(strings.TrimSpace(string(logged)), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[2], "-p user.err -- event=failure binary=bin") || !strings.Contains(lines[2], "status=127 reason=pin-not-started") {
+		t.Errorf("unexpected logger calls:\n%s", logged)
+	}
+}
+
+func TestExecutionSummary(t *testing.T) {
+	tmp := t.TempDir()
+	started := time.Unix(1790000000, 0)
+	runID := fmt.Sprintf("2a-%x", started.Add(250*time.Millisecond).UnixNano())
+	logs := map[string]string{
+		"ls_20260101-100000_1.log":  "[FuncTracer] [Format:6] [Run:" + runID + "]\n[Image:/bin/ls] [Function:foo]\n" + fmt.Sprintf("[FuncTracer] [Exit:0] [WallMs:1500] [Started:%d]\n", started.UnixNano()),
+		"ls_20260101-100001_2.log":  "[FuncTracer] [Format:6]\n[Image:/bin/ls] [Function:foo]\n[FuncTracer] [Exit:2] [WallMs:500] [Started:1]\n",
+		"tar_20260101-100002_3.log": "[FuncTracer] [Format:6]\n[Image:/bin/tar] [Function:bar]\n[FuncTracer] [Exit:137] [Signal:9] [WallMs:100]\n",
+		"old_20260101-100003_4.log": "[FuncTracer] [Format:6]\n[Image:/bin/old] [Function:baz]\n",
+	}
+	var files []string
+	for name, content := range logs {
+		path := filepath.Join(tmp, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, path)
+	}
+	sort.Strings(files)
+	_, stats, err := analyzeLogsWith(files, AnalyzeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := stats[0].Exit; e == nil || e.Code != 0 || e.Wall != 1500*time.Millisecond || e.Startup != 250*time.Millisecond {
+		t.Fatalf("unexpected exit of %s: %+v", stats[0].File, e)
+	}
+	for _, s := range stats {
+		if s.Malformed != 0 {
+			t.Errorf("%s: trailer counted as malformed", s.File)
+		}
+	}
+	summary := executionSummary(stats)
+	if summary == nil || len(summary.Rows) != 2 || summary.NoTrailer != 1 {
+		t.Fatalf("unexpected summary %+v", summary)
+	}
+	ls, tar := summary.Rows[0], summary.Rows[1]
+	if ls.Binary != "ls" || ls.Runs != 2 || ls.Failed != 1 || ls.MeanWall() != time.Second || ls.MeanStartup() != 250*time.Millisecond {
+		t.Errorf("unexpected ls row %+v", ls)
+	}
+	if tar.Binary != "tar" || tar.Signaled != 1 || tar.Failed != 0 || tar.MeanStartup() != 0 {
+		t.Errorf("unexpected tar row %+v", tar)
+	}
+	if summary.Total.Runs != 3 || summary.Total.Wall != 2100*time.Millisecond {
+		t.Errorf("unexpected total %+v", summary.Total)
+	}
+
+	out := t.TempDir()
+	if err := generateAggregateHTMLReport(map[string]*CoverageData{}, nil, nil, stats, AggregateView{Thresholds: defaultThresholds}, out, time.Unix(0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	html, err := os.ReadFile(filepath.Join(out, aggregateReportFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(html), "Execution Summary") || !strings.Contains(string(html), "<td>250ms</td>") {
+		t.Errorf("aggregate report misses the execution summary")
+	}
+}
+
+func TestExplainLogContext(t *testing.T) {
+	tmp := t.TempDir()
+	log := filepath.Join(tmp, "ls_20260921-141320_1.log")
+	content := "[FuncTracer] [Format:7] [Run:2a-1]\n" +
+		"[FuncTracer] [Context] [Time:2026-09-21T14:13:20Z] [Host:host1] [Cwd:/tmp/my dir] [Arg:ls] [Arg:-l] [Arg:a\\x5db] [Env:LANG=C]\n" +
+		"[Image:/bin/ls] [BuildID:a2b6365ae90d61af]\n" +
+		"[Image:/bin/ls] [Function:foo]\n[Image:/bin/ls] [Function:bar]\n[Image:/bin/ls] [Called:foo]\n" +
+		"[FuncTracer] [Exit:1] [WallMs:20]\n"
+	if err := os.WriteFile(log, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	logs, err := explainLogs([]string{log})
+	if err != nil || len(logs) != 1 {
+		t.Fatalf("explainLogs: %v, %+v", err, logs)
+	}
+	l := logs[0]
+	if l.Malformed != 0 {
+		t.Errorf("context or build ID line counted as malformed")
+	}
+	c := l.Context
+	if c == nil || c.Host != "host1" || c.Cwd != "/tmp/my dir" || !reflect.DeepEqual(c.Argv, []string{"ls", "-l", "a]b"}) ||
+		c.Env["LANG"] != "C" || !c.Time.Equal(time.Date(2026, 9, 21, 14, 13, 20, 0, time.UTC)) {
+		t.Fatalf("unexpected context %+v", c)
+	}
+	if len(l.Images) != 1 || l.Images[0].BuildID != "a2b6365ae90d61af" || l.Images[0].CalledCount != 1 || l.Images[0].CoveragePct != 50 {
+		t.Errorf("unexpected images %+v", l.Images)
+	}
+
+	var out bytes.Buffer
+	if err := printExplainedLogs(&out, logs, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Host:     host1", "Command:  ls -l 'a]b'", "Env:      LANG=C", "Exit:     status 1 after 20ms", "/bin/ls: 1/2 functions called (50.0%), build ID a2b6365ae90d61af"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("explain output misses %q:\n%s", want, out.String())
+		}
+	}
+	out.Reset()
+	if err := printExplainedLogs(&out, logs, true); err != nil {
+		t.Fatal(err)
+	}
+	var decoded []map[string]any
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil || decoded[0]["context"] == nil || decoded[0]["images"] == nil {
+		t.Errorf("unexpected JSON %s (err: %v)", out.String(), err)
+	}
+}
+
+func TestSessionsAndTags(t *testing.T) {
+	tmp := t.TempDir()
+	logs := map[string]string{
+		"a_20260101-100000_1.log": "[FuncTracer] [Format:7] [Session:smoke] [Tags:nightly,x86]\n[Image:/bin/prog] [Function:foo]\n[Image:/bin/prog] [Function:bar]\n[Image:/bin/prog] [Called:foo]\n",
+		"b_20260101-100001_2.log": "[FuncTracer] [Format:7] [Session:regression] [Tags:x86]\n[Image:/bin/prog] [Function:foo]\n[Image:/bin/prog] [Function:bar]\n[Image:/bin/prog] [Called:foo]\n[Image:/bin/prog] [Called:bar]\n",
+		"c_20260101-100002_3.log": "[FuncTracer] [Format:6]\n[Image:/bin/prog] [Function:foo]\n[Image:/bin/prog] [Function:bar]\n",
+	}
+	var files []string
+	for name, content := range logs {
+		path := filepath.Join(tmp, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, path)
+	}
+	sort.Strings(files)
+
+	session, tags := parseLogLabels([]byte("[FuncTracer] [Format:7] [Run:1-2] [Session:smoke \\x5bci\\x5d] [Tags:a, b]"))
+	if session != "smoke [ci]" || !reflect.DeepEqual(tags, []string{"a", "b"}) {
+		t.Errorf("parseLogLabels = %q, %q", session, tags)
+	}
+	for _, tc := range []struct {
+		filter LabelFilter
+		want   int
+	}{
+		{LabelFilter{}, 3},
+		{LabelFilter{Sessions: []string{"smoke"}}, 1},
+		{LabelFilter{Tags: []string{"x86"}}, 2},
+		{LabelFilter{Sessions: []string{"regression"}, Tags: []string{"nightly"}}, 0},
+	} {
+		kept, err := filterLogs(files, tc.filter)
+		if err != nil || len(kept) != tc.want {
+			t.Errorf("filterLogs(%+v) = %v, %v; want %d logs", tc.filter, kept, err, tc.want)
+		}
+	}
+
+	sessions, err := sessionSummaries(files, AnalyzeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SessionSummary{
+		{Session: noSession, Logs: 1, Images: 1, TotalCount: 2, CalledCount: 0, CoveragePct: 0},
+		{Session: "regression", Logs: 1, Tags: []string{"x86"}, Images: 1, TotalCount: 2, CalledCount: 2, CoveragePct: 100},
+		{Session: "smoke", Logs: 1, Tags: []string{"nightly", "x86"}, Images: 1, TotalCount: 2, CalledCount: 1, CoveragePct: 50},
+	}
+	if !reflect.DeepEqual(sessions, want) {
+		t.Errorf("sessionSummaries = %+v, want %+v", sessions, want)
+	}
+	_, stats, err := analyzeLogsWith(files[:1], AnalyzeOptions{})
+	if err != nil || stats[0].Session != "smoke" || len(stats[0].Tags) != 2 {
+		t.Errorf("expected the labels in the log stats, got %+v (err: %v)", stats, err)
+	}
+
+	srv := httptest.NewServer(newServeMux(tmp))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/api/coverage?session=smoke")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var points []map[string]any
+	// One point for the image and one for the total, of the smoke log only.
+	if err := json.NewDecoder(resp.Body).Decode(&points); err != nil || len(points) != 2 {
+		t.Errorf("expected the timeline of the smoke session only, got %v (err: %v)", points, err)
+	}
+}
+
+func TestWrapDisableRules(t *testing.T) {
+	r, err := parseDisableRule("uid=0, cwd=/etc/init.d/, boot")
+	if err != nil || r != (DisableRule{UID: 0, Cwd: "/etc/init.d", Boot: true}) || r.String() != "uid=0,cwd=/etc/init.d,boot" {
+		t.Errorf("parseDisableRule = %+v (%q), %v", r, r.String(), err)
+	}
+	for _, bad := range []string{"", "uid=-1", "uid=root", "cwd=relative", "user=", "ppid=1", "cwd=/a\n/b"} {
+		if _, err := parseDisableRule(bad); err == nil {
+			t.Errorf("parseDisableRule(%q): expected an error", bad)
+		}
+	}
+	if disableRulesScript(nil) != "" {
+		t.Error("expected no script without rules")
+	}
+
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found")
+	}
+	tmp := t.TempDir()
+	os.Setenv("PIN_ROOT", tmp)
+	os.Setenv("PIN_TOOL_SEARCH_DIR", tmp)
+	os.Setenv("SAFE_BIN_DIR", tmp)
+	os.Setenv("LOG_DIR", tmp)
+	if err := os.WriteFile(filepath.Join(tmp, "FuncTracer.so"), []byte("dummy"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pin := "#!/bin/bash\necho traced >> \"$PIN_ROOT/pin.calls\"\nwhile [ \"$1\" != -- ]; do shift; done\nshift\nexec \"$@\"\n"
+	if err := os.WriteFile(filepath.Join(tmp, "pin"), []byte(pin), 0755); err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(tmp, "main.c")
+	if err := os.WriteFile(src, []byte("int main() { return 0; }"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bin := filepath.Join(tmp, "bin")
+	if out, err := exec.Command("gcc", "-g", "-o", bin, src).CombinedOutput(); err != nil {
+		t.Fatalf("failed to compile: %v\n%s", err, out)
+	}
+	quiet := filepath.Join(tmp, "it's quiet")
+	if err := os.Mkdir(filepath.Join(quiet), 0755); err != nil {
+		t.Fatal(err)
+	}
+	rules := []DisableRule{
+		{UID: -1, Cwd: quiet},
+		{UID: os.Geteuid(), User: "no-such-user-here"},
+	}
+	if err := wrap(bin, WrapOptions{Disable: rules}); err != nil {
+		t.Fatalf("wrap failed: %v", err)
+	}
+	defer unwrap(bin)
+	for _, dir := range []string{filepath.Join(quiet), tmp} {
+		cmd := exec.Command(bin)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("wrapped run in %s failed: %v\n%s", dir, err, out)
+		}
+	}
+	calls, _ := os.ReadFile(filepath.Join(tmp, "pin.calls"))
+	if strings.Count(string(calls), "traced") != 1 {
+		t.Errorf("expected only the run outside %s to be traced, got %q", quiet, calls)
+	}
+}
+
+func TestLogNameTemplate(t *testing.T) {
+	for _, bad := range []string{"{binary}.txt", "{binary}_{timestamp}.log", "{binary}_{user}_{pid}.log", "logs/{pid}.log", "{binary_{pid}.log"} {
+		if _, err := parseLogName(bad); err == nil {
+			t.Errorf("expected log name %q to be refused", bad)
+		}
+	}
+	started := time.Date(2024, 5, 6, 7, 8, 9, 42, time.UTC)
+	if got := expandLogName("", "ls", 7, "", "h", started); got != "ls_20240506-070809_000000042.log" {
+		t.Errorf("default log name = %q", got)
+	}
+	if got := expandLogName("{host}-{session}-{binary}-{pid}.log", "ls", 7, "nightly/2", "box.example", started); got != "box.example-nightly_2-ls-7.log" {
+		t.Errorf("expanded log name = %q", got)
+	}
+
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found")
+	}
+	tmp := t.TempDir()
+	logDir := filepath.Join(tmp, "logs")
+	os.Setenv("PIN_ROOT", tmp)
+	os.Setenv("PIN_TOOL_SEARCH_DIR", tmp)
+	os.Setenv("SAFE_BIN_DIR", filepath.Join(tmp, "store"))
+	os.Setenv("LOG_DIR", logDir)
+	if err := os.WriteFile(filepath.Join(tmp, "FuncTracer.so"), []byte("dummy"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pin := "#!/bin/bash\nwhile [ \"$1\" != -- ]; do [ \"$1\" = -o ] && log=$2; shift; done\nshift\necho '[Image:/p] [Called:main]' > \"$log\"\nexec \"$@\"\n"
+	if err := os.WriteFile(filepath.Join(tmp, "pin"), []byte(pin), 0755); err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(tmp, "main.c")
+	if err := os.WriteFile(src, []byte("int main() { return 0; }"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bin := filepath.Join(tmp, "prog")
+	if out, err := exec.Command("gcc", "-g", "-o", bin, src).CombinedOutput(); err != nil {
+		t.Fatalf("failed to compile: %v\n%s", err, out)
+	}
+	if err := wrap(bin, WrapOptions{LogName: "{host}-{session}-{binary}-{pid}-{timestamp}.log"}); err != nil {
+		t.Fatalf("wrap failed: %v", err)
+	}
+	defer unwrap(bin)
+	cmd := exec.Command(bin)
+	cmd.Env = append(os.Environ(), "COVERAGE_SESSION=nightly/2", "HOSTNAME=box.example")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("wrapped run failed: %v\n%s", err, out)
+	}
+	logs, _ := filepath.Glob(filepath.Join(logDir, "*.log"))
+	if len(logs) != 1 || !regexp.MustCompile(`^box\.example-nightly_2-prog-\d+-\d{8}-\d{6}\.log$`).MatchString(filepath.Base(logs[0])) {
+		t.Errorf("expected a log named after the template, got %v", logs)
+	}
+	if m, _ := loadManifest(filepath.Join(tmp, "store")); len(m.Entries) != 1 || m.Entries[0].LogName != "{host}-{session}-{binary}-{pid}-{timestamp}.log" {
+		t.Errorf("expected the template in the manifest, got %+v", m.Entries)
+	}
+}
+
+func TestCoverageWindows(t *testing.T) {
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found")
+	}
+	tmp := t.TempDir()
+	logDir := filepath.Join(tmp, "logs")
+	os.Setenv("PIN_ROOT", tmp)
+	os.Setenv("PIN_TOOL_SEARCH_DIR", tmp)
+	os.Setenv("SAFE_BIN_DIR", filepath.Join(tmp, "store"))
+	os.Setenv("LOG_DIR", logDir)
+	if err := os.WriteFile(filepath.Join(tmp, "FuncTracer.so"), []byte("dummy"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pin := "#!/bin/bash\necho \"$*\" >> \"$PIN_ROOT/pin.calls\"\nwhile [ \"$1\" != -- ]; do shift; done\nshift\nexec \"$@\"\n"
+	if err := os.WriteFile(filepath.Join(tmp, "pin"), []byte(pin), 0755); err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(tmp, "main.c")
+	if err := os.WriteFile(src, []byte("int main() { return 0; }"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bin := filepath.Join(tmp, "prog")
+	if out, err := exec.Command("gcc", "-g", "-o", bin, src).CombinedOutput(); err != nil {
+		t.Fatalf("failed to compile: %v\n%s", err, out)
+	}
+	if err := wrap(bin, WrapOptions{}); err != nil {
+		t.Fatalf("wrap failed: %v", err)
+	}
+	defer unwrap(bin)
+	run := func() string {
+		t.Helper()
+		calls := filepath.Join(tmp, "pin.calls")
+		os.Remove(calls)
+		cmd := exec.Command(bin)
+		cmd.Env = append(os.Environ(), "COVERAGE_TAGS=nightly")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("wrapped run failed: %v\n%s", err, out)
+		}
+		got, _ := os.ReadFile(calls)
+		return string(got)
+	}
+
+	if got := run(); !strings.Contains(got, "-tags nightly --") {
+		t.Errorf("expected a traced run without windows, got %q", got)
+	}
+	if err := startWindow(logDir, "bad label"); err == nil {
+		t.Error("expected a label with a space to be refused")
+	}
+	if err := startWindow(logDir, "phase-1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := run(); !strings.Contains(got, "-tags nightly,phase-1 --") {
+		t.Errorf("expected a run tagged with the window, got %q", got)
+	}
+	if err := stopWindow(logDir, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := run(); got != "" {
+		t.Errorf("expected no traced run outside the window, got %q", got)
+	}
+	if err := stopWindow(logDir, false); err == nil {
+		t.Error("expected stopping a closed window to fail")
+	}
+	if err := stopWindow(logDir, true); err != nil {
+		t.Fatal(err)
+	}
+	if got := run(); !strings.Contains(got, "-tags nightly --") {
+		t.Errorf("expected a traced run after the reset, got %q", got)
+	}
+
+	os.Setenv("COVERAGE_TAGS", "nightly")
+	defer os.Unsetenv("COVERAGE_TAGS")
+	if args := strings.Join(pinToolArgs("", "", "phase-1"), " "); args != "-tags nightly,phase-1" {
+		t.Errorf("launcher tool args = %q", args)
+	}
+}
+
+func TestTracingControl(t *testing.T) {
+	var rules []ControlRule
+	var err error
+	for _, req := range []controlRequest{{"disable", "*"}, {"enable", "python3*"}, {"disable", "python3.12"}} {
+		if rules, err = applyControl(rules, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, want := range map[string]bool{"ls": false, "python3": true, "python3.11": true, "python3.12": false} {
+		if got := controlTraced(rules, name); got != want {
+			t.Errorf("traced(%s) = %v, want %v", name, got, want)
+		}
+	}
+	if rules, _ = applyControl(rules, controlRequest{"enable", "*"}); !controlTraced(rules, "ls") || len(rules) != 3 {
+		t.Errorf("expected the rule of a pattern to be replaced and applied last, got %+v", rules)
+	}
+	for _, bad := range []controlRequest{{"disable", ""}, {"disable", "bin/ls"}, {"disable", "[ls"}, {"pause", "ls"}} {
+		if _, err := applyControl(nil, bad); err == nil {
+			t.Errorf("expected %+v to be refused", bad)
+		}
+	}
+
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found")
+	}
+	tmp := t.TempDir()
+	logDir := filepath.Join(tmp, "logs")
+	os.Setenv("PIN_ROOT", tmp)
+	os.Setenv("PIN_TOOL_SEARCH_DIR", tmp)
+	os.Setenv("SAFE_BIN_DIR", filepath.Join(tmp, "store"))
+	os.Setenv("LOG_DIR", logDir)
+	if err := os.WriteFile(filepath.Join(tmp, "FuncTracer.so"), []byte("dummy"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pin := "#!/bin/bash\necho traced >> \"$PIN_ROOT/pin.calls\"\nwhile [ \"$1\" != -- ]; do shift; done\nshift\nexec \"$@\"\n"
+	if err := os.WriteFile(filepath.Join(tmp, "pin"), []byte(pin), 0755); err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(tmp, "main.c")
+	if err := os.WriteFile(src, []byte("int main() { return 0; }"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bin := filepath.Join(tmp, "prog")
+	if out, err := exec.Command("gcc", "-g", "-o", bin, src).CombinedOutput(); err != nil {
+		t.Fatalf("failed to compile: %v\n%s", err, out)
+	}
+	if err := wrap(bin, WrapOptions{}); err != nil {
+		t.Fatalf("wrap failed: %v", err)
+	}
+	defer unwrap(bin)
+	traced := func() bool {
+		t.Helper()
+		calls := filepath.Join(tmp, "pin.calls")
+		os.Remove(calls)
+		if out, err := exec.Command(bin).CombinedOutput(); err != nil {
+			t.Fatalf("wrapped run failed: %v\n%s", err, out)
+		}
+		_, err := os.Stat(calls)
+		return err == nil
+	}
+
+	// Without a server, ctl updates the control file itself.
+	if _, err := sendControl(logDir, "", controlRequest{Command: "disable", Pattern: "pr*"}); err != nil {
+		t.Fatal(err)
+	}
+	if traced() {
+		t.Error("expected the disabled binary to run untraced")
+	}
+
+	// Through the control socket.
+	socket := filepath.Join(tmp, "ctl.sock")
+	go serveControl(logDir, socket)
+	for i := 0; i < 100 && !fileExists(socket); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	rules, err = sendControl(logDir, socket, controlRequest{Command: "enable", Pattern: "prog"})
+	if err != nil || len(rules) != 2 {
+		t.Fatalf("enable through the socket = %+v, %v", rules, err)
+	}
+	if !traced() {
+		t.Error("expected the enabled binary to be traced")
+	}
+	if _, err := sendControl(logDir, socket, controlRequest{Command: "disable", Pattern: "[x"}); err == nil {
+		t.Error("expected the server to refuse an invalid pattern")
+	}
+	if rules, err := sendControl(logDir, socket, controlRequest{Command: "reset"}); err != nil || len(rules) != 0 || fileExists(filepath.Join(logDir, controlFileName)) {
+		t.Errorf("reset = %+v, %v", rules, err)
+	}
+}
+
+func TestImportForeignCoverage(t *testing.T) {
+	tmp := t.TempDir()
+	out := filepath.Join(tmp, "logs")
+	write := func(name string, data []byte) string {
+		path := filepath.Join(tmp, name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	called := func(log string) (*CoverageData, LogStats) {
+		coverage, stats, err := analyzeLogsWith([]string{log}, AnalyzeOptions{})
+		if err != nil {
+			t.Fatalf("analyzing %s: %v", log, err)
+		}
+		if len(coverage) != 1 {
+			t.Fatalf("expected one image in %s, got %v", log, sortedKeys(coverage))
+		}
+		for _, data := range coverage {
+			return data, stats[0]
+		}
+		return nil, stats[0]
+	}
+
+	lcov := write("app.info", []byte("TN:\nSF:/src/app.c\nFN:3,used\nFN:7,9,unused\nFNDA:4,used\nFNDA:0,unused\nend_of_record\n"))
+	if _, err := importLogs([]string{lcov}, out, ImportOptions{Format: "lcov"}); err == nil {
+		t.Error("expected lcov without --image to fail")
+	}
+	logs, err := importLogs([]string{lcov}, out, ImportOptions{Format: "lcov", Image: "/usr/bin/app"})
+	if err != nil || len(logs) != 1 || !strings.HasPrefix(filepath.Base(logs[0]), "app_") {
+		t.Fatalf("lcov import = %v, %v", logs, err)
+	}
+	data, stats := called(logs[0])
+	if len(data.TotalFunctions) != 2 || len(data.CalledFunctions) != 1 || data.Calls["used"] != 4 || stats.Import != "lcov" || !strings.HasPrefix(stats.RunID, "import-") {
+		t.Errorf("lcov import: %+v, %+v", data, stats)
+	}
+
+	gcov := write("app.c.gcov.json", []byte(`{"files":[{"file":"app.c","functions":[{"name":"used","execution_count":2},{"name":"unused","execution_count":0}]}]}`))
+	logs, err = importLogs([]string{gcov}, out, ImportOptions{Format: "gcov", Image: "/usr/bin/app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := called(logs[0]); len(data.TotalFunctions) != 2 || data.Calls["used"] != 2 {
+		t.Errorf("gcov import: %+v", data)
+	}
+
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found")
+	}
+	src := write("main.c", []byte("__attribute__((noinline)) int used(int x) { return x + 1; }\n__attribute__((noinline)) int unused(int x) { return x - 1; }\nint main() { return used(0) - 1; }\n"))
+	bin := filepath.Join(tmp, "app")
+	if out, err := exec.Command("gcc", "-O0", "-o", bin, src).CombinedOutput(); err != nil {
+		t.Fatalf("failed to compile: %v\n%s", err, out)
+	}
+	f, err := elf.Open(bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	syms, _ := f.Symbols()
+	f.Close()
+	var usedAddr uint64
+	for _, s := range syms {
+		if s.Name == "used" {
+			usedAddr = s.Value
+		}
+	}
+	base, err := loadImageSymbols(bin)
+	if err != nil || usedAddr == 0 {
+		t.Fatalf("no symbols in %s: %v", bin, err)
+	}
+	off := usedAddr - base.base + 2
+
+	var drcov bytes.Buffer
+	fmt.Fprintf(&drcov, "DRCOV VERSION: 2\nDRCOV FLAVOR: drcov\nModule Table: version 2, count 1\nColumns: id, base, end, entry, checksum, timestamp, path\n")
+	fmt.Fprintf(&drcov, " 0, 0x00007f0000000000, 0x00007f0000100000, 0x0000000000000000, 0x00000000, 0x00000000, %s\nBB Table: 1 bbs\n", bin)
+	drcov.Write([]byte{byte(off), byte(off >> 8), byte(off >> 16), byte(off >> 24), 4, 0, 0, 0})
+	logs, err = importLogs([]string{write("drcov.app.1.proc.log", drcov.Bytes())}, out, ImportOptions{Format: "drcov"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ = called(logs[0])
+	if _, ok := data.CalledFunctions["used"]; !ok || len(data.CalledFunctions) != 1 {
+		t.Errorf("drcov import: called %v", sortedKeys(data.CalledFunctions))
+	}
+	if _, ok := data.TotalFunctions["unused"]; !ok {
+		t.Errorf("drcov import: functions %v", sortedKeys(data.TotalFunctions))
+	}
+
+	sancov := make([]byte, 16)
+	binary.LittleEndian.PutUint64(sancov, sancovMagic64)
+	binary.LittleEndian.PutUint64(sancov[8:], off)
+	input := write("app.1234.sancov", sancov)
+	if _, err := importLogs([]string{input}, out, ImportOptions{Format: "sancov"}); err == nil {
+		t.Error("expected sancov without --binary to fail")
+	}
+	logs, err = importLogs([]string{input}, out, ImportOptions{Format: "sancov", Binary: bin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := called(logs[0]); len(data.CalledFunctions) != 1 {
+		t.Errorf("sancov import: called %v", sortedKeys(data.CalledFunctions))
+	}
+	if _, err := importLogs([]string{input}, out, ImportOptions{Format: "bullseye"}); err == nil {
+		t.Error("expected an unknown format to fail")
+	}
+}
+
+func TestPerfettoTraceExport(t *testing.T) {
+	image, function, pid, tid, at, ok := parseFirstCallLine([]byte("[Image:/bin/prog] [Addr:0x10] [Pid:42] [Tid:43] [Time:1700000000000001000] [First:op\\x5b\\x5d]"))
+	if !ok || string(image) != "/bin/prog" || string(function) != "op[]" || pid != 42 || tid != 43 || at.UnixNano() != 1700000000000001000 {
+		t.Fatalf("parseFirstCallLine = %q %q %d %d %v %v", image, function, pid, tid, at, ok)
+	}
+
+	tmp := t.TempDir()
+	logs := filepath.Join(tmp, "logs")
+	if err := os.Mkdir(logs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// pid 0x2a started at 1700000000000000000 ns, ran 5 ms
+	content := "[FuncTracer] [Format:8] [Run:2a-17979cfe362a0000]\n" +
+		"[FuncTracer] [Context] [Time:2023-11-14T22:13:20Z] [Host:h] [Cwd:/] [Arg:/usr/bin/prog]\n" +
+		"[Image:/bin/prog] [Function:init]\n" +
+		"[Image:/bin/prog] [Function:work]\n" +
+		"[Image:/bin/prog] [Count:3] [Called:init]\n" +
+		"[Image:/bin/prog] [Pid:42] [Tid:42] [Time:1700000000000002000] [First:init]\n" +
+		"[Image:/bin/prog] [Count:1] [Called:work]\n" +
+		"[Image:/bin/prog] [Pid:42] [Tid:44] [Time:1700000000000004000] [First:work]\n" +
+		"[FuncTracer] [Exit:0] [WallMs:5] [Started:1699999999999000000]\n"
+	logFile := filepath.Join(logs, "prog_20231114-221320_1.log")
+	if err := os.WriteFile(logFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, stats, err := analyzeLogsWith([]string{logFile}, AnalyzeOptions{})
+	if err != nil || stats[0].Malformed != 0 {
+		t.Fatalf("first-call lines counted as malformed: %+v, %v", stats, err)
+	}
+
+	out := filepath.Join(tmp, "out")
+	if err := runReport(ReportOptions{Inputs: []string{logs}, OutputDir: out, Formats: []string{"perfetto"}}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(out, traceReportFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var trace struct {
+		TraceEvents []traceEvent `json:"traceEvents"`
+	}
+	if err := json.Unmarshal(data, &trace); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range trace.TraceEvents {
+		names = append(names, fmt.Sprintf("%s:%s:%d:%g", e.Phase, e.Name, e.Tid, e.Ts))
+	}
+	want := []string{
+		"M:process_name:42:0", "M:thread_name:42:0", "M:thread_name:44:0",
+		"X:prog:42:0", "i:init:42:2", "i:work:44:4",
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("trace events = %v, want %v", names, want)
+	}
+	if trace.TraceEvents[3].Dur != 5000 || trace.TraceEvents[4].Args["calls"] != float64(3) {
+		t.Errorf("unexpected span or call count: %+v", trace.TraceEvents)
+	}
+}
+
+func TestImageArchitectures(t *testing.T) {
+	image, arch, ok := parseArchLine([]byte("[Image:/bin/a\\x5db] [Arch:aarch64]"))
+	if !ok || string(image) != "/bin/a]b" || arch != "aarch64" {
+		t.Fatalf("parseArchLine = %q %q %v", image, arch, ok)
+	}
+	if got := elfArchName(elf.EM_PPC64, elf.ELFCLASS64, elf.ELFDATA2LSB); got != "ppc64le" {
+		t.Errorf("elfArchName = %q", got)
+	}
+	foreign := "s390x"
+	if hostArch() == foreign {
+		foreign = "aarch64"
+	}
+	if key := archImageKey("/usr/bin/prog", foreign); key != "/usr/bin/prog ["+foreign+"]" {
+		t.Errorf("archImageKey = %q", key)
+	} else if path, arch := splitArchImage(key); path != "/usr/bin/prog" || arch != foreign {
+		t.Errorf("splitArchImage(%q) = %q, %q", key, path, arch)
+	}
+	if key := archImageKey("/usr/bin/prog", hostArch()); key != "/usr/bin/prog" {
+		t.Errorf("archImageKey of the host arch = %q", key)
+	}
+
+	tmp := t.TempDir()
+	logs := filepath.Join(tmp, "logs")
+	if err := os.Mkdir(logs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, arch := range map[string]string{"prog_20260101-100000_1.log": hostArch(), "prog_20260101-100001_2.log": foreign} {
+		content := "[FuncTracer] [Format:9]\n[Image:/usr/bin/prog] [Arch:" + arch + "]\n" +
+			"[Image:/usr/bin/prog] [Function:foo]\n[Image:/usr/bin/prog] [Function:bar]\n"
+		if arch == foreign {
+			content += "[Image:/usr/bin/prog] [Called:bar]\n"
+		}
+		if err := os.WriteFile(filepath.Join(logs, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	logFiles, _ := collectLogFiles(logs)
+	coverage, stats, err := analyzeLogsWith(logFiles, AnalyzeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	native, other := coverage["/usr/bin/prog"], coverage["/usr/bin/prog ["+foreign+"]"]
+	if native == nil || other == nil || len(native.CalledFunctions) != 0 || len(other.CalledFunctions) != 1 {
+		t.Fatalf("expected the architectures to stay apart, got %v", sortedKeys(coverage))
+	}
+	archs := imageArchs(coverage, stats)
+	if archs["/usr/bin/prog"] != hostArch() || archs["/usr/bin/prog ["+foreign+"]"] != foreign {
+		t.Errorf("imageArchs = %v", archs)
+	}
+	out := filepath.Join(tmp, "out")
+	if err := os.Mkdir(out, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := generateAggregateHTMLReport(coverage, nil, nil, stats, AggregateView{}, out, time.Unix(0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	html, _ := os.ReadFile(filepath.Join(out, aggregateReportFileName))
+	if !bytes.Contains(html, []byte("<th>Arch</th>")) || !bytes.Contains(html, []byte("<td>"+foreign+"</td>")) {
+		t.Error("expected an architecture column in the aggregate report")
+	}
+}
+
+func TestContainerRun(t *testing.T) {
+	tmp := t.TempDir()
+	bin := filepath.Join(tmp, "bin")
+	if err := os.Mkdir(bin, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// The fake engine records its arguments and writes a log into the mount
+	// of the log directory, as the wrapped command would.
+	engine := `#!/bin/bash
+printf '%s\n' "$@" > "$FAKE_ENGINE_ARGS"
+while [ $# -gt 0 ]; do
+    case "$2" in *:/opt/funkoverage/logs) dir=${2%:/opt/funkoverage/logs} ;; esac
+    shift
+done
+echo "[FuncTracer] [Format:9]" > "$dir/ls_20260101-100000_1.log"
+exit 3
+`
+	if err := os.WriteFile(filepath.Join(bin, "podman"), []byte(engine), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("FAKE_ENGINE_ARGS", filepath.Join(tmp, "args"))
+	t.Setenv("PIN_ROOT", tmp)
+	t.Setenv("PIN_TOOL_SEARCH_DIR", tmp)
+	t.Setenv("COVERAGE_SESSION", "smoke")
+	if err := os.WriteFile(filepath.Join(tmp, "FuncTracer.so"), []byte("dummy"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	logDir := filepath.Join(tmp, "logs")
+	status, err := containerRun(ContainerOptions{Engine: "podman", Image: "registry.example.com/app:1.0", LogDir: logDir, Wrap: []string{"/usr/bin/helper"}, Command: []string{"ls", "-l"}})
+	if err != nil || status != 3 {
+		t.Fatalf("containerRun = %d, %v", status, err)
+	}
+	if _, err := os.Stat(filepath.Join(logDir, "registry.example.com_app_1.0_ls_20260101-100000_1.log")); err != nil {
+		t.Errorf("log not collected: %v", err)
+	}
+	if entries, _ := os.ReadDir(logDir); len(entries) != 1 {
+		t.Errorf("expected the staging directory to be removed, got %v", entries)
+	}
+	args, _ := os.ReadFile(filepath.Join(tmp, "args"))
+	for _, want := range []string{tmp + ":/opt/funkoverage/pin:ro", "PIN_ROOT=/opt/funkoverage/pin", "COVERAGE_SESSION", "registry.example.com/app:1.0", `funkoverage wrap "$target" '/usr/bin/helper'`, "ls\n-l\n"} {
+		if !strings.Contains(string(args), want) {
+			t.Errorf("engine arguments lack %q:\n%s", want, args)
+		}
+	}
+	if _, err := containerRun(ContainerOptions{Engine: "podman", LogDir: logDir, Command: []string{"ls"}}); err == nil {
+		t.Error("expected a run without image to fail")
+	}
+}
+
+func TestFleetAgent(t *testing.T) {
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found")
+	}
+	tmp := t.TempDir()
+	store, logDir := filepath.Join(tmp, "store"), filepath.Join(tmp, "logs")
+	for _, dir := range []string{store, logDir} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.Setenv("PIN_ROOT", tmp)
+	os.Setenv("PIN_TOOL_SEARCH_DIR", tmp)
+	os.Setenv("SAFE_BIN_DIR", tmp)
+	os.Setenv("LOG_DIR", logDir)
+	if err := os.WriteFile(filepath.Join(tmp, "FuncTracer.so"), []byte("dummy"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmp, "pin"), []byte("#!/bin/sh\nexit 0\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(tmp, "main.c")
+	if err := os.WriteFile(src, []byte("int main() { return 0; }"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bin := filepath.Join(tmp, "prog")
+	if out, err := exec.Command("gcc", "-g", "-o", bin, src).CombinedOutput(); err != nil {
+		t.Fatalf("failed to compile: %v\n%s", err, out)
+	}
+	log := "[Run:1-2] [Image:/usr/bin/prog] [Function:main]\n[Image:/usr/bin/prog] [Called:main]\n"
+	if err := os.WriteFile(filepath.Join(logDir, "prog_1.log"), []byte(log), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Minute)
+	os.Chtimes(filepath.Join(logDir, "prog_1.log"), old, old)
+
+	srv := httptest.NewServer(newCollectorMux(store, CollectorConfig{Token: "s3cret", AdminToken: "adm1n"}))
+	defer srv.Close()
+	post := func(path string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer adm1n")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	if resp := post("/api/agents/instructions?host=node1&action=wrap&path=" + bin); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("queueing for an unregistered agent: got %s", resp.Status)
+	}
+
+	opts := AgentOptions{URL: srv.URL, Token: "s3cret", Host: "node1", LogDir: logDir, Interval: time.Second, Once: true}
+	if err := runAgent(AgentOptions{URL: srv.URL, Token: "wrong", Host: "node1", LogDir: logDir, Interval: time.Second, Once: true}); err == nil {
+		t.Error("expected a wrong token to be refused")
+	}
+	if err := runAgent(opts); err != nil {
+		t.Fatalf("agent round failed: %v", err)
+	}
+	shipped, _ := os.ReadFile(filepath.Join(store, "node1_prog_1.log"))
+	if string(shipped) != log {
+		t.Errorf("shipped log = %q", shipped)
+	}
+
+	// The token of the agents does not queue instructions.
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/api/agents/instructions?host=*&action=wrap&path="+bin, nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("queueing with the agent token: got %s", resp.Status)
+	}
+	if resp := post("/api/agents/instructions?host=*&action=wrap&path=" + bin); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("queueing the wrap: got %s", resp.Status)
+	}
+	if err := runAgent(opts); err != nil {
+		t.Fatalf("agent round failed: %v", err)
+	}
+	defer unwrap(bin)
+	if content, _ := os.ReadFile(bin); !strings.Contains(string(content), wrapperIDComment) {
+		t.Error("expected the agent to wrap the binary")
+	}
+	if logs, _ := filepath.Glob(filepath.Join(store, "*.log")); len(logs) != 1 {
+		t.Errorf("expected the log to be shipped once, got %v", logs)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, srv.URL+"/api/agents", nil)
+	req.Header.Set("Authorization", "Bearer adm1n")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var agents []AgentRecord
+	if err := json.NewDecoder(resp.Body).Decode(&agents); err != nil {
+		t.Fatal(err)
+	}
+	if len(agents) != 1 || agents[0].Host != "node1" || agents[0].Version != versionString {
+		t.Fatalf("agents = %+v", agents)
+	}
+	a := agents[0]
+	if len(a.Pending) != 0 || len(a.Results) != 1 || a.Results[0].Action != agentWrap || a.Results[0].Error != "" {
+		t.Errorf("expected one successful wrap result and nothing pending, got %+v / %+v", a.Pending, a.Results)
+	}
+	if !reflect.DeepEqual(a.Health.Wrapped, []string{bin}) || a.Health.PendingLogs != 0 || a.Health.FreeBytes == 0 {
+		t.Errorf("health = %+v", a.Health)
+	}
+
+	// Without a token, the collector takes no instructions and the agent
+	// only ships logs.
+	tokenless := httptest.NewServer(newCollectorMux(store, CollectorConfig{}))
+	defer tokenless.Close()
+	resp, err = http.Post(tokenless.URL+"/api/agents/instructions?host=*&action=unwrap&path="+bin, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("queueing without a token: got %s", resp.Status)
+	}
+	if err := runAgent(AgentOptions{URL: tokenless.URL, Host: "node1", LogDir: logDir, Interval: time.Second, Once: true}); err != nil {
+		t.Errorf("tokenless agent round failed: %v", err)
+	}
+}
+
+// minimalPE returns the headers of a PE executable without sections,
+// followed by payload.
+func minimalPE(payload string) []byte {
+	b := make([]byte, 0x40)
+	copy(b, "MZ")
+	binary.LittleEndian.PutUint32(b[0x3c:], 0x40)
+	b = append(b, "PE\x00\x00"...)
+	header := make([]byte, 20)
+	binary.LittleEndian.PutUint16(header[0:], 0x8664) // IMAGE_FILE_MACHINE_AMD64
+	binary.LittleEndian.PutUint16(header[18:], 0x22)  // executable, large address aware
+	return append(append(b, header...), payload...)
+}
+
+func TestWindowsLaunchers(t *testing.T) {
+	for image, want := range map[string]string{
+		`C:\Windows\System32\KERNEL32.DLL`: "KERNEL32.DLL",
+		`C:\Program Files\App\my app.exe`:  "my_app.exe",
+		"/usr/bin/ls":                      "ls",
+		`D:\tools\con.exe`:                 "_con.exe",
+		"/opt/x/nul":                       "_nul",
+		"/opt/x/consul":                    "consul",
+		"/opt/x/..":                        ".._",
+	} {
+		if got := safeImageName(image); got != want {
+			t.Errorf("safeImageName(%q) = %q, want %q", image, got, want)
+		}
+	}
+
+	tmp := t.TempDir()
+	safeBin, logDir, tools := filepath.Join(tmp, "safe"), filepath.Join(tmp, "logs"), filepath.Join(tmp, "tools")
+	os.Setenv("PIN_ROOT", tmp)
+	os.Setenv("PIN_TOOL_SEARCH_DIR", tools)
+	os.Setenv("SAFE_BIN_DIR", safeBin)
+	os.Setenv("LOG_DIR", logDir)
+	if err := os.MkdirAll(tools, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tools, peToolName), []byte("dummy"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	exe, original := filepath.Join(tmp, "prog.exe"), minimalPE("original")
+	if err := os.WriteFile(exe, original, 0755); err != nil {
+		t.Fatal(err)
+	}
+	launcher := filepath.Join(tmp, "funkoverage.exe")
+	if err := os.WriteFile(launcher, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { launcherExecutable = os.Executable }()
+	launcherExecutable = func() (string, error) { return launcher, nil }
+	if err := wrap(exe, WrapOptions{}); err == nil || !strings.Contains(err.Error(), "Windows build") {
+		t.Errorf("expected wrapping with a non-PE launcher to fail, got %v", err)
+	}
+	if err := os.WriteFile(launcher, minimalPE("launcher"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := wrap(exe, WrapOptions{}); err == nil || !strings.Contains(err.Error(), "prog.pdb") {
+		t.Errorf("expected wrapping without a PDB to fail, got %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmp, "prog.pdb"), []byte("pdb"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	disable := []DisableRule{{UID: -1, User: "nobody-here"}}
+	if err := wrap(exe, WrapOptions{Sample: Sampling{Every: 2}, Disable: disable}); err != nil {
+		t.Fatalf("wrap failed: %v", err)
+	}
+	if content, _ := os.ReadFile(exe); !bytes.Equal(content, minimalPE("launcher")) {
+		t.Error("expected the launcher in place of the executable")
+	}
+	cfg, ok := readLauncherConfig(exe)
+	if !ok || cfg.Tool != filepath.Join(tools, peToolName) || cfg.LogDir != logDir || cfg.Sample != "2" || !reflect.DeepEqual(cfg.Disable, []string{"user=nobody-here"}) {
+		t.Fatalf("launcher config = %+v, %v", cfg, ok)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(cfg.Original), "prog.pdb")); err != nil {
+		t.Errorf("expected the PDB next to the backup: %v", err)
+	}
+	if m, _ := loadManifest(safeBin); len(m.Entries) != 1 || m.Entries[0].Path != exe {
+		t.Errorf("manifest = %+v", m)
+	}
+	if err := wrap(exe, WrapOptions{}); err != nil {
+		t.Errorf("expected wrapping twice to skip the executable, got %v", err)
+	}
+	if err := wrap(exe, WrapOptions{Rewrap: true, Sample: Sampling{Every: 5}}); err != nil {
+		t.Fatalf("rewrap failed: %v", err)
+	}
+	if rewrapped, ok := readLauncherConfig(exe); !ok || rewrapped.Original != cfg.Original || rewrapped.Sample != "5" {
+		t.Errorf("rewrapped launcher config = %+v, %v", rewrapped, ok)
+	}
+
+	// Run the launcher logic with a fake pin and original, as funkoverage.exe would.
+	calls := filepath.Join(tmp, "calls")
+	pin := "#!/bin/sh\necho \"pin $*\" >> " + calls + "\nwhile [ \"$1\" != -- ]; do [ \"$1\" = -o ] && log=$2; shift; done\nshift\necho '[Image:/p] [Called:main]' > \"$log\"\nexec \"$@\"\n"
+	if err := os.WriteFile(filepath.Join(tmp, "pin"), []byte(pin), 0755); err != nil {
+		t.Fatal(err)
+	}
+	fake := *cfg
+	fake.Original = filepath.Join(tmp, "original.sh")
+	if err := os.WriteFile(fake.Original, []byte("#!/bin/sh\necho \"run $*\" >> "+calls+"\nexit 3\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("COVERAGE_SESSION", "win")
+	defer os.Unsetenv("COVERAGE_SESSION")
+	for i := 0; i < 2; i++ {
+		if status := runLauncher(&fake, launcherName(exe), []string{"a b"}); status != 3 {
+			t.Errorf("run %d: status = %d, want 3", i, status)
+		}
+	}
+	got, _ := os.ReadFile(calls)
+	want := "pin -follow_execv -t " + cfg.Tool + " -o "
+	lines := strings.Split(strings.TrimSpace(string(got)), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], want) || !strings.HasSuffix(lines[0], "-sample 1/2 -session win -- "+fake.Original+" a b") || lines[1] != "run a b" || lines[2] != "run a b" {
+		t.Errorf("expected one traced and one direct run, got %q", got)
+	}
+	logs, _ := filepath.Glob(filepath.Join(logDir, "prog_*.log"))
+	if len(logs) != 1 {
+		t.Fatalf("expected one log, got %v", logs)
+	}
+	if content, _ := os.ReadFile(logs[0]); !strings.Contains(string(content), "[FuncTracer] [Exit:3] [WallMs:") {
+		t.Errorf("expected an exit trailer, got %q", content)
+	}
+
+	if err := unwrap(exe); err != nil {
+		t.Fatalf("unwrap failed: %v", err)
+	}
+	if content, _ := os.ReadFile(exe); !bytes.Equal(content, original) {
+		t.Error("expected the original executable back")
+	}
+	if _, err := os.Stat(exe + launcherSuffix); !os.IsNotExist(err) {
+		t.Error("expected the launcher config to be removed")
+	}
+	if _, err := os.Stat(filepath.Dir(cfg.Original)); !os.IsNotExist(err) {
+		t.Error("expected the backup directory to be removed")
+	}
+	if m, _ := loadManifest(safeBin); len(m.Entries) != 0 {
+		t.Errorf("expected an empty manifest, got %+v", m)
+	}
+}
+
+func TestPackageHooks(t *testing.T) {
+	self, env := "/usr/bin/funkoverage", []string{"PIN_ROOT=/opt/pin", "SAFE_BIN_DIR=/srv/it's"}
+	for _, format := range hookFormats {
+		files, err := generateHooks(format, self, env)
+		if err != nil || len(files) == 0 {
+			t.Fatalf("generateHooks(%s) = %v, %v", format, files, err)
+		}
+		for _, f := range files {
+			if !filepath.IsAbs(f.Path) || !strings.Contains(f.Content, self) || !strings.Contains(f.Content, "refresh") || !strings.Contains(f.Content, "/opt/pin") {
+				t.Errorf("%s hook %s:\n%s", format, f.Path, f.Content)
+			}
+		}
+	}
+	if files, _ := generateHooks("apt", self, env); !strings.Contains(files[0].Content, `SAFE_BIN_DIR='/srv/it'\''s' '/usr/bin/funkoverage' refresh || true"; };`) {
+		t.Errorf("apt hook:\n%s", files[0].Content)
+	}
+	if _, err := generateHooks("pacman", self, nil); err == nil {
+		t.Error("expected an unknown format to fail")
+	}
+	root := t.TempDir()
+	files, _ := generateHooks("zypper", self, nil)
+	if err := writeHooks(files, root); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filepath.Join(root, "usr/lib/zypp/plugins/commit/funkoverage")); err != nil || info.Mode().Perm() != 0755 {
+		t.Errorf("zypper plugin not installed executable: %v", err)
+	}
+
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found")
+	}
+	tmp := t.TempDir()
+	os.Setenv("PIN_ROOT", tmp)
+	os.Setenv("PIN_TOOL_SEARCH_DIR", tmp)
+	os.Setenv("SAFE_BIN_DIR", tmp)
+	os.Setenv("LOG_DIR", tmp)
+	if err := os.WriteFile(filepath.Join(tmp, "FuncTracer.so"), []byte("dummy"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(tmp, "main.c")
+	if err := os.WriteFile(src, []byte("int main() { return 0; }"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	updated, removed := filepath.Join(tmp, "updated"), filepath.Join(tmp, "removed")
+	for _, bin := range []string{updated, removed} {
+		if out, err := exec.Command("gcc", "-g", "-o", bin, src).CombinedOutput(); err != nil {
+			t.Fatalf("failed to compile: %v\n%s", err, out)
+		}
+	}
+	opts := WrapOptions{Sample: Sampling{Every: 3}, Disable: []DisableRule{{UID: 0}}}
+	if err := wrapMany([]string{updated, removed}, opts); err != nil {
+		t.Fatal(err)
+	}
+	defer unwrap(updated)
+	before, _ := loadManifest(tmp)
+	// The package manager installs a new build over the wrapper and removes the other binary.
+	os.Remove(updated)
+	if out, err := exec.Command("gcc", "-g", "-o", updated, src).CombinedOutput(); err != nil {
+		t.Fatalf("failed to compile: %v\n%s", err, out)
+	}
+	os.Remove(removed)
+
+	if err := refreshWrappers(tmp); err != nil {
+		t.Fatalf("refresh failed: %v", err)
+	}
+	script, _ := os.ReadFile(updated)
+	if !strings.Contains(string(script), wrapperIDComment) || !strings.Contains(string(script), `FUNKOVERAGE_SAMPLE-3}`) || !strings.Contains(string(script), "# uid=0") {
+		t.Errorf("expected the update to be wrapped again with its options:\n%s", script)
+	}
+	after, _ := loadManifest(tmp)
+	if len(after.Entries) != 1 || after.Entries[0].Path != updated || after.Entries[0].Backup == before.Entries[0].Backup {
+		t.Fatalf("manifest after refresh = %+v", after.Entries)
+	}
+	for _, e := range before.Entries {
+		if _, err := os.Stat(filepath.Dir(e.Backup)); !os.IsNotExist(err) {
+			t.Errorf("expected the outdated backup %s to be removed", e.Backup)
+		}
+	}
+	if err := refreshWrappers(tmp); err != nil {
+		t.Fatalf("second refresh failed: %v", err)
+	}
+	if again, _ := loadManifest(tmp); !reflect.DeepEqual(again.Entries, after.Entries) {
+		t.Errorf("expected a second refresh to change nothing, got %+v", again.Entries)
+	}
+}
+
+func TestStapBackend(t *testing.T) {
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found")
+	}
+	tmp := t.TempDir()
+	src := filepath.Join(tmp, "main.c")
+	code := "int helper(int x) { return x + 1; }\nint unused(void) { return 2; }\nint main() { return helper(2) == 3 ? 4 : 0; }\n"
+	if err := os.WriteFile(src, []byte(code), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bin := filepath.Join(tmp, "prog")
+	if out, err := exec.Command("gcc", "-g", "-O0", "-o", bin, src).CombinedOutput(); err != nil {
+		t.Fatalf("failed to compile: %v\n%s", err, out)
+	}
+	if script := stapScript(`/opt/a "b"`); !strings.Contains(script, `process("/opt/a \"b\"").function("*").call`) {
+		t.Errorf("unexpected script:\n%s", script)
+	}
+
+	// The fake stap checks the script, runs the command and writes what the
+	// script would print.
+	stap := filepath.Join(tmp, "stap")
+	fake := `#!/bin/sh
+while [ $# -gt 0 ]; do
+    case "$1" in
+    -o) out=$2; shift ;;
+    -e) script=$2; shift ;;
+    -c) cmd=$2; shift ;;
+    esac
+    shift
+done
+case "$script" in *'process("` + bin + `")'*) ;; *) echo "bad script" >&2; exit 1 ;; esac
+sh -c "$cmd"
+printf 'pid\t42\ncall\tmain\t1\ncall\thelper\t3\ncall\t0x1234\t1\nexit\t%d\n' $? > "$out"
+`
+	if err := os.WriteFile(stap, []byte(fake), 0755); err != nil {
+		t.Fatal(err)
+	}
+	logDir := filepath.Join(tmp, "logs")
+	status, log, err := runStap(StapOptions{Stap: stap, LogDir: logDir, Command: []string{bin, "it's"}})
+	if err != nil {
+		t.Fatalf("runStap failed: %v", err)
+	}
+	if status != 4 || !strings.HasPrefix(filepath.Base(log), "prog_") {
+		t.Errorf("runStap = %d, %s", status, log)
+	}
+	content, _ := os.ReadFile(log)
+	for _, want := range []string{"] [Run:2a-", "[Import:stap]", "[Function:unused]", "[Count:3] [Called:helper]", "[FuncTracer] [Exit:4] [WallMs:"} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("log lacks %q:\n%s", want, content)
+		}
+	}
+	if strings.Contains(string(content), "[Called:unused]") {
+		t.Errorf("unused must not be called:\n%s", content)
+	}
+	if entries, _ := os.ReadDir(logDir); len(entries) != 1 {
+		t.Errorf("expected only the log in %s, got %v", logDir, entries)
+	}
+	if _, _, err := runStap(StapOptions{Stap: stap, LogDir: logDir}); err == nil {
+		t.Error("expected an error without a command")
+	}
+}
+
+func TestLibraryAPIMode(t *testing.T) {
+	libs, err := parseAPILibraries(" libssl, libz.so.1,,")
+	if err != nil || !reflect.DeepEqual(libs, []string{"libssl", "libz.so.1"}) {
+		t.Errorf("parseAPILibraries = %q, %v", libs, err)
+	}
+	for _, bad := range []string{"/usr/lib/libssl.so", "lib ssl", `libssl"`, ".libssl"} {
+		if _, err := parseAPILibraries(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+	if args := pinToolArgs("", "libssl,libz.so.1", ""); !reflect.DeepEqual(args[len(args)-2:], []string{"-api", "libssl,libz.so.1"}) {
+		t.Errorf("pinToolArgs = %q", args)
+	}
+
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found")
+	}
+	tmp := t.TempDir()
+	os.Setenv("PIN_ROOT", tmp)
+	os.Setenv("PIN_TOOL_SEARCH_DIR", tmp)
+	os.Setenv("SAFE_BIN_DIR", tmp)
+	os.Setenv("LOG_DIR", tmp)
+	if err := os.WriteFile(filepath.Join(tmp, "FuncTracer.so"), []byte("dummy"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(tmp, "main.c")
+	if err := os.WriteFile(src, []byte("int main() { return 0; }"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bin := filepath.Join(tmp, "prog")
+	if out, err := exec.Command("gcc", "-g", "-o", bin, src).CombinedOutput(); err != nil {
+		t.Fatalf("failed to compile: %v\n%s", err, out)
+	}
+	if err := wrap(bin, WrapOptions{API: libs}); err != nil {
+		t.Fatal(err)
+	}
+	defer unwrap(bin)
+	script, _ := os.ReadFile(bin)
+	if !strings.Contains(string(script), `api_libs="${FUNKOVERAGE_API-libssl,libz.so.1}"`) {
+		t.Errorf("expected the wrapper to pass the libraries to -api:\n%s", script)
+	}
+	m, _ := loadManifest(tmp)
+	if len(m.Entries) != 1 {
+		t.Fatalf("manifest = %+v", m.Entries)
+	}
+	// refresh wraps an updated binary again in API mode.
+	if opts, err := m.Entries[0].wrapOptions(); err != nil || !reflect.DeepEqual(opts.API, libs) {
+		t.Errorf("wrapOptions = %+v, %v", opts, err)
+	}
+}
+
+func TestDlopenPlugins(t *testing.T) {
+	if image, loader, ok := parsePluginLine([]byte(`[Image:/usr/lib/mod_\x5bx\x5d.so] [Plugin:/usr/sbin/httpd]`)); !ok || string(image) != "/usr/lib/mod_[x].so" || loader != "/usr/sbin/httpd" {
+		t.Errorf("parsePluginLine = %q, %q, %v", image, loader, ok)
+	}
+	tmp := t.TempDir()
+	logs := filepath.Join(tmp, "logs")
+	if err := os.Mkdir(logs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// httpd unloads mod_a.so and loads it again: each load lists the
+	// functions and writes its calls on unload or exit.
+	load := "[Image:/usr/lib/mod_a.so] [Plugin:/usr/sbin/httpd]\n" +
+		"[Image:/usr/lib/mod_a.so] [Addr:0x10] [Function:handler]\n[Image:/usr/lib/mod_a.so] [Addr:0x20] [Function:cleanup]\n"
+	httpd := "[FuncTracer] [Format:10] [Run:1-1]\n[Image:/usr/sbin/httpd] [Function:serve]\n" +
+		load + "[Image:/usr/lib/mod_a.so] [Addr:0x10] [Count:2] [Called:handler]\n" +
+		load + "[Image:/usr/lib/mod_a.so] [Addr:0x10] [Count:3] [Called:handler]\n[Image:/usr/lib/mod_a.so] [Addr:0x20] [Count:1] [Called:cleanup]\n"
+	// A test program links against the module instead.
+	linked := "[FuncTracer] [Format:10] [Run:2-2]\n[Image:/usr/lib/mod_a.so] [Addr:0x10] [Function:handler]\n"
+	for name, content := range map[string]string{"httpd_20260101-100000_1.log": httpd, "test_20260101-100001_2.log": linked} {
+		if err := os.WriteFile(filepath.Join(logs, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	logFiles, _ := collectLogFiles(logs)
+	var coverage map[string]*CoverageData
+	var stats []LogStats
+	// The second analysis reads the indexes the first one wrote.
+	for run := 0; run < 2; run++ {
+		var err error
+		coverage, stats, err = analyzeLogsWith(logFiles, AnalyzeOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		mod := coverage["/usr/lib/mod_a.so"]
+		if mod == nil || len(mod.TotalFunctions) != 2 || len(mod.CalledFunctions) != 2 || mod.Calls["handler"] != 5 {
+			t.Fatalf("run %d: expected the calls of both loads to be kept, got %+v", run, mod)
+		}
+		plugins := imagePlugins(coverage, stats)
+		if !reflect.DeepEqual(plugins, map[string][]string{"/usr/lib/mod_a.so": {"httpd"}}) {
+			t.Errorf("run %d: imagePlugins = %v", run, plugins)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(logs, "httpd_20260101-100000_1.log"+logIndexSuffix)); err != nil {
+		t.Errorf("expected the log to be indexed: %v", err)
+	}
+	out := filepath.Join(tmp, "out")
+	if err := os.Mkdir(out, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := generateAggregateHTMLReport(coverage, nil, nil, stats, AggregateView{}, out, time.Unix(0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	html, _ := os.ReadFile(filepath.Join(out, aggregateReportFileName))
+	if !bytes.Contains(html, []byte("(plugin of httpd)")) {
+		t.Error("expected the module to be marked as a plugin of httpd")
+	}
+}
+
+func TestImageFilter(t *testing.T) {
+	var f ImageFilter
+	for _, s := range []string{"/usr/sbin/*", "libssl*", "re:/opt/app/", "!sshd"} {
+		if err := f.Set(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for image, want := range map[string]bool{
+		"/usr/sbin/httpd":           true,
+		"/usr/sbin/sshd":            false,
+		"/usr/lib64/libssl.so.3":    true,
+		"/opt/app/bin/server":       true,
+		"/usr/lib64/libcrypto.so.3": false,
+		"/usr/sbin/x/deep":          false,
+	} {
+		if got := f.match(image); got != want {
+			t.Errorf("match(%s) = %v, want %v", image, got, want)
+		}
+	}
+	if !(ImageFilter{}).match("/any") {
+		t.Error("an empty filter must keep every image")
+	}
+	var only ImageFilter
+	only.Set("!re:\\.so")
+	if only.match("/usr/lib/libz.so.1") || !only.match("/usr/bin/ls") {
+		t.Error("a filter of exclusions must keep the other images")
+	}
+	for _, bad := range []string{"re:(", "[", ""} {
+		if _, err := parseImagePattern(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+
+	logFile := filepath.Join(t.TempDir(), "prog_20260101-100000_1.log")
+	content := "[FuncTracer] [Format:10]\n[Image:/usr/sbin/httpd] [Function:serve]\n[Image:/usr/lib64/libz.so.1] [Function:inflate]\n"
+	if err := os.WriteFile(logFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	coverage, _, err := analyzeLogsWith([]string{logFile}, AnalyzeOptions{Images: only})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sortedKeys(coverage), []string{"/usr/sbin/httpd"}) {
+		t.Errorf("expected only httpd to be analyzed, got %v", sortedKeys(coverage))
+	}
+}
+
+func TestCoverageFilter(t *testing.T) {
+	f, err := newCoverageFilter(80, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.String() != "above 20% and below 80%" || !f.lists(50) || f.lists(80) || f.lists(20) {
+		t.Errorf("unexpected filter %q", f)
+	}
+	for _, bad := range [][2]float64{{50, 60}, {101, 0}, {0, -1}} {
+		if _, err := newCoverageFilter(bad[0], bad[1]); err == nil {
+			t.Errorf("expected --below %g --above %g to be rejected", bad[0], bad[1])
+		}
+	}
+	if (CoverageFilter{}).active() || !(CoverageFilter{}).lists(0) {
+		t.Error("the zero filter must list every image")
+	}
+
+	image := func(total, called int) *CoverageData {
+		d := newCoverageData()
+		for i := range total {
+			d.TotalFunctions[fmt.Sprint("f", i)] = struct{}{}
+			if i < called {
+				d.CalledFunctions[fmt.Sprint("f", i)] = struct{}{}
+			}
+		}
+		return d
+	}
+	coverage := map[string]*CoverageData{"/bin/red": image(10, 1), "/bin/green": image(10, 9)}
+	out := t.TempDir()
+	if err := generateAggregateHTMLReport(coverage, nil, nil, nil, AggregateView{Thresholds: defaultThresholds, Listing: CoverageFilter{Above: 50}}, out, time.Unix(0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	html, _ := os.ReadFile(filepath.Join(out, aggregateReportFileName))
+	if !bytes.Contains(html, []byte("<td>green")) || bytes.Contains(html, []byte("<td>red")) || !bytes.Contains(html, []byte("images above 50% coverage, 1 more left out")) {
+		t.Errorf("expected only the green image to be listed:\n%s", html)
+	}
+
+	r, w, _ := os.Pipe()
+	stdout := os.Stdout
+	os.Stdout = w
+	printTxtReport(coverage, nil, nil, nil, CoverageFilter{Below: 50}, nil, 0, 1)
+	os.Stdout = stdout
+	w.Close()
+	txt, _ := io.ReadAll(r)
+	if !bytes.Contains(txt, []byte("Image: /bin/red")) || bytes.Contains(txt, []byte("Image: /bin/green")) || !bytes.Contains(txt, []byte("images below 50% coverage, 1 more left out")) {
+		t.Errorf("expected only the red image in the text report:\n%s", txt)
+	}
+}
+
+func TestReportDaemon(t *testing.T) {
+	if _, err := (ReportdConfig{}).options(); err == nil {
+		t.Error("expected a configuration without output directory to be refused")
+	}
+	if _, err := (ReportdConfig{OutputDir: "out", Quiet: "soon"}).options(); err == nil {
+		t.Error("expected an invalid duration to be refused")
+	}
+	tmp := t.TempDir()
+	logs, out, history := filepath.Join(tmp, "logs"), filepath.Join(tmp, "out"), filepath.Join(tmp, "history")
+	if err := os.Mkdir(logs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	opts, err := ReportdConfig{LogDir: logs, OutputDir: out, HistoryDir: history, Quiet: "50ms"}.options()
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.Poll = 10 * time.Millisecond
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() { done <- runReportd(opts, stop) }()
+	writeLog := func(name, function string) {
+		content := "[FuncTracer] [Format:10]\n[Image:/usr/bin/alpha] [Function:a]\n[Image:/usr/bin/alpha] [Function:b]\n[Image:/usr/bin/alpha] [Called:" + function + "]\n"
+		// Written aside and renamed, so the daemon never sees it half written.
+		tmpLog := filepath.Join(tmp, name)
+		if err := os.WriteFile(tmpLog, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmpLog, filepath.Join(logs, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor := func(what string, ok func() bool) {
+		t.Helper()
+		for i := 0; i < 300 && !ok(); i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if !ok() {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+	// The functions called, as of the last snapshot of the history.
+	called := func() int {
+		snapshots, _ := loadHistory(history)
+		if len(snapshots) == 0 {
+			return 0
+		}
+		return snapshots[len(snapshots)-1].TotalCalled
+	}
+	aggregate := filepath.Join(out, aggregateReportFileName)
+	writeLog("alpha_20260101-100000_1.log", "a")
+	waitFor("the first reports", func() bool { return fileExists(aggregate) && called() == 1 })
+	first, _ := os.Stat(aggregate)
+	time.Sleep(100 * time.Millisecond)
+	if again, _ := os.Stat(aggregate); !again.ModTime().Equal(first.ModTime()) {
+		t.Error("expected no generation without new logs")
+	}
+	writeLog("alpha_20260101-100000_2.log", "b")
+	waitFor("the reports of the new log", func() bool { return called() == 2 })
+	close(stop)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	d := &reportDaemon{opts: opts, status: ReportdStatus{Logs: 2}}
+	server := httptest.NewServer(d.handler())
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/" + aggregateReportFileName)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET aggregate report = %v, %v", resp, err)
+	}
+	resp.Body.Close()
+	resp, err = http.Get(server.URL + "/reportd/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var status ReportdStatus
+	json.NewDecoder(resp.Body).Decode(&status)
+	resp.Body.Close()
+	if status.Logs != 2 {
+		t.Errorf("status = %+v", status)
+	}
+}
+
+func TestCrossLinkedHTMLReports(t *testing.T) {
+	tmp := t.TempDir()
+	logs, out := filepath.Join(tmp, "logs"), filepath.Join(tmp, "out")
+	if err := os.Mkdir(logs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	content := "[FuncTracer] [Format:10]\n[Image:/usr/bin/alpha] [Function:a]\n[Image:/usr/bin/beta] [Function:b]\n" +
+		"[Image:/usr/bin/gamma] [Function:c]\n[Image:/usr/bin/beta] [Called:b]\n"
+	if err := os.WriteFile(filepath.Join(logs, "prog_20260101-100000_1.log"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := runReport(ReportOptions{Inputs: []string{logs}, OutputDir: out, Formats: []string{"html"}}); err != nil {
+		t.Fatal(err)
+	}
+	aggregate, _ := os.ReadFile(filepath.Join(out, aggregateReportFileName))
+	for _, image := range []string{"alpha", "beta", "gamma"} {
+		link := `<a href="` + htmlReportFileName("/usr/bin/"+image) + `">` + image + `</a>`
+		if !bytes.Contains(aggregate, []byte(link)) {
+			t.Errorf("expected the aggregate report to link %s", link)
+		}
+	}
+	beta, _ := os.ReadFile(filepath.Join(out, htmlReportFileName("/usr/bin/beta")))
+	for _, want := range []string{
+		`<a href="aggregate.html">All images</a>`,
+		`<a href="` + htmlReportFileName("/usr/bin/alpha") + `" title="Previous image">&lsaquo; alpha</a>`,
+		`<a href="` + htmlReportFileName("/usr/bin/gamma") + `" title="Next image">gamma &rsaquo;</a>`,
+	} {
+		if !bytes.Contains(beta, []byte(want)) {
+			t.Errorf("expected %q in the detailed report:\n%s", want, beta)
+		}
+	}
+}
+
+func TestSingleFileHTMLReport(t *testing.T) {
+	tmp := t.TempDir()
+	logs, out := filepath.Join(tmp, "logs"), filepath.Join(tmp, "out")
+	if err := os.Mkdir(logs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	content := "[FuncTracer] [Format:10]\n[Image:/usr/bin/alpha] [Function:a]\n[Image:/usr/bin/beta] [Function:b]\n" +
+		"[Image:/usr/bin/beta] [Called:b]\n"
+	if err := os.WriteFile(filepath.Join(logs, "prog_20260101-100000_1.log"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := runReport(ReportOptions{Inputs: []string{logs}, OutputDir: out, Formats: []string{"html"}, HTMLSingleFile: true}); err != nil {
+		t.Fatal(err)
+	}
+	single, err := os.ReadFile(filepath.Join(out, singleFileReportName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, blob, found := bytes.Cut(single, []byte(`<script type="application/json" id="pages">`))
+	blob, _, _ = bytes.Cut(blob, []byte("</script>"))
+	var pages map[string]string
+	if err := json.Unmarshal(blob, &pages); !found || err != nil {
+		t.Fatalf("embedded pages not found or invalid: %v", err)
+	}
+	for _, page := range []string{aggregateReportFileName, htmlReportFileName("/usr/bin/alpha"), htmlReportFileName("/usr/bin/beta")} {
+		written, _ := os.ReadFile(filepath.Join(out, page))
+		if pages[page] == "" || pages[page] != string(written) {
+			t.Errorf("expected %s embedded as written", page)
+		}
+	}
+	if len(pages) != 3 || !bytes.Contains(single, []byte(`const start = "aggregate.html";`)) {
+		t.Errorf("unexpected single-file report: %d pages\n%s", len(pages), single)
+	}
+}
+
+func TestReportInputs(t *testing.T) {
+	tmp := t.TempDir()
+	root := filepath.Join(tmp, "logs")
+	for _, f := range []string{"host-a/run1/a.log", "host-a/b.log", "host-b/c.log", "host-b/notes.txt", "other/d.log", ".hosts/host-a/e.log"} {
+		path := filepath.Join(root, f)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rel := func(files []string) []string {
+		out := make([]string, len(files))
+		for i, f := range files {
+			out[i], _ = filepath.Rel(root, f)
+		}
+		return out
+	}
+	for _, tc := range []struct {
+		inputs []string
+		want   []string
+	}{
+		{[]string{root}, []string{"host-a/b.log", "host-a/run1/a.log", "host-b/c.log", "other/d.log"}},
+		{[]string{filepath.Join(root, "host-*/**/*.log")}, []string{"host-a/b.log", "host-a/run1/a.log", "host-b/c.log"}},
+		{[]string{filepath.Join(root, "host-*")}, []string{"host-a/b.log", "host-a/run1/a.log", "host-b/c.log"}},
+		{[]string{filepath.Join(root, "other"), filepath.Join(root, "*/d.log")}, []string{"other/d.log"}},
+		{[]string{filepath.Join(root, "host-b/c.log") + "," + filepath.Join(root, "other/d.log")}, []string{"host-b/c.log", "other/d.log"}},
+	} {
+		got, err := collectLogFiles(tc.inputs...)
+		if err != nil {
+			t.Errorf("collectLogFiles(%v): %v", tc.inputs, err)
+		} else if !reflect.DeepEqual(rel(got), tc.want) {
+			t.Errorf("collectLogFiles(%v) = %v, want %v", tc.inputs, rel(got), tc.want)
+		}
+	}
+	if _, err := collectLogFiles(filepath.Join(root, "host-c*/*.log")); err == nil {
+		t.Error("expected a pattern matching nothing to fail")
+	}
+	if err := prepareOutputDir(filepath.Join(root, "report"), filepath.Join(root, "host-*/*.log")); err == nil {
+		t.Error("expected an output directory below the base of a pattern to be rejected")
+	}
+}
+
+func TestReportSummary(t *testing.T) {
+	tmp := t.TempDir()
+	logs, out := filepath.Join(tmp, "logs"), filepath.Join(tmp, "out")
+	if err := os.Mkdir(logs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	content := "[FuncTracer] [Format:10]\n[Image:/usr/bin/alpha] [Function:a]\n[Image:/usr/bin/alpha] [Function:b]\n[Image:/usr/bin/alpha] [Called:b]\n"
+	if err := os.WriteFile(filepath.Join(logs, "prog_20260101-100000_1.log"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := runReport(ReportOptions{Inputs: []string{logs}, OutputDir: out, Formats: []string{"txt"}}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(out, summaryFileName))
+	if err != nil {
+		t.Fatalf("expected summary.json with only the text report: %v", err)
+	}
+	var summary ReportSummary
+	if err := json.Unmarshal(data, &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Version != summaryVersion || summary.Logs != 1 || !reflect.DeepEqual(summary.Formats, []string{"txt"}) || len(summary.Artifacts) != 0 {
+		t.Errorf("unexpected run metadata: %s", data)
+	}
+	if summary.TotalFunctions != 2 || summary.TotalCalled != 1 || len(summary.Rows) != 1 || summary.Rows[0].ImageName != "/usr/bin/alpha" || summary.Rows[0].CoveragePct != 50 {
+		t.Errorf("unexpected totals: %s", data)
+	}
+
+	out = filepath.Join(tmp, "none")
+	if err := runReport(ReportOptions{Inputs: []string{logs}, OutputDir: out, Formats: []string{"txt"}, NoSummary: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Error("expected --no-summary to leave the output directory alone")
+	}
+}
+
+func TestCompareSavedReport(t *testing.T) {
+	tmp := t.TempDir()
+	logs := filepath.Join(tmp, "logs")
+	if err := os.Mkdir(logs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(content string) {
+		if err := os.WriteFile(filepath.Join(logs, "prog_20260101-100000_1.log"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("[FuncTracer] [Format:10]\n[Image:/usr/bin/alpha] [Function:a]\n[Image:/usr/bin/alpha] [Function:b]\n[Image:/usr/bin/alpha] [Called:a]\n")
+	prev := filepath.Join(tmp, "prev")
+	if err := runReport(ReportOptions{Inputs: []string{logs}, OutputDir: prev, Formats: []string{"xml"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	write("[FuncTracer] [Format:10]\n[Image:/usr/bin/alpha] [Function:a]\n[Image:/usr/bin/alpha] [Function:b]\n" +
+		"[Image:/usr/bin/alpha] [Called:a]\n[Image:/usr/bin/alpha] [Called:b]\n[Image:/usr/bin/beta] [Function:c]\n")
+	for _, saved := range []string{filepath.Join(prev, summaryFileName), filepath.Join(prev, xunitReportFileName("/usr/bin/alpha")), prev} {
+		report, err := loadSavedReport(saved)
+		if err != nil {
+			t.Fatalf("loadSavedReport(%s): %v", saved, err)
+		}
+		if d := report.compareImage("/usr/bin/alpha", 100); d == nil || !d.Saved || d.PreviousPct != 50 || d.Delta != 50 {
+			t.Errorf("%s: unexpected delta of alpha: %+v", saved, d)
+		}
+		if d := report.compareImage("/usr/bin/beta", 0); d == nil || d.Saved {
+			t.Errorf("%s: expected beta to be missing from the saved report: %+v", saved, d)
+		}
+	}
+
+	out := filepath.Join(tmp, "out")
+	if err := runReport(ReportOptions{Inputs: []string{logs}, OutputDir: out, Formats: []string{"html"}, CompareFile: prev}); err != nil {
+		t.Fatal(err)
+	}
+	aggregate, _ := os.ReadFile(filepath.Join(out, aggregateReportFileName))
+	if !bytes.Contains(aggregate, []byte(`title="50.0% in the saved report">&#43;50.0</span>`)) || !bytes.Contains(aggregate, []byte(`<span class="badge badge-new">new</span>`)) {
+		t.Errorf("expected the aggregate report to show the deltas since the saved report:\n%s", aggregate)
+	}
+	detailed, _ := os.ReadFile(filepath.Join(out, htmlReportFileName("/usr/bin/alpha")))
+	if !bytes.Contains(detailed, []byte("50.0% &rarr; 100.0% (&#43;50.0)")) {
+		t.Errorf("expected the detailed report to show the delta since the saved report:\n%s", detailed)
+	}
+	if _, err := loadSavedReport(logs); err == nil {
+		t.Error("expected a directory without saved report to be rejected")
+	}
+}
+
+func TestXUnitUncalledAsTestCases(t *testing.T) {
+	tmp := t.TempDir()
+	data := newCoverageData()
+	for _, fn := range []string{"a", "b", "c"} {
+		data.TotalFunctions[fn] = struct{}{}
+	}
+	data.CalledFunctions["b"] = struct{}{}
+	type testcase struct {
+		Name    string    `xml:"name,attr"`
+		Skipped *struct{} `xml:"skipped"`
+		Failure *struct{} `xml:"failure"`
+	}
+	for _, mode := range xunitUncalledModes {
+		var suites struct {
+			TestSuite struct {
+				Failures  int        `xml:"failures,attr"`
+				Skipped   int        `xml:"skipped,attr"`
+				Tests     int        `xml:"tests,attr"`
+				TestCases []testcase `xml:"testcase"`
+				SystemOut string     `xml:"system-out"`
+			} `xml:"testsuite"`
+		}
+		if err := generateXUnitReport("/bin/prog", data, XUnitOptions{UncalledAs: mode}, tmp, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		file := filepath.Join(tmp, xunitReportFileName("/bin/prog"))
+		content, _ := os.ReadFile(file)
+		if err := xml.Unmarshal(content, &suites); err != nil {
+			t.Fatal(err)
+		}
+		suite := suites.TestSuite
+		if suite.Tests != 3 || suite.Skipped+suite.Failures != 2 || (mode == "failure") != (suite.Failures == 2) || !strings.Contains(suite.SystemOut, "Called Functions: 1") {
+			t.Errorf("%s: unexpected test suite %+v", mode, suite)
+		}
+		var names []string
+		for _, tc := range suite.TestCases {
+			names = append(names, tc.Name)
+			if uncalled := tc.Name != "b"; uncalled != (tc.Skipped != nil || tc.Failure != nil) || (mode == "failure") != (tc.Failure != nil) && uncalled {
+				t.Errorf("%s: unexpected test case %+v", mode, tc)
+			}
+		}
+		if strings.Join(names, ",") != "a,b,c" {
+			t.Errorf("%s: expected a test case per function, got %v", mode, names)
+		}
+		rows, err := readXUnitCoverage(file)
+		if err != nil || len(rows) != 1 || rows[0].CalledCount != 1 || rows[0].TotalCount != 3 {
+			t.Errorf("%s: unexpected saved coverage %+v, %v", mode, rows, err)
+		}
+	}
+	if err := runReport(ReportOptions{Inputs: []string{tmp}, OutputDir: filepath.Join(tmp, "out"), Formats: []string{"xml"}, XMLUncalledAs: "error"}); err == nil || !strings.Contains(err.Error(), "--xml-uncalled-as") {
+		t.Errorf("expected an unknown mode to be rejected, got %v", err)
+	}
+}
+
+func TestChunkedXUnitReports(t *testing.T) {
+	tmp := t.TempDir()
+	logs, out := filepath.Join(tmp, "logs"), filepath.Join(tmp, "out")
+	if err := os.Mkdir(logs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	content := "[FuncTracer] [Format:10]\n[Image:/usr/bin/beta] [Function:z]\n"
+	for _, fn := range []string{"a", "b", "c", "d", "e"} {
+		content += "[Image:/usr/bin/alpha] [Function:" + fn + "]\n"
+	}
+	content += "[Image:/usr/bin/alpha] [Called:b]\n[Image:/usr/bin/alpha] [Called:e]\n"
+	if err := os.WriteFile(filepath.Join(logs, "prog_20260101-100000_1.log"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	report := func(chunkSize int) {
+		if err := runReport(ReportOptions{Inputs: []string{logs}, OutputDir: out, Formats: []string{"xml"}, XMLChunkSize: chunkSize}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	xunitFiles := func() []string {
+		files, _ := filepath.Glob(filepath.Join(out, "coverage_*.xml"))
+		for i, f := range files {
+			files[i] = filepath.Base(f)
+		}
+		return files
+	}
+
+	report(0)
+	report(2)
+	parts := []string{"coverage_alpha_part1.xml", "coverage_alpha_part2.xml", "coverage_alpha_part3.xml"}
+	if got, want := xunitFiles(), append(parts[:3:3], "coverage_beta.xml"); !reflect.DeepEqual(got, want) {
+		t.Errorf("XUnit files %v, want %v", got, want)
+	}
+	var index XUnitIndex
+	indexJSON, _ := os.ReadFile(filepath.Join(out, xunitIndexFileName))
+	if err := json.Unmarshal(indexJSON, &index); err != nil {
+		t.Fatal(err)
+	}
+	if index.ChunkSize != 2 || len(index.Images) != 2 || !reflect.DeepEqual(index.Images[0].Files, parts) || index.Images[0].Functions != 5 || index.Images[1].Files[0] != "coverage_beta.xml" {
+		t.Errorf("unexpected index %+v", index)
+	}
+	last, _ := os.ReadFile(filepath.Join(out, parts[2]))
+	if !bytes.Contains(last, []byte(`skipped="0" tests="1"`)) || !bytes.Contains(last, []byte("Part 3 of 3: functions 5 to 5")) {
+		t.Errorf("unexpected last part:\n%s", last)
+	}
+	os.Remove(filepath.Join(out, summaryFileName))
+	saved, err := loadSavedReport(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alpha := saved.Images["alpha"]; alpha.TotalCount != 5 || alpha.CalledCount != 2 || alpha.CoveragePct != 40 || fmt.Sprintf("%.2f", saved.AverageCoverage) != "33.33" {
+		t.Errorf("unexpected saved coverage of the parts: %+v, average %v", alpha, saved.AverageCoverage)
+	}
+
+	report(0)
+	if got := xunitFiles(); !reflect.DeepEqual(got, []string{"coverage_alpha.xml", "coverage_beta.xml"}) {
+		t.Errorf("expected the parts replaced by a single file, got %v", got)
+	}
+}
+
+func TestCoberturaReport(t *testing.T) {
+	tmp := t.TempDir()
+	data := newCoverageData()
+	for _, fn := range []string{"main", "ns::detail::Parser::parse()", "ns::detail::Parser::reset()", "ns::helper(int)", "void ns::Vec<a::b>::at(unsigned long)"} {
+		data.TotalFunctions[fn] = struct{}{}
+	}
+	data.CalledFunctions["main"] = struct{}{}
+	data.CalledFunctions["ns::detail::Parser::parse()"] = struct{}{}
+	if err := generateCoberturaReport("/usr/bin/prog", data, tmp, time.Unix(1700000000, 0)); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join(tmp, coberturaReportFileName("/usr/bin/prog")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report CoberturaCoverage
+	if err := xml.Unmarshal(content, &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.LinesValid != 5 || report.LinesCovered != 2 || report.LineRate != "0.4" || report.Timestamp != 1700000000 {
+		t.Errorf("unexpected totals: %+v", report)
+	}
+	var tree []string
+	for _, p := range report.Packages {
+		for _, c := range p.Classes {
+			var methods []string
+			for _, m := range c.Methods {
+				methods = append(methods, m.Name+"="+m.LineRate)
+			}
+			tree = append(tree, fmt.Sprintf("%s/%s(%s): %s", p.Name, c.Name, c.LineRate, strings.Join(methods, " ")))
+		}
+	}
+	want := []string{
+		"(global)/(global)(1): main=1",
+		"(global)/ns(0): helper(int)=0",
+		"ns/ns.Vec<a::b>(0): at(unsigned long)=0",
+		"ns.detail/ns.detail.Parser(0.5): parse()=1 reset()=0",
+	}
+	if !reflect.DeepEqual(tree, want) {
+		t.Errorf("package tree:\n%s\nwant:\n%s", strings.Join(tree, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestCompressedReport(t *testing.T) {
+	tmp := t.TempDir()
+	logs, out := filepath.Join(tmp, "logs"), filepath.Join(tmp, "out")
+	if err := os.Mkdir(logs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	var content strings.Builder
+	content.WriteString("[FuncTracer] [Format:10]\n[Image:/usr/bin/alpha] [Called:f0]\n")
+	for i := range 1000 {
+		fmt.Fprintf(&content, "[Image:/usr/bin/alpha] [Function:f%d]\n", i)
+	}
+	if err := os.WriteFile(filepath.Join(logs, "prog_20260101-100000_1.log"), []byte(content.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	report := func(compress bool) {
+		// A fixed timestamp, so the pages of both runs are the same
+		if err := runReport(ReportOptions{Inputs: []string{logs}, OutputDir: out, Formats: []string{"html", "xml"}, Compress: compress, Timestamp: time.Unix(1700000000, 0).UTC()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	page := filepath.Join(out, htmlReportFileName("/usr/bin/alpha"))
+	report(false)
+	plain, _ := os.ReadFile(page)
+	report(true)
+
+	if fileExists(page) || !fileExists(page+".gz") || !fileExists(filepath.Join(out, summaryFileName)) {
+		t.Fatal("expected the detailed page replaced by its gzipped copy, and summary.json left as it is")
+	}
+	f, err := openLog(page + ".gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unzipped, _ := io.ReadAll(f)
+	f.Close()
+	if !bytes.Equal(unzipped, plain) {
+		t.Error("the gzipped page differs from the page")
+	}
+	var index CompressedIndex
+	indexJSON, _ := os.ReadFile(filepath.Join(out, compressedIndexFileName))
+	if err := json.Unmarshal(indexJSON, &index); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, file := range index.Files {
+		found = found || file.File == filepath.Base(page) && file.Size == int64(len(plain)) && file.CompressedSize > 0 && file.CompressedSize < file.Size
+	}
+	if !found || index.CompressedSize >= index.Size {
+		t.Errorf("unexpected index %+v", index)
+	}
+	summary, _ := os.ReadFile(filepath.Join(out, summaryFileName))
+	if !bytes.Contains(summary, []byte(filepath.Base(page)+`.gz"`)) {
+		t.Errorf("expected the summary to list the gzipped page:\n%s", summary)
+	}
+	os.Remove(filepath.Join(out, summaryFileName))
+	if saved, err := loadSavedReport(out); err != nil || saved.Images["alpha"].TotalCount != 1000 {
+		t.Errorf("expected the gzipped XUnit report to be read back: %v", err)
+	}
+
+	report(false)
+	if !fileExists(page) || fileExists(page+".gz") || fileExists(filepath.Join(out, compressedIndexFileName)) {
+		t.Error("expected an uncompressed run to remove the stale gzipped copies")
+	}
+}
+
+func TestCoverageGates(t *testing.T) {
+	totals := CoverageTotals{Rows: []CoverageSummary{
+		{ImageName: "/usr/lib64/libssl.so.3", CoveragePct: 55},
+		{ImageName: "/usr/sbin/sshd", CoveragePct: 80},
+		{ImageName: "/usr/libexec/ssh-helper", CoveragePct: 12},
+	}}
+	gates := GatesConfig{Default: 40, Images: map[string]float64{"libssl*": 60, "re:^/usr/sbin/": 75, "*-helper": 10, "re:ssh": 20}}
+	results, err := gates.evaluate(totals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []GateResult{
+		{Image: "/usr/lib64/libssl.so.3", Pattern: "libssl*", Target: 60, Coverage: 55},
+		{Image: "/usr/sbin/sshd", Pattern: "re:^/usr/sbin/", Target: 75, Coverage: 80, Passed: true},
+		{Image: "/usr/libexec/ssh-helper", Pattern: "re:ssh", Target: 20, Coverage: 12},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("evaluate = %+v, want %+v", results, want)
+	}
+	if err := gateFailure(results); err == nil || err.Error() != "2 of 3 images below their coverage target" {
+		t.Errorf("gateFailure = %v", err)
+	}
+	if results, _ := (GatesConfig{Images: map[string]float64{"sshd": 90}}).evaluate(totals); len(results) != 1 {
+		t.Errorf("expected only the matched image to be checked without a default, got %+v", results)
+	}
+	if _, err := (GatesConfig{Images: map[string]float64{"sshd": 120}}).evaluate(totals); err == nil {
+		t.Error("expected a target above 100% to be rejected")
+	}
+
+	rules := GatesConfig{Default: 40, Images: map[string]float64{"sshd": 90}, Rules: []GateRule{
+		{Regex: "^/usr/", Target: 30},
+		{Regex: "^lib.*\\.so", Target: 50},
+		{Regex: "^/usr/libexec/", Target: 10, Priority: 1},
+	}}
+	results, err = rules.evaluate(totals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []GateResult{
+		{Image: "/usr/lib64/libssl.so.3", Pattern: "^/usr/", Target: 30, Coverage: 55, Passed: true},
+		{Image: "/usr/sbin/sshd", Pattern: "sshd", Target: 90, Coverage: 80},
+		{Image: "/usr/libexec/ssh-helper", Pattern: "^/usr/libexec/", Target: 10, Coverage: 12, Passed: true},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("evaluate with rules = %+v, want %+v", results, want)
+	}
+	rules.Rules[0].Priority = -1
+	if results, _ := rules.evaluate(totals); results[0].Pattern != "^lib.*\\.so" {
+		t.Errorf("expected the base name rule of higher priority to apply, got %+v", results[0])
+	}
+	if _, err := (GatesConfig{Rules: []GateRule{{Regex: "(", Target: 10}}}).evaluate(totals); err == nil {
+		t.Error("expected an invalid rule regex to be rejected")
+	}
+
+	tmp := t.TempDir()
+	logs, out := filepath.Join(tmp, "logs"), filepath.Join(tmp, "out")
+	if err := os.Mkdir(logs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	content := "[FuncTracer] [Format:10]\n[Image:/usr/bin/alpha] [Function:a]\n[Image:/usr/bin/alpha] [Function:b]\n[Image:/usr/bin/alpha] [Called:a]\n"
+	if err := os.WriteFile(filepath.Join(logs, "prog_20260101-100000_1.log"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := filepath.Join(tmp, "config.json")
+	t.Setenv("FUNKOVERAGE_CONFIG", config)
+	for target, pass := range map[string]bool{"50": true, "60": false} {
+		if err := os.WriteFile(config, []byte(`{"gates": {"images": {"alpha": `+target+`}}}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		err := runReport(ReportOptions{Inputs: []string{logs}, OutputDir: out, Formats: []string{"dot"}})
+		if pass != (err == nil) {
+			t.Errorf("target %s%%: runReport = %v", target, err)
+		}
+		data, _ := os.ReadFile(filepath.Join(out, summaryFileName))
+		if !bytes.Contains(data, []byte(`"pattern": "alpha"`)) {
+			t.Errorf("expected the gates in summary.json:\n%s", data)
+		}
+	}
+}
+
+func TestSymbolAliases(t *testing.T) {
+	tmp := t.TempDir()
+	file := filepath.Join(tmp, "aliases")
+	rules := "# wrappers\n__wrap_malloc = malloc\n\nre:(.*)_(sse2|avx2) = $1\noperator== = equals\n"
+	if err := os.WriteFile(file, []byte(rules), 0644); err != nil {
+		t.Fatal(err)
+	}
+	aliases, err := loadSymbolAliases(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for from, to := range map[string]string{"__wrap_malloc": "malloc", "copy_avx2": "copy", "copy_avx2x": "copy_avx2x", "operator==": "equals", "free": "free"} {
+		if got := aliases.rename(from); got != to {
+			t.Errorf("rename(%q) = %q, want %q", from, got, to)
+		}
+	}
+	for _, bad := range []string{"malloc", "re:( = x", " = x"} {
+		if err := os.WriteFile(file, []byte(bad+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadSymbolAliases(file); err == nil {
+			t.Errorf("expected rule %q to be rejected", bad)
+		}
+	}
+
+	log := filepath.Join(tmp, "prog_20260101-100000_1.log")
+	content := "[FuncTracer] [Format:10]\n[Image:/usr/bin/alpha] [Function:copy_sse2]\n[Image:/usr/bin/alpha] [Function:copy_avx2]\n" +
+		"[Image:/usr/bin/alpha] [Function:__wrap_malloc]\n[Image:/usr/bin/alpha] [Function:malloc]\n[Image:/usr/bin/alpha] [Called:copy_avx2]\n"
+	if err := os.WriteFile(log, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	coverage, _, err := analyzeLogsWith([]string{log}, AnalyzeOptions{Aliases: aliases})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := coverage["/usr/bin/alpha"]
+	if got := sortedKeys(data.TotalFunctions); !reflect.DeepEqual(got, []string{"copy", "malloc"}) {
+		t.Errorf("functions = %v, want the aliases merged", got)
+	}
+	if _, ok := data.CalledFunctions["copy"]; !ok || len(data.CalledFunctions) != 1 {
+		t.Errorf("called = %v, want copy", sortedKeys(data.CalledFunctions))
+	}
+}
+
+func TestStructorVariants(t *testing.T) {
+	for symbol, want := range map[string]string{
+		"_ZN3FooC1Ev":       "C1",
+		"_ZN3FooC2ERKS_":    "C2",
+		"_ZN3FooD0Ev":       "D0",
+		"_ZN2ns3BarIiED2Ev": "D2",
+		"_ZN3BazCI13FooEi":  "CI1",
+		"_ZN3Foo5runC1Ev":   "", // Foo::runC1()
+		"_ZN3Foo3runEv":     "",
+		"malloc":            "",
+	} {
+		if got := structorVariant(symbol, demangle.Filter(symbol)); got != want {
+			t.Errorf("structorVariant(%s) = %q, want %q", symbol, got, want)
+		}
+	}
+
+	log := filepath.Join(t.TempDir(), "prog_20260101-100000_1.log")
+	content := "[FuncTracer] [Format:10]\n" +
+		"[Image:/usr/bin/alpha] [Addr:0x1000] [Function:_ZN3FooC1Ev]\n[Image:/usr/bin/alpha] [Addr:0x1040] [Function:_ZN3FooC2Ev]\n" +
+		"[Image:/usr/bin/alpha] [Addr:0x1080] [Function:_ZN3FooD0Ev]\n[Image:/usr/bin/alpha] [Addr:0x10c0] [Function:_ZN3FooD1Ev]\n" +
+		"[Image:/usr/bin/alpha] [Addr:0x1100] [Function:_ZN3Foo5runC1Ev]\n" +
+		"[Image:/usr/bin/alpha] [Addr:0x1040] [Count:3] [Called:_ZN3FooC2Ev]\n[Image:/usr/bin/alpha] [Addr:0x1000] [Count:2] [Called:_ZN3FooC1Ev]\n"
+	if err := os.WriteFile(log, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	coverage, _, err := analyzeLogsWith([]string{log}, AnalyzeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := coverage["/usr/bin/alpha"]
+	if got := sortedKeys(data.TotalFunctions); !reflect.DeepEqual(got, []string{"Foo::Foo()", "Foo::runC1()", "Foo::~Foo()"}) {
+		t.Errorf("functions = %q, want one per constructor and destructor", got)
+	}
+	if got := sortedKeys(data.CalledFunctions); !reflect.DeepEqual(got, []string{"Foo::Foo()"}) {
+		t.Errorf("called = %q", got)
+	}
+	if data.Calls["Foo::Foo()"] != 5 || !reflect.DeepEqual(data.Variants, map[string][]string{"Foo::Foo()": {"C1", "C2"}}) {
+		t.Errorf("calls = %v, variants = %v", data.Calls, data.Variants)
+	}
+}
+
+func TestMangledNames(t *testing.T) {
+	tmp := t.TempDir()
+	log := filepath.Join(tmp, "prog_20260101-100000_1.log")
+	content := "[FuncTracer] [Format:10]\n[Image:/usr/bin/alpha] [Function:_ZN3Foo3runEv]\n[Image:/usr/bin/alpha] [Function:_ZN3FooC2Ev]\n" +
+		"[Image:/usr/bin/alpha] [Function:_ZN3FooC1Ev]\n[Image:/usr/bin/alpha] [Function:malloc]\n[Image:/usr/bin/alpha] [Called:_ZN3FooC2Ev]\n"
+	if err := os.WriteFile(log, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"Foo::run()": "_ZN3Foo3runEv", "Foo::Foo()": "_ZN3FooC1Ev"}
+	// Parsed, then read back from the index, then spilled to disk.
+	for _, opts := range []AnalyzeOptions{{}, {}, {MaxMemory: 1}} {
+		coverage, _, err := analyzeLogsWith([]string{log}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := coverage["/usr/bin/alpha"].Mangled; !reflect.DeepEqual(got, want) {
+			t.Errorf("mangled = %v, want %v", got, want)
+		}
+		if got := uncalledFunctions(coverage); len(got) != 2 || got[0].Function != "Foo::run()" || got[0].Mangled != "_ZN3Foo3runEv" || got[1].Mangled != "" {
+			t.Errorf("uncalled = %+v", got)
+		}
+	}
+
+	coverage, _, err := analyzeLogsWith([]string{log}, AnalyzeOptions{NoDemangle: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sortedKeys(coverage["/usr/bin/alpha"].TotalFunctions); !reflect.DeepEqual(got, []string{"_ZN3Foo3runEv", "_ZN3FooC1Ev", "_ZN3FooC2Ev", "malloc"}) {
+		t.Errorf("functions with --no-demangle = %v", got)
+	}
+
+	out := filepath.Join(tmp, "out")
+	if err := runReport(ReportOptions{Inputs: []string{log}, OutputDir: out, Formats: []string{"html"}}); err != nil {
+		t.Fatal(err)
+	}
+	html, _ := os.ReadFile(filepath.Join(out, htmlReportFileName("/usr/bin/alpha")))
+	if !bytes.Contains(html, []byte(`title="run() [_ZN3Foo3runEv]" data-mangled="_ZN3Foo3runEv"`)) {
+		t.Errorf("expected the mangled symbol in the detailed report:\n%s", html)
+	}
+}
+
+func TestFunctionsKeyedOnSymbols(t *testing.T) {
+	tmp := t.TempDir()
+	log := filepath.Join(tmp, "prog_20260101-100000_1.log")
+	content := "[FuncTracer] [Format:10]\n[Image:/usr/bin/alpha] [Function:_ZN3Foo3runEv]\n[Image:/usr/bin/alpha] [Function:_ZN3Foo4stopEv]\n" +
+		"[Image:/usr/bin/alpha] [Count:2] [Called:_ZN3Foo3runEv]\n[Image:/usr/bin/alpha] [Count:2] [Caller:_ZN3Foo3runEv] [Callee:_ZN3Foo4stopEv]\n"
+	if err := os.WriteFile(log, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	coverage, _, err := analyzeLogsWith([]string{log}, AnalyzeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := coverage["/usr/bin/alpha"]
+	if _, ok := data.CalledFunctions["Foo::run()"]; !ok || data.Calls["Foo::run()"] != 2 {
+		t.Errorf("called = %v, calls = %v", sortedKeys(data.CalledFunctions), data.Calls)
+	}
+	if data.Edges[Edge{Caller: "Foo::run()", Callee: "Foo::stop()"}] != 2 {
+		t.Errorf("expected the edges under the demangled names, got %v", data.Edges)
+	}
+	// The index keeps the symbols, whatever names the reports use.
+	idx, ok := readLogIndex(log, AnalyzeOptions{NoDemangle: true})
+	if !ok {
+		t.Fatal("expected the index to be valid whatever the presentation options")
+	}
+	if got := idx.Images["/usr/bin/alpha"].Called; !reflect.DeepEqual(got, []string{"_ZN3Foo3runEv"}) {
+		t.Errorf("indexed called functions = %v, want the symbols", got)
+	}
+}
+
+func TestAddressMatching(t *testing.T) {
+	log := filepath.Join(t.TempDir(), "prog_20260101-100000_1.log")
+	content := "[FuncTracer] [Format:10]\n" +
+		"[Image:/usr/bin/alpha] [Addr:0x2000] [Count:4] [Called:_ZN3FooC1Ev]\n" + // before its definition, under an alias
+		"[Image:/usr/bin/alpha] [Addr:0x1000] [Function:init]\n[Image:/usr/bin/alpha] [Addr:0x3000] [Function:init]\n" +
+		"[Image:/usr/bin/alpha] [Addr:0x2000] [Function:_ZN3FooC2Ev]\n[Image:/usr/bin/alpha] [Addr:0x2000] [Function:_ZN3FooC1Ev]\n" +
+		"[Image:/usr/bin/alpha] [Addr:0x4000] [Function:helper]\n[Image:/usr/bin/alpha] [Addr:0x4000] [Count:1] [Called:helper.localalias]\n" +
+		"[Image:/usr/bin/alpha] [Addr:0x3000] [Count:2] [Called:init]\n[Image:/usr/bin/alpha] [Count:2] [Caller:init] [Callee:helper.localalias]\n"
+	if err := os.WriteFile(log, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	coverage, _, err := analyzeLogsWith([]string{log}, AnalyzeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := coverage["/usr/bin/alpha"]
+	if got := sortedKeys(data.TotalFunctions); !reflect.DeepEqual(got, []string{"Foo::Foo()", "helper", "init [0x1000]", "init [0x3000]"}) {
+		t.Errorf("functions = %q", got)
+	}
+	if got := sortedKeys(data.CalledFunctions); !reflect.DeepEqual(got, []string{"Foo::Foo()", "helper", "init [0x3000]"}) {
+		t.Errorf("called = %q, want every call matched to its definition by address", got)
+	}
+	if data.Calls["Foo::Foo()"] != 4 || data.Calls["helper"] != 1 {
+		t.Errorf("calls = %v", data.Calls)
+	}
+	if data.Edges[Edge{Caller: "init", Callee: "helper"}] != 2 {
+		t.Errorf("edges = %v, want the callee under its definition", data.Edges)
+	}
+}
+
+func TestStaticReachability(t *testing.T) {
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found")
+	}
+	tmp := t.TempDir()
+	src := filepath.Join(tmp, "prog.c")
+	code := `#include <stdio.h>
+__attribute__((noinline)) int helper(int x) { return x + 1; }
+__attribute__((noinline)) int called(int x) { return helper(x) * 2; }
+__attribute__((noinline)) int via_pointer(int x) { return x - 1; }
+int (*table[])(int) = {via_pointer};
+__attribute__((noinline)) int plugin_helper(int x) { return x * 7; }
+int plugin_api(int x) { return plugin_helper(x); }
+__attribute__((noinline, used)) static int dead(int x) { return x * 3; }
+int main(int argc, char **argv) { if (argc > 5) return table[0](argc); printf("%d\n", called(argc)); return 0; }
+`
+	if err := os.WriteFile(src, []byte(code), 0644); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"main": reachableFromMain, "called": reachableFromMain, "helper": reachableFromMain, "via_pointer": reachableFromMain,
+		"plugin_api": reachableViaPlugins, "plugin_helper": reachableViaPlugins, "dead": apparentlyUnreachable,
+	}
+	for _, flags := range [][]string{{"-pie", "-fPIE"}, {"-no-pie", "-fno-pie"}} {
+		bin := filepath.Join(tmp, "prog"+flags[0])
+		args := append([]string{"-O1", "-rdynamic", "-o", bin, src}, flags...)
+		if out, err := exec.Command("gcc", args...).CombinedOutput(); err != nil {
+			t.Fatalf("gcc %v: %v\n%s", flags, err, out)
+		}
+		classes := staticReachability(bin)
+		for fn, class := range want {
+			if classes[fn] != class {
+				t.Errorf("%s: %s is %q, want %q", flags[0], fn, classes[fn], class)
+			}
+		}
+	}
+
+	// Without -rdynamic nothing is exported for plugins to call.
+	bin := filepath.Join(tmp, "prog")
+	if out, err := exec.Command("gcc", "-O1", "-o", bin, src).CombinedOutput(); err != nil {
+		t.Fatalf("gcc: %v\n%s", err, out)
+	}
+	data := newCoverageData()
+	for _, fn := range []string{"main", "called", "helper", "via_pointer", "plugin_api", "dead"} {
+		data.TotalFunctions[fn] = struct{}{}
+	}
+	data.CalledFunctions["main"] = struct{}{}
+	r, w, _ := os.Pipe()
+	stdout := os.Stdout
+	os.Stdout = w
+	printReachability(map[string]*CoverageData{bin: data})
+	os.Stdout = stdout
+	w.Close()
+	txt, _ := io.ReadAll(r)
+	if !bytes.Contains(txt, []byte("reachable from main (3):\n      - called\n      - helper\n      - via_pointer\n")) ||
+		!bytes.Contains(txt, []byte("apparently unreachable (2):\n      - dead\n      - plugin_api\n")) {
+		t.Errorf("unexpected reachability section:\n%s", txt)
+	}
+}
+
+func TestCommandHooks(t *testing.T) {
+	tmp := t.TempDir()
+	record := filepath.Join(tmp, "record")
+	hook := filepath.Join(tmp, "hook")
+	script := "#!/bin/sh\n{ echo \"$FUNKOVERAGE_HOOK $FUNKOVERAGE_STATUS $FUNKOVERAGE_BINARIES\"; cat; echo; } >> " + record + "\n"
+	if err := os.WriteFile(hook, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	veto := filepath.Join(tmp, "veto")
+	if err := os.WriteFile(veto, []byte("#!/bin/sh\nexit 3\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	hooks := HooksConfig{PreWrap: []string{hook}, PostWrap: []string{hook}}
+	ran := false
+	if err := hooks.around("wrap", HookContext{Binaries: []string{"/usr/bin/a"}}, func() error {
+		ran = true
+		return fmt.Errorf("boom")
+	}); err == nil || err.Error() != "boom" || !ran {
+		t.Fatalf("around = %v, ran = %v; want the error of the command", err, ran)
+	}
+	got, _ := os.ReadFile(record)
+	want := "pre-wrap  /usr/bin/a\n" + `{"hook":"pre-wrap","command":"wrap","binaries":["/usr/bin/a"]}` + "\n" +
+		"post-wrap failed /usr/bin/a\n" + `{"hook":"post-wrap","command":"wrap","binaries":["/usr/bin/a"],"status":"failed","error":"boom"}` + "\n"
+	if string(got) != want {
+		t.Errorf("hooks recorded:\n%s\nwant:\n%s", got, want)
+	}
+
+	// A failing pre hook cancels the command, a failing post hook fails it.
+	ran = false
+	err := HooksConfig{PreUnwrap: []string{veto}}.around("unwrap", HookContext{}, func() error { ran = true; return nil })
+	if err == nil || ran || !strings.Contains(err.Error(), "pre-unwrap hook") {
+		t.Errorf("around = %v, ran = %v; want the command cancelled", err, ran)
+	}
+	if err := (HooksConfig{PostReport: []string{veto}}).around("report", HookContext{}, func() error { return nil }); err == nil {
+		t.Error("expected the failing post-report hook to fail the command")
+	}
+}
+
+func TestForcedUnwrap(t *testing.T) {
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found")
+	}
+	tmp := t.TempDir()
+	store := filepath.Join(tmp, "store")
+	os.Setenv("PIN_ROOT", "/tmp/pin")
+	os.Setenv("PIN_TOOL_SEARCH_DIR", tmp)
+	os.Setenv("SAFE_BIN_DIR", store)
+	os.Setenv("LOG_DIR", tmp)
+	if err := os.WriteFile(filepath.Join(tmp, "FuncTracer.so"), []byte("dummy"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(tmp, "main.c")
+	if err := os.WriteFile(src, []byte("int main() { return 0; }"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	origVersion := packageVersionResolver
+	defer func() { packageVersionResolver = origVersion }()
+	packageVersionResolver = func(string) (string, string) { return "tools", "1.2-3" }
+	origExtractor := packageExtractor
+	defer func() { packageExtractor = origExtractor }()
+	wrapAndBreak := func(name string) (string, string) {
+		bin := filepath.Join(tmp, name)
+		if out, err := exec.Command("gcc", "-g", "-Wl,--build-id", "-o", bin, src).CombinedOutput(); err != nil {
+			t.Fatalf("failed to compile: %v\n%s", err, out)
+		}
+		if err := wrap(bin, WrapOptions{}); err != nil {
+			t.Fatalf("wrap failed: %v", err)
+		}
+		// An edited wrapper, which unwrap no longer recognizes.
+		if err := os.WriteFile(bin, []byte("#!/bin/sh\nexec /opt/other \"$@\"\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		m, _ := loadManifest(store)
+		e, _ := m.findByPath(bin)
+		return bin, e.Backup
+	}
+
+	// The backup was moved within the store: found by its build ID.
+	moved, backup := wrapAndBreak("moved")
+	if err := os.MkdirAll(filepath.Join(store, "elsewhere"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(backup, filepath.Join(store, "elsewhere", "copy")); err != nil {
+		t.Fatal(err)
+	}
+	packageExtractor = func(path, name, version string) error {
+		t.Errorf("unexpected extraction of %s", path)
+		return nil
+	}
+	if err := unwrapMany([]string{moved}, UnwrapOptions{}); err == nil {
+		t.Fatal("expected unwrap to fail without --force")
+	}
+	if err := unwrapMany([]string{moved}, UnwrapOptions{Force: true}); err != nil {
+		t.Fatalf("forced unwrap failed: %v", err)
+	}
+	if !isELF(moved) || fileExists(filepath.Join(store, "elsewhere", "copy")) {
+		t.Error("expected the moved backup to be restored")
+	}
+
+	// The backup is gone: extracted from the recorded package version.
+	lost, backup := wrapAndBreak("lost")
+	os.Remove(backup)
+	var extracted string
+	packageExtractor = func(path, name, version string) error {
+		extracted = path + " " + name + " " + version
+		return os.WriteFile(path, []byte("\x7fELF restored"), 0755)
+	}
+	if err := forceUnwrap(lost); err != nil {
+		t.Fatalf("forced unwrap failed: %v", err)
+	}
+	if extracted != lost+" tools 1.2-3" {
+		t.Errorf("extracted %q, want the recorded package version", extracted)
+	}
+	if m, _ := loadManifest(store); len(m.Entries) != 0 {
+		t.Errorf("expected the manifest entries to be removed, got %+v", m.Entries)
+	}
+
+	// Anything else is left alone.
+	other := filepath.Join(tmp, "other")
+	if err := os.WriteFile(other, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := forceUnwrap(other); err == nil {
+		t.Error("expected a binary that is neither a wrapper nor in the manifest to be refused")
+	}
+
+	// A file extracted from a package keeps its setuid bit and owner.
+	suid := filepath.Join(tmp, "suid")
+	if err := os.WriteFile(suid, []byte("\x7fELF suid"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if os.Geteuid() == 0 {
+		if err := os.Chown(suid, 1, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chmod(suid, 0755|os.ModeSetuid); err != nil {
+		t.Fatal(err)
+	}
+	if err := installExtractedFile(suid, other); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode() != 0755|os.ModeSetuid {
+		t.Errorf("mode = %v, want -rwsr-xr-x", info.Mode())
+	}
+	if uid, _, _ := fileOwner(info); os.Geteuid() == 0 && uid != 1 {
+		t.Errorf("owner = %d, want 1", uid)
+	}
+	if err := installExtractedFile(filepath.Join(tmp, "missing"), other); err == nil {
+		t.Error("expected a file missing from the package to be refused")
+	}
+	if unixFileMode(0o4754) != 0754|os.ModeSetuid {
+		t.Errorf("unixFileMode(4754) = %v", unixFileMode(0o4754))
+	}
+
+	// The archive is piped into the extraction, whose failures both count.
+	unpack := exec.Command("sh", "-c", "cat > piped")
+	unpack.Dir = tmp
+	if err := runPipe(exec.Command("echo", "archive"), unpack); err != nil {
+		t.Fatal(err)
+	}
+	if piped, _ := os.ReadFile(filepath.Join(tmp, "piped")); string(piped) != "archive\n" {
+		t.Errorf("piped %q", piped)
+	}
+	if err := runPipe(exec.Command("sh", "-c", "echo broken >&2; exit 3"), exec.Command("cat")); err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("expected the archive failure to be reported, got %v", err)
+	}
+	if err := runPipe(exec.Command("echo", "archive"), exec.Command("sh", "-c", "exit 2")); err == nil {
+		t.Error("expected the extraction failure to be reported")
+	}
+}
+
+func TestUnwrapVerifiesBackupChecksum(t *testing.T) {
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found")
+	}
+	tmp := t.TempDir()
+	store := filepath.Join(tmp, "store")
+	os.Setenv("PIN_ROOT", "/tmp/pin")
+	os.Setenv("PIN_TOOL_SEARCH_DIR", tmp)
+	os.Setenv("SAFE_BIN_DIR", store)
+	os.Setenv("LOG_DIR", tmp)
+	if err := os.WriteFile(filepath.Join(tmp, "FuncTracer.so"), []byte("dummy"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(tmp, "main.c")
+	if err := os.WriteFile(src, []byte("int main() { return 0; }"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	origVersion := packageVersionResolver
+	defer func() { packageVersionResolver = origVersion }()
+	packageVersionResolver = func(string) (string, string) { return "", "" }
+	bin := filepath.Join(tmp, "prog")
+	if out, err := exec.Command("gcc", "-g", "-o", bin, src).CombinedOutput(); err != nil {
+		t.Fatalf("failed to compile: %v\n%s", err, out)
+	}
+	want, err := fileSHA256(bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wrap(bin, WrapOptions{}); err != nil {
+		t.Fatalf("wrap failed: %v", err)
+	}
+	m, _ := loadManifest(store)
+	e, _ := m.findByPath(bin)
+	if e.SHA256 != want {
+		t.Fatalf("recorded SHA-256 %q, want %q", e.SHA256, want)
+	}
+
+	// A corrupted backup is refused, and the wrapper left in place.
+	f, err := os.OpenFile(e.Backup, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("garbage")
+	f.Close()
+	if err := unwrap(bin); err == nil || !strings.Contains(err.Error(), "SHA-256") {
+		t.Fatalf("expected unwrap to refuse the corrupted backup, got %v", err)
+	}
+	if !isWrapper(bin) {
+		t.Fatal("expected the wrapper to be left in place")
+	}
+
+	// With --force and no other copy, it is restored with a warning.
+	if err := unwrapMany([]string{bin}, UnwrapOptions{Force: true}); err != nil {
+		t.Fatalf("forced unwrap failed: %v", err)
+	}
+	if isWrapper(bin) || !isELF(bin) {
+		t.Error("expected the backup to be restored")
+	}
+	if m, _ := loadManifest(store); len(m.Entries) != 0 {
+		t.Errorf("expected the manifest entry to be removed, got %+v", m.Entries)
+	}
+}
+
+func TestWrapSkipsAndRewrapsWrappers(t *testing.T) {
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found")
+	}
+	tmp := t.TempDir()
+	store := filepath.Join(tmp, "store")
+	os.Setenv("PIN_ROOT", "/tmp/pin")
+	os.Setenv("PIN_TOOL_SEARCH_DIR", tmp)
+	os.Setenv("SAFE_BIN_DIR", store)
+	os.Setenv("LOG_DIR", tmp)
+	if err := os.WriteFile(filepath.Join(tmp, "FuncTracer.so"), []byte("dummy"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(tmp, "main.c")
+	if err := os.WriteFile(src, []byte("int main() { return 0; }"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bin := filepath.Join(tmp, "prog")
+	if out, err := exec.Command("gcc", "-g", "-o", bin, src).CombinedOutput(); err != nil {
+		t.Fatalf("failed to compile: %v\n%s", err, out)
+	}
+	// A multicall binary, wrapped through the name it is called by.
+	alias := filepath.Join(tmp, "alias")
+	if err := os.Symlink(bin, alias); err != nil {
+		t.Fatal(err)
+	}
+	if err := wrap(alias, WrapOptions{}); err != nil {
+		t.Fatalf("wrap failed: %v", err)
+	}
+	wrapper, _ := os.ReadFile(bin)
+	m, _ := loadManifest(store)
+	e, _ := m.findByPath(bin)
+
+	// Wrapping again leaves the wrapper and its backup alone.
+	if err := wrapMany([]string{bin}, WrapOptions{Sample: Sampling{Every: 3}}); err != nil {
+		t.Fatalf("wrapping twice failed: %v", err)
+	}
+	if again, _ := os.ReadFile(bin); !bytes.Equal(again, wrapper) || !isELF(e.Backup) {
+		t.Fatal("expected the wrapped binary to be skipped")
+	}
+
+	// --rewrap regenerates the wrapper with the new options, same backup.
+	if err := wrap(bin, WrapOptions{Sample: Sampling{Every: 3}, Rewrap: true}); err != nil {
+		t.Fatalf("rewrap failed: %v", err)
+	}
+	content, _ := os.ReadFile(bin)
+	if wrapperOriginal(content) != e.Backup || !strings.Contains(string(content), `sample="${FUNKOVERAGE_SAMPLE-3}"`) {
+		t.Errorf("unexpected rewrapped wrapper:\n%s", content)
+	}
+	if !strings.Contains(string(content), `ORIGINAL_BINARY="`+filepath.Join(filepath.Dir(e.Backup), "alias")+`"`) {
+		t.Error("expected the multicall symlink to still be run")
+	}
+	m, _ = loadManifest(store)
+	if len(m.Entries) != 1 || m.Entries[0].Sample != "3" || m.Entries[0].Backup != e.Backup || m.Entries[0].SHA256 != e.SHA256 {
+		t.Errorf("manifest after rewrap = %+v", m.Entries)
+	}
+
+	// upgrade-wrappers brings an outdated wrapper to the current template.
+	outdated := strings.Replace(string(content), "export BINARYCOVERAGE_PIN_ACTIVE=1", "", 1)
+	if err := os.WriteFile(bin, []byte(outdated), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := upgradeWrappers(store, true); err != nil {
+		t.Fatalf("upgrade-wrappers --dry-run failed: %v", err)
+	}
+	if current, _ := os.ReadFile(bin); string(current) != outdated {
+		t.Error("expected --dry-run to leave the wrapper alone")
+	}
+	if err := upgradeWrappers(store, false); err != nil {
+		t.Fatalf("upgrade-wrappers failed: %v", err)
+	}
+	content, _ = os.ReadFile(bin)
+	if !strings.Contains(string(content), "export BINARYCOVERAGE_PIN_ACTIVE=1") || !strings.Contains(string(content), `sample="${FUNKOVERAGE_SAMPLE-3}"`) || wrapperOriginal(content) != e.Backup {
+		t.Errorf("unexpected upgraded wrapper:\n%s", content)
+	}
+
+	if err := unwrap(bin); err != nil || !isELF(bin) {
+		t.Fatalf("unwrap after rewrap failed: %v", err)
+	}
+}
+
+func TestRecoverWrappers(t *testing.T) {
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found")
+	}
+	tmp := t.TempDir()
+	store := filepath.Join(tmp, "store")
+	os.Setenv("PIN_ROOT", "/tmp/pin")
+	os.Setenv("PIN_TOOL_SEARCH_DIR", tmp)
+	os.Setenv("SAFE_BIN_DIR", store)
+	os.Setenv("LOG_DIR", tmp)
+	if err := os.WriteFile(filepath.Join(tmp, "FuncTracer.so"), []byte("dummy"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	compile := func(bin, code string) {
+		src := bin + ".c"
+		if err := os.WriteFile(src, []byte(code), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if out, err := exec.Command("gcc", "-g", "-Wl,--build-id", "-o", bin, src).CombinedOutput(); err != nil {
+			t.Fatalf("failed to compile: %v\n%s", err, out)
+		}
+	}
+	restored, updated, wrapped := filepath.Join(tmp, "restored"), filepath.Join(tmp, "updated"), filepath.Join(tmp, "wrapped")
+	for _, bin := range []string{restored, updated, wrapped} {
+		compile(bin, "int main() { return 0; }\n// "+filepath.Base(bin))
+		if err := wrap(bin, WrapOptions{Sample: Sampling{Every: 3}}); err != nil {
+			t.Fatalf("wrap failed: %v", err)
+		}
+	}
+	// The golden image puts the originals back, and one was updated since.
+	saved := filepath.Join(tmp, "golden-manifest.json")
+	if err := copyFile(filepath.Join(store, manifestFileName), saved); err != nil {
+		t.Fatal(err)
+	}
+	m, _ := loadManifest(store)
+	old, _ := m.findByPath(restored)
+	if err := copyFile(old.Backup, restored); err != nil {
+		t.Fatal(err)
+	}
+	compile(updated, "int main() { return 1; }")
+
+	if err := recoverWrappers(saved, store); err != nil {
+		t.Fatalf("recover failed: %v", err)
+	}
+	if !isWrapper(restored) || isWrapper(updated) || !isWrapper(wrapped) {
+		t.Errorf("expected only the restored binary to be wrapped again: %v %v %v", isWrapper(restored), isWrapper(updated), isWrapper(wrapped))
+	}
+	m, _ = loadManifest(store)
+	if e, ok := m.findByPath(restored); !ok || e.Backup == old.Backup || e.Sample != "3" || fileExists(old.Backup) {
+		t.Errorf("expected a new backup with the recorded options, got %+v", e)
+	}
+}
+
+func TestRunMetadataInReports(t *testing.T) {
+	tmp := t.TempDir()
+	logs, out, safeBin := filepath.Join(tmp, "logs"), filepath.Join(tmp, "out"), filepath.Join(tmp, "bin")
+	for _, dir := range []string{logs, safeBin} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PIN_ROOT", "")
+	t.Setenv("SAFE_BIN_DIR", safeBin)
+	if err := os.WriteFile(filepath.Join(safeBin, manifestFileName), []byte("{}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for i, ctx := range []string{"[Time:2025-07-10T08:00:00Z] [Host:vm2]", "[Time:2025-07-09T10:46:48Z] [Host:vm1]"} {
+		content := "[FuncTracer] [Format:11] [Tool:0.6.3] [Run:" + fmt.Sprint(i) + "]\n" +
+			"[FuncTracer] [Context] " + ctx + " [Cwd:/tmp] [Arg:prog]\n" +
+			"[Image:/usr/bin/prog] [Function:main]\n[Image:/usr/bin/prog] [Called:main]\n"
+		if err := os.WriteFile(filepath.Join(logs, fmt.Sprintf("prog_%d.log", i)), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := runReport(ReportOptions{Inputs: []string{logs}, OutputDir: out, Formats: []string{"html", "xml"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var summary ReportSummary
+	content, _ := os.ReadFile(filepath.Join(out, summaryFileName))
+	if err := json.Unmarshal(content, &summary); err != nil {
+		t.Fatal(err)
+	}
+	sum, _ := fileSHA256(filepath.Join(safeBin, manifestFileName))
+	want := RunMetadata{Host: summary.Metadata.Host, Kernel: summary.Metadata.Kernel, Version: versionString, TracerVersions: []string{"0.6.3"},
+		ManifestSHA256: sum, LogHosts: []string{"vm1", "vm2"}, FirstLog: "2025-07-09T10:46:48Z", LastLog: "2025-07-10T08:00:00Z"}
+	if summary.Metadata == nil || !reflect.DeepEqual(*summary.Metadata, want) {
+		t.Fatalf("unexpected metadata %+v, want %+v", summary.Metadata, want)
+	}
+	for _, page := range []string{htmlReportFileName("/usr/bin/prog"), aggregateReportFileName} {
+		html, _ := os.ReadFile(filepath.Join(out, page))
+		if !bytes.Contains(html, []byte("Run metadata")) || !bytes.Contains(html, []byte("<td>vm1, vm2</td>")) {
+			t.Errorf("expected %s to show the run metadata:\n%s", page, html)
+		}
+	}
+	xunit, _ := os.ReadFile(filepath.Join(out, xunitReportFileName("/usr/bin/prog")))
+	if !bytes.Contains(xunit, []byte(`<property name="manifest_sha256" value="`+sum+`"></property>`)) {
+		t.Errorf("expected the XUnit report to hold the metadata as properties:\n%s", xunit)
+	}
+	export, _ := os.ReadFile(filepath.Join(out, htmlReportFileName("/usr/bin/prog")))
+	if !bytes.Contains(export, []byte(`"log_hosts":["vm1","vm2"]`)) {
+		t.Error("expected the embedded JSON of the detailed page to hold the metadata")
+	}
+	os.Remove(filepath.Join(out, summaryFileName))
+	if saved, err := loadSavedReport(out); err != nil || saved.Images["prog"].CalledCount != 1 {
+		t.Errorf("expected the XUnit report with properties to be read back: %v", err)
+	}
+}
+
+func TestCoverageStats(t *testing.T) {
+	tmp := t.TempDir()
+	logs := filepath.Join(tmp, "logs")
+	if err := os.Mkdir(logs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	var content strings.Builder
+	content.WriteString("[FuncTracer] [Format:10]\n")
+	for _, fn := range []string{"f", "g", "h", "i"} {
+		fmt.Fprintf(&content, "[Image:/usr/bin/alpha] [Function:%s]\n[Image:/usr/bin/beta] [Function:%s]\n", fn, fn)
+	}
+	content.WriteString("[Image:/usr/bin/alpha] [Called:f]\n[Image:/usr/bin/beta] [Called:f]\n[Image:/usr/bin/beta] [Called:g]\n")
+	if err := os.WriteFile(filepath.Join(logs, "prog_20260101-100000_1.log"), []byte(content.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	totals, err := coverageStats(logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	printCoverageStats(&out, totals, false)
+	if got, want := out.String(), "2 images, 8 functions, 3 called, 37.50%\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	state := filepath.Join(tmp, "state.json")
+	if err := runReport(ReportOptions{Inputs: []string{logs}, OutputDir: filepath.Join(tmp, "out"), Formats: []string{"txt"}, NoSummary: true, StateFile: state}); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := coverageStats(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(saved, totals) {
+		t.Errorf("the state sums up to %+v, the logs to %+v", saved, totals)
+	}
+	out.Reset()
+	printCoverageStats(&out, saved, true)
+	want := "IMAGE           FUNCTIONS     CALLED COVERAGE\n" +
+		"/usr/bin/alpha          4          1   25.00%\n" +
+		"/usr/bin/beta           4          2   50.00%\n" +
+		"TOTAL                   8          3   37.50%\n"
+	if out.String() != want {
+		t.Errorf("got table\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestMergeDuplicateImages(t *testing.T) {
+	tmp := t.TempDir()
+	logs, out := filepath.Join(tmp, "logs"), filepath.Join(tmp, "out")
+	if err := os.Mkdir(logs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for i, image := range []string{"/usr/bin/prog", "/var/lib/containers/layer1/usr/bin/prog"} {
+		content := "[FuncTracer] [Format:10]\n[Image:" + image + "] [BuildID:ab12]\n" +
+			"[Image:" + image + "] [Function:f]\n[Image:" + image + "] [Function:g]\n" +
+			"[Image:" + image + "] [Called:" + []string{"f", "g"}[i] + "]\n" +
+			"[Image:/usr/bin/other" + fmt.Sprint(i) + "] [BuildID:cd3" + fmt.Sprint(i) + "]\n[Image:/usr/bin/other" + fmt.Sprint(i) + "] [Function:h]\n"
+		if err := os.WriteFile(filepath.Join(logs, fmt.Sprintf("prog_%d.log", i)), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := runReport(ReportOptions{Inputs: []string{logs}, OutputDir: out, Formats: []string{"html"}}); err != nil {
+		t.Fatal(err)
+	}
+	var summary ReportSummary
+	content, _ := os.ReadFile(filepath.Join(out, summaryFileName))
+	if err := json.Unmarshal(content, &summary); err != nil {
+		t.Fatal(err)
+	}
+	want := []CoverageSummary{
+		{ImageName: "/usr/bin/other0", TotalCount: 1},
+		{ImageName: "/usr/bin/other1", TotalCount: 1},
+		{ImageName: "/usr/bin/prog", TotalCount: 2, CalledCount: 2, CoveragePct: 100},
+	}
+	if !reflect.DeepEqual(summary.Rows, want) {
+		t.Errorf("got rows %+v, want %+v", summary.Rows, want)
+	}
+	html, _ := os.ReadFile(filepath.Join(out, aggregateReportFileName))
+	if !bytes.Contains(html, []byte("(also at /var/lib/containers/layer1/usr/bin/prog)")) {
+		t.Error("expected the aggregate report to list the merged path")
+	}
+
+	// Without build IDs, the images on disk are compared by content.
+	var images []string
+	for i, content := range []string{"same", "same", "diff"} {
+		image := filepath.Join(tmp, fmt.Sprintf("root%d", i), "tool")
+		if err := os.MkdirAll(filepath.Dir(image), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(image, []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+		images = append(images, image)
+	}
+	coverage := map[string]*CoverageData{}
+	for i, image := range images {
+		coverage[image] = newCoverageData()
+		coverage[image].TotalFunctions["main"] = struct{}{}
+		coverage[image].CalledFunctions["main"] = struct{}{}
+		coverage[image].addCalls("main", uint64(i+1))
+	}
+	mergeDuplicateImages(coverage, nil)
+	if len(coverage) != 2 || coverage[images[0]] == nil || !reflect.DeepEqual(coverage[images[0]].Copies, images[1:2]) || coverage[images[0]].Calls["main"] != 3 {
+		t.Errorf("expected the identical copies merged, got %+v", coverage)
+	}
+}
+
+func TestFunctionPresets(t *testing.T) {
+	tmp := t.TempDir()
+	logFile := filepath.Join(tmp, "prog_20260101-100000_1.log")
+	var content strings.Builder
+	content.WriteString("[FuncTracer] [Format:10]\n")
+	for _, symbol := range []string{"_ZNSt6vectorIiSaIiEE9push_backERKi", "_ZSt4sortIPiEvT_S1_", "_ZN9__gnu_cxx13new_allocatorIiE8allocateEmPKv", "_ZN10__cxxabiv117__class_type_infoD2Ev", "_ZN2ns3fooEv", "main"} {
+		fmt.Fprintf(&content, "[Image:/usr/bin/prog] [Function:%s]\n", symbol)
+	}
+	content.WriteString("[Image:/usr/bin/prog] [Called:_ZNSt6vectorIiSaIiEE9push_backERKi]\n[Image:/usr/bin/prog] [Called:_ZN2ns3fooEv]\n")
+	if err := os.WriteFile(logFile, []byte(content.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	presets, err := lookupFunctionPresets([]string{"cxx-internals"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	coverage, _, err := analyzeLogsWith([]string{logFile}, AnalyzeOptions{Presets: presets})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := coverage["/usr/bin/prog"]
+	if got := sortedKeys(data.TotalFunctions); !reflect.DeepEqual(got, []string{"main", "ns::foo()"}) {
+		t.Errorf("expected the standard library functions left out, got %q", got)
+	}
+	if got := sortedKeys(data.CalledFunctions); !reflect.DeepEqual(got, []string{"ns::foo()"}) {
+		t.Errorf("got called functions %q", got)
+	}
+	if _, err := lookupFunctionPresets([]string{"boost"}); err == nil {
+		t.Error("expected an unknown preset to be rejected")
+	}
+}
+
+func TestInlinedFunctions(t *testing.T) {
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found, skipping inlining test")
+	}
+	tmp := t.TempDir()
+	src, bin := filepath.Join(tmp, "main.c"), filepath.Join(tmp, "prog")
+	code := `#define INLINE static inline __attribute__((always_inline))
+INLINE int inner(int x) { return x * 7; }
+INLINE int helper(int x) { return inner(x) + 1; }
+INLINE int deep(int x) { return x - 3; }
+__attribute__((noinline)) int outer(int x) { return helper(x) + 2; }
+__attribute__((noinline)) int unused(int x) { return deep(x); }
+int main(int argc, char **argv) { return outer(argc); }
+`
+	if err := os.WriteFile(src, []byte(code), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command("gcc", "-g", "-O2", "-o", bin, src).CombinedOutput(); err != nil {
+		t.Fatalf("failed to compile: %v\n%s", err, out)
+	}
+	parents, err := inlinedFunctions(bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for fn, want := range map[string][]string{"helper": {"outer"}, "inner": {"helper", "outer"}, "deep": {"unused"}} {
+		// Depending on the compiler, nested copies are attributed to the
+		// inlined function or to the one holding the code.
+		found := false
+		for _, p := range parents[fn] {
+			found = found || strings.Contains(" "+strings.Join(want, " ")+" ", " "+p+" ")
+		}
+		if !found {
+			t.Errorf("%s inlined into %q, want one of %q", fn, parents[fn], want)
+		}
+	}
+
+	coverage := map[string]*CoverageData{bin: newCoverageData()}
+	data := coverage[bin]
+	for _, fn := range []string{"main", "outer", "unused"} {
+		data.TotalFunctions[fn] = struct{}{}
+	}
+	data.CalledFunctions["main"], data.CalledFunctions["outer"] = struct{}{}, struct{}{}
+	markInlinedFunctions(coverage, nil)
+	if got := sortedKeys(data.TotalFunctions); !reflect.DeepEqual(got, []string{"deep", "helper", "inner", "main", "outer", "unused"}) {
+		t.Errorf("expected the functions only inlined to be counted, got %q", got)
+	}
+	if got := sortedKeys(data.CalledFunctions); !reflect.DeepEqual(got, []string{"helper", "inner", "main", "outer"}) {
+		t.Errorf("expected the functions inlined into called ones to be covered, got %q", got)
+	}
+	if got := data.Inlined["helper"]; !reflect.DeepEqual(got, []string{"outer"}) {
+		t.Errorf("expected helper covered via outer, got %q", got)
+	}
+	if _, ok := data.Inlined["main"]; ok {
+		t.Error("expected the functions called directly not to be marked inlined")
+	}
+}
+
+func TestCloneSuffixes(t *testing.T) {
+	for name, want := range map[string]string{
+		"parse.lto_priv.0":                           "parse",
+		"parse.constprop.0.isra.0":                   "parse",
+		"parse.part.3.cold":                          "parse",
+		"helper.1234":                                "helper",
+		"_ZN2ns3fooEv.llvm.8812":                     "_ZN2ns3fooEv",
+		"ns::foo(int) [clone .constprop.0]":          "ns::foo(int)",
+		"ns::foo(int) [clone .isra.0] [clone .cold]": "ns::foo(int)",
+		"memcpy":                           "memcpy",
+		"std::array<int, 3>::size() const": "std::array<int, 3>::size() const",
+		".1":                               ".1",
+	} {
+		if got := stripCloneSuffixes(name); got != want {
+			t.Errorf("stripCloneSuffixes(%q) = %q, want %q", name, got, want)
+		}
+	}
+
+	tmp := t.TempDir()
+	logFile := filepath.Join(tmp, "prog_20260101-100000_1.log")
+	content := "[FuncTracer] [Format:10]\n" +
+		"[Image:/usr/bin/prog] [Function:parse]\n[Image:/usr/bin/prog] [Function:parse.constprop.0]\n" +
+		"[Image:/usr/bin/prog] [Function:_Z3fooi]\n[Image:/usr/bin/prog] [Function:_Z3fooi.lto_priv.0]\n" +
+		"[Image:/usr/bin/prog] [Called:parse.constprop.0]\n[Image:/usr/bin/prog] [Called:_Z3fooi.lto_priv.0]\n"
+	if err := os.WriteFile(logFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	coverage, _, err := analyzeLogsWith([]string{logFile}, AnalyzeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := coverage["/usr/bin/prog"]
+	if got := sortedKeys(data.CalledFunctions); !reflect.DeepEqual(got, []string{"foo(int)", "parse"}) || len(data.TotalFunctions) != 2 {
+		t.Errorf("expected the copies merged into their functions, got %q of %q", got, sortedKeys(data.TotalFunctions))
+	}
+	if coverage, _, _ = analyzeLogsWith([]string{logFile}, AnalyzeOptions{CloneSuffixes: true}); len(coverage["/usr/bin/prog"].TotalFunctions) != 4 {
+		t.Errorf("expected --clone-suffixes to keep the copies apart, got %q", sortedKeys(coverage["/usr/bin/prog"].TotalFunctions))
+	}
+}
+
+func TestNormalizeSonames(t *testing.T) {
+	tmp := t.TempDir()
+	coverage := map[string]*CoverageData{}
+	add := func(image string, functions ...string) {
+		data := newCoverageData()
+		for _, fn := range functions {
+			data.TotalFunctions[fn] = struct{}{}
+		}
+		data.CalledFunctions[functions[0]] = struct{}{}
+		coverage[image] = data
+	}
+	// Files gone from disk are named by their major version.
+	add("/nonexistent/lib64/libbar.so.2.0.1", "bar_a", "bar_b")
+	add("/nonexistent/lib64/libbar.so.2.0.2", "bar_b", "bar_c")
+	add("/nonexistent/lib64/libbaz.so.3 [riscv64]", "baz")
+	add("/nonexistent/lib64/libbaz.so.3.1 [riscv64]", "baz")
+	add("/usr/bin/prog", "main")
+	if _, err := exec.LookPath("gcc"); err == nil {
+		// Files on disk are named by their DT_SONAME, when it prefixes the name.
+		src := filepath.Join(tmp, "lib.c")
+		if err := os.WriteFile(src, []byte("int sn(void) { return 1; }\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		for soname, file := range map[string]string{"libsn.so.1": "libsn.so.1.2.3", "libodd.so.4.5": "libodd.so.4.5.6", "libother.so.1": "librenamed.so.1.0"} {
+			if out, err := exec.Command("gcc", "-shared", "-fPIC", "-Wl,-soname,"+soname, "-o", filepath.Join(tmp, file), src).CombinedOutput(); err != nil {
+				t.Fatalf("failed to compile %s: %v\n%s", file, err, out)
+			}
+			add(filepath.Join(tmp, file), "sn")
+		}
+	}
+	normalizeSonames(coverage)
+
+	bar := coverage["/nonexistent/lib64/libbar.so.2"]
+	if bar == nil || len(bar.TotalFunctions) != 3 || len(bar.CalledFunctions) != 2 ||
+		!reflect.DeepEqual(bar.Files, []string{"/nonexistent/lib64/libbar.so.2.0.1", "/nonexistent/lib64/libbar.so.2.0.2"}) {
+		t.Errorf("expected the libbar versions merged under their soname, got %q", sortedKeys(coverage))
+	}
+	if baz := coverage["/nonexistent/lib64/libbaz.so.3 [riscv64]"]; baz == nil || !reflect.DeepEqual(baz.Files, []string{"/nonexistent/lib64/libbaz.so.3.1"}) {
+		t.Errorf("expected the riscv64 libbaz merged under its soname, got %q", sortedKeys(coverage))
+	}
+	if prog := coverage["/usr/bin/prog"]; prog == nil || prog.Files != nil {
+		t.Error("expected the program to keep its name")
+	}
+	if _, err := exec.LookPath("gcc"); err == nil {
+		for image, files := range map[string][]string{
+			filepath.Join(tmp, "libsn.so.1"):        {filepath.Join(tmp, "libsn.so.1.2.3")},
+			filepath.Join(tmp, "libodd.so.4.5"):     {filepath.Join(tmp, "libodd.so.4.5.6")},
+			filepath.Join(tmp, "librenamed.so.1.0"): nil,
+		} {
+			if data := coverage[image]; data == nil || !reflect.DeepEqual(data.Files, files) {
+				t.Errorf("expected %s with files %q, got %q", image, files, sortedKeys(coverage))
+			}
+		}
+	}
+	if len(coverage) != 6 && len(coverage) != 3 {
+		t.Errorf("unexpected images %q", sortedKeys(coverage))
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// --- AFL Fuzzing Bridge ---
+
+// aflShmEnv names the coverage map a fuzzer of the AFL family shares with its
+// target; FuncTracer -afl counts the transitions between routines in it.
+const aflShmEnv = "__AFL_SHM_ID"
+
+// aflDefaultMapSize is the size of the map, AFL_DEFAULT_MAP_SIZE of
+// FuncTracer.hpp.
+const aflDefaultMapSize = 1 << 16
+
+// FuzzBridgeOptions describe a run of fuzz-bridge.
+type FuzzBridgeOptions struct {
+	// MapSize is the size of the map fuzz-bridge creates when not run by a
+	// fuzzer (default: 64 KiB, or AFL_MAP_SIZE).
+	MapSize int
+	// Output receives the map of a run outside a fuzzer.
+	Output  string
+	Command []string
+}
+
+// fuzzBridge runs a command as the coverage-guided target of a fuzzer: under
+// Pin with FuncTracer -afl, or as is when it is a wrapped binary, whose
+// wrapper passes -afl itself. Run by a fuzzer, the command is executed in
+// place of fuzz-bridge and fills the map of __AFL_SHM_ID. Run by hand, fuzz-bridge creates a map and writes it to
+// Output like afl-showmap -r, one "index:count" line per transition seen. It
+// returns the exit status of the command.
+func fuzzBridge(opts FuzzBridgeOptions) (int, error) {
+	if len(opts.Command) == 0 {
+		return 0, errors.New("a command is required")
+	}
+	path, err := exec.LookPath(opts.Command[0])
+	if err != nil {
+		return 0, err
+	}
+	argv := append([]string{path}, opts.Command[1:]...)
+	if !isWrapper(path) {
+		pinRoot := os.Getenv("PIN_ROOT")
+		if pinRoot == "" {
+			return 0, errors.New("PIN_ROOT environment variable is not set")
+		}
+		searchDir := os.Getenv("PIN_TOOL_SEARCH_DIR")
+		if searchDir == "" {
+			searchDir = defaultPinToolSearchDir
+		}
+		pinTool, err := findPinTool(searchDir)
+		if err != nil {
+			return 0, err
+		}
+		argv = append([]string{filepath.Join(pinRoot, "pin"), "-follow_execv", "-t", pinTool, "-o", os.DevNull, "-afl", "1", "--"}, argv...)
+	}
+	if os.Getenv(aflShmEnv) != "" {
+		// The command takes the place of fuzz-bridge, so the fuzzer sees
+		// its exit status and the signal of a crash as its own.
+		return 0, syscall.Exec(argv[0], argv, os.Environ())
+	}
+
+	if opts.Output == "" {
+		return 0, errors.New("outside a fuzzer, --output names the file receiving the coverage map")
+	}
+	size := opts.MapSize
+	if size <= 0 {
+		if size, _ = strconv.Atoi(os.Getenv("AFL_MAP_SIZE")); size <= 0 {
+			size = aflDefaultMapSize
+		}
+	}
+	// A POSIX shared memory object, read back like a file.
+	f, err := os.CreateTemp("/dev/shm", "funkoverage-afl-")
+	if err != nil {
+		return 0, fmt.Errorf("could not create the coverage map: %w", err)
+	}
+	defer os.Remove(f.Name())
+	err = f.Truncate(int64(size))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("could not create the coverage map: %w", err)
+	}
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), aflShmEnv+"=/"+filepath.Base(f.Name()), "AFL_MAP_SIZE="+strconv.Itoa(size))
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return 0, err
+	}
+	if err := writeAflMap(f.Name(), opts.Output); err != nil {
+		return 0, err
+	}
+	return cmd.ProcessState.ExitCode(), nil
+}
+
+// writeAflMap writes the transitions counted in a map to output.
+func writeAflMap(mapFile, output string) error {
+	counts, err := os.ReadFile(mapFile)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	for i, n := range counts {
+		if n != 0 {
+			fmt.Fprintf(&b, "%06d:%d\n", i, n)
+		}
+	}
+	return os.WriteFile(output, []byte(b.String()), 0644)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+// --- Coverage Gates ---
+
+// GatesConfig holds the coverage targets report checks the images against,
+// failing when one misses its target.
+type GatesConfig struct {
+	// Default is the target of the images no pattern matches; 0 leaves them unchecked.
+	Default float64 `json:"default"`
+	// Images maps image patterns (see report --image) to their target. When
+	// several match an image, the highest target applies.
+	Images map[string]float64 `json:"images"`
+	// Rules give the images no pattern of Images matches the target of the
+	// first rule whose regex matches, for families of images to share one.
+	Rules []GateRule `json:"rules"`
+}
+
+// GateRule is a coverage target for the images matching a regular expression.
+type GateRule struct {
+	// Regex is matched against the path and the base name of the images.
+	Regex  string  `json:"regex"`
+	Target float64 `json:"target"`
+	// Priority orders the rules, highest first; rules of the same priority
+	// are tried in their order.
+	Priority int `json:"priority"`
+}
+
+// GateResult is the check of an image against its coverage target.
+type GateResult struct {
+	Image    string  `json:"image"`
+	Pattern  string  `json:"pattern"` // "default" when no pattern matched
+	Target   float64 `json:"target"`
+	Coverage float64 `json:"coverage"`
+	Passed   bool    `json:"passed"`
+}
+
+const defaultGatePattern = "default"
+
+func (c GatesConfig) enabled() bool {
+	return c.Default > 0 || len(c.Images) > 0 || len(c.Rules) > 0
+}
+
+// gateRule is a GateRule with its regex compiled.
+type gateRule struct {
+	GateRule
+	re *regexp.Regexp
+}
+
+// rules compiles the rules, in the order they are tried.
+func (c GatesConfig) rules() ([]gateRule, error) {
+	rules := make([]gateRule, 0, len(c.Rules))
+	for _, r := range c.Rules {
+		if r.Target < 0 || r.Target > 100 {
+			return nil, fmt.Errorf("target %g of rule %q is not a percentage", r.Target, r.Regex)
+		}
+		re, err := regexp.Compile(r.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gate rule regex %q: %w", r.Regex, err)
+		}
+		rules = append(rules, gateRule{r, re})
+	}
+	sort.SliceStable(rules, func(i, j int) bool { return rules[i].Priority > rules[j].Priority })
+	return rules, nil
+}
+
+// evaluate checks every image of totals against its target, in image order.
+// Images without a target are left out.
+func (c GatesConfig) evaluate(totals CoverageTotals) ([]GateResult, error) {
+	if !c.enabled() {
+		return nil, nil
+	}
+	if c.Default < 0 || c.Default > 100 {
+		return nil, fmt.Errorf("default target %g is not a percentage", c.Default)
+	}
+	patterns := make([]ImagePattern, 0, len(c.Images))
+	for _, source := range sortedKeys(c.Images) {
+		if target := c.Images[source]; target < 0 || target > 100 {
+			return nil, fmt.Errorf("target %g of %q is not a percentage", target, source)
+		}
+		p, err := parseImagePattern(source)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, p)
+	}
+	rules, err := c.rules()
+	if err != nil {
+		return nil, err
+	}
+	var results []GateResult
+	for _, row := range totals.Rows {
+		result := GateResult{Image: row.ImageName, Pattern: defaultGatePattern, Target: c.Default, Coverage: row.CoveragePct}
+		matched := false
+		for _, p := range patterns {
+			if p.matches(row.ImageName) == p.Negate {
+				continue
+			}
+			if target := c.Images[p.Source]; !matched || target > result.Target {
+				result.Pattern, result.Target = p.Source, target
+			}
+			matched = true
+		}
+		for i := 0; !matched && i < len(rules); i++ {
+			if r := rules[i]; r.re.MatchString(row.ImageName) || r.re.MatchString(filepath.Base(row.ImageName)) {
+				result.Pattern, result.Target = r.Regex, r.Target
+				matched = true
+			}
+		}
+		if !matched && c.Default == 0 {
+			continue
+		}
+		result.Passed = result.Coverage >= result.Target
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// printGateResults prints the pass/fail table of the checked images, the
+// failing ones first.
+func printGateResults(results []GateResult) {
+	if len(results) == 0 {
+		return
+	}
+	sorted := append([]GateResult(nil), results...)
+	sort.SliceStable(sorted, func(i, j int) bool { return !sorted[i].Passed && sorted[j].Passed })
+	fmt.Println("\n================= Coverage Gates =================")
+	for _, r := range sorted {
+		status, cmp := "PASS", ">="
+		if !r.Passed {
+			status, cmp = "FAIL", "< "
+		}
+		fmt.Printf("  %s  %6.2f%% %s %6.2f%%  %s (%s)\n", status, r.Coverage, cmp, r.Target, r.Image, r.Pattern)
+	}
+	fmt.Println("==================================================")
+}
+
+// gateFailure returns an error when an image missed its target.
+func gateFailure(results []GateResult) error {
+	failed := 0
+	for _, r := range results {
+		if !r.Passed {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d images below their coverage target", failed, len(results))
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// --- Coverage History ---
+
+// historyPoints is how many runs the sparklines of the aggregate report show.
+const historyPoints = 20
+
+// historySnapshot is the coverage summary of one report run. The history
+// store is a directory holding one snapshot file per run.
+type historySnapshot struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	CoverageTotals
+}
+
+func historySnapshotFileName(generatedAt time.Time) string {
+	return "coverage-" + generatedAt.UTC().Format("20060102T150405Z") + ".json"
+}
+
+// loadHistory reads the snapshots of dir, oldest first. A missing directory is
+// an empty history.
+func loadHistory(dir string) ([]historySnapshot, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	snapshots := []historySnapshot{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), "coverage-") || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var s historySnapshot
+		if err := json.Unmarshal(content, &s); err != nil {
+			return nil, fmt.Errorf("could not parse %s: %w", entry.Name(), err)
+		}
+		snapshots = append(snapshots, s)
+	}
+	sort.SliceStable(snapshots, func(i, j int) bool { return snapshots[i].GeneratedAt.Before(snapshots[j].GeneratedAt) })
+	return snapshots, nil
+}
+
+// saveHistorySnapshot adds the summary of the current run to the history.
+// Runs with the same generation time, such as reproducible rebuilds, replace
+// each other instead of piling up.
+func saveHistorySnapshot(dir string, generatedAt time.Time, totals CoverageTotals) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(historySnapshot{GeneratedAt: generatedAt, CoverageTotals: totals}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, historySnapshotFileName(generatedAt)), append(content, '\n'), 0644)
+}
+
+// imageTrend is the coverage history of an image for the aggregate report.
+type imageTrend struct {
+	// Points are the coverage percentages of the last runs, the current one last.
+	Points []float64
+	// Delta is the change versus the previous run, valid when HasPrevious is set.
+	Delta       float64
+	HasPrevious bool
+}
+
+// imageTrends combines the history with the current summary. Snapshots taken
+// at the current generation time are skipped, as the current run replaces them.
+func imageTrends(history []historySnapshot, generatedAt time.Time, current CoverageTotals) map[string]imageTrend {
+	trends := make(map[string]imageTrend, len(current.Rows))
+	for _, row := range current.Rows {
+		var t imageTrend
+		for _, s := range history {
+			if s.GeneratedAt.Equal(generatedAt) {
+				continue
+			}
+			for _, r := range s.Rows {
+				if r.ImageName == row.ImageName {
+					t.Points = append(t.Points, r.CoveragePct)
+					break
+				}
+			}
+		}
+		if n := len(t.Points); n > 0 {
+			t.Delta, t.HasPrevious = row.CoveragePct-t.Points[n-1], true
+		}
+		t.Points = append(t.Points, row.CoveragePct)
+		if len(t.Points) > historyPoints {
+			t.Points = t.Points[len(t.Points)-historyPoints:]
+		}
+		trends[row.ImageName] = t
+	}
+	return trends
+}
+
+// Sparkline geometry, in pixels.
+const (
+	sparklineWidth  = 100
+	sparklineHeight = 20
+)
+
+// sparkline renders coverage percentages as a small inline SVG line on a fixed
+// 0-100% scale, so the trends of different images compare at a glance.
+func sparkline(points []float64) template.HTML {
+	if len(points) < 2 {
+		return ""
+	}
+	coords := make([]string, len(points))
+	step := float64(sparklineWidth-2) / float64(len(points)-1)
+	for i, p := range points {
+		y := 1 + (100-p)/100*(sparklineHeight-2)
+		coords[i] = fmt.Sprintf("%.1f,%.1f", 1+float64(i)*step, y)
+	}
+	last := coords[len(coords)-1]
+	x, y, _ := strings.Cut(last, ",")
+	return template.HTML(fmt.Sprintf(`<svg class="sparkline" width="%d" height="%d" viewBox="0 0 %d %d"><polyline fill="none" stroke="currentColor" stroke-width="1.5" points="%s"/><circle cx="%s" cy="%s" r="2" fill="currentColor"/></svg>`,
+		sparklineWidth, sparklineHeight, sparklineWidth, sparklineHeight, strings.Join(coords, " "), x, y))
+}
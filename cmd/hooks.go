@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// --- Package Manager Hooks ---
+
+// hookFormats are the package managers generate-hooks writes hooks for.
+var hookFormats = []string{"zypper", "dnf", "apt"}
+
+// hookEnv are baked into the hooks when set, since package managers run
+// them with an environment of their own.
+var hookEnv = []string{"PIN_ROOT", "PIN_TOOL_SEARCH_DIR", "LOG_DIR", "SAFE_BIN_DIR", "FUNKOVERAGE_CONFIG"}
+
+// HookFile is a hook to install at Path (absolute).
+type HookFile struct {
+	Path    string
+	Mode    os.FileMode
+	Content string
+}
+
+// hookEnvironment returns the hookEnv variables set in the environment, as NAME=value.
+func hookEnvironment() []string {
+	var env []string
+	for _, name := range hookEnv {
+		if value, ok := os.LookupEnv(name); ok && value != "" {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
+
+// pythonRefreshCall returns the Python statements running funkoverage
+// refresh, for the zypper and dnf plugins, indented by indent. A missing
+// funkoverage must not fail the transaction, and its output goes to stderr:
+// libzypp talks to its plugins over stdout.
+func pythonRefreshCall(self string, env []string, indent string) string {
+	var b strings.Builder
+	b.WriteString("env = dict(os.environ")
+	for _, kv := range env {
+		name, value, _ := strings.Cut(kv, "=")
+		fmt.Fprintf(&b, ", %s=%q", name, value)
+	}
+	fmt.Fprintf(&b, ")\ntry:\n    subprocess.call([%q, \"refresh\"], env=env, stdout=2)\nexcept OSError:\n    pass", self)
+	return indent + strings.ReplaceAll(b.String(), "\n", "\n"+indent)
+}
+
+// dnfPluginDir returns where dnf 4 loads its Python plugins from on this
+// host, which depends on the Python version.
+func dnfPluginDir() string {
+	for _, pattern := range []string{"/usr/lib/python3*/site-packages/dnf-plugins", "/usr/lib/python3/dist-packages/dnf-plugins"} {
+		if dirs, _ := filepath.Glob(pattern); len(dirs) > 0 {
+			return dirs[len(dirs)-1]
+		}
+	}
+	return "/usr/lib/python3/site-packages/dnf-plugins"
+}
+
+// generateHooks returns the hook files of a package manager calling self
+// refresh after every transaction, so binaries replaced by an update are
+// wrapped again.
+func generateHooks(format, self string, env []string) ([]HookFile, error) {
+	switch format {
+	case "zypper":
+		// libzypp commit plugin, see /usr/share/doc/packages/libzypp/zypp-plugins.
+		return []HookFile{{
+			Path: "/usr/lib/zypp/plugins/commit/funkoverage",
+			Mode: 0755,
+			Content: `#!/usr/bin/python3
+# Generated by funkoverage generate-hooks: wrap again the binaries updated
+# by a zypper transaction. Needs python3-zypp-plugin.
+import os
+import subprocess
+
+from zypp_plugin import Plugin
+
+
+class FunkoveragePlugin(Plugin):
+    def COMMITEND(self, headers, body):
+` + pythonRefreshCall(self, env, "        ") + `
+        self.ack()
+
+
+FunkoveragePlugin().main()
+`}}, nil
+	case "dnf":
+		actions := "post_transaction::::/usr/bin/env " + strings.Join(append(env, self, "refresh"), " ")
+		return []HookFile{{
+			Path: filepath.Join(dnfPluginDir(), "funkoverage.py"),
+			Mode: 0644,
+			Content: `# Generated by funkoverage generate-hooks: wrap again the binaries updated
+# by a dnf transaction (dnf 4).
+import os
+import subprocess
+
+import dnf
+
+
+class Funkoverage(dnf.Plugin):
+    name = "funkoverage"
+
+    def transaction(self):
+` + pythonRefreshCall(self, env, "        ") + `
+`}, {
+			// dnf 5 runs commands of its actions plugin (libdnf5-plugin-actions).
+			Path:    "/etc/dnf/libdnf5-plugins/actions.d/funkoverage.actions",
+			Mode:    0644,
+			Content: "# Generated by funkoverage generate-hooks (dnf 5).\n" + actions + "\n",
+		}}, nil
+	case "apt":
+		words := []string{}
+		for _, kv := range env {
+			name, value, _ := strings.Cut(kv, "=")
+			words = append(words, name+"="+shellQuote(value))
+		}
+		cmd := strings.Join(append(words, shellQuote(self), "refresh"), " ")
+		return []HookFile{{
+			Path: "/etc/apt/apt.conf.d/80funkoverage",
+			Mode: 0644,
+			Content: `// Generated by funkoverage generate-hooks: wrap again the binaries updated
+// by an apt/dpkg run. A failure does not fail the run.
+DPkg::Post-Invoke { "` + strings.ReplaceAll(cmd, `"`, `\"`) + ` || true"; };
+`}}, nil
+	}
+	return nil, fmt.Errorf("unknown hook format %q, expected %s", format, strings.Join(hookFormats, ", "))
+}
+
+// writeHooks installs the hook files below root, "/" for the running system.
+func writeHooks(files []HookFile, root string) error {
+	for _, f := range files {
+		path := filepath.Join(root, f.Path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(f.Content), f.Mode); err != nil {
+			return err
+		}
+		if err := os.Chmod(path, f.Mode); err != nil {
+			return err
+		}
+		fmt.Println("Wrote", path)
+	}
+	return nil
+}
+
+// isWrapper reports whether path holds a wrapper script or launcher.
+func isWrapper(path string) bool {
+	if _, ok := readLauncherConfig(path); ok {
+		return true
+	}
+	content, err := os.ReadFile(path)
+	return err == nil && strings.Contains(string(content), wrapperIDComment)
+}
+
+// refreshWrappers brings the manifest of safeBinDir in line with the system
+// after a package transaction: binaries an update put in place of their
+// wrapper are wrapped again with their options, and binaries the package
+// manager removed are dropped along with their backup.
+func refreshWrappers(safeBinDir string) error {
+	m, err := loadManifest(safeBinDir)
+	if err != nil {
+		return err
+	}
+	var failed []string
+	for _, e := range m.Entries {
+		_, statErr := os.Lstat(e.Path)
+		if statErr == nil && isWrapper(e.Path) {
+			continue
+		}
+		// The backup is outdated: the package manager removed or replaced the binary.
+		if dir := filepath.Dir(e.Backup); filepath.Dir(dir) == filepath.Clean(safeBinDir) {
+			if err := os.RemoveAll(dir); err != nil {
+				fmt.Printf("Warning: failed to remove %s: %v\n", dir, err)
+			}
+		}
+		if err := updateManifest(safeBinDir, func(m *Manifest) { m.remove(e.Path) }); err != nil {
+			return err
+		}
+		if errors.Is(statErr, os.ErrNotExist) {
+			fmt.Printf("Dropped %s (removed)\n", e.Path)
+			continue
+		}
+		opts, err := e.wrapOptions()
+		if err == nil {
+			err = wrap(e.Path, opts)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "refresh error for %s: %v\n", e.Path, err)
+			failed = append(failed, e.Path)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to wrap again: %v", failed)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+// --- Hot Functions ---
+
+// defaultHotFunctions is how many of the most called functions are listed per image.
+const defaultHotFunctions = 10
+
+type HotFunction struct {
+	Image    string `json:"image"`
+	Function string `json:"function"`
+	Calls    uint64 `json:"calls"`
+}
+
+// hotFunctions returns the n most called functions of an image, by call count
+// and then name. Images traced before call counts existed have none.
+func hotFunctions(image string, data *CoverageData, n int) []HotFunction {
+	if n <= 0 || len(data.Calls) == 0 {
+		return nil
+	}
+	list := make([]HotFunction, 0, len(data.Calls))
+	for fn, calls := range data.Calls {
+		list = append(list, HotFunction{Image: filepath.Base(image), Function: fn, Calls: calls})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Calls != list[j].Calls {
+			return list[i].Calls > list[j].Calls
+		}
+		return list[i].Function < list[j].Function
+	})
+	if len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+// allHotFunctions lists the n most called functions of every image, sorted by image.
+func allHotFunctions(coverage map[string]*CoverageData, n int) []HotFunction {
+	images := make([]string, 0, len(coverage))
+	for image := range coverage {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	list := []HotFunction{}
+	for _, image := range images {
+		list = append(list, hotFunctions(image, coverage[image], n)...)
+	}
+	return list
+}
+
+// printHotFunctions prints the hot functions section of an image in the text report.
+func printHotFunctions(hot []HotFunction) {
+	if len(hot) == 0 {
+		return
+	}
+	fmt.Println("  Hot Functions:")
+	for _, h := range hot {
+		fmt.Printf("    %12d  %s\n", h.Calls, printableSymbol(h.Function))
+	}
+	fmt.Printf("--------------------------------------------------\n")
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// --- GNU IFUNC Handling ---
+
+// ifuncResolverSuffix is how Pin names the resolver routine of an IFUNC symbol.
+const ifuncResolverSuffix = "_ifunc"
+
+// ifuncVariantTags are the name fragments glibc and friends use for the
+// per-CPU implementations an IFUNC resolver chooses from; ifunc_routine of
+// FuncTracer.hpp logs the variants by the same tags.
+var ifuncVariantTags = map[string]bool{
+	"sse2": true, "ssse3": true, "sse4": true, "sse42": true, "avx": true, "avx2": true,
+	"avx512": true, "avx512f": true, "avx512bw": true, "avx512vl": true, "evex": true,
+	"evex512": true, "rtm": true, "erms": true, "unaligned": true, "aligned": true,
+	"generic": true, "baseline": true, "sve": true, "asimd": true, "simd": true,
+	"power7": true, "power8": true, "power9": true, "power10": true, "z13": true, "z15": true,
+}
+
+// ifuncVariantOf returns the logical function fn implements if it looks like
+// a per-CPU variant ("__memcpy_avx_unaligned") of one of the dispatchers.
+func ifuncVariantOf(fn string, dispatchers map[string]bool) (string, bool) {
+	name := strings.TrimLeft(fn, "_")
+	for i := 1; i < len(name); i++ {
+		if name[i] != '_' || !dispatchers[name[:i]] {
+			continue
+		}
+		for _, tag := range strings.Split(name[i+1:], "_") {
+			if ifuncVariantTags[tag] {
+				return name[:i], true
+			}
+		}
+	}
+	return "", false
+}
+
+// collapseIFuncs merges IFUNC resolvers and their implementation variants into
+// the logical function of each image, which counts as called when one of its
+// variants ran (or, when no variant was traced, when the resolver ran). It
+// returns, per image, the variants that actually ran for each logical function.
+// The IFUNC functions are those with a resolver in the logs, so the result
+// does not depend on the images of the host the report runs on.
+func collapseIFuncs(coverage map[string]*CoverageData) map[string]map[string][]string {
+	implementations := make(map[string]map[string][]string)
+	for image, data := range coverage {
+		dispatchers := map[string]bool{}
+		for fn := range data.TotalFunctions {
+			if logical, ok := strings.CutSuffix(fn, ifuncResolverSuffix); ok && logical != "" {
+				dispatchers[logical] = true
+			}
+		}
+		if len(dispatchers) == 0 {
+			continue
+		}
+		members := map[string][]string{} // logical function -> resolver and variants
+		for fn := range data.TotalFunctions {
+			if logical, ok := strings.CutSuffix(fn, ifuncResolverSuffix); ok && dispatchers[logical] {
+				members[logical] = append(members[logical], fn)
+			} else if logical, ok := ifuncVariantOf(fn, dispatchers); ok {
+				members[logical] = append(members[logical], fn)
+			}
+		}
+		for logical, fns := range members {
+			resolverCalled, variantsTraced := false, false
+			ran := []string{}
+			variantCalls, resolverCalls := uint64(0), uint64(0)
+			for _, fn := range fns {
+				_, called := data.CalledFunctions[fn]
+				if strings.HasSuffix(fn, ifuncResolverSuffix) {
+					resolverCalled = called
+					resolverCalls += data.Calls[fn]
+				} else {
+					variantsTraced = true
+					variantCalls += data.Calls[fn]
+					if called {
+						ran = append(ran, fn)
+					}
+				}
+				delete(data.TotalFunctions, fn)
+				delete(data.CalledFunctions, fn)
+				delete(data.Calls, fn)
+			}
+			data.TotalFunctions[logical] = struct{}{}
+			if len(ran) > 0 || (!variantsTraced && resolverCalled) {
+				data.CalledFunctions[logical] = struct{}{}
+			}
+			// The logical function counts the calls of its variants, like its status.
+			if variantsTraced {
+				data.addCalls(logical, variantCalls)
+			} else {
+				data.addCalls(logical, resolverCalls)
+			}
+			if len(ran) > 0 {
+				sort.Strings(ran)
+				if implementations[image] == nil {
+					implementations[image] = make(map[string][]string)
+				}
+				implementations[image][logical] = ran
+			}
+		}
+	}
+	return implementations
+}
+
+// printIFuncImplementations lists which IFUNC variant ran for each function.
+func printIFuncImplementations(implementations map[string]map[string][]string) {
+	fmt.Println("\n============ IFUNC Implementations ===============")
+	images := make([]string, 0, len(implementations))
+	for image := range implementations {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	for _, image := range images {
+		fmt.Printf("  %s:\n", filepath.Base(image))
+		logicals := make([]string, 0, len(implementations[image]))
+		for logical := range implementations[image] {
+			logicals = append(logicals, logical)
+		}
+		sort.Strings(logicals)
+		for _, logical := range logicals {
+			fmt.Printf("    - %s: %s\n", logical, strings.Join(implementations[image][logical], ", "))
+		}
+	}
+	fmt.Println("==================================================")
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// --- Image Filter ---
+
+// ImagePattern selects images by path: a glob (libssl*, /usr/sbin/*) matched
+// against the path and the base name, or a regular expression prefixed with
+// "re:" matched anywhere in the path. A leading "!" excludes what it matches.
+type ImagePattern struct {
+	Source string
+	Negate bool
+	glob   string
+	re     *regexp.Regexp
+}
+
+func parseImagePattern(s string) (ImagePattern, error) {
+	p := ImagePattern{Source: s}
+	if rest, ok := strings.CutPrefix(s, "!"); ok {
+		p.Negate, s = true, rest
+	}
+	if expr, ok := strings.CutPrefix(s, "re:"); ok {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return ImagePattern{}, fmt.Errorf("invalid image regex %q: %w", expr, err)
+		}
+		p.re = re
+		return p, nil
+	}
+	if s == "" {
+		return ImagePattern{}, fmt.Errorf("empty image pattern")
+	}
+	if _, err := filepath.Match(s, ""); err != nil {
+		return ImagePattern{}, fmt.Errorf("invalid image glob %q: %w", s, err)
+	}
+	p.glob = s
+	return p, nil
+}
+
+func (p ImagePattern) matches(image string) bool {
+	if p.re != nil {
+		return p.re.MatchString(image)
+	}
+	if ok, _ := filepath.Match(p.glob, image); ok {
+		return true
+	}
+	ok, _ := filepath.Match(p.glob, filepath.Base(image))
+	return ok
+}
+
+// ImageFilter restricts a report to the images matching any of its patterns,
+// all images when none includes, minus those matching an excluding pattern.
+type ImageFilter []ImagePattern
+
+// match reports whether the filter keeps image.
+func (f ImageFilter) match(image string) bool {
+	included, includes := false, false
+	for _, p := range f {
+		switch {
+		case p.Negate && p.matches(image):
+			return false
+		case !p.Negate:
+			includes = true
+			included = included || p.matches(image)
+		}
+	}
+	return included || !includes
+}
+
+// String and Set collect repeated --image flags.
+func (f *ImageFilter) String() string {
+	sources := make([]string, len(*f))
+	for i, p := range *f {
+		sources[i] = p.Source
+	}
+	return strings.Join(sources, ",")
+}
+
+func (f *ImageFilter) Set(s string) error {
+	p, err := parseImagePattern(s)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, p)
+	return nil
+}
@@ -0,0 +1,718 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Foreign Coverage Import ---
+
+// importFormats are the foreign coverage formats funkoverage import reads.
+var importFormats = []string{"drcov", "gcov", "lcov", "sancov"}
+
+// importMarker is the header field naming the format a log was imported from.
+var importMarker = []byte("] [Import:")
+
+// ImportOptions tells funkoverage import how to read its inputs.
+type ImportOptions struct {
+	Format string
+	// Image names the binary lcov and gcov data describe.
+	Image string
+	// Binary is the ELF binary sancov offsets are resolved against.
+	Binary string
+}
+
+// importedFunction is a function of an imported image. Addr is its
+// image-relative start address, 0 when the format does not tell.
+type importedFunction struct {
+	Name string
+	Addr uint64
+}
+
+// importedImage holds the functions of one image read from a foreign format
+// and the calls of those that ran. Formats recording which code ran rather
+// than how often (drcov, sancov) leave the count at 0.
+type importedImage struct {
+	functions map[importedFunction]struct{}
+	called    map[importedFunction]uint64
+}
+
+func newImportedImage() *importedImage {
+	return &importedImage{functions: make(map[importedFunction]struct{}), called: make(map[importedFunction]uint64)}
+}
+
+func (img *importedImage) add(fn importedFunction) {
+	img.functions[fn] = struct{}{}
+}
+
+func (img *importedImage) hit(fn importedFunction, calls uint64) {
+	img.functions[fn] = struct{}{}
+	img.called[fn] += calls
+}
+
+// importedCoverage maps image paths to their imported functions.
+type importedCoverage map[string]*importedImage
+
+func (c importedCoverage) image(path string) *importedImage {
+	img, ok := c[path]
+	if !ok {
+		img = newImportedImage()
+		c[path] = img
+	}
+	return img
+}
+
+// readLcov reads an lcov tracefile: the functions of its FN records (FNL/FNA
+// in lcov 2) with the call counts of the FNDA records.
+func readLcov(r io.Reader, img *importedImage) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxLogLineSize)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "FN":
+			// FN:<line>,<name> or, since lcov 2, FN:<line>,<end line>,<name>
+			fields := strings.SplitN(value, ",", 3)
+			if len(fields) < 2 {
+				return fmt.Errorf("invalid lcov record %q", scanner.Text())
+			}
+			name := value[len(fields[0])+1:]
+			if len(fields) == 3 {
+				if _, err := strconv.Atoi(fields[1]); err == nil {
+					name = fields[2]
+				}
+			}
+			img.add(importedFunction{Name: name})
+		case "FNDA", "FNA":
+			// FNDA:<count>,<name> and FNA:<index>,<count>,<name>
+			if key == "FNA" {
+				_, value, _ = strings.Cut(value, ",")
+			}
+			count, name, ok := strings.Cut(value, ",")
+			n, err := strconv.ParseUint(count, 10, 64)
+			if !ok || err != nil {
+				return fmt.Errorf("invalid lcov record %q", scanner.Text())
+			}
+			fn := importedFunction{Name: name}
+			if n > 0 {
+				img.hit(fn, n)
+			} else {
+				img.add(fn)
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// gcovJSON is the part of the gcov JSON intermediate format (gcov
+// --json-format) import reads.
+type gcovJSON struct {
+	Files []struct {
+		Functions []struct {
+			Name           string `json:"name"`
+			ExecutionCount uint64 `json:"execution_count"`
+		} `json:"functions"`
+	} `json:"files"`
+}
+
+// readGcovJSON reads a gcov JSON file.
+func readGcovJSON(r io.Reader, img *importedImage) error {
+	var data gcovJSON
+	if err := json.NewDecoder(r).Decode(&data); err != nil {
+		return fmt.Errorf("invalid gcov JSON: %w", err)
+	}
+	for _, file := range data.Files {
+		for _, f := range file.Functions {
+			if f.ExecutionCount > 0 {
+				img.hit(importedFunction{Name: f.Name}, f.ExecutionCount)
+			} else {
+				img.add(importedFunction{Name: f.Name})
+			}
+		}
+	}
+	return nil
+}
+
+// readGcovText reads the "function NAME called N returned ..." summaries of
+// a .gcov file written with gcov -b.
+func readGcovText(r io.Reader, img *importedImage) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxLogLineSize)
+	for scanner.Scan() {
+		rest, ok := strings.CutPrefix(scanner.Text(), "function ")
+		if !ok {
+			continue
+		}
+		name, count, ok := strings.Cut(rest, " called ")
+		if !ok {
+			continue
+		}
+		count, _, _ = strings.Cut(count, " ")
+		n, err := strconv.ParseUint(count, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid gcov function summary %q", scanner.Text())
+		}
+		if n > 0 {
+			img.hit(importedFunction{Name: name}, n)
+		} else {
+			img.add(importedFunction{Name: name})
+		}
+	}
+	return scanner.Err()
+}
+
+// readGcov reads a .gcov, .gcov.json or .gcov.json.gz file, or all of them
+// within a directory.
+func readGcov(path string, img *importedImage) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, e := range entries {
+			name := e.Name()
+			if !e.IsDir() && (strings.HasSuffix(name, ".gcov") || strings.HasSuffix(name, ".gcov.json") || strings.HasSuffix(name, ".gcov.json.gz")) {
+				if err := readGcov(filepath.Join(path, name), img); err != nil {
+					return err
+				}
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("no .gcov or .gcov.json(.gz) files in %s", path)
+		}
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	if strings.HasSuffix(strings.TrimSuffix(path, ".gz"), ".json") {
+		err = readGcovJSON(r, img)
+	} else {
+		err = readGcovText(r, img)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// drcovModule is an entry of the module table of a drcov file.
+type drcovModule struct {
+	ID, Containing int
+	Start          uint64
+	Path           string
+}
+
+// drcovBlock is a basic block that ran, as an offset within its module.
+type drcovBlock struct {
+	Offset uint64
+	Module int
+}
+
+// readDrcov reads a DynamoRIO drcov file: its module table and the basic
+// blocks of its BB table, binary or dumped as text (-dump_text).
+func readDrcov(r io.Reader) ([]drcovModule, []drcovBlock, error) {
+	br := bufio.NewReader(r)
+	line := func() (string, error) {
+		s, err := br.ReadString('\n')
+		if err != nil && (err != io.EOF || s == "") {
+			return "", fmt.Errorf("truncated drcov file: %w", err)
+		}
+		return strings.TrimRight(s, "\r\n"), nil
+	}
+	first, err := line()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !strings.HasPrefix(first, "DRCOV VERSION:") {
+		return nil, nil, fmt.Errorf("not a drcov file")
+	}
+	var s string
+	for !strings.HasPrefix(s, "Module Table:") {
+		if s, err = line(); err != nil {
+			return nil, nil, err
+		}
+	}
+	// "Module Table: <count>" (version 1) or "Module Table: version N, count <count>"
+	count, err := strconv.Atoi(strings.TrimSpace(s[strings.LastIndexAny(s, ": ")+1:]))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid drcov module table header %q", s)
+	}
+	columns := []string{"id", "size", "path"}
+	if s, err = line(); err != nil {
+		return nil, nil, err
+	}
+	if rest, ok := strings.CutPrefix(s, "Columns:"); ok {
+		columns = strings.Split(rest, ",")
+		for i := range columns {
+			columns[i] = strings.TrimSpace(columns[i])
+		}
+		if s, err = line(); err != nil {
+			return nil, nil, err
+		}
+	}
+	modules := make([]drcovModule, 0, count)
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			if s, err = line(); err != nil {
+				return nil, nil, err
+			}
+		}
+		// The path is the last column and may contain commas.
+		fields := strings.SplitN(s, ",", len(columns))
+		if len(fields) != len(columns) {
+			return nil, nil, fmt.Errorf("invalid drcov module %q", s)
+		}
+		m := drcovModule{Containing: -1}
+		for j, col := range columns {
+			v := strings.TrimSpace(fields[j])
+			switch col {
+			case "id":
+				m.ID, err = strconv.Atoi(v)
+			case "containing_id":
+				m.Containing, err = strconv.Atoi(v)
+			case "base", "start":
+				m.Start, err = strconv.ParseUint(strings.TrimPrefix(v, "0x"), 16, 64)
+			case "path":
+				m.Path = v
+			}
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid drcov module %q", s)
+			}
+		}
+		modules = append(modules, m)
+	}
+	if s, err = line(); err != nil {
+		return nil, nil, err
+	}
+	header, ok := strings.CutPrefix(s, "BB Table:")
+	if !ok {
+		return nil, nil, fmt.Errorf("missing drcov BB table, got %q", s)
+	}
+	bbs, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(header), " bbs"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid drcov BB table header %q", s)
+	}
+	blocks := make([]drcovBlock, 0, bbs)
+	if peek, _ := br.Peek(7); string(peek) == "module " || string(peek) == "module[" {
+		// module[  0]: 0x0000000000001c40,   4
+		for i := 0; i < bbs; i++ {
+			if s, err = line(); err != nil {
+				return nil, nil, err
+			}
+			if strings.HasPrefix(s, "module id") {
+				i--
+				continue
+			}
+			id, rest, ok := strings.Cut(strings.TrimPrefix(s, "module["), "]:")
+			start, _, _ := strings.Cut(rest, ",")
+			m, err1 := strconv.Atoi(strings.TrimSpace(id))
+			off, err2 := strconv.ParseUint(strings.TrimPrefix(strings.TrimSpace(start), "0x"), 16, 64)
+			if !ok || err1 != nil || err2 != nil {
+				return nil, nil, fmt.Errorf("invalid drcov basic block %q", s)
+			}
+			blocks = append(blocks, drcovBlock{Offset: off, Module: m})
+		}
+		return modules, blocks, nil
+	}
+	// struct { uint32 start; uint16 size; uint16 id; }
+	var entry [8]byte
+	for i := 0; i < bbs; i++ {
+		if _, err := io.ReadFull(br, entry[:]); err != nil {
+			return nil, nil, fmt.Errorf("truncated drcov BB table: %w", err)
+		}
+		blocks = append(blocks, drcovBlock{
+			Offset: uint64(binary.LittleEndian.Uint32(entry[0:4])),
+			Module: int(binary.LittleEndian.Uint16(entry[6:8])),
+		})
+	}
+	return modules, blocks, nil
+}
+
+// drcovImageBlocks resolves the blocks of a drcov file to offsets within
+// their image. Since drcov version 3 an image is split into one module per
+// segment, whose blocks are relative to the segment.
+func drcovImageBlocks(modules []drcovModule, blocks []drcovBlock) (map[string][]uint64, error) {
+	byID := make(map[int]drcovModule, len(modules))
+	for _, m := range modules {
+		byID[m.ID] = m
+	}
+	offsets := make(map[string][]uint64)
+	for _, b := range blocks {
+		m, ok := byID[b.Module]
+		if !ok {
+			return nil, fmt.Errorf("drcov basic block of unknown module %d", b.Module)
+		}
+		off := b.Offset
+		if c, ok := byID[m.Containing]; ok && m.Containing != m.ID {
+			off += m.Start - c.Start
+		}
+		offsets[m.Path] = append(offsets[m.Path], off)
+	}
+	return offsets, nil
+}
+
+// sancov magics, for 64-bit and 32-bit offsets.
+const (
+	sancovMagic64 = 0xC0BFFFFFFFFFFF64
+	sancovMagic32 = 0xC0BFFFFFFFFFFF32
+)
+
+// readSancov reads the module offsets of a SanitizerCoverage .sancov file.
+func readSancov(r io.Reader) ([]uint64, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8 {
+		return nil, fmt.Errorf("not a sancov file")
+	}
+	width := 0
+	switch binary.LittleEndian.Uint64(data) {
+	case sancovMagic64:
+		width = 8
+	case sancovMagic32:
+		width = 4
+	default:
+		return nil, fmt.Errorf("not a sancov file")
+	}
+	data = data[8:]
+	if len(data)%width != 0 {
+		return nil, fmt.Errorf("truncated sancov file")
+	}
+	pcs := make([]uint64, 0, len(data)/width)
+	for ; len(data) > 0; data = data[width:] {
+		if width == 8 {
+			pcs = append(pcs, binary.LittleEndian.Uint64(data))
+		} else {
+			pcs = append(pcs, uint64(binary.LittleEndian.Uint32(data)))
+		}
+	}
+	return pcs, nil
+}
+
+// imageSymbols are the functions of an ELF image, by address, to resolve the
+// module offsets of drcov and sancov.
+type imageSymbols struct {
+	// base is the address the image is mapped from, so an offset is at
+	// base+offset in its symbol table.
+	base uint64
+	syms []elf.Symbol
+	// names maps every function name, aliases included, to its address.
+	names map[string]uint64
+}
+
+// loadImageSymbols reads the relevant functions of image, falling back to
+// its exported ones when it is stripped.
+func loadImageSymbols(image string) (*imageSymbols, error) {
+	f, err := elf.Open(image)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s := &imageSymbols{base: ^uint64(0), names: make(map[string]uint64)}
+	for _, p := range f.Progs {
+		if p.Type == elf.PT_LOAD && p.Vaddr&^(p.Align-1) < s.base {
+			s.base = p.Vaddr &^ (p.Align - 1)
+		}
+	}
+	if s.base == ^uint64(0) {
+		s.base = 0
+	}
+	syms, err := f.Symbols()
+	if isStripped(f) || err != nil {
+		syms, _ = f.DynamicSymbols()
+	}
+	for _, sym := range syms {
+		t := elf.ST_TYPE(sym.Info)
+		if (t == elf.STT_FUNC || t == elf.STT_GNU_IFUNC) && sym.Section != elf.SHN_UNDEF && funcIsRelevant(sym.Name) {
+			s.syms = append(s.syms, sym)
+			s.names[sym.Name] = sym.Value
+		}
+	}
+	// Aliases share an address: keep one name per function, the sized one.
+	sort.Slice(s.syms, func(i, j int) bool {
+		a, b := s.syms[i], s.syms[j]
+		if a.Value != b.Value {
+			return a.Value < b.Value
+		}
+		if (a.Size > 0) != (b.Size > 0) {
+			return a.Size > 0
+		}
+		return a.Name < b.Name
+	})
+	unique := s.syms[:0]
+	for _, sym := range s.syms {
+		if len(unique) == 0 || unique[len(unique)-1].Value != sym.Value {
+			unique = append(unique, sym)
+		}
+	}
+	s.syms = unique
+	return s, nil
+}
+
+func (s *imageSymbols) function(sym elf.Symbol) importedFunction {
+	return importedFunction{Name: sym.Name, Addr: sym.Value - s.base}
+}
+
+// addAll records every function of the image as instrumented.
+func (s *imageSymbols) addAll(img *importedImage) {
+	for _, sym := range s.syms {
+		img.add(s.function(sym))
+	}
+}
+
+// at returns the function containing the image offset off.
+func (s *imageSymbols) at(off uint64) (importedFunction, bool) {
+	addr := s.base + off
+	i := sort.Search(len(s.syms), func(i int) bool { return s.syms[i].Value > addr }) - 1
+	if i < 0 || addr >= s.syms[i].Value+max(s.syms[i].Size, 1) {
+		return importedFunction{}, false
+	}
+	return s.function(s.syms[i]), true
+}
+
+// named returns the function called name, or the one it is an alias of.
+func (s *imageSymbols) named(name string) (importedFunction, bool) {
+	addr, ok := s.names[name]
+	if !ok {
+		return importedFunction{}, false
+	}
+	return s.at(addr - s.base)
+}
+
+// resolveOffsets records the functions of image, marking those containing
+// one of the offsets as called.
+func resolveOffsets(coverage importedCoverage, image string, offsets []uint64) error {
+	syms, err := loadImageSymbols(image)
+	if err != nil {
+		return err
+	}
+	img := coverage.image(image)
+	syms.addAll(img)
+	for _, off := range offsets {
+		if fn, ok := syms.at(off); ok {
+			img.hit(fn, 0)
+		}
+	}
+	return nil
+}
+
+// importCoverage reads one input in the given foreign format. It also
+// returns the traced binary, which the imported log is named after.
+func importCoverage(input string, opts ImportOptions) (importedCoverage, string, error) {
+	coverage := make(importedCoverage)
+	binary := opts.Image
+	switch opts.Format {
+	case "lcov", "gcov":
+		if opts.Image == "" {
+			return nil, "", fmt.Errorf("%s data does not name the traced binary: pass --image", opts.Format)
+		}
+		img := coverage.image(opts.Image)
+		if opts.Format == "gcov" {
+			return coverage, binary, readGcov(input, img)
+		}
+		f, err := os.Open(input)
+		if err != nil {
+			return nil, "", err
+		}
+		defer f.Close()
+		if err := readLcov(f, img); err != nil {
+			return nil, "", fmt.Errorf("%s: %w", input, err)
+		}
+	case "drcov":
+		f, err := os.Open(input)
+		if err != nil {
+			return nil, "", err
+		}
+		defer f.Close()
+		modules, blocks, err := readDrcov(f)
+		if err != nil {
+			return nil, "", fmt.Errorf("%s: %w", input, err)
+		}
+		if binary == "" && len(modules) > 0 {
+			binary = modules[0].Path
+		}
+		offsets, err := drcovImageBlocks(modules, blocks)
+		if err != nil {
+			return nil, "", fmt.Errorf("%s: %w", input, err)
+		}
+		for _, image := range sortedKeys(offsets) {
+			if err := resolveOffsets(coverage, image, offsets[image]); err != nil {
+				fmt.Printf("Warning: skipping module %s of %s: %v\n", image, input, err)
+			}
+		}
+	case "sancov":
+		if opts.Binary == "" {
+			return nil, "", fmt.Errorf("sancov offsets need the binary they belong to: pass --binary")
+		}
+		f, err := os.Open(input)
+		if err != nil {
+			return nil, "", err
+		}
+		defer f.Close()
+		if binary == "" {
+			binary = opts.Binary
+		}
+		pcs, err := readSancov(f)
+		if err != nil {
+			return nil, "", fmt.Errorf("%s: %w", input, err)
+		}
+		if err := resolveOffsets(coverage, opts.Binary, pcs); err != nil {
+			return nil, "", fmt.Errorf("%s: %w", opts.Binary, err)
+		}
+	default:
+		return nil, "", fmt.Errorf("unknown format %q, expected one of %s", opts.Format, strings.Join(importFormats, ", "))
+	}
+	return coverage, binary, nil
+}
+
+// escapeField mirrors escape_field of FuncTracer.hpp.
+func escapeField(s string) string {
+	if !strings.ContainsAny(s, "[]\\\n\r") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '[', ']', '\\', '\n', '\r':
+			fmt.Fprintf(&b, "\\x%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// importedLog formats coverage as a FuncTracer log of the given format. Its
+// run ID is derived from the content, so importing the same data twice yields
+// duplicates the reports skip.
+func importedLog(coverage importedCoverage, format string) []byte {
+	body := importedLogBody(coverage)
+	sum := sha256.Sum256(body)
+	header := fmt.Sprintf("%s%d] [Run:import-%s] [Import:%s]\n", formatMarker, supportedLogFormat, hex.EncodeToString(sum[:8]), format)
+	return append([]byte(header), body...)
+}
+
+// importedLogBody formats the Function and Called lines of coverage.
+func importedLogBody(coverage importedCoverage) []byte {
+	var body bytes.Buffer
+	entry := func(image string, fn importedFunction, field string) {
+		fmt.Fprintf(&body, "[Image:%s] ", escapeField(image))
+		if fn.Addr != 0 {
+			fmt.Fprintf(&body, "[Addr:0x%x] ", fn.Addr)
+		}
+		body.WriteString(field)
+		fmt.Fprintf(&body, "%s]\n", escapeField(fn.Name))
+	}
+	for _, image := range sortedKeys(coverage) {
+		img := coverage[image]
+		functions := make([]importedFunction, 0, len(img.functions))
+		for fn := range img.functions {
+			functions = append(functions, fn)
+		}
+		sort.Slice(functions, func(i, j int) bool {
+			if functions[i].Name != functions[j].Name {
+				return functions[i].Name < functions[j].Name
+			}
+			return functions[i].Addr < functions[j].Addr
+		})
+		for _, fn := range functions {
+			entry(image, fn, "[Function:")
+		}
+		for _, fn := range functions {
+			if calls, ok := img.called[fn]; ok {
+				field := "[Called:"
+				if calls > 0 {
+					field = "[Count:" + strconv.FormatUint(calls, 10) + "] " + field
+				}
+				entry(image, fn, field)
+			}
+		}
+	}
+	return body.Bytes()
+}
+
+// writeImportedLog writes a log into outputDir named like the wrapper's logs
+// after binary and the time the input was written.
+func writeImportedLog(outputDir, binary string, modTime time.Time, log []byte) (string, error) {
+	for ns := modTime.Nanosecond(); ; ns++ {
+		name := fmt.Sprintf("%s_%s_%09d.log", filepath.Base(binary), modTime.Format("20060102-150405"), ns)
+		path := filepath.Join(outputDir, name)
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if _, err := f.Write(log); err != nil {
+			f.Close()
+			return "", err
+		}
+		return path, f.Close()
+	}
+}
+
+// importLogs converts each input into a log of outputDir, which every other
+// command then reads like the logs of wrapped binaries. It returns the logs
+// written.
+func importLogs(inputs []string, outputDir string, opts ImportOptions) ([]string, error) {
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return nil, fmt.Errorf("could not create output directory %s: %w", outputDir, err)
+	}
+	var logs []string
+	for _, input := range inputs {
+		info, err := os.Stat(input)
+		if err != nil {
+			return logs, err
+		}
+		coverage, binary, err := importCoverage(input, opts)
+		if err != nil {
+			return logs, err
+		}
+		if len(coverage) == 0 {
+			return logs, fmt.Errorf("%s: no coverage data", input)
+		}
+		path, err := writeImportedLog(outputDir, binary, info.ModTime(), importedLog(coverage, opts.Format))
+		if err != nil {
+			return logs, err
+		}
+		logs = append(logs, path)
+	}
+	return logs, nil
+}
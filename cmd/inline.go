@@ -0,0 +1,156 @@
+package main
+
+import (
+	"debug/dwarf"
+	"slices"
+
+	"github.com/ianlancetaylor/demangle"
+)
+
+// --- Inlined Functions ---
+
+// Inlined copies of a function run without a call FuncTracer could see: the
+// functions inlined everywhere have no symbol at all, and the out-of-line copy
+// of the others may never be called while their inlined copies run. report
+// --inlined reads the inlined instances of the DWARF debug info and counts a
+// function as covered via inlining when a function it is inlined into was
+// called.
+
+// inlinedFunctions maps the functions the debug info of image has inlined
+// copies of to the functions holding the copies, by their demangled names.
+func inlinedFunctions(image string) (map[string][]string, error) {
+	d, err := imageDWARF(image)
+	if err != nil {
+		return nil, err
+	}
+	type subprogram struct {
+		name string
+		ref  dwarf.Offset
+	}
+	type frame struct {
+		tag dwarf.Tag
+		off dwarf.Offset
+	}
+	subprograms := make(map[dwarf.Offset]*subprogram)
+	// sites pairs the origin of each inlined copy with the function holding it.
+	var sites [][2]dwarf.Offset
+	// stack holds the entries whose children are being read.
+	var stack []frame
+	r := d.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return nil, err
+		}
+		if e == nil {
+			break
+		}
+		switch e.Tag {
+		case 0:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			continue
+		case dwarf.TagSubprogram:
+			s := &subprogram{}
+			if name, ok := e.Val(dwarf.AttrLinkageName).(string); ok {
+				s.name = demangle.Filter(name)
+			} else if name, ok := e.Val(dwarf.AttrName).(string); ok {
+				s.name = name
+			}
+			if ref, ok := e.Val(dwarf.AttrSpecification).(dwarf.Offset); ok {
+				s.ref = ref
+			} else if ref, ok := e.Val(dwarf.AttrAbstractOrigin).(dwarf.Offset); ok {
+				s.ref = ref
+			}
+			subprograms[e.Offset] = s
+		case dwarf.TagInlinedSubroutine:
+			origin, ok := e.Val(dwarf.AttrAbstractOrigin).(dwarf.Offset)
+			// The copy is in the innermost function around it.
+			for i := len(stack) - 1; ok && i >= 0; i-- {
+				if stack[i].tag == dwarf.TagSubprogram {
+					sites = append(sites, [2]dwarf.Offset{origin, stack[i].off})
+					break
+				}
+			}
+		}
+		if e.Children {
+			stack = append(stack, frame{e.Tag, e.Offset})
+		}
+	}
+	// Concrete instances and out-of-line definitions take their name from
+	// the declaration they refer to.
+	name := func(off dwarf.Offset) string {
+		for depth := 0; depth < 4; depth++ {
+			s, ok := subprograms[off]
+			if !ok {
+				return ""
+			}
+			if s.name != "" {
+				return s.name
+			}
+			off = s.ref
+		}
+		return ""
+	}
+	parents := make(map[string][]string)
+	for _, site := range sites {
+		fn, parent := name(site[0]), name(site[1])
+		if fn == "" || parent == "" || fn == parent || slices.Contains(parents[fn], parent) {
+			continue
+		}
+		parents[fn] = append(parents[fn], parent)
+	}
+	for _, p := range parents {
+		slices.Sort(p)
+	}
+	return parents, nil
+}
+
+// markInlinedFunctions counts the functions of each image on disk that were
+// inlined into called functions of the image, directly or through other
+// inlined functions, as called, and records the called functions they were
+// inlined into in Inlined. Functions only ever inlined are added to the
+// functions of their image; those the presets leave out are not. Images
+// without debug info are left as they are.
+func markInlinedFunctions(coverage map[string]*CoverageData, presets []FunctionPreset) {
+	for image, data := range coverage {
+		if _, arch := splitArchImage(image); arch != "" {
+			continue
+		}
+		parents, err := inlinedFunctions(image)
+		if err != nil {
+			continue
+		}
+		for fn := range parents {
+			if slices.ContainsFunc(presets, func(p FunctionPreset) bool { return p.excludes(fn) }) {
+				delete(parents, fn)
+			}
+		}
+		listed := func(fn string) bool { _, ok := data.TotalFunctions[fn]; return ok }
+		called := func(fn string) bool { _, ok := data.CalledFunctions[fn]; return ok }
+		// An inlined copy counts when it is in a function of the image, or in
+		// one inlined into such a function.
+		var covered []string
+		for changed := true; changed; {
+			changed = false
+			for fn, holders := range parents {
+				if !listed(fn) && slices.ContainsFunc(holders, listed) {
+					data.TotalFunctions[fn] = struct{}{}
+					changed = true
+				}
+				if !called(fn) && slices.ContainsFunc(holders, called) {
+					data.CalledFunctions[fn] = struct{}{}
+					covered = append(covered, fn)
+					changed = true
+				}
+			}
+		}
+		for _, fn := range covered {
+			if data.Inlined == nil {
+				data.Inlined = make(map[string][]string)
+			}
+			data.Inlined[fn] = slices.DeleteFunc(slices.Clone(parents[fn]), func(h string) bool { return !called(h) })
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// --- Report Inputs ---
+
+// isLogName reports whether a file name is the one of a log: a .log, or a
+// .log.gz segment rotated out by stream-collector.
+func isLogName(name string) bool {
+	return strings.HasSuffix(name, ".log") || strings.HasSuffix(name, ".log.gz")
+}
+
+// gzipLog reads a compressed log, closing its file with it.
+type gzipLog struct {
+	*gzip.Reader
+	f *os.File
+}
+
+func (g gzipLog) Close() error {
+	g.Reader.Close()
+	return g.f.Close()
+}
+
+// openLog opens a log for reading, decompressing .gz logs.
+func openLog(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil || !strings.HasSuffix(path, ".gz") {
+		return f, err
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return gzipLog{gz, f}, nil
+}
+
+// isGlobPattern reports whether an input holds glob metacharacters.
+func isGlobPattern(input string) bool {
+	return strings.ContainsAny(input, "*?[")
+}
+
+// globBase returns the directory of a pattern before its first element with
+// metacharacters: "logs/host-*/**/*.log" gives "logs".
+func globBase(pattern string) string {
+	parts := strings.Split(filepath.ToSlash(pattern), "/")
+	for i, part := range parts {
+		if isGlobPattern(part) {
+			base := strings.Join(parts[:i], "/")
+			if base == "" && strings.HasPrefix(pattern, "/") {
+				return "/"
+			}
+			if base == "" {
+				return "."
+			}
+			return filepath.FromSlash(base)
+		}
+	}
+	return filepath.Clean(pattern)
+}
+
+// matchGlob matches the elements of a path against those of a pattern,
+// where a "**" element matches any number of directories.
+func matchGlob(pattern, path []string) bool {
+	if len(pattern) == 0 {
+		return len(path) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(path); i++ {
+			if matchGlob(pattern[1:], path[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(path) == 0 {
+		return false
+	}
+	if ok, _ := filepath.Match(pattern[0], path[0]); !ok {
+		return false
+	}
+	return matchGlob(pattern[1:], path[1:])
+}
+
+// walkLogs returns the logs below dir, in lexical order. Hidden
+// directories, such as the staging area of collect, are skipped.
+func walkLogs(dir string) ([]string, error) {
+	var logs []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != dir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isLogName(d.Name()) {
+			logs = append(logs, path)
+		}
+		return nil
+	})
+	return logs, err
+}
+
+// globLogs returns the files matching pattern, and the .log files below the
+// directories it matches.
+func globLogs(pattern string) ([]string, error) {
+	base := globBase(pattern)
+	rel, err := filepath.Rel(base, pattern)
+	if err != nil {
+		return nil, err
+	}
+	elements := strings.Split(filepath.ToSlash(rel), "/")
+	var logs []string
+	err = filepath.WalkDir(base, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == base {
+			return err
+		}
+		name, _ := filepath.Rel(base, path)
+		if !matchGlob(elements, strings.Split(filepath.ToSlash(name), "/")) {
+			if d.IsDir() && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			logs = append(logs, path)
+			return nil
+		}
+		found, err := walkLogs(path)
+		logs = append(logs, found...)
+		if err != nil {
+			return err
+		}
+		return filepath.SkipDir
+	})
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return logs, err
+}
+
+// collectLogFiles expands the report inputs into a list of log files. A
+// directory yields all the .log files below it, a glob pattern the files it
+// matches, "**" standing for any number of directories, and anything else is
+// treated as a comma-separated list of files. A log given twice is read once.
+func collectLogFiles(inputs ...string) ([]string, error) {
+	seen := make(map[string]bool)
+	logFiles := []string{}
+	for _, input := range inputs {
+		var found []string
+		info, err := os.Stat(input)
+		switch {
+		case err == nil && info.IsDir():
+			if found, err = walkLogs(input); err != nil {
+				return nil, fmt.Errorf("failed to read directory %s: %w", input, err)
+			}
+			if len(found) == 0 {
+				return nil, fmt.Errorf("no .log files found in directory %s", input)
+			}
+		case err != nil && isGlobPattern(input):
+			if found, err = globLogs(input); err != nil {
+				return nil, fmt.Errorf("failed to expand %s: %w", input, err)
+			}
+			if len(found) == 0 {
+				return nil, fmt.Errorf("no log files match %s", input)
+			}
+		default:
+			found = strings.Split(input, ",")
+		}
+		for _, f := range found {
+			if !seen[f] {
+				seen[f] = true
+				logFiles = append(logFiles, f)
+			}
+		}
+	}
+	return logFiles, nil
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// --- JSON-Lines Logs ---
+
+// parseJSONEvent parses a line of a log written with FuncTracer -json (or
+// FUNKOVERAGE_JSON=1) into ev, reporting whether it is an object with an
+// event, e.g.
+//
+//	{"event":"called","image":"/bin/ls","addr":"0x4f10","count":3,"function":"main"}
+//
+// The members are named like the fields of proto/funkoverage_log.proto.
+// Members of other names or types are skipped, so later tools may add more.
+func parseJSONEvent(line []byte, ev *logEvent) bool {
+	*ev = logEvent{}
+	s := &jsonScanner{b: line}
+	if !s.consume('{') {
+		return false
+	}
+	if s.consume('}') {
+		return false
+	}
+	for {
+		key, ok := s.string()
+		if !ok || !s.consume(':') {
+			return false
+		}
+		var dst *[]byte
+		var num *uint64
+		var list *[]string
+		switch string(key) {
+		case "event":
+			dst = &ev.Event
+		case "image":
+			dst = &ev.Image
+		case "function":
+			dst = &ev.Function
+		case "addr":
+			dst = &ev.Addr
+		case "caller":
+			dst = &ev.Caller
+		case "callee":
+			dst = &ev.Callee
+		case "format":
+			num = &ev.Format
+		case "tool":
+			dst = &ev.Tool
+		case "run":
+			dst = &ev.Run
+		case "sample":
+			dst = &ev.Sample
+		case "session":
+			dst = &ev.Session
+		case "options":
+			dst = &ev.Options
+		case "import":
+			dst = &ev.Import
+		case "tags":
+			list = &ev.Tags
+		case "time":
+			dst = &ev.Time
+		case "host":
+			dst = &ev.Host
+		case "cwd":
+			dst = &ev.Cwd
+		case "args":
+			list = &ev.Args
+		case "env":
+			list = &ev.Env
+		case "build_id":
+			dst = &ev.BuildID
+		case "arch":
+			dst = &ev.Arch
+		case "loader":
+			dst = &ev.Loader
+		case "count":
+			num = &ev.Count
+		case "pid":
+			num = &ev.Pid
+		case "tid":
+			num = &ev.Tid
+		case "time_ns":
+			num = &ev.TimeNs
+		case "code":
+			num = &ev.Code
+		case "signal":
+			num = &ev.Signal
+		case "wall_ms":
+			num = &ev.WallMs
+		case "started":
+			num = &ev.Started
+		}
+		switch {
+		case dst != nil && s.peek() == '"':
+			*dst, ok = s.string()
+		case num != nil && s.peek() >= '0' && s.peek() <= '9':
+			*num, ok = s.number()
+		case list != nil && s.peek() == '[':
+			*list, ok = s.strings()
+		default:
+			ok = s.skip()
+		}
+		if !ok {
+			return false
+		}
+		if s.consume(',') {
+			continue
+		}
+		return s.consume('}') && s.end() && len(ev.Event) > 0
+	}
+}
+
+// jsonScanner reads the flat objects of JSON-lines logs without allocating
+// for the strings free of escapes.
+type jsonScanner struct {
+	b []byte
+	i int
+}
+
+func (s *jsonScanner) space() {
+	for s.i < len(s.b) && (s.b[s.i] == ' ' || s.b[s.i] == '\t' || s.b[s.i] == '\r' || s.b[s.i] == '\n') {
+		s.i++
+	}
+}
+
+func (s *jsonScanner) peek() byte {
+	s.space()
+	if s.i < len(s.b) {
+		return s.b[s.i]
+	}
+	return 0
+}
+
+func (s *jsonScanner) consume(c byte) bool {
+	if s.peek() != c {
+		return false
+	}
+	s.i++
+	return true
+}
+
+func (s *jsonScanner) end() bool {
+	s.space()
+	return s.i == len(s.b)
+}
+
+// string reads a string, decoding its escapes into a new slice. Bytes that
+// are not valid UTF-8 are kept, as FuncTracer writes symbols byte for byte.
+func (s *jsonScanner) string() ([]byte, bool) {
+	if !s.consume('"') {
+		return nil, false
+	}
+	start, escaped := s.i, false
+	for ; s.i < len(s.b) && s.b[s.i] != '"'; s.i++ {
+		if s.b[s.i] == '\\' {
+			escaped = true
+			s.i++
+		}
+	}
+	if s.i >= len(s.b) {
+		return nil, false
+	}
+	raw := s.b[start:s.i]
+	s.i++
+	if !escaped {
+		return raw, true
+	}
+	return unescapeJSON(raw)
+}
+
+// unescapeJSON decodes the escapes of the raw content of a JSON string.
+func unescapeJSON(raw []byte) ([]byte, bool) {
+	out := make([]byte, 0, len(raw))
+	for i := 0; i < len(raw); i++ {
+		if raw[i] != '\\' {
+			out = append(out, raw[i])
+			continue
+		}
+		if i++; i >= len(raw) {
+			return nil, false
+		}
+		switch raw[i] {
+		case '"', '\\', '/':
+			out = append(out, raw[i])
+		case 'b':
+			out = append(out, '\b')
+		case 'f':
+			out = append(out, '\f')
+		case 'n':
+			out = append(out, '\n')
+		case 'r':
+			out = append(out, '\r')
+		case 't':
+			out = append(out, '\t')
+		case 'u':
+			r, ok := hex4(raw[i+1:])
+			if !ok {
+				return nil, false
+			}
+			i += 4
+			if utf16.IsSurrogate(r) && i+6 < len(raw) && raw[i+1] == '\\' && raw[i+2] == 'u' {
+				if r2, ok := hex4(raw[i+3:]); ok {
+					r = utf16.DecodeRune(r, r2)
+					i += 6
+				}
+			}
+			out = utf8.AppendRune(out, r)
+		default:
+			return nil, false
+		}
+	}
+	return out, true
+}
+
+// hex4 decodes the four hexadecimal digits of a \u escape.
+func hex4(b []byte) (rune, bool) {
+	if len(b) < 4 {
+		return 0, false
+	}
+	var r rune
+	for _, c := range b[:4] {
+		d, ok := unhex(c)
+		if !ok {
+			return 0, false
+		}
+		r = r<<4 | rune(d)
+	}
+	return r, true
+}
+
+// number reads a non-negative integer.
+func (s *jsonScanner) number() (uint64, bool) {
+	s.space()
+	start, n := s.i, uint64(0)
+	for ; s.i < len(s.b) && s.b[s.i] >= '0' && s.b[s.i] <= '9'; s.i++ {
+		n = n*10 + uint64(s.b[s.i]-'0')
+	}
+	return n, s.i > start
+}
+
+// strings reads an array of strings.
+func (s *jsonScanner) strings() ([]string, bool) {
+	if !s.consume('[') {
+		return nil, false
+	}
+	items := []string{}
+	if s.consume(']') {
+		return items, true
+	}
+	for {
+		item, ok := s.string()
+		if !ok {
+			return nil, false
+		}
+		items = append(items, string(item))
+		if s.consume(',') {
+			continue
+		}
+		return items, s.consume(']')
+	}
+}
+
+// skip steps over a value of any type.
+func (s *jsonScanner) skip() bool {
+	switch c := s.peek(); {
+	case c == '"':
+		_, ok := s.string()
+		return ok
+	case c == '[' || c == '{':
+		depth := 0
+		for ; s.i < len(s.b); s.i++ {
+			switch s.b[s.i] {
+			case '"':
+				if _, ok := s.string(); !ok {
+					return false
+				}
+				s.i--
+			case '[', '{':
+				depth++
+			case ']', '}':
+				if depth--; depth == 0 {
+					s.i++
+					return true
+				}
+			}
+		}
+		return false
+	default:
+		start := s.i
+		for s.i < len(s.b) && !strings.ContainsRune(",}] \t", rune(s.b[s.i])) {
+			s.i++
+		}
+		return s.i > start
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// --- Sessions and Tags ---
+
+var (
+	sessionMarker = []byte("] [Session:")
+	tagsMarker    = []byte("] [Tags:")
+)
+
+// noSession names the logs written outside of any COVERAGE_SESSION.
+const noSession = "(none)"
+
+// parseLogLabels returns the session and tags a header line carries.
+func parseLogLabels(line []byte) (session string, tags []string) {
+	if v, ok := parseHeaderField(line, sessionMarker); ok {
+		session = string(unescapeField([]byte(v)))
+	}
+	if v, ok := parseHeaderField(line, tagsMarker); ok {
+		tags = splitList(string(unescapeField([]byte(v))))
+	}
+	return session, tags
+}
+
+// splitList splits a comma-separated list, dropping blank entries.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// readLogLabels returns the session and tags of a log from its header,
+// without reading the rest of it.
+func readLogLabels(logFile string) (session string, tags []string, err error) {
+	f, err := openLog(logFile)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var ev logEvent
+	if isProtoLog(r) {
+		s := &protoScanner{r: r}
+		if s.scan() && decodeProtoEvent(s.record, &ev) && string(ev.Event) == "header" {
+			return string(ev.Session), ev.Tags, nil
+		}
+		return "", nil, s.err
+	}
+	scanner := bufio.NewScanner(r)
+	// The header is the first line FuncTracer writes, after the Pin banner at most.
+	for i := 0; i < 16 && scanner.Scan(); i++ {
+		if parseJSONEvent(scanner.Bytes(), &ev) && string(ev.Event) == "header" {
+			return string(ev.Session), ev.Tags, nil
+		}
+		if _, ok := parseLogHeader(scanner.Bytes()); ok {
+			session, tags = parseLogLabels(scanner.Bytes())
+			return session, tags, nil
+		}
+	}
+	return "", nil, scanner.Err()
+}
+
+// LabelFilter keeps the logs of some sessions, carrying some tags.
+type LabelFilter struct {
+	Sessions []string
+	Tags     []string
+}
+
+func (f LabelFilter) empty() bool {
+	return len(f.Sessions) == 0 && len(f.Tags) == 0
+}
+
+// match reports whether a log of session with tags is kept: it must belong
+// to one of the sessions and carry one of the tags, when given.
+func (f LabelFilter) match(session string, tags []string) bool {
+	if len(f.Sessions) > 0 && !slices.Contains(f.Sessions, session) {
+		return false
+	}
+	if len(f.Tags) > 0 && !slices.ContainsFunc(f.Tags, func(tag string) bool { return slices.Contains(tags, tag) }) {
+		return false
+	}
+	return true
+}
+
+// filterLogs returns the logs matching the filter.
+func filterLogs(logFiles []string, f LabelFilter) ([]string, error) {
+	if f.empty() {
+		return logFiles, nil
+	}
+	var kept []string
+	for _, logFile := range logFiles {
+		session, tags, err := readLogLabels(logFile)
+		if err != nil {
+			return nil, err
+		}
+		if f.match(session, tags) {
+			kept = append(kept, logFile)
+		}
+	}
+	return kept, nil
+}
+
+// SessionSummary is the coverage reached by the logs of one session.
+type SessionSummary struct {
+	Session     string   `json:"session"`
+	Logs        int      `json:"logs"`
+	Tags        []string `json:"tags,omitempty"`
+	Images      int      `json:"images"`
+	TotalCount  int      `json:"total_count"`
+	CalledCount int      `json:"called_count"`
+	CoveragePct float64  `json:"coverage_pct"`
+}
+
+// sessionSummaries merges the logs of each session on their own, logs
+// without a session going to noSession.
+func sessionSummaries(logFiles []string, opts AnalyzeOptions) ([]SessionSummary, error) {
+	bySession := make(map[string][]string)
+	tagsOf := make(map[string][]string)
+	for _, logFile := range logFiles {
+		session, tags, err := readLogLabels(logFile)
+		if err != nil {
+			return nil, err
+		}
+		if session == "" {
+			session = noSession
+		}
+		bySession[session] = append(bySession[session], logFile)
+		for _, tag := range tags {
+			if !slices.Contains(tagsOf[session], tag) {
+				tagsOf[session] = append(tagsOf[session], tag)
+			}
+		}
+	}
+	var summaries []SessionSummary
+	for _, session := range sortedKeys(bySession) {
+		coverage, _, err := analyzeLogsWith(bySession[session], opts)
+		if err != nil {
+			return nil, err
+		}
+		totals := summarizeCoverage(coverage)
+		tags := tagsOf[session]
+		slices.Sort(tags)
+		summaries = append(summaries, SessionSummary{
+			Session:     session,
+			Logs:        len(bySession[session]),
+			Tags:        tags,
+			Images:      len(totals.Rows),
+			TotalCount:  totals.TotalFunctions,
+			CalledCount: totals.TotalCalled,
+			CoveragePct: totals.AverageCoverage,
+		})
+	}
+	return summaries, nil
+}
+
+// printSessionSummaries prints the session section of the text report.
+func printSessionSummaries(sessions []SessionSummary) {
+	if len(sessions) == 0 {
+		return
+	}
+	fmt.Println("\n--- Coverage by Session ---")
+	for _, s := range sessions {
+		fmt.Printf("  %s: %d logs, %d images, %d/%d functions called (%.2f%%)", s.Session, s.Logs, s.Images, s.CalledCount, s.TotalCount, s.CoveragePct)
+		if len(s.Tags) > 0 {
+			fmt.Printf(" [tags: %s]", strings.Join(s.Tags, ", "))
+		}
+		fmt.Println()
+	}
+}
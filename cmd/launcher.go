@@ -0,0 +1,391 @@
+package main
+
+import (
+	"debug/pe"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Windows (PE) Launchers ---
+
+// A PE executable cannot be replaced by a script its callers still run as
+// foo.exe. Wrapping it instead puts a copy of funkoverage in its place, with
+// a sidecar file holding what the wrapper script would: funkoverage started
+// as foo.exe finds the sidecar and launches the original under Pin.
+
+// launcherSuffix is appended to the path of a wrapped PE executable to form
+// its launcher configuration.
+const launcherSuffix = ".funkoverage.json"
+
+// peToolName is the FuncTracer build loaded by pin.exe.
+const peToolName = "FuncTracer.dll"
+
+// launcherExecutable returns the funkoverage binary copied in place of the
+// wrapped executables. Tests point it at another file.
+var launcherExecutable = os.Executable
+
+// LauncherConfig is the sidecar of a wrapped PE executable.
+type LauncherConfig struct {
+	// Generator is wrapperIDComment, telling launchers from other JSON files.
+	Generator string    `json:"generator"`
+	WrappedAt time.Time `json:"wrapped_at"`
+	Pin       string    `json:"pin"`
+	Tool      string    `json:"tool"`
+	LogDir    string    `json:"log_dir"`
+	// Original is the backup of the executable in SAFE_BIN_DIR.
+	Original string   `json:"original"`
+	Sample   string   `json:"sample,omitempty"`
+	Disable  []string `json:"disable,omitempty"`
+	API      string   `json:"api,omitempty"`
+	LogName  string   `json:"log_name,omitempty"`
+}
+
+// isPE checks if a binary is a PE executable (Windows).
+func isPE(path string) bool {
+	f, err := pe.Open(path)
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return true
+}
+
+// peDebugInfo returns the PDB file next to a PE executable, or "" when the
+// executable embeds DWARF (MinGW builds). It fails when it has neither.
+func peDebugInfo(path string) (string, error) {
+	f, err := pe.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open pe: %w", err)
+	}
+	defer f.Close()
+	for _, s := range f.Sections {
+		if strings.HasPrefix(s.Name, ".debug_") && s.Size > 0 {
+			return "", nil
+		}
+	}
+	pdb := strings.TrimSuffix(path, filepath.Ext(path)) + ".pdb"
+	if _, err := os.Stat(pdb); err == nil {
+		return pdb, nil
+	}
+	return "", fmt.Errorf("'%s' does not contain debug information and has no %s next to it. Aborting", path, filepath.Base(pdb))
+}
+
+// pinExecutable returns pin.exe of PIN_ROOT when it exists, else pin.
+func pinExecutable(root string) string {
+	exe := filepath.Join(root, "pin.exe")
+	if _, err := os.Stat(exe); err == nil {
+		return exe
+	}
+	return filepath.Join(root, "pin")
+}
+
+// readLauncherConfig returns the launcher configuration of a wrapped PE executable.
+func readLauncherConfig(path string) (*LauncherConfig, bool) {
+	content, err := os.ReadFile(path + launcherSuffix)
+	if err != nil {
+		return nil, false
+	}
+	var cfg LauncherConfig
+	if json.Unmarshal(content, &cfg) != nil || cfg.Generator != wrapperIDComment {
+		return nil, false
+	}
+	return &cfg, true
+}
+
+// launcherConfig returns the configuration of a launcher running original
+// under Pin with these options.
+func (o WrapOptions) launcherConfig(pinRoot, pinTool, logDir, original string) LauncherConfig {
+	cfg := LauncherConfig{
+		Generator: wrapperIDComment,
+		WrappedAt: time.Now(),
+		Pin:       pinExecutable(pinRoot),
+		Tool:      pinTool,
+		LogDir:    logDir,
+		Original:  original,
+		Sample:    o.Sample.String(),
+		API:       strings.Join(o.API, ","),
+		LogName:   o.LogName,
+	}
+	for _, r := range o.Disable {
+		cfg.Disable = append(cfg.Disable, r.String())
+	}
+	return cfg
+}
+
+// writeLauncherConfig writes the configuration next to the launcher at path.
+func writeLauncherConfig(path string, cfg LauncherConfig) error {
+	content, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path+launcherSuffix, append(content, '\n'), 0644)
+}
+
+// wrapPE moves a PE executable, with its PDB, to SAFE_BIN_DIR and puts the
+// launcher and its configuration in its place.
+func wrapPE(targetBinary, pinRoot, toolDir, logDir, safeBinDir string, opts WrapOptions) error {
+	launcher, err := launcherExecutable()
+	if err != nil {
+		return fmt.Errorf("could not locate the funkoverage binary: %w", err)
+	}
+	if !isPE(launcher) {
+		return fmt.Errorf("'%s' is a PE executable: wrap it with the Windows build of funkoverage", targetBinary)
+	}
+	pdb, err := peDebugInfo(targetBinary)
+	if err != nil {
+		return err
+	}
+	pinTool, err := findPinToolNamed(toolDir, peToolName)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(safeBinDir, 0755); err != nil {
+		return err
+	}
+	tmpDir, err := os.MkdirTemp(safeBinDir, "*")
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpDir, 0755); err != nil {
+		return fmt.Errorf("could not set permissions on temp dir: %w", err)
+	}
+	movedBinaryPath := filepath.Join(tmpDir, filepath.Base(targetBinary))
+	if err := move(targetBinary, movedBinaryPath); err != nil {
+		return err
+	}
+	// Pin looks up the symbols next to the executable it runs.
+	if pdb != "" {
+		if err := copyFile(pdb, filepath.Join(tmpDir, filepath.Base(pdb))); err != nil {
+			fmt.Printf("Warning: failed to copy %s next to the backup: %v\n", pdb, err)
+		}
+	}
+	if err := copyFile(launcher, targetBinary); err != nil {
+		move(movedBinaryPath, targetBinary)
+		return fmt.Errorf("could not install the launcher: %w", err)
+	}
+	if err := writeLauncherConfig(targetBinary, opts.launcherConfig(pinRoot, pinTool, logDir, movedBinaryPath)); err != nil {
+		return err
+	}
+	err = updateManifest(safeBinDir, func(m *Manifest) {
+		m.put(opts.manifestEntry(targetBinary, movedBinaryPath))
+	})
+	if err != nil {
+		fmt.Printf("Warning: failed to update manifest in %s: %v\n", safeBinDir, err)
+	}
+	fmt.Printf("Wrapped %s (original moved to %s)\n", targetBinary, movedBinaryPath)
+	return nil
+}
+
+// unwrapPE restores the original of a launcher and removes its configuration.
+func unwrapPE(targetBinary string, cfg *LauncherConfig) error {
+	if _, err := os.Stat(cfg.Original); err != nil {
+		return fmt.Errorf("original binary not found at %s: %w", cfg.Original, err)
+	}
+	if err := verifyBackup(filepath.Dir(filepath.Dir(cfg.Original)), targetBinary, cfg.Original); err != nil {
+		return err
+	}
+	if err := os.Remove(targetBinary); err != nil {
+		return fmt.Errorf("could not remove the launcher: %w", err)
+	}
+	if err := move(cfg.Original, targetBinary); err != nil {
+		return fmt.Errorf("could not restore original binary: %w", err)
+	}
+	os.Remove(targetBinary + launcherSuffix)
+	backupDir := filepath.Dir(cfg.Original)
+	os.Remove(strings.TrimSuffix(cfg.Original, filepath.Ext(cfg.Original)) + ".pdb")
+	_ = os.Remove(backupDir)
+	manifestDir := filepath.Dir(backupDir)
+	if _, err := os.Stat(filepath.Join(manifestDir, manifestFileName)); err == nil {
+		if err := updateManifest(manifestDir, func(m *Manifest) { m.remove(targetBinary) }); err != nil {
+			fmt.Printf("Warning: failed to update manifest in %s: %v\n", manifestDir, err)
+		}
+	}
+	fmt.Printf("Unwrapped %s (restored original from %s)\n", targetBinary, cfg.Original)
+	return nil
+}
+
+// matches reports whether the rule holds for this process. The boot
+// condition needs systemd, so it never holds for a launcher.
+func (r DisableRule) matches() bool {
+	if r.UID >= 0 && os.Geteuid() != r.UID {
+		return false
+	}
+	if r.User != "" {
+		u, err := user.Current()
+		// Windows names users DOMAIN\name.
+		if err != nil || (u.Username != r.User && !strings.HasSuffix(u.Username, `\`+r.User)) {
+			return false
+		}
+	}
+	if r.Cwd != "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return false
+		}
+		if rel, err := filepath.Rel(r.Cwd, wd); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return false
+		}
+	}
+	return !r.Boot
+}
+
+// sampleInvocation tells whether the invocation is traced and with which
+// rate, counting the invocations of name in logDir like the wrapper script.
+func sampleInvocation(s Sampling, logDir, name string) (bool, string) {
+	switch {
+	case s.Percent > 0:
+		return rand.Intn(100) < s.Percent, s.String()
+	case s.Every > 1:
+		countFile := filepath.Join(logDir, "."+name+".invocations")
+		lock := countFile + ".lock"
+		for i := 0; i < 100; i++ {
+			f, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL, 0666)
+			if err == nil {
+				f.Close()
+				defer os.Remove(lock)
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		content, _ := os.ReadFile(countFile)
+		n, _ := strconv.Atoi(strings.TrimSpace(string(content)))
+		n++
+		os.WriteFile(countFile, []byte(strconv.Itoa(n)+"\n"), 0666)
+		return (n-1)%s.Every == 0, "1/" + strconv.Itoa(s.Every)
+	}
+	return true, ""
+}
+
+// pinToolArgs returns the FuncTracer options set by the environment of a
+// wrapped run and its coverage window, as the wrapper script passes them.
+func pinToolArgs(sampleRate, api, window string) []string {
+	var args []string
+	if os.Getenv("FUNKOVERAGE_EDGES") != "" {
+		args = append(args, "-edges", "1")
+	}
+	if os.Getenv("FUNKOVERAGE_TIMELINE") != "" {
+		args = append(args, "-timeline", "1")
+	}
+	if os.Getenv("FUNKOVERAGE_JSON") != "" {
+		args = append(args, "-json", "1")
+	}
+	if os.Getenv("FUNKOVERAGE_PROTO") != "" {
+		args = append(args, "-proto", "1")
+	}
+	// The logs of a coverage window are tagged with its label.
+	tags := os.Getenv("COVERAGE_TAGS")
+	if window != "" {
+		tags = strings.Trim(tags+","+window, ",")
+	}
+	for _, opt := range []struct{ flag, value string }{
+		{"-sample", sampleRate},
+		{"-env", os.Getenv("FUNKOVERAGE_LOG_ENV")},
+		{"-session", os.Getenv("COVERAGE_SESSION")},
+		{"-tags", tags},
+		{"-api", api},
+	} {
+		if opt.value != "" {
+			args = append(args, opt.flag, opt.value)
+		}
+	}
+	return args
+}
+
+// runCommand runs a program with the standard streams of the launcher and
+// returns its exit status, 127 when it could not be started.
+func runCommand(name string, args []string, env []string) int {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = env
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return exitErr.ExitCode()
+		}
+		fmt.Fprintf(os.Stderr, "funkoverage: %v\n", err)
+		return 127
+	}
+	return 0
+}
+
+// runLauncher runs the original of a wrapped PE executable named name,
+// under Pin unless a disable rule or the sampling says otherwise, and
+// returns its exit status.
+func runLauncher(cfg *LauncherConfig, name string, args []string) int {
+	direct := func() int { return runCommand(cfg.Original, args, nil) }
+	// Pin follows the programs the traced one starts (-follow_execv).
+	if os.Getenv("BINARYCOVERAGE_PIN_ACTIVE") != "" {
+		return direct()
+	}
+	for _, s := range cfg.Disable {
+		if r, err := parseDisableRule(s); err == nil && r.matches() {
+			return direct()
+		}
+	}
+	if err := os.MkdirAll(cfg.LogDir, 0777); err != nil {
+		fmt.Fprintf(os.Stderr, "funkoverage: %v\n", err)
+		return direct()
+	}
+	if rules, err := readControlRules(cfg.LogDir); err == nil && !controlTraced(rules, name) {
+		return direct()
+	}
+	window, windowed := readWindow(cfg.LogDir)
+	if windowed && window == "" {
+		return direct()
+	}
+	sample := cfg.Sample
+	if v, ok := os.LookupEnv("FUNKOVERAGE_SAMPLE"); ok {
+		sample = v
+	}
+	rate := ""
+	if s, err := parseSampling(sample); err == nil {
+		var traced bool
+		if traced, rate = sampleInvocation(s, cfg.LogDir, name); !traced {
+			return direct()
+		}
+	}
+	api := cfg.API
+	if v, ok := os.LookupEnv("FUNKOVERAGE_API"); ok {
+		api = v
+	}
+	started := time.Now()
+	host, _ := os.Hostname()
+	logFile := filepath.Join(cfg.LogDir, expandLogName(cfg.LogName, name, os.Getpid(), os.Getenv("COVERAGE_SESSION"), host, started))
+	pinArgs := append([]string{"-follow_execv", "-t", cfg.Tool, "-o", logFile}, pinToolArgs(rate, api, window)...)
+	pinArgs = append(append(pinArgs, "--", cfg.Original), args...)
+	status := runCommand(cfg.Pin, pinArgs, append(os.Environ(), "BINARYCOVERAGE_PIN_ACTIVE=1"))
+	if info, err := os.Stat(logFile); err == nil && info.Size() > 0 {
+		if f, err := os.OpenFile(logFile, os.O_WRONLY|os.O_APPEND, 0); err == nil {
+			wall := time.Since(started)
+			switch {
+			case os.Getenv("FUNKOVERAGE_PROTO") != "":
+				f.Write(appendProtoExit(nil, status, wall, started))
+			case os.Getenv("FUNKOVERAGE_JSON") != "":
+				fmt.Fprintf(f, "{\"event\":\"exit\",\"code\":%d,\"wall_ms\":%d,\"started\":%d}\n", status, wall.Milliseconds(), started.UnixNano())
+			default:
+				fmt.Fprintf(f, "[FuncTracer] [Exit:%d] [WallMs:%d] [Started:%d]\n", status, wall.Milliseconds(), started.UnixNano())
+			}
+			f.Close()
+		}
+	}
+	return status
+}
+
+// launcherName is the name a launcher logs its runs under: the executable
+// name without its .exe extension.
+func launcherName(path string) string {
+	name := filepath.Base(path)
+	if ext := filepath.Ext(name); strings.EqualFold(ext, ".exe") {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return name
+}
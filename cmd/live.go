@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// --- Live Logs ---
+
+// liveLogWindow is how recently a log must have been written to be treated as
+// still in progress, for writers this process cannot see in /proc.
+const liveLogWindow = 5 * time.Second
+
+// Handling of logs still written by a running, wrapped program.
+const (
+	liveLogsTail = "tail" // parse up to the last complete line
+	liveLogsSkip = "skip" // leave the log out of the report
+)
+
+type fileID struct {
+	dev, ino uint64
+}
+
+// openFileIDs returns the files other processes hold open, read from
+// /proc/<pid>/fd. Processes we may not inspect are silently left out.
+func openFileIDs() map[fileID]bool {
+	ids := map[fileID]bool{}
+	fds, _ := filepath.Glob("/proc/[0-9]*/fd/*")
+	self := fmt.Sprintf("/proc/%d/", os.Getpid())
+	for _, fd := range fds {
+		if len(fd) > len(self) && fd[:len(self)] == self {
+			continue
+		}
+		info, err := os.Stat(fd)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if id, ok := statFileID(info); ok {
+			ids[id] = true
+		}
+	}
+	return ids
+}
+
+// endsMidLine reports whether the last line of path lacks its newline, or
+// the last record of a protobuf log is cut short. Compressed logs are
+// written whole.
+func endsMidLine(path string, size int64) bool {
+	if size == 0 || strings.HasSuffix(path, ".gz") {
+		return false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	if r := bufio.NewReader(f); isProtoLog(r) {
+		return endsMidRecord(r)
+	}
+	last := make([]byte, 1)
+	if _, err := f.ReadAt(last, size-1); err != nil {
+		return false
+	}
+	return last[0] != '\n'
+}
+
+// liveLogDetector tells whether a log is still being written: a process holds
+// it open, or it was modified moments ago and ends in the middle of a line
+// (or record).
+type liveLogDetector struct {
+	open map[fileID]bool
+	now  time.Time
+}
+
+func (d *liveLogDetector) isLive(path string, info os.FileInfo) bool {
+	if d.open == nil {
+		d.open, d.now = openFileIDs(), time.Now()
+	}
+	if id, ok := statFileID(info); ok && d.open[id] {
+		return true
+	}
+	return d.now.Sub(info.ModTime()) < liveLogWindow && endsMidLine(path, info.Size())
+}
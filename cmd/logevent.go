@@ -0,0 +1,122 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// --- Structured Log Events ---
+
+// logEvent is an event of a JSON-lines or protobuf log, named by Event
+// ("header", "function", "called", ...) like the lines of a text log. The
+// byte members alias the line or record they were decoded from, where
+// possible.
+type logEvent struct {
+	Event, Image, Function, Addr, Caller, Callee []byte
+	// header
+	Format                              uint64
+	Tool, Run, Sample, Session, Options []byte
+	Import                              []byte
+	Tags                                []string
+	// context
+	Time, Host, Cwd []byte
+	Args, Env       []string
+	// build_id, arch, plugin
+	BuildID, Arch, Loader []byte
+	// called, edge, first_call
+	Count, Pid, Tid, TimeNs uint64
+	// exit, appended by the wrapper
+	Code, Signal, WallMs, Started uint64
+	// addr holds the Addr of protobuf events, numbers there.
+	addr [20]byte
+}
+
+// addEvent records an event like parseFile records the lines of a text log.
+// It fails only for a header of an unsupported format.
+func (a *logAnalyzer) addEvent(ev *logEvent, coverage map[string]*CoverageData, stats *LogStats) error {
+	switch string(ev.Event) {
+	case "function", "called":
+		kind := lineFunction
+		if ev.Event[0] == 'c' {
+			kind = lineCalled
+		}
+		if !a.addEntry(coverage, kind, ev.Image, ev.Function, ev.Addr, ev.Count) {
+			stats.Malformed++
+		}
+	case "edge":
+		if !a.addEdge(coverage, ev.Image, ev.Caller, ev.Callee, ev.Count) {
+			stats.Malformed++
+		}
+	case "header":
+		if _, err := logFormatFor(int(ev.Format)); err != nil {
+			return err
+		}
+		if stats.Format == 0 {
+			stats.Format, stats.Tool, stats.Options = int(ev.Format), string(ev.Tool), string(ev.Options)
+		}
+		if stats.RunID == "" {
+			stats.RunID = string(ev.Run)
+		}
+		if stats.Sample == "" {
+			stats.Sample = string(ev.Sample)
+		}
+		if stats.Import == "" {
+			stats.Import = string(ev.Import)
+		}
+		if stats.Session == "" && stats.Tags == nil {
+			stats.Session, stats.Tags = string(ev.Session), ev.Tags
+		}
+	case "context":
+		if stats.Context == nil {
+			stats.Context = ev.context()
+		}
+	case "build_id", "arch", "plugin", "image":
+		// A protobuf image event may carry several of them.
+		image := a.symbols.image(ev.Image)
+		if len(ev.BuildID) > 0 {
+			if stats.BuildIDs == nil {
+				stats.BuildIDs = make(map[string]string)
+			}
+			stats.BuildIDs[image] = string(ev.BuildID)
+		}
+		if len(ev.Arch) > 0 {
+			if stats.Archs == nil {
+				stats.Archs = make(map[string]string)
+			}
+			stats.Archs[image] = string(ev.Arch)
+		}
+		if len(ev.Loader) > 0 {
+			if stats.Plugins == nil {
+				stats.Plugins = make(map[string]string)
+			}
+			stats.Plugins[image] = string(ev.Loader)
+		}
+	case "exit":
+		stats.Exit = ev.exit()
+	}
+	return nil
+}
+
+// context returns the invocation a context event describes.
+func (ev *logEvent) context() *LogContext {
+	ctx := &LogContext{Host: string(ev.Host), Cwd: string(ev.Cwd), Argv: ev.Args}
+	ctx.Time, _ = time.Parse(time.RFC3339, string(ev.Time))
+	for _, v := range ev.Env {
+		if name, val, ok := strings.Cut(v, "="); ok {
+			if ctx.Env == nil {
+				ctx.Env = make(map[string]string)
+			}
+			ctx.Env[name] = val
+		}
+	}
+	return ctx
+}
+
+// exit returns how the invocation of an exit event ended.
+func (ev *logEvent) exit() *LogExit {
+	exit := &LogExit{Code: int(int32(ev.Code)), Signal: int(int32(ev.Signal)), Wall: time.Duration(ev.WallMs) * time.Millisecond}
+	if ev.Started > 0 {
+		exit.started = time.Unix(0, int64(ev.Started))
+	}
+	return exit
+}
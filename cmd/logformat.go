@@ -0,0 +1,50 @@
+package main
+
+import "fmt"
+
+// --- Log Format Versions ---
+
+var (
+	toolMarker    = []byte("] [Tool:")
+	optionsMarker = []byte("] [Options:")
+)
+
+// logFormat reads the Function and Called lines of the log format versions
+// First to Last.
+type logFormat struct {
+	First, Last int
+	parseLine   func(line []byte) (kind lineKind, image, function, addr []byte, count uint64)
+}
+
+// logFormats lists the parsers of the supported log format versions, oldest
+// first; logs without a header are read by the first. Every format so far
+// only added fields and line kinds older readers skip, so parseLogLine reads
+// them all. A format changing the meaning of existing lines gets a parser of
+// its own here, keeping the logs of older tracers readable.
+var logFormats = []logFormat{
+	{First: 1, Last: supportedLogFormat, parseLine: parseLogLine},
+}
+
+// logFormatFor returns the parser of a log format version announced by a
+// header.
+func logFormatFor(version int) (logFormat, error) {
+	for _, f := range logFormats {
+		if version >= f.First && version <= f.Last {
+			return f, nil
+		}
+	}
+	if version > supportedLogFormat {
+		return logFormat{}, fmt.Errorf("format %d, newer than the supported %d: upgrade funkoverage", version, supportedLogFormat)
+	}
+	return logFormat{}, fmt.Errorf("unknown format %d", version)
+}
+
+// parseLogTool returns the tracer version and options a header line carries
+// (format 11).
+func parseLogTool(line []byte) (tool, options string) {
+	tool, _ = parseHeaderField(line, toolMarker)
+	if v, ok := parseHeaderField(line, optionsMarker); ok {
+		options = string(unescapeField([]byte(v)))
+	}
+	return tool, options
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// --- Log Index Sidecars ---
+
+// logIndexSuffix is appended to a log file name to form its sidecar index.
+const logIndexSuffix = ".idx"
+
+// logIndexVersion is bumped whenever the index layout or the symbol
+// processing changes, invalidating the existing sidecars.
+const logIndexVersion = 22
+
+// LogIndex summarizes a single log file. It is valid as long as the log keeps
+// the size and modification time recorded here and is read with the same
+// analysis options.
+type LogIndex struct {
+	Version   int               `json:"version"`
+	Size      int64             `json:"size"`
+	ModTime   time.Time         `json:"mod_time"`
+	Lines     int               `json:"lines"`
+	Malformed int               `json:"malformed"`
+	RunID     string            `json:"run_id,omitempty"`
+	Sample    string            `json:"sample,omitempty"`
+	Session   string            `json:"session,omitempty"`
+	Tags      []string          `json:"tags,omitempty"`
+	Exit      *LogExit          `json:"exit,omitempty"`
+	Context   *LogContext       `json:"context,omitempty"`
+	BuildIDs  map[string]string `json:"build_ids,omitempty"`
+	Archs     map[string]string `json:"archs,omitempty"`
+	Plugins   map[string]string `json:"plugins,omitempty"`
+	Import    string            `json:"import,omitempty"`
+	Format    int               `json:"format,omitempty"`
+	Tool      string            `json:"tool,omitempty"`
+	// ToolOptions are the tracer options of the header (format 11).
+	ToolOptions string `json:"tool_options,omitempty"`
+	// Options records the AnalyzeOptions.indexKey the names were produced with.
+	Options string                 `json:"options"`
+	Images  map[string]*ImageIndex `json:"images"`
+}
+
+// ImageIndex lists the deduplicated functions of one image within a log.
+type ImageIndex struct {
+	TotalCount  int      `json:"total_count"`
+	CalledCount int      `json:"called_count"`
+	Functions   []string `json:"functions"`
+	Called      []string `json:"called"`
+	// Calls counts the calls of the called functions (format 5 logs).
+	Calls map[string]uint64 `json:"calls,omitempty"`
+	Edges []EdgeCount       `json:"edges,omitempty"`
+}
+
+// EdgeCount is a call-graph edge with its number of calls.
+type EdgeCount struct {
+	Edge
+	Calls uint64 `json:"calls"`
+}
+
+func logIndexPath(logFile string) string {
+	return logFile + logIndexSuffix
+}
+
+func sortedKeys[V any](set map[string]V) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// readLogIndex returns the sidecar index of logFile if it is still up to date.
+func readLogIndex(logFile string, opts AnalyzeOptions) (*LogIndex, bool) {
+	info, err := os.Stat(logFile)
+	if err != nil {
+		return nil, false
+	}
+	content, err := os.ReadFile(logIndexPath(logFile))
+	if err != nil {
+		return nil, false
+	}
+	var idx LogIndex
+	if err := json.Unmarshal(content, &idx); err != nil {
+		return nil, false
+	}
+	if idx.Version != logIndexVersion || idx.Size != info.Size() || !idx.ModTime.Equal(info.ModTime()) || idx.Options != opts.indexKey() {
+		return nil, false
+	}
+	return &idx, true
+}
+
+// loadLogIndex returns the coverage data and line stats stored in the sidecar of logFile.
+func loadLogIndex(logFile string, opts AnalyzeOptions) (map[string]*CoverageData, LogStats, bool) {
+	idx, ok := readLogIndex(logFile, opts)
+	if !ok {
+		return nil, LogStats{}, false
+	}
+	coverage := make(map[string]*CoverageData, len(idx.Images))
+	for image, entry := range idx.Images {
+		data := &CoverageData{TotalFunctions: make(map[string]struct{}, len(entry.Functions)), CalledFunctions: make(map[string]struct{}, len(entry.Called)), Calls: entry.Calls}
+		for _, fn := range entry.Functions {
+			data.TotalFunctions[fn] = struct{}{}
+		}
+		for _, fn := range entry.Called {
+			data.CalledFunctions[fn] = struct{}{}
+		}
+		for _, e := range entry.Edges {
+			data.addEdge(e.Edge, e.Calls)
+		}
+		coverage[image] = data
+	}
+	return coverage, LogStats{File: logFile, Lines: idx.Lines, Malformed: idx.Malformed, RunID: idx.RunID, Sample: idx.Sample, Session: idx.Session, Tags: idx.Tags, Exit: idx.Exit, Context: idx.Context, BuildIDs: idx.BuildIDs, Archs: idx.Archs, Plugins: idx.Plugins, Import: idx.Import, Format: idx.Format, Tool: idx.Tool, Options: idx.ToolOptions}, true
+}
+
+// writeLogIndex stores the coverage of logFile in its sidecar index. info must
+// be taken before parsing, so a log growing meanwhile invalidates the index.
+func writeLogIndex(logFile string, info os.FileInfo, opts AnalyzeOptions, coverage map[string]*CoverageData, stats LogStats) error {
+	idx := LogIndex{
+		Version:     logIndexVersion,
+		Size:        info.Size(),
+		ModTime:     info.ModTime(),
+		Lines:       stats.Lines,
+		Malformed:   stats.Malformed,
+		RunID:       stats.RunID,
+		Sample:      stats.Sample,
+		Session:     stats.Session,
+		Tags:        stats.Tags,
+		Exit:        stats.Exit,
+		Context:     stats.Context,
+		BuildIDs:    stats.BuildIDs,
+		Archs:       stats.Archs,
+		Plugins:     stats.Plugins,
+		Import:      stats.Import,
+		Format:      stats.Format,
+		Tool:        stats.Tool,
+		ToolOptions: stats.Options,
+		Options:     opts.indexKey(),
+		Images:      make(map[string]*ImageIndex, len(coverage)),
+	}
+	for image, data := range coverage {
+		entry := &ImageIndex{
+			TotalCount:  len(data.TotalFunctions),
+			CalledCount: len(data.CalledFunctions),
+			Functions:   sortedKeys(data.TotalFunctions),
+			Called:      sortedKeys(data.CalledFunctions),
+			Calls:       data.Calls,
+		}
+		for _, e := range sortedEdges(data, 0) {
+			entry.Edges = append(entry.Edges, EdgeCount{e, data.Edges[e]})
+		}
+		idx.Images[image] = entry
+	}
+	content, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(logFile), ".idx-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), logIndexPath(logFile))
+}
+
+// --- In-memory Log Cache ---
+
+// logCache keeps the coverage of every log a long-running server analyzed, so
+// its requests merge the coverage of the logs instead of parsing them again.
+// An entry stays valid as long as its log keeps its size and modification time.
+type logCache struct {
+	opts    AnalyzeOptions
+	mu      sync.Mutex
+	entries map[string]*cachedLog
+}
+
+type cachedLog struct {
+	size     int64
+	modTime  time.Time
+	coverage map[string]*CoverageData
+	stats    LogStats
+}
+
+func newLogCache(opts AnalyzeOptions) *logCache {
+	return &logCache{opts: opts, entries: make(map[string]*cachedLog)}
+}
+
+// load returns the coverage and stats of logFile, analyzing it (through its
+// sidecar index when up to date) only when it is new or changed. The coverage
+// is shared by every caller and must not be modified.
+func (c *logCache) load(logFile string) (map[string]*CoverageData, LogStats, error) {
+	info, err := os.Stat(logFile)
+	if err != nil {
+		c.mu.Lock()
+		delete(c.entries, logFile)
+		c.mu.Unlock()
+		return nil, LogStats{}, fmt.Errorf("could not open log file %s: %w", logFile, err)
+	}
+	c.mu.Lock()
+	entry := c.entries[logFile]
+	c.mu.Unlock()
+	if entry != nil && entry.size == info.Size() && entry.modTime.Equal(info.ModTime()) {
+		return entry.coverage, entry.stats, nil
+	}
+	coverage, stats, err := analyzeLogsWith([]string{logFile}, c.opts)
+	if err != nil {
+		return nil, LogStats{}, err
+	}
+	entry = &cachedLog{size: info.Size(), modTime: info.ModTime(), coverage: coverage, stats: LogStats{File: logFile}}
+	if len(stats) > 0 {
+		entry.stats = stats[0]
+	}
+	c.mu.Lock()
+	c.entries[logFile] = entry
+	c.mu.Unlock()
+	return entry.coverage, entry.stats, nil
+}
+
+// merge returns the coverage of logFiles merged into fresh maps, leaving out
+// the copies of a run already merged like analyzeLogsWith does.
+func (c *logCache) merge(logFiles []string) (map[string]*CoverageData, []LogStats, error) {
+	coverage := make(map[string]*CoverageData)
+	stats := make([]LogStats, 0, len(logFiles))
+	runs := map[string]string{}
+	for _, logFile := range logFiles {
+		data, s, err := c.load(logFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		if s.RunID != "" {
+			if first, ok := runs[s.RunID]; ok {
+				s.DuplicateOf = first
+				stats = append(stats, s)
+				continue
+			}
+			runs[s.RunID] = logFile
+		}
+		if s.Live != liveLogsSkip {
+			mergeCoverage(coverage, data)
+		}
+		stats = append(stats, s)
+	}
+	return coverage, stats, nil
+}
+
+// retain drops the entries of the logs not in logFiles, such as removed ones.
+func (c *logCache) retain(logFiles []string) {
+	keep := make(map[string]struct{}, len(logFiles))
+	for _, logFile := range logFiles {
+		keep[logFile] = struct{}{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for logFile := range c.entries {
+		if _, ok := keep[logFile]; !ok {
+			delete(c.entries, logFile)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Log File Names ---
+
+// defaultLogName is how wrappers name their logs, unless wrapped with
+// --log-name. Reports take the binary and the time of a log from such names
+// (see logBinary and logTimestamp), else from the log and its mtime.
+const defaultLogName = "{binary}_{timestamp}_{nanos}.log"
+
+// logNamePlaceholders are the fields of a log name template.
+var logNamePlaceholders = map[string]bool{
+	"binary":    true, // name the wrapped binary was called by
+	"pid":       true, // process ID of the wrapper
+	"session":   true, // COVERAGE_SESSION, or "none"
+	"host":      true, // host name
+	"timestamp": true, // start of the run, as YYYYMMDD-HHMMSS
+	"nanos":     true, // nanoseconds of the start of the run
+}
+
+var (
+	logNamePlaceholderRe = regexp.MustCompile(`\{([^{}]*)\}`)
+	logNameCharsRe       = regexp.MustCompile(`^[A-Za-z0-9._{}-]+$`)
+	unsafeLogNameRe      = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+)
+
+// parseLogName validates a --log-name template: a file name ending in .log,
+// made unique per run by {pid} or {nanos}. "" stands for defaultLogName.
+func parseLogName(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	if !logNameCharsRe.MatchString(s) {
+		return "", fmt.Errorf("invalid log name %q: only letters, digits, '.', '_', '-' and placeholders are allowed", s)
+	}
+	for _, m := range logNamePlaceholderRe.FindAllStringSubmatch(s, -1) {
+		if !logNamePlaceholders[m[1]] {
+			return "", fmt.Errorf("invalid log name %q: unknown placeholder {%s} (expected {%s})", s, m[1], strings.Join(sortedKeys(logNamePlaceholders), "}, {"))
+		}
+	}
+	if strings.ContainsAny(logNamePlaceholderRe.ReplaceAllString(s, ""), "{}") {
+		return "", fmt.Errorf("invalid log name %q: unbalanced braces", s)
+	}
+	if !strings.HasSuffix(s, ".log") {
+		return "", fmt.Errorf("invalid log name %q: it must end in .log", s)
+	}
+	if !strings.Contains(s, "{pid}") && !strings.Contains(s, "{nanos}") {
+		return "", fmt.Errorf("invalid log name %q: it needs {pid} or {nanos} to tell runs apart", s)
+	}
+	return s, nil
+}
+
+// expandLogName returns the name of the log of a run started at started,
+// as the wrapper script names it.
+func expandLogName(template, binary string, pid int, session, host string, started time.Time) string {
+	if template == "" {
+		template = defaultLogName
+	}
+	if session == "" {
+		session = "none"
+	}
+	return strings.NewReplacer(
+		"{binary}", binary,
+		"{pid}", strconv.Itoa(pid),
+		"{session}", unsafeLogNameRe.ReplaceAllString(session, "_"),
+		"{host}", unsafeLogNameRe.ReplaceAllString(host, "_"),
+		"{timestamp}", started.Format("20060102-150405"),
+		"{nanos}", fmt.Sprintf("%09d", started.Nanosecond()),
+	).Replace(template)
+}
+
+// logNameScript is the part of the wrapper script setting log_file from the
+// template, the components substituted like expandLogName does.
+func logNameScript(template string) string {
+	if template == "" {
+		template = defaultLogName
+	}
+	return `log_name="` + template + `"
+session_name="${COVERAGE_SESSION:-none}"
+host_name="${HOSTNAME:-$(hostname 2>/dev/null)}"
+log_name=${log_name//"{binary}"/"$binary_name"}
+log_name=${log_name//"{pid}"/"$$"}
+log_name=${log_name//"{session}"/"${session_name//[^A-Za-z0-9._-]/_}"}
+log_name=${log_name//"{host}"/"${host_name//[^A-Za-z0-9._-]/_}"}
+log_name=${log_name//"{timestamp}"/"$timestamp"}
+log_name=${log_name//"{nanos}"/"$nano_seconds"}
+log_file="$LOG_DIR/$log_name"
+`
+}
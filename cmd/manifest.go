@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// --- Wrap Manifest ---
+
+// manifestFileName is stored in SAFE_BIN_DIR next to the backups it describes.
+const manifestFileName = "manifest.json"
+
+// ManifestEntry records one wrapped binary.
+type ManifestEntry struct {
+	// Path is where the wrapper script now lives (the original binary location).
+	Path string `json:"path"`
+	// Backup is where the original binary was moved to.
+	Backup    string    `json:"backup"`
+	WrappedAt time.Time `json:"wrapped_at"`
+	// Sample, Disable, API and LogName record the wrap options, to wrap the
+	// binary again the same way after a package update (see refresh).
+	Sample  string   `json:"sample,omitempty"`
+	Disable []string `json:"disable,omitempty"`
+	API     []string `json:"api,omitempty"`
+	LogName string   `json:"log_name,omitempty"`
+	// Package is the source package owning Path when it was wrapped, which
+	// report --group-by package uses without asking the package manager.
+	Package string `json:"package,omitempty"`
+	// PackageName and PackageVersion are the binary package owning Path
+	// and its version, and BuildID the GNU build ID of the original binary,
+	// telling which build the coverage of the binary was measured on.
+	PackageName    string `json:"package_name,omitempty"`
+	PackageVersion string `json:"package_version,omitempty"`
+	BuildID        string `json:"build_id,omitempty"`
+	// SHA256 is the checksum of the original binary, which unwrap verifies
+	// the backup against before restoring it.
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// wrapOptions returns the options the entry was wrapped with.
+func (e ManifestEntry) wrapOptions() (WrapOptions, error) {
+	sample, err := parseSampling(e.Sample)
+	if err != nil {
+		return WrapOptions{}, err
+	}
+	opts := WrapOptions{Sample: sample, API: e.API, LogName: e.LogName}
+	for _, s := range e.Disable {
+		r, err := parseDisableRule(s)
+		if err != nil {
+			return WrapOptions{}, err
+		}
+		opts.Disable = append(opts.Disable, r)
+	}
+	return opts, nil
+}
+
+type Manifest struct {
+	Entries []ManifestEntry `json:"entries"`
+}
+
+func safeBinDir() string {
+	if dir := os.Getenv("SAFE_BIN_DIR"); dir != "" {
+		return dir
+	}
+	return defaultSafeBinDir
+}
+
+// loadManifest reads the manifest of dir. A missing manifest is empty.
+func loadManifest(dir string) (*Manifest, error) {
+	return loadManifestFile(filepath.Join(dir, manifestFileName))
+}
+
+// loadManifestFile reads a manifest, wherever it is. A missing manifest is empty.
+func loadManifestFile(path string) (*Manifest, error) {
+	m := &Manifest{}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// save atomically replaces the manifest of dir.
+func (m *Manifest) save(dir string) error {
+	content, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".manifest-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(content, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, manifestFileName))
+}
+
+// put adds the entry, replacing any previous entry for the same path.
+func (m *Manifest) put(entry ManifestEntry) {
+	m.remove(entry.Path)
+	m.Entries = append(m.Entries, entry)
+}
+
+// remove drops the entry for path, if any.
+func (m *Manifest) remove(path string) {
+	kept := m.Entries[:0]
+	for _, e := range m.Entries {
+		if e.Path != path {
+			kept = append(kept, e)
+		}
+	}
+	m.Entries = kept
+}
+
+// findByImage returns the entry whose backup directory contains image. Every
+// wrap uses its own directory, which also covers multicall symlinks.
+func (m *Manifest) findByImage(image string) (ManifestEntry, bool) {
+	for _, e := range m.Entries {
+		if e.Backup == image || filepath.Dir(e.Backup) == filepath.Dir(image) {
+			return e, true
+		}
+	}
+	return ManifestEntry{}, false
+}
+
+// fileSHA256 returns the hex SHA-256 of the file at path.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// verifyBackup checks the backup about to be restored over target against
+// the checksum the manifest of dir recorded when target was wrapped. Entries
+// recorded without a checksum are not verified.
+func verifyBackup(dir, target, backup string) error {
+	m, err := loadManifest(dir)
+	if err != nil {
+		return err
+	}
+	e, ok := m.findByPath(target)
+	if !ok || e.SHA256 == "" {
+		return nil
+	}
+	sum, err := fileSHA256(backup)
+	if err != nil {
+		return err
+	}
+	if sum != e.SHA256 {
+		return fmt.Errorf("backup %s is corrupted or another version: SHA-256 %s, recorded %s", backup, sum, e.SHA256)
+	}
+	return nil
+}
+
+// updateManifest applies fn to the manifest of dir and saves it.
+func updateManifest(dir string, fn func(*Manifest)) error {
+	m, err := loadManifest(dir)
+	if err != nil {
+		return err
+	}
+	fn(m)
+	return m.save(dir)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// --- Run Metadata ---
+
+// RunMetadata describes the system state a report was made from, embedded in
+// every output: months later, it tells which hosts, tools and wraps the
+// coverage was recorded with.
+type RunMetadata struct {
+	// Host and Kernel are the ones the report ran on, PinVersion the Pin of
+	// PIN_ROOT there.
+	Host       string `json:"host,omitempty"`
+	Kernel     string `json:"kernel,omitempty"`
+	PinVersion string `json:"pin_version,omitempty"`
+	Version    string `json:"funkoverage_version"`
+	// TracerVersions are the FuncTracer versions the logs were written by
+	// (format 11 headers).
+	TracerVersions []string `json:"tracer_versions,omitempty"`
+	// ManifestSHA256 identifies the wrap manifest of SAFE_BIN_DIR.
+	ManifestSHA256 string `json:"manifest_sha256,omitempty"`
+	// LogHosts are the hosts the logs were recorded on, when they say.
+	LogHosts []string `json:"log_hosts,omitempty"`
+	// FirstLog and LastLog bound the times the logs were recorded, RFC 3339.
+	FirstLog string `json:"first_log,omitempty"`
+	LastLog  string `json:"last_log,omitempty"`
+}
+
+// pinVersionTimeout bounds pin -version, which starts the whole of Pin.
+const pinVersionTimeout = 10 * time.Second
+
+// collectRunMetadata gathers the metadata of a report run over the logs.
+func collectRunMetadata(stats []LogStats) *RunMetadata {
+	meta := &RunMetadata{Version: versionString}
+	meta.Host, _ = os.Hostname()
+	if release, err := os.ReadFile("/proc/sys/kernel/osrelease"); err == nil {
+		meta.Kernel = strings.TrimSpace(string(release))
+	}
+	if root := os.Getenv("PIN_ROOT"); root != "" {
+		meta.PinVersion = pinVersion(root)
+	}
+	if sum, err := fileSHA256(filepath.Join(safeBinDir(), manifestFileName)); err == nil {
+		meta.ManifestSHA256 = sum
+	}
+	var first, last time.Time
+	for _, s := range stats {
+		if s.DuplicateOf != "" {
+			continue
+		}
+		if s.Tool != "" && !slices.Contains(meta.TracerVersions, s.Tool) {
+			meta.TracerVersions = append(meta.TracerVersions, s.Tool)
+		}
+		at := logTimestamp(s.File)
+		if s.Context != nil {
+			if s.Context.Host != "" && !slices.Contains(meta.LogHosts, s.Context.Host) {
+				meta.LogHosts = append(meta.LogHosts, s.Context.Host)
+			}
+			if !s.Context.Time.IsZero() {
+				at = s.Context.Time
+			}
+		}
+		if at.IsZero() {
+			continue
+		}
+		if first.IsZero() || at.Before(first) {
+			first = at
+		}
+		if at.After(last) {
+			last = at
+		}
+	}
+	slices.Sort(meta.TracerVersions)
+	slices.Sort(meta.LogHosts)
+	if !first.IsZero() {
+		meta.FirstLog, meta.LastLog = first.Format(time.RFC3339), last.Format(time.RFC3339)
+	}
+	return meta
+}
+
+// pinVersion returns the version pin -version prints, like
+// "pin-3.30-98830-g1d7b601b3-gcc-linux", or empty when Pin does not run.
+func pinVersion(root string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), pinVersionTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, pinExecutable(root), "-version").Output()
+	if err != nil {
+		return ""
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "Pin:"); ok {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// MetadataField is a line of the metadata block of the reports.
+type MetadataField struct {
+	Name  string
+	Value string
+}
+
+// Fields lists the metadata that is known, in the order the reports show it.
+func (m *RunMetadata) Fields() []MetadataField {
+	if m == nil {
+		return nil
+	}
+	logTimes := ""
+	if m.FirstLog != "" {
+		logTimes = m.FirstLog + " to " + m.LastLog
+	}
+	var fields []MetadataField
+	for _, f := range []MetadataField{
+		{"Host", m.Host},
+		{"Kernel", m.Kernel},
+		{"Pin", m.PinVersion},
+		{"funkoverage", m.Version},
+		{"FuncTracer", strings.Join(m.TracerVersions, ", ")},
+		{"Wrap manifest SHA-256", m.ManifestSHA256},
+		{"Log hosts", strings.Join(m.LogHosts, ", ")},
+		{"Log times", logTimes},
+	} {
+		if f.Value != "" {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// printRunMetadata ends the text report with the metadata of the run.
+func printRunMetadata(m *RunMetadata) {
+	fields := m.Fields()
+	if len(fields) == 0 {
+		return
+	}
+	fmt.Println("\n--- Run Metadata ---")
+	for _, f := range fields {
+		fmt.Printf("  %-22s %s\n", f.Name+":", f.Value)
+	}
+}
+
+// properties lists the metadata that is known under the names of its JSON
+// fields, as the properties of the XUnit test suites.
+func (m *RunMetadata) properties() []MetadataField {
+	if m == nil {
+		return nil
+	}
+	var props []MetadataField
+	for _, p := range []MetadataField{
+		{"host", m.Host},
+		{"kernel", m.Kernel},
+		{"pin_version", m.PinVersion},
+		{"funkoverage_version", m.Version},
+		{"tracer_versions", strings.Join(m.TracerVersions, ",")},
+		{"manifest_sha256", m.ManifestSHA256},
+		{"log_hosts", strings.Join(m.LogHosts, ",")},
+		{"first_log", m.FirstLog},
+		{"last_log", m.LastLog},
+	} {
+		if p.Value != "" {
+			props = append(props, p)
+		}
+	}
+	return props
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// --- Regression Notifications ---
+
+// NotifyConfig configures when and where coverage alerts are sent.
+type NotifyConfig struct {
+	// MinCoverage alerts when the overall coverage falls below this percentage.
+	MinCoverage float64 `json:"min_coverage"`
+	// MaxDrop is the tolerated per-image coverage drop (in points) versus the baseline.
+	MaxDrop float64 `json:"max_drop"`
+	// BaselineFile stores the coverage each image is compared to (see
+	// nextBaseline).
+	BaselineFile string `json:"baseline_file"`
+
+	SlackWebhook string     `json:"slack_webhook"`
+	Webhook      string     `json:"webhook"`
+	SMTP         SMTPConfig `json:"smtp"`
+}
+
+type SMTPConfig struct {
+	Addr     string   `json:"addr"` // host:port
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+}
+
+func (c NotifyConfig) enabled() bool {
+	return c.SlackWebhook != "" || c.Webhook != "" || c.SMTP.Addr != ""
+}
+
+// loadCoverageTotals reads a coverage summary previously saved as JSON.
+func loadCoverageTotals(path string) (*CoverageTotals, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var totals CoverageTotals
+	if err := json.Unmarshal(content, &totals); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+	return &totals, nil
+}
+
+// saveCoverageTotals writes a coverage summary as indented JSON.
+func saveCoverageTotals(path string, totals CoverageTotals) error {
+	content, err := json.MarshalIndent(totals, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(content, '\n'), 0644)
+}
+
+// coverageAlerts compares the current summary with the thresholds and the
+// (optional) baseline and returns a human-readable line for each problem found.
+func coverageAlerts(cfg NotifyConfig, current CoverageTotals, baseline *CoverageTotals) []string {
+	alerts := []string{}
+	if cfg.MinCoverage > 0 && current.AverageCoverage < cfg.MinCoverage {
+		alerts = append(alerts, fmt.Sprintf("Average coverage %.2f%% is below the %.2f%% threshold", current.AverageCoverage, cfg.MinCoverage))
+	}
+	if baseline == nil {
+		return alerts
+	}
+	previous := make(map[string]float64, len(baseline.Rows))
+	for _, row := range baseline.Rows {
+		previous[row.ImageName] = row.CoveragePct
+	}
+	for _, row := range current.Rows {
+		prev, ok := previous[row.ImageName]
+		if ok && prev-row.CoveragePct > cfg.MaxDrop {
+			alerts = append(alerts, fmt.Sprintf("%s regressed from %.2f%% to %.2f%%", filepath.Base(row.ImageName), prev, row.CoveragePct))
+		}
+	}
+	return alerts
+}
+
+func postJSON(url string, payload any) error {
+	return postJSONAs(url, "application/json", payload)
+}
+
+// postJSONAs posts payload encoded as JSON with a custom content type.
+func postJSONAs(url, contentType string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(url, contentType, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return nil
+}
+
+func sendMail(cfg SMTPConfig, subject, text string) error {
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		host := strings.Split(cfg.Addr, ":")[0]
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, host)
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s\r\n", cfg.From, strings.Join(cfg.To, ", "), subject, text)
+	return smtp.SendMail(cfg.Addr, auth, cfg.From, cfg.To, []byte(msg))
+}
+
+// nextBaseline returns the baseline the next run is compared to. An image only
+// moves to its current coverage when it did not drop, or dropped enough to be
+// alerted on: a slow decline, each step within MaxDrop, adds up until it
+// raises an alert.
+func nextBaseline(cfg NotifyConfig, current CoverageTotals, baseline *CoverageTotals) CoverageTotals {
+	if baseline == nil {
+		return current
+	}
+	previous := make(map[string]float64, len(baseline.Rows))
+	for _, row := range baseline.Rows {
+		previous[row.ImageName] = row.CoveragePct
+	}
+	next := current
+	next.Rows = slices.Clone(current.Rows)
+	for i, row := range next.Rows {
+		if prev, ok := previous[row.ImageName]; ok && prev > row.CoveragePct && prev-row.CoveragePct <= cfg.MaxDrop {
+			next.Rows[i].CoveragePct = prev
+		}
+	}
+	return next
+}
+
+// notifyRegressions evaluates the alerts for the current summary and sends them
+// to every configured target. The baseline only moves forward once the alerts
+// were delivered, so that failed ones are raised again by the next run.
+func notifyRegressions(cfg NotifyConfig, current CoverageTotals) error {
+	if !cfg.enabled() {
+		return nil
+	}
+	var baseline *CoverageTotals
+	if cfg.BaselineFile != "" {
+		if b, err := loadCoverageTotals(cfg.BaselineFile); err == nil {
+			baseline = b
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	alerts := coverageAlerts(cfg, current, baseline)
+	var errs []error
+	if len(alerts) > 0 {
+		text := "funkoverage coverage alert:\n- " + strings.Join(alerts, "\n- ")
+		if cfg.SlackWebhook != "" {
+			if err := postJSON(cfg.SlackWebhook, map[string]string{"text": text}); err != nil {
+				errs = append(errs, fmt.Errorf("slack: %w", err))
+			}
+		}
+		if cfg.Webhook != "" {
+			payload := map[string]any{"text": text, "alerts": alerts, "summary": current}
+			if err := postJSON(cfg.Webhook, payload); err != nil {
+				errs = append(errs, fmt.Errorf("webhook: %w", err))
+			}
+		}
+		if cfg.SMTP.Addr != "" {
+			if err := sendMail(cfg.SMTP, "funkoverage coverage alert", text); err != nil {
+				errs = append(errs, fmt.Errorf("smtp: %w", err))
+			}
+		}
+	}
+	if cfg.BaselineFile != "" && len(errs) == 0 {
+		if err := saveCoverageTotals(cfg.BaselineFile, nextBaseline(cfg, current, baseline)); err != nil {
+			errs = append(errs, fmt.Errorf("baseline: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
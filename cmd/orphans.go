@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// --- Forced Unwrap ---
+
+// UnwrapOptions are the settings of unwrap.
+type UnwrapOptions struct {
+	// Force restores the binaries unwrap cannot, because their wrapper was
+	// edited or their backup moved, from the backup store or their package.
+	Force bool
+}
+
+// wrapperOriginal returns the backup a wrapper script names, or "".
+func wrapperOriginal(content []byte) string {
+	for _, line := range strings.Split(string(content), "\n") {
+		if rest, ok := strings.CutPrefix(line, "# Original Binary:"); ok {
+			return strings.TrimSpace(rest)
+		}
+	}
+	return ""
+}
+
+// findByPath returns the entry of the binary wrapped at path.
+func (m *Manifest) findByPath(path string) (ManifestEntry, bool) {
+	for _, e := range m.Entries {
+		if e.Path == path {
+			return e, true
+		}
+	}
+	return ManifestEntry{}, false
+}
+
+// forceUnwrap puts back the original of a wrapped binary whose wrapper or
+// backup is not where unwrap expects it, or whose backup fails its checksum.
+// The original is, in this order: the backup the wrapper or the manifest
+// names, the file of the backup store with the SHA-256 (or else the build ID)
+// the manifest recorded, or the file of the package version the manifest
+// recorded, extracted again. A backup failing its checksum is only restored,
+// with a warning, when none of these is found. Only binaries of the manifest
+// and wrappers are restored, so a forced unwrap never replaces another binary.
+func forceUnwrap(targetBinary string) error {
+	if realTarget, err := filepath.EvalSymlinks(targetBinary); err == nil {
+		targetBinary = realTarget
+	}
+	store := safeBinDir()
+	m, err := loadManifest(store)
+	if err != nil {
+		return err
+	}
+	entry, inManifest := m.findByPath(targetBinary)
+	backup := entry.Backup
+	if cfg, ok := readLauncherConfig(targetBinary); ok {
+		backup = cfg.Original
+	} else if content, err := os.ReadFile(targetBinary); err == nil {
+		if original := wrapperOriginal(content); original != "" {
+			backup = original
+		} else if !inManifest {
+			return fmt.Errorf("'%s' is neither a wrapper nor in the manifest of %s", targetBinary, store)
+		}
+	} else if !inManifest {
+		return err
+	}
+
+	source, mismatched := "", ""
+	if backup != "" && fileExists(backup) && !isWrapper(backup) {
+		if sum, _ := fileSHA256(backup); entry.SHA256 == "" || sum == entry.SHA256 {
+			source = backup
+		} else {
+			mismatched = backup
+		}
+	}
+	if source == "" {
+		// The checksum, when recorded, also tells a corrupted copy apart.
+		if entry.SHA256 != "" {
+			source = findBackup(store, func(path string) bool {
+				sum, _ := fileSHA256(path)
+				return sum == entry.SHA256
+			})
+		} else if entry.BuildID != "" {
+			source = findBackupByBuildID(store, entry.BuildID)
+		}
+	}
+	var restored string
+	if source == "" {
+		name, version := owningPackage(entry, targetBinary)
+		var err error
+		if name == "" {
+			err = fmt.Errorf("no backup of %s found in %s, and no package owns it", targetBinary, store)
+		} else if err = packageExtractor(targetBinary, name, version); err != nil {
+			err = fmt.Errorf("no backup of %s found in %s, and extracting it from %s %s failed: %w", targetBinary, store, name, version, err)
+		}
+		if err != nil && mismatched == "" {
+			return err
+		}
+		if err != nil {
+			// The only copy left, restored rather than none.
+			fmt.Printf("Warning: %v; restoring %s anyway, although its SHA-256 differs from the one recorded\n", err, mismatched)
+			source = mismatched
+		} else {
+			restored = "package " + strings.TrimSpace(name+" "+version)
+			if backup != "" {
+				_ = os.Remove(backup)
+				_ = os.Remove(filepath.Dir(backup))
+			}
+		}
+	}
+	if source != "" {
+		if real, err := filepath.EvalSymlinks(source); err == nil {
+			source = real
+		}
+		if err := move(source, targetBinary); err != nil {
+			return fmt.Errorf("could not restore original binary: %w", err)
+		}
+		_ = os.Remove(filepath.Dir(source))
+		restored = source
+	}
+	os.Remove(targetBinary + launcherSuffix)
+	if inManifest {
+		if err := updateManifest(store, func(m *Manifest) { m.remove(targetBinary) }); err != nil {
+			fmt.Printf("Warning: failed to update manifest in %s: %v\n", store, err)
+		}
+	}
+	fmt.Printf("Unwrapped %s (restored original from %s)\n", targetBinary, restored)
+	return nil
+}
+
+// owningPackage returns the package version the manifest recorded for the
+// binary, else the package owning it now.
+func owningPackage(entry ManifestEntry, targetBinary string) (name, version string) {
+	if entry.PackageName != "" {
+		return entry.PackageName, entry.PackageVersion
+	}
+	return packageVersionResolver(targetBinary)
+}
+
+// findBackup returns the first file of the backup store, wherever it was
+// moved to below the store, that match accepts, or "".
+func findBackup(store string, match func(path string) bool) string {
+	found := ""
+	filepath.WalkDir(store, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() || d.Name() == manifestFileName {
+			return nil
+		}
+		if match(path) {
+			found = path
+			return fs.SkipAll
+		}
+		return nil
+	})
+	return found
+}
+
+// findBackupByBuildID returns the binary of the backup store with the build ID.
+func findBackupByBuildID(store, buildID string) string {
+	return findBackup(store, func(path string) bool {
+		f, err := elf.Open(path)
+		if err != nil {
+			return false
+		}
+		id, _ := getBuildID(f)
+		f.Close()
+		return id == buildID
+	})
+}
+
+// packageExtractor is replaced in tests to avoid downloading packages.
+var packageExtractor = extractPackageFile
+
+// extractPackageFile downloads the version of a package with dnf, zypper or
+// apt-get, and writes its file path over the wrapper at path, with the mode
+// and owner the package gives it. The file capabilities rpm records and the
+// dpkg-statoverride entry of the file are applied as well.
+func extractPackageFile(path, name, version string) error {
+	tmp, err := os.MkdirTemp("", "funkoverage-package-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	root := filepath.Join(tmp, "root")
+	if err := os.Mkdir(root, 0700); err != nil {
+		return err
+	}
+	member := "." + path
+	var archive, unpack *exec.Cmd
+	var finish func() error
+	switch {
+	case commandExists("rpm2cpio") && (commandExists("dnf") || commandExists("zypper")):
+		nvr := name
+		if version != "" {
+			// Download names carry no epoch.
+			if _, v, ok := strings.Cut(version, ":"); ok {
+				version = v
+			}
+			nvr += "-" + version
+		}
+		if commandExists("dnf") {
+			if out, err := exec.Command("dnf", "download", "--destdir", tmp, nvr).CombinedOutput(); err != nil {
+				return fmt.Errorf("dnf download %s: %w: %s", nvr, err, out)
+			}
+		} else {
+			spec := name
+			if version != "" {
+				spec += "=" + version
+			}
+			if out, err := exec.Command("zypper", "--non-interactive", "--pkg-cache-dir", tmp, "download", spec).CombinedOutput(); err != nil {
+				return fmt.Errorf("zypper download %s: %w: %s", spec, err, out)
+			}
+		}
+		rpms, _ := filepath.Glob(filepath.Join(tmp, "*.rpm"))
+		if more, _ := filepath.Glob(filepath.Join(tmp, "*", "*", nvr+"*.rpm")); len(more) > 0 {
+			rpms = append(rpms, more...)
+		}
+		if len(rpms) == 0 {
+			return fmt.Errorf("no package %s downloaded", nvr)
+		}
+		archive = exec.Command("rpm2cpio", rpms[0])
+		unpack = exec.Command("cpio", "-idm", "--quiet", member)
+		// The archive has no room for them: rpm keeps them in its database.
+		finish = func() error {
+			if !commandExists("rpm") {
+				return nil
+			}
+			if out, err := exec.Command("rpm", "--setcaps", name).CombinedOutput(); err != nil {
+				return fmt.Errorf("rpm --setcaps %s: %w: %s", name, err, out)
+			}
+			return nil
+		}
+	case commandExists("apt-get") && commandExists("dpkg-deb"):
+		spec := name
+		if version != "" {
+			spec += "=" + version
+		}
+		cmd := exec.Command("apt-get", "download", spec)
+		cmd.Dir = tmp
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("apt-get download %s: %w: %s", spec, err, out)
+		}
+		debs, _ := filepath.Glob(filepath.Join(tmp, "*.deb"))
+		if len(debs) == 0 {
+			return fmt.Errorf("no package %s downloaded", spec)
+		}
+		archive = exec.Command("dpkg-deb", "--fsys-tarfile", debs[0])
+		unpack = exec.Command("tar", "-xp", "--same-owner", member)
+		finish = func() error { return applyStatOverride(path) }
+	default:
+		return errors.New("needs dnf or zypper with rpm2cpio, or apt-get with dpkg-deb")
+	}
+	unpack.Dir = root
+	if err := runPipe(archive, unpack); err != nil {
+		return err
+	}
+	if err := installExtractedFile(filepath.Join(root, member), path); err != nil {
+		return err
+	}
+	// The binary is in place by now: what is missing is only reported.
+	if err := finish(); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+	return nil
+}
+
+// runPipe runs from with its output piped into to, failing when either fails.
+func runPipe(from, to *exec.Cmd) error {
+	pipe, err := from.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	to.Stdin = pipe
+	var fromErr, toErr bytes.Buffer
+	from.Stderr, to.Stderr = &fromErr, &toErr
+	if err := from.Start(); err != nil {
+		return err
+	}
+	if err := to.Run(); err != nil {
+		// from may block on a pipe nobody reads anymore.
+		_ = from.Process.Kill()
+		_ = from.Wait()
+		return fmt.Errorf("%s: %w: %s", strings.Join(to.Args, " "), err, bytes.TrimSpace(toErr.Bytes()))
+	}
+	if err := from.Wait(); err != nil {
+		return fmt.Errorf("%s: %w: %s", strings.Join(from.Args, " "), err, bytes.TrimSpace(fromErr.Bytes()))
+	}
+	return nil
+}
+
+// installExtractedFile puts the file extracted from a package in place of the
+// wrapper at path, in one step, keeping its mode, setuid and setgid bits
+// included, and its owner.
+func installExtractedFile(extracted, path string) error {
+	info, err := os.Lstat(extracted)
+	if err != nil || !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not in the package", path)
+	}
+	content, err := os.ReadFile(extracted)
+	if err != nil {
+		return err
+	}
+	// Written aside, then renamed over the wrapper in one step.
+	staged := path + ".funkoverage-restore"
+	if err := os.WriteFile(staged, content, 0700); err != nil {
+		return err
+	}
+	err = setFileAttributes(staged, info)
+	if err == nil {
+		err = os.Rename(staged, path)
+	}
+	if err != nil {
+		os.Remove(staged)
+	}
+	return err
+}
+
+// setFileAttributes gives path the owner and mode of info. The owner goes
+// first, as changing it clears the setuid and setgid bits.
+func setFileAttributes(path string, info os.FileInfo) error {
+	if uid, gid, ok := fileOwner(info); ok && os.Geteuid() == 0 {
+		if err := os.Chown(path, uid, gid); err != nil {
+			return err
+		}
+	}
+	return os.Chmod(path, info.Mode()&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky))
+}
+
+// applyStatOverride applies the owner and mode dpkg-statoverride records for
+// path, if any, as dpkg does when it installs the file.
+func applyStatOverride(path string) error {
+	if !commandExists("dpkg-statoverride") {
+		return nil
+	}
+	// Exits 1 when the file has no override.
+	out, _ := exec.Command("dpkg-statoverride", "--list", path).Output()
+	fields := strings.Fields(string(out))
+	if len(fields) < 4 {
+		return nil
+	}
+	owner, err := user.Lookup(fields[0])
+	if err != nil {
+		return fmt.Errorf("dpkg-statoverride of %s: %w", path, err)
+	}
+	group, err := user.LookupGroup(fields[1])
+	if err != nil {
+		return fmt.Errorf("dpkg-statoverride of %s: %w", path, err)
+	}
+	uid, _ := strconv.Atoi(owner.Uid)
+	gid, _ := strconv.Atoi(group.Gid)
+	mode, err := strconv.ParseUint(fields[2], 8, 32)
+	if err != nil {
+		return fmt.Errorf("dpkg-statoverride of %s: invalid mode %q", path, fields[2])
+	}
+	if err := os.Chown(path, uid, gid); err != nil {
+		return err
+	}
+	return os.Chmod(path, unixFileMode(uint32(mode)))
+}
+
+// unixFileMode converts the permission bits of a Unix mode, setuid, setgid
+// and sticky included, to a FileMode.
+func unixFileMode(mode uint32) fs.FileMode {
+	m := fs.FileMode(mode) & fs.ModePerm
+	if mode&0o4000 != 0 {
+		m |= fs.ModeSetuid
+	}
+	if mode&0o2000 != 0 {
+		m |= fs.ModeSetgid
+	}
+	if mode&0o1000 != 0 {
+		m |= fs.ModeSticky
+	}
+	return m
+}
+
+func commandExists(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// --- Package Attribution ---
+
+// unpackagedGroup collects images no package manager claims.
+const unpackagedGroup = "(unpackaged)"
+
+var (
+	packageCacheMu sync.Mutex
+	packageCache   = map[string]string{}
+)
+
+// queryPackage asks rpm, then dpkg, which source package built path, so the
+// subpackages of one project (openssl and libopenssl3, for instance) are
+// reported together.
+func queryPackage(path string) string {
+	if _, err := exec.LookPath("rpm"); err == nil {
+		out, err := exec.Command("rpm", "-qf", "--queryformat", "%{NAME} %{SOURCERPM}\n", path).Output()
+		if err == nil {
+			if name := rpmSourcePackage(strings.SplitN(string(out), "\n", 2)[0]); name != "" {
+				return name
+			}
+		}
+	}
+	if _, err := exec.LookPath("dpkg"); err == nil {
+		// Output format: "package[:arch]: /path"
+		out, err := exec.Command("dpkg", "-S", path).Output()
+		if err == nil {
+			name, _, found := strings.Cut(strings.SplitN(string(out), "\n", 2)[0], ": ")
+			if found {
+				name = strings.Split(strings.Split(name, ",")[0], ":")[0]
+				out, err := exec.Command("dpkg-query", "-W", "-f", "${source:Package}", name).Output()
+				if source := strings.TrimSpace(string(out)); err == nil && source != "" {
+					return source
+				}
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// rpmSourcePackage returns the source package of an rpm query line of the form
+// "name name-version-release.src.rpm", the binary package name when the source
+// rpm is unknown.
+func rpmSourcePackage(line string) string {
+	name, srpm, _ := strings.Cut(strings.TrimSpace(line), " ")
+	srpm = strings.TrimSuffix(strings.TrimSuffix(srpm, ".rpm"), ".src")
+	srpm = strings.TrimSuffix(srpm, ".nosrc")
+	// Strip release, then version: neither contains a dash.
+	if i := strings.LastIndex(srpm, "-"); i > 0 {
+		if j := strings.LastIndex(srpm[:i], "-"); j > 0 {
+			return srpm[:j]
+		}
+	}
+	return name
+}
+
+// queryPackageVersion asks rpm, then dpkg, which binary package installed
+// path and its version ("[epoch:]version-release" for rpm).
+func queryPackageVersion(path string) (name, version string) {
+	if _, err := exec.LookPath("rpm"); err == nil {
+		out, err := exec.Command("rpm", "-qf", "--queryformat", "%{NAME} %|EPOCH?{%{EPOCH}:}:{}|%{VERSION}-%{RELEASE}\n", path).Output()
+		if err == nil {
+			if name, version, ok := strings.Cut(strings.SplitN(string(out), "\n", 2)[0], " "); ok {
+				return name, version
+			}
+		}
+	}
+	if _, err := exec.LookPath("dpkg"); err == nil {
+		out, err := exec.Command("dpkg", "-S", path).Output()
+		if err == nil {
+			if name, _, found := strings.Cut(strings.SplitN(string(out), "\n", 2)[0], ": "); found {
+				name = strings.Split(name, ",")[0]
+				out, err := exec.Command("dpkg-query", "-W", "-f", "${Version}", name).Output()
+				if err == nil {
+					return strings.Split(name, ":")[0], strings.TrimSpace(string(out))
+				}
+				return strings.Split(name, ":")[0], ""
+			}
+		}
+	}
+	return "", ""
+}
+
+// packageResolver and packageVersionResolver are replaced in tests to avoid
+// invoking the package manager.
+var (
+	packageResolver        = queryPackage
+	packageVersionResolver = queryPackageVersion
+)
+
+// resolvePackage returns the source package owning image, or "" if unknown.
+// Wrapped images take the package recorded in the wrap manifest; images living
+// in SAFE_BIN_DIR are otherwise looked up under their original path, since
+// package managers only know the installed location.
+func resolvePackage(image string, manifest *Manifest) string {
+	path := image
+	if manifest != nil {
+		if e, ok := manifest.findByImage(image); ok {
+			if e.Package != "" {
+				return e.Package
+			}
+			path = e.Path
+		}
+	}
+	if !filepath.IsAbs(path) {
+		return ""
+	}
+	packageCacheMu.Lock()
+	defer packageCacheMu.Unlock()
+	if name, ok := packageCache[path]; ok {
+		return name
+	}
+	name := packageResolver(path)
+	packageCache[path] = name
+	return name
+}
+
+// resolvePackages maps every image of the coverage data to its package.
+func resolvePackages(coverage map[string]*CoverageData) map[string]string {
+	manifest, _ := loadManifest(safeBinDir())
+	packages := make(map[string]string, len(coverage))
+	for image := range coverage {
+		if name := resolvePackage(image, manifest); name != "" {
+			packages[image] = name
+		}
+	}
+	return packages
+}
+
+// groupCoverageByPackage merges the images of each package into a single
+// entry keyed by package name. Function names are prefixed with their image so
+// identically named functions of different libraries stay distinct.
+func groupCoverageByPackage(coverage map[string]*CoverageData, packages map[string]string) map[string]*CoverageData {
+	grouped := make(map[string]*CoverageData)
+	for image, data := range coverage {
+		pkg, ok := packages[image]
+		if !ok {
+			pkg = unpackagedGroup
+		}
+		if _, ok := grouped[pkg]; !ok {
+			grouped[pkg] = newCoverageData()
+		}
+		prefix := filepath.Base(image) + ": "
+		for fn := range data.TotalFunctions {
+			grouped[pkg].TotalFunctions[prefix+fn] = struct{}{}
+		}
+		for fn := range data.CalledFunctions {
+			grouped[pkg].CalledFunctions[prefix+fn] = struct{}{}
+		}
+		for fn, n := range data.Calls {
+			grouped[pkg].addCalls(prefix+fn, n)
+		}
+		for e, n := range data.Edges {
+			grouped[pkg].addEdge(Edge{Caller: prefix + e.Caller, Callee: e.Callee}, n)
+		}
+		for fn, symbol := range data.Mangled {
+			grouped[pkg].addMangled(prefix+fn, symbol)
+		}
+		for fn, variants := range data.Variants {
+			if grouped[pkg].Variants == nil {
+				grouped[pkg].Variants = make(map[string][]string)
+			}
+			grouped[pkg].Variants[prefix+fn] = variants
+		}
+	}
+	return grouped
+}
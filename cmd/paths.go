@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// --- Image Path Normalization ---
+
+// PathMapping rewrites the Old prefix of an image path to New, e.g. to drop
+// the mount point of a chroot or container the logs were produced in.
+type PathMapping struct {
+	Old string
+	New string
+}
+
+// parsePathMapping parses an "old=new" --path-map argument.
+func parsePathMapping(s string) (PathMapping, error) {
+	old, new, ok := strings.Cut(s, "=")
+	if !ok || old == "" {
+		return PathMapping{}, fmt.Errorf("invalid path mapping %q, expected old=new", s)
+	}
+	return PathMapping{Old: filepath.Clean(old), New: new}, nil
+}
+
+// pathMapFlag collects repeated --path-map flags.
+type pathMapFlag []PathMapping
+
+func (f *pathMapFlag) String() string {
+	parts := make([]string, len(*f))
+	for i, m := range *f {
+		parts[i] = m.Old + "=" + m.New
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f *pathMapFlag) Set(s string) error {
+	m, err := parsePathMapping(s)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, m)
+	return nil
+}
+
+// usrMergeDirs are the top-level directories merged into /usr on UsrMerge
+// systems, where /bin/ls and /usr/bin/ls are the same file.
+var usrMergeDirs = []string{"/bin", "/sbin", "/lib", "/lib64", "/lib32", "/libx32"}
+
+// trimPathPrefix returns path without prefix if prefix is one of its leading
+// directories.
+func trimPathPrefix(path, prefix string) (string, bool) {
+	if prefix == "/" {
+		return path, strings.HasPrefix(path, "/")
+	}
+	rest, ok := strings.CutPrefix(path, prefix)
+	if !ok || (rest != "" && !strings.HasPrefix(rest, "/")) {
+		return path, false
+	}
+	return rest, true
+}
+
+// normalizeImagePath maps an image path to the canonical name it is reported
+// under: the first matching --path-map applies, then the UsrMerge directories
+// are folded into /usr and finally symlinks are resolved when the file exists
+// on this host. Non-absolute names, such as [vdso], are kept as they are.
+func normalizeImagePath(path string, mappings []PathMapping) string {
+	for _, m := range mappings {
+		if rest, ok := trimPathPrefix(path, m.Old); ok {
+			path = m.New + rest
+			break
+		}
+	}
+	if !filepath.IsAbs(path) {
+		return path
+	}
+	path = filepath.Clean(path)
+	for _, dir := range usrMergeDirs {
+		if rest, ok := trimPathPrefix(path, dir); ok {
+			path = "/usr" + dir + rest
+			break
+		}
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	return path
+}
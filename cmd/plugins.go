@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+)
+
+// --- dlopen'd Plugins ---
+
+// pluginMarker follows the image of the line marking it as loaded with
+// dlopen, and precedes the program loading it (format 10).
+var pluginMarker = []byte("] [Plugin:")
+
+// parsePluginLine returns the image and loading program of a plugin line.
+func parsePluginLine(line []byte) (image []byte, loader string, ok bool) {
+	i := bytes.Index(line, pluginMarker)
+	if i < 0 {
+		return nil, "", false
+	}
+	start := bytes.Index(line[:i], imageMarker)
+	if start < 0 {
+		return nil, "", false
+	}
+	rest := line[i+len(pluginMarker):]
+	j := bytes.LastIndexByte(rest, ']')
+	if j <= 0 {
+		return nil, "", false
+	}
+	return unescapeField(line[start+len(imageMarker) : i]), string(unescapeField(rest[:j])), true
+}
+
+// imagePlugins maps the reported images loaded with dlopen to the base names
+// of the programs loading them, sorted. An image some programs link against
+// and others load as a plugin is a plugin of the latter.
+func imagePlugins(coverage map[string]*CoverageData, stats []LogStats) map[string][]string {
+	loaders := make(map[string]map[string]bool)
+	for _, s := range stats {
+		if s.DuplicateOf != "" {
+			continue
+		}
+		for image, loader := range s.Plugins {
+			key := archImageKey(image, s.Archs[image])
+			if _, ok := coverage[key]; !ok {
+				continue
+			}
+			if loaders[key] == nil {
+				loaders[key] = make(map[string]bool)
+			}
+			loaders[key][filepath.Base(loader)] = true
+		}
+	}
+	plugins := make(map[string][]string, len(loaders))
+	for image, names := range loaders {
+		plugins[image] = sortedKeys(names)
+	}
+	return plugins
+}
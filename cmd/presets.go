@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// --- Function Filter Presets ---
+
+// FunctionPreset is a named set of functions report --preset leaves out of
+// the coverage, such as the library code instantiated into every image.
+type FunctionPreset struct {
+	Name        string
+	Description string
+	// Namespaces are the outermost C++ namespaces whose functions are left out.
+	Namespaces []string
+}
+
+// functionPresets are the presets of report --preset.
+var functionPresets = []FunctionPreset{
+	{
+		// Header-only instantiations of the standard library, the containers
+		// first, otherwise dominate the uncalled functions of C++ images.
+		Name:        "cxx-internals",
+		Description: "C++ standard library internals: std::, __gnu_cxx:: and __cxxabiv1::, with the standard containers instantiated in them",
+		Namespaces:  []string{"std", "__gnu_cxx", "__cxxabiv1"},
+	},
+}
+
+// lookupFunctionPresets returns the presets of the given names.
+func lookupFunctionPresets(names []string) ([]FunctionPreset, error) {
+	var presets []FunctionPreset
+	for _, name := range names {
+		i := slices.IndexFunc(functionPresets, func(p FunctionPreset) bool { return p.Name == name })
+		if i < 0 {
+			known := make([]string, len(functionPresets))
+			for j, p := range functionPresets {
+				known[j] = p.Name
+			}
+			return nil, fmt.Errorf("unknown preset %q, expected %s", name, strings.Join(known, ", "))
+		}
+		presets = append(presets, functionPresets[i])
+	}
+	return presets, nil
+}
+
+// excludes reports whether the preset leaves out a function, by its demangled
+// name: template functions, which demangle with their return type, included.
+func (p FunctionPreset) excludes(function string) bool {
+	scope, _ := splitScope(function)
+	return len(scope) > 0 && slices.Contains(p.Namespaces, scope[0])
+}
+
+// excludePresetFunctions removes the functions the presets leave out from
+// coverage, with their calls and call-graph edges.
+func excludePresetFunctions(coverage map[string]*CoverageData, presets []FunctionPreset) {
+	if len(presets) == 0 {
+		return
+	}
+	excluded := func(fn string) bool {
+		return slices.ContainsFunc(presets, func(p FunctionPreset) bool { return p.excludes(fn) })
+	}
+	for _, data := range coverage {
+		// Called functions may lack a Function entry.
+		for _, functions := range []map[string]struct{}{data.TotalFunctions, data.CalledFunctions} {
+			for fn := range functions {
+				if !excluded(fn) {
+					continue
+				}
+				delete(data.TotalFunctions, fn)
+				delete(data.CalledFunctions, fn)
+				delete(data.Calls, fn)
+				delete(data.Mangled, fn)
+				delete(data.Variants, fn)
+			}
+		}
+		for e := range data.Edges {
+			if excluded(e.Caller) || excluded(e.Callee) {
+				delete(data.Edges, e)
+			}
+		}
+	}
+}
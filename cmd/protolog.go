@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// --- Protobuf Logs ---
+
+// protoRecordPrefix starts every record of a log written with FuncTracer
+// -proto (or FUNKOVERAGE_PROTO=1): the tag of the events field (16) of the
+// Log message of proto/funkoverage_log.proto, which holds one Event.
+var protoRecordPrefix = []byte{0x82, 0x01}
+
+// The fields of the Event message, one per kind of event.
+const (
+	protoHeader = iota + 1
+	protoContext
+	protoFunction
+	protoCalled
+	protoEdge
+	protoFirstCall
+	protoImage
+	protoExit
+)
+
+// protoEventNames names the events of the fields of the Event message like
+// the JSON-lines logs do.
+var protoEventNames = [...][]byte{
+	protoHeader:    []byte("header"),
+	protoContext:   []byte("context"),
+	protoFunction:  []byte("function"),
+	protoCalled:    []byte("called"),
+	protoEdge:      []byte("edge"),
+	protoFirstCall: []byte("first_call"),
+	protoImage:     []byte("image"),
+	protoExit:      []byte("exit"),
+}
+
+// isProtoLog reports whether the log read by r starts with a protobuf record.
+func isProtoLog(r *bufio.Reader) bool {
+	prefix, _ := r.Peek(len(protoRecordPrefix))
+	return bytes.Equal(prefix, protoRecordPrefix)
+}
+
+// protoScanner reads the records of a protobuf log one by one.
+type protoScanner struct {
+	r      *bufio.Reader
+	record []byte
+	// truncated is set when the log ends within a record or goes on with
+	// something else, which a log still being written may do.
+	truncated bool
+	err       error
+}
+
+func (s *protoScanner) scan() bool {
+	prefix, err := s.r.Peek(len(protoRecordPrefix))
+	if len(prefix) == 0 && err == io.EOF {
+		return false
+	}
+	if err != nil && err != io.EOF {
+		s.err = err
+		return false
+	}
+	if !bytes.Equal(prefix, protoRecordPrefix) {
+		s.truncated = true
+		return false
+	}
+	s.r.Discard(len(prefix))
+	n, err := binary.ReadUvarint(s.r)
+	if err != nil || n > maxLogLineSize {
+		s.truncated = true
+		return false
+	}
+	if uint64(cap(s.record)) < n {
+		s.record = make([]byte, n)
+	}
+	s.record = s.record[:n]
+	if _, err := io.ReadFull(s.r, s.record); err != nil {
+		if !errors.Is(err, io.ErrUnexpectedEOF) && err != io.EOF {
+			s.err = err
+		}
+		s.truncated = true
+		return false
+	}
+	return true
+}
+
+// endsMidRecord reports whether the protobuf log read by r ends within a
+// record.
+func endsMidRecord(r *bufio.Reader) bool {
+	s := &protoScanner{r: r}
+	for s.scan() {
+	}
+	return s.truncated
+}
+
+// protoField splits the first field off an encoded message: its number, and
+// its value as a varint (wire type 0) or as bytes (wire type 2). Fixed-size
+// values are skipped over.
+func protoField(b []byte) (field int, num uint64, data, rest []byte, ok bool) {
+	tag, n := binary.Uvarint(b)
+	if n <= 0 {
+		return 0, 0, nil, nil, false
+	}
+	b = b[n:]
+	field = int(tag >> 3)
+	switch tag & 7 {
+	case 0:
+		if num, n = binary.Uvarint(b); n <= 0 {
+			return 0, 0, nil, nil, false
+		}
+		return field, num, nil, b[n:], true
+	case 1:
+		if len(b) < 8 {
+			return 0, 0, nil, nil, false
+		}
+		return field, 0, nil, b[8:], true
+	case 2:
+		size, n := binary.Uvarint(b)
+		if n <= 0 || uint64(len(b)-n) < size {
+			return 0, 0, nil, nil, false
+		}
+		return field, 0, b[n : n+int(size)], b[n+int(size):], true
+	case 5:
+		if len(b) < 4 {
+			return 0, 0, nil, nil, false
+		}
+		return field, 0, nil, b[4:], true
+	}
+	return 0, 0, nil, nil, false
+}
+
+// decodeProtoEvent decodes the Event message of a record into ev, reporting
+// whether it holds an event. Fields unknown to this version are skipped.
+func decodeProtoEvent(record []byte, ev *logEvent) bool {
+	*ev = logEvent{}
+	for len(record) > 0 {
+		kind, _, message, rest, ok := protoField(record)
+		if !ok {
+			return false
+		}
+		record = rest
+		if kind <= 0 || kind >= len(protoEventNames) || message == nil {
+			continue
+		}
+		ev.Event = protoEventNames[kind]
+		for len(message) > 0 {
+			field, num, data, rest, ok := protoField(message)
+			if !ok {
+				return false
+			}
+			message = rest
+			ev.setProtoField(kind, field, num, data)
+		}
+	}
+	return len(ev.Event) > 0
+}
+
+// setProtoField sets the member of ev holding a field of the message of an
+// event of the given kind.
+func (ev *logEvent) setProtoField(kind, field int, num uint64, data []byte) {
+	switch kind {
+	case protoHeader:
+		switch field {
+		case 1:
+			ev.Format = num
+		case 2:
+			ev.Tool = data
+		case 3:
+			ev.Run = data
+		case 4:
+			ev.Sample = data
+		case 5:
+			ev.Session = data
+		case 6:
+			ev.Tags = append(ev.Tags, string(data))
+		case 7:
+			ev.Options = data
+		case 8:
+			ev.Import = data
+		}
+	case protoContext:
+		switch field {
+		case 1:
+			ev.Time = data
+		case 2:
+			ev.Host = data
+		case 3:
+			ev.Cwd = data
+		case 4:
+			ev.Args = append(ev.Args, string(data))
+		case 5:
+			ev.Env = append(ev.Env, string(data))
+		}
+	case protoFunction, protoCalled:
+		switch field {
+		case 1:
+			ev.Image = data
+		case 2:
+			ev.setAddr(num)
+		case 3:
+			ev.Count = num
+		case 4:
+			ev.Function = data
+		}
+	case protoEdge:
+		switch field {
+		case 1:
+			ev.Image = data
+		case 2:
+			ev.Count = num
+		case 3:
+			ev.Caller = data
+		case 4:
+			ev.Callee = data
+		}
+	case protoFirstCall:
+		switch field {
+		case 1:
+			ev.Image = data
+		case 2:
+			ev.setAddr(num)
+		case 3:
+			ev.Pid = num
+		case 4:
+			ev.Tid = num
+		case 5:
+			ev.TimeNs = num
+		case 6:
+			ev.Function = data
+		}
+	case protoImage:
+		switch field {
+		case 1:
+			ev.Image = data
+		case 2:
+			ev.BuildID = data
+		case 3:
+			ev.Arch = data
+		case 4:
+			ev.Loader = data
+		}
+	case protoExit:
+		switch field {
+		case 1:
+			ev.Code = num
+		case 2:
+			ev.Signal = num
+		case 3:
+			ev.WallMs = num
+		case 4:
+			ev.Started = num
+		}
+	}
+}
+
+// setAddr sets the address of ev as the text logs write it, "0x4f10".
+func (ev *logEvent) setAddr(addr uint64) {
+	if addr != 0 {
+		ev.Addr = strconv.AppendUint(append(ev.addr[:0], "0x"...), addr, 16)
+	}
+}
+
+// appendProtoExit appends the trailer of a protobuf log to b: a record
+// holding the Exit event of an invocation, which the Windows launcher appends
+// once Pin exited.
+func appendProtoExit(b []byte, code int, wall time.Duration, started time.Time) []byte {
+	var exit []byte
+	for _, f := range []struct {
+		field int
+		value uint64
+	}{{1, uint64(int64(code))}, {3, uint64(wall.Milliseconds())}, {4, uint64(started.UnixNano())}} {
+		if f.value != 0 {
+			exit = binary.AppendUvarint(binary.AppendUvarint(exit, uint64(f.field)<<3), f.value)
+		}
+	}
+	event := appendProtoBytes(nil, protoExit, exit)
+	return appendProtoBytes(b, 16, event)
+}
+
+// appendProtoBytes appends a length-delimited field to b.
+func appendProtoBytes(b []byte, field int, value []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|2)
+	return append(binary.AppendUvarint(b, uint64(len(value))), value...)
+}
+
+// parseProtoLog records the events of a protobuf log like parseFile records
+// the lines of a text log. A record cut short counts as a malformed line,
+// unless the log is still being written.
+func (a *logAnalyzer) parseProtoLog(logFile string, r *bufio.Reader, coverage map[string]*CoverageData, stats *LogStats, live bool) error {
+	s := &protoScanner{r: r}
+	var ev logEvent
+	for s.scan() {
+		stats.Lines++
+		if !decodeProtoEvent(s.record, &ev) {
+			stats.Malformed++
+			continue
+		}
+		if err := a.addEvent(&ev, coverage, stats); err != nil {
+			return fmt.Errorf("log file %s uses %w", logFile, err)
+		}
+	}
+	if s.err != nil {
+		return fmt.Errorf("could not read log file %s: %w", logFile, s.err)
+	}
+	if s.truncated && !live {
+		stats.Lines++
+		stats.Malformed++
+	}
+	return nil
+}
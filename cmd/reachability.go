@@ -0,0 +1,365 @@
+package main
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// --- Static Reachability ---
+
+// An uncalled function is not necessarily missing a test: it may be dead
+// code, or only be reachable from a plugin calling back into the program.
+// The static call graph of an image tells them apart. It is built from the
+// direct calls and jumps of its code, and from the function addresses its
+// code and data take, which count as calls of the functions taking them.
+// It over-approximates: an unreachable function is unreachable for sure, as
+// far as code outside the image does not call it by name.
+
+// The reachability classes of a function.
+const (
+	reachableFromMain     = "reachable from main"
+	reachableViaPlugins   = "only reachable via plugin entry points"
+	apparentlyUnreachable = "apparently unreachable"
+)
+
+// reachabilityClasses lists the classes in the order of the text report.
+var reachabilityClasses = []string{reachableFromMain, reachableViaPlugins, apparentlyUnreachable}
+
+var (
+	reachabilityMu    sync.Mutex
+	reachabilityCache = map[string]map[string]string{}
+)
+
+// staticReachability returns the reachability class of the functions of
+// image, by symbol. The functions of an executable are reachable from main
+// when its entry point, main, its constructors and destructors or its data
+// lead to them, and via plugins when only its exported functions, which
+// plugins it loads can call, do. For a library, its exported functions are
+// its main. It returns nil for an image that is no longer readable, or whose
+// machine code is not analyzed (x86, x86-64 and AArch64 are).
+func staticReachability(image string) map[string]string {
+	reachabilityMu.Lock()
+	defer reachabilityMu.Unlock()
+	if classes, ok := reachabilityCache[image]; ok {
+		return classes
+	}
+	var classes map[string]string
+	if f, err := elf.Open(image); err == nil {
+		classes = newCallGraph(f).classify()
+		f.Close()
+	}
+	reachabilityCache[image] = classes
+	return classes
+}
+
+// callGraph is the static call graph between the functions of an image.
+type callGraph struct {
+	f         *elf.File
+	functions []elf.Symbol
+	// start indexes the functions by address; aliases share an index.
+	start map[uint64]int
+	// calls holds the functions each function calls or takes the address of.
+	calls [][]int
+	// referenced are the functions whose address the data of the image holds.
+	referenced map[int]bool
+	supported  bool
+}
+
+func newCallGraph(f *elf.File) *callGraph {
+	g := &callGraph{f: f, start: map[uint64]int{}, referenced: map[int]bool{}}
+	switch f.Machine {
+	case elf.EM_X86_64, elf.EM_386, elf.EM_AARCH64:
+		g.supported = true
+	default:
+		return g
+	}
+	syms, err := f.Symbols()
+	if err != nil || len(syms) == 0 {
+		syms, _ = f.DynamicSymbols()
+	}
+	for _, sym := range syms {
+		t := elf.ST_TYPE(sym.Info)
+		if (t != elf.STT_FUNC && t != elf.STT_GNU_IFUNC) || sym.Section == elf.SHN_UNDEF || sym.Value == 0 {
+			continue
+		}
+		g.functions = append(g.functions, sym)
+	}
+	sort.SliceStable(g.functions, func(i, j int) bool { return g.functions[i].Value < g.functions[j].Value })
+	for i, sym := range g.functions {
+		if _, ok := g.start[sym.Value]; !ok {
+			g.start[sym.Value] = i
+		}
+	}
+	g.calls = make([][]int, len(g.functions))
+	for _, sec := range f.Sections {
+		if sec.Type == elf.SHT_NOBITS || sec.Flags&elf.SHF_ALLOC == 0 {
+			continue
+		}
+		data, err := sec.Data()
+		if err != nil {
+			continue
+		}
+		switch {
+		case sec.Flags&elf.SHF_EXECINSTR != 0:
+			g.scanCode(sec.Addr, data)
+		case sec.Type == elf.SHT_RELA:
+			g.scanRelocations(data)
+		case sec.Type == elf.SHT_PROGBITS, sec.Type == elf.SHT_DYNAMIC, sec.Type == elf.SHT_INIT_ARRAY,
+			sec.Type == elf.SHT_FINI_ARRAY, sec.Type == elf.SHT_PREINIT_ARRAY:
+			// Not the symbol tables, whose values are no references.
+			g.scanData(data)
+		}
+	}
+	return g
+}
+
+// function returns the index of the function starting at addr.
+func (g *callGraph) function(addr uint64) (int, bool) {
+	i, ok := g.start[addr]
+	return i, ok
+}
+
+// scanCode adds the calls of the functions of a code section.
+func (g *callGraph) scanCode(addr uint64, data []byte) {
+	for i, sym := range g.functions {
+		if sym.Size == 0 || sym.Value < addr || sym.Value+sym.Size > addr+uint64(len(data)) || g.start[sym.Value] != i {
+			continue
+		}
+		code := data[sym.Value-addr : sym.Value-addr+sym.Size]
+		var targets []uint64
+		if g.f.Machine == elf.EM_AARCH64 {
+			targets = arm64Targets(sym.Value, code)
+		} else {
+			targets = x86Targets(sym.Value, code, g.f.Type == elf.ET_EXEC)
+		}
+		for _, target := range targets {
+			if callee, ok := g.function(target); ok && callee != i {
+				g.calls[i] = append(g.calls[i], callee)
+			}
+		}
+	}
+}
+
+// x86Targets returns the function addresses the code at pc may refer to: the
+// targets of rel32 calls and jumps, of RIP-relative operands, and, in code
+// that is not position-independent, absolute 32-bit immediates. Instructions
+// are not decoded, so a target may come from the middle of another
+// instruction; it only makes more functions reachable.
+func x86Targets(pc uint64, code []byte, absolute bool) []uint64 {
+	var targets []uint64
+	for i := 1; i+4 <= len(code); i++ {
+		word := binary.LittleEndian.Uint32(code[i:])
+		rel := uint64(int64(int32(word)))
+		op := code[i-1]
+		switch {
+		case op == 0xe8 || op == 0xe9, // call, jmp
+			i >= 2 && code[i-2] == 0x0f && op&0xf0 == 0x80, // jcc
+			op&0xc7 == 0x05: // ModRM of a RIP-relative operand
+			targets = append(targets, pc+uint64(i)+4+rel)
+		}
+		if absolute {
+			targets = append(targets, uint64(word))
+		}
+	}
+	return targets
+}
+
+// arm64Targets returns the targets of the B and BL instructions of the code
+// at pc, and the addresses ADR and ADRP+ADD compute.
+func arm64Targets(pc uint64, code []byte) []uint64 {
+	var targets []uint64
+	var pages [32]uint64
+	signExtend := func(v uint32, bits uint) uint64 {
+		return uint64(int64(v<<(32-bits)) >> (32 - bits))
+	}
+	for i := 0; i+4 <= len(code); i += 4 {
+		insn := binary.LittleEndian.Uint32(code[i:])
+		at := pc + uint64(i)
+		imm21 := (insn>>5&0x7ffff)<<2 | insn>>29&3
+		switch {
+		case insn&0x7c000000 == 0x14000000: // b, bl
+			targets = append(targets, at+signExtend(insn&0x3ffffff, 26)<<2)
+		case insn&0x9f000000 == 0x10000000: // adr
+			targets = append(targets, at+signExtend(imm21, 21))
+		case insn&0x9f000000 == 0x90000000: // adrp
+			pages[insn&31] = at&^0xfff + signExtend(imm21, 21)<<12
+		case insn&0xff800000 == 0x91000000: // add (immediate)
+			imm := uint64(insn >> 10 & 0xfff)
+			if insn>>22&1 != 0 {
+				imm <<= 12
+			}
+			targets = append(targets, pages[insn>>5&31]+imm)
+		}
+	}
+	return targets
+}
+
+// scanData records the functions whose address a data section holds, like
+// function pointer tables, vtables and .init_array.
+func (g *callGraph) scanData(data []byte) {
+	size := 8
+	if g.f.Class == elf.ELFCLASS32 {
+		size = 4
+	}
+	for i := 0; i+size <= len(data); i += size {
+		var addr uint64
+		if size == 8 {
+			addr = g.f.ByteOrder.Uint64(data[i:])
+		} else {
+			addr = uint64(g.f.ByteOrder.Uint32(data[i:]))
+		}
+		if callee, ok := g.function(addr); ok {
+			g.referenced[callee] = true
+		}
+	}
+}
+
+// scanRelocations records the functions the dynamic relocations of a
+// position-independent image point to, as their data only holds zeros.
+func (g *callGraph) scanRelocations(data []byte) {
+	if g.f.Class != elf.ELFCLASS64 {
+		return
+	}
+	dynsyms, _ := g.f.DynamicSymbols()
+	for i := 0; i+24 <= len(data); i += 24 {
+		info := g.f.ByteOrder.Uint64(data[i+8:])
+		addr := g.f.ByteOrder.Uint64(data[i+16:])
+		if sym := int(info >> 32); sym > 0 {
+			if sym > len(dynsyms) || dynsyms[sym-1].Section == elf.SHN_UNDEF {
+				continue
+			}
+			addr += dynsyms[sym-1].Value
+		}
+		if callee, ok := g.function(addr); ok {
+			g.referenced[callee] = true
+		}
+	}
+}
+
+// classify returns the reachability class of each function, by symbol.
+func (g *callGraph) classify() map[string]string {
+	if !g.supported {
+		return nil
+	}
+	executable := false
+	for _, prog := range g.f.Progs {
+		if prog.Type == elf.PT_INTERP {
+			executable = true
+		}
+	}
+	var roots, plugins []int
+	for callee := range g.referenced {
+		roots = append(roots, callee)
+	}
+	if i, ok := g.function(g.f.Entry); ok {
+		roots = append(roots, i)
+	}
+	for i, sym := range g.functions {
+		if g.start[sym.Value] != i {
+			continue
+		}
+		if sym.Name == "main" {
+			roots = append(roots, i)
+		}
+	}
+	if dynsyms, err := g.f.DynamicSymbols(); err == nil {
+		for _, sym := range dynsyms {
+			t := elf.ST_TYPE(sym.Info)
+			if (t != elf.STT_FUNC && t != elf.STT_GNU_IFUNC) || sym.Section == elf.SHN_UNDEF {
+				continue
+			}
+			if i, ok := g.function(sym.Value); !ok {
+				continue
+			} else if executable {
+				plugins = append(plugins, i)
+			} else {
+				roots = append(roots, i)
+			}
+		}
+	}
+	fromMain, fromPlugins := g.reach(roots), g.reach(plugins)
+	classes := make(map[string]string, len(g.functions))
+	for _, sym := range g.functions {
+		i := g.start[sym.Value]
+		switch {
+		case fromMain[i]:
+			classes[sym.Name] = reachableFromMain
+		case fromPlugins[i]:
+			classes[sym.Name] = reachableViaPlugins
+		default:
+			classes[sym.Name] = apparentlyUnreachable
+		}
+	}
+	return classes
+}
+
+// reach returns the functions the roots lead to, the roots included.
+func (g *callGraph) reach(roots []int) map[int]bool {
+	seen := map[int]bool{}
+	for len(roots) > 0 {
+		i := roots[len(roots)-1]
+		roots = roots[:len(roots)-1]
+		if seen[i] {
+			continue
+		}
+		seen[i] = true
+		roots = append(roots, g.calls[i]...)
+	}
+	return seen
+}
+
+// reachabilityOf returns the reachability class of the function fn of data,
+// from the classes of its image.
+func reachabilityOf(fn string, data *CoverageData, classes map[string]string) (string, bool) {
+	symbol := fn
+	if mangled, ok := data.Mangled[fn]; ok {
+		symbol = mangled
+	}
+	if i := strings.LastIndex(symbol, " [0x"); i > 0 && strings.HasSuffix(symbol, "]") {
+		symbol = symbol[:i]
+	}
+	symbol, _ = splitSymbolVersion(symbol)
+	class, ok := classes[symbol]
+	return class, ok
+}
+
+// printReachability groups the uncalled functions of each image by their
+// static reachability. Functions the analysis does not know are left out.
+func printReachability(coverage map[string]*CoverageData) {
+	fmt.Println("\n=== Static Reachability of Uncalled Functions ====")
+	for _, image := range sortedKeys(coverage) {
+		data := coverage[image]
+		classes := staticReachability(image)
+		if classes == nil {
+			continue
+		}
+		groups := map[string][]string{}
+		for _, fn := range sortedKeys(data.TotalFunctions) {
+			if _, called := data.CalledFunctions[fn]; called {
+				continue
+			}
+			if class, ok := reachabilityOf(fn, data, classes); ok {
+				groups[class] = append(groups[class], fn)
+			}
+		}
+		if len(groups) == 0 {
+			continue
+		}
+		fmt.Printf("  %s:\n", filepath.Base(image))
+		for _, class := range reachabilityClasses {
+			if len(groups[class]) == 0 {
+				continue
+			}
+			fmt.Printf("    %s (%d):\n", class, len(groups[class]))
+			for _, fn := range groups[class] {
+				fmt.Printf("      - %s\n", printableSymbol(fn))
+			}
+		}
+	}
+	fmt.Println("==================================================")
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"debug/elf"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// --- Recovery ---
+
+// A system restore or the redeploy of a golden image puts the original
+// binaries back in place of their wrappers. recover wraps them again as the
+// manifest records, provided they are the builds that were wrapped.
+
+// verifyRecorded tells why the binary at e.Path is not the build the entry
+// was wrapped from, or "" when it is, or when the entry recorded nothing to
+// tell them apart.
+func verifyRecorded(e ManifestEntry) string {
+	if e.BuildID != "" {
+		f, err := elf.Open(e.Path)
+		if err != nil {
+			return fmt.Sprintf("not an ELF binary: %v", err)
+		}
+		id, _ := getBuildID(f)
+		f.Close()
+		if id != e.BuildID {
+			return fmt.Sprintf("build ID %s, wrapped was %s", id, e.BuildID)
+		}
+		return ""
+	}
+	if e.PackageName != "" && e.PackageVersion != "" {
+		if name, version := packageVersionResolver(e.Path); name != e.PackageName || version != e.PackageVersion {
+			return fmt.Sprintf("package %s %s, wrapped was %s %s", name, version, e.PackageName, e.PackageVersion)
+		}
+	}
+	return ""
+}
+
+// recoverWrappers wraps again the binaries of the manifest file that are no
+// longer wrapped, with the options they were wrapped with, into the backup
+// store safeBinDir. Binaries that are missing, or another build than the one
+// wrapped, are skipped.
+func recoverWrappers(manifestFile, safeBinDir string) error {
+	m, err := loadManifestFile(manifestFile)
+	if err != nil {
+		return fmt.Errorf("could not read the manifest %s: %w", manifestFile, err)
+	}
+	var failed []string
+	for _, e := range m.Entries {
+		if _, err := os.Lstat(e.Path); err != nil {
+			fmt.Printf("Skipped %s: %v\n", e.Path, err)
+			continue
+		}
+		if isWrapper(e.Path) {
+			fmt.Printf("Skipped %s: already wrapped\n", e.Path)
+			continue
+		}
+		if reason := verifyRecorded(e); reason != "" {
+			fmt.Printf("Skipped %s: %s\n", e.Path, reason)
+			continue
+		}
+		opts, err := e.wrapOptions()
+		if err == nil {
+			err = wrap(e.Path, opts)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "recover error for %s: %v\n", e.Path, err)
+			failed = append(failed, e.Path)
+			continue
+		}
+		// The backup of the previous wrap, restored along with the store.
+		if dir := filepath.Dir(e.Backup); filepath.Dir(dir) == filepath.Clean(safeBinDir) {
+			if _, err := os.Stat(dir); err == nil {
+				os.RemoveAll(dir)
+			}
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to wrap again: %v", failed)
+	}
+	return nil
+}
@@ -2,128 +2,1716 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"html/template"
+	"iter"
 	"os"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
-
-	"github.com/ianlancetaylor/demangle"
+	"unicode"
 )
 
 type CoverageData struct {
 	TotalFunctions  map[string]struct{}
 	CalledFunctions map[string]struct{}
+	// Calls counts the calls of the called functions; logs older than
+	// format 5 carry no counts, so it may be partial or empty.
+	Calls map[string]uint64
+	// Edges counts the calls along each caller -> callee edge (format 6 logs
+	// written with FuncTracer -edges).
+	Edges map[Edge]uint64
+	// Variants are the constructor and destructor variants (C1, D0...) that
+	// ran, per function.
+	Variants map[string][]string
+	// Mangled maps the functions reported under another name to the symbol
+	// they were logged as, as nm and objdump list it.
+	Mangled map[string]string
+	// Copies are the other paths the same binary was traced at, merged into
+	// the image (see mergeDuplicateImages).
+	Copies []string
+	// Files are the paths of the library files reported under the soname of
+	// the image (see normalizeSonames).
+	Files []string
+	// Inlined maps the functions covered via inlining to the called functions
+	// they were inlined into (see markInlinedFunctions).
+	Inlined map[string][]string
+}
+
+func newCoverageData() *CoverageData {
+	return &CoverageData{TotalFunctions: make(map[string]struct{}), CalledFunctions: make(map[string]struct{})}
+}
+
+// addCalls adds n calls of fn.
+func (d *CoverageData) addCalls(fn string, n uint64) {
+	if n == 0 {
+		return
+	}
+	if d.Calls == nil {
+		d.Calls = make(map[string]uint64)
+	}
+	d.Calls[fn] += n
+}
+
+// addMangled records symbol as the mangled name of fn. Functions merged from
+// several symbols keep the first one in order, for stable reports.
+func (d *CoverageData) addMangled(fn, symbol string) {
+	if d.Mangled == nil {
+		d.Mangled = make(map[string]string)
+	}
+	if old, ok := d.Mangled[fn]; !ok || symbol < old {
+		d.Mangled[fn] = symbol
+	}
+}
+
+type FunctionEntry struct {
+	Name   string
+	Status string // "called" or "uncalled"
+	// Source links to the definition in the annotated source view, and URL
+	// to the repository, if any.
+	Source string
+	URL    string
+	// Change versus the baseline: "new", "regressed" or "unchanged", if any.
+	Change string
+	// Mangled is the symbol of a demangled C++ function.
+	Mangled string
+	// InlinedInto lists the called functions a function covered via
+	// inlining was inlined into.
+	InlinedInto string
+}
+
+// HTMLOptions are the settings of the detailed HTML reports.
+type HTMLOptions struct {
+	// Top is how many of the most called functions are listed; 0 disables the section.
+	Top int
+	// NamespaceDepth is how many levels of C++ namespaces the coverage is summed up by.
+	NamespaceDepth int
+	// Sources locate the source code the functions link to.
+	Sources SourceOptions
+	// Baseline is the state of a previous run the report is compared with.
+	Baseline *CoverageState
+	// Compared is a saved report the coverage is compared with, nil without --compare.
+	Compared *SavedReport
+	// Images are the images of the run getting a detailed page, sorted,
+	// linked from the navigation header; nil for a page on its own.
+	Images []string
+	// PaginateAbove is the number of functions beyond which the function list
+	// is rendered page by page from embedded JSON: 0 for defaultPaginateAbove,
+	// negative to never paginate.
+	PaginateAbove int
+	// Metadata describes the run, shown on every page.
+	Metadata *RunMetadata
+}
+
+// defaultPaginateAbove is the number of functions beyond which the detailed
+// page renders its function list page by page: browsers choke on the
+// hundreds of thousands of list items of big C++ images.
+const defaultPaginateAbove = 10000
+
+// paginates reports whether the function list of an image of total
+// functions is rendered page by page.
+func (o HTMLOptions) paginates(total int) bool {
+	limit := o.PaginateAbove
+	if limit == 0 {
+		limit = defaultPaginateAbove
+	}
+	return limit > 0 && total > limit
+}
+
+// NavLink is a page of the report, linked from the navigation header.
+type NavLink struct {
+	Name string
+	Page string
+}
+
+// ReportNav is the navigation header shared by the HTML pages of a run:
+// the way back to the aggregate report and the image, and the images
+// before and after the current one.
+type ReportNav struct {
+	// Aggregate links the aggregate report, empty on the report itself.
+	Aggregate string
+	Image     *NavLink
+	// Current names the page below Image, such as a source file.
+	Current    string
+	Prev, Next *NavLink
+}
+
+// nav returns the navigation header of the detailed page of image.
+func (o HTMLOptions) nav(image string) ReportNav {
+	if len(o.Images) == 0 {
+		return ReportNav{}
+	}
+	link := func(image string) *NavLink {
+		return &NavLink{Name: filepath.Base(image), Page: htmlReportFileName(image)}
+	}
+	nav := ReportNav{Aggregate: aggregateReportFileName}
+	i, found := slices.BinarySearch(o.Images, image)
+	if !found {
+		return nav
+	}
+	if i > 0 {
+		nav.Prev = link(o.Images[i-1])
+	}
+	if i+1 < len(o.Images) {
+		nav.Next = link(o.Images[i+1])
+	}
+	return nav
+}
+
+type HTMLReportData struct {
+	ImageName          string
+	TotalCount         int
+	CalledCount        int
+	UncalledCount      int
+	CoveragePercentage float64
+	// PartialSymbols marks stripped images counted from their exported symbols only.
+	PartialSymbols bool
+	// Copies are the other paths of the image, merged into it.
+	Copies []string
+	// Files are the library files reported under the soname of the image.
+	Files []string
+	// HotFunctions are the most called functions, when the logs have call counts.
+	HotFunctions []HotFunction
+	// Namespaces sum up the coverage per C++ namespace or C name prefix.
+	Namespaces []NamespaceSummary
+	Functions  iter.Seq[FunctionEntry]
+	// Paged renders Functions page by page from Export when the list is too
+	// long to render at once; Functions and Scopes are then unset.
+	Paged bool
+	// Scopes groups the functions by C++ namespace and class, nil for C images.
+	Scopes *ScopeGroup
+	// Baseline compares the image with a previous run, nil without --baseline.
+	Baseline *ImageComparison
+	// Compared is the coverage versus the saved report of --compare, if any.
+	Compared     *ImageDelta
+	ComparedWith string
+	Nav          ReportNav
+	GeneratedAt  string // Add this field
+	// Export is the ImageExport of the page as JSON, embedded for its
+	// download buttons and paged function list.
+	Export template.JS
+	// Metadata are the fields of the run metadata.
+	Metadata []MetadataField
+}
+
+// ImageExport is the coverage data of an image embedded in its detailed
+// page, which the page offers to download as JSON or CSV: the data of a
+// report can then be extracted without the logs or the CLI.
+type ImageExport struct {
+	Image       string             `json:"image"`
+	GeneratedAt string             `json:"generated_at"`
+	TotalCount  int                `json:"total_count"`
+	CalledCount int                `json:"called_count"`
+	CoveragePct float64            `json:"coverage_pct"`
+	Files       []string           `json:"files,omitempty"`
+	Metadata    *RunMetadata       `json:"metadata,omitempty"`
+	Functions   []ExportedFunction `json:"functions"`
+}
+
+// ExportedFunction is a function of an ImageExport.
+type ExportedFunction struct {
+	Name   string `json:"name"`
+	Called bool   `json:"called"`
+	// Calls is 0 when the logs carry no call counts.
+	Calls   uint64 `json:"calls,omitempty"`
+	Mangled string `json:"mangled,omitempty"`
+	Source  string `json:"source,omitempty"`
+	URL     string `json:"url,omitempty"`
+	Change  string `json:"change,omitempty"`
+	// InlinedInto are the called functions a function covered via inlining
+	// was inlined into.
+	InlinedInto []string `json:"inlined_into,omitempty"`
+}
+
+// exportJSON encodes the export for a script element of the page.
+// encoding/json escapes <, > and &, so it cannot close the element; quotes,
+// only found in strings, are escaped too so that no symbol shows up raw.
+func (e ImageExport) exportJSON() (template.JS, error) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	return template.JS(bytes.ReplaceAll(b, []byte("'"), []byte(`\u0027`))), nil
+}
+
+// --- Coverage Analysis ---
+
+// Log lines look like "[PID:1] [Image:/bin/ls] [Function:main]" for every
+// instrumented function and "... [Image:/bin/ls] [Called:main]" when it runs.
+var (
+	imageMarker    = []byte("[Image:")
+	functionMarker = []byte("] [Function:")
+	calledMarker   = []byte("] [Called:")
+	addrMarker     = []byte("] [Addr:")
+	countMarker    = []byte("] [Count:")
+)
+
+// supportedLogFormat is the newest FuncTracer log format this parser reads.
+// Format 1 logs have no header; format 2 adds a "[FuncTracer] [Format:2]"
+// header and writes each Called line once, when the image is unloaded;
+// format 3 escapes brackets, backslashes and line breaks in the fields as \xNN;
+// format 4 adds an "[Addr:0x...]" field, the image-relative start address of
+// the function, between the image and the function; format 5 adds a
+// "[Count:N]" field with the number of calls before the function of Called lines;
+// format 6 adds call-graph edge lines, read by parseEdgeLine; format 7 adds
+// the context line of the invocation and a build ID line per image; format 8
+// adds first-call lines, read by parseFirstCallLine; format 9 adds an
+// architecture line per image; format 10 adds a plugin line per image loaded
+// with dlopen, read by parsePluginLine; format 11 adds the tool version and
+// options to the header. logFormats maps each version to its line parser.
+const supportedLogFormat = 11
+
+var (
+	formatMarker = []byte("[FuncTracer] [Format:")
+	runMarker    = []byte("] [Run:")
+)
+
+// parseLogHeader returns the format version announced by a header line.
+func parseLogHeader(line []byte) (int, bool) {
+	i := bytes.Index(line, formatMarker)
+	if i < 0 {
+		return 0, false
+	}
+	rest := line[i+len(formatMarker):]
+	j := bytes.IndexByte(rest, ']')
+	if j < 0 {
+		return 0, false
+	}
+	version, err := strconv.Atoi(string(rest[:j]))
+	if err != nil {
+		return 0, false
+	}
+	return version, true
+}
+
+// parseLogRunID returns the run ID a header line carries. Logs of older
+// tracers have none and are never treated as duplicates.
+func parseLogRunID(line []byte) (string, bool) {
+	return parseHeaderField(line, runMarker)
+}
+
+// parseLogSample returns the sampling rate of the invocation a header line
+// describes, for logs of sampled invocations.
+func parseLogSample(line []byte) (string, bool) {
+	return parseHeaderField(line, sampleMarker)
+}
+
+// parseHeaderField returns the value of the header field starting with marker.
+func parseHeaderField(line, marker []byte) (string, bool) {
+	i := bytes.Index(line, marker)
+	if i < 0 || !bytes.Contains(line[:i], formatMarker) {
+		return "", false
+	}
+	rest := line[i+len(marker):]
+	j := bytes.IndexByte(rest, ']')
+	if j <= 0 {
+		return "", false
+	}
+	return string(rest[:j]), true
+}
+
+type lineKind int
+
+const (
+	lineOther lineKind = iota
+	lineFunction
+	lineCalled
+)
+
+// parseLogLine extracts the image, function, (format 4) address and (format 5)
+// call count of a Function or Called line without allocating. The returned
+// slices alias line.
+func parseLogLine(line []byte) (kind lineKind, image, function, addr []byte, count uint64) {
+	i := bytes.Index(line, imageMarker)
+	if i < 0 {
+		return lineOther, nil, nil, nil, 0
+	}
+	rest := line[i+len(imageMarker):]
+	marker, kind := functionMarker, lineFunction
+	j := bytes.Index(rest, functionMarker)
+	if j < 0 {
+		marker, kind = calledMarker, lineCalled
+		if j = bytes.Index(rest, calledMarker); j < 0 {
+			return lineOther, nil, nil, nil, 0
+		}
+	}
+	image = rest[:j]
+	if c := bytes.Index(image, countMarker); c >= 0 {
+		image, count = image[:c], parseCount(image[c+len(countMarker):])
+	}
+	if a := bytes.Index(image, addrMarker); a >= 0 {
+		image, addr = image[:a], bytes.TrimSpace(image[a+len(addrMarker):])
+	}
+	rest = rest[j+len(marker):]
+	// The function is the last field: ending it at the last bracket also
+	// keeps unescaped symbols such as operator[] of older formats intact.
+	k := bytes.LastIndexByte(rest, ']')
+	if k < 0 {
+		return lineOther, nil, nil, nil, 0
+	}
+	return kind, unescapeField(bytes.TrimSpace(image)), unescapeField(bytes.TrimSpace(rest[:k])), addr, count
+}
+
+// parseCount parses the decimal value of a Count field, 0 if it is invalid.
+func parseCount(b []byte) uint64 {
+	b = bytes.TrimSpace(b)
+	n := uint64(0)
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0
+		}
+		n = n*10 + uint64(c-'0')
+	}
+	return n
+}
+
+// unescapeField decodes the \xNN escapes of a format 3 field in place.
+func unescapeField(field []byte) []byte {
+	i := bytes.IndexByte(field, '\\')
+	if i < 0 {
+		return field
+	}
+	out := field[:i]
+	for ; i < len(field); i++ {
+		if field[i] == '\\' && i+3 < len(field) && field[i+1] == 'x' {
+			hi, ok1 := unhex(field[i+2])
+			lo, ok2 := unhex(field[i+3])
+			if ok1 && ok2 {
+				out = append(out, hi<<4|lo)
+				i += 3
+				continue
+			}
+		}
+		out = append(out, field[i])
+	}
+	return out
+}
+
+func unhex(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+// symbolTable interns image and function names so repeated lines share a
+// single string, and normalizes and demangles each distinct symbol only once.
+type symbolTable struct {
+	images    map[string]string
+	functions map[string]string
+	addressed map[string]string
+	display   map[string]string
+	buf       []byte
+	opts      AnalyzeOptions
+}
+
+func newSymbolTable(opts AnalyzeOptions) *symbolTable {
+	return &symbolTable{images: make(map[string]string), functions: make(map[string]string), addressed: make(map[string]string), display: make(map[string]string), opts: opts}
+}
+
+// addressedFunction interns the key of a function logged with its address.
+func (t *symbolTable) addressedFunction(function string, addr []byte) string {
+	t.buf = append(append(append(t.buf[:0], function...), addrSep), addr...)
+	if s, ok := t.addressed[string(t.buf)]; ok {
+		return s
+	}
+	s := string(t.buf)
+	t.addressed[s] = s
+	return s
+}
+
+// addrSep separates a function name from its address in the keys used while
+// a log is parsed, until disambiguateFunctions turns them into report names.
+const addrSep = '\x00'
+
+// matchAddresses matches the functions of one log by image and address
+// rather than by name: an entry logged with the address of a definition is
+// keyed as that definition, whichever name it carries. Names of the same
+// routine that disagree, through symbol aliases or a tool demangling
+// differently, thus count as one function. A routine with several names is
+// defined under the first in order, for stable reports.
+func matchAddresses(coverage map[string]*CoverageData) {
+	for _, data := range coverage {
+		defs := make(map[string]string) // address -> definition key
+		for key := range data.TotalFunctions {
+			if _, addr, ok := strings.Cut(key, string(addrSep)); ok {
+				if def, seen := defs[addr]; !seen || key < def {
+					defs[addr] = key
+				}
+			}
+		}
+		if len(defs) == 0 {
+			continue
+		}
+		// Edges name their ends without address: they follow the names
+		// that moved from a single address.
+		moved := make(map[string]map[string]string) // name -> address -> new name
+		match := func(key string) string {
+			name, addr, ok := strings.Cut(key, string(addrSep))
+			if !ok {
+				return key
+			}
+			if def, ok := defs[addr]; ok && def != key {
+				if moved[name] == nil {
+					moved[name] = make(map[string]string)
+				}
+				moved[name][addr], _, _ = strings.Cut(def, string(addrSep))
+				return def
+			}
+			return key
+		}
+		renameSet := func(set map[string]struct{}) map[string]struct{} {
+			renamed := make(map[string]struct{}, len(set))
+			for key := range set {
+				renamed[match(key)] = struct{}{}
+			}
+			return renamed
+		}
+		data.TotalFunctions = renameSet(data.TotalFunctions)
+		data.CalledFunctions = renameSet(data.CalledFunctions)
+		if data.Calls != nil {
+			calls := make(map[string]uint64, len(data.Calls))
+			for key, n := range data.Calls {
+				calls[match(key)] += n
+			}
+			data.Calls = calls
+		}
+		if len(moved) == 0 || data.Edges == nil {
+			continue
+		}
+		rename := func(name string) string {
+			if to := moved[name]; len(to) == 1 {
+				for _, def := range to {
+					return def
+				}
+			}
+			return name
+		}
+		edges := make(map[Edge]uint64, len(data.Edges))
+		for e, n := range data.Edges {
+			edges[Edge{Caller: rename(e.Caller), Callee: rename(e.Callee)}] += n
+		}
+		data.Edges = edges
+	}
+}
+
+// disambiguateFunctions names the functions of one log: a name found at a
+// single address keeps its plain name, while distinct functions sharing a name
+// (static functions of different compilation units) get their address appended.
+func disambiguateFunctions(coverage map[string]*CoverageData) {
+	for _, data := range coverage {
+		addrs := make(map[string]int)
+		for key := range data.TotalFunctions {
+			if name, _, ok := strings.Cut(key, string(addrSep)); ok {
+				addrs[name]++
+			}
+		}
+		if len(addrs) == 0 {
+			continue
+		}
+		rename := func(key string) string {
+			name, addr, ok := strings.Cut(key, string(addrSep))
+			if ok && addrs[name] > 1 {
+				name += " [" + addr + "]"
+			}
+			return name
+		}
+		renameSet := func(set map[string]struct{}) map[string]struct{} {
+			renamed := make(map[string]struct{}, len(set))
+			for key := range set {
+				renamed[rename(key)] = struct{}{}
+			}
+			return renamed
+		}
+		data.TotalFunctions = renameSet(data.TotalFunctions)
+		data.CalledFunctions = renameSet(data.CalledFunctions)
+		if data.Calls != nil {
+			calls := make(map[string]uint64, len(data.Calls))
+			for key, n := range data.Calls {
+				calls[rename(key)] += n
+			}
+			data.Calls = calls
+		}
+	}
+}
+
+func (t *symbolTable) image(b []byte) string {
+	if s, ok := t.images[string(b)]; ok {
+		return s
+	}
+	raw := string(b)
+	s := normalizeImagePath(raw, t.opts.PathMap)
+	t.images[raw] = s
+	return s
+}
+
+func (t *symbolTable) function(b []byte) string {
+	if s, ok := t.functions[string(b)]; ok {
+		return s
+	}
+	raw := string(b)
+	name, version := splitSymbolVersion(raw)
+	s := name
+	if t.opts.SymbolVersions && version != "" {
+		s += "@" + version
+	}
+	t.functions[raw] = s
+	return s
+}
+
+// splitSymbolVersion separates an ELF symbol version from a symbol name:
+// "memcpy@GLIBC_2.14" and the default version "memcpy@@GLIBC_2.14" both yield
+// "memcpy" and "GLIBC_2.14", so the Function and Called entries always match.
+func splitSymbolVersion(symbol string) (name, version string) {
+	i := strings.IndexByte(symbol, '@')
+	if i <= 0 {
+		return symbol, ""
+	}
+	return symbol[:i], strings.TrimLeft(symbol[i:], "@")
+}
+
+// resolvePath makes path absolute and resolves the symlinks of its longest
+// existing prefix, so paths that do not exist yet can still be compared.
+func resolvePath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	rest := ""
+	for dir := abs; ; dir = filepath.Dir(dir) {
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			return filepath.Join(resolved, rest), nil
+		}
+		if dir == filepath.Dir(dir) {
+			return abs, nil
+		}
+		rest = filepath.Join(filepath.Base(dir), rest)
+	}
+}
+
+// prepareOutputDir validates the report output directory and creates it. It
+// refuses the filesystem root, an existing non-directory and any directory
+// inside one of the log input directories, where reports would mix with the logs.
+func prepareOutputDir(outputDir string, inputs ...string) error {
+	if outputDir == "" {
+		return errors.New("no output directory given")
+	}
+	out, err := resolvePath(outputDir)
+	if err != nil {
+		return fmt.Errorf("invalid output directory %s: %w", outputDir, err)
+	}
+	if out == filepath.Dir(out) {
+		return fmt.Errorf("refusing to write reports into the filesystem root %s", outputDir)
+	}
+	for _, input := range inputs {
+		dir := input
+		if isGlobPattern(input) {
+			dir = globBase(input)
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			continue
+		}
+		in, err := resolvePath(dir)
+		if err != nil {
+			return fmt.Errorf("invalid input directory %s: %w", dir, err)
+		}
+		if rel, err := filepath.Rel(in, out); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("output directory %s is inside the log directory %s: choose a directory outside it", outputDir, dir)
+		}
+	}
+	if info, err := os.Stat(out); err == nil && !info.IsDir() {
+		return fmt.Errorf("output path %s exists and is not a directory", outputDir)
+	}
+	if err := os.MkdirAll(out, 0755); err != nil {
+		return fmt.Errorf("could not create output directory %s: %w", outputDir, err)
+	}
+	return nil
+}
+
+// maxLogLineSize bounds a single log line; longer lines are skipped.
+const maxLogLineSize = 16 << 20
+
+// LogStats counts the lines of a log file the parser had to discard.
+type LogStats struct {
+	File      string `json:"file"`
+	Lines     int    `json:"lines"`
+	Malformed int    `json:"malformed"`
+	RunID     string `json:"run_id,omitempty"`
+	// Sample is the sampling rate of the wrapper when it traced this invocation.
+	Sample string `json:"sample,omitempty"`
+	// Session and Tags label the invocation (COVERAGE_SESSION, COVERAGE_TAGS).
+	Session string   `json:"session,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+	// Context describes the traced invocation; BuildIDs maps its images to
+	// their GNU build ID.
+	Context  *LogContext       `json:"context,omitempty"`
+	BuildIDs map[string]string `json:"build_ids,omitempty"`
+	// Archs maps the images of the log to their architecture (format 9).
+	Archs map[string]string `json:"archs,omitempty"`
+	// Plugins maps the images loaded with dlopen to the program loading them (format 10).
+	Plugins map[string]string `json:"plugins,omitempty"`
+	// Exit is how the traced invocation ended, from the trailer of its log.
+	Exit *LogExit `json:"exit,omitempty"`
+	// Import names the foreign format an imported log was converted from.
+	Import string `json:"import,omitempty"`
+	// Format is the log format version announced by the header; Tool and
+	// Options are the version and options of the tracer (format 11).
+	Format  int    `json:"format,omitempty"`
+	Tool    string `json:"tool,omitempty"`
+	Options string `json:"options,omitempty"`
+	// DuplicateOf names the log of the same run this copy was skipped for.
+	DuplicateOf string `json:"duplicate_of,omitempty"`
+	// Live is set to how a log still being written was handled: "tail" or "skip".
+	Live string `json:"live,omitempty"`
+	// Coverage is the coverage of this log alone, with AnalyzeOptions.KeepLogCoverage.
+	Coverage map[string]*CoverageData `json:"-"`
+}
+
+// MalformedPct is the percentage of malformed lines.
+func (s LogStats) MalformedPct() float64 {
+	if s.Lines == 0 {
+		return 0
+	}
+	return float64(s.Malformed) / float64(s.Lines) * 100
+}
+
+var (
+	sectionMarker     = []byte("] [Section:")
+	notRelevantMarker = []byte("] is not relevant")
+)
+
+// isMalformedLine reports whether a line that did not parse as a Function or
+// Called entry still looks like a broken FuncTracer line. Other output, such
+// as the Pin banner, is legitimate.
+func isMalformedLine(line []byte) bool {
+	return bytes.Contains(line, imageMarker) && !bytes.Contains(line, sectionMarker) && !bytes.Contains(line, notRelevantMarker)
+}
+
+// analyzeLogs processes the log files and extracts coverage data for each image.
+func analyzeLogs(logFiles []string) (map[string]*CoverageData, error) {
+	coverage, _, err := analyzeLogsWith(logFiles, AnalyzeOptions{})
+	return coverage, err
+}
+
+// AnalyzeOptions tunes the log analysis.
+type AnalyzeOptions struct {
+	// MaxMemory is an approximate budget in bytes for the collected function
+	// sets: beyond it they are spilled to temporary files, which are merged
+	// back at the end. Zero means unbounded. Each log is still parsed in memory.
+	MaxMemory int64
+	// SymbolVersions keeps ELF symbol versions ("memcpy@GLIBC_2.14") in the
+	// function names instead of stripping them.
+	SymbolVersions bool
+	// PathMap rewrites image path prefixes before they are normalized.
+	PathMap []PathMapping
+	// Aliases rename functions, merging the ones renamed alike.
+	Aliases *SymbolAliases
+	// NoDemangle keeps C++ functions under their mangled symbol.
+	NoDemangle bool
+	// CloneSuffixes keeps the copies optimizers make of a function
+	// (foo.constprop.0, foo.lto_priv.0) apart instead of merging them into it.
+	CloneSuffixes bool
+	// Images restricts the analysis to the images it matches, after the
+	// paths are normalized.
+	Images ImageFilter
+	// LiveLogs is how logs still being written are handled: "tail" (the
+	// default) parses them up to the last complete line, "skip" ignores them.
+	LiveLogs string
+	// Presets leave functions out of the coverage, by their display names.
+	Presets []FunctionPreset
+	// KeepLogCoverage also keeps the coverage of each log alone, in
+	// LogStats.Coverage, at the cost of holding it in memory.
+	KeepLogCoverage bool
+}
+
+// indexKey identifies the options that shape the names stored in log indexes.
+func (o AnalyzeOptions) indexKey() string {
+	return fmt.Sprintf("versions=%t;paths=%s", o.SymbolVersions, (*pathMapFlag)(&o.PathMap).String())
+}
+
+// logAnalyzer accumulates the coverage of the log lines fed to it.
+type logAnalyzer struct {
+	coverage map[string]*CoverageData
+	symbols  *symbolTable
+	opts     AnalyzeOptions
+	// spill is set when the analysis runs under a memory budget
+	spill *spillStore
+	stats []LogStats
+	// runs maps the run IDs seen so far to the first log of each run
+	runs map[string]string
+	live liveLogDetector
+}
+
+func newLogAnalyzer(opts AnalyzeOptions) *logAnalyzer {
+	return &logAnalyzer{coverage: make(map[string]*CoverageData), symbols: newSymbolTable(opts), opts: opts, runs: make(map[string]string)}
+}
+
+// record adds one Function or Called entry, with the calls of a Called one.
+func (a *logAnalyzer) record(kind lineKind, image, function string, calls uint64) error {
+	data, ok := a.coverage[image]
+	if !ok {
+		data = newCoverageData()
+		a.coverage[image] = data
+	}
+	data.addCalls(function, calls)
+	set := data.CalledFunctions
+	if kind == lineFunction {
+		set = data.TotalFunctions
+	}
+	if _, ok := set[function]; ok {
+		return nil
+	}
+	set[function] = struct{}{}
+	if a.spill != nil && a.spill.account(len(function)) {
+		if err := a.spill.write(a.coverage); err != nil {
+			return err
+		}
+		a.coverage = make(map[string]*CoverageData)
+		a.symbols = newSymbolTable(a.opts)
+	}
+	return nil
+}
+
+// recordEdge adds the calls along a call-graph edge.
+func (a *logAnalyzer) recordEdge(image string, edge Edge, calls uint64) error {
+	data, ok := a.coverage[image]
+	if !ok {
+		data = newCoverageData()
+		a.coverage[image] = data
+	}
+	_, known := data.Edges[edge]
+	data.addEdge(edge, calls)
+	if !known && a.spill != nil && a.spill.account(len(edge.Caller)+len(edge.Callee)) {
+		if err := a.spill.write(a.coverage); err != nil {
+			return err
+		}
+		a.coverage = make(map[string]*CoverageData)
+		a.symbols = newSymbolTable(a.opts)
+	}
+	return nil
+}
+
+// analyzeFile feeds the coverage of logFile to the analyzer, reading it from
+// the index sidecar when that is up to date and writing a fresh one otherwise.
+func (a *logAnalyzer) analyzeFile(logFile string, buf []byte) error {
+	info, err := os.Stat(logFile)
+	if err != nil {
+		return fmt.Errorf("could not open log file %s: %w", logFile, err)
+	}
+	var coverage map[string]*CoverageData
+	var stats LogStats
+	if a.live.isLive(logFile, info) {
+		// A log still being written is neither indexed nor read past its last
+		// complete line, which may be half written.
+		if a.opts.LiveLogs == liveLogsSkip {
+			a.stats = append(a.stats, LogStats{File: logFile, Live: liveLogsSkip})
+			return nil
+		}
+		if coverage, stats, err = a.parseFile(logFile, buf, true); err != nil {
+			return err
+		}
+		stats.Live = liveLogsTail
+	} else {
+		var ok bool
+		if coverage, stats, ok = loadLogIndex(logFile, a.opts); !ok {
+			if coverage, stats, err = a.parseFile(logFile, buf, false); err != nil {
+				return err
+			}
+			// The index is only a cache: a read-only log directory is not an error.
+			_ = writeLogIndex(logFile, info, a.opts, coverage, stats)
+		}
+	}
+	if stats.RunID != "" {
+		if first, ok := a.runs[stats.RunID]; ok {
+			stats.DuplicateOf = first
+			a.stats = append(a.stats, stats)
+			return nil
+		}
+		a.runs[stats.RunID] = logFile
+	}
+	if a.opts.KeepLogCoverage {
+		stats.Coverage = make(map[string]*CoverageData, len(coverage))
+	}
+	a.stats = append(a.stats, stats)
+	for image, data := range coverage {
+		if !a.opts.Images.match(image) {
+			continue
+		}
+		image = archImageKey(image, stats.Archs[image])
+		if stats.Coverage != nil {
+			stats.Coverage[image] = data
+		}
+		for fn := range data.TotalFunctions {
+			if err := a.record(lineFunction, image, fn, 0); err != nil {
+				return err
+			}
+		}
+		for fn := range data.CalledFunctions {
+			if err := a.record(lineCalled, image, fn, data.Calls[fn]); err != nil {
+				return err
+			}
+		}
+		for edge, n := range data.Edges {
+			if err := a.recordEdge(image, edge, n); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseFile extracts the coverage data of a single log file, counting the
+// lines it cannot use: overlong lines, broken entries and a truncated tail.
+// With live set the log is still being written, so an unterminated last line
+// is an entry in progress and is left out instead.
+func (a *logAnalyzer) parseFile(logFile string, buf []byte, live bool) (map[string]*CoverageData, LogStats, error) {
+	stats := LogStats{File: logFile}
+	f, err := openLog(logFile)
+	if err != nil {
+		return nil, stats, fmt.Errorf("could not open log file %s: %w", logFile, err)
+	}
+	defer f.Close()
+	coverage := make(map[string]*CoverageData)
+	r := bufio.NewReader(f)
+	if isProtoLog(r) {
+		if err := a.parseProtoLog(logFile, r, coverage, &stats, live); err != nil {
+			return nil, stats, err
+		}
+		finishLog(coverage, &stats)
+		return coverage, stats, nil
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(buf, maxLogLineSize)
+	skipping, unterminated := false, false
+	format := logFormats[0]
+	var event logEvent
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		if skipping {
+			if i := bytes.IndexByte(data, '\n'); i >= 0 {
+				skipping = false
+				return i + 1, nil, nil
+			}
+			return len(data), nil, nil
+		}
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		if advance == 0 && token == nil && len(data) >= maxLogLineSize {
+			stats.Lines++
+			stats.Malformed++
+			skipping = true
+			return len(data), nil, nil
+		}
+		unterminated = atEOF && token != nil && bytes.IndexByte(data[:advance], '\n') < 0
+		return advance, token, err
+	})
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if live && unterminated {
+			break
+		}
+		stats.Lines++
+		if len(line) > 0 && line[0] == '{' {
+			if !parseJSONEvent(line, &event) {
+				stats.Malformed++
+			} else if err := a.addEvent(&event, coverage, &stats); err != nil {
+				return nil, stats, fmt.Errorf("log file %s uses %w", logFile, err)
+			}
+			continue
+		}
+		kind, rawImage, rawFunction, addr, count := format.parseLine(line)
+		if kind == lineOther {
+			if rawImage, caller, callee, count, ok := parseEdgeLine(line); ok {
+				if !a.addEdge(coverage, rawImage, caller, callee, count) {
+					stats.Malformed++
+				}
+				continue
+			}
+			if _, _, _, _, _, ok := parseFirstCallLine(line); ok {
+				continue
+			}
+			if ctx, ok := parseLogContext(line); ok {
+				if stats.Context == nil {
+					stats.Context = ctx
+				}
+				continue
+			}
+			if rawImage, buildID, ok := parseBuildIDLine(line); ok {
+				if stats.BuildIDs == nil {
+					stats.BuildIDs = make(map[string]string)
+				}
+				stats.BuildIDs[a.symbols.image(rawImage)] = buildID
+				continue
+			}
+			if rawImage, arch, ok := parseArchLine(line); ok {
+				if stats.Archs == nil {
+					stats.Archs = make(map[string]string)
+				}
+				stats.Archs[a.symbols.image(rawImage)] = arch
+				continue
+			}
+			if rawImage, loader, ok := parsePluginLine(line); ok {
+				if stats.Plugins == nil {
+					stats.Plugins = make(map[string]string)
+				}
+				stats.Plugins[a.symbols.image(rawImage)] = loader
+				continue
+			}
+			if exit, ok := parseLogTrailer(line); ok {
+				stats.Exit = &exit
+				continue
+			}
+			if version, ok := parseLogHeader(line); ok {
+				if format, err = logFormatFor(version); err != nil {
+					return nil, stats, fmt.Errorf("log file %s uses %w", logFile, err)
+				}
+				if stats.Format == 0 {
+					stats.Format = version
+					stats.Tool, stats.Options = parseLogTool(line)
+				}
+				if runID, ok := parseLogRunID(line); ok && stats.RunID == "" {
+					stats.RunID = runID
+				}
+				if sample, ok := parseLogSample(line); ok && stats.Sample == "" {
+					stats.Sample = sample
+				}
+				if format, ok := parseHeaderField(line, importMarker); ok && stats.Import == "" {
+					stats.Import = format
+				}
+				if session, tags := parseLogLabels(line); stats.Session == "" && stats.Tags == nil {
+					stats.Session, stats.Tags = session, tags
+				}
+			}
+			if isMalformedLine(line) || (unterminated && len(bytes.TrimSpace(line)) > 0) {
+				stats.Malformed++
+			}
+			continue
+		}
+		if !a.addEntry(coverage, kind, rawImage, rawFunction, addr, count) {
+			stats.Malformed++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, stats, fmt.Errorf("could not read log file %s: %w", logFile, err)
+	}
+	finishLog(coverage, &stats)
+	return coverage, stats, nil
+}
+
+// finishLog completes the coverage and stats of a log once it was read.
+func finishLog(coverage map[string]*CoverageData, stats *LogStats) {
+	if stats.Exit != nil {
+		stats.Exit.setStartup(stats.RunID)
+	}
+	matchAddresses(coverage)
+	disambiguateFunctions(coverage)
+}
+
+// addEntry records a Function or Called entry, reporting false for an
+// entry missing its image or function.
+func (a *logAnalyzer) addEntry(coverage map[string]*CoverageData, kind lineKind, rawImage, rawFunction, addr []byte, count uint64) bool {
+	if len(rawImage) == 0 || len(rawFunction) == 0 {
+		return false
+	}
+	image, function := a.symbols.image(rawImage), a.symbols.function(rawFunction)
+	if function == "" {
+		return true
+	}
+	if len(addr) > 0 {
+		function = a.symbols.addressedFunction(function, addr)
+	}
+	data, ok := coverage[image]
+	if !ok {
+		data = newCoverageData()
+		coverage[image] = data
+	}
+	if kind == lineFunction {
+		data.TotalFunctions[function] = struct{}{}
+	} else {
+		data.CalledFunctions[function] = struct{}{}
+		data.addCalls(function, count)
+	}
+	return true
+}
+
+// addEdge records a call-graph edge, reporting false for an incomplete one.
+func (a *logAnalyzer) addEdge(coverage map[string]*CoverageData, rawImage, caller, callee []byte, count uint64) bool {
+	image := a.symbols.image(rawImage)
+	edge := Edge{Caller: a.symbols.function(caller), Callee: a.symbols.function(callee)}
+	if len(rawImage) == 0 || edge.Caller == "" || edge.Callee == "" {
+		return false
+	}
+	data, ok := coverage[image]
+	if !ok {
+		data = newCoverageData()
+		coverage[image] = data
+	}
+	data.addEdge(edge, count)
+	return true
+}
+
+// analyzeLogsWith is analyzeLogs with options. The returned stats hold the
+// malformed line counts of every log.
+func analyzeLogsWith(logFiles []string, opts AnalyzeOptions) (map[string]*CoverageData, []LogStats, error) {
+	a := newLogAnalyzer(opts)
+	if opts.MaxMemory > 0 {
+		spill, err := newSpillStore(opts.MaxMemory)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer spill.cleanup()
+		a.spill = spill
+	}
+	switch opts.LiveLogs {
+	case "", liveLogsTail, liveLogsSkip:
+	default:
+		return nil, nil, fmt.Errorf("unknown --live-logs value %q", opts.LiveLogs)
+	}
+	buf := make([]byte, 0, 64*1024)
+	for _, logFile := range logFiles {
+		if err := a.analyzeFile(logFile, buf); err != nil {
+			return nil, nil, err
+		}
+	}
+	if a.spill != nil {
+		if err := a.spill.merge(a.coverage); err != nil {
+			return nil, nil, err
+		}
+	}
+	presentFunctions(a.coverage, newSymbolTable(opts))
+	excludePresetFunctions(a.coverage, opts.Presets)
+	for _, s := range a.stats {
+		if s.Coverage != nil {
+			presentFunctions(s.Coverage, newSymbolTable(opts))
+			excludePresetFunctions(s.Coverage, opts.Presets)
+		}
+	}
+	return a.coverage, a.stats, nil
+}
+
+// ReportOptions holds the settings of a report run.
+type ReportOptions struct {
+	// Inputs are the log directories, files and patterns (see collectLogFiles).
+	Inputs    []string
+	OutputDir string
+	Formats   []string
+	// GroupBy merges images into one row per package ("package"), or adds
+	// the coverage of each session ("session").
+	GroupBy string
+	// Labels keeps the logs of some sessions or carrying some tags.
+	Labels LabelFilter
+	// Jobs is the number of per-image reports written concurrently (default: number of CPUs).
+	Jobs int
+	AnalyzeOptions
+	// IFuncVariants lists which IFUNC implementation ran in the text report.
+	IFuncVariants bool
+	// StructorVariants lists which constructor and destructor variants ran in the text report.
+	StructorVariants bool
+	// Reachability groups the uncalled functions by static reachability in the text report.
+	Reachability bool
+	// Strict fails the run when a log has more than MaxMalformed percent of malformed lines.
+	Strict       bool
+	MaxMalformed float64
+	// Top is how many of the most called functions each image lists; 0 disables the section.
+	Top int
+	// NamespaceDepth is how many levels of C++ namespaces the coverage is summed up by.
+	NamespaceDepth int
+	// XMLUncalledAs makes each function an XUnit test case, the uncalled ones
+	// "skipped" or a "failure" (see xunitUncalledModes).
+	XMLUncalledAs string
+	// XMLChunkSize splits the XUnit reports of the images with more functions
+	// into files of as many functions, listed in xunit-index.json.
+	XMLChunkSize int
+	// Compress gzips the large HTML, XML and JSON files of the output
+	// directory (see compressOutputDir).
+	Compress bool
+	// HTMLSingleFile also bundles the HTML pages into singleFileReportName.
+	HTMLSingleFile bool
+	// Inlined counts the functions inlined into called ones as called (see
+	// markInlinedFunctions).
+	Inlined bool
+	// NoSoname reports shared libraries under the name of their file instead
+	// of their soname (see normalizeSonames).
+	NoSoname bool
+	// PaginateAbove is the number of functions beyond which the detailed HTML
+	// pages render their function list page by page (see HTMLOptions).
+	PaginateAbove int
+	// DotMinCalls prunes the call-graph edges taken fewer times from the dot output.
+	DotMinCalls uint64
+	// Sources locate the source code the HTML reports link to.
+	Sources SourceOptions
+	// HistoryDir stores a coverage snapshot per run, shown as trends in the aggregate report.
+	HistoryDir string
+	// BaselineFile is the state of a previous run the HTML reports are compared with.
+	BaselineFile string
+	// StateFile receives the state of this run, the baseline of later ones.
+	StateFile string
+	// CompareFile is a summary.json or XUnit report of an earlier run, or its
+	// output directory, the text and HTML reports show the changes since.
+	CompareFile string
+	// Thresholds color the images of the aggregate report by coverage.
+	Thresholds CoverageThresholds
+	// Listing restricts the text and aggregate reports to the images in its range.
+	Listing CoverageFilter
+	// NoSummary leaves summary.json out.
+	NoSummary bool
+	// Timestamp is the generation time written into the reports. When zero,
+	// SOURCE_DATE_EPOCH is used if set, otherwise the current time.
+	Timestamp time.Time
+}
+
+// reportTimeLayout formats the generation time shown in the reports.
+const reportTimeLayout = "2006-01-02 15:04:05 MST"
+
+// parseReportTimestamp parses a --timestamp value, either Unix seconds (as
+// SOURCE_DATE_EPOCH) or RFC 3339. Fixed timestamps are reported in UTC, so the
+// output does not depend on the time zone of the host.
+func parseReportTimestamp(s string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0).UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q, expected Unix seconds or RFC 3339", s)
+	}
+	return t.UTC(), nil
+}
+
+// reportTimestamp returns the generation time of a report run, following the
+// reproducible builds SOURCE_DATE_EPOCH convention when no timestamp is given.
+func reportTimestamp(t time.Time) (time.Time, error) {
+	if !t.IsZero() {
+		return t, nil
+	}
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		secs, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH %q", epoch)
+		}
+		return time.Unix(secs, 0).UTC(), nil
+	}
+	return time.Now(), nil
+}
+
+// forEachImage runs gen for every image on a pool of workers, printing the
+// failures prefixed by errPrefix, and returns the images that succeeded.
+func forEachImage(coverage map[string]*CoverageData, workers int, gen func(string, *CoverageData) error, errPrefix string) []string {
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+	images := make(chan string)
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		done []string
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for image := range images {
+				err := gen(image, coverage[image])
+				mu.Lock()
+				if err != nil {
+					fmt.Println(errPrefix, err)
+				} else {
+					done = append(done, image)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for image := range coverage {
+		images <- image
+	}
+	close(images)
+	wg.Wait()
+	sort.Strings(done)
+	return done
+}
+
+// runReport analyzes the logs found in opts.Inputs and writes the reports in
+// the requested formats to opts.OutputDir.
+func runReport(opts ReportOptions) error {
+	outputDir, formats := opts.OutputDir, opts.Formats
+	if opts.XMLUncalledAs != "" && !slices.Contains(xunitUncalledModes, opts.XMLUncalledAs) {
+		return fmt.Errorf("unknown --xml-uncalled-as value %q, expected %s", opts.XMLUncalledAs, strings.Join(xunitUncalledModes, " or "))
+	}
+	if opts.XMLChunkSize < 0 {
+		return fmt.Errorf("invalid --xml-chunk-size %d", opts.XMLChunkSize)
+	}
+	logFiles, err := collectLogFiles(opts.Inputs...)
+	if err != nil {
+		return err
+	}
+	if logFiles, err = filterLogs(logFiles, opts.Labels); err != nil {
+		return err
+	}
+	if len(logFiles) == 0 {
+		return fmt.Errorf("no log in %s matches --session/--tag", strings.Join(opts.Inputs, ", "))
+	}
+	generatedAt, err := reportTimestamp(opts.Timestamp)
+	if err != nil {
+		return err
+	}
+	if !opts.NoSummary || slices.ContainsFunc(formats, func(format string) bool { return format != "txt" }) {
+		if err := prepareOutputDir(outputDir, opts.Inputs...); err != nil {
+			return err
+		}
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	// The events are published per log, from the coverage the analysis kept.
+	analyzeOpts := opts.AnalyzeOptions
+	analyzeOpts.KeepLogCoverage = cfg.Events.enabled()
+	coverage, stats, err := analyzeLogsWith(logFiles, analyzeOpts)
+	if err != nil {
+		return err
+	}
+	if opts.Strict {
+		if err := checkMalformed(stats, opts.MaxMalformed); err != nil {
+			return err
+		}
+	}
+	mergeDuplicateImages(coverage, stats)
+	if !opts.NoSoname {
+		normalizeSonames(coverage)
+	}
+	if opts.Inlined {
+		markInlinedFunctions(coverage, opts.Presets)
+	}
+	implementations := collapseIFuncs(coverage)
+	partial := addDynsymFallback(coverage)
+	packages := resolvePackages(coverage)
+	var sessions []SessionSummary
+	switch opts.GroupBy {
+	case "":
+	case "session":
+		if sessions, err = sessionSummaries(logFiles, opts.AnalyzeOptions); err != nil {
+			return err
+		}
+	case "package":
+		coverage = groupCoverageByPackage(coverage, packages)
+		packages, partial = nil, nil
+	default:
+		return fmt.Errorf("unknown --group-by value %q", opts.GroupBy)
+	}
+	meta := collectRunMetadata(stats)
+	htmlOpts := HTMLOptions{Top: opts.Top, NamespaceDepth: opts.NamespaceDepth, Sources: opts.Sources, PaginateAbove: opts.PaginateAbove, Metadata: meta}
+	if opts.BaselineFile != "" {
+		if htmlOpts.Baseline, err = loadCoverageState(opts.BaselineFile); err != nil {
+			return err
+		}
+	}
+	if opts.CompareFile != "" {
+		if htmlOpts.Compared, err = loadSavedReport(opts.CompareFile); err != nil {
+			return err
+		}
+	}
+	view := AggregateView{Thresholds: opts.Thresholds, Listing: opts.Listing, Baseline: htmlOpts.Baseline, Compared: htmlOpts.Compared, Sessions: sessions, Metadata: meta}
+	if opts.HistoryDir != "" {
+		history, err := loadHistory(opts.HistoryDir)
+		if err != nil {
+			return err
+		}
+		view.Trends = imageTrends(history, generatedAt, summarizeCoverage(coverage))
+	}
+	artifacts := []string{}
+	for _, format := range formats {
+		switch format {
+		case "txt":
+			printTxtReport(coverage, packages, partial, stats, opts.Listing, htmlOpts.Compared, opts.Top, opts.NamespaceDepth)
+			if opts.IFuncVariants {
+				printIFuncImplementations(implementations)
+			}
+			if opts.StructorVariants {
+				printStructorVariants(coverage)
+			}
+			if opts.Reachability {
+				printReachability(coverage)
+			}
+			printSessionSummaries(sessions)
+			printRunMetadata(meta)
+		case "html":
+			// The pages of one run link each other.
+			htmlOpts.Images = sortedKeys(coverage)
+			view.Pages = make(map[string]bool)
+			for _, image := range forEachImage(coverage, opts.Jobs, func(image string, data *CoverageData) error {
+				return generateHTMLReport(image, data, partial[image], htmlOpts, outputDir, generatedAt)
+			}, "HTML report error:") {
+				artifacts = append(artifacts, filepath.Join(outputDir, htmlReportFileName(image)))
+				view.Pages[image] = true
+			}
+			if err := generateAggregateHTMLReport(coverage, packages, partial, stats, view, outputDir, generatedAt); err == nil {
+				artifacts = append(artifacts, filepath.Join(outputDir, aggregateReportFileName))
+				if opts.HTMLSingleFile {
+					if err := generateSingleFileReport(outputDir, runPages(outputDir, sortedKeys(view.Pages))); err != nil {
+						fmt.Println("single-file HTML error:", err)
+					} else {
+						artifacts = append(artifacts, filepath.Join(outputDir, singleFileReportName))
+					}
+				}
+			}
+		case "xml":
+			xunitOpts := XUnitOptions{UncalledAs: opts.XMLUncalledAs, ChunkSize: opts.XMLChunkSize, Metadata: meta}
+			images := forEachImage(coverage, opts.Jobs, func(image string, data *CoverageData) error {
+				return generateXUnitReport(image, data, xunitOpts, outputDir, generatedAt)
+			}, "XUnit report error:")
+			for _, image := range images {
+				for _, file := range xunitOpts.reportFiles(image, len(coverage[image].TotalFunctions)) {
+					artifacts = append(artifacts, filepath.Join(outputDir, file))
+				}
+			}
+			if opts.XMLChunkSize > 0 {
+				sort.Strings(images)
+				if err := writeXUnitIndex(outputDir, coverage, images, xunitOpts); err != nil {
+					fmt.Println("XUnit index error:", err)
+				} else {
+					artifacts = append(artifacts, filepath.Join(outputDir, xunitIndexFileName))
+				}
+			}
+		case "cobertura":
+			for _, image := range forEachImage(coverage, opts.Jobs, func(image string, data *CoverageData) error {
+				return generateCoberturaReport(image, data, outputDir, generatedAt)
+			}, "Cobertura report error:") {
+				artifacts = append(artifacts, filepath.Join(outputDir, coberturaReportFileName(image)))
+			}
+		case "dot":
+			withEdges := make(map[string]*CoverageData)
+			for image, data := range coverage {
+				if len(data.Edges) > 0 {
+					withEdges[image] = data
+				}
+			}
+			if len(withEdges) == 0 {
+				fmt.Println("dot: the logs have no call-graph edges, run the wrapped binaries with FUNKOVERAGE_EDGES=1")
+			}
+			for _, image := range forEachImage(withEdges, opts.Jobs, func(image string, data *CoverageData) error {
+				return generateDOTReport(image, data, opts.DotMinCalls, outputDir, generatedAt)
+			}, "DOT report error:") {
+				artifacts = append(artifacts, filepath.Join(outputDir, dotReportFileName(image)))
+			}
+		case "folded", "flamegraph":
+			stacks := foldedStacks(coverage)
+			if len(stacks) == 0 {
+				fmt.Printf("%s: the logs have no call counts, they need FuncTracer log format 5 or newer\n", format)
+			}
+			gen, name := generateFoldedReport, foldedReportFileName
+			if format == "flamegraph" {
+				gen = func(stacks []foldedStack, outputDir string) error {
+					return generateFlameGraph(stacks, outputDir, generatedAt)
+				}
+				name = flameGraphReportFileName
+			}
+			if err := gen(stacks, outputDir); err != nil {
+				fmt.Println("flame graph error:", err)
+			} else {
+				artifacts = append(artifacts, filepath.Join(outputDir, name))
+			}
+		case "perfetto":
+			var traced []string
+			for _, s := range stats {
+				if s.DuplicateOf == "" && s.Live != liveLogsSkip {
+					traced = append(traced, s.File)
+				}
+			}
+			written, err := generateTraceReport(traced, opts.AnalyzeOptions, outputDir)
+			switch {
+			case err != nil:
+				fmt.Println("trace export error:", err)
+			case !written:
+				fmt.Println("perfetto: the logs have no first-call times, run the wrapped binaries with FUNKOVERAGE_TIMELINE=1")
+			default:
+				artifacts = append(artifacts, filepath.Join(outputDir, traceReportFileName))
+			}
+		}
+	}
+	removeStaleCompressed(outputDir)
+	if opts.Compress {
+		if compressed, err := compressOutputDir(outputDir); err != nil {
+			fmt.Println("compress error:", err)
+		} else {
+			artifacts = append(compressedArtifacts(artifacts, compressed), filepath.Join(outputDir, compressedIndexFileName))
+		}
+	}
+	sort.Strings(artifacts)
+	gates, err := cfg.Gates.evaluate(summarizeCoverage(coverage))
+	if err != nil {
+		return fmt.Errorf("config gates: %w", err)
+	}
+	if !opts.NoSummary {
+		summary := newReportSummary(opts, stats, artifacts, summarizeCoverage(coverage), generatedAt)
+		summary.Gates = gates
+		summary.Metadata = meta
+		if err := writeReportSummary(outputDir, summary); err != nil {
+			fmt.Println("summary error:", err)
+		}
+	}
+	if opts.StateFile != "" {
+		if err := saveCoverageState(opts.StateFile, coverage, generatedAt); err != nil {
+			fmt.Println("state error:", err)
+		}
+	}
+	if opts.HistoryDir != "" {
+		if err := saveHistorySnapshot(opts.HistoryDir, generatedAt, summarizeCoverage(coverage)); err != nil {
+			fmt.Println("history error:", err)
+		}
+	}
+	if err := notifyRegressions(cfg.Notify, summarizeCoverage(coverage)); err != nil {
+		fmt.Println("notification error:", err)
+	}
+	if err := publishEvents(cfg.Events, stats, outputDir); err != nil {
+		fmt.Println("event streaming error:", err)
+	}
+	payload := ReportWebhookPayload{
+		Event:       "report",
+		GeneratedAt: generatedAt.Format(time.RFC3339),
+		OutputDir:   outputDir,
+		Formats:     formats,
+		Artifacts:   artifacts,
+		Summary:     summarizeCoverage(coverage),
+	}
+	if err := fireReportWebhooks(cfg.ReportWebhooks, payload); err != nil {
+		fmt.Println("webhook error:", err)
+	}
+	printGateResults(gates)
+	return gateFailure(gates)
 }
 
-type FunctionEntry struct {
-	Name   string
-	Status string // "called" or "uncalled"
+// checkMalformed fails when a log has more than maxPct percent of malformed lines.
+func checkMalformed(stats []LogStats, maxPct float64) error {
+	var errs []error
+	for _, s := range stats {
+		if s.Malformed > 0 && s.DuplicateOf == "" && s.MalformedPct() > maxPct {
+			errs = append(errs, fmt.Errorf("%s: %d of %d lines malformed (%.2f%% > %.2f%%)", s.File, s.Malformed, s.Lines, s.MalformedPct(), maxPct))
+		}
+	}
+	return errors.Join(errs...)
 }
 
-type HTMLReportData struct {
-	ImageName          string
-	TotalCount         int
-	CalledCount        int
-	UncalledCount      int
-	CoveragePercentage float64
-	Functions          []FunctionEntry
-	GeneratedAt        string // Add this field
+// liveLogs returns the stats of the logs that were still being written.
+func liveLogs(stats []LogStats) []LogStats {
+	live := []LogStats{}
+	for _, s := range stats {
+		if s.Live != "" {
+			live = append(live, s)
+		}
+	}
+	return live
 }
 
-// --- Coverage Analysis ---
-
-var (
-	functionDefRe  = regexp.MustCompile(`\[Image:(.*?)\] \[Function:(.*?)\]`)
-	functionCallRe = regexp.MustCompile(`\[Image:(.*?)\] \[Called:(.*?)\]`)
-)
+// duplicateLogs returns the stats of the logs skipped as copies of another log.
+func duplicateLogs(stats []LogStats) []LogStats {
+	dups := []LogStats{}
+	for _, s := range stats {
+		if s.DuplicateOf != "" {
+			dups = append(dups, s)
+		}
+	}
+	return dups
+}
 
-func extractImageAndFunction(m []string) (string, string) {
-	image, function := strings.TrimSpace(m[1]), strings.TrimSpace(m[2])
-	function = demangle.Filter(function) // Apply demangling for c++
-	return image, function
+// malformedLogs returns the stats of the logs that have malformed lines.
+func malformedLogs(stats []LogStats) []LogStats {
+	bad := []LogStats{}
+	for _, s := range stats {
+		if s.Malformed > 0 && s.DuplicateOf == "" {
+			bad = append(bad, s)
+		}
+	}
+	return bad
 }
 
-// analyzeLogs processes the log files and extracts coverage data for each image.
-func analyzeLogs(logFiles []string) (map[string]*CoverageData, error) {
-	coverage := make(map[string]*CoverageData)
-	for _, logFile := range logFiles {
-		f, err := os.Open(logFile)
-		if err != nil {
-			return nil, fmt.Errorf("could not open log file %s: %w", logFile, err)
-		}
-		scanner := bufio.NewScanner(f)
-		for scanner.Scan() {
-			line := scanner.Text()
-			if m := functionDefRe.FindStringSubmatch(line); m != nil {
-				image, function := extractImageAndFunction(m)
-				if image == "" || function == "" {
-					continue
-				}
-				if _, ok := coverage[image]; !ok {
-					coverage[image] = &CoverageData{make(map[string]struct{}), make(map[string]struct{})}
-				}
-				coverage[image].TotalFunctions[function] = struct{}{}
-			} else if m := functionCallRe.FindStringSubmatch(line); m != nil {
-				image, function := extractImageAndFunction(m)
-				if image == "" || function == "" {
-					continue
-				}
-				if _, ok := coverage[image]; !ok {
-					coverage[image] = &CoverageData{make(map[string]struct{}), make(map[string]struct{})}
-				}
-				coverage[image].CalledFunctions[function] = struct{}{}
-			}
+// printableSymbol quotes the control characters of a symbol name, which the
+// format 3 escapes can carry, so it stays on one line of the text and XUnit
+// listings. HTML output relies on html/template escaping instead.
+func printableSymbol(fn string) string {
+	if strings.IndexFunc(fn, func(r rune) bool { return !unicode.IsPrint(r) }) < 0 {
+		return fn
+	}
+	var b strings.Builder
+	for _, r := range fn {
+		if unicode.IsPrint(r) {
+			b.WriteRune(r)
+		} else {
+			q := strconv.QuoteRune(r)
+			b.WriteString(q[1 : len(q)-1])
 		}
-		f.Close()
 	}
-	return coverage, nil
+	return b.String()
 }
 
 // --- Console Report ---
 // printTxtReport prints a text-based report to the console summarizing coverage for each image.
-func printTxtReport(coverage map[string]*CoverageData) {
+// The top most called functions of each image are listed when the logs have call counts.
+func printTxtReport(coverage map[string]*CoverageData, packages map[string]string, partial map[string]bool, stats []LogStats, listing CoverageFilter, compared *SavedReport, top, namespaceDepth int) {
 	summary := summarizeCoverage(coverage)
+	plugins := imagePlugins(coverage, stats)
+	hidden := 0
 	for _, row := range summary.Rows {
+		if !listing.lists(row.CoveragePct) {
+			hidden++
+			continue
+		}
 		uncalled := row.TotalCount - row.CalledCount
 		fmt.Printf("\n==================================================\n")
 		fmt.Printf("Image: %s\n", row.ImageName)
+		if pkg, ok := packages[row.ImageName]; ok {
+			fmt.Printf("Package: %s\n", pkg)
+		}
+		if partial[row.ImageName] {
+			fmt.Println("Symbols: partial (stripped binary, exported functions only)")
+		}
+		if loaders, ok := plugins[row.ImageName]; ok {
+			fmt.Printf("Plugin: loaded with dlopen by %s\n", strings.Join(loaders, ", "))
+		}
+		if copies := coverage[row.ImageName].Copies; len(copies) > 0 {
+			fmt.Printf("Also traced at: %s\n", strings.Join(copies, ", "))
+		}
+		if files := coverage[row.ImageName].Files; len(files) > 0 {
+			fmt.Printf("Library files: %s\n", strings.Join(files, ", "))
+		}
 		fmt.Printf("==================================================\n")
 		fmt.Printf("  Functions Found:   %d\n", row.TotalCount)
 		fmt.Printf("  Functions Called:  %d\n", row.CalledCount)
 		fmt.Printf("  Coverage:          %.2f%%\n", row.CoveragePct)
+		if d := compared.compareImage(row.ImageName, row.CoveragePct); d != nil {
+			if d.Saved {
+				fmt.Printf("  Since Saved:       %+.2f points (was %.2f%%)\n", d.Delta, d.PreviousPct)
+			} else {
+				fmt.Println("  Since Saved:       not in the saved report")
+			}
+		}
 		fmt.Printf("--------------------------------------------------\n")
+		printHotFunctions(hotFunctions(row.ImageName, coverage[row.ImageName], top))
+		printNamespaceSummaries(namespaceSummaries(row.ImageName, coverage[row.ImageName], namespaceDepth))
 		if row.CalledCount > 0 {
 			fmt.Println("  Called Functions:")
-			// Print called functions (need to look up in coverage map)
-			for fn := range coverage[row.ImageName].CalledFunctions {
-				fmt.Printf("    - %s\n", fn)
+			for _, fn := range sortedKeys(coverage[row.ImageName].CalledFunctions) {
+				if into := coverage[row.ImageName].Inlined[fn]; len(into) > 0 {
+					fmt.Printf("    - %s (inlined into %s)\n", printableSymbol(fn), strings.Join(into, ", "))
+				} else {
+					fmt.Printf("    - %s\n", printableSymbol(fn))
+				}
 			}
 		} else {
 			fmt.Println("  No functions were called for this image.")
 		}
 		if uncalled > 0 {
 			fmt.Println("\n  Uncalled Functions:")
-			for fn := range coverage[row.ImageName].TotalFunctions {
+			for _, fn := range sortedKeys(coverage[row.ImageName].TotalFunctions) {
 				if _, ok := coverage[row.ImageName].CalledFunctions[fn]; !ok {
-					fmt.Printf("    - %s\n", fn)
+					fmt.Printf("    - %s\n", printableSymbol(fn))
 				}
 			}
 		}
 	}
+	if listing.active() {
+		fmt.Printf("\nListing only the images %s coverage, %d more left out.\n", listing, hidden)
+	}
 	// Print totals
 	fmt.Println("\n==================== Totals ======================")
 	fmt.Printf("  Total Functions:   %d\n", summary.TotalFunctions)
 	fmt.Printf("  Total Called:      %d\n", summary.TotalCalled)
 	fmt.Printf("  Average Coverage:  %.2f%%\n", summary.AverageCoverage)
+	if compared != nil {
+		fmt.Printf("  Since Saved:       %+.2f points (was %.2f%% in %s)\n", summary.AverageCoverage-compared.AverageCoverage, compared.AverageCoverage, compared.File)
+	}
 	fmt.Println("==================================================")
+	if bad := malformedLogs(stats); len(bad) > 0 {
+		fmt.Println("\n  Malformed Log Lines:")
+		for _, s := range bad {
+			fmt.Printf("    - %s: %d of %d (%.2f%%)\n", s.File, s.Malformed, s.Lines, s.MalformedPct())
+		}
+	}
+	if live := liveLogs(stats); len(live) > 0 {
+		fmt.Println("\n  Logs Still Being Written:")
+		for _, s := range live {
+			if s.Live == liveLogsSkip {
+				fmt.Printf("    - %s: skipped\n", s.File)
+			} else {
+				fmt.Printf("    - %s: read up to its last complete line\n", s.File)
+			}
+		}
+	}
+	if dups := duplicateLogs(stats); len(dups) > 0 {
+		fmt.Printf("\n  Duplicate Logs Ignored: %d\n", len(dups))
+		for _, s := range dups {
+			fmt.Printf("    - %s (same run as %s)\n", s.File, s.DuplicateOf)
+		}
+	}
+	if rates := sampledLogs(stats); len(rates) > 0 {
+		fmt.Println("\n  Sampled Invocations (only some runs of these binaries were traced):")
+		for _, rate := range sortedKeys(rates) {
+			fmt.Printf("    - %s of the invocations: %d logs\n", rate, rates[rate])
+		}
+	}
+	if exec := executionSummary(stats); exec != nil {
+		fmt.Println("\n  Execution Summary (how the traced invocations ended):")
+		for _, r := range append(exec.Rows, exec.Total) {
+			fmt.Printf("    - %s: %d runs, %d failed, %d killed by a signal, %s wall time (mean %s)", r.Binary, r.Runs, r.Failed, r.Signaled, r.Wall.Round(time.Millisecond), r.MeanWall())
+			if startup := r.MeanStartup(); startup > 0 {
+				fmt.Printf(", Pin start-up ~%s", startup)
+			}
+			fmt.Println()
+		}
+		if exec.NoTrailer > 0 {
+			fmt.Printf("    %d logs have no trailer (older wrapper, or still running)\n", exec.NoTrailer)
+		}
+	}
 	fmt.Println("\n--- End of Console Report ---")
 }
 
+// --- Report File Names ---
+
+const aggregateReportFileName = "aggregate.html"
+
+var unsafeImageCharsRe = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
+
+// windowsReservedNameRe matches the names Windows reserves for devices,
+// whatever their extension: nul.html cannot be created there.
+var windowsReservedNameRe = regexp.MustCompile(`(?i)^(con|prn|aux|nul|com[0-9]|lpt[0-9])(\.|$)`)
+
+// safeImageName reduces an image path to a base name usable in file names on
+// Linux and Windows. Images of Windows logs have backslash-separated paths.
+func safeImageName(image string) string {
+	if i := strings.LastIndexAny(image, `/\`); i >= 0 && i < len(image)-1 {
+		image = image[i+1:]
+	}
+	name := unsafeImageCharsRe.ReplaceAllString(image, "_")
+	if windowsReservedNameRe.MatchString(name) {
+		name = "_" + name
+	}
+	// Windows drops trailing dots, and "." or ".." are no file names.
+	if strings.HasSuffix(name, ".") {
+		name += "_"
+	}
+	return name
+}
+
+func htmlReportFileName(image string) string {
+	return safeImageName(image) + ".html"
+}
+
+func xunitReportFileName(image string) string {
+	return fmt.Sprintf("coverage_%s.xml", safeImageName(image))
+}
+
 // --- XUnit XML Report ---
 
 type TestSuites struct {
@@ -149,26 +1737,94 @@ type Passed struct {
 	Text    string `xml:",chardata"`
 }
 
-// generateXUnitReport generates an XUnit XML report for a single image's coverage data.
-func generateXUnitReport(image string, data *CoverageData, outputDir string) error {
-	totalFns := make([]string, 0, len(data.TotalFunctions))
-	for fn := range data.TotalFunctions {
-		totalFns = append(totalFns, fn)
+// XUnitOptions are the settings of the XUnit reports.
+type XUnitOptions struct {
+	// UncalledAs makes each function a test case, the uncalled ones "skipped"
+	// or a "failure"; when empty, the functions are listed in the text of one.
+	UncalledAs string
+	// ChunkSize splits the report of the images with more functions into files
+	// of as many functions each; 0 writes one file per image.
+	ChunkSize int
+	// Metadata describes the run, written as the properties of the test suites.
+	Metadata *RunMetadata
+}
+
+// xunitIndexFileName lists the XUnit files of each image when they are split.
+const xunitIndexFileName = "xunit-index.json"
+
+// reportFiles returns the XUnit files of an image with total functions:
+// coverage_<image>.xml, or coverage_<image>_part<N>.xml once split.
+func (o XUnitOptions) reportFiles(image string, total int) []string {
+	if o.ChunkSize <= 0 || total <= o.ChunkSize {
+		return []string{xunitReportFileName(image)}
 	}
-	calledFns := data.CalledFunctions
-	totalCount := len(totalFns)
-	skippedCount := totalCount - len(calledFns)
-	calledList := make([]string, 0, len(calledFns))
-	uncalledList := make([]string, 0, skippedCount)
-	for fn := range data.TotalFunctions {
-		if _, ok := calledFns[fn]; ok {
-			calledList = append(calledList, fn)
-		} else {
-			uncalledList = append(uncalledList, fn)
+	files := make([]string, 0, (total+o.ChunkSize-1)/o.ChunkSize)
+	for part := 1; (part-1)*o.ChunkSize < total; part++ {
+		files = append(files, fmt.Sprintf("coverage_%s_part%d.xml", safeImageName(image), part))
+	}
+	return files
+}
+
+// removeStaleXUnitFiles removes the XUnit files an earlier run wrote for
+// image other than files, such as parts of a report no longer split, which
+// --compare would count along.
+func removeStaleXUnitFiles(outputDir, image string, files []string) {
+	stale, _ := filepath.Glob(filepath.Join(outputDir, fmt.Sprintf("coverage_%s_part*.xml", safeImageName(image))))
+	stale = append(stale, filepath.Join(outputDir, xunitReportFileName(image)))
+	for _, path := range stale {
+		if !slices.Contains(files, filepath.Base(path)) {
+			os.Remove(path)
 		}
 	}
-	safeName := regexp.MustCompile(`[^a-zA-Z0-9._-]`).ReplaceAllString(filepath.Base(image), "_")
-	outfile := filepath.Join(outputDir, fmt.Sprintf("coverage_%s.xml", safeName))
+}
+
+// XUnitIndex is the xunit-index.json of a run with split XUnit reports.
+type XUnitIndex struct {
+	ChunkSize int               `json:"chunk_size"`
+	Images    []XUnitIndexImage `json:"images"`
+}
+
+// XUnitIndexImage lists the XUnit files of an image, in function name order.
+type XUnitIndexImage struct {
+	Image     string   `json:"image"`
+	Functions int      `json:"functions"`
+	Files     []string `json:"files"`
+}
+
+// writeXUnitIndex writes the xunit-index.json of the images.
+func writeXUnitIndex(outputDir string, coverage map[string]*CoverageData, images []string, opts XUnitOptions) error {
+	index := XUnitIndex{ChunkSize: opts.ChunkSize, Images: []XUnitIndexImage{}}
+	for _, image := range images {
+		total := len(coverage[image].TotalFunctions)
+		index.Images = append(index.Images, XUnitIndexImage{Image: image, Functions: total, Files: opts.reportFiles(image, total)})
+	}
+	content, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(outputDir, xunitIndexFileName), append(content, '\n'), 0644)
+}
+
+// xunitPart is the content of an XUnit file: the functions of an image, or
+// of a chunk of them, and the summary of the image.
+type xunitPart struct {
+	safeName string
+	names    []string
+	called   map[string]struct{}
+	// skipped are the uncalled functions the test suite counts.
+	skipped       int
+	summary, text string
+	properties    []MetadataField
+}
+
+// generateXUnitReport generates an XUnit XML report for a single image's coverage data.
+// Functions are listed in name order, so the same data always gives the same file.
+// Images with more than opts.ChunkSize functions are split over several files.
+func generateXUnitReport(image string, data *CoverageData, opts XUnitOptions, outputDir string, generatedAt time.Time) error {
+	calledFns := data.CalledFunctions
+	totalCount := len(data.TotalFunctions)
+	skippedCount := totalCount - len(calledFns)
+	safeName := safeImageName(image)
 
 	// Use summarizeCoverage for totals
 	coverage := map[string]*CoverageData{image: data}
@@ -180,96 +1836,352 @@ func generateXUnitReport(image string, data *CoverageData, outputDir string) err
 		safeName, totalCount, len(calledFns), skippedCount, float64(len(calledFns))/float64(totalCount)*100,
 		summary.TotalFunctions, summary.TotalCalled, summary.AverageCoverage,
 	)
+	totalsText := fmt.Sprintf(
+		"\nTOTALS:\n  Total Functions: %d\n  Total Called: %d\n  Average Coverage: %.2f%%\n",
+		summary.TotalFunctions, summary.TotalCalled, summary.AverageCoverage,
+	)
 
-	var details strings.Builder
-	if len(calledList) > 0 {
-		details.WriteString("CALLED FUNCTIONS:\n")
-		for _, fn := range calledList {
-			details.WriteString(fmt.Sprintf("  ✓ %s\n", fn))
-		}
-		details.WriteString("\n")
+	names := sortedKeys(data.TotalFunctions)
+	files := opts.reportFiles(image, totalCount)
+	removeStaleXUnitFiles(outputDir, image, files)
+	if len(files) == 1 {
+		part := xunitPart{safeName: safeName, names: names, called: calledFns, skipped: skippedCount, summary: summaryText, text: totalsText, properties: opts.Metadata.properties()}
+		return writeXUnitPart(filepath.Join(outputDir, files[0]), part, opts.UncalledAs, generatedAt)
 	}
-	if len(uncalledList) > 0 {
-		details.WriteString("UNCALLED FUNCTIONS:\n")
-		for _, fn := range uncalledList {
-			details.WriteString(fmt.Sprintf("  ✗ %s\n", fn))
+	for i, file := range files {
+		chunk := names[i*opts.ChunkSize : min((i+1)*opts.ChunkSize, len(names))]
+		part := xunitPart{safeName: safeName, names: chunk, called: calledFns, summary: fmt.Sprintf("%s\nPart %d of %d: functions %d to %d", summaryText, i+1, len(files), i*opts.ChunkSize+1, i*opts.ChunkSize+len(chunk)), text: totalsText, properties: opts.Metadata.properties()}
+		for _, fn := range chunk {
+			if _, ok := calledFns[fn]; !ok {
+				part.skipped++
+			}
+		}
+		if err := writeXUnitPart(filepath.Join(outputDir, file), part, opts.UncalledAs, generatedAt); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	// Add totals section to details
-	details.WriteString(fmt.Sprintf(
-		"\nTOTALS:\n  Total Functions: %d\n  Total Called: %d\n  Average Coverage: %.2f%%\n",
-		summary.TotalFunctions, summary.TotalCalled, summary.AverageCoverage,
-	))
-
-	ts := TestSuites{
-		Generated: time.Now().Format("2006-01-02 15:04:05 MST"),
-		TestSuite: []TestSuite{
-			{
-				Errors:   0,
-				Failures: 0,
-				Name:     "binary_coverage_" + safeName,
-				Skipped:  skippedCount,
-				Tests:    totalCount,
-				TestCase: []TestCase{
-					{
-						ClassName: "binary_coverage_" + safeName,
-						Name:      "Result",
-						Passed: &Passed{
-							Message: summaryText,
-							Text:    details.String(),
-						},
-					},
-				},
-			},
-		},
-	}
+// writeXUnitPart writes an XUnit file, with one test case per function when
+// uncalledAs is set.
+func writeXUnitPart(outfile string, part xunitPart, uncalledAs string, generatedAt time.Time) error {
 	f, err := os.Create(outfile)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-	enc := xml.NewEncoder(f)
+	w := bufio.NewWriter(f)
+	enc := xml.NewEncoder(w)
 	enc.Indent("", "  ")
-	return enc.Encode(ts)
+	if uncalledAs != "" {
+		err = writeXUnitTestCases(enc, part, uncalledAs, generatedAt)
+	} else {
+		err = writeXUnitSummaryCase(enc, part, generatedAt)
+	}
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// writeXUnitSummaryCase writes an XUnit report with a single test case,
+// whose text lists the called and uncalled functions.
+func writeXUnitSummaryCase(enc *xml.Encoder, part xunitPart, generatedAt time.Time) error {
+	calledListed := 0
+	for _, fn := range part.names {
+		if _, ok := part.called[fn]; ok {
+			calledListed++
+		}
+	}
+	uncalledListed := len(part.names) - calledListed
+
+	// The document is written token by token so that the function list, which
+	// can hold hundreds of thousands of entries, is never built in memory
+	// beyond the sorted names.
+	attr := func(name, value string) xml.Attr { return xml.Attr{Name: xml.Name{Local: name}, Value: value} }
+	start := []xml.StartElement{
+		{Name: xml.Name{Local: "testsuites"}, Attr: []xml.Attr{attr("generated", generatedAt.Format(reportTimeLayout))}},
+		{Name: xml.Name{Local: "testsuite"}, Attr: []xml.Attr{
+			attr("errors", "0"),
+			attr("failures", "0"),
+			attr("name", "binary_coverage_"+part.safeName),
+			attr("skipped", strconv.Itoa(part.skipped)),
+			attr("tests", strconv.Itoa(len(part.names))),
+		}},
+		{Name: xml.Name{Local: "testcase"}, Attr: []xml.Attr{attr("classname", "binary_coverage_"+part.safeName), attr("name", "Result")}},
+		{Name: xml.Name{Local: "passed"}, Attr: []xml.Attr{attr("message", part.summary)}},
+	}
+	for i, el := range start {
+		if err := enc.EncodeToken(el); err != nil {
+			return err
+		}
+		// The properties come first in the test suite.
+		if i == 1 {
+			if err := writeXUnitProperties(enc, part.properties); err != nil {
+				return err
+			}
+		}
+	}
+	text := func(s string) error { return enc.EncodeToken(xml.CharData(s)) }
+	if calledListed > 0 {
+		if err := text("CALLED FUNCTIONS:\n"); err != nil {
+			return err
+		}
+		for _, fn := range part.names {
+			if _, ok := part.called[fn]; ok {
+				if err := text("  ✓ " + printableSymbol(fn) + "\n"); err != nil {
+					return err
+				}
+			}
+		}
+		if err := text("\n"); err != nil {
+			return err
+		}
+	}
+	if uncalledListed > 0 {
+		if err := text("UNCALLED FUNCTIONS:\n"); err != nil {
+			return err
+		}
+		for _, fn := range part.names {
+			if _, ok := part.called[fn]; !ok {
+				if err := text("  ✗ " + printableSymbol(fn) + "\n"); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	// Add totals section to details
+	if err := text(part.text); err != nil {
+		return err
+	}
+	for i := len(start) - 1; i >= 0; i-- {
+		if err := enc.EncodeToken(start[i].End()); err != nil {
+			return err
+		}
+	}
+	return enc.Close()
+}
+
+// xunitUncalledModes are the values of report --xml-uncalled-as.
+var xunitUncalledModes = []string{"skipped", "failure"}
+
+// writeXUnitTestCases writes the XUnit report of an image with one test case
+// per function, for CI servers to filter and keep the history of each
+// function: the uncalled ones are skipped, or failures when uncalledAs is
+// "failure". The summary goes to the system-out of the test suite.
+func writeXUnitTestCases(enc *xml.Encoder, part xunitPart, uncalledAs string, generatedAt time.Time) error {
+	attr := func(name, value string) xml.Attr { return xml.Attr{Name: xml.Name{Local: name}, Value: value} }
+	skipped, failures := part.skipped, 0
+	if uncalledAs == "failure" {
+		skipped, failures = 0, part.skipped
+	}
+	classname := "binary_coverage_" + part.safeName
+	start := []xml.StartElement{
+		{Name: xml.Name{Local: "testsuites"}, Attr: []xml.Attr{attr("generated", generatedAt.Format(reportTimeLayout))}},
+		{Name: xml.Name{Local: "testsuite"}, Attr: []xml.Attr{
+			attr("errors", "0"),
+			attr("failures", strconv.Itoa(failures)),
+			attr("name", classname),
+			attr("skipped", strconv.Itoa(skipped)),
+			attr("tests", strconv.Itoa(len(part.names))),
+		}},
+	}
+	for _, el := range start {
+		if err := enc.EncodeToken(el); err != nil {
+			return err
+		}
+	}
+	if err := writeXUnitProperties(enc, part.properties); err != nil {
+		return err
+	}
+	for _, fn := range part.names {
+		testcase := xml.StartElement{Name: xml.Name{Local: "testcase"}, Attr: []xml.Attr{attr("classname", classname), attr("name", printableSymbol(fn))}}
+		if err := enc.EncodeToken(testcase); err != nil {
+			return err
+		}
+		if _, ok := part.called[fn]; !ok {
+			result := xml.StartElement{Name: xml.Name{Local: uncalledAs}, Attr: []xml.Attr{attr("message", "function not called")}}
+			if err := enc.EncodeToken(result); err != nil {
+				return err
+			}
+			if err := enc.EncodeToken(result.End()); err != nil {
+				return err
+			}
+		}
+		if err := enc.EncodeToken(testcase.End()); err != nil {
+			return err
+		}
+	}
+	out := xml.StartElement{Name: xml.Name{Local: "system-out"}}
+	for _, tok := range []xml.Token{out, xml.CharData(part.summary), out.End(), start[1].End(), start[0].End()} {
+		if err := enc.EncodeToken(tok); err != nil {
+			return err
+		}
+	}
+	return enc.Close()
+}
+
+// writeXUnitProperties writes the properties element of a test suite, if any.
+func writeXUnitProperties(enc *xml.Encoder, props []MetadataField) error {
+	if len(props) == 0 {
+		return nil
+	}
+	properties := xml.StartElement{Name: xml.Name{Local: "properties"}}
+	tokens := []xml.Token{properties}
+	for _, p := range props {
+		property := xml.StartElement{Name: xml.Name{Local: "property"}, Attr: []xml.Attr{
+			{Name: xml.Name{Local: "name"}, Value: p.Name},
+			{Name: xml.Name{Local: "value"}, Value: p.Value},
+		}}
+		tokens = append(tokens, property, property.End())
+	}
+	for _, tok := range append(tokens, properties.End()) {
+		if err := enc.EncodeToken(tok); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 type Row struct {
-	ImageName   string
+	ImageName      string
+	Arch           string
+	Package        string
+	PartialSymbols bool
+	LoadedBy       string
+	// Copies are the other paths of the image, merged into it, and Files the
+	// library files reported under its soname.
+	Copies      string
+	Files       string
+	Report      string
 	TotalCount  int
 	CalledCount int
 	CoveragePct float64
+	// Trend and Delta come from the history store, when there is one.
+	Trend    template.HTML
+	Delta    float64
+	HasDelta bool
+	// Level is the threshold band of the coverage: "low", "medium" or "high".
+	Level string
+	// Baseline compares the image with a previous run, nil without --baseline.
+	Baseline *ImageComparison
+	// Compared is the coverage versus the saved report of --compare, if any.
+	Compared *ImageDelta
+}
+
+// AggregateView holds the presentation settings of the aggregate report.
+type AggregateView struct {
+	Thresholds CoverageThresholds
+	// Listing lists only the images in its coverage range, when set.
+	Listing CoverageFilter
+	// Trends come from the history store, nil without one.
+	Trends map[string]imageTrend
+	// Baseline is the state of a previous run the images are compared with.
+	Baseline *CoverageState
+	// Compared is a saved report the images are compared with, nil without --compare.
+	Compared *SavedReport
+	// Sessions is the coverage of each session, with --group-by session.
+	Sessions []SessionSummary
+	// Pages are the images whose detailed page was written, which the rows link to.
+	Pages map[string]bool
+	// Metadata describes the run.
+	Metadata *RunMetadata
 }
 type AggregateData struct {
-	Rows            []Row
+	Nav          ReportNav
+	Rows         []Row
+	ShowPackages bool
+	ShowArchs    bool
+	ShowHistory  bool
+	Thresholds   CoverageThresholds
+	BelowRed     int
+	BelowGreen   int
+	Listing      string
+	HiddenImages int
+	// Baseline* sum up the changes since the baseline, when there is one.
+	ShowBaseline    bool
+	BaselineAt      string
+	BaselineChanges map[string]int // images per change
+	NewlyCalled     int
+	RegressedCalls  int
+	// Compared* sum up the coverage versus the saved report of --compare.
+	ComparedWith  string
+	ComparedDelta float64
+	MalformedLogs []LogStats
+	DuplicateLogs []LogStats
+	// SampledLogs counts the logs per sampling rate of the wrapper.
+	SampledLogs     map[string]int
+	Execution       *ExecutionSummary
+	Sessions        []SessionSummary
+	LiveLogs        []LogStats
+	Metadata        []MetadataField
 	GeneratedAt     string
 	TotalFunctions  int
 	TotalCalled     int
 	AverageCoverage float64
 }
 
+// parseReportTemplate parses a page of the HTML report along with the
+// navigation header the pages share.
+func parseReportTemplate(name, page string) (*template.Template, error) {
+	tmpl, err := template.New(name).Parse(page)
+	if err != nil {
+		return nil, err
+	}
+	return tmpl.Parse(navHTMLTemplate)
+}
+
+// The HTML templates are parsed once and shared by all the report workers.
+var (
+	detailedTemplate = sync.OnceValues(func() (*template.Template, error) {
+		return parseReportTemplate("report", detailedHTMLTemplateStr)
+	})
+	sourceTemplate = sync.OnceValues(func() (*template.Template, error) {
+		return parseReportTemplate("source", sourceHTMLTemplate)
+	})
+	aggregateTemplate = sync.OnceValues(func() (*template.Template, error) {
+		return parseReportTemplate("aggregate", aggregateHTMLTemplate)
+	})
+)
+
 // generateHTMLReport generates an HTML report for a single image's coverage data.
 // It creates a detailed report with the image name, total functions, called functions,
-func generateHTMLReport(image string, data *CoverageData, outputDir string) error {
-	totalFns := make([]string, 0, len(data.TotalFunctions))
-	for fn := range data.TotalFunctions {
-		totalFns = append(totalFns, fn)
-	}
+// its top most called functions, and flags images with partial symbol info.
+// C++ functions are grouped by namespace and class.
+func generateHTMLReport(image string, data *CoverageData, partial bool, opts HTMLOptions, outputDir string, generatedAt time.Time) error {
 	calledFns := data.CalledFunctions
-	totalCount := len(totalFns)
+	totalCount := len(data.TotalFunctions)
 	calledCount := len(calledFns)
 	uncalledCount := totalCount - calledCount
 	coveragePct := 0.0
 	if totalCount > 0 {
 		coveragePct = float64(calledCount) / float64(totalCount) * 100
 	}
-	functions := make([]FunctionEntry, 0, totalCount)
-	for _, fn := range totalFns {
+	var sources map[string]sourceLink
+	if opts.Sources.enabled() {
+		var err error
+		if sources, err = generateSourceReports(image, data, opts.Sources, opts.nav(image), outputDir, generatedAt.Format(reportTimeLayout)); err != nil {
+			return err
+		}
+	}
+	comparison := opts.Baseline.compareImage(image, data)
+	entry := func(fn string) FunctionEntry {
+		_, called := calledFns[fn]
 		status := "uncalled"
-		if _, ok := calledFns[fn]; ok {
+		if called {
 			status = "called"
 		}
-		functions = append(functions, FunctionEntry{Name: fn, Status: status})
+		return FunctionEntry{Name: fn, Status: status, Source: sources[fn].Page, URL: sources[fn].URL, Change: comparison.functionChange(fn, called), Mangled: data.Mangled[fn], InlinedInto: strings.Join(data.Inlined[fn], ", ")}
+	}
+	// Entries are produced in name order while the template renders instead of being collected first
+	names := sortedKeys(data.TotalFunctions)
+	functions := func(yield func(FunctionEntry) bool) {
+		for _, fn := range names {
+			if !yield(entry(fn)) {
+				return
+			}
+		}
 	}
 	reportData := HTMLReportData{
 		ImageName:          filepath.Base(image),
@@ -277,52 +2189,146 @@ func generateHTMLReport(image string, data *CoverageData, outputDir string) erro
 		CalledCount:        calledCount,
 		UncalledCount:      uncalledCount,
 		CoveragePercentage: coveragePct,
-		Functions:          functions,
-		GeneratedAt:        time.Now().Format("2006-01-02 15:04:05 MST"),
+		PartialSymbols:     partial,
+		Copies:             data.Copies,
+		Files:              data.Files,
+		HotFunctions:       hotFunctions(image, data, opts.Top),
+		Namespaces:         namespaceSummaries(image, data, opts.NamespaceDepth),
+		Baseline:           comparison,
+		Compared:           opts.Compared.compareImage(image, coveragePct),
+		ComparedWith:       opts.Compared.String(),
+		Nav:                opts.nav(image),
+		GeneratedAt:        generatedAt.Format(reportTimeLayout),
+		Metadata:           opts.Metadata.Fields(),
+	}
+	export := ImageExport{Image: reportData.ImageName, GeneratedAt: reportData.GeneratedAt, TotalCount: totalCount, CalledCount: calledCount, CoveragePct: coveragePct,
+		Files: data.Files, Metadata: opts.Metadata, Functions: make([]ExportedFunction, 0, totalCount)}
+	for fn := range functions {
+		export.Functions = append(export.Functions, ExportedFunction{Name: fn.Name, Called: fn.Status == "called", Calls: data.Calls[fn.Name], Mangled: fn.Mangled, Source: fn.Source, URL: fn.URL, Change: fn.Change, InlinedInto: data.Inlined[fn.Name]})
+	}
+	var err error
+	if reportData.Export, err = export.exportJSON(); err != nil {
+		return err
 	}
-	tmpl, err := template.New("report").Parse(detailedHTMLTemplateStr)
+	if reportData.Paged = opts.paginates(totalCount); !reportData.Paged {
+		reportData.Functions = functions
+		reportData.Scopes = groupByScope(data, entry)
+	}
+	tmpl, err := detailedTemplate()
 	if err != nil {
 		return err
 	}
-	safeName := regexp.MustCompile(`[^a-zA-Z0-9._-]`).ReplaceAllString(filepath.Base(image), "_")
-	outfile := filepath.Join(outputDir, fmt.Sprintf("%s.html", safeName))
+	outfile := filepath.Join(outputDir, htmlReportFileName(image))
 	f, err := os.Create(outfile)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-	return tmpl.Execute(f, reportData)
+	w := bufio.NewWriter(f)
+	if err := tmpl.Execute(w, reportData); err != nil {
+		return err
+	}
+	return w.Flush()
 }
 
 // generateAggregateHTMLReport generates an HTML report summarizing coverage across all images.
 // It creates a table with the image name, total functions, called functions, and coverage percentage.
-func generateAggregateHTMLReport(coverage map[string]*CoverageData, outputDir string) error {
+// When packages is not empty, the owning package of each image is shown as well.
+// view colors the rows by coverage and adds the trends of the images.
+func generateAggregateHTMLReport(coverage map[string]*CoverageData, packages map[string]string, partial map[string]bool, stats []LogStats, view AggregateView, outputDir string, generatedAt time.Time) error {
 	summary := summarizeCoverage(coverage)
+	archs := imageArchs(coverage, stats)
+	plugins := imagePlugins(coverage, stats)
 
 	// Convert CoverageSummary to Row for template compatibility
-	rows := make([]Row, len(summary.Rows))
-	for i, r := range summary.Rows {
-		rows[i] = Row{
-			ImageName:   filepath.Base(r.ImageName),
-			TotalCount:  r.TotalCount,
-			CalledCount: r.CalledCount,
-			CoveragePct: r.CoveragePct,
+	rows := make([]Row, 0, len(summary.Rows))
+	belowRed, belowGreen := 0, 0
+	changes := map[string]int{}
+	newlyCalled, regressed := 0, 0
+	for _, r := range summary.Rows {
+		comparison := view.Baseline.compareImage(r.ImageName, coverage[r.ImageName])
+		if comparison != nil {
+			changes[comparison.Change]++
+			newlyCalled += comparison.NewlyCalled
+			regressed += comparison.Regressed
+		}
+		level := view.Thresholds.level(r.CoveragePct)
+		switch level {
+		case "low":
+			belowRed++
+			belowGreen++
+		case "medium":
+			belowGreen++
+		}
+		if !view.Listing.lists(r.CoveragePct) {
+			continue
+		}
+		image, _ := splitArchImage(r.ImageName)
+		report := ""
+		if view.Pages[r.ImageName] {
+			report = htmlReportFileName(r.ImageName)
+		}
+		rows = append(rows, Row{
+			ImageName:      filepath.Base(image),
+			Arch:           archs[r.ImageName],
+			Package:        packages[r.ImageName],
+			PartialSymbols: partial[r.ImageName],
+			LoadedBy:       strings.Join(plugins[r.ImageName], ", "),
+			Copies:         strings.Join(coverage[r.ImageName].Copies, ", "),
+			Files:          strings.Join(coverage[r.ImageName].Files, ", "),
+			Report:         report,
+			TotalCount:     r.TotalCount,
+			CalledCount:    r.CalledCount,
+			CoveragePct:    r.CoveragePct,
+			Level:          level,
+			Baseline:       comparison,
+			Compared:       view.Compared.compareImage(r.ImageName, r.CoveragePct),
+		})
+		if t, ok := view.Trends[r.ImageName]; ok {
+			row := &rows[len(rows)-1]
+			row.Trend, row.Delta, row.HasDelta = sparkline(t.Points), t.Delta, t.HasPrevious
 		}
 	}
 
 	aggData := AggregateData{
 		Rows:            rows,
-		GeneratedAt:     time.Now().Format("2006-01-02 15:04:05 MST"),
+		ShowPackages:    len(packages) > 0,
+		ShowArchs:       len(archs) > 0,
+		ShowHistory:     view.Trends != nil,
+		Thresholds:      view.Thresholds,
+		BelowRed:        belowRed,
+		BelowGreen:      belowGreen,
+		Listing:         view.Listing.String(),
+		HiddenImages:    len(summary.Rows) - len(rows),
+		ShowBaseline:    view.Baseline != nil,
+		BaselineChanges: changes,
+		NewlyCalled:     newlyCalled,
+		RegressedCalls:  regressed,
+		MalformedLogs:   malformedLogs(stats),
+		DuplicateLogs:   duplicateLogs(stats),
+		SampledLogs:     sampledLogs(stats),
+		Execution:       executionSummary(stats),
+		Sessions:        view.Sessions,
+		LiveLogs:        liveLogs(stats),
+		Metadata:        view.Metadata.Fields(),
+		GeneratedAt:     generatedAt.Format(reportTimeLayout),
 		TotalFunctions:  summary.TotalFunctions,
 		TotalCalled:     summary.TotalCalled,
 		AverageCoverage: summary.AverageCoverage,
 	}
+	if view.Compared != nil {
+		aggData.ComparedWith = view.Compared.File
+		aggData.ComparedDelta = summary.AverageCoverage - view.Compared.AverageCoverage
+	}
+	if view.Baseline != nil {
+		aggData.BaselineAt = view.Baseline.GeneratedAt.Format(reportTimeLayout)
+	}
 
-	tmpl, err := template.New("aggregate").Parse(aggregateHTMLTemplate)
+	tmpl, err := aggregateTemplate()
 	if err != nil {
 		return err
 	}
-	outfile := filepath.Join(outputDir, "aggregate.html")
+	outfile := filepath.Join(outputDir, aggregateReportFileName)
 	f, err := os.Create(outfile)
 	if err != nil {
 		return err
@@ -332,17 +2338,17 @@ func generateAggregateHTMLReport(coverage map[string]*CoverageData, outputDir st
 }
 
 type CoverageSummary struct {
-	ImageName   string
-	TotalCount  int
-	CalledCount int
-	CoveragePct float64
+	ImageName   string  `json:"image_name"`
+	TotalCount  int     `json:"total_count"`
+	CalledCount int     `json:"called_count"`
+	CoveragePct float64 `json:"coverage_pct"`
 }
 
 type CoverageTotals struct {
-	Rows            []CoverageSummary
-	TotalFunctions  int
-	TotalCalled     int
-	AverageCoverage float64
+	Rows            []CoverageSummary `json:"rows"`
+	TotalFunctions  int               `json:"total_functions"`
+	TotalCalled     int               `json:"total_called"`
+	AverageCoverage float64           `json:"average_coverage"`
 }
 
 // summarizeCoverage aggregates coverage data across all images and calculates totals.
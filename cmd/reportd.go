@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// --- Report Daemon ---
+
+// funkoverage reportd regenerates the reports of a log directory as new logs
+// come in, in place of a cron job that may overlap with itself: generations
+// run one at a time, once the logs stopped changing for a while, or after at
+// most an interval while they keep changing.
+
+// ReportdConfig holds the settings of funkoverage reportd.
+type ReportdConfig struct {
+	// LogDir is the directory watched for logs (default: LOG_DIR).
+	LogDir string `json:"log_dir"`
+	// OutputDir receives the reports.
+	OutputDir string   `json:"output_dir"`
+	Formats   []string `json:"formats"`
+	// HistoryDir keeps a coverage snapshot per generation (see report --history).
+	HistoryDir string `json:"history_dir"`
+	// Quiet is how long the logs must stay unchanged before reports are
+	// generated, and Interval how long at most new logs wait for them while
+	// they keep changing: durations such as "1m" and "15m".
+	Quiet    string `json:"quiet"`
+	Interval string `json:"interval"`
+	// Addr serves the reports over HTTP when set, e.g. ":8080".
+	Addr string `json:"addr"`
+}
+
+const (
+	defaultReportdQuiet    = time.Minute
+	defaultReportdInterval = 15 * time.Minute
+)
+
+// ReportdOptions are the settings of a report daemon.
+type ReportdOptions struct {
+	LogDir, OutputDir, HistoryDir, Addr string
+	Formats                             []string
+	Quiet, Interval                     time.Duration
+	// Poll is how often the log directory is checked for changes.
+	Poll  time.Duration
+	Hooks HooksConfig
+}
+
+// options validates the configuration and fills in the defaults.
+func (c ReportdConfig) options() (ReportdOptions, error) {
+	opts := ReportdOptions{LogDir: c.LogDir, OutputDir: c.OutputDir, HistoryDir: c.HistoryDir, Addr: c.Addr, Formats: c.Formats,
+		Quiet: defaultReportdQuiet, Interval: defaultReportdInterval}
+	if opts.OutputDir == "" {
+		return opts, errors.New("reportd.output_dir is not set")
+	}
+	if opts.LogDir == "" {
+		if opts.LogDir = os.Getenv("LOG_DIR"); opts.LogDir == "" {
+			opts.LogDir = defaultLogDir
+		}
+	}
+	if len(opts.Formats) == 0 {
+		opts.Formats = []string{"html", "xml"}
+	}
+	for _, d := range []struct {
+		name  string
+		value string
+		to    *time.Duration
+	}{{"quiet", c.Quiet, &opts.Quiet}, {"interval", c.Interval, &opts.Interval}} {
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil || v <= 0 {
+			return opts, fmt.Errorf("reportd.%s: invalid duration %q", d.name, d.value)
+		}
+		*d.to = v
+	}
+	opts.Poll = max(min(opts.Quiet/4, 10*time.Second), 100*time.Millisecond)
+	return opts, nil
+}
+
+// logFingerprint tells whether the logs of a directory changed.
+type logFingerprint struct {
+	Count    int
+	Size     int64
+	Modified time.Time
+}
+
+// fingerprintLogs returns the fingerprint of the logs below dir.
+func fingerprintLogs(dir string) (logFingerprint, error) {
+	var fp logFingerprint
+	logs, err := walkLogs(dir)
+	if err != nil {
+		return fp, err
+	}
+	for _, log := range logs {
+		info, err := os.Stat(log)
+		if err != nil {
+			continue // rotated or removed meanwhile
+		}
+		fp.Count++
+		fp.Size += info.Size()
+		if info.ModTime().After(fp.Modified) {
+			fp.Modified = info.ModTime()
+		}
+	}
+	return fp, nil
+}
+
+// ReportdStatus is what /reportd/status tells of the last generation.
+type ReportdStatus struct {
+	GeneratedAt time.Time `json:"generated_at,omitempty"`
+	Duration    string    `json:"duration,omitempty"`
+	Logs        int       `json:"logs"`
+	Error       string    `json:"error,omitempty"`
+}
+
+type reportDaemon struct {
+	opts ReportdOptions
+	// mu is held for writing while reports are generated, so that they are
+	// never served half written.
+	mu       sync.RWMutex
+	statusMu sync.Mutex
+	status   ReportdStatus
+}
+
+// runReportd generates the reports whenever the logs changed, until stop is
+// closed, serving them over HTTP meanwhile when opts.Addr is set.
+func runReportd(opts ReportdOptions, stop <-chan struct{}) error {
+	if err := prepareOutputDir(opts.OutputDir, opts.LogDir); err != nil {
+		return err
+	}
+	d := &reportDaemon{opts: opts}
+	if opts.Addr != "" {
+		server := &http.Server{Addr: opts.Addr, Handler: d.handler()}
+		go func() {
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				fmt.Println("reportd: serve:", err)
+			}
+		}()
+		defer server.Close()
+		fmt.Printf("Serving the reports of %s on %s\n", opts.OutputDir, opts.Addr)
+	}
+	fmt.Printf("Watching %s for logs, reports into %s\n", opts.LogDir, opts.OutputDir)
+	ticker := time.NewTicker(opts.Poll)
+	defer ticker.Stop()
+	var generated, seen logFingerprint
+	var changedAt, lastRun time.Time
+	for {
+		fp, err := fingerprintLogs(opts.LogDir)
+		now := time.Now()
+		if err != nil {
+			fmt.Println("reportd:", err)
+		} else if fp != seen {
+			seen, changedAt = fp, now
+		}
+		if err == nil && fp.Count > 0 && fp != generated && (now.Sub(changedAt) >= opts.Quiet || now.Sub(lastRun) >= opts.Interval) {
+			d.generate(fp.Count)
+			generated, lastRun = fp, now
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// generate regenerates the reports, between the report hooks.
+func (d *reportDaemon) generate(logs int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	started := time.Now()
+	opts := ReportOptions{Inputs: []string{d.opts.LogDir}, OutputDir: d.opts.OutputDir, Formats: d.opts.Formats, HistoryDir: d.opts.HistoryDir,
+		Top: defaultHotFunctions, NamespaceDepth: 1, Thresholds: defaultThresholds}
+	err := d.opts.Hooks.around("report", HookContext{Inputs: opts.Inputs, OutputDir: opts.OutputDir, Formats: opts.Formats}, func() error {
+		return runReport(opts)
+	})
+	status := ReportdStatus{GeneratedAt: started, Duration: time.Since(started).Round(time.Millisecond).String(), Logs: logs}
+	if err != nil {
+		status.Error = err.Error()
+		fmt.Println("reportd: report error:", err)
+	} else {
+		fmt.Printf("Generated the reports of %d logs in %s\n", logs, status.Duration)
+	}
+	d.statusMu.Lock()
+	d.status = status
+	d.statusMu.Unlock()
+}
+
+// handler serves the reports, and the status of the last generation at
+// /reportd/status.
+func (d *reportDaemon) handler() http.Handler {
+	files := http.FileServer(http.Dir(d.opts.OutputDir))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/reportd/status", func(w http.ResponseWriter, r *http.Request) {
+		d.statusMu.Lock()
+		status := d.status
+		d.statusMu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		d.mu.RLock()
+		defer d.mu.RUnlock()
+		files.ServeHTTP(w, r)
+	})
+	return mux
+}
+
+// stopOnSignal returns a channel closed on SIGINT or SIGTERM.
+func stopOnSignal() <-chan struct{} {
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		close(stop)
+	}()
+	return stop
+}
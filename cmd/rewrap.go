@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// --- Rewrap ---
+
+// rewrap regenerates the wrapper of an already wrapped binary, its script or
+// its launcher and configuration, with the current template and options. The
+// backup stays where it is: wrapping the wrapper again would back up the
+// wrapper and lose the original.
+func rewrap(targetBinary, pinRoot, toolDir, logDir string, opts WrapOptions) error {
+	var backup string
+	if cfg, ok := readLauncherConfig(targetBinary); ok {
+		backup = cfg.Original
+		if err := requireBackup(targetBinary, backup); err != nil {
+			return err
+		}
+		launcher, err := launcherExecutable()
+		if err != nil {
+			return fmt.Errorf("could not locate the funkoverage binary: %w", err)
+		}
+		if !isPE(launcher) {
+			return fmt.Errorf("'%s' is a PE executable: wrap it with the Windows build of funkoverage", targetBinary)
+		}
+		pinTool, err := findPinToolNamed(toolDir, peToolName)
+		if err != nil {
+			return err
+		}
+		if err := copyFile(launcher, targetBinary); err != nil {
+			return fmt.Errorf("could not install the launcher: %w", err)
+		}
+		if err := writeLauncherConfig(targetBinary, opts.launcherConfig(pinRoot, pinTool, logDir, backup)); err != nil {
+			return err
+		}
+	} else {
+		content, err := os.ReadFile(targetBinary)
+		if err != nil {
+			return fmt.Errorf("could not read wrapper: %w", err)
+		}
+		backup = wrapperOriginal(content)
+		if err := requireBackup(targetBinary, backup); err != nil {
+			return err
+		}
+		// A multicall binary runs through the symlink giving it its name.
+		binaryToRun := backup
+		for _, line := range strings.Split(string(content), "\n") {
+			if value, ok := strings.CutPrefix(line, "ORIGINAL_BINARY=\""); ok {
+				binaryToRun = strings.TrimSuffix(value, "\"")
+				break
+			}
+		}
+		pinTool, err := findPinTool(toolDir)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(targetBinary, []byte(wrapperScript(opts, pinRoot, pinTool, logDir, backup, binaryToRun)), 0755); err != nil {
+			return err
+		}
+	}
+	// The backup lived in SAFE_BIN_DIR/<random>/, so the manifest is two levels up
+	manifestDir := filepath.Dir(filepath.Dir(backup))
+	err := updateManifest(manifestDir, func(m *Manifest) {
+		e := opts.manifestEntry(targetBinary, backup)
+		// What was recorded of the original at wrap time stays, to verify the backup against.
+		if old, ok := m.findByPath(targetBinary); ok {
+			e.BuildID, e.SHA256 = old.BuildID, old.SHA256
+		}
+		m.put(e)
+	})
+	if err != nil {
+		fmt.Printf("Warning: failed to update manifest in %s: %v\n", manifestDir, err)
+	}
+	fmt.Printf("Rewrapped %s (original kept at %s)\n", targetBinary, backup)
+	return nil
+}
+
+// requireBackup fails unless the backup a wrapper names is still there.
+func requireBackup(targetBinary, backup string) error {
+	if backup == "" {
+		return fmt.Errorf("could not find the original binary path of the wrapper %s", targetBinary)
+	}
+	if _, err := os.Stat(backup); err != nil {
+		return fmt.Errorf("original binary of %s not found at %s (unwrap --force restores it): %w", targetBinary, backup, err)
+	}
+	return nil
+}
+
+// upgradeWrappers regenerates the wrappers of the binaries of the manifest of
+// safeBinDir with the template of this funkoverage and the options the
+// manifest records, after an upgrade. Binaries no longer wrapped are left to
+// refresh and recover. With dryRun, it only lists the wrappers it would
+// regenerate.
+func upgradeWrappers(safeBinDir string, dryRun bool) error {
+	m, err := loadManifest(safeBinDir)
+	if err != nil {
+		return err
+	}
+	var failed []string
+	for _, e := range m.Entries {
+		if !isWrapper(e.Path) {
+			fmt.Printf("Skipped %s: not wrapped (see refresh and recover)\n", e.Path)
+			continue
+		}
+		if dryRun {
+			fmt.Printf("Would rewrap %s\n", e.Path)
+			continue
+		}
+		opts, err := e.wrapOptions()
+		if err == nil {
+			opts.Rewrap = true
+			err = wrap(e.Path, opts)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "upgrade-wrappers error for %s: %v\n", e.Path, err)
+			failed = append(failed, e.Path)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to upgrade: %v", failed)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// --- Invocation Sampling ---
+
+// Sampling selects which invocations of a wrapped binary are traced: every
+// Every-th one, or a random Percent of them. The zero value traces them all.
+type Sampling struct {
+	Every   int
+	Percent int
+}
+
+// parseSampling parses a --sample value: "10" traces every 10th invocation
+// and "5%" a random 5% of them.
+func parseSampling(s string) (Sampling, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Sampling{}, nil
+	}
+	if pct, ok := strings.CutSuffix(s, "%"); ok {
+		n, err := strconv.Atoi(pct)
+		if err != nil || n < 1 || n > 100 {
+			return Sampling{}, fmt.Errorf("invalid percentage %q, expected 1%% to 100%%", s)
+		}
+		if n == 100 {
+			return Sampling{}, nil
+		}
+		return Sampling{Percent: n}, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return Sampling{}, fmt.Errorf("invalid sampling %q, expected N (every Nth invocation) or P%%", s)
+	}
+	if n == 1 {
+		return Sampling{}, nil
+	}
+	return Sampling{Every: n}, nil
+}
+
+// String returns the value the wrapper reads from FUNKOVERAGE_SAMPLE.
+func (s Sampling) String() string {
+	switch {
+	case s.Every > 1:
+		return strconv.Itoa(s.Every)
+	case s.Percent > 0:
+		return strconv.Itoa(s.Percent) + "%"
+	}
+	return ""
+}
+
+// sampleMarker starts the sampling rate FuncTracer writes in the log header
+// of sampled invocations: "1/10" or "5%".
+var sampleMarker = []byte("] [Sample:")
+
+// sampledLogs returns the number of logs per sampling rate, leaving out
+// duplicates and logs that traced every invocation.
+func sampledLogs(stats []LogStats) map[string]int {
+	rates := make(map[string]int)
+	for _, s := range stats {
+		if s.Sample != "" && s.DuplicateOf == "" {
+			rates[s.Sample]++
+		}
+	}
+	return rates
+}
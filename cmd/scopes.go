@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// --- C++ Namespace/Class Grouping ---
+
+const anonymousNamespace = "(anonymous namespace)"
+
+// splitScope splits a demangled name into its enclosing namespaces/classes
+// and the function itself: "ns::Vec<int>::at(unsigned long) const" gives
+// ["ns", "Vec<int>"] and "at(unsigned long) const". Separators inside template
+// arguments and parameters do not count, nor does a leading return type
+// ("void ns::f<int>(int)"), and everything from an operator on is the leaf.
+func splitScope(name string) (scope []string, leaf string) {
+	depth, start := 0, 0
+	for i := 0; i < len(name); i++ {
+		switch c := name[i]; {
+		case depth == 0 && strings.HasPrefix(name[i:], anonymousNamespace):
+			i += len(anonymousNamespace) - 1
+		case depth == 0 && strings.HasPrefix(name[i:], "operator") && (i == start || name[i-1] == ':'):
+			return scope, name[start:]
+		case c == '<' || c == '(' || c == '[':
+			if depth == 0 && c == '(' {
+				return scope, name[start:]
+			}
+			depth++
+		case c == '>' || c == ')' || c == ']':
+			if depth > 0 {
+				depth--
+			}
+		case depth == 0 && c == ' ' && len(scope) == 0:
+			// a return type or qualifier before the qualified name
+			start = i + 1
+		case depth == 0 && c == ':' && i+1 < len(name) && name[i+1] == ':':
+			scope = append(scope, name[start:i])
+			start = i + 2
+			i++
+		}
+	}
+	return scope, name[start:]
+}
+
+// ScopeGroup is a namespace or class of the detailed report with the
+// coverage of all the functions it contains, nested groups included.
+type ScopeGroup struct {
+	Name        string
+	Path        string // fully qualified, e.g. "ns::Vec<int>"
+	TotalCount  int
+	CalledCount int
+	Groups      []*ScopeGroup
+	Functions   []FunctionEntry // Name is the unqualified function
+}
+
+func (g *ScopeGroup) CoveragePct() float64 {
+	if g.TotalCount == 0 {
+		return 0
+	}
+	return float64(g.CalledCount) / float64(g.TotalCount) * 100
+}
+
+// groupByScope arranges the functions of an image in a tree of namespaces and
+// classes, sorted by name. It returns nil when no function is scoped, as in a
+// C program, where the tree would only repeat the flat list. entry describes
+// a function for the report; its name is replaced by the unqualified one.
+func groupByScope(data *CoverageData, entry func(fn string) FunctionEntry) *ScopeGroup {
+	root := &ScopeGroup{}
+	index := map[string]*ScopeGroup{"": root}
+	scoped := false
+	// In order, so the functions of the same unqualified name, such as a
+	// destructor and its thunk, keep the order of their full names.
+	for _, fn := range sortedKeys(data.TotalFunctions) {
+		scope, leaf := splitScope(fn)
+		_, called := data.CalledFunctions[fn]
+		group, path := root, ""
+		group.TotalCount++
+		if called {
+			group.CalledCount++
+		}
+		for _, name := range scope {
+			scoped = true
+			if path != "" {
+				path += "::"
+			}
+			path += name
+			child, ok := index[path]
+			if !ok {
+				child = &ScopeGroup{Name: name, Path: path}
+				index[path] = child
+				group.Groups = append(group.Groups, child)
+			}
+			group = child
+			group.TotalCount++
+			if called {
+				group.CalledCount++
+			}
+		}
+		e := entry(fn)
+		e.Name = leaf
+		group.Functions = append(group.Functions, e)
+	}
+	if !scoped {
+		return nil
+	}
+	for _, g := range index {
+		sort.Slice(g.Groups, func(i, j int) bool { return g.Groups[i].Name < g.Groups[j].Name })
+		sort.SliceStable(g.Functions, func(i, j int) bool { return g.Functions[i].Name < g.Functions[j].Name })
+	}
+	return root
+}
+
+// --- Namespace Summaries ---
+
+// globalNamespace collects the functions without a namespace or name prefix.
+const globalNamespace = "(global)"
+
+// namespaceOf returns the group a function is summed up under: its first
+// depth namespaces/classes for C++, and for C the prefix libraries put on
+// their names, like "png_" in png_read_info. depth is at least 1.
+func namespaceOf(fn string, depth int) string {
+	scope, leaf := splitScope(fn)
+	if len(scope) > 0 {
+		return strings.Join(scope[:min(max(depth, 1), len(scope))], "::")
+	}
+	if i := strings.IndexAny(leaf, "(<"); i >= 0 {
+		leaf = leaf[:i]
+	}
+	if i := strings.IndexByte(leaf, '_'); i > 0 && i < len(leaf)-1 {
+		return leaf[:i+1]
+	}
+	return globalNamespace
+}
+
+type NamespaceSummary struct {
+	Image       string  `json:"image"`
+	Namespace   string  `json:"namespace"`
+	TotalCount  int     `json:"total_count"`
+	CalledCount int     `json:"called_count"`
+	CoveragePct float64 `json:"coverage_pct"`
+}
+
+// namespaceSummaries sums up the coverage of an image per namespace, in name
+// order. Images whose functions all fall in one group get none, as the image
+// totals already say it all.
+func namespaceSummaries(image string, data *CoverageData, depth int) []NamespaceSummary {
+	groups := make(map[string]*NamespaceSummary)
+	for fn := range data.TotalFunctions {
+		ns := namespaceOf(fn, depth)
+		g, ok := groups[ns]
+		if !ok {
+			g = &NamespaceSummary{Image: filepath.Base(image), Namespace: ns}
+			groups[ns] = g
+		}
+		g.TotalCount++
+		if _, ok := data.CalledFunctions[fn]; ok {
+			g.CalledCount++
+		}
+	}
+	if len(groups) < 2 {
+		return nil
+	}
+	list := make([]NamespaceSummary, 0, len(groups))
+	for _, ns := range sortedKeys(groups) {
+		g := groups[ns]
+		g.CoveragePct = float64(g.CalledCount) / float64(g.TotalCount) * 100
+		list = append(list, *g)
+	}
+	return list
+}
+
+// allNamespaceSummaries lists the namespace summaries of every image, sorted by image.
+func allNamespaceSummaries(coverage map[string]*CoverageData, depth int) []NamespaceSummary {
+	list := []NamespaceSummary{}
+	for _, image := range sortedKeys(coverage) {
+		list = append(list, namespaceSummaries(image, coverage[image], depth)...)
+	}
+	return list
+}
+
+// printNamespaceSummaries prints the namespace section of an image in the text report.
+func printNamespaceSummaries(summaries []NamespaceSummary) {
+	if len(summaries) == 0 {
+		return
+	}
+	fmt.Println("  Coverage by Namespace:")
+	for _, s := range summaries {
+		fmt.Printf("    %6.2f%%  %6d/%-6d  %s\n", s.CoveragePct, s.CalledCount, s.TotalCount, printableSymbol(s.Namespace))
+	}
+	fmt.Printf("--------------------------------------------------\n")
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// --- Grafana JSON datasource ---
+
+// The wrapper names logs <binary>_<YYYYMMDD-HHMMSS>_<nanoseconds>.log
+var logTimestampRe = regexp.MustCompile(`_(\d{8}-\d{6})_\d+\.log(\.gz)?$`)
+
+// logTimestamp returns when a log was written, taken from the wrapper-generated
+// filename or, failing that, from the file modification time.
+func logTimestamp(path string) time.Time {
+	if m := logTimestampRe.FindStringSubmatch(filepath.Base(path)); m != nil {
+		if t, err := time.ParseInLocation("20060102-150405", m[1], time.Local); err == nil {
+			return t
+		}
+	}
+	if info, err := os.Stat(path); err == nil {
+		return info.ModTime()
+	}
+	return time.Time{}
+}
+
+// mergeCoverage adds all the functions and calls of src into dst.
+func mergeCoverage(dst, src map[string]*CoverageData) {
+	for image, data := range src {
+		if _, ok := dst[image]; !ok {
+			dst[image] = newCoverageData()
+		}
+		for fn := range data.TotalFunctions {
+			dst[image].TotalFunctions[fn] = struct{}{}
+		}
+		for fn := range data.CalledFunctions {
+			dst[image].CalledFunctions[fn] = struct{}{}
+		}
+		for fn, n := range data.Calls {
+			dst[image].addCalls(fn, n)
+		}
+		for e, n := range data.Edges {
+			dst[image].addEdge(e, n)
+		}
+		for fn, symbol := range data.Mangled {
+			dst[image].addMangled(fn, symbol)
+		}
+		for fn, into := range data.Inlined {
+			if dst[image].Inlined == nil {
+				dst[image].Inlined = make(map[string][]string)
+			}
+			dst[image].Inlined[fn] = mergeSorted(dst[image].Inlined[fn], into)
+		}
+		if len(data.Copies) > 0 {
+			dst[image].Copies = mergeSorted(dst[image].Copies, data.Copies)
+		}
+		if len(data.Files) > 0 {
+			dst[image].Files = mergeSorted(dst[image].Files, data.Files)
+		}
+		for fn, variants := range data.Variants {
+			if dst[image].Variants == nil {
+				dst[image].Variants = make(map[string][]string)
+			}
+			dst[image].Variants[fn] = mergeSorted(dst[image].Variants[fn], variants)
+		}
+	}
+}
+
+type TimelinePoint struct {
+	Time    time.Time
+	Summary CoverageTotals
+}
+
+// coverageTimeline replays the logs in chronological order and returns the
+// cumulative coverage summary after each of them. The coverage of each log
+// comes from cache and the totals are only updated for the images it touches.
+func coverageTimeline(cache *logCache, logFiles []string) ([]TimelinePoint, error) {
+	sorted := append([]string(nil), logFiles...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return logTimestamp(sorted[i]).Before(logTimestamp(sorted[j]))
+	})
+	cumulative := make(map[string]*CoverageData)
+	rows := map[string]CoverageSummary{}
+	var images []string
+	var totals CoverageTotals
+	points := make([]TimelinePoint, 0, len(sorted))
+	for _, logFile := range sorted {
+		coverage, _, err := cache.load(logFile)
+		if err != nil {
+			return nil, err
+		}
+		mergeCoverage(cumulative, coverage)
+		for image := range coverage {
+			row, known := rows[image]
+			if !known {
+				row.ImageName = image
+				images = append(images, image)
+			}
+			totals.TotalFunctions -= row.TotalCount
+			totals.TotalCalled -= row.CalledCount
+			row.TotalCount, row.CalledCount = len(cumulative[image].TotalFunctions), len(cumulative[image].CalledFunctions)
+			row.CoveragePct = 0
+			if row.TotalCount > 0 {
+				row.CoveragePct = float64(row.CalledCount) / float64(row.TotalCount) * 100
+			}
+			totals.TotalFunctions += row.TotalCount
+			totals.TotalCalled += row.CalledCount
+			rows[image] = row
+		}
+		sort.Strings(images)
+		summary := CoverageTotals{Rows: make([]CoverageSummary, 0, len(images)), TotalFunctions: totals.TotalFunctions, TotalCalled: totals.TotalCalled}
+		for _, image := range images {
+			summary.Rows = append(summary.Rows, rows[image])
+		}
+		if summary.TotalFunctions > 0 {
+			summary.AverageCoverage = float64(summary.TotalCalled) / float64(summary.TotalFunctions) * 100
+		}
+		points = append(points, TimelinePoint{Time: logTimestamp(logFile), Summary: summary})
+	}
+	return points, nil
+}
+
+// grafanaTotalTarget is the pseudo-image used for the overall coverage series.
+const grafanaTotalTarget = "total"
+
+// grafanaUncalledTarget is the table target listing uncalled functions.
+const grafanaUncalledTarget = "uncalled"
+
+type grafanaQuery struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	Targets []struct {
+		Target string `json:"target"`
+		Type   string `json:"type"`
+	} `json:"targets"`
+}
+
+type grafanaSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+type grafanaColumn struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+type grafanaTable struct {
+	Type    string          `json:"type"`
+	Columns []grafanaColumn `json:"columns"`
+	Rows    [][]any         `json:"rows"`
+}
+
+type UncalledFunction struct {
+	Image    string `json:"image"`
+	Function string `json:"function"`
+	// Mangled is the symbol of a demangled C++ function.
+	Mangled string `json:"mangled,omitempty"`
+}
+
+// uncalledFunctions lists every function that was never called, sorted by image and name.
+func uncalledFunctions(coverage map[string]*CoverageData) []UncalledFunction {
+	return uncalledFunctionsOf(coverage, "")
+}
+
+// uncalledFunctionsOf is uncalledFunctions restricted to the images whose base
+// name is image; an empty image keeps them all.
+func uncalledFunctionsOf(coverage map[string]*CoverageData, image string) []UncalledFunction {
+	list := []UncalledFunction{}
+	for name, data := range coverage {
+		if image != "" && filepath.Base(name) != image {
+			continue
+		}
+		for fn := range data.TotalFunctions {
+			if _, ok := data.CalledFunctions[fn]; !ok {
+				list = append(list, UncalledFunction{Image: filepath.Base(name), Function: fn, Mangled: data.Mangled[fn]})
+			}
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Image != list[j].Image {
+			return list[i].Image < list[j].Image
+		}
+		return list[i].Function < list[j].Function
+	})
+	return list
+}
+
+// seriesFor extracts the coverage percentage of target from each timeline point within [from, to].
+func seriesFor(target string, timeline []TimelinePoint, from, to time.Time) grafanaSeries {
+	series := grafanaSeries{Target: target, Datapoints: [][2]float64{}}
+	for _, p := range timeline {
+		if (!from.IsZero() && p.Time.Before(from)) || (!to.IsZero() && p.Time.After(to)) {
+			continue
+		}
+		value, found := p.Summary.AverageCoverage, target == grafanaTotalTarget
+		for _, row := range p.Summary.Rows {
+			if filepath.Base(row.ImageName) == target {
+				value, found = row.CoveragePct, true
+				break
+			}
+		}
+		if found {
+			series.Datapoints = append(series.Datapoints, [2]float64{value, float64(p.Time.UnixMilli())})
+		}
+	}
+	return series
+}
+
+// newServeMux builds the HTTP handlers. Logs are listed on every request so
+// the data is always current, but each is only analyzed again once changed.
+func newServeMux(inputArg string) *http.ServeMux {
+	cache := newLogCache(AnalyzeOptions{})
+	analyze := func(logFiles []string) (map[string]*CoverageData, error) {
+		coverage, _, err := cache.merge(logFiles)
+		return coverage, err
+	}
+	// Every endpoint takes ?session=a,b and ?tag=x,y to restrict the logs.
+	load := func(r *http.Request) ([]string, error) {
+		logFiles, err := collectLogFiles(inputArg)
+		if err != nil {
+			return nil, err
+		}
+		cache.retain(logFiles)
+		q := r.URL.Query()
+		return filterLogs(logFiles, LabelFilter{Sessions: splitList(q.Get("session")), Tags: splitList(q.Get("tag"))})
+	}
+	writeJSON := func(w http.ResponseWriter, v any) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(v)
+	}
+
+	mux := http.NewServeMux()
+	// Grafana datasource health check
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintln(w, "funkoverage", versionString)
+	})
+	mux.HandleFunc("/search", func(w http.ResponseWriter, r *http.Request) {
+		logFiles, err := load(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		coverage, err := analyze(logFiles)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		targets := []string{grafanaTotalTarget, grafanaUncalledTarget}
+		for _, row := range summarizeCoverage(coverage).Rows {
+			targets = append(targets, filepath.Base(row.ImageName))
+		}
+		writeJSON(w, targets)
+	})
+	mux.HandleFunc("/query", func(w http.ResponseWriter, r *http.Request) {
+		var q grafanaQuery
+		if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+			http.Error(w, "invalid query: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		logFiles, err := load(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// The timeline is only built for series targets, and the coverage only
+		// for tables, each at most once per query.
+		var timeline []TimelinePoint
+		var coverage map[string]*CoverageData
+		result := []any{}
+		for _, t := range q.Targets {
+			if t.Type == "table" || t.Target == grafanaUncalledTarget {
+				if coverage == nil {
+					if coverage, err = analyze(logFiles); err != nil {
+						http.Error(w, err.Error(), http.StatusInternalServerError)
+						return
+					}
+				}
+				table := grafanaTable{
+					Type:    "table",
+					Columns: []grafanaColumn{{"Image", "string"}, {"Function", "string"}},
+					Rows:    [][]any{},
+				}
+				// A table named after an image lists the uncalled functions of that image only
+				image := t.Target
+				if image == grafanaUncalledTarget || image == grafanaTotalTarget {
+					image = ""
+				}
+				for _, u := range uncalledFunctionsOf(coverage, image) {
+					table.Rows = append(table.Rows, []any{u.Image, u.Function})
+				}
+				result = append(result, table)
+				continue
+			}
+			if timeline == nil {
+				if timeline, err = coverageTimeline(cache, logFiles); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}
+			result = append(result, seriesFor(t.Target, timeline, q.Range.From, q.Range.To))
+		}
+		writeJSON(w, result)
+	})
+	// Plain JSON endpoints for the Infinity datasource
+	mux.HandleFunc("/api/coverage", func(w http.ResponseWriter, r *http.Request) {
+		logFiles, err := load(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		timeline, err := coverageTimeline(cache, logFiles)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		type point struct {
+			Time     time.Time `json:"time"`
+			Image    string    `json:"image"`
+			Coverage float64   `json:"coverage"`
+		}
+		points := []point{}
+		for _, p := range timeline {
+			for _, row := range p.Summary.Rows {
+				points = append(points, point{p.Time, filepath.Base(row.ImageName), row.CoveragePct})
+			}
+			points = append(points, point{p.Time, grafanaTotalTarget, p.Summary.AverageCoverage})
+		}
+		writeJSON(w, points)
+	})
+	mux.HandleFunc("/api/uncalled", func(w http.ResponseWriter, r *http.Request) {
+		logFiles, err := load(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		coverage, err := analyze(logFiles)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, uncalledFunctions(coverage))
+	})
+	mux.HandleFunc("/api/hot", func(w http.ResponseWriter, r *http.Request) {
+		top := defaultHotFunctions
+		if s := r.URL.Query().Get("top"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				http.Error(w, "invalid top: "+s, http.StatusBadRequest)
+				return
+			}
+			top = n
+		}
+		logFiles, err := load(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		coverage, err := analyze(logFiles)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, allHotFunctions(coverage, top))
+	})
+	mux.HandleFunc("/api/namespaces", func(w http.ResponseWriter, r *http.Request) {
+		depth := 1
+		if s := r.URL.Query().Get("depth"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				http.Error(w, "invalid depth: "+s, http.StatusBadRequest)
+				return
+			}
+			depth = n
+		}
+		logFiles, err := load(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		coverage, err := analyze(logFiles)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, allNamespaceSummaries(coverage, depth))
+	})
+	mux.HandleFunc("/api/sessions", func(w http.ResponseWriter, r *http.Request) {
+		logFiles, err := load(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		sessions, err := sessionSummaries(logFiles, AnalyzeOptions{})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if sessions == nil {
+			sessions = []SessionSummary{}
+		}
+		writeJSON(w, sessions)
+	})
+	return mux
+}
+
+// serve exposes the coverage of the logs in inputArg over HTTP.
+func serve(inputArg, addr string) error {
+	fmt.Printf("Serving coverage of %s on %s\n", inputArg, addr)
+	return http.ListenAndServe(addr, newServeMux(inputArg))
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// --- Single-File HTML Report ---
+
+// singleFileReportName is the standalone page of report --html-single-file:
+// the HTML pages of a run in one file, which survives being attached to a
+// mail or a bug tracker where loose linked files get renamed or dropped.
+const singleFileReportName = "coverage-report.html"
+
+// SingleFileReportData is the data of the single-file page.
+type SingleFileReportData struct {
+	// Pages maps the file names of the pages to their HTML, as a JSON object;
+	// Start is the page shown first.
+	Pages template.JS
+	Start string
+}
+
+var singleFileTemplate = sync.OnceValues(func() (*template.Template, error) {
+	return template.New("single").Parse(singleFileHTMLTemplate)
+})
+
+// runPages returns the HTML pages of a run written into outputDir: the
+// aggregate report, the detailed page of each image and their source pages.
+func runPages(outputDir string, images []string) []string {
+	pages := []string{aggregateReportFileName}
+	for _, image := range images {
+		pages = append(pages, htmlReportFileName(image))
+		// Safe image names only keep characters glob patterns take literally;
+		// the pages of images whose names share a prefix are listed twice.
+		sources, _ := filepath.Glob(filepath.Join(outputDir, "source_"+safeImageName(image)+"_*.html"))
+		for _, source := range sources {
+			pages = append(pages, filepath.Base(source))
+		}
+	}
+	return pages
+}
+
+// generateSingleFileReport bundles the pages of outputDir into the
+// single-file page, which shows them one at a time, the first one initially,
+// following the links between them in the browser.
+func generateSingleFileReport(outputDir string, pages []string) error {
+	contents := make(map[string]string, len(pages))
+	for _, page := range pages {
+		content, err := os.ReadFile(filepath.Join(outputDir, page))
+		if err != nil {
+			return err
+		}
+		contents[page] = string(content)
+	}
+	// encoding/json escapes <, > and &: the pages cannot close the script
+	// element they are embedded in.
+	blob, err := json.Marshal(contents)
+	if err != nil {
+		return err
+	}
+	tmpl, err := singleFileTemplate()
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(filepath.Join(outputDir, singleFileReportName))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	data := SingleFileReportData{Pages: template.JS(blob), Start: pages[0]}
+	if err := tmpl.Execute(w, data); err != nil {
+		return err
+	}
+	return w.Flush()
+}
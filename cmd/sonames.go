@@ -0,0 +1,72 @@
+package main
+
+import (
+	"debug/elf"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// --- Shared Library Sonames ---
+
+// Shared libraries are traced under the path of their file, which carries the
+// full version (libfoo.so.1.2.3): each patch release installed during a test
+// campaign would show up as a new image in trends and comparisons. Libraries
+// are reported under their soname instead (libfoo.so.1), the name programs
+// load them by, with the paths of the files merged into it kept as its Files.
+
+// versionedLibraryRe matches the name of a library file whose soname is not
+// known: the soname is taken to be the name up to the major version.
+var versionedLibraryRe = regexp.MustCompile(`^(.+\.so\.\d+)(?:\.\d+)+$`)
+
+// libraryFileSoname returns the soname of the library at path, by its
+// DT_SONAME entry when the file is on disk or else by its name, or "" when
+// the file name is its soname already. Sonames that are not a prefix of the
+// file name up to a version, such as the soname of a renamed copy, are
+// ignored.
+func libraryFileSoname(path string, onDisk bool) string {
+	base := filepath.Base(path)
+	if onDisk {
+		if f, err := elf.Open(path); err == nil {
+			defer f.Close()
+			if names, err := f.DynString(elf.DT_SONAME); err == nil && len(names) > 0 {
+				if soname := names[0]; soname != base && strings.HasPrefix(base, soname+".") {
+					return soname
+				}
+				return ""
+			}
+		}
+	}
+	if m := versionedLibraryRe.FindStringSubmatch(base); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// normalizeSonames renames the shared libraries of coverage to their soname,
+// in the directory of their file, merging the versions traced into one image
+// which lists the paths of their files as its Files.
+func normalizeSonames(coverage map[string]*CoverageData) {
+	renamed := make(map[string][]string)
+	for key := range coverage {
+		image, arch := splitArchImage(key)
+		if !filepath.IsAbs(image) {
+			continue
+		}
+		if soname := libraryFileSoname(image, arch == ""); soname != "" {
+			to := archImageKey(filepath.Join(filepath.Dir(image), soname), arch)
+			renamed[to] = append(renamed[to], key)
+		}
+	}
+	for to, keys := range renamed {
+		sort.Strings(keys)
+		for _, key := range keys {
+			data := coverage[key]
+			delete(coverage, key)
+			mergeCoverage(coverage, map[string]*CoverageData{to: data})
+			image, _ := splitArchImage(key)
+			coverage[to].Files = mergeSorted(coverage[to].Files, []string{image})
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bufio"
+	"debug/dwarf"
+	"debug/elf"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ianlancetaylor/demangle"
+)
+
+// --- Annotated Source View ---
+
+// sourceLocation is where a function is defined, as recorded in DWARF.
+type sourceLocation struct {
+	File string
+	Line int
+	// CompDir is the build directory of the compilation unit.
+	CompDir string
+}
+
+// repoPath returns the path of the file within the compiled project, the
+// build directory stripped, as repository URLs expect it.
+func (l sourceLocation) repoPath() string {
+	if rel, err := filepath.Rel(l.CompDir, l.File); l.CompDir != "" && err == nil && !strings.HasPrefix(rel, "..") {
+		return filepath.ToSlash(rel)
+	}
+	return strings.TrimPrefix(filepath.ToSlash(l.File), "/")
+}
+
+// SourceOptions tells the HTML reports where the sources of the images are.
+type SourceOptions struct {
+	// Root is the local source tree rendered as annotated pages.
+	Root string
+	// URL links the functions to their repository, e.g.
+	// "https://github.com/org/repo/blob/{rev}/{file}#L{line}".
+	URL string
+	// Rev replaces {rev} in URL (default: HEAD).
+	Rev string
+	// Index is a compile_commands.json or ctags tags file locating the
+	// functions of the images without debug info.
+	Index string
+}
+
+func (o SourceOptions) enabled() bool {
+	return o.Root != "" || o.URL != ""
+}
+
+// link expands the URL template for a file and line.
+func (o SourceOptions) link(file string, line int) string {
+	rev := o.Rev
+	if rev == "" {
+		rev = "HEAD"
+	}
+	return strings.NewReplacer("{rev}", rev, "{file}", file, "{line}", strconv.Itoa(line)).Replace(o.URL)
+}
+
+// sourceLink is where the detailed report sends a function: its annotated
+// source page and its repository URL, each empty when not configured.
+type sourceLink struct {
+	Page string
+	URL  string
+}
+
+// imageDWARF opens the debug info of image, embedded or detached.
+func imageDWARF(image string) (*dwarf.Data, error) {
+	paths := []string{image}
+	if debugPath, err := findDebugFile(image); err == nil && debugPath != "" {
+		paths = append(paths, debugPath)
+	}
+	var lastErr error
+	for _, path := range paths {
+		f, err := elf.Open(path)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if f.Section(".debug_info") == nil && f.Section(".zdebug_info") == nil {
+			f.Close()
+			continue
+		}
+		d, err := f.DWARF()
+		f.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return d, nil
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("%s has no debug info", image)
+	}
+	return nil, lastErr
+}
+
+// functionLocations maps the demangled functions of image to the file and line
+// of their definition. Out-of-line member functions and concrete instances of
+// inlined functions inherit the name and location they omit from the
+// declaration they refer to.
+func functionLocations(image string) (map[string]sourceLocation, error) {
+	d, err := imageDWARF(image)
+	if err != nil {
+		return nil, err
+	}
+	type subprogram struct {
+		name string
+		loc  sourceLocation
+		ref  dwarf.Offset
+		decl bool
+	}
+	subprograms := make(map[dwarf.Offset]*subprogram)
+	var order []dwarf.Offset
+	var files []*dwarf.LineFile
+	var compDir string
+	r := d.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return nil, err
+		}
+		if e == nil {
+			break
+		}
+		switch e.Tag {
+		case dwarf.TagCompileUnit:
+			files = nil
+			compDir, _ = e.Val(dwarf.AttrCompDir).(string)
+			if lr, err := d.LineReader(e); err == nil && lr != nil {
+				files = lr.Files()
+			}
+		case dwarf.TagSubprogram:
+			s := &subprogram{}
+			if name, ok := e.Val(dwarf.AttrLinkageName).(string); ok {
+				s.name = demangle.Filter(name)
+			} else if name, ok := e.Val(dwarf.AttrName).(string); ok {
+				s.name = name
+			}
+			if i, ok := e.Val(dwarf.AttrDeclFile).(int64); ok && i >= 0 && int(i) < len(files) && files[i] != nil {
+				s.loc.File, s.loc.CompDir = files[i].Name, compDir
+			}
+			if line, ok := e.Val(dwarf.AttrDeclLine).(int64); ok {
+				s.loc.Line = int(line)
+			}
+			if ref, ok := e.Val(dwarf.AttrSpecification).(dwarf.Offset); ok {
+				s.ref = ref
+			} else if ref, ok := e.Val(dwarf.AttrAbstractOrigin).(dwarf.Offset); ok {
+				s.ref = ref
+			}
+			s.decl, _ = e.Val(dwarf.AttrDeclaration).(bool)
+			subprograms[e.Offset] = s
+			order = append(order, e.Offset)
+		}
+	}
+	locations := make(map[string]sourceLocation)
+	for _, off := range order {
+		s := subprograms[off]
+		if s.decl {
+			continue
+		}
+		name, loc := s.name, s.loc
+		// an abstract origin may itself complete a specification
+		for ref, depth := s.ref, 0; ref != 0 && depth < 4; depth++ {
+			target, ok := subprograms[ref]
+			if !ok {
+				break
+			}
+			if name == "" {
+				name = target.name
+			}
+			if loc.File == "" {
+				loc = target.loc
+			}
+			ref = target.ref
+		}
+		if name == "" || loc.File == "" || loc.Line == 0 {
+			continue
+		}
+		if _, ok := locations[name]; !ok {
+			locations[name] = loc
+		}
+	}
+	return locations, nil
+}
+
+// resolveSourceFile finds a file recorded in DWARF under root. Build paths
+// rarely match the local checkout, so the longest suffix of the path that
+// exists under root wins: "/build/proj-1.0/src/main.c" is found as
+// root/src/main.c. It returns the local path and the path relative to root.
+func resolveSourceFile(root, file string) (path, rel string, ok bool) {
+	parts := strings.Split(filepath.ToSlash(filepath.Clean(file)), "/")
+	for i := range parts {
+		rel := filepath.Join(parts[i:]...)
+		if rel == "" || rel == "." {
+			continue
+		}
+		if candidate := filepath.Join(root, rel); fileExists(candidate) {
+			return candidate, rel, true
+		}
+	}
+	return "", "", false
+}
+
+func sourceReportFileName(image, rel string) string {
+	return fmt.Sprintf("source_%s_%s.html", safeImageName(image), unsafeImageCharsRe.ReplaceAllString(filepath.ToSlash(rel), "_"))
+}
+
+// SourceLine is a line of an annotated source page. Functions lists the
+// functions defined there, and Status is "uncalled" if any of them was not
+// called, "called" if all were, and empty on other lines.
+type SourceLine struct {
+	Number    int
+	Text      string
+	Status    string
+	Functions []FunctionEntry
+}
+
+type SourceReportData struct {
+	Nav         ReportNav
+	ImageName   string
+	ImageReport string
+	File        string
+	TotalCount  int
+	CalledCount int
+	Lines       []SourceLine
+	GeneratedAt string
+}
+
+// generateSourceReports writes an annotated page for every source file of
+// image found under src.Root, and returns the links of each function to its
+// definition for the detailed report, whose navigation header nav the pages
+// extend. Images without debug info are located by the source index, if any;
+// otherwise they get no pages, like files that are missing from the root.
+func generateSourceReports(image string, data *CoverageData, src SourceOptions, nav ReportNav, outputDir, generatedAt string) (map[string]sourceLink, error) {
+	var locate func(fn string) (sourceLocation, bool)
+	if locations, err := functionLocations(image); err == nil {
+		locate = func(fn string) (sourceLocation, bool) {
+			loc, ok := locations[fn]
+			return loc, ok
+		}
+	} else if src.Index != "" {
+		index, err := loadSourceIndex(src.Index)
+		if err != nil {
+			return nil, err
+		}
+		locate = index.lookup
+	} else {
+		return nil, nil // nothing to annotate
+	}
+	byFile := make(map[string]map[int][]FunctionEntry)
+	files := make(map[string]sourceLocation)
+	for fn := range data.TotalFunctions {
+		loc, ok := locate(fn)
+		if !ok {
+			continue
+		}
+		status := "uncalled"
+		if _, ok := data.CalledFunctions[fn]; ok {
+			status = "called"
+		}
+		if byFile[loc.File] == nil {
+			byFile[loc.File] = make(map[int][]FunctionEntry)
+			files[loc.File] = loc
+		}
+		byFile[loc.File][loc.Line] = append(byFile[loc.File][loc.Line], FunctionEntry{Name: fn, Status: status, Mangled: data.Mangled[fn]})
+	}
+	tmpl, err := sourceTemplate()
+	if err != nil {
+		return nil, err
+	}
+	links := make(map[string]sourceLink)
+	for _, file := range sortedKeys(byFile) {
+		repoPath := files[file].repoPath()
+		path, rel, found := "", "", false
+		if src.Root != "" {
+			path, rel, found = resolveSourceFile(src.Root, file)
+		}
+		if found {
+			// the checkout knows better than the build directory
+			repoPath = filepath.ToSlash(rel)
+		}
+		for line, functions := range byFile[file] {
+			for i, fn := range functions {
+				link := sourceLink{}
+				if found {
+					link.Page = fmt.Sprintf("%s#L%d", sourceReportFileName(image, rel), line)
+				}
+				if src.URL != "" {
+					link.URL = src.link(repoPath, line)
+					functions[i].URL = link.URL
+				}
+				links[fn.Name] = link
+			}
+		}
+		if !found {
+			continue
+		}
+		pageNav := nav
+		pageNav.Image = &NavLink{Name: filepath.Base(image), Page: htmlReportFileName(image)}
+		pageNav.Current = filepath.ToSlash(rel)
+		page := SourceReportData{
+			Nav:         pageNav,
+			ImageName:   filepath.Base(image),
+			ImageReport: htmlReportFileName(image),
+			File:        filepath.ToSlash(rel),
+			GeneratedAt: generatedAt,
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for i, text := range strings.Split(strings.TrimSuffix(string(content), "\n"), "\n") {
+			line := SourceLine{Number: i + 1, Text: text}
+			if functions := byFile[file][line.Number]; len(functions) > 0 {
+				sort.Slice(functions, func(i, j int) bool { return functions[i].Name < functions[j].Name })
+				line.Status, line.Functions = "called", functions
+				for _, fn := range functions {
+					page.TotalCount++
+					if fn.Status == "uncalled" {
+						line.Status = "uncalled"
+					} else {
+						page.CalledCount++
+					}
+				}
+			}
+			page.Lines = append(page.Lines, line)
+		}
+		if err := writeSourceReport(tmpl, filepath.Join(outputDir, sourceReportFileName(image, rel)), page); err != nil {
+			return nil, err
+		}
+	}
+	return links, nil
+}
+
+func writeSourceReport(tmpl *template.Template, outfile string, page SourceReportData) error {
+	f, err := os.Create(outfile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	if err := tmpl.Execute(w, page); err != nil {
+		return err
+	}
+	return w.Flush()
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// --- Source Index ---
+
+// Binaries built elsewhere often ship without debug info while their sources
+// are at hand. A source index, the compile_commands.json of the build or a
+// ctags tags file, then tells where their functions are defined. It knows
+// the functions by their qualified name only, so overloads share the first
+// definition found, and a name may need its namespaces stripped to match.
+
+var (
+	sourceIndexMu    sync.Mutex
+	sourceIndexCache = map[string]*sourceIndex{}
+)
+
+// sourceIndex maps qualified function names ("ns::Foo::run") to the location
+// of their definition.
+type sourceIndex struct {
+	locations map[string]sourceLocation
+	err       error
+}
+
+// loadSourceIndex reads a compile_commands.json or tags file once per run.
+func loadSourceIndex(path string) (*sourceIndex, error) {
+	sourceIndexMu.Lock()
+	defer sourceIndexMu.Unlock()
+	if index, ok := sourceIndexCache[path]; ok {
+		return index, index.err
+	}
+	index := &sourceIndex{locations: map[string]sourceLocation{}}
+	if strings.HasSuffix(path, ".json") {
+		index.err = index.readCompileCommands(path)
+	} else {
+		index.err = index.readTags(path)
+	}
+	sourceIndexCache[path] = index
+	return index, index.err
+}
+
+// add records the first definition of a name.
+func (x *sourceIndex) add(name string, loc sourceLocation) {
+	if _, ok := x.locations[name]; !ok && name != "" && loc.Line > 0 {
+		x.locations[name] = loc
+	}
+}
+
+// lookup finds the definition of a function of the coverage data, by its
+// qualified name, then with its outer namespaces stripped one by one.
+func (x *sourceIndex) lookup(fn string) (sourceLocation, bool) {
+	name := plainFunctionName(fn)
+	for name != "" {
+		if loc, ok := x.locations[name]; ok {
+			return loc, true
+		}
+		_, rest, found := strings.Cut(name, "::")
+		if !found {
+			break
+		}
+		name = rest
+	}
+	return sourceLocation{}, false
+}
+
+// plainFunctionName reduces a demangled function name to its qualified name:
+// no return type, template arguments, parameters or address suffix.
+// "void ns::Foo<int>::run(int) const" becomes "ns::Foo::run".
+func plainFunctionName(fn string) string {
+	if i := strings.LastIndex(fn, " [0x"); i > 0 && strings.HasSuffix(fn, "]") {
+		fn = fn[:i]
+	}
+	var b strings.Builder
+	depth := 0
+loop:
+	for i := 0; i < len(fn); i++ {
+		switch c := fn[i]; {
+		case depth == 0 && isOperatorName(b.String()):
+			// operator(), operator<< and the like are names of their own.
+			n := strings.IndexByte(fn[i+1:], '(') + 1
+			if strings.HasPrefix(fn[i:], "()") {
+				n = 2
+			}
+			if n <= 0 {
+				n = len(fn) - i
+			}
+			b.WriteString(fn[i : i+n])
+			i += n - 1
+		case c == '<':
+			depth++
+		case c == '>' && depth > 0:
+			depth--
+		case c == '(' && depth == 0:
+			break loop
+		case depth == 0:
+			b.WriteByte(c)
+		}
+	}
+	name := b.String()
+	if i := strings.LastIndexByte(name, ' '); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+func isOperatorName(name string) bool {
+	prefix, ok := strings.CutSuffix(name, "operator")
+	return ok && (prefix == "" || strings.HasSuffix(prefix, ":") || strings.HasSuffix(prefix, " "))
+}
+
+// compileCommand is an entry of compile_commands.json.
+type compileCommand struct {
+	Directory string `json:"directory"`
+	File      string `json:"file"`
+}
+
+// readCompileCommands scans the translation units of a compilation database
+// for the functions they define.
+func (x *sourceIndex) readCompileCommands(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var commands []compileCommand
+	if err := json.Unmarshal(data, &commands); err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	for _, c := range commands {
+		file := c.File
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(c.Directory, file)
+		}
+		if seen[file] {
+			continue
+		}
+		seen[file] = true
+		lines, err := readLines(file)
+		if err != nil {
+			continue // a generated or removed unit
+		}
+		for name, line := range functionDefinitions(lines) {
+			x.add(name, sourceLocation{File: file, Line: line, CompDir: c.Directory})
+		}
+	}
+	return nil
+}
+
+// definitionRe matches the start of a function definition at the top level of
+// a C or C++ file: an optional return type, then the name and its parameters,
+// possibly on a line of its own as in the GNU style.
+var definitionRe = regexp.MustCompile(`^(?:[A-Za-z_][\w\s*&:<>,]*[\s*&])?((?:[A-Za-z_]\w*::)*~?[A-Za-z_]\w*)\s*\(`)
+
+var notFunctionNames = map[string]bool{
+	"if": true, "for": true, "while": true, "switch": true, "return": true, "sizeof": true, "defined": true,
+}
+
+// functionDefinitions finds the functions a source file defines, with the
+// line of their name. A declaration or a macro call ends in ';' before any '{'.
+func functionDefinitions(lines []string) map[string]int {
+	definitions := map[string]int{}
+	for i, line := range lines {
+		m := definitionRe.FindStringSubmatchIndex(line)
+		if m == nil {
+			continue
+		}
+		name := line[m[2]:m[3]]
+		if notFunctionNames[name] {
+			continue
+		}
+		rest := line[m[1]:]
+		for j := i + 1; j < len(lines) && j <= i+20 && !strings.ContainsAny(rest, ";{"); j++ {
+			rest += "\n" + lines[j]
+		}
+		if k := strings.IndexAny(rest, ";{"); k < 0 || rest[k] != '{' {
+			continue
+		}
+		if _, ok := definitions[name]; !ok {
+			definitions[name] = i + 1
+		}
+	}
+	return definitions
+}
+
+// tagScopeFields are the ctags fields naming the scope of a member function.
+var tagScopeFields = map[string]bool{"class": true, "struct": true, "union": true, "namespace": true, "scope": true}
+
+// readTags reads the functions of a ctags tags file, located by their line
+// field, their line number address, or else by searching their pattern.
+func (x *sourceIndex) readTags(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dir, _ := filepath.Abs(filepath.Dir(path))
+	files := map[string][]string{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "!_TAG_") {
+			continue
+		}
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) < 3 {
+			continue
+		}
+		name, file := fields[0], fields[1]
+		end := strings.LastIndex(fields[2], `;"`)
+		if end < 0 {
+			continue
+		}
+		address := fields[2][:end]
+		kind, scope, lineNo := "", "", 0
+		for _, ext := range strings.Split(fields[2][end+2:], "\t") {
+			key, value, ok := strings.Cut(ext, ":")
+			switch {
+			case !ok:
+				kind = ext
+			case key == "kind":
+				kind = value
+			case key == "line":
+				lineNo, _ = strconv.Atoi(value)
+			case tagScopeFields[key]:
+				if key == "scope" {
+					_, value, _ = strings.Cut(value, ":")
+				}
+				scope = value
+			}
+		}
+		if kind != "f" && kind != "function" {
+			continue
+		}
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		if lineNo == 0 {
+			if n, err := strconv.Atoi(address); err == nil {
+				lineNo = n
+			} else {
+				lines, ok := files[file]
+				if !ok {
+					lines, _ = readLines(file)
+					files[file] = lines
+				}
+				lineNo = findTagPattern(lines, address)
+			}
+		}
+		if scope != "" {
+			name = scope + "::" + name
+		}
+		x.add(name, sourceLocation{File: file, Line: lineNo, CompDir: dir})
+	}
+	return scanner.Err()
+}
+
+// findTagPattern returns the line a ctags search pattern such as
+// /^int main(void)$/ matches, or 0.
+func findTagPattern(lines []string, pattern string) int {
+	if len(pattern) < 2 || (pattern[0] != '/' && pattern[0] != '?') || pattern[len(pattern)-1] != pattern[0] {
+		return 0
+	}
+	text := pattern[1 : len(pattern)-1]
+	anchored := strings.HasPrefix(text, "^")
+	text = strings.TrimPrefix(text, "^")
+	whole := strings.HasSuffix(text, "$") && !strings.HasSuffix(text, `\$`)
+	text = strings.TrimSuffix(text, "$")
+	text = strings.NewReplacer(`\\`, `\`, `\/`, `/`, `\?`, `?`, `\$`, `$`).Replace(text)
+	for i, line := range lines {
+		switch {
+		case whole && anchored && line == text,
+			!whole && anchored && strings.HasPrefix(line, text),
+			whole && !anchored && strings.HasSuffix(line, text),
+			!whole && !anchored && strings.Contains(line, text):
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// readLines returns the lines of a text file.
+func readLines(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"), nil
+}
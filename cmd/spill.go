@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// --- Disk Spill for Memory-bounded Analysis ---
+
+// setEntryOverhead approximates the memory used by one map entry besides the name itself.
+const setEntryOverhead = 64
+
+// spillStore keeps function sets evicted from memory in one file per image.
+// Each line is "F <name>" for a defined function, "C <name>" for a call or
+// "N <count> <name>" for the number of calls of a function or
+// "E <count> <caller>\x00<callee>" for the calls along a call-graph edge.
+type spillStore struct {
+	dir    string
+	budget int64
+	used   int64
+	files  map[string]string // image -> spill file
+}
+
+func newSpillStore(budget int64) (*spillStore, error) {
+	dir, err := os.MkdirTemp("", "funkoverage-spill-*")
+	if err != nil {
+		return nil, err
+	}
+	return &spillStore{dir: dir, budget: budget, files: make(map[string]string)}, nil
+}
+
+// account registers a new set entry and reports whether the budget is exceeded.
+func (s *spillStore) account(nameLen int) bool {
+	s.used += int64(nameLen + setEntryOverhead)
+	return s.used > s.budget
+}
+
+// write appends the function sets of coverage to the spill files.
+func (s *spillStore) write(coverage map[string]*CoverageData) error {
+	for image, data := range coverage {
+		path, ok := s.files[image]
+		if !ok {
+			path = filepath.Join(s.dir, strconv.Itoa(len(s.files))+".spill")
+			s.files[image] = path
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		w := bufio.NewWriter(f)
+		for fn := range data.TotalFunctions {
+			fmt.Fprintf(w, "F %s\n", strings.ReplaceAll(fn, "\n", " "))
+		}
+		for fn := range data.CalledFunctions {
+			fmt.Fprintf(w, "C %s\n", strings.ReplaceAll(fn, "\n", " "))
+		}
+		for fn, n := range data.Calls {
+			fmt.Fprintf(w, "N %d %s\n", n, strings.ReplaceAll(fn, "\n", " "))
+		}
+		for e, n := range data.Edges {
+			fmt.Fprintf(w, "E %d %s\x00%s\n", n, strings.ReplaceAll(e.Caller, "\n", " "), strings.ReplaceAll(e.Callee, "\n", " "))
+		}
+		err = w.Flush()
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	s.used = 0
+	return nil
+}
+
+// merge loads the spilled sets back into coverage, one image at a time.
+func (s *spillStore) merge(coverage map[string]*CoverageData) error {
+	for image, path := range s.files {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		data, ok := coverage[image]
+		if !ok {
+			data = newCoverageData()
+			coverage[image] = data
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxLogLineSize)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if len(line) < 2 {
+				continue
+			}
+			switch line[0] {
+			case 'F':
+				data.TotalFunctions[line[2:]] = struct{}{}
+			case 'N':
+				count, fn, _ := strings.Cut(line[2:], " ")
+				n, _ := strconv.ParseUint(count, 10, 64)
+				data.addCalls(fn, n)
+			case 'E':
+				count, edge, _ := strings.Cut(line[2:], " ")
+				caller, callee, _ := strings.Cut(edge, "\x00")
+				n, _ := strconv.ParseUint(count, 10, 64)
+				data.addEdge(Edge{Caller: caller, Callee: callee}, n)
+			default:
+				data.CalledFunctions[line[2:]] = struct{}{}
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		os.Remove(path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *spillStore) cleanup() {
+	os.RemoveAll(s.dir)
+}
+
+// parseByteSize parses sizes such as "512M", "2G" or "1048576".
+func parseByteSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	multiplier := int64(1)
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		}
+		if multiplier > 1 {
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * multiplier, nil
+}
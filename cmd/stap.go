@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- SystemTap Backend ---
+
+// StapOptions describe a run traced with SystemTap instead of Pin, for
+// kernels that allow stap but forbid Pin's code injection.
+type StapOptions struct {
+	// Stap is the stap executable (default: stap from PATH).
+	Stap string
+	// Binary is the traced binary, by default the one of Command.
+	Binary string
+	LogDir string
+	// Pid attaches to a running process instead of starting Command.
+	Pid     int
+	Command []string
+}
+
+// stapQuote quotes s as a SystemTap string literal.
+func stapQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// stapScript returns the script counting the calls of every function of
+// binary in the target process and its children. Calls are keyed by the
+// symbol usymname finds, the same names as the ELF symbol table, and printed
+// when stap exits with the pid and exit status of the target.
+func stapScript(binary string) string {
+	return `global calls, exit_status = -1
+
+probe process(` + stapQuote(binary) + `).function("*").call {
+	if (target_set_pid(pid()))
+		calls[usymname(uaddr())]++
+}
+
+probe nd_syscall.exit_group {
+	if (pid() == target())
+		exit_status = status
+}
+
+probe end {
+	printf("pid\t%d\n", target())
+	foreach (name in calls)
+		printf("call\t%s\t%d\n", name, calls[name])
+	if (exit_status >= 0)
+		printf("exit\t%d\n", exit_status)
+}
+`
+}
+
+// stapArgs returns the arguments of stap writing the counts of the script
+// to output. Maps and the end probe must hold every function of the binary.
+func stapArgs(opts StapOptions, script, output string, functions int) []string {
+	args := []string{
+		"-d", opts.Binary,
+		"-DMAXMAPENTRIES=" + strconv.Itoa(functions+1024),
+		"-DMAXACTION=" + strconv.Itoa(max(1000, 4*functions)),
+		"-o", output,
+		"-e", script,
+	}
+	if opts.Pid > 0 {
+		return append(args, "-x", strconv.Itoa(opts.Pid))
+	}
+	return append(args, "-c", shellWords(opts.Command))
+}
+
+// stapResult is the output of the script.
+type stapResult struct {
+	Calls map[string]uint64
+	Pid   int
+	// Status is the exit status of the target, when it Exited normally.
+	Status int
+	Exited bool
+}
+
+// readStapOutput parses the output of the script.
+func readStapOutput(r io.Reader) (stapResult, error) {
+	res := stapResult{Calls: make(map[string]uint64)}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		var err error
+		switch {
+		case len(fields) == 3 && fields[0] == "call":
+			var n uint64
+			n, err = strconv.ParseUint(fields[2], 10, 64)
+			res.Calls[fields[1]] += n
+		case len(fields) == 2 && fields[0] == "pid":
+			res.Pid, err = strconv.Atoi(fields[1])
+		case len(fields) == 2 && fields[0] == "exit":
+			res.Status, err = strconv.Atoi(fields[1])
+			res.Exited = true
+		}
+		if err != nil {
+			return stapResult{}, fmt.Errorf("invalid stap output line %q", scanner.Text())
+		}
+	}
+	return res, scanner.Err()
+}
+
+// stapLog formats the calls counted by stap as a FuncTracer log of binary:
+// every function of its symbol table, the called ones with their counts.
+// Addresses usymname could not name are left out.
+func stapLog(binary string, syms *imageSymbols, calls map[string]uint64, pid int, started time.Time) []byte {
+	coverage := importedCoverage{}
+	img := coverage.image(binary)
+	syms.addAll(img)
+	for name, n := range calls {
+		if fn, ok := syms.named(name); ok {
+			img.hit(fn, n)
+		}
+	}
+	header := fmt.Sprintf("%s%d] [Run:%x-%x] [Import:stap]\n", formatMarker, supportedLogFormat, pid, started.UnixNano())
+	return append([]byte(header), importedLogBody(coverage)...)
+}
+
+// runStap traces the command, or the process opts.Pid, with stap and writes
+// its log into opts.LogDir. It returns the exit status of the command and
+// the log written.
+func runStap(opts StapOptions) (int, string, error) {
+	if opts.Pid <= 0 && len(opts.Command) == 0 {
+		return 0, "", errors.New("a command or --pid is required")
+	}
+	if opts.Stap == "" {
+		opts.Stap = "stap"
+	}
+	stap, err := exec.LookPath(opts.Stap)
+	if err != nil {
+		return 0, "", fmt.Errorf("stap not found, install systemtap: %w", err)
+	}
+	if opts.Binary == "" {
+		if opts.Pid > 0 {
+			opts.Binary, err = os.Readlink(fmt.Sprintf("/proc/%d/exe", opts.Pid))
+		} else {
+			opts.Binary, err = exec.LookPath(opts.Command[0])
+		}
+		if err != nil {
+			return 0, "", fmt.Errorf("could not locate the traced binary: %w", err)
+		}
+	}
+	if opts.Binary, err = filepath.Abs(opts.Binary); err != nil {
+		return 0, "", err
+	}
+	if opts.Binary, err = filepath.EvalSymlinks(opts.Binary); err != nil {
+		return 0, "", err
+	}
+	if !isELF(opts.Binary) {
+		return 0, "", fmt.Errorf("'%s' is not an ELF executable", opts.Binary)
+	}
+	if found, err := hasDebugInfo(opts.Binary); err != nil || !found {
+		return 0, "", fmt.Errorf("'%s' has no debug information, which stap needs to probe its functions", opts.Binary)
+	}
+	syms, err := loadImageSymbols(opts.Binary)
+	if err != nil {
+		return 0, "", err
+	}
+	if err := os.MkdirAll(opts.LogDir, 0755); err != nil {
+		return 0, "", err
+	}
+	out, err := os.CreateTemp(opts.LogDir, ".stap-*")
+	if err != nil {
+		return 0, "", err
+	}
+	out.Close()
+	defer os.Remove(out.Name())
+
+	started := time.Now()
+	cmd := exec.Command(stap, stapArgs(opts, stapScript(opts.Binary), out.Name(), len(syms.syms))...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return 0, "", fmt.Errorf("stap failed: %w", err)
+	}
+	wall := time.Since(started)
+	f, err := os.Open(out.Name())
+	if err != nil {
+		return 0, "", err
+	}
+	res, err := readStapOutput(f)
+	f.Close()
+	if err != nil {
+		return 0, "", err
+	}
+	log := stapLog(opts.Binary, syms, res.Calls, res.Pid, started)
+	if res.Exited {
+		log = fmt.Appendf(log, "[FuncTracer] [Exit:%d] [WallMs:%d] [Started:%d]\n", res.Status, wall.Milliseconds(), started.UnixNano())
+	}
+	path, err := writeImportedLog(opts.LogDir, opts.Binary, started, log)
+	return res.Status, path, err
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// --- Quick Coverage Stats ---
+
+// coverageStats sums up the coverage of a log directory or list of logs, or of
+// a state file saved by report --save-state (a .json file), without writing
+// any report.
+func coverageStats(input string) (CoverageTotals, error) {
+	if strings.HasSuffix(input, ".json") {
+		state, err := loadCoverageState(input)
+		if err != nil {
+			return CoverageTotals{}, err
+		}
+		return state.totals(), nil
+	}
+	logFiles, err := collectLogFiles(input)
+	if err != nil {
+		return CoverageTotals{}, err
+	}
+	coverage, stats, err := analyzeLogsWith(logFiles, AnalyzeOptions{})
+	if err != nil {
+		return CoverageTotals{}, err
+	}
+	// As report counts them.
+	mergeDuplicateImages(coverage, stats)
+	normalizeSonames(coverage)
+	collapseIFuncs(coverage)
+	addDynsymFallback(coverage)
+	return summarizeCoverage(coverage), nil
+}
+
+// totals sums up the coverage of a saved state.
+func (s *CoverageState) totals() CoverageTotals {
+	coverage := make(map[string]*CoverageData, len(s.Images))
+	for image, index := range s.Images {
+		data := newCoverageData()
+		for _, fn := range index.Functions {
+			data.TotalFunctions[fn] = struct{}{}
+		}
+		for _, fn := range index.Called {
+			data.CalledFunctions[fn] = struct{}{}
+		}
+		coverage[image] = data
+	}
+	return summarizeCoverage(coverage)
+}
+
+// printCoverageStats prints the totals on one line, or with a line per image
+// when table is set.
+func printCoverageStats(w io.Writer, totals CoverageTotals, table bool) {
+	if !table {
+		fmt.Fprintf(w, "%d images, %d functions, %d called, %.2f%%\n", len(totals.Rows), totals.TotalFunctions, totals.TotalCalled, totals.AverageCoverage)
+		return
+	}
+	width := len("TOTAL")
+	for _, row := range totals.Rows {
+		width = max(width, len(row.ImageName))
+	}
+	fmt.Fprintf(w, "%-*s %10s %10s %8s\n", width, "IMAGE", "FUNCTIONS", "CALLED", "COVERAGE")
+	for _, row := range totals.Rows {
+		fmt.Fprintf(w, "%-*s %10d %10d %7.2f%%\n", width, row.ImageName, row.TotalCount, row.CalledCount, row.CoveragePct)
+	}
+	fmt.Fprintf(w, "%-*s %10d %10d %7.2f%%\n", width, "TOTAL", totals.TotalFunctions, totals.TotalCalled, totals.AverageCoverage)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// --- Wrap Status ---
+
+// WrapStatus is a binary of the manifest and whether it is still wrapped:
+// "wrapped", "replaced" when a package update put a binary in place of its
+// wrapper (see refresh), or "missing".
+type WrapStatus struct {
+	ManifestEntry
+	State string `json:"state"`
+}
+
+// wrapStatus returns the binaries of the manifest of safeBinDir, or only the
+// given ones.
+func wrapStatus(safeBinDir string, binaries []string) ([]WrapStatus, error) {
+	m, err := loadManifest(safeBinDir)
+	if err != nil {
+		return nil, err
+	}
+	wanted := map[string]bool{}
+	for _, bin := range binaries {
+		if abs, err := filepath.Abs(bin); err == nil {
+			bin = abs
+		}
+		wanted[bin] = true
+	}
+	statuses := []WrapStatus{}
+	for _, e := range m.Entries {
+		if len(wanted) > 0 && !wanted[e.Path] {
+			continue
+		}
+		delete(wanted, e.Path)
+		s := WrapStatus{ManifestEntry: e, State: "wrapped"}
+		if _, err := os.Lstat(e.Path); errors.Is(err, os.ErrNotExist) {
+			s.State = "missing"
+		} else if !isWrapper(e.Path) {
+			s.State = "replaced"
+		}
+		statuses = append(statuses, s)
+	}
+	if len(wanted) > 0 {
+		return statuses, fmt.Errorf("not in the manifest: %s", strings.Join(sortedKeys(wanted), ", "))
+	}
+	return statuses, nil
+}
+
+// printWrapStatus writes the statuses as text, or as a JSON array.
+func printWrapStatus(w io.Writer, statuses []WrapStatus, asJSON bool) error {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(statuses)
+	}
+	if len(statuses) == 0 {
+		fmt.Fprintln(w, "No wrapped binaries.")
+		return nil
+	}
+	for _, s := range statuses {
+		fmt.Fprintf(w, "%s (%s)\n", s.Path, s.State)
+		fmt.Fprintf(w, "  original:  %s\n", s.Backup)
+		fmt.Fprintf(w, "  wrapped:   %s\n", s.WrappedAt.Format(time.RFC3339))
+		if s.BuildID != "" {
+			fmt.Fprintf(w, "  build ID:  %s\n", s.BuildID)
+		}
+		if s.SHA256 != "" {
+			fmt.Fprintf(w, "  sha256:    %s\n", s.SHA256)
+		}
+		if s.PackageName != "" {
+			pkg := s.PackageName
+			if s.PackageVersion != "" {
+				pkg += " " + s.PackageVersion
+			}
+			if s.Package != "" && s.Package != s.PackageName {
+				pkg += " (source " + s.Package + ")"
+			}
+			fmt.Fprintf(w, "  package:   %s\n", pkg)
+		} else if s.Package != "" {
+			fmt.Fprintf(w, "  package:   %s\n", s.Package)
+		}
+		var options []string
+		if s.Sample != "" {
+			options = append(options, "--sample "+s.Sample)
+		}
+		for _, r := range s.Disable {
+			options = append(options, "--disable "+r)
+		}
+		if len(s.API) > 0 {
+			options = append(options, "--api "+strings.Join(s.API, ","))
+		}
+		if s.LogName != "" {
+			options = append(options, "--log-name "+s.LogName)
+		}
+		if len(options) > 0 {
+			fmt.Fprintf(w, "  options:   %s\n", strings.Join(options, " "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// --- Stream Collector ---
+
+// A busy host running wrapped binaries leaves one log per invocation,
+// millions of tiny files in the end. Wrappers find stream-collector through
+// its socket in the log directory and stream their logs to it instead (see
+// LogWriter in FuncTracer.hpp): it appends them to one log per binary, drops
+// the function definitions the log already holds, and rotates it into a
+// compressed segment once it grows large or old.
+
+// streamSocketName is the socket of stream-collector in the log directory,
+// where wrappers look for it.
+const streamSocketName = ".funkoverage.sock"
+
+// streamHello starts each connection, naming the binary the log is filed under.
+var streamHello = []byte("[FuncTracer] [Stream:")
+
+const (
+	defaultStreamMaxSize = 64 << 20
+	defaultStreamMaxAge  = time.Hour
+)
+
+// StreamOptions configures stream-collector.
+type StreamOptions struct {
+	LogDir string
+	// Socket defaults to streamSocketName in LogDir.
+	Socket string
+	// A log is rotated once it holds MaxSize bytes or was started MaxAge ago.
+	MaxSize int64
+	MaxAge  time.Duration
+}
+
+// streamKey identifies a log being written: protobuf records and lines are
+// never mixed in one log.
+type streamKey struct {
+	binary string
+	proto  bool
+}
+
+// streamLog is the log a binary streams to, until it is rotated.
+type streamLog struct {
+	mu      sync.Mutex
+	f       *os.File
+	path    string
+	size    int64
+	started time.Time
+	// definitions holds the function definitions written to the log.
+	definitions map[string]struct{}
+}
+
+type streamCollector struct {
+	opts StreamOptions
+	mu   sync.Mutex
+	logs map[streamKey]*streamLog
+}
+
+// runStreamCollector receives the logs streamed to the socket until
+// interrupted, then rotates the logs it was writing. Processes still
+// streaming then fall back to their own log files.
+func runStreamCollector(opts StreamOptions) error {
+	if opts.Socket == "" {
+		opts.Socket = filepath.Join(opts.LogDir, streamSocketName)
+	}
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = defaultStreamMaxSize
+	}
+	if opts.MaxAge <= 0 {
+		opts.MaxAge = defaultStreamMaxAge
+	}
+	if err := os.MkdirAll(opts.LogDir, 0777); err != nil {
+		return err
+	}
+	// A socket left behind by a collector that did not stop cleanly.
+	if info, err := os.Lstat(opts.Socket); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(opts.Socket)
+	}
+	listener, err := net.Listen("unix", opts.Socket)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(opts.Socket)
+	// Wrapped binaries run as any user.
+	if err := os.Chmod(opts.Socket, 0666); err != nil {
+		listener.Close()
+		return err
+	}
+	c := &streamCollector{opts: opts, logs: map[streamKey]*streamLog{}}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(min(opts.MaxAge, time.Minute))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				listener.Close()
+				return
+			case <-done:
+				return
+			case now := <-ticker.C:
+				c.rotateAged(now)
+			}
+		}
+	}()
+	fmt.Printf("Collecting streamed logs into %s on %s\n", opts.LogDir, opts.Socket)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			close(done)
+			c.rotateAll()
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			if err := c.receive(conn); err != nil {
+				fmt.Println("stream-collector:", err)
+			}
+		}()
+	}
+}
+
+// receive appends the blocks streamed by one process to the log of its
+// binary. A block cut short by the process dying is dropped.
+func (c *streamCollector) receive(conn io.Reader) error {
+	r := bufio.NewReaderSize(conn, 64<<10)
+	hello, err := r.ReadSlice('\n')
+	if err != nil {
+		return nil
+	}
+	if !bytes.HasPrefix(hello, streamHello) {
+		return fmt.Errorf("not a FuncTracer stream: %q", hello)
+	}
+	name := string(unescapeField(bytes.TrimSuffix(bytes.TrimSuffix(hello[len(streamHello):], []byte("\n")), []byte("]"))))
+	name = unsafeNameRe.ReplaceAllString(name, "_")
+	if name == "" || name == "." || name == ".." {
+		name = "stream"
+	}
+	var log *streamLog
+	var block []byte
+	for {
+		line, err := r.ReadSlice('\n')
+		if err != nil {
+			return nil
+		}
+		size, err := strconv.ParseUint(string(bytes.TrimSuffix(line, []byte("\n"))), 10, 64)
+		if err != nil || size > uint64(maxUploadSize) {
+			return fmt.Errorf("%s: invalid block size %q", name, line)
+		}
+		if uint64(cap(block)) < size {
+			block = make([]byte, size)
+		}
+		block = block[:size]
+		if _, err := io.ReadFull(r, block); err != nil {
+			return nil
+		}
+		if log == nil {
+			log = c.log(streamKey{name, bytes.HasPrefix(block, protoRecordPrefix)})
+		}
+		if err := c.append(log, name, block); err != nil {
+			return err
+		}
+	}
+}
+
+// log returns the log of a binary, created at its first block.
+func (c *streamCollector) log(key streamKey) *streamLog {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	log, ok := c.logs[key]
+	if !ok {
+		log = &streamLog{}
+		c.logs[key] = log
+	}
+	return log
+}
+
+// append writes a block to the log of the binary name in one piece, without
+// the function definitions the log already holds.
+func (c *streamCollector) append(log *streamLog, name string, block []byte) error {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	if log.f == nil {
+		started := time.Now()
+		path := filepath.Join(c.opts.LogDir, fmt.Sprintf("%s_%s_%09d.log", name, started.Format("20060102-150405"), started.Nanosecond()))
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return err
+		}
+		log.f, log.path, log.size, log.started, log.definitions = f, path, 0, started, map[string]struct{}{}
+	}
+	out := block[:0:0]
+	for _, unit := range splitLogBlock(block) {
+		if isDefinition(unit) {
+			if _, ok := log.definitions[string(unit)]; ok {
+				continue
+			}
+			log.definitions[string(unit)] = struct{}{}
+		}
+		out = append(out, unit...)
+	}
+	n, err := log.f.Write(out)
+	log.size += int64(n)
+	if err != nil {
+		return err
+	}
+	if log.size >= c.opts.MaxSize {
+		return log.rotate()
+	}
+	return nil
+}
+
+// splitLogBlock splits a block into its lines, or its protobuf records.
+func splitLogBlock(block []byte) [][]byte {
+	var units [][]byte
+	for len(block) > 0 {
+		n := len(block)
+		if bytes.HasPrefix(block, protoRecordPrefix) {
+			size, m := binary.Uvarint(block[len(protoRecordPrefix):])
+			if m > 0 && size <= uint64(len(block)-len(protoRecordPrefix)-m) {
+				n = len(protoRecordPrefix) + m + int(size)
+			}
+		} else if i := bytes.IndexByte(block, '\n'); i >= 0 {
+			n = i + 1
+		}
+		units = append(units, block[:n])
+		block = block[n:]
+	}
+	return units
+}
+
+// isDefinition reports whether a line or protobuf record of a log defines a
+// function, which every invocation of a binary writes again.
+func isDefinition(unit []byte) bool {
+	if record, ok := bytes.CutPrefix(unit, protoRecordPrefix); ok {
+		_, n := binary.Uvarint(record)
+		kind, _, _, _, ok := protoField(record[max(n, 0):])
+		return n > 0 && ok && kind == protoFunction
+	}
+	if bytes.HasPrefix(unit, []byte("{")) {
+		var ev logEvent
+		return parseJSONEvent(bytes.TrimSuffix(unit, []byte("\n")), &ev) && string(ev.Event) == "function"
+	}
+	kind, _, _, _, _ := parseLogLine(unit)
+	return kind == lineFunction
+}
+
+// rotate closes the log and compresses it into a .log.gz segment, so the
+// next block starts a new log.
+func (log *streamLog) rotate() error {
+	if log.f == nil {
+		return nil
+	}
+	err := log.f.Close()
+	log.f, log.definitions = nil, nil
+	if err != nil {
+		return err
+	}
+	return compressLog(log.path)
+}
+
+// compressLog replaces a log by its .gz, which appears whole.
+func compressLog(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp := path + ".gz.tmp"
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	_, err = io.Copy(gz, src)
+	if cerr := gz.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path+".gz")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(path)
+}
+
+// rotateAged rotates the logs started MaxAge ago.
+func (c *streamCollector) rotateAged(now time.Time) {
+	for _, log := range c.streamLogs() {
+		log.mu.Lock()
+		if log.f != nil && now.Sub(log.started) >= c.opts.MaxAge {
+			if err := log.rotate(); err != nil {
+				fmt.Println("stream-collector:", err)
+			}
+		}
+		log.mu.Unlock()
+	}
+}
+
+// rotateAll rotates every log, once no more blocks come.
+func (c *streamCollector) rotateAll() {
+	for _, log := range c.streamLogs() {
+		log.mu.Lock()
+		if err := log.rotate(); err != nil {
+			fmt.Println("stream-collector:", err)
+		}
+		log.mu.Unlock()
+	}
+}
+
+func (c *streamCollector) streamLogs() []*streamLog {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	logs := make([]*streamLog, 0, len(c.logs))
+	for _, log := range c.logs {
+		logs = append(logs, log)
+	}
+	return logs
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ianlancetaylor/demangle"
+)
+
+// --- C++ Constructor and Destructor Variants ---
+
+// The Itanium C++ ABI emits several clones of constructors (complete C1,
+// base C2, allocating C3, inheriting CI1/CI2) and destructors (deleting D0,
+// complete D1, base D2), which all demangle to the same name. A code is told
+// from the same letters in a name by swapping it for its alternate here.
+var structorAlternates = map[string]string{
+	"C1": "C2", "C2": "C1", "C3": "C1", "CI1": "CI2", "CI2": "CI1",
+	"D0": "D1", "D1": "D2", "D2": "D1",
+}
+
+// structorVariant returns the variant of the constructor or destructor
+// mangled as symbol, whose demangled name is demangled. A variant code only
+// counts when its alternate demangles to the same name.
+func structorVariant(symbol, demangled string) string {
+	if !strings.HasPrefix(symbol, "_Z") || symbol == demangled {
+		return ""
+	}
+	if !strings.Contains(demangled, "::") {
+		return ""
+	}
+	// Longest codes first, so CI1 is not taken for C1 of a name.
+	for _, code := range []string{"CI1", "CI2", "C1", "C2", "C3", "D0", "D1", "D2"} {
+		for i := strings.LastIndex(symbol, code); i > 2; i = strings.LastIndex(symbol[:i], code) {
+			alternate := symbol[:i] + structorAlternates[code] + symbol[i+len(code):]
+			if demangle.Filter(alternate) == demangled {
+				return code
+			}
+		}
+	}
+	return ""
+}
+
+// printStructorVariants lists which variants ran for each constructor and
+// destructor with variants.
+func printStructorVariants(coverage map[string]*CoverageData) {
+	images := []string{}
+	for image, data := range coverage {
+		if len(data.Variants) > 0 {
+			images = append(images, image)
+		}
+	}
+	if len(images) == 0 {
+		return
+	}
+	sort.Strings(images)
+	fmt.Println("\n====== Constructor and Destructor Variants =======")
+	for _, image := range images {
+		fmt.Printf("  %s:\n", filepath.Base(image))
+		for _, fn := range sortedKeys(coverage[image].Variants) {
+			fmt.Printf("    - %s: %s\n", printableSymbol(fn), strings.Join(coverage[image].Variants[fn], ", "))
+		}
+	}
+	fmt.Println("==================================================")
+}
+
+// mergeSorted returns the union of two sorted lists of variants, sorted.
+func mergeSorted(a, b []string) []string {
+	set := make(map[string]struct{}, len(a)+len(b))
+	for _, s := range append(a[:len(a):len(a)], b...) {
+		set[s] = struct{}{}
+	}
+	return sortedKeys(set)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// --- Machine-Readable Summary ---
+
+// summaryFileName is written into the output directory of every report run,
+// whichever formats were selected, for CI steps to read the totals from.
+const summaryFileName = "summary.json"
+
+// summaryVersion is bumped when fields of summary.json change meaning or go away.
+const summaryVersion = 1
+
+// ReportSummary is the content of summary.json: the coverage totals and
+// per-image rows of the run, and what the run read and wrote.
+type ReportSummary struct {
+	Version     int      `json:"version"`
+	GeneratedAt string   `json:"generated_at"`
+	Inputs      []string `json:"inputs"`
+	Formats     []string `json:"formats"`
+	// Artifacts are the report files written, summary.json aside.
+	Artifacts []string `json:"artifacts"`
+	// Logs counts the logs analyzed; duplicate copies of a run are counted apart.
+	Logs          int `json:"logs"`
+	DuplicateLogs int `json:"duplicate_logs"`
+	MalformedLogs int `json:"malformed_logs"`
+	// Gates are the checks of the images against the targets of the config, if any.
+	Gates []GateResult `json:"gates,omitempty"`
+	// Metadata describes the hosts, tools and logs of the run.
+	Metadata *RunMetadata `json:"metadata,omitempty"`
+	CoverageTotals
+}
+
+// newReportSummary sums up a report run.
+func newReportSummary(opts ReportOptions, stats []LogStats, artifacts []string, totals CoverageTotals, generatedAt time.Time) ReportSummary {
+	duplicates := len(duplicateLogs(stats))
+	return ReportSummary{
+		Version:        summaryVersion,
+		GeneratedAt:    generatedAt.Format(time.RFC3339),
+		Inputs:         opts.Inputs,
+		Formats:        opts.Formats,
+		Artifacts:      artifacts,
+		Logs:           len(stats) - duplicates,
+		DuplicateLogs:  duplicates,
+		MalformedLogs:  len(malformedLogs(stats)),
+		CoverageTotals: totals,
+	}
+}
+
+// writeReportSummary writes summary.json into outputDir.
+func writeReportSummary(outputDir string, summary ReportSummary) error {
+	content, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(outputDir, summaryFileName), append(content, '\n'), 0644)
+}
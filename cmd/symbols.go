@@ -0,0 +1,124 @@
+package main
+
+import (
+	"debug/elf"
+	"strings"
+	"sync"
+
+	"github.com/ianlancetaylor/demangle"
+)
+
+// --- Stripped Binaries ---
+
+// strippedImage describes an image without a .symtab. Its totals come from
+// its detached debuginfo when available, otherwise only from the exported
+// (dynsym) functions, which makes the symbol info partial.
+type strippedImage struct {
+	stripped  bool
+	partial   bool
+	functions []string
+}
+
+var (
+	strippedCacheMu sync.Mutex
+	strippedCache   = map[string]strippedImage{}
+)
+
+// funcIsRelevant mirrors func_is_relevant of FuncTracer.hpp, so the fallback
+// counts the same kind of functions the tracer logs.
+func funcIsRelevant(name string) bool {
+	switch name {
+	case "main", "_init", "_start", ".plt.got", ".plt":
+		return false
+	}
+	return !strings.HasSuffix(name, "@plt") && !strings.HasPrefix(name, "__")
+}
+
+// isStripped reports whether f lacks a full symbol table.
+func isStripped(f *elf.File) bool {
+	return f.Section(".symtab") == nil
+}
+
+// definedFunctions returns the relevant functions defined in syms, demangled.
+func definedFunctions(syms []elf.Symbol) []string {
+	functions := []string{}
+	for _, sym := range syms {
+		t := elf.ST_TYPE(sym.Info)
+		if (t != elf.STT_FUNC && t != elf.STT_GNU_IFUNC) || sym.Section == elf.SHN_UNDEF || !funcIsRelevant(sym.Name) {
+			continue
+		}
+		functions = append(functions, demangle.Filter(sym.Name))
+	}
+	return functions
+}
+
+// debugFunctions reads the functions of the detached debuginfo of image.
+func debugFunctions(image string) ([]string, bool) {
+	debugPath, err := findDebugFile(image)
+	if err != nil || debugPath == "" {
+		return nil, false
+	}
+	f, err := elf.Open(debugPath)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+	syms, err := f.Symbols()
+	if err != nil {
+		return nil, false
+	}
+	return definedFunctions(syms), true
+}
+
+// inspectImage reads the symbol information of image. Images that are no
+// longer readable are reported as not stripped, so they are left untouched.
+func inspectImage(image string) strippedImage {
+	strippedCacheMu.Lock()
+	defer strippedCacheMu.Unlock()
+	if info, ok := strippedCache[image]; ok {
+		return info
+	}
+	info := strippedImage{}
+	if f, err := elf.Open(image); err == nil {
+		if isStripped(f) {
+			info.stripped = true
+			if functions, ok := debugFunctions(image); ok {
+				info.functions = functions
+			} else {
+				syms, _ := f.DynamicSymbols()
+				info.functions = definedFunctions(syms)
+				info.partial = true
+			}
+		}
+		f.Close()
+	}
+	strippedCache[image] = info
+	return info
+}
+
+// addDynsymFallback completes the totals of stripped images with the functions
+// of their detached debuginfo or, lacking that, their exported functions, and
+// returns the set of images left with partial symbol info.
+func addDynsymFallback(coverage map[string]*CoverageData) map[string]bool {
+	partial := make(map[string]bool)
+	for image, data := range coverage {
+		info := inspectImage(image)
+		if !info.stripped {
+			continue
+		}
+		if info.partial {
+			partial[image] = true
+		}
+		known := make(map[string]struct{}, len(data.TotalFunctions))
+		for fn := range data.TotalFunctions {
+			name, _ := splitSymbolVersion(fn)
+			known[name] = struct{}{}
+		}
+		for _, fn := range info.functions {
+			if _, ok := known[fn]; !ok {
+				data.TotalFunctions[fn] = struct{}{}
+			}
+		}
+	}
+	return partial
+}
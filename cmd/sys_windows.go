@@ -0,0 +1,34 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// statFileID is not available on Windows, where logs still written are told
+// by their modification time only.
+func statFileID(info os.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
+
+// fileOwner is not available on Windows, whose files have no Unix owner.
+func fileOwner(info os.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
+
+// freeBytes returns the space available to the user on dir's volume.
+func freeBytes(dir string) uint64 {
+	path, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0
+	}
+	var free uint64
+	proc := syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+	if r, _, _ := proc.Call(uintptr(unsafe.Pointer(path)), uintptr(unsafe.Pointer(&free)), 0, 0); r == 0 {
+		return 0
+	}
+	return free
+}
@@ -9,22 +9,363 @@ import (
 //go:embed templates/detailed.html
 var detailedHTMLTemplateStr string
 
+//go:embed templates/source.html
+var sourceHTMLTemplate string
+
 //go:embed templates/aggregate.html
 var aggregateHTMLTemplate string
 
-const wrapHelpText = `Usage: funkoverage wrap /path/to/binary
-Wrap the given ELF binary with the Pin coverage wrapper.`
+//go:embed templates/nav.html
+var navHTMLTemplate string
+
+//go:embed templates/dashboard.html
+var dashboardHTMLTemplate string
+
+//go:embed templates/single.html
+var singleFileHTMLTemplate string
 
-const unwrapHelpText = `Usage: funkoverage unwrap /path/to/binary
-Restore the original binary previously wrapped.`
+const wrapHelpText = `Usage: funkoverage wrap [--sample N|P%] [--disable <rule>] [--api <libs>] [--log-name <template>] [--rewrap] /path/to/binary
+Wrap the given ELF binary with the Pin coverage wrapper. On Windows, PE executables with a PDB
+next to them are replaced by a copy of funkoverage launching them under pin.exe with FuncTracer.dll.
+Binaries already wrapped are skipped.
+  --sample           Trace only every Nth invocation (N) or a random share of them (P%),
+                     running the original binary directly otherwise
+  --disable          Run the original binary untraced when all the comma-separated conditions
+                     of the rule hold: uid=N, user=NAME, cwd=DIR (or below), boot (systemd still
+                     starting), e.g. "uid=0,boot" (repeatable; see also wrap.disable in the config)
+  --api              Library API mode: trace only the functions the comma-separated shared
+                     libraries export (file names like libssl.so.3, or prefixes like libssl),
+                     counting the calls other images make to them; FUNKOVERAGE_API overrides it
+  --log-name         Template of the log file names, ending in .log, from {binary}, {pid}, {session}
+                     (COVERAGE_SESSION), {host}, {timestamp} and {nanos}, with {pid} or {nanos} among them
+                     (default: {binary}_{timestamp}_{nanos}.log; see also wrap.log_name in the config)
+  --rewrap           Regenerate the wrapper of binaries already wrapped with the current template
+                     and these options, keeping their backup`
 
-const reportHelpText = `Usage: funkoverage report <inputdir|log1.txt,log2.txt> <outputdir> [--formats <formats>]
+const unwrapHelpText = `Usage: funkoverage unwrap [--force] /path/to/binary
+Restore the original binary previously wrapped, once its backup matches the SHA-256 recorded at wrap time.
+  --force            When the wrapper was edited, the backup moved or it fails its checksum, restore the original
+                     all the same: from the backup the manifest names, the file of SAFE_BIN_DIR with the SHA-256
+                     (or build ID) the manifest recorded, or else the recorded package version, downloaded and
+                     extracted; a backup failing its checksum is the last resort, restored with a warning`
 
-Generate coverage reports from log files.
-  <inputdir>         Directory containing .log files (all will be used)
+const reportHelpText = `Usage: funkoverage report [--formats <formats>] [--group-by package|session] [--image <pattern>] <input>... <outputdir>
+
+Generate coverage reports from log files. Several inputs can be given, each one of:
+  <inputdir>         Directory containing .log (and .log.gz) files, in subdirectories too (all will be used)
   log1.txt,log2.txt  Comma-separated list of log files
-  <outputdir>        Output directory for reports (mandatory)
-  --formats          Comma-separated list: html,xml,txt (default: html,txt,xml)
+  <glob>             Pattern of log files or directories, where ** matches any number of
+                     directories, e.g. 'logs/host-*/**/*.log' (quoted against the shell)
+  <outputdir>        Output directory for reports (mandatory, outside the log directory)
+  --formats          Comma-separated list: html,xml,cobertura,txt,dot,folded,flamegraph,perfetto (default:
+                     html,txt,xml); cobertura writes Cobertura XML with C++ namespaces as packages and
+                     classes as classes, dot writes Graphviz call graphs from logs recorded with FUNKOVERAGE_EDGES=1,
+                     folded and flamegraph the call counts as folded stacks and as an SVG flame graph,
+                     perfetto a Chrome/Perfetto trace (trace.json) of the first calls recorded with
+                     FUNKOVERAGE_TIMELINE=1
+  --group-by         Merge images into one row and page per source package (from the wrap
+                     manifest, else rpm/dpkg): package, or add the coverage reached by each
+                     COVERAGE_SESSION: session
+  --session          Comma-separated sessions (COVERAGE_SESSION) whose logs are reported
+  --tag              Comma-separated tags (COVERAGE_TAGS): report only the logs carrying any of them
+  --jobs             Number of per-image reports generated in parallel (default: number of CPUs)
+  --max-memory       Spill the analysis to temporary files beyond this size, e.g. 512M or 2G
+  --symbol-versions  Keep ELF symbol versions (memcpy@GLIBC_2.14) in function names
+  --clone-suffixes   Keep the copies optimizers make of functions (foo.constprop.0, foo.lto_priv.0,
+                     foo.cold) apart instead of merging them into the function they were made from
+  --no-demangle      Report C++ functions under their mangled symbol (_ZN3FooC2Ev); otherwise the
+                     HTML reports and JSON outputs carry the symbol next to the demangled name
+  --path-map         Rewrite image path prefixes before merging, old=new (repeatable)
+  --no-soname        Report shared libraries under the name of their file (libfoo.so.1.2.3) instead of
+                     their soname (libfoo.so.1), which merges the versions traced during a campaign
+  --aliases          File of "from = to" rules renaming functions, "re:" for regular expressions
+                     whose groups "to" uses as $1; the functions renamed alike are merged
+  --preset           Comma-separated presets of functions left out of the coverage, after demangling:
+                     cxx-internals (std::, __gnu_cxx:: and __cxxabiv1::, the standard containers)
+  --inlined          Count the functions inlined into called functions as called, covered via inlining,
+                     from the DWARF debug info; those only ever inlined are added to the functions
+  --image            Report only the images matching a glob on the path or base name (libssl*,
+                     /usr/sbin/*), or a regex with re: (re:^/opt/); a leading ! excludes the
+                     matches instead (repeatable)
+  --top              Number of most called functions listed per image, 0 disables (default: 10)
+  --namespace-depth  Levels of C++ namespaces the per-namespace coverage is summed up by (default: 1);
+                     C functions are summed up by name prefix (png_, g_)
+  --xml-uncalled-as  Make each function its own XUnit test case, passed when called, and skipped
+                     (skipped) or failed (failure) when not, instead of listing them in one test case
+  --xml-chunk-size   Split the XUnit report of the images with more functions into
+                     coverage_<image>_part<N>.xml files of as many functions, listed in xunit-index.json
+  --html-single-file  Also bundle the HTML pages into coverage-report.html, a standalone page showing
+                     them all, to attach to mails and bug trackers
+  --compress         Replace the HTML, XML and JSON files of the output directory of 16 KiB or more
+                     by gzipped copies (<file>.gz), listed in compressed-index.json, for web servers
+                     serving pre-compressed files; summary.json is left as it is
+  --paginate-above   Render the function list of the images with more functions page by page, with a
+                     filter, from JSON embedded in their HTML page (default: 10000, -1 never)
+  --dot-min-calls    Leave call-graph edges taken fewer times out of the dot output (default: 1)
+  --source-root      Source tree of the traced programs: the HTML report links each function to its
+                     definition in per-file pages of the sources, for images with debug info
+  --source-url       Repository URL template the HTML report links functions to, e.g.
+                     https://github.com/org/repo/blob/{rev}/{file}#L{line}
+  --source-rev       Revision substituted for {rev} in --source-url (default: HEAD)
+  --source-index     compile_commands.json or ctags tags file locating the functions of the images
+                     without debug info, for --source-root and --source-url
+  --save-state       Write the per-function coverage of this run to a JSON state file
+  --baseline         State file of a previous run: the HTML reports mark images and functions
+                     as new, regressed, improved or unchanged and sum up the changes
+  --compare          summary.json or XUnit XML of an earlier report, or its output directory: the
+                     text and HTML reports show each image's coverage change since, without its logs
+  --history          Directory keeping a JSON coverage snapshot per run; the aggregate report then
+                     shows a coverage sparkline per image and the change since the previous run
+  --thresholds       Coverage percentages below which images are red and yellow in the aggregate
+                     report, <red>,<green> (default: 50,80)
+  --below            List only the images below this coverage percentage in the text and aggregate
+                     reports, to find the worst covered ones
+  --above            List only the images above this coverage percentage, e.g. --above 90
+  --min-image-coverage  Same as --below
+  --no-summary       Do not write summary.json, the totals and per-image rows of the run for CI
+                     steps, into the output directory (written whatever the formats)
+  --ifunc-variants   List which IFUNC implementation (e.g. __memcpy_avx_unaligned) ran per function
+  --structor-variants  List which C++ constructor and destructor variants (C1, C2, D0...) ran; the
+                     variants, which demangle alike, are always counted as one function
+  --reachability     Group the uncalled functions by their static call graph: reachable from main,
+                     only reachable via plugin entry points (exported functions), or apparently
+                     unreachable (x86, x86-64 and AArch64 images)
+  --strict           Fail when a log has more malformed lines than --max-malformed
+  --max-malformed    Tolerated percentage of malformed lines per log (default: 1)
+  --timestamp        Generation time written into the reports, Unix seconds or RFC 3339,
+                     for byte-identical output (default: SOURCE_DATE_EPOCH, else now)
+  --live-logs        Logs still written by a running program: tail (read complete lines) or skip (default: tail)
+
+The per-image coverage targets under gates in the config file are checked after every run:
+the report then prints a pass/fail table and exits with status 1 when an image misses its target.
+`
+
+const serveHelpText = `Usage: funkoverage serve [--addr <addr>] <inputdir|log1.txt,log2.txt>
+
+Serve coverage data over HTTP, compatible with the Grafana JSON and Infinity datasources.
+  /search, /query    Grafana JSON datasource (timeseries per image, "uncalled" table, or a table per image)
+  /api/coverage      Coverage timeseries per image as plain JSON
+  /api/uncalled      Uncalled functions as plain JSON
+  /api/hot?top=<n>   Most called functions per image as plain JSON (default: 10)
+  /api/namespaces?depth=<n>  Coverage per C++ namespace or C name prefix per image as plain JSON
+  /api/sessions      Coverage reached by each COVERAGE_SESSION as plain JSON
+  Every endpoint takes ?session=<a,b> and ?tag=<x,y> to restrict the logs to sessions or tags.
+  --addr             Address to listen on (default: :8080)
+`
+
+const collectHelpText = `Usage: funkoverage collect --hosts <hostsfile> --dest <dir> [--jobs <n>] [--report <outputdir>]
+
+Fetch coverage logs from remote hosts over SSH (rsync), tagging each file with its host.
+  --hosts            File listing one [user@]host per line
+  --dest             Local directory receiving <host>_<log> files
+  --remote-dir       Log directory on the remote hosts (default: /var/coverage/data)
+  --jobs             Number of hosts collected in parallel (default: 4)
+  --report           Run report on the collected logs into this directory
+`
+
+const collectorHelpText = `Usage: funkoverage collector [--addr <addr>] <storedir>
+
+Receive coverage logs over HTTP and store them in <storedir> for report/serve.
+  POST /api/logs?name=<file>&host=<host>&product=<p>&run=<r>
+                     Upload one log (body may be gzip, see Content-Encoding)
+  GET  /api/logs     List stored logs
+  POST /api/agents/heartbeat
+                     Register or refresh a fleet agent (see agent), returning its pending instructions
+  POST /api/agents/instructions?host=<host|*>&action=wrap|unwrap&path=<binary>
+                     Queue a wrap or unwrap for one agent, or for all of them with host=* (admin token)
+  GET  /api/agents   List the agents with their last heartbeat, health and instruction results
+                     (admin token)
+  /dashboard         Coverage merged per host and per run (add ?product=<p> to filter)
+  /api/dashboard     Same data as JSON
+  --addr             Address to listen on (default: :8081)
+Clients authenticate with "Authorization: Bearer <token>" (collector.token in the config file);
+operators queueing agent instructions with collector.admin_token, which the agents do not hold.
+Without a token, the /api/agents endpoints are not served.
+`
+
+const streamCollectorHelpText = `Usage: funkoverage stream-collector [--socket <path>] [--max-size <bytes>] [--max-age <d>] [<logdir>]
+
+Receive the logs of wrapped binaries over a unix socket instead of one file per invocation: while
+the socket (<logdir>/.funkoverage.sock) exists, wrappers stream their logs to it. Each binary gets
+one log in <logdir> (default: $LOG_DIR or /var/coverage/data) holding each function definition
+once, rotated into a gzip-compressed .log.gz once large or old; report reads both. Wrappers fall
+back to their own log files should the collector stop.
+  --socket           Unix socket to listen on (default: .funkoverage.sock in <logdir>)
+  --max-size         Rotate a log once it holds this many bytes (default: 64 MiB)
+  --max-age          Rotate a log once it was started this long ago (default: 1h)
+`
+
+const explainHelpText = `Usage: funkoverage explain [--json] <inputdir|log1.txt,log2.txt>
+
+Describe the invocation behind each log: time, host, working directory, command line,
+recorded environment variables, exit status, and the coverage and build ID of its images.
+  --json             Print the descriptions as a JSON array
+`
+
+const statsHelpText = `Usage: funkoverage stats [--table] <inputdir|log1.txt,log2.txt|state.json>
+
+Print the images, functions, called functions and overall coverage of the logs, or of a state saved
+by report --save-state, on one line and without writing any report: quick enough for a shell prompt
+or a CI log.
+  --table            Print a line per image besides the totals
+`
+
+const importHelpText = `Usage: funkoverage import --format <format> [--image <path>] [--binary <path>] <input>... <outputdir>
+
+Convert coverage data of other tools into FuncTracer logs, written into <outputdir> (one log per
+input) and read by report, serve and explain like the logs of wrapped binaries.
+  --format           drcov (DynamoRIO), gcov (.gcov with -b, .gcov.json(.gz), or a directory of them),
+                     lcov (tracefile) or sancov (SanitizerCoverage .sancov)
+  --image            Path of the binary lcov and gcov data describe (mandatory for them)
+  --binary           ELF binary the sancov offsets belong to (mandatory for sancov)
+drcov and sancov only record which code ran: their functions are called, without call counts.
+`
+
+const containerHelpText = `Usage: funkoverage container run --image <image> [--engine podman|docker] [--log-dir <dir>] [--wrap <binaries>] -- <command> [args...]
+
+Run a command in a container with its binary wrapped: Pin ($PIN_ROOT), FuncTracer.so and funkoverage
+itself are mounted read-only, and the logs written in the container are moved into the log directory,
+prefixed with the image name. COVERAGE_SESSION, COVERAGE_TAGS and the FUNKOVERAGE_* variables are
+passed on. Exits with the status of the command.
+  --image            Container image to run the command in (mandatory)
+  --engine           Container engine: podman or docker (default: whichever is installed, podman first)
+  --log-dir          Directory receiving the logs (default: $LOG_DIR or /var/coverage/data)
+  --wrap             Comma-separated binaries of the container to wrap besides the command
+`
+
+const agentHelpText = `Usage: funkoverage agent [--url <collector>] [--host <name>] [--product <p>] [--interval <d>] [--once]
+
+Run as a fleet agent reporting to a collector: every interval, ship the finished logs of $LOG_DIR
+gzip-compressed (each log once, tracked in $LOG_DIR/.funkoverage-agent.json), send a heartbeat with
+the health of the host (wrapped binaries, pending logs, free space, last error), and apply the wraps
+and unwraps queued at the collector. The token is agent.token in the config file; without one, the
+agent only ships logs. Meant to run as a systemd service or a Kubernetes DaemonSet (see the README).
+  --url              Base URL of the collector, e.g. http://collector:8081 (default: agent.url)
+  --host             Name of this host at the collector (default: agent.host, else the hostname)
+  --product          Product the shipped logs are recorded under (default: agent.product)
+  --interval         Time between two rounds (default: agent.interval, else 5m)
+  --once             Run a single round and exit
+`
+
+const generateHooksHelpText = `Usage: funkoverage generate-hooks --format zypper|dnf|apt [--output <root>]
+
+Generate package manager hooks running funkoverage refresh after every transaction, so binaries an
+update put in place of their wrapper are wrapped again. PIN_ROOT, PIN_TOOL_SEARCH_DIR, LOG_DIR,
+SAFE_BIN_DIR and FUNKOVERAGE_CONFIG are baked into the hooks when set.
+  --format           zypper (libzypp commit plugin, needs python3-zypp-plugin), dnf (dnf 4 plugin
+                     and dnf 5 actions file) or apt (DPkg::Post-Invoke in apt.conf.d)
+  --output           Install the hooks below this root directory, / for this system (default: print them)
+`
+
+const refreshHelpText = `Usage: funkoverage refresh
+
+Wrap again, with their wrap options, the binaries of the manifest a package update put in place of
+their wrapper, and drop the binaries removed since, with their backup. Run by the hooks of generate-hooks.
+`
+
+const recoverHelpText = `Usage: funkoverage recover [--manifest <file>]
+
+Wrap again, with their wrap options, the binaries of a manifest that are no longer wrapped, e.g. after
+a system restore or the redeploy of a golden image. Binaries are first verified against the build ID,
+else the package version, the manifest recorded: missing binaries, and builds other than the one
+wrapped, are skipped.
+  --manifest         Manifest whose wraps are applied again, e.g. one saved with the golden image
+                     (default: manifest.json of SAFE_BIN_DIR)
+`
+
+const upgradeWrappersHelpText = `Usage: funkoverage upgrade-wrappers [--dry-run]
+
+Regenerate the wrapper scripts and launchers of the binaries of the manifest with the template of this
+funkoverage, after an upgrade, keeping their backup and the wrap options the manifest records. Binaries
+no longer wrapped are skipped (see refresh and recover).
+  --dry-run          List the wrappers to regenerate without touching them
+`
+
+const startWindowHelpText = `Usage: funkoverage start-window [--log-dir <dir>] <label>
+
+Open a coverage window: from now on until stop-window, wrapped binaries logging to the log directory
+are traced, their logs tagged with the label (see report --tag). Once windows are used, runs outside
+of them are not traced. Starting a window closes the open one.
+  --log-dir          Log directory of the wrapped binaries (default: $LOG_DIR or /var/coverage/data)
+`
+
+const stopWindowHelpText = `Usage: funkoverage stop-window [--log-dir <dir>] [--reset]
+
+Close the open coverage window: wrapped binaries run untraced until the next start-window.
+  --log-dir          Log directory of the wrapped binaries (default: $LOG_DIR or /var/coverage/data)
+  --reset            Stop using coverage windows, tracing every run again
+`
+
+const ctlHelpText = `Usage: funkoverage ctl serve|enable <pattern>|disable <pattern>|reset|status [--log-dir <dir>] [--socket <path>]
+
+Turn tracing off and on per binary name pattern, without rewrapping: a kill switch for when the
+instrumentation overhead gets in the way. Wrapped binaries of the log directory read the rules on every
+run; the last rule whose glob pattern matches the name a binary was called by applies, and binaries no
+rule matches are traced.
+  serve              Serve the control socket (the owner's only), saving the rules for the wrappers
+  disable <pattern>  Run the matching binaries untraced, e.g. "*" for all of them
+  enable <pattern>   Trace the matching binaries again, e.g. after disabling "*"
+  reset              Drop all the rules: every binary is traced
+  status             Print the rules in force
+Without a server on the socket, ctl updates the rules itself.
+  --log-dir          Log directory of the wrapped binaries (default: $LOG_DIR or /var/coverage/data)
+  --socket           Control socket (default: .funkoverage.ctl in the log directory)
+`
+
+const reportdHelpText = `Usage: funkoverage reportd
+
+Watch the log directory and regenerate the reports whenever new logs came in, one generation at a time:
+once the logs stopped changing for reportd.quiet (default 1m), or every reportd.interval (default 15m)
+while they keep changing. It keeps the history of report --history, runs the report hooks, and can
+serve the reports over HTTP, with the outcome of the last generation at /reportd/status. All the
+settings are under "reportd" in the config file: output_dir (mandatory), log_dir (default: $LOG_DIR),
+formats (default: html,xml), history_dir, quiet, interval and addr (e.g. ":8080").
+`
+
+const statusHelpText = `Usage: funkoverage status [--json] [/path/to/binary...]
+
+List the wrapped binaries of the manifest, or the given ones: where their original is, when they were
+wrapped and with which options, the GNU build ID of the original and the package and version owning
+it, which tell the build the coverage was measured on. A binary a package update put in place of its
+wrapper is "replaced" (see refresh).
+  --json             Print the wrapped binaries as a JSON array
+`
+
+const stapHelpText = `Usage: funkoverage stap [--binary <path>] [--log-dir <dir>] (--pid <pid> | -- <command> [args...])
+
+Trace a command, or a running process, with SystemTap instead of Pin, for kernels that allow stap but
+forbid Pin's code injection: every function of the binary with debug info is probed with uprobes, and
+the calls of the process and its children are written as a log of the log directory, with call counts.
+Exits with the status of the command. Needs the privileges of stap (root, or the stapusr group).
+  --binary           Binary whose functions are probed (default: the one of the command or --pid)
+  --pid              Trace this running process until it exits instead of starting a command
+  --log-dir          Directory receiving the log (default: $LOG_DIR or /var/coverage/data)
+  --stap             stap executable (default: stap)
+`
+
+const fuzzBridgeHelpText = `Usage: funkoverage fuzz-bridge [--map-size <bytes>] [--output <file>] -- <command> [args...]
+
+Run a system binary as the coverage-guided target of a fuzzer of the AFL family (AFL, AFL++): the
+command runs under Pin with FuncTracer -afl, which counts the transitions between its functions in
+the fuzzer's coverage map (__AFL_SHM_ID, AFL_MAP_SIZE). A wrapped binary is run through its wrapper,
+which does the same whenever __AFL_SHM_ID is set. Outside a fuzzer, a map is created and written to
+--output like afl-showmap -r. Fuzzers need AFL_NO_FORKSRV=1 and AFL_SKIP_BIN_CHECK=1 for it:
+  AFL_NO_FORKSRV=1 AFL_SKIP_BIN_CHECK=1 afl-fuzz -i in -o out -- funkoverage fuzz-bridge -- /usr/bin/xmllint @@
+  --map-size         Size of the map created outside a fuzzer (default: $AFL_MAP_SIZE, else 65536)
+  --output           File receiving the map of a run outside a fuzzer
+`
+
+const eventsHelpText = `Usage: funkoverage events [--since <time>] [--binary <name>] [--event <kind>] [--file <syslog>] [--json]
+
+List the instrumentation events wrapped binaries log to journald/syslog (tag "funkoverage"):
+start when pin is launched, stop with the exit status of the run, and failure when pin
+could not start or FuncTracer could not open the coverage log.
+  --since            Events from this time on: a duration back from now (2h), Unix seconds or RFC 3339
+  --binary           Only the events of this wrapped binary name
+  --event            Only events of this kind: start, stop or failure
+  --file             Read a syslog file instead of the journal (default: journalctl, else
+                     /var/log/messages or /var/log/syslog)
+  --json             Print the events as a JSON array
 `
 
 var helpText string
@@ -37,6 +378,27 @@ func init() {
   %s
   %s
   %s
+  %s
+  %s
+  %s
+  %s
+  %s
+  %s
+  %s
+  %s
+  %s
+  %s
+  %s
+  %s
+  %s
+  %s
+  %s
+  %s
+  %s
+  %s
+  %s
+  %s
+  %s
   help
       Show this help message.
   version
@@ -47,10 +409,43 @@ Environment variables:
   PIN_TOOL_SEARCH_DIR Directory to search for FuncTracer.so (default: /usr/lib64/coverage-tools)
   LOG_DIR             Directory for coverage logs (default: /var/coverage/data)
   SAFE_BIN_DIR        Directory to store original binaries (default: /var/coverage/bin)
+  FUNKOVERAGE_CONFIG  Path to the JSON configuration file (default: /etc/funkoverage/config.json)
+  DEBUGINFOD_URLS     Space-separated debuginfod servers used to fetch detached debuginfo
+  FUNKOVERAGE_EDGES   Set when running a wrapped binary to record call-graph edges (for --formats dot)
+  FUNKOVERAGE_TIMELINE  Set when running a wrapped binary to record first-call times (for --formats perfetto)
+  FUNKOVERAGE_JSON    Set when running a wrapped binary to write its log as JSON lines, one object per event
+  FUNKOVERAGE_PROTO   Set when running a wrapped binary to write its log as protobuf (see proto/funkoverage_log.proto)
+  FUNKOVERAGE_SOCKET  Socket of the stream-collector a wrapped binary streams its log to (default: $LOG_DIR/.funkoverage.sock; empty writes log files)
+  FUNKOVERAGE_SAMPLE  Overrides the wrap --sample rate of a wrapped binary (N or P%%; empty traces every run)
+  COVERAGE_SESSION    Session a wrapped binary stamps into its logs, e.g. smoke or regression (see report --session)
+  COVERAGE_TAGS       Comma-separated tags a wrapped binary stamps into its logs (see report --tag)
+  FUNKOVERAGE_LOG_ENV Comma-separated environment variables a wrapped binary records in its log (besides USER, LANG, CI)
+  SOURCE_DATE_EPOCH   Fixed report generation time (Unix seconds) for reproducible output
 `,
 		indent(strings.TrimPrefix(wrapHelpText, "Usage: funkoverage "), "  "),
 		indent(strings.TrimPrefix(unwrapHelpText, "Usage: funkoverage "), "  "),
-		indent(strings.TrimPrefix(reportHelpText, "Usage: funkoverage "), "  "))
+		indent(strings.TrimPrefix(reportHelpText, "Usage: funkoverage "), "  "),
+		indent(strings.TrimPrefix(serveHelpText, "Usage: funkoverage "), "  "),
+		indent(strings.TrimPrefix(collectHelpText, "Usage: funkoverage "), "  "),
+		indent(strings.TrimPrefix(collectorHelpText, "Usage: funkoverage "), "  "),
+		indent(strings.TrimPrefix(streamCollectorHelpText, "Usage: funkoverage "), "  "),
+		indent(strings.TrimPrefix(explainHelpText, "Usage: funkoverage "), "  "),
+		indent(strings.TrimPrefix(statsHelpText, "Usage: funkoverage "), "  "),
+		indent(strings.TrimPrefix(importHelpText, "Usage: funkoverage "), "  "),
+		indent(strings.TrimPrefix(containerHelpText, "Usage: funkoverage "), "  "),
+		indent(strings.TrimPrefix(agentHelpText, "Usage: funkoverage "), "  "),
+		indent(strings.TrimPrefix(generateHooksHelpText, "Usage: funkoverage "), "  "),
+		indent(strings.TrimPrefix(refreshHelpText, "Usage: funkoverage "), "  "),
+		indent(strings.TrimPrefix(recoverHelpText, "Usage: funkoverage "), "  "),
+		indent(strings.TrimPrefix(upgradeWrappersHelpText, "Usage: funkoverage "), "  "),
+		indent(strings.TrimPrefix(startWindowHelpText, "Usage: funkoverage "), "  "),
+		indent(strings.TrimPrefix(stopWindowHelpText, "Usage: funkoverage "), "  "),
+		indent(strings.TrimPrefix(ctlHelpText, "Usage: funkoverage "), "  "),
+		indent(strings.TrimPrefix(reportdHelpText, "Usage: funkoverage "), "  "),
+		indent(strings.TrimPrefix(statusHelpText, "Usage: funkoverage "), "  "),
+		indent(strings.TrimPrefix(stapHelpText, "Usage: funkoverage "), "  "),
+		indent(strings.TrimPrefix(fuzzBridgeHelpText, "Usage: funkoverage "), "  "),
+		indent(strings.TrimPrefix(eventsHelpText, "Usage: funkoverage "), "  "))
 }
 
 // indent adds indentation to each line of a string.
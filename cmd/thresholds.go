@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// --- Coverage Thresholds ---
+
+// CoverageThresholds splits the images of the aggregate report in three
+// bands: red below Red percent, yellow below Green, and green from there on.
+type CoverageThresholds struct {
+	Red   float64
+	Green float64
+}
+
+var defaultThresholds = CoverageThresholds{Red: 50, Green: 80}
+
+func (t CoverageThresholds) String() string {
+	return strconv.FormatFloat(t.Red, 'g', -1, 64) + "," + strconv.FormatFloat(t.Green, 'g', -1, 64)
+}
+
+// parseThresholds parses a --thresholds value such as "50,80".
+func parseThresholds(s string) (CoverageThresholds, error) {
+	red, green, ok := strings.Cut(s, ",")
+	if !ok {
+		return CoverageThresholds{}, fmt.Errorf("expected <red>,<green> percentages, got %q", s)
+	}
+	var t CoverageThresholds
+	var err error
+	if t.Red, err = strconv.ParseFloat(strings.TrimSpace(red), 64); err != nil {
+		return CoverageThresholds{}, fmt.Errorf("invalid percentage %q", red)
+	}
+	if t.Green, err = strconv.ParseFloat(strings.TrimSpace(green), 64); err != nil {
+		return CoverageThresholds{}, fmt.Errorf("invalid percentage %q", green)
+	}
+	if t.Red < 0 || t.Green > 100 || t.Red > t.Green {
+		return CoverageThresholds{}, fmt.Errorf("expected 0 <= red <= green <= 100, got %q", s)
+	}
+	return t, nil
+}
+
+// level returns the band of a coverage percentage: "low", "medium" or "high".
+func (t CoverageThresholds) level(pct float64) string {
+	switch {
+	case pct < t.Red:
+		return "low"
+	case pct < t.Green:
+		return "medium"
+	}
+	return "high"
+}
+
+// CoverageFilter lists only the images below Below percent and above Above
+// percent of coverage; a zero bound is not set.
+type CoverageFilter struct {
+	Below float64
+	Above float64
+}
+
+func newCoverageFilter(below, above float64) (CoverageFilter, error) {
+	f := CoverageFilter{Below: below, Above: above}
+	if below < 0 || below > 100 || above < 0 || above > 100 {
+		return CoverageFilter{}, fmt.Errorf("expected percentages between 0 and 100")
+	}
+	if below > 0 && above > 0 && above >= below {
+		return CoverageFilter{}, fmt.Errorf("--above %g leaves nothing below %g", above, below)
+	}
+	return f, nil
+}
+
+func (f CoverageFilter) active() bool { return f.Below > 0 || f.Above > 0 }
+
+// lists reports whether an image with the coverage pct is listed.
+func (f CoverageFilter) lists(pct float64) bool {
+	return (f.Below <= 0 || pct < f.Below) && (f.Above <= 0 || pct > f.Above)
+}
+
+// String describes the listed images: "below 80%", "above 90%" or both.
+func (f CoverageFilter) String() string {
+	var parts []string
+	if f.Above > 0 {
+		parts = append(parts, "above "+strconv.FormatFloat(f.Above, 'g', -1, 64)+"%")
+	}
+	if f.Below > 0 {
+		parts = append(parts, "below "+strconv.FormatFloat(f.Below, 'g', -1, 64)+"%")
+	}
+	return strings.Join(parts, " and ")
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Perfetto / Chrome Trace Export ---
+
+const traceReportFileName = "trace.json"
+
+// firstMarker starts the function of a first-call line (format 8, written
+// with FUNKOVERAGE_TIMELINE=1): "[Image:x] [Pid:N] [Tid:N] [Time:ns] [First:f]".
+var firstMarker = []byte("] [First:")
+
+// FirstCall is when and where a function was first called.
+type FirstCall struct {
+	Image    string
+	Function string
+	Pid      int
+	Tid      int
+	Time     time.Time
+}
+
+// parseFirstCallLine returns the raw image and function of a first-call line
+// with the process, thread and time of the call.
+func parseFirstCallLine(line []byte) (image, function []byte, pid, tid int, at time.Time, ok bool) {
+	i := bytes.Index(line, imageMarker)
+	if i < 0 {
+		return nil, nil, 0, 0, time.Time{}, false
+	}
+	rest := line[i+len(imageMarker):]
+	j := bytes.Index(rest, firstMarker)
+	if j < 0 {
+		return nil, nil, 0, 0, time.Time{}, false
+	}
+	fields, name := rest[:j], rest[j+len(firstMarker):]
+	k := bytes.LastIndexByte(name, ']')
+	a := bytes.Index(fields, []byte("] ["))
+	if k < 0 || a < 0 {
+		return nil, nil, 0, 0, time.Time{}, false
+	}
+	image = fields[:a]
+	var ns int64
+	for _, field := range bytes.Split(fields[a+3:], []byte("] [")) {
+		key, value, _ := bytes.Cut(field, []byte(":"))
+		switch string(key) {
+		case "Pid":
+			pid, _ = strconv.Atoi(string(value))
+		case "Tid":
+			tid, _ = strconv.Atoi(string(value))
+		case "Time":
+			ns, _ = strconv.ParseInt(string(value), 10, 64)
+		}
+	}
+	if ns <= 0 {
+		return nil, nil, 0, 0, time.Time{}, false
+	}
+	return unescapeField(bytes.TrimSpace(image)), unescapeField(bytes.TrimSpace(name[:k])), pid, tid, time.Unix(0, ns), true
+}
+
+// runPid returns the pid encoded in a run ID as "<pid>-<start ns>" in hexadecimal.
+func runPid(runID string) (int, bool) {
+	pid, _, ok := strings.Cut(runID, "-")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(pid, 16, 32)
+	return int(n), err == nil
+}
+
+// traceProcess is a process of a traced run: the wrapped binary, or a
+// program it executed, which starts a header of its own in the same log.
+type traceProcess struct {
+	Pid   int
+	Name  string
+	Start time.Time
+	// Wall is the run time of the whole invocation, known for the first
+	// process of a log with a trailer.
+	Wall time.Duration
+}
+
+// LogTimeline holds the processes and first calls of one log.
+type LogTimeline struct {
+	Processes []traceProcess
+	Calls     []FirstCall
+	// counts maps image and function to the number of calls of the
+	// Called line preceding each first-call line.
+	counts map[[2]string]uint64
+}
+
+// readTimeline reads the processes and first calls of a log.
+func readTimeline(logFile string, symbols *symbolTable) (*LogTimeline, error) {
+	f, err := openLog(logFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not open log file %s: %w", logFile, err)
+	}
+	defer f.Close()
+	t := &LogTimeline{counts: make(map[[2]string]uint64)}
+	r := bufio.NewReader(f)
+	var ev logEvent
+	if isProtoLog(r) {
+		s := &protoScanner{r: r}
+		for s.scan() {
+			if decodeProtoEvent(s.record, &ev) {
+				t.addEvent(&ev, logFile, symbols)
+			}
+		}
+		if s.err != nil {
+			return nil, fmt.Errorf("could not read log file %s: %w", logFile, s.err)
+		}
+		return t, nil
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxLogLineSize)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) > 0 && line[0] == '{' {
+			if parseJSONEvent(line, &ev) {
+				t.addEvent(&ev, logFile, symbols)
+			}
+			continue
+		}
+		if image, function, pid, tid, at, ok := parseFirstCallLine(line); ok {
+			t.Calls = append(t.Calls, FirstCall{Image: symbols.image(image), Function: symbols.displayName(symbols.function(function)), Pid: pid, Tid: tid, Time: at})
+			continue
+		}
+		if kind, image, function, _, count := parseLogLine(line); kind == lineCalled {
+			t.counts[[2]string{symbols.image(image), symbols.displayName(symbols.function(function))}] = count
+			continue
+		}
+		if _, ok := parseLogHeader(line); ok {
+			runID, _ := parseLogRunID(line)
+			p := traceProcess{Name: logBinary(logFile)}
+			p.Pid, _ = runPid(runID)
+			p.Start, _ = runStart(runID)
+			t.Processes = append(t.Processes, p)
+			continue
+		}
+		if ctx, ok := parseLogContext(line); ok && len(t.Processes) > 0 && len(ctx.Argv) > 0 {
+			t.Processes[len(t.Processes)-1].Name = filepath.Base(ctx.Argv[0])
+			continue
+		}
+		if exit, ok := parseLogTrailer(line); ok && len(t.Processes) > 0 {
+			t.Processes[0].Wall = exit.Wall
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read log file %s: %w", logFile, err)
+	}
+	return t, nil
+}
+
+// addEvent reads an event of a JSON-lines or protobuf log like readTimeline
+// reads the lines of a text log.
+func (t *LogTimeline) addEvent(ev *logEvent, logFile string, symbols *symbolTable) {
+	switch string(ev.Event) {
+	case "first_call":
+		t.Calls = append(t.Calls, FirstCall{Image: symbols.image(ev.Image), Function: symbols.displayName(symbols.function(ev.Function)), Pid: int(ev.Pid), Tid: int(ev.Tid), Time: time.Unix(0, int64(ev.TimeNs))})
+	case "called":
+		t.counts[[2]string{symbols.image(ev.Image), symbols.displayName(symbols.function(ev.Function))}] = ev.Count
+	case "header":
+		p := traceProcess{Name: logBinary(logFile)}
+		p.Pid, _ = runPid(string(ev.Run))
+		p.Start, _ = runStart(string(ev.Run))
+		t.Processes = append(t.Processes, p)
+	case "context":
+		if len(t.Processes) > 0 && len(ev.Args) > 0 {
+			t.Processes[len(t.Processes)-1].Name = filepath.Base(ev.Args[0])
+		}
+	case "exit":
+		if len(t.Processes) > 0 {
+			t.Processes[0].Wall = time.Duration(ev.WallMs) * time.Millisecond
+		}
+	}
+}
+
+// traceEvent is an event of the Chrome trace event format, which Perfetto
+// (ui.perfetto.dev) and chrome://tracing load.
+type traceEvent struct {
+	Name  string         `json:"name"`
+	Cat   string         `json:"cat,omitempty"`
+	Phase string         `json:"ph"`
+	Ts    float64        `json:"ts"`
+	Dur   float64        `json:"dur,omitempty"`
+	Pid   int            `json:"pid"`
+	Tid   int            `json:"tid"`
+	Scope string         `json:"s,omitempty"`
+	Args  map[string]any `json:"args,omitempty"`
+}
+
+// chromeTrace turns timelines into trace events: a track per process and
+// thread, a span per traced invocation and an instant marker per first call.
+// Times are in microseconds from the earliest event.
+func chromeTrace(timelines []*LogTimeline) []traceEvent {
+	var origin time.Time
+	earliest := func(t time.Time) {
+		if !t.IsZero() && (origin.IsZero() || t.Before(origin)) {
+			origin = t
+		}
+	}
+	for _, t := range timelines {
+		for _, p := range t.Processes {
+			earliest(p.Start)
+		}
+		for _, c := range t.Calls {
+			earliest(c.Time)
+		}
+	}
+	ts := func(t time.Time) float64 {
+		return float64(t.Sub(origin).Nanoseconds()) / 1000
+	}
+	var meta, events []traceEvent
+	threads := make(map[[2]int]bool)
+	thread := func(pid, tid int) {
+		if threads[[2]int{pid, tid}] {
+			return
+		}
+		threads[[2]int{pid, tid}] = true
+		name := "thread " + strconv.Itoa(tid)
+		if tid == pid {
+			name = "main"
+		}
+		meta = append(meta, traceEvent{Name: "thread_name", Phase: "M", Pid: pid, Tid: tid, Args: map[string]any{"name": name}})
+	}
+	seen := make(map[FirstCall]bool)
+	for _, t := range timelines {
+		for _, p := range t.Processes {
+			meta = append(meta, traceEvent{Name: "process_name", Phase: "M", Pid: p.Pid, Tid: p.Pid, Args: map[string]any{"name": p.Name}})
+			thread(p.Pid, p.Pid)
+			if p.Wall > 0 && !p.Start.IsZero() {
+				events = append(events, traceEvent{Name: p.Name, Cat: "run", Phase: "X", Ts: ts(p.Start), Dur: float64(p.Wall.Microseconds()), Pid: p.Pid, Tid: p.Pid})
+			}
+		}
+		for _, c := range t.Calls {
+			// A log copied by rsync or a forked child repeats calls.
+			if seen[c] {
+				continue
+			}
+			seen[c] = true
+			thread(c.Pid, c.Tid)
+			args := map[string]any{"image": c.Image}
+			if n := t.counts[[2]string{c.Image, c.Function}]; n > 0 {
+				args["calls"] = n
+			}
+			events = append(events, traceEvent{Name: c.Function, Cat: filepath.Base(c.Image), Phase: "i", Scope: "t", Ts: ts(c.Time), Pid: c.Pid, Tid: c.Tid, Args: args})
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Ts < events[j].Ts })
+	return append(meta, events...)
+}
+
+// generateTraceReport writes the first calls of the logs as a Chrome trace
+// JSON file. It reports false when none of the logs has first-call times.
+func generateTraceReport(logFiles []string, opts AnalyzeOptions, outputDir string) (bool, error) {
+	symbols := newSymbolTable(opts)
+	var timelines []*LogTimeline
+	calls := 0
+	for _, logFile := range logFiles {
+		t, err := readTimeline(logFile, symbols)
+		if err != nil {
+			return false, err
+		}
+		timelines = append(timelines, t)
+		calls += len(t.Calls)
+	}
+	if calls == 0 {
+		return false, nil
+	}
+	data, err := json.Marshal(struct {
+		TraceEvents     []traceEvent `json:"traceEvents"`
+		DisplayTimeUnit string       `json:"displayTimeUnit"`
+	}{chromeTrace(timelines), "ms"})
+	if err != nil {
+		return false, err
+	}
+	return true, os.WriteFile(filepath.Join(outputDir, traceReportFileName), data, 0644)
+}
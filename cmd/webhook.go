@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// --- Post-report Webhooks ---
+
+// signatureHeader carries the HMAC-SHA256 of the request body, as "sha256=<hex>".
+const signatureHeader = "X-Funkoverage-Signature"
+
+// webhookBackoff is the delay before the first retry, doubled at each attempt.
+var webhookBackoff = 2 * time.Second
+
+type WebhookConfig struct {
+	URL string `json:"url"`
+	// Secret enables HMAC signing of the payload when set.
+	Secret string `json:"secret"`
+	// Retries is the number of additional attempts after a failure (default: 3).
+	Retries *int `json:"retries"`
+}
+
+// ReportWebhookPayload is the JSON body posted after a report run.
+type ReportWebhookPayload struct {
+	Event       string         `json:"event"`
+	GeneratedAt string         `json:"generated_at"`
+	OutputDir   string         `json:"output_dir"`
+	Formats     []string       `json:"formats"`
+	Artifacts   []string       `json:"artifacts"`
+	Summary     CoverageTotals `json:"summary"`
+}
+
+// signPayload returns the value of the signature header for body.
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliverWebhook posts body to the hook, retrying with exponential backoff on
+// network errors and 5xx answers. Client errors (4xx) are not retried.
+func deliverWebhook(hook WebhookConfig, body []byte) error {
+	retries := 3
+	if hook.Retries != nil {
+		retries = *hook.Retries
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	delay := webhookBackoff
+	var lastErr error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+		req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "funkoverage/"+versionString)
+		if hook.Secret != "" {
+			req.Header.Set(signatureHeader, signPayload(hook.Secret, body))
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp.Body.Close()
+		switch {
+		case resp.StatusCode < 300:
+			return nil
+		case resp.StatusCode < 500:
+			return fmt.Errorf("%s returned %s", hook.URL, resp.Status)
+		}
+		lastErr = fmt.Errorf("%s returned %s", hook.URL, resp.Status)
+	}
+	return lastErr
+}
+
+// fireReportWebhooks delivers the report payload to every configured hook.
+func fireReportWebhooks(hooks []WebhookConfig, payload ReportWebhookPayload) error {
+	if len(hooks) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, hook := range hooks {
+		if err := deliverWebhook(hook, body); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// --- Coverage Windows ---
+
+// Coverage windows restrict tracing to a phase of a test run, not its setup
+// and teardown. While the window file of the log directory exists, wrappers
+// trace only the runs within an open window, its label in the file, and tag
+// their logs with the label; a closed window leaves the file empty. Without
+// the file, every run is traced.
+const windowFileName = ".funkoverage.window"
+
+// windowLabelRe matches a window label, which logs carry as a tag.
+var windowLabelRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:-]*$`)
+
+// readWindow returns the label of the window open in logDir, and whether
+// windows are in use there at all.
+func readWindow(logDir string) (label string, windowed bool) {
+	content, err := os.ReadFile(filepath.Join(logDir, windowFileName))
+	if err != nil {
+		return "", false
+	}
+	label, _, _ = strings.Cut(string(content), "\n")
+	return strings.TrimSpace(label), true
+}
+
+// writeWindow replaces the window file of logDir in one step, for wrappers
+// never to read it half written.
+func writeWindow(logDir, label string) error {
+	if err := os.MkdirAll(logDir, 0777); err != nil {
+		return err
+	}
+	staged := filepath.Join(logDir, windowFileName+".tmp")
+	content := ""
+	if label != "" {
+		content = label + "\n"
+	}
+	if err := os.WriteFile(staged, []byte(content), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(staged, filepath.Join(logDir, windowFileName)); err != nil {
+		os.Remove(staged)
+		return err
+	}
+	return nil
+}
+
+// startWindow opens the window label in logDir, closing the open one.
+func startWindow(logDir, label string) error {
+	if !windowLabelRe.MatchString(label) {
+		return fmt.Errorf("invalid window label %q: expected letters, digits, '.', '_', ':' and '-'", label)
+	}
+	if previous, _ := readWindow(logDir); previous != "" && previous != label {
+		fmt.Printf("Closed coverage window %s\n", previous)
+	}
+	if err := writeWindow(logDir, label); err != nil {
+		return err
+	}
+	fmt.Printf("Started coverage window %s: only the runs from now on until stop-window are traced\n", label)
+	return nil
+}
+
+// stopWindow closes the window open in logDir, after which no run is traced
+// until the next window. With reset, windows are no longer used and every run
+// is traced again.
+func stopWindow(logDir string, reset bool) error {
+	label, windowed := readWindow(logDir)
+	if reset {
+		if err := os.Remove(filepath.Join(logDir, windowFileName)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		fmt.Println("Coverage windows reset: every run is traced")
+		return nil
+	}
+	if !windowed || label == "" {
+		return errors.New("no coverage window is open")
+	}
+	if err := writeWindow(logDir, ""); err != nil {
+		return err
+	}
+	fmt.Printf("Stopped coverage window %s: no run is traced until the next start-window (stop-window --reset traces every run)\n", label)
+	return nil
+}
+
+// windowScript is the part of the wrapper script running the original
+// untraced outside an open window, and setting window_label within one.
+const windowScript = `# Coverage windows (funkoverage start-window): while the window file exists,
+# only the runs within an open window, whose label it holds, are traced.
+window_label=""
+if [ -f "$LOG_DIR/` + windowFileName + `" ]; then
+    read -r window_label < "$LOG_DIR/` + windowFileName + `"
+    if [ -z "$window_label" ]; then
+        exec "$ORIGINAL_BINARY" "$@"
+    fi
+fi
+`
+
+// windowLogDir returns the log directory of --log-dir, else of LOG_DIR.
+func windowLogDir(dir string) string {
+	if dir == "" {
+		if dir = os.Getenv("LOG_DIR"); dir == "" {
+			dir = defaultLogDir
+		}
+	}
+	return dir
+}
@@ -11,17 +11,31 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"runtime"
 	"strings"
 	"time"
 )
 
-const (
-	wrapperIDComment        = "# Pin Wrapper generated by Go tool funkoverage"
+const wrapperIDComment = "# Pin Wrapper generated by Go tool funkoverage"
+
+var (
 	defaultPinToolSearchDir = "/usr/lib64/coverage-tools"
 	defaultLogDir           = "/var/coverage/data"
 	defaultSafeBinDir       = "/var/coverage/bin"
 )
 
+// On Windows the defaults live under %ProgramData%\funkoverage.
+func init() {
+	if runtime.GOOS != "windows" {
+		return
+	}
+	base := filepath.Join(os.Getenv("ProgramData"), "funkoverage")
+	defaultPinToolSearchDir = filepath.Join(base, "tools")
+	defaultLogDir = filepath.Join(base, "data")
+	defaultSafeBinDir = filepath.Join(base, "bin")
+}
+
 var globalDebugRoot = "/usr/lib/debug"
 
 // --- Wrapper Management ---
@@ -41,7 +55,7 @@ func isELF(path string) bool {
 }
 
 // checks if a binary has embedded debug symbols OR
-// if it links to a valid, existing external debug file via Build ID.
+// if a detached debug file can be found for it (see findDebugFile).
 func hasDebugInfo(path string) (bool, error) {
 	f, err := elf.Open(path)
 	if err != nil {
@@ -60,29 +74,22 @@ func hasDebugInfo(path string) (bool, error) {
 		}
 	}
 
-	// 2. Check for External Symbols via Build ID
-	// If we are here, it is likely "stripped". We need to find the Build ID note.
-	buildID, err := getBuildID(f)
+	// 2. Check for External Symbols: build-id tree, .gnu_debuglink or debuginfod
+	debugPath, err := findDebugFile(path)
 	if err != nil {
-		return false, nil // No Build ID found, so definitely no debug info
+		fmt.Printf("Warning: %v\n", err)
 	}
-
-	// 3. Verify the external file actually exists
-	// Standard path: /usr/lib/debug/.build-id/xx/xxxx.debug
-	if len(buildID) > 2 {
-		debugPath := fmt.Sprintf("%s/.build-id/%s/%s.debug", globalDebugRoot, buildID[:2], buildID[2:])
-		if _, err := os.Stat(debugPath); err == nil {
-			return true, nil // Found linked symbols
-		}
-	}
-
-	return false, nil
+	return debugPath != "", nil
 }
 
-// PIN does not follow .gnu_debuglink. If the binary is stripped but a build-id
-// debug file exists, merge the symbols back into the binary using eu-unstrip
-// so PIN's RTN_* API can discover real function names.
-func mergeDebugIfExternal(binPath string) error {
+// PIN does not follow .gnu_debuglink. If the binary is stripped but a detached
+// debug file was found (debugPath, located before the binary was moved), merge
+// the symbols back into the binary using eu-unstrip so PIN's RTN_* API can
+// discover real function names.
+func mergeDebugIfExternal(binPath, debugPath string) error {
+	if debugPath == "" {
+		return nil
+	}
 	f, err := elf.Open(binPath)
 	if err != nil {
 		return fmt.Errorf("open elf: %w", err)
@@ -93,15 +100,7 @@ func mergeDebugIfExternal(binPath string) error {
 			return nil
 		}
 	}
-	buildID, err := getBuildID(f)
 	f.Close()
-	if err != nil || len(buildID) <= 2 {
-		return nil
-	}
-	debugPath := fmt.Sprintf("%s/.build-id/%s/%s.debug", globalDebugRoot, buildID[:2], buildID[2:])
-	if _, err := os.Stat(debugPath); err != nil {
-		return nil
-	}
 	tmp, err := os.CreateTemp(filepath.Dir(binPath), ".unstrip-*")
 	if err != nil {
 		return fmt.Errorf("create temp: %w", err)
@@ -199,7 +198,56 @@ func moveCrossDevice(source, destination string) error {
 	return nil
 }
 
-func wrap(targetBinary string) error {
+// WrapOptions are the settings baked into a wrapper script.
+type WrapOptions struct {
+	// Sample traces only some invocations; FUNKOVERAGE_SAMPLE overrides it at run time.
+	Sample Sampling
+	// Disable lists the rules under which the original binary runs untraced.
+	Disable []DisableRule
+	// API lists the shared libraries whose exported functions alone are
+	// traced, counting the calls the other images make to them (FuncTracer
+	// -api); FUNKOVERAGE_API overrides it at run time.
+	API []string
+	// LogName is the template of the log file names (see parseLogName), ""
+	// for defaultLogName.
+	LogName string
+	// Rewrap regenerates the wrapper of a binary already wrapped with the
+	// current template and these options, keeping its backup. Without it,
+	// wrapped binaries are skipped.
+	Rewrap bool
+}
+
+// apiLibraryRe matches a library of --api: a file name like libssl.so.3, or
+// a prefix of one like libssl.
+var apiLibraryRe = regexp.MustCompile(`^[A-Za-z0-9_+][A-Za-z0-9_.+-]*$`)
+
+// parseAPILibraries parses a comma-separated --api value.
+func parseAPILibraries(s string) ([]string, error) {
+	libs := splitList(s)
+	for _, lib := range libs {
+		if !apiLibraryRe.MatchString(lib) {
+			return nil, fmt.Errorf("invalid library %q: expected a file name like libssl.so.3 or libssl", lib)
+		}
+	}
+	return libs, nil
+}
+
+// manifestEntry records a binary wrapped with these options.
+func (o WrapOptions) manifestEntry(path, backup string) ManifestEntry {
+	e := ManifestEntry{Path: path, Backup: backup, WrappedAt: time.Now(), Sample: o.Sample.String(), API: o.API, LogName: o.LogName, Package: packageResolver(path)}
+	e.PackageName, e.PackageVersion = packageVersionResolver(path)
+	if f, err := elf.Open(backup); err == nil {
+		e.BuildID, _ = getBuildID(f)
+		f.Close()
+	}
+	e.SHA256, _ = fileSHA256(backup)
+	for _, r := range o.Disable {
+		e.Disable = append(e.Disable, r.String())
+	}
+	return e
+}
+
+func wrap(targetBinary string, opts WrapOptions) error {
 	PIN_ROOT := os.Getenv("PIN_ROOT")
 	if PIN_ROOT == "" {
 		return errors.New("PIN_ROOT environment variable is not set")
@@ -216,10 +264,6 @@ func wrap(targetBinary string) error {
 	if SAFE_BIN_DIR == "" {
 		SAFE_BIN_DIR = defaultSafeBinDir
 	}
-	pinTool, err := findPinTool(PIN_TOOL_SEARCH_DIR)
-	if err != nil {
-		return err
-	}
 
 	// Check if the target is a symlink to preserve the calling name for multicall binaries
 	fileInfo, err := os.Lstat(targetBinary)
@@ -234,12 +278,19 @@ func wrap(targetBinary string) error {
 		return fmt.Errorf("could not resolve symlink: %w", err)
 	}
 	targetBinary = realTarget
-	content, err := os.ReadFile(targetBinary)
-	if err != nil {
-		return fmt.Errorf("could not read target: %w", err)
+	if isWrapper(targetBinary) {
+		if !opts.Rewrap {
+			fmt.Printf("Skipped %s: already wrapped (--rewrap regenerates its wrapper)\n", targetBinary)
+			return nil
+		}
+		return rewrap(targetBinary, PIN_ROOT, PIN_TOOL_SEARCH_DIR, LOG_DIR, opts)
 	}
-	if strings.Contains(string(content), wrapperIDComment) {
-		return fmt.Errorf("'%s' is already a wrapper. Use unwrap first", targetBinary)
+	if isPE(targetBinary) {
+		return wrapPE(targetBinary, PIN_ROOT, PIN_TOOL_SEARCH_DIR, LOG_DIR, SAFE_BIN_DIR, opts)
+	}
+	pinTool, err := findPinTool(PIN_TOOL_SEARCH_DIR)
+	if err != nil {
+		return err
 	}
 	// --- ELF check here ---
 	if !isELF(targetBinary) {
@@ -251,9 +302,22 @@ func wrap(targetBinary string) error {
 		return fmt.Errorf("could not determine debug information for '%s': %w", targetBinary, err)
 	}
 	if !found {
-		return fmt.Errorf("'%s' does not contain debug information. Aborting", targetBinary)
+		f, err := elf.Open(targetBinary)
+		if err != nil {
+			return fmt.Errorf("failed to open elf: %w", err)
+		}
+		stripped := isStripped(f)
+		f.Close()
+		if !stripped {
+			return fmt.Errorf("'%s' does not contain debug information. Aborting", targetBinary)
+		}
+		// Fully stripped binaries can still be traced through their dynamic symbols.
+		fmt.Printf("Warning: '%s' is stripped: only its exported functions will be traced and reports will show partial symbol info. Install its debuginfo for full coverage.\n", targetBinary)
 	}
 
+	// .gnu_debuglink is resolved relative to the binary, so look before moving it.
+	debugPath, _ := findDebugFile(targetBinary)
+
 	if err := os.MkdirAll(SAFE_BIN_DIR, 0755); err != nil {
 		return err
 	}
@@ -271,7 +335,7 @@ func wrap(targetBinary string) error {
 	if err := move(targetBinary, movedBinaryPath); err != nil {
 		return err
 	}
-	if err := mergeDebugIfExternal(movedBinaryPath); err != nil {
+	if err := mergeDebugIfExternal(movedBinaryPath, debugPath); err != nil {
 		return fmt.Errorf("could not merge external debug symbols: %w", err)
 	}
 
@@ -285,7 +349,24 @@ func wrap(targetBinary string) error {
 		}
 	}
 
-	wrapperScript := fmt.Sprintf(`#!/bin/bash
+	if err := os.WriteFile(targetBinary, []byte(wrapperScript(opts, PIN_ROOT, pinTool, LOG_DIR, movedBinaryPath, binaryToRun)), 0755); err != nil {
+		return err
+	}
+	err = updateManifest(SAFE_BIN_DIR, func(m *Manifest) {
+		m.put(opts.manifestEntry(targetBinary, movedBinaryPath))
+	})
+	if err != nil {
+		fmt.Printf("Warning: failed to update manifest in %s: %v\n", SAFE_BIN_DIR, err)
+	}
+	fmt.Printf("Wrapped %s (original moved to %s)\n", targetBinary, movedBinaryPath)
+	return nil
+}
+
+// wrapperScript returns the wrapper running the backup of a binary, original,
+// under Pin. binaryToRun is the backup, or the symlink to it carrying the name
+// of a multicall binary.
+func wrapperScript(opts WrapOptions, pinRoot, pinTool, logDir, original, binaryToRun string) string {
+	return fmt.Sprintf(`#!/bin/bash
 %s on %s
 # Original Binary: %s
 
@@ -300,22 +381,125 @@ ORIGINAL_BINARY="%s"
 if [ -n "$BINARYCOVERAGE_PIN_ACTIVE" ]; then
     exec "$ORIGINAL_BINARY" "$@"
 fi
-export BINARYCOVERAGE_PIN_ACTIVE=1
-
-mkdir -m 0777 -p "$LOG_DIR"
+%smkdir -m 0777 -p "$LOG_DIR"
 
 binary_name=$(basename "$0")
+
+%s
+%s
+# Invocation sampling: trace every Nth run ("N") or a random share ("P%%"),
+# running the original directly otherwise.
+sample="${FUNKOVERAGE_SAMPLE-%s}"
+sample_rate=""
+case "$sample" in
+    ""|1|100%%) ;;
+    *[!0-9]*%%|*%%*%%) ;;
+    *%%)
+        sample_rate="$sample"
+        if [ $(( (RANDOM * 32768 + RANDOM) %% 100 )) -ge "${sample%%\%%}" ]; then
+            exec "$ORIGINAL_BINARY" "$@"
+        fi
+        ;;
+    *[!0-9]*) ;;
+    *)
+        sample_rate="1/$sample"
+        # Runs are counted per user, in a directory only they can write to:
+        # not in the world-writable LOG_DIR, where root would follow the
+        # symlinks other users plant.
+        if [ "$(id -u)" -eq 0 ]; then
+            count_dir=/var/lib/funkoverage
+        else
+            count_dir="${XDG_STATE_HOME:-$HOME/.local/state}/funkoverage"
+        fi
+        count_file="$count_dir/${binary_name}.invocations"
+        count=""
+        if mkdir -m 0700 -p "$count_dir" 2>/dev/null && [ -O "$count_dir" ] && [ ! -L "$count_dir" ]; then
+            count=$( {
+                command -v flock >/dev/null && flock -x 9
+                n=$(cat "$count_file" 2>/dev/null)
+                n=$(( ${n:-0} + 1 ))
+                echo "$n" > "$count_file"
+                echo "$n"
+            } 9>>"$count_file.lock" )
+        fi
+        # Runs that cannot be counted are traced.
+        if [ $(( (${count:-1} - 1) %% sample )) -ne 0 ]; then
+            exec "$ORIGINAL_BINARY" "$@"
+        fi
+        ;;
+esac
+
+export BINARYCOVERAGE_PIN_ACTIVE=1
+
 timestamp=$(date "+%%Y%%m%%d-%%H%%M%%S")
 nano_seconds=$(date "+%%N")
-log_file="$LOG_DIR/${binary_name}_${timestamp}_${nano_seconds}.log"
+%s
+tool_args=()
+if [ -n "$FUNKOVERAGE_EDGES" ]; then
+    tool_args+=(-edges 1)
+fi
+if [ -n "$FUNKOVERAGE_TIMELINE" ]; then
+    tool_args+=(-timeline 1)
+fi
+if [ -n "$FUNKOVERAGE_JSON" ]; then
+    tool_args+=(-json 1)
+fi
+if [ -n "$FUNKOVERAGE_PROTO" ]; then
+    tool_args+=(-proto 1)
+fi
+# Run by a fuzzer of the AFL family: fill its coverage map.
+if [ -n "$__AFL_SHM_ID" ]; then
+    tool_args+=(-afl 1)
+fi
+# While funkoverage stream-collector listens, the log is streamed to it, the
+# log file only receiving what it could not take.
+stream_socket="${FUNKOVERAGE_SOCKET-$LOG_DIR/.funkoverage.sock}"
+if [ -n "$stream_socket" ] && [ -S "$stream_socket" ]; then
+    tool_args+=(-stream "$stream_socket")
+else
+    stream_socket=""
+fi
+if [ -n "$sample_rate" ]; then
+    tool_args+=(-sample "$sample_rate")
+fi
+if [ -n "$FUNKOVERAGE_LOG_ENV" ]; then
+    tool_args+=(-env "$FUNKOVERAGE_LOG_ENV")
+fi
+if [ -n "$COVERAGE_SESSION" ]; then
+    tool_args+=(-session "$COVERAGE_SESSION")
+fi
+# The logs of a coverage window are tagged with its label.
+tags="$COVERAGE_TAGS"
+if [ -n "$window_label" ]; then
+    tags="${tags:+$tags,}$window_label"
+fi
+if [ -n "$tags" ]; then
+    tool_args+=(-tags "$tags")
+fi
+# API mode: only the exported functions of these libraries are traced.
+api_libs="${FUNKOVERAGE_API-%s}"
+if [ -n "$api_libs" ]; then
+    tool_args+=(-api "$api_libs")
+fi
 
-exec "$PIN_ROOT/pin" -follow_execv -t "$PIN_TOOL" -logfile "$log_file" -- "$ORIGINAL_BINARY" "$@"
-`, wrapperIDComment, time.Now().Format(time.RFC3339), movedBinaryPath, PIN_ROOT, pinTool, LOG_DIR, binaryToRun)
-	if err := os.WriteFile(targetBinary, []byte(wrapperScript), 0755); err != nil {
-		return err
-	}
-	fmt.Printf("Wrapped %s (original moved to %s)\n", targetBinary, movedBinaryPath)
-	return nil
+# Instrumentation events go to journald/syslog, tagged "funkoverage" (see
+# funkoverage events), when logger is installed; FuncTracer logs the stop.
+log_event() {
+    command -v logger >/dev/null 2>&1 || return 0
+    logger -t funkoverage -p "user.$1" -- "event=$2 binary=$binary_name pid=$$ ${*:3}" || true
+}
+
+log_event info start "log=$log_file"
+started=$(date "+%%s%%N")
+# Pin replaces the wrapper, keeping its PID and the signals sent to it: the
+# tool of this process ends the log with the exit trailer and logs the stop.
+# execfail keeps the wrapper when pin cannot be run, to log the failure.
+shopt -s execfail
+exec "$PIN_ROOT/pin" -follow_execv -t "$PIN_TOOL" -o "$log_file" -exit_pid $$ -started "$started" "${tool_args[@]}" -- "$ORIGINAL_BINARY" "$@"
+status=$?
+log_event err failure "status=$status reason=pin-not-started"
+exit "$status"
+`, wrapperIDComment, time.Now().Format(time.RFC3339), original, pinRoot, pinTool, logDir, binaryToRun, disableRulesScript(opts.Disable), controlScript, windowScript, opts.Sample, logNameScript(opts.LogName), strings.Join(opts.API, ","))
 }
 
 func unwrap(targetBinary string) error {
@@ -325,6 +509,9 @@ func unwrap(targetBinary string) error {
 		return fmt.Errorf("could not resolve symlink: %w", err)
 	}
 	targetBinary = realTarget
+	if cfg, ok := readLauncherConfig(targetBinary); ok {
+		return unwrapPE(targetBinary, cfg)
+	}
 
 	content, err := os.ReadFile(targetBinary)
 	if err != nil {
@@ -333,13 +520,7 @@ func unwrap(targetBinary string) error {
 	if !strings.Contains(string(content), wrapperIDComment) {
 		return fmt.Errorf("'%s' is not a valid wrapper script. Nothing to unwrap", targetBinary)
 	}
-	var origPath string
-	for _, line := range strings.Split(string(content), "\n") {
-		if strings.HasPrefix(line, "# Original Binary:") {
-			origPath = strings.TrimSpace(strings.TrimPrefix(line, "# Original Binary:"))
-			break
-		}
-	}
+	origPath := wrapperOriginal(content)
 	if origPath == "" {
 		return errors.New("could not find original binary path in wrapper")
 	}
@@ -357,40 +538,57 @@ func unwrap(targetBinary string) error {
 		if err != nil {
 			return fmt.Errorf("failed to resolve symlink %s: %w", origPath, err)
 		}
+		sourcePath = realPath
+	}
+	// The backup lived in SAFE_BIN_DIR/<random>/, so the manifest is two levels up
+	manifestDir := filepath.Dir(filepath.Dir(sourcePath))
+	if err := verifyBackup(manifestDir, targetBinary, sourcePath); err != nil {
+		return err
+	}
+	if sourcePath != origPath {
 		// Remove the symlink so the directory can be empty after we move the binary
 		if err := os.Remove(origPath); err != nil {
 			fmt.Printf("Warning: failed to remove symlink %s: %v\n", origPath, err)
 		}
-		sourcePath = realPath
 	}
 
 	if err := move(sourcePath, targetBinary); err != nil {
 		return fmt.Errorf("could not restore original binary: %w", err)
 	}
 	_ = os.Remove(filepath.Dir(sourcePath))
+	if _, err := os.Stat(filepath.Join(manifestDir, manifestFileName)); err == nil {
+		if err := updateManifest(manifestDir, func(m *Manifest) { m.remove(targetBinary) }); err != nil {
+			fmt.Printf("Warning: failed to update manifest in %s: %v\n", manifestDir, err)
+		}
+	}
 	fmt.Printf("Unwrapped %s (restored original from %s)\n", targetBinary, sourcePath)
 	return nil
 }
 
 func findPinTool(searchDir string) (string, error) {
+	return findPinToolNamed(searchDir, "FuncTracer.so")
+}
+
+// findPinToolNamed looks for the FuncTracer build name (.so or .dll) below searchDir.
+func findPinToolNamed(searchDir, name string) (string, error) {
 	var found string
 	_ = filepath.WalkDir(searchDir, func(path string, d os.DirEntry, err error) error {
-		if d != nil && d.Name() == "FuncTracer.so" {
+		if d != nil && d.Name() == name {
 			found = path
 			return io.EOF // stop walking
 		}
 		return nil
 	})
 	if found == "" {
-		return "", errors.New("FuncTracer.so not found. Look for it in the $PIN_TOOL_SEARCH_DIR env variable or " + defaultPinToolSearchDir + " directory")
+		return "", errors.New(name + " not found. Look for it in the $PIN_TOOL_SEARCH_DIR env variable or " + defaultPinToolSearchDir + " directory")
 	}
 	return found, nil
 }
 
-func wrapMany(binaries []string) error {
+func wrapMany(binaries []string, opts WrapOptions) error {
 	var failed []string
 	for _, bin := range binaries {
-		if err := wrap(bin); err != nil {
+		if err := wrap(bin, opts); err != nil {
 			fmt.Fprintf(os.Stderr, "wrap error for %s: %v\n", bin, err)
 			failed = append(failed, bin)
 		}
@@ -401,10 +599,15 @@ func wrapMany(binaries []string) error {
 	return nil
 }
 
-func unwrapMany(binaries []string) error {
+func unwrapMany(binaries []string, opts UnwrapOptions) error {
 	var failed []string
 	for _, bin := range binaries {
-		if err := unwrap(bin); err != nil {
+		err := unwrap(bin)
+		if err != nil && opts.Force {
+			fmt.Fprintf(os.Stderr, "unwrap: %s: %v; forcing\n", bin, err)
+			err = forceUnwrap(bin)
+		}
+		if err != nil {
 			fmt.Fprintf(os.Stderr, "unwrap error for %s: %v\n", bin, err)
 			failed = append(failed, bin)
 		}
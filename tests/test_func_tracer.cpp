@@ -1,6 +1,9 @@
 #define CATCH_CONFIG_MAIN
 #include "catch2/catch.hpp"
 #include "../FuncTracer.hpp"
+#include <fstream>
+#include <sys/wait.h>
+#include <link.h>
 
 TEST_CASE("func_is_relevant works as expected") {
     SECTION("PLT functions are not relevant") {
@@ -29,6 +32,25 @@ TEST_CASE("func_is_relevant works as expected") {
     }
 }
 
+TEST_CASE("ifunc_routine keeps IFUNC resolvers and variants") {
+    const std::set<std::string, std::less<>> dispatchers = {"memcpy", "strlen"};
+    SECTION("Resolvers are kept") {
+        REQUIRE(ifunc_routine("memcpy_ifunc", dispatchers));
+        REQUIRE(ifunc_routine("__memcpy_chk_ifunc", dispatchers));
+        REQUIRE_FALSE(ifunc_routine("_ifunc", dispatchers));
+    }
+    SECTION("Per-CPU variants of the dispatchers are kept") {
+        REQUIRE(ifunc_routine("__memcpy_avx_unaligned", dispatchers));
+        REQUIRE(ifunc_routine("__memcpy_sse2_unaligned_erms", dispatchers));
+        REQUIRE(ifunc_routine("__strlen_evex", dispatchers));
+    }
+    SECTION("Other internal functions are not") {
+        REQUIRE_FALSE(ifunc_routine("__memcpy_chk", dispatchers));
+        REQUIRE_FALSE(ifunc_routine("__strchr_avx2", dispatchers));
+        REQUIRE_FALSE(ifunc_routine("__libc_start_main", dispatchers));
+    }
+}
+
 TEST_CASE("image_is_relevant works as expected") {
     SECTION("Blacklisted images are not relevant") {
         REQUIRE_FALSE(image_is_relevant("[vdso]"));
@@ -38,4 +60,408 @@ TEST_CASE("image_is_relevant works as expected") {
         REQUIRE(image_is_relevant("libc.so.6"));
         REQUIRE(image_is_relevant("mybinary"));
     }
-}
\ No newline at end of file
+}
+TEST_CASE("split_list drops empty items") {
+    REQUIRE(split_list("libssl,,libcrypto.so,") == std::vector<std::string>{"libssl", "libcrypto.so"});
+    REQUIRE(split_list("").empty());
+}
+
+TEST_CASE("api_library_matches selects libraries by name or prefix") {
+    const std::vector<std::string> libs = {"libssl", "libz.so.1"};
+    REQUIRE(api_library_matches("/usr/lib64/libssl.so.3", libs));
+    REQUIRE(api_library_matches("libssl-1.1.so", libs));
+    REQUIRE(api_library_matches("/lib/libz.so.1", libs));
+    REQUIRE_FALSE(api_library_matches("/usr/lib64/libssl3.so", libs));
+    REQUIRE_FALSE(api_library_matches("/usr/lib/libssl/other.so", libs));
+    REQUIRE_FALSE(api_library_matches("/usr/bin/openssl", libs));
+    REQUIRE_FALSE(api_library_matches("/lib/libz.so.1.2", {"libz.so.1.3"}));
+}
+
+TEST_CASE("CallRegistry deduplicates calls per image") {
+    CallRegistry registry;
+    FuncRecord *foo = registry.add("/bin/prog", "foo");
+    FuncRecord *foo_again = registry.add("/bin/prog", "foo"); // same symbol in another section
+    registry.add("/bin/prog", "bar");
+    FuncRecord *lib = registry.add("libc.so.6", "puts");
+
+    SECTION("Only called functions are written, once, with their summed calls") {
+        foo->calls = 2;
+        foo_again->calls = 1;
+        REQUIRE(registry.flush_image("/bin/prog") == "[Image:/bin/prog] [Count:3] [Called:foo]\n");
+    }
+    SECTION("Flushing an image forgets it") {
+        foo->calls = 1;
+        registry.flush_image("/bin/prog");
+        REQUIRE(registry.flush_image("/bin/prog").empty());
+    }
+    SECTION("flush_all writes the remaining images") {
+        lib->calls = 1;
+        REQUIRE(registry.flush_all() == "[Image:libc.so.6] [Count:1] [Called:puts]\n");
+        REQUIRE(registry.flush_all().empty());
+    }
+}
+
+TEST_CASE("log_header carries the format and tool versions") {
+    REQUIRE(log_header() == "[FuncTracer] [Format:" + std::to_string(LOG_FORMAT_VERSION) + "] [Tool:" + FUNCTRACER_VERSION + "]\n");
+}
+
+TEST_CASE("log_header carries the run ID") {
+    REQUIRE(make_run_id(0x1f, 0xabc) == "1f-abc");
+    REQUIRE(make_run_id(1, 2) != make_run_id(1, 3));
+    REQUIRE(log_header("1f-abc") == "[FuncTracer] [Format:" + std::to_string(LOG_FORMAT_VERSION) + "] [Tool:" + FUNCTRACER_VERSION + "] [Run:1f-abc]\n");
+}
+
+TEST_CASE("log_header carries the session and tags") {
+    REQUIRE(log_header("1f-abc", "", "smoke [ci]", "nightly,x86") == "[FuncTracer] [Format:" + std::to_string(LOG_FORMAT_VERSION) +
+                                                                   "] [Tool:" + FUNCTRACER_VERSION + "] [Run:1f-abc] [Session:smoke \\x5bci\\x5d] [Tags:nightly,x86]\n");
+}
+
+TEST_CASE("log_header carries the sampling rate") {
+    REQUIRE(log_header("1f-abc", "1/10") == "[FuncTracer] [Format:" + std::to_string(LOG_FORMAT_VERSION) + "] [Tool:" + FUNCTRACER_VERSION + "] [Run:1f-abc] [Sample:1/10]\n");
+}
+
+TEST_CASE("log_header carries the options") {
+    REQUIRE(log_header("1f-abc", "", "", "", "-edges -api libssl.so.3") == "[FuncTracer] [Format:" + std::to_string(LOG_FORMAT_VERSION) +
+                                                                          "] [Tool:" + FUNCTRACER_VERSION + "] [Run:1f-abc] [Options:-edges -api libssl.so.3]\n");
+}
+
+TEST_CASE("LogEncoding::Json writes one JSON object per event") {
+    log_encoding = LogEncoding::Json;
+    REQUIRE(log_header("1f-abc", "", "smoke", "nightly,x86", "-edges") ==
+            "{\"event\":\"header\",\"format\":" + std::to_string(LOG_FORMAT_VERSION) + ",\"tool\":\"" + FUNCTRACER_VERSION +
+                "\",\"run\":\"1f-abc\",\"session\":\"smoke\",\"tags\":[\"nightly\",\"x86\"],\"options\":\"-edges\"}\n");
+    REQUIRE(entry_line("Function", "/bin/ls", "operator[]", 0x4f10) ==
+            "{\"event\":\"function\",\"image\":\"/bin/ls\",\"addr\":\"0x4f10\",\"function\":\"operator[]\"}\n");
+    REQUIRE(entry_line("Called", "/bin/ls", "main", 0, 3) == "{\"event\":\"called\",\"image\":\"/bin/ls\",\"count\":3,\"function\":\"main\"}\n");
+    REQUIRE(edge_line("/bin/ls", "main", "parse", 2) ==
+            "{\"event\":\"edge\",\"image\":\"/bin/ls\",\"count\":2,\"caller\":\"main\",\"callee\":\"parse\"}\n");
+    REQUIRE(build_id_line("/bin/ls", "ab12") == "{\"event\":\"build_id\",\"image\":\"/bin/ls\",\"build_id\":\"ab12\"}\n");
+    REQUIRE(context_line(0, "host", "/tmp", {"ls", "-l"}, {{"USER", "me"}}) ==
+            "{\"event\":\"context\",\"time\":\"1970-01-01T00:00:00Z\",\"host\":\"host\",\"cwd\":\"/tmp\",\"args\":[\"ls\",\"-l\"],\"env\":[\"USER=me\"]}\n");
+    log_encoding = LogEncoding::Text;
+}
+
+TEST_CASE("LogEncoding::Proto writes one Log record per event") {
+    using namespace std::string_literals;
+    log_encoding = LogEncoding::Proto;
+    // Log{events(16): Event{called(4): Entry{image "a", addr 0x10, count 3, function "f"}}}
+    REQUIRE(entry_line("Called", "a", "f", 0x10, 3) == "\x82\x01\x0c\x22\x0a\x0a\x01"s "a" "\x10\x10\x18\x03\x22\x01" "f");
+    // Zero fields are left out; varints take 7 bits per byte.
+    REQUIRE(entry_line("Function", "a", "f", 300) == "\x82\x01\x0b\x1a\x09\x0a\x01"s "a" "\x10\xac\x02\x22\x01" "f");
+    REQUIRE(section_line("a", ".text").empty());
+    const std::string header = log_header("1f-abc");
+    REQUIRE(header.starts_with("\x82\x01"));
+    REQUIRE(header.find("1f-abc") != std::string::npos);
+    log_encoding = LogEncoding::Text;
+}
+
+TEST_CASE("append_json_string escapes quotes, backslashes and control characters") {
+    std::string out;
+    append_json_string(out, "a\"b\\c\nd\x01\xff");
+    REQUIRE(out == "\"a\\\"b\\\\c\\u000ad\\u0001\xff\"");
+}
+
+TEST_CASE("escape_field protects field delimiters") {
+    REQUIRE(escape_field("foo") == "foo");
+    REQUIRE(escape_field("operator[]") == "operator\\x5b\\x5d");
+    REQUIRE(escape_field("a\\b\nc") == "a\\x5cb\\x0ac");
+}
+
+TEST_CASE("CallRegistry escapes symbol names") {
+    CallRegistry registry;
+    registry.add("/bin/prog", "_ZN3VecixEm[abi:cxx11]")->calls = 1;
+    REQUIRE(registry.flush_all() == "[Image:/bin/prog] [Count:1] [Called:_ZN3VecixEm\\x5babi:cxx11\\x5d]\n");
+}
+
+TEST_CASE("entry_line carries the routine address") {
+    REQUIRE(entry_line("Function", "/bin/prog", "init", 0x1a2b) == "[Image:/bin/prog] [Addr:0x1a2b] [Function:init]\n");
+    REQUIRE(entry_line("Called", "/bin/prog", "init", 0) == "[Image:/bin/prog] [Called:init]\n");
+    REQUIRE(entry_line("Called", "/bin/prog", "init", 0x1a2b, 7) == "[Image:/bin/prog] [Addr:0x1a2b] [Count:7] [Called:init]\n");
+}
+
+TEST_CASE("CallRegistry keeps same-named routines at different addresses apart") {
+    CallRegistry registry;
+    registry.add("/bin/prog", "init", 0x10)->calls = 1;
+    registry.add("/bin/prog", "init", 0x20)->calls = 4;
+    REQUIRE(registry.flush_all() == "[Image:/bin/prog] [Addr:0x10] [Count:1] [Called:init]\n"
+                                    "[Image:/bin/prog] [Addr:0x20] [Count:4] [Called:init]\n");
+}
+
+TEST_CASE("call_target resolves PLT stubs") {
+    REQUIRE(call_target("puts@plt") == "puts");
+    REQUIRE(call_target("helper") == "helper");
+}
+
+TEST_CASE("CallRegistry sums the calls of each edge") {
+    CallRegistry registry;
+    registry.add("/bin/prog", "main_loop")->calls = 1;
+    registry.add_edge("/bin/prog", "main_loop", "parse")->calls = 2;
+    registry.add_edge("/bin/prog", "main_loop", "parse")->calls = 3; // another call site
+    registry.add_edge("/bin/prog", "main_loop", "never");
+    registry.add_edge("/bin/prog", "parse", "operator[]")->calls = 1;
+    REQUIRE(registry.flush_image("/bin/prog") == "[Image:/bin/prog] [Count:1] [Called:main_loop]\n"
+                                                 "[Image:/bin/prog] [Count:5] [Caller:main_loop] [Callee:parse]\n"
+                                                 "[Image:/bin/prog] [Count:1] [Caller:parse] [Callee:operator\\x5b\\x5d]\n");
+    REQUIRE(registry.flush_all().empty());
+}
+
+TEST_CASE("CallRegistry keeps the loads of an image apart") {
+    CallRegistry registry;
+    registry.add("/usr/lib/mod_a.so", "handler", 0x10, 1)->calls = 2;
+    FuncRecord *reloaded = registry.add("/usr/lib/mod_a.so", "handler", 0x10, 2);
+    // Unloading the first load keeps the records of the second one alive.
+    REQUIRE(registry.flush_image("/usr/lib/mod_a.so", 1) == "[Image:/usr/lib/mod_a.so] [Addr:0x10] [Count:2] [Called:handler]\n");
+    reloaded->calls = 3;
+    REQUIRE(registry.flush_all() == "[Image:/usr/lib/mod_a.so] [Addr:0x10] [Count:3] [Called:handler]\n");
+}
+
+TEST_CASE("plugin_line names the program loading an image") {
+    REQUIRE(plugin_line("/usr/lib/mod_[x].so", "/usr/sbin/httpd") == "[Image:/usr/lib/mod_\\x5bx\\x5d.so] [Plugin:/usr/sbin/httpd]\n");
+}
+
+TEST_CASE("LogWriter appends without interleaving lines of forked processes") {
+    char path[] = "/tmp/functracer_log_XXXXXX";
+    const int tmp = mkstemp(path);
+    REQUIRE(tmp >= 0);
+    REQUIRE(::write(tmp, "existing\n", 9) == 9);
+    ::close(tmp);
+
+    LogWriter writer;
+    REQUIRE(writer.open(path));
+    const std::string line(300, 'x');
+    std::vector<pid_t> children;
+    for (int child = 0; child < 4; child++)
+    {
+        const pid_t pid = fork();
+        REQUIRE(pid >= 0);
+        if (pid == 0)
+        {
+            writer.reopen();
+            std::string block;
+            for (int i = 0; i < 50; i++)
+                block += std::to_string(child) + line + "\n";
+            for (int i = 0; i < 20; i++)
+                if (!writer.write(block, i == 19))
+                    _exit(1);
+            _exit(0);
+        }
+        children.push_back(pid);
+    }
+    for (pid_t pid : children)
+    {
+        int status = 0;
+        REQUIRE(waitpid(pid, &status, 0) == pid);
+        REQUIRE(WIFEXITED(status));
+        REQUIRE(WEXITSTATUS(status) == 0);
+    }
+    writer.close();
+
+    std::ifstream in(path);
+    std::string got;
+    REQUIRE(std::getline(in, got));
+    REQUIRE(got == "existing");
+    int lines = 0;
+    while (std::getline(in, got))
+    {
+        REQUIRE(got.size() == line.size() + 1);
+        REQUIRE(got.substr(1) == line);
+        lines++;
+    }
+    REQUIRE(lines == 4 * 20 * 50);
+    unlink(path);
+}
+
+TEST_CASE("LogWriter streams framed blocks and falls back to the log") {
+    char dir[] = "/tmp/functracer_stream_XXXXXX";
+    REQUIRE(mkdtemp(dir));
+    const std::string socket_path = std::string(dir) + "/collector.sock";
+    const std::string log_path = std::string(dir) + "/fallback.log";
+    const int listener = ::socket(AF_UNIX, SOCK_STREAM, 0);
+    REQUIRE(listener >= 0);
+    sockaddr_un addr{};
+    addr.sun_family = AF_UNIX;
+    strcpy(addr.sun_path, socket_path.c_str());
+    REQUIRE(bind(listener, reinterpret_cast<sockaddr *>(&addr), sizeof(addr)) == 0);
+    REQUIRE(listen(listener, 1) == 0);
+
+    LogWriter writer;
+    REQUIRE(writer.stream(socket_path, "a]b", log_path));
+    REQUIRE(writer.write("one\ntwo\n"));
+    const int conn = accept(listener, nullptr, nullptr);
+    REQUIRE(conn >= 0);
+    const std::string want = "[FuncTracer] [Stream:a\\x5db]\n8\none\ntwo\n";
+    std::string received;
+    char buf[256];
+    while (received.size() < want.size())
+    {
+        const ssize_t n = read(conn, buf, sizeof(buf));
+        REQUIRE(n > 0);
+        received.append(buf, n);
+    }
+    REQUIRE(received == want);
+
+    // The collector going away leaves the rest to the log.
+    ::close(conn);
+    ::close(listener);
+    for (int i = 0; i < 3; i++)
+        REQUIRE(writer.write("three\n"));
+    writer.close();
+    std::ifstream in(log_path);
+    std::string got;
+    int lines = 0;
+    while (std::getline(in, got))
+    {
+        REQUIRE(got == "three");
+        lines++;
+    }
+    REQUIRE(lines >= 1);
+
+    LogWriter unreachable;
+    REQUIRE_FALSE(unreachable.stream(std::string(dir) + "/none.sock", "prog", log_path));
+    unlink(log_path.c_str());
+    unlink(socket_path.c_str());
+    rmdir(dir);
+}
+
+TEST_CASE("AflMap counts the transitions between routines") {
+    REQUIRE(afl_location("/bin/ls", 0x10) == afl_location("/bin/ls", 0x10));
+    REQUIRE(afl_location("/bin/ls", 0x10) != afl_location("/bin/ls", 0x20));
+    REQUIRE(afl_location("/bin/ls", 0x10) != afl_location("/bin/cp", 0x10));
+
+    SECTION("System V shared memory") {
+        const int id = shmget(IPC_PRIVATE, AFL_DEFAULT_MAP_SIZE, IPC_CREAT | 0600);
+        REQUIRE(id >= 0);
+        const auto *map = static_cast<const uint8_t *>(shmat(id, nullptr, 0));
+        shmctl(id, IPC_RMID, nullptr);
+        AflMap afl;
+        REQUIRE_FALSE(afl.attached());
+        REQUIRE(afl.attach(std::to_string(id).c_str(), AFL_DEFAULT_MAP_SIZE));
+        const uint32_t a = afl_location("/bin/ls", 0x10), b = afl_location("/bin/ls", 0x20);
+        for (int i = 0; i < 3; i++)
+        {
+            afl.record(a);
+            afl.record(b);
+        }
+        REQUIRE(map[a % AFL_DEFAULT_MAP_SIZE] == 1);
+        REQUIRE(map[(b ^ (a >> 1)) % AFL_DEFAULT_MAP_SIZE] == 3);
+        REQUIRE(map[(a ^ (b >> 1)) % AFL_DEFAULT_MAP_SIZE] == 2);
+        shmdt(map);
+    }
+
+    SECTION("POSIX shared memory") {
+        const std::string name = "/functracer_afl_" + std::to_string(getpid());
+        const int fd = ::open(("/dev/shm" + name).c_str(), O_RDWR | O_CREAT | O_EXCL, 0600);
+        REQUIRE(fd >= 0);
+        REQUIRE(ftruncate(fd, 64) == 0);
+        AflMap afl;
+        REQUIRE(afl.attach(name.c_str(), 64));
+        afl.record(70);
+        uint8_t map[64];
+        REQUIRE(pread(fd, map, sizeof(map), 0) == 64);
+        REQUIRE(map[70 % 64] == 1);
+        ::close(fd);
+        unlink(("/dev/shm" + name).c_str());
+    }
+
+    AflMap none;
+    REQUIRE_FALSE(none.attach(nullptr, AFL_DEFAULT_MAP_SIZE));
+    REQUIRE_FALSE(none.attach("/functracer_afl_missing", AFL_DEFAULT_MAP_SIZE));
+}
+
+TEST_CASE("context_line describes the invocation") {
+    REQUIRE(context_line(1790000000ull * 1000000000, "host1", "/tmp/my dir", {"ls", "-l", "a]b"}, {{"LANG", "C"}}) ==
+            "[FuncTracer] [Context] [Time:2026-09-21T14:13:20Z] [Host:host1] [Cwd:/tmp/my dir] "
+            "[Arg:ls] [Arg:-l] [Arg:a\\x5db] [Env:LANG=C]\n");
+}
+
+TEST_CASE("exit_trailer tells how the invocation ended") {
+    using namespace std::string_literals;
+    REQUIRE(exit_trailer(0, 0, 42, 1790000000) == "[FuncTracer] [Exit:0] [WallMs:42] [Started:1790000000]\n");
+    REQUIRE(exit_trailer(137, 9, 5, 7) == "[FuncTracer] [Exit:137] [Signal:9] [WallMs:5] [Started:7]\n");
+    log_encoding = LogEncoding::Json;
+    REQUIRE(exit_trailer(1, 0, 5, 7) == "{\"event\":\"exit\",\"code\":1,\"wall_ms\":5,\"started\":7}\n");
+    REQUIRE(exit_trailer(137, 9, 5, 7) == "{\"event\":\"exit\",\"code\":137,\"signal\":9,\"wall_ms\":5,\"started\":7}\n");
+    // Log{events(16): Event{exit(8): Exit{code 1, wall_ms 5, started 7}}}
+    log_encoding = LogEncoding::Proto;
+    REQUIRE(exit_trailer(1, 0, 5, 7) == "\x82\x01\x08\x42\x06\x08\x01\x18\x05\x20\x07"s);
+    log_encoding = LogEncoding::Text;
+}
+
+TEST_CASE("syslog_event formats the events of funkoverage events") {
+    const std::string stop = syslog_event(false, "stop", "ls", 42, "status=0 log=/tmp/ls.log", 0);
+    REQUIRE(stop.starts_with("<14>"));
+    REQUIRE(stop.ends_with(" funkoverage[42]: event=stop binary=ls pid=42 status=0 log=/tmp/ls.log"));
+    REQUIRE(syslog_event(true, "failure", "ls", 42, "reason=no-log", 0).starts_with("<11>"));
+
+    const std::string path = "/tmp/functracer_test_syslog_" + std::to_string(getpid());
+    unlink(path.c_str());
+    const int fd = socket(AF_UNIX, SOCK_DGRAM, 0);
+    sockaddr_un addr{};
+    addr.sun_family = AF_UNIX;
+    strcpy(addr.sun_path, path.c_str());
+    REQUIRE(bind(fd, reinterpret_cast<sockaddr *>(&addr), sizeof(addr)) == 0);
+    send_syslog(stop, path.c_str());
+    char buf[256];
+    const ssize_t n = recv(fd, buf, sizeof(buf), MSG_DONTWAIT);
+    REQUIRE(std::string(buf, n > 0 ? n : 0) == stop);
+    close(fd);
+    unlink(path.c_str());
+}
+
+TEST_CASE("mapped_build_id reads the GNU build ID of a loaded image") {
+    // The test binary is linked with --build-id (see run_unit_tests.sh).
+    std::string id;
+    dl_iterate_phdr([](struct dl_phdr_info *info, size_t, void *data) {
+        for (int i = 0; i < info->dlpi_phnum; i++)
+        {
+            if (info->dlpi_phdr[i].p_type == PT_LOAD && info->dlpi_phdr[i].p_offset == 0)
+            {
+                const void *ehdr = reinterpret_cast<const void *>(info->dlpi_addr + info->dlpi_phdr[i].p_vaddr);
+                *static_cast<std::string *>(data) = mapped_build_id(ehdr, info->dlpi_addr);
+                return 1; // the first object is the program itself
+            }
+        }
+        return 1;
+    }, &id);
+    REQUIRE(id.size() == 40);
+    REQUIRE(id.find_first_not_of("0123456789abcdef") == std::string::npos);
+    REQUIRE(build_id_line("/bin/a]b", id) == "[Image:/bin/a\\x5db] [BuildID:" + id + "]\n");
+}
+
+TEST_CASE("CallRegistry writes the first call of each routine") {
+    CallRegistry registry;
+    FuncRecord *a = registry.add("/bin/prog", "init", 0x10);
+    FuncRecord *b = registry.add("/bin/prog", "init", 0x10);
+    a->calls = 2;
+    a->first_ns = 2000, a->first_pid = 7, a->first_tid = 8;
+    b->calls = 1;
+    b->first_ns = 1000, b->first_pid = 7, b->first_tid = 9;
+    registry.add("/bin/prog", "idle", 0x20)->calls = 1;
+    REQUIRE(registry.flush_all() == "[Image:/bin/prog] [Addr:0x10] [Count:3] [Called:init]\n"
+                                    "[Image:/bin/prog] [Addr:0x10] [Pid:7] [Tid:9] [Time:1000] [First:init]\n"
+                                    "[Image:/bin/prog] [Addr:0x20] [Count:1] [Called:idle]\n");
+}
+
+TEST_CASE("CallRegistry forgets the calls inherited by a forked child") {
+    CallRegistry registry;
+    FuncRecord *rec = registry.add("/bin/prog", "init");
+    FuncRecord *idle = registry.add("/bin/prog", "idle");
+    EdgeRecord *edge = registry.add_edge("/bin/prog", "init", "idle");
+    rec->calls = 1;
+    rec->first_ns = 1000;
+    idle->calls = 2;
+    edge->calls = 2;
+    registry.forget_parent_calls();
+    rec->calls++;
+    REQUIRE(registry.flush_all() == "[Image:/bin/prog] [Count:1] [Called:init]\n");
+}
+
+TEST_CASE("elf_arch_name names architectures like uname -m") {
+    REQUIRE(elf_arch_name(EM_X86_64, ELFCLASS64, ELFDATA2LSB) == "x86_64");
+    REQUIRE(elf_arch_name(EM_386, ELFCLASS32, ELFDATA2LSB) == "i386");
+    REQUIRE(elf_arch_name(EM_AARCH64, ELFCLASS64, ELFDATA2LSB) == "aarch64");
+    REQUIRE(elf_arch_name(EM_PPC64, ELFCLASS64, ELFDATA2LSB) == "ppc64le");
+    REQUIRE(elf_arch_name(EM_S390, ELFCLASS64, ELFDATA2MSB) == "s390x");
+    REQUIRE(elf_arch_name(0x1234, ELFCLASS64, ELFDATA2LSB) == "machine4660");
+    REQUIRE(arch_line("/bin/a]b", "x86_64") == "[Image:/bin/a\\x5db] [Arch:x86_64]\n");
+}